@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/rand"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type AccessControlEntryRecordValue struct {
+	Header         RecordValueHeader    `order:"1"`
+	Id             ktypes.UUID          `order:"2"`
+	ResourceType   ktypes.Int8          `order:"3"`
+	ResourceName   ktypes.CompactString `order:"4"`
+	PatternType    ktypes.Int8          `order:"5"`
+	Principal      ktypes.CompactString `order:"6"`
+	Host           ktypes.CompactString `order:"7"`
+	Operation      ktypes.Int8          `order:"8"`
+	PermissionType ktypes.Int8          `order:"9"`
+	TaggedFields   ktypes.TaggedFields  `order:"10"`
+}
+
+type RemoveAccessControlEntryRecordValue struct {
+	Header       RecordValueHeader   `order:"1"`
+	Id           ktypes.UUID         `order:"2"`
+	TaggedFields ktypes.TaggedFields `order:"3"`
+}
+
+// Acl grants or denies an operation on the resources matching a pattern.
+type Acl struct {
+	Id             ktypes.UUID
+	ResourceType   int8
+	ResourceName   string
+	PatternType    int8
+	Principal      string
+	Host           string
+	Operation      int8
+	PermissionType int8
+}
+
+// AclFilter selects ACLs. Empty strings and the ANY types match everything;
+// PATTERN_TYPE_MATCH selects the ACLs that apply to a resource name.
+type AclFilter struct {
+	ResourceType   int8
+	ResourceName   string
+	PatternType    int8
+	Principal      string
+	Host           string
+	Operation      int8
+	PermissionType int8
+}
+
+// ACLs by id, guarded by metadataMu
+var acls = make(map[ktypes.UUID]Acl)
+
+func applyAccessControlEntryRecord(record *AccessControlEntryRecordValue) {
+	acls[record.Id] = Acl{
+		Id:             record.Id,
+		ResourceType:   int8(record.ResourceType),
+		ResourceName:   string(record.ResourceName),
+		PatternType:    int8(record.PatternType),
+		Principal:      string(record.Principal),
+		Host:           string(record.Host),
+		Operation:      int8(record.Operation),
+		PermissionType: int8(record.PermissionType),
+	}
+}
+
+func applyRemoveAccessControlEntryRecord(record *RemoveAccessControlEntryRecordValue) {
+	delete(acls, record.Id)
+}
+
+// matchesResource reports whether the ACL applies to the named resource.
+func (a Acl) matchesResource(resourceType int8, resourceName string) bool {
+	if a.ResourceType != resourceType {
+		return false
+	}
+	switch a.PatternType {
+	case PATTERN_TYPE_LITERAL:
+		return a.ResourceName == resourceName || a.ResourceName == WILDCARD_RESOURCE
+	case PATTERN_TYPE_PREFIXED:
+		return strings.HasPrefix(resourceName, a.ResourceName)
+	}
+	return false
+}
+
+func (f AclFilter) matches(acl Acl) bool {
+	if f.ResourceType != RESOURCE_TYPE_ANY && f.ResourceType != acl.ResourceType {
+		return false
+	}
+	switch f.PatternType {
+	case PATTERN_TYPE_ANY:
+		if f.ResourceName != "" && f.ResourceName != acl.ResourceName {
+			return false
+		}
+	case PATTERN_TYPE_MATCH:
+		if f.ResourceName != "" && !acl.matchesResource(acl.ResourceType, f.ResourceName) {
+			return false
+		}
+	default:
+		if f.PatternType != acl.PatternType || (f.ResourceName != "" && f.ResourceName != acl.ResourceName) {
+			return false
+		}
+	}
+	if f.Principal != "" && f.Principal != acl.Principal {
+		return false
+	}
+	if f.Host != "" && f.Host != acl.Host {
+		return false
+	}
+	if f.Operation != ACL_OPERATION_ANY && f.Operation != acl.Operation {
+		return false
+	}
+	if f.PermissionType != ACL_PERMISSION_TYPE_ANY && f.PermissionType != acl.PermissionType {
+		return false
+	}
+	return true
+}
+
+// validateAclFilter returns the error for a filter with unknown types.
+func validateAclFilter(filter AclFilter) *KafkaError {
+	if filter.ResourceType == RESOURCE_TYPE_UNKNOWN || filter.PatternType == PATTERN_TYPE_UNKNOWN ||
+		filter.Operation == ACL_OPERATION_UNKNOWN || filter.PermissionType == ACL_PERMISSION_TYPE_UNKNOWN {
+		return newKafkaError(ERROR_CODE_INVALID_REQUEST, "ACL filters cannot use the UNKNOWN type")
+	}
+	return nil
+}
+
+// validateAcl returns the error for an ACL that cannot be created.
+func validateAcl(acl Acl) *KafkaError {
+	if acl.ResourceType <= RESOURCE_TYPE_ANY || acl.ResourceType > RESOURCE_TYPE_USER {
+		return newKafkaError(ERROR_CODE_INVALID_REQUEST, "invalid resource type %d", acl.ResourceType)
+	}
+	if acl.PatternType != PATTERN_TYPE_LITERAL && acl.PatternType != PATTERN_TYPE_PREFIXED {
+		return newKafkaError(ERROR_CODE_INVALID_REQUEST, "invalid pattern type %d", acl.PatternType)
+	}
+	if acl.Operation <= ACL_OPERATION_ANY || acl.Operation > ACL_OPERATION_DESCRIBE_TOKENS {
+		return newKafkaError(ERROR_CODE_INVALID_REQUEST, "invalid operation %d", acl.Operation)
+	}
+	if acl.PermissionType != ACL_PERMISSION_TYPE_ALLOW && acl.PermissionType != ACL_PERMISSION_TYPE_DENY {
+		return newKafkaError(ERROR_CODE_INVALID_REQUEST, "invalid permission type %d", acl.PermissionType)
+	}
+	if acl.ResourceName == "" {
+		return newKafkaError(ERROR_CODE_INVALID_REQUEST, "resource name must not be empty")
+	}
+	if acl.ResourceType == RESOURCE_TYPE_CLUSTER && acl.ResourceName != CLUSTER_RESOURCE_NAME {
+		return newKafkaError(ERROR_CODE_INVALID_REQUEST, "the cluster resource is named %s", CLUSTER_RESOURCE_NAME)
+	}
+	if _, name, ok := strings.Cut(acl.Principal, ":"); !ok || name == "" {
+		return newKafkaError(ERROR_CODE_INVALID_REQUEST, "invalid principal %s", acl.Principal)
+	}
+	if acl.Host == "" {
+		return newKafkaError(ERROR_CODE_INVALID_REQUEST, "host must not be empty")
+	}
+	return nil
+}
+
+func aclHeader(recordType int) RecordValueHeader {
+	return RecordValueHeader{
+		FrameVersion: ktypes.Int8(METADATA_RECORD_FRAME_VERSION),
+		RecordType:   ktypes.Int8(recordType),
+		Version:      ktypes.Int8(0),
+	}
+}
+
+// createAcl stores an ACL, unless an identical one already exists.
+func createAcl(acl Acl) error {
+	if err := validateAcl(acl); err != nil {
+		return err
+	}
+
+	metadataWriteMu.Lock()
+	defer metadataWriteMu.Unlock()
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	for _, existing := range acls {
+		existing.Id = acl.Id
+		if existing == acl {
+			return nil
+		}
+	}
+
+	var id ktypes.UUID
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	return appendMetadataRecord(&AccessControlEntryRecordValue{
+		Header:         aclHeader(ACCESS_CONTROL_ENTRY_RECORD_TYPE),
+		Id:             id,
+		ResourceType:   ktypes.Int8(acl.ResourceType),
+		ResourceName:   ktypes.CompactString(acl.ResourceName),
+		PatternType:    ktypes.Int8(acl.PatternType),
+		Principal:      ktypes.CompactString(acl.Principal),
+		Host:           ktypes.CompactString(acl.Host),
+		Operation:      ktypes.Int8(acl.Operation),
+		PermissionType: ktypes.Int8(acl.PermissionType),
+	})
+}
+
+// describeAcls returns the ACLs matching the filter, sorted by resource.
+func describeAcls(filter AclFilter) []Acl {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	matching := make([]Acl, 0)
+	for _, acl := range acls {
+		if filter.matches(acl) {
+			matching = append(matching, acl)
+		}
+	}
+	slices.SortFunc(matching, compareAcls)
+	return matching
+}
+
+// deleteAcls removes the ACLs matching the filter and returns them.
+func deleteAcls(filter AclFilter) ([]Acl, error) {
+	metadataWriteMu.Lock()
+	defer metadataWriteMu.Unlock()
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	matching := make([]Acl, 0)
+	for _, acl := range acls {
+		if filter.matches(acl) {
+			matching = append(matching, acl)
+		}
+	}
+	slices.SortFunc(matching, compareAcls)
+
+	for i, acl := range matching {
+		err := appendMetadataRecord(&RemoveAccessControlEntryRecordValue{
+			Header: aclHeader(REMOVE_ACCESS_CONTROL_ENTRY_RECORD_TYPE),
+			Id:     acl.Id,
+		})
+		if err != nil {
+			return matching[:i], err
+		}
+	}
+	return matching, nil
+}
+
+func compareAcls(a, b Acl) int {
+	if a.ResourceType != b.ResourceType {
+		return int(a.ResourceType) - int(b.ResourceType)
+	}
+	if c := strings.Compare(a.ResourceName, b.ResourceName); c != 0 {
+		return c
+	}
+	if a.PatternType != b.PatternType {
+		return int(a.PatternType) - int(b.PatternType)
+	}
+	if c := strings.Compare(a.Principal, b.Principal); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.Host, b.Host); c != 0 {
+		return c
+	}
+	if a.Operation != b.Operation {
+		return int(a.Operation) - int(b.Operation)
+	}
+	return int(a.PermissionType) - int(b.PermissionType)
+}
@@ -0,0 +1,179 @@
+package main
+
+import "net"
+
+// Resource types, pattern types, operations and permission types use the
+// ids of the Kafka protocol.
+const (
+	RESOURCE_TYPE_UNKNOWN          = 0
+	RESOURCE_TYPE_ANY              = 1
+	RESOURCE_TYPE_TOPIC            = 2
+	RESOURCE_TYPE_GROUP            = 3
+	RESOURCE_TYPE_CLUSTER          = 4
+	RESOURCE_TYPE_TRANSACTIONAL_ID = 5
+	RESOURCE_TYPE_DELEGATION_TOKEN = 6
+	RESOURCE_TYPE_USER             = 7
+)
+
+const (
+	PATTERN_TYPE_UNKNOWN  = 0
+	PATTERN_TYPE_ANY      = 1
+	PATTERN_TYPE_MATCH    = 2
+	PATTERN_TYPE_LITERAL  = 3
+	PATTERN_TYPE_PREFIXED = 4
+)
+
+const (
+	ACL_OPERATION_UNKNOWN          = 0
+	ACL_OPERATION_ANY              = 1
+	ACL_OPERATION_ALL              = 2
+	ACL_OPERATION_READ             = 3
+	ACL_OPERATION_WRITE            = 4
+	ACL_OPERATION_CREATE           = 5
+	ACL_OPERATION_DELETE           = 6
+	ACL_OPERATION_ALTER            = 7
+	ACL_OPERATION_DESCRIBE         = 8
+	ACL_OPERATION_CLUSTER_ACTION   = 9
+	ACL_OPERATION_DESCRIBE_CONFIGS = 10
+	ACL_OPERATION_ALTER_CONFIGS    = 11
+	ACL_OPERATION_IDEMPOTENT_WRITE = 12
+	ACL_OPERATION_CREATE_TOKENS    = 13
+	ACL_OPERATION_DESCRIBE_TOKENS  = 14
+)
+
+const (
+	ACL_PERMISSION_TYPE_UNKNOWN = 0
+	ACL_PERMISSION_TYPE_ANY     = 1
+	ACL_PERMISSION_TYPE_DENY    = 2
+	ACL_PERMISSION_TYPE_ALLOW   = 3
+)
+
+const (
+	CLUSTER_RESOURCE_NAME = "kafka-cluster"
+	WILDCARD_RESOURCE     = "*"
+	WILDCARD_PRINCIPAL    = "User:*"
+	WILDCARD_HOST         = "*"
+
+	STANDARD_AUTHORIZER_CLASS_NAME = "org.apache.kafka.metadata.authorizer.StandardAuthorizer"
+)
+
+// Operations reported in authorized operations bitfields, per resource type
+var resourceTypeOperations = map[int8][]int8{
+	RESOURCE_TYPE_TOPIC: {
+		ACL_OPERATION_READ, ACL_OPERATION_WRITE, ACL_OPERATION_CREATE, ACL_OPERATION_DELETE,
+		ACL_OPERATION_ALTER, ACL_OPERATION_DESCRIBE, ACL_OPERATION_DESCRIBE_CONFIGS, ACL_OPERATION_ALTER_CONFIGS,
+	},
+	RESOURCE_TYPE_GROUP: {
+		ACL_OPERATION_READ, ACL_OPERATION_DELETE, ACL_OPERATION_DESCRIBE,
+		ACL_OPERATION_DESCRIBE_CONFIGS, ACL_OPERATION_ALTER_CONFIGS,
+	},
+	RESOURCE_TYPE_CLUSTER: {
+		ACL_OPERATION_CREATE, ACL_OPERATION_ALTER, ACL_OPERATION_DESCRIBE, ACL_OPERATION_CLUSTER_ACTION,
+		ACL_OPERATION_DESCRIBE_CONFIGS, ACL_OPERATION_ALTER_CONFIGS, ACL_OPERATION_IDEMPOTENT_WRITE,
+	},
+	RESOURCE_TYPE_TRANSACTIONAL_ID: {ACL_OPERATION_WRITE, ACL_OPERATION_DESCRIBE},
+}
+
+// Authorizer decides whether a principal connected from host may perform an
+// operation on a resource.
+type Authorizer interface {
+	Authorize(principal string, host string, operation int8, resourceType int8, resourceName string) bool
+}
+
+// Authorizer configured with authorizer.class.name, nil when every request
+// is allowed
+var authorizer Authorizer
+
+// StandardAuthorizer checks the ACLs stored in the cluster metadata.
+type StandardAuthorizer struct {
+	SuperUsers                []string
+	AllowEveryoneIfNoAclFound bool
+}
+
+// impliedOperations returns the operations whose ACLs also grant op.
+// Describe is implied by any operation that reads or changes the resource.
+func impliedOperations(operation int8) []int8 {
+	switch operation {
+	case ACL_OPERATION_DESCRIBE:
+		return []int8{ACL_OPERATION_DESCRIBE, ACL_OPERATION_READ, ACL_OPERATION_WRITE, ACL_OPERATION_DELETE, ACL_OPERATION_ALTER}
+	case ACL_OPERATION_DESCRIBE_CONFIGS:
+		return []int8{ACL_OPERATION_DESCRIBE_CONFIGS, ACL_OPERATION_ALTER_CONFIGS}
+	}
+	return []int8{operation}
+}
+
+func (a *StandardAuthorizer) Authorize(principal string, host string, operation int8, resourceType int8, resourceName string) bool {
+	for _, superUser := range a.SuperUsers {
+		if superUser == principal {
+			return true
+		}
+	}
+
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	// DENY always wins, whatever operation the ACL names
+	found := false
+	allowed := false
+	for _, acl := range acls {
+		if !acl.matchesResource(resourceType, resourceName) {
+			continue
+		}
+		found = true
+		if acl.Principal != principal && acl.Principal != WILDCARD_PRINCIPAL {
+			continue
+		}
+		if acl.Host != host && acl.Host != WILDCARD_HOST {
+			continue
+		}
+		if acl.PermissionType == ACL_PERMISSION_TYPE_DENY {
+			if acl.Operation == ACL_OPERATION_ALL || acl.Operation == operation {
+				return false
+			}
+			continue
+		}
+		if acl.Operation == ACL_OPERATION_ALL {
+			allowed = true
+			continue
+		}
+		for _, implied := range impliedOperations(operation) {
+			if acl.Operation == implied {
+				allowed = true
+			}
+		}
+	}
+	if !found {
+		return a.AllowEveryoneIfNoAclFound
+	}
+	return allowed
+}
+
+// clientAddress strips the port from a connection's remote address.
+func clientAddress(remoteAddress string) string {
+	host, _, err := net.SplitHostPort(remoteAddress)
+	if err != nil {
+		return remoteAddress
+	}
+	return host
+}
+
+// authorize reports whether the request's principal may perform the
+// operation on the resource.
+func authorize(req *Request, operation int8, resourceType int8, resourceName string) bool {
+	if authorizer == nil {
+		return true
+	}
+	return authorizer.Authorize(req.Session.Principal, clientAddress(req.ClientHost), operation, resourceType, resourceName)
+}
+
+// authorizedOperations returns the bitfield of the operations the request's
+// principal may perform on the resource, bit n standing for operation n.
+func authorizedOperations(req *Request, resourceType int8, resourceName string) int32 {
+	var operations int32
+	for _, operation := range resourceTypeOperations[resourceType] {
+		if authorize(req, operation, resourceType, resourceName) {
+			operations |= 1 << operation
+		}
+	}
+	return operations
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+// setTestAcls replaces the ACLs of the cluster until the test ends
+func setTestAcls(t *testing.T, testAcls ...Acl) {
+	t.Helper()
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	previousAcls := acls
+	acls = make(map[ktypes.UUID]Acl)
+	for i, acl := range testAcls {
+		acl.Id = ktypes.UUID{byte(i + 1)}
+		acls[acl.Id] = acl
+	}
+	t.Cleanup(func() {
+		metadataMu.Lock()
+		defer metadataMu.Unlock()
+		acls = previousAcls
+	})
+}
+
+func topicTestAcl(name string, patternType int8, principal string, host string, operation int8, permissionType int8) Acl {
+	return Acl{
+		ResourceType:   RESOURCE_TYPE_TOPIC,
+		ResourceName:   name,
+		PatternType:    patternType,
+		Principal:      principal,
+		Host:           host,
+		Operation:      operation,
+		PermissionType: permissionType,
+	}
+}
+
+func TestAclMatchesResource(t *testing.T) {
+	tests := []struct {
+		acl          Acl
+		resourceType int8
+		resourceName string
+		want         bool
+	}{
+		{topicTestAcl("orders", PATTERN_TYPE_LITERAL, "User:a", "*", ACL_OPERATION_READ, ACL_PERMISSION_TYPE_ALLOW), RESOURCE_TYPE_TOPIC, "orders", true},
+		{topicTestAcl("orders", PATTERN_TYPE_LITERAL, "User:a", "*", ACL_OPERATION_READ, ACL_PERMISSION_TYPE_ALLOW), RESOURCE_TYPE_TOPIC, "orders-eu", false},
+		{topicTestAcl("orders", PATTERN_TYPE_LITERAL, "User:a", "*", ACL_OPERATION_READ, ACL_PERMISSION_TYPE_ALLOW), RESOURCE_TYPE_GROUP, "orders", false},
+		{topicTestAcl("*", PATTERN_TYPE_LITERAL, "User:a", "*", ACL_OPERATION_READ, ACL_PERMISSION_TYPE_ALLOW), RESOURCE_TYPE_TOPIC, "anything", true},
+		{topicTestAcl("orders", PATTERN_TYPE_PREFIXED, "User:a", "*", ACL_OPERATION_READ, ACL_PERMISSION_TYPE_ALLOW), RESOURCE_TYPE_TOPIC, "orders-eu", true},
+		{topicTestAcl("orders", PATTERN_TYPE_PREFIXED, "User:a", "*", ACL_OPERATION_READ, ACL_PERMISSION_TYPE_ALLOW), RESOURCE_TYPE_TOPIC, "order", false},
+		// A prefixed * is not a wildcard
+		{topicTestAcl("*", PATTERN_TYPE_PREFIXED, "User:a", "*", ACL_OPERATION_READ, ACL_PERMISSION_TYPE_ALLOW), RESOURCE_TYPE_TOPIC, "orders", false},
+	}
+	for _, test := range tests {
+		if got := test.acl.matchesResource(test.resourceType, test.resourceName); got != test.want {
+			t.Errorf("%+v on %d %q: got %v, want %v", test.acl, test.resourceType, test.resourceName, got, test.want)
+		}
+	}
+}
+
+func TestAclFilterMatches(t *testing.T) {
+	literal := topicTestAcl("orders", PATTERN_TYPE_LITERAL, "User:a", "*", ACL_OPERATION_READ, ACL_PERMISSION_TYPE_ALLOW)
+	prefixed := topicTestAcl("ord", PATTERN_TYPE_PREFIXED, "User:b", "10.0.0.1", ACL_OPERATION_WRITE, ACL_PERMISSION_TYPE_DENY)
+	any := AclFilter{ResourceType: RESOURCE_TYPE_ANY, PatternType: PATTERN_TYPE_ANY, Operation: ACL_OPERATION_ANY, PermissionType: ACL_PERMISSION_TYPE_ANY}
+
+	tests := []struct {
+		name         string
+		change       func(*AclFilter)
+		wantLiteral  bool
+		wantPrefixed bool
+	}{
+		{"any", func(f *AclFilter) {}, true, true},
+		{"resource name", func(f *AclFilter) { f.ResourceName = "orders" }, true, false},
+		{"literal", func(f *AclFilter) { f.PatternType = PATTERN_TYPE_LITERAL }, true, false},
+		{"prefixed name", func(f *AclFilter) { f.PatternType = PATTERN_TYPE_PREFIXED; f.ResourceName = "ord" }, false, true},
+		{"match", func(f *AclFilter) { f.PatternType = PATTERN_TYPE_MATCH; f.ResourceName = "orders" }, true, true},
+		{"match other name", func(f *AclFilter) { f.PatternType = PATTERN_TYPE_MATCH; f.ResourceName = "ordinal" }, false, true},
+		{"resource type", func(f *AclFilter) { f.ResourceType = RESOURCE_TYPE_GROUP }, false, false},
+		{"principal", func(f *AclFilter) { f.Principal = "User:b" }, false, true},
+		{"host", func(f *AclFilter) { f.Host = "*" }, true, false},
+		{"operation", func(f *AclFilter) { f.Operation = ACL_OPERATION_READ }, true, false},
+		{"permission type", func(f *AclFilter) { f.PermissionType = ACL_PERMISSION_TYPE_DENY }, false, true},
+	}
+	for _, test := range tests {
+		filter := any
+		test.change(&filter)
+		if got := filter.matches(literal); got != test.wantLiteral {
+			t.Errorf("%s: literal ACL matched %v, want %v", test.name, got, test.wantLiteral)
+		}
+		if got := filter.matches(prefixed); got != test.wantPrefixed {
+			t.Errorf("%s: prefixed ACL matched %v, want %v", test.name, got, test.wantPrefixed)
+		}
+	}
+}
+
+func TestStandardAuthorizer(t *testing.T) {
+	setTestAcls(t,
+		topicTestAcl("orders", PATTERN_TYPE_LITERAL, "User:alice", "*", ACL_OPERATION_READ, ACL_PERMISSION_TYPE_ALLOW),
+		topicTestAcl("orders", PATTERN_TYPE_LITERAL, "User:*", "10.0.0.9", ACL_OPERATION_ALL, ACL_PERMISSION_TYPE_DENY),
+		topicTestAcl("pay", PATTERN_TYPE_PREFIXED, "User:bob", "10.0.0.1", ACL_OPERATION_ALL, ACL_PERMISSION_TYPE_ALLOW),
+		topicTestAcl("payments-secret", PATTERN_TYPE_LITERAL, "User:bob", "*", ACL_OPERATION_WRITE, ACL_PERMISSION_TYPE_DENY),
+	)
+	a := &StandardAuthorizer{SuperUsers: []string{"User:admin"}}
+
+	tests := []struct {
+		name      string
+		principal string
+		host      string
+		operation int8
+		resource  string
+		want      bool
+	}{
+		{"allowed", "User:alice", "10.0.0.1", ACL_OPERATION_READ, "orders", true},
+		{"describe implied by read", "User:alice", "10.0.0.1", ACL_OPERATION_DESCRIBE, "orders", true},
+		{"other operation", "User:alice", "10.0.0.1", ACL_OPERATION_WRITE, "orders", false},
+		{"other principal", "User:carol", "10.0.0.1", ACL_OPERATION_READ, "orders", false},
+		{"denied host", "User:alice", "10.0.0.9", ACL_OPERATION_READ, "orders", false},
+		{"prefixed", "User:bob", "10.0.0.1", ACL_OPERATION_WRITE, "payments", true},
+		{"prefixed from other host", "User:bob", "10.0.0.2", ACL_OPERATION_WRITE, "payments", false},
+		{"deny wins", "User:bob", "10.0.0.1", ACL_OPERATION_WRITE, "payments-secret", false},
+		{"deny of other operation", "User:bob", "10.0.0.1", ACL_OPERATION_READ, "payments-secret", true},
+		{"no ACL", "User:alice", "10.0.0.1", ACL_OPERATION_READ, "logs", false},
+		{"super user", "User:admin", "10.0.0.9", ACL_OPERATION_DELETE, "orders", true},
+	}
+	for _, test := range tests {
+		if got := a.Authorize(test.principal, test.host, test.operation, RESOURCE_TYPE_TOPIC, test.resource); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// Without ACLs on the resource the authorizer falls back to its setting
+	a.AllowEveryoneIfNoAclFound = true
+	if !a.Authorize("User:alice", "10.0.0.1", ACL_OPERATION_READ, RESOURCE_TYPE_TOPIC, "logs") {
+		t.Errorf("resource without ACLs denied with allow.everyone.if.no.acl.found")
+	}
+	if a.Authorize("User:carol", "10.0.0.1", ACL_OPERATION_READ, RESOURCE_TYPE_TOPIC, "orders") {
+		t.Errorf("resource with ACLs allowed to everyone")
+	}
+}
+
+func TestValidateAcl(t *testing.T) {
+	valid := topicTestAcl("orders", PATTERN_TYPE_LITERAL, "User:alice", "*", ACL_OPERATION_READ, ACL_PERMISSION_TYPE_ALLOW)
+	if err := validateAcl(valid); err != nil {
+		t.Fatalf("valid ACL rejected: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(*Acl)
+	}{
+		{"any resource type", func(a *Acl) { a.ResourceType = RESOURCE_TYPE_ANY }},
+		{"match pattern", func(a *Acl) { a.PatternType = PATTERN_TYPE_MATCH }},
+		{"any operation", func(a *Acl) { a.Operation = ACL_OPERATION_ANY }},
+		{"any permission", func(a *Acl) { a.PermissionType = ACL_PERMISSION_TYPE_ANY }},
+		{"no resource name", func(a *Acl) { a.ResourceName = "" }},
+		{"cluster name", func(a *Acl) { a.ResourceType = RESOURCE_TYPE_CLUSTER }},
+		{"principal without type", func(a *Acl) { a.Principal = "alice" }},
+		{"no host", func(a *Acl) { a.Host = "" }},
+	}
+	for _, test := range tests {
+		acl := valid
+		test.change(&acl)
+		if err := validateAcl(acl); err == nil {
+			t.Errorf("%s: ACL %+v accepted", test.name, acl)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+// BrokerClient sends requests to another node of the cluster, one at a
+// time.
+type BrokerClient struct {
+	brokerId      int32
+	conn          net.Conn
+	correlationId int32
+	// Time given to each request to complete
+	timeout time.Duration
+}
+
+// dialBroker connects to a broker's inter-broker listener. Only PLAINTEXT
+// listeners can be used between brokers.
+func dialBroker(brokerId int32) (*BrokerClient, error) {
+	endpoint, ok := brokerEndpoint(brokerId, brokerConfig.InterBrokerListenerName)
+	if !ok {
+		return nil, fmt.Errorf("broker %d has no %s listener", brokerId, brokerConfig.InterBrokerListenerName)
+	}
+	if endpoint.SecurityProtocol != SECURITY_PROTOCOL_PLAINTEXT {
+		return nil, fmt.Errorf("unsupported inter-broker security protocol %s", endpoint.SecurityProtocol)
+	}
+
+	address := net.JoinHostPort(endpoint.Host, strconv.Itoa(int(endpoint.Port)))
+	return dialNode(brokerId, address, REPLICA_SOCKET_TIMEOUT_MS*time.Millisecond)
+}
+
+// dialVoter connects to a controller of the metadata quorum at its
+// controller.quorum.voters address.
+func dialVoter(voterId int32) (*BrokerClient, error) {
+	address, ok := brokerConfig.ControllerQuorumVoters[voterId]
+	if !ok {
+		return nil, fmt.Errorf("node %d is not a voter", voterId)
+	}
+	return dialNode(voterId, address, time.Duration(brokerConfig.ControllerQuorumRequestTimeoutMs)*time.Millisecond)
+}
+
+func dialNode(nodeId int32, address string, timeout time.Duration) (*BrokerClient, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to node %d: %w", nodeId, err)
+	}
+	return &BrokerClient{brokerId: nodeId, conn: conn, timeout: timeout}, nil
+}
+
+// send sends a flexible version request and decodes its response into
+// responseBody.
+func (c *BrokerClient) send(apiKey ktypes.Int16, apiVersion ktypes.Int16, requestBody any, responseBody any) error {
+	c.correlationId++
+	header := Request{
+		RequestApiKey:     apiKey,
+		RequestApiVersion: apiVersion,
+		CorrelationId:     ktypes.Int32(c.correlationId),
+		ClientId:          ktypes.String(fmt.Sprintf("node-%d", brokerConfig.NodeId)),
+	}
+	encodedBody, err := ktypes.NewKEncoder().Encode(requestBody)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %v", err)
+	}
+	frame, err := encodeRequest(&header, encodedBody)
+	if err != nil {
+		return err
+	}
+
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write(frame); err != nil {
+		return fmt.Errorf("unable to send request to broker %d: %w", c.brokerId, err)
+	}
+	responseFrame, err := readRequestFrame(c.conn)
+	if err != nil {
+		return fmt.Errorf("unable to read response of broker %d: %w", c.brokerId, err)
+	}
+
+	decoder := ktypes.NewKDecoder(responseFrame[4:])
+	var responseHeader ResponseHeaderV1
+	if err := decoder.Decode(&responseHeader); err != nil {
+		return fmt.Errorf("failed to decode response header: %v", err)
+	}
+	if int32(responseHeader.CorrelationId) != c.correlationId {
+		return fmt.Errorf("unexpected correlation id %d from broker %d", responseHeader.CorrelationId, c.brokerId)
+	}
+	if err := decoder.Decode(responseBody); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	return nil
+}
+
+// encodeRequest encodes a request header and body into a size prefixed
+// frame.
+func encodeRequest(header *Request, body []byte) ([]byte, error) {
+	frame, err := ktypes.NewKEncoder().Encode(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request header: %v", err)
+	}
+	if isFlexibleRequest(header.RequestApiKey, header.RequestApiVersion) {
+		encodedTags, err := ktypes.NewKEncoder().Encode(&RequestHeaderTaggedFields{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request header: %v", err)
+		}
+		frame = append(frame, encodedTags...)
+	}
+
+	// The header starts with the size, set once the request is encoded
+	frame = append(frame, body...)
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+	return frame, nil
+}
+
+func (c *BrokerClient) Close() error {
+	return c.conn.Close()
+}
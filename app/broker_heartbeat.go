@@ -0,0 +1,384 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+// Sessions of the registered brokers on the active controller, guarded by
+// brokerSessionsMu. They start over with every controller epoch: brokers
+// are given a full session from when this node saw the epoch start.
+var (
+	brokerSessionsMu      sync.Mutex
+	brokerSessionsEpoch   int32 = -1
+	brokerSessionsStartMs int64
+	lastBrokerHeartbeatMs = make(map[int32]int64)
+)
+
+// BrokerHeartbeatResult is what the controller tells a broker heartbeating.
+type BrokerHeartbeatResult struct {
+	IsCaughtUp     bool
+	IsFenced       bool
+	ShouldShutDown bool
+}
+
+// syncBrokerSessions starts the sessions over when the controller epoch
+// changed, callers hold brokerSessionsMu.
+func syncBrokerSessions(nowMs int64) {
+	_, epoch := raftClient.currentLeader()
+	if epoch != brokerSessionsEpoch {
+		brokerSessionsEpoch = epoch
+		brokerSessionsStartMs = nowMs
+		clear(lastBrokerHeartbeatMs)
+	}
+}
+
+func touchBrokerSession(brokerId int32, nowMs int64) {
+	brokerSessionsMu.Lock()
+	defer brokerSessionsMu.Unlock()
+	syncBrokerSessions(nowMs)
+	lastBrokerHeartbeatMs[brokerId] = nowMs
+}
+
+// brokerSessionExpired tells whether the broker was not heard from within
+// broker.session.timeout.ms.
+func brokerSessionExpired(brokerId int32, nowMs int64) bool {
+	brokerSessionsMu.Lock()
+	defer brokerSessionsMu.Unlock()
+	syncBrokerSessions(nowMs)
+	lastHeartbeatMs, ok := lastBrokerHeartbeatMs[brokerId]
+	if !ok {
+		lastHeartbeatMs = brokerSessionsStartMs
+	}
+	return nowMs-lastHeartbeatMs > int64(brokerConfig.BrokerSessionTimeoutMs)
+}
+
+// brokerSessionActive tells whether the broker heartbeated to this
+// controller within broker.session.timeout.ms.
+func brokerSessionActive(brokerId int32, nowMs int64) bool {
+	brokerSessionsMu.Lock()
+	defer brokerSessionsMu.Unlock()
+	syncBrokerSessions(nowMs)
+	lastHeartbeatMs, ok := lastBrokerHeartbeatMs[brokerId]
+	return ok && nowMs-lastHeartbeatMs <= int64(brokerConfig.BrokerSessionTimeoutMs)
+}
+
+// registerBroker records the registration of a broker, fenced until it
+// catches up with the metadata log. A restarted broker replaces its
+// registration once the session of the previous one is over, retries of
+// the same incarnation get the same epoch.
+func registerBroker(request *BrokerRegistrationRequestBody) (int64, error) {
+	if request.ClusterId != "" && clusterId != "" && string(request.ClusterId) != clusterId {
+		return -1, newKafkaError(ERROR_CODE_INCONSISTENT_CLUSTER_ID, "cluster id %s is not %s", request.ClusterId, clusterId)
+	}
+	brokerId := int32(request.BrokerId)
+	nowMs := time.Now().UnixMilli()
+
+	metadataWriteMu.Lock()
+	defer metadataWriteMu.Unlock()
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	if existing, ok := brokerRegistrations[brokerId]; ok {
+		if existing.IncarnationId == request.IncarnationId {
+			touchBrokerSession(brokerId, nowMs)
+			return existing.Epoch, nil
+		}
+		if !existing.Fenced && brokerSessionActive(brokerId, nowMs) {
+			return -1, newKafkaError(ERROR_CODE_DUPLICATE_BROKER_REGISTRATION, "broker %d is registered by another running incarnation", brokerId)
+		}
+	}
+
+	endpoints := make([]RegisterBrokerRecordEndpoint, len(request.Listeners))
+	for i, listener := range request.Listeners {
+		endpoints[i] = RegisterBrokerRecordEndpoint{
+			Name:             listener.Name,
+			Host:             listener.Host,
+			Port:             listener.Port,
+			SecurityProtocol: listener.SecurityProtocol,
+		}
+	}
+	features := make([]RegisterBrokerRecordFeature, len(request.Features))
+	for i, feature := range request.Features {
+		features[i] = RegisterBrokerRecordFeature{
+			Name:                feature.Name,
+			MinSupportedVersion: feature.MinSupportedVersion,
+			MaxSupportedVersion: feature.MaxSupportedVersion,
+		}
+	}
+	brokerEpoch := raftClient.log.LogEndOffset()
+	err := appendMetadataRecord(&RegisterBrokerRecordValue{
+		Header: RecordValueHeader{
+			FrameVersion: ktypes.Int8(METADATA_RECORD_FRAME_VERSION),
+			RecordType:   ktypes.Int8(REGISTER_BROKER_RECORD_TYPE),
+			Version:      ktypes.Int8(REGISTER_BROKER_RECORD_VERSION),
+		},
+		BrokerId:      request.BrokerId,
+		IncarnationId: request.IncarnationId,
+		BrokerEpoch:   ktypes.Int64(brokerEpoch),
+		EndPoints:     endpoints,
+		Features:      features,
+		Rack:          request.Rack,
+		Fenced:        true,
+		LogDirs:       request.LogDirs,
+	})
+	if err != nil {
+		return -1, err
+	}
+	touchBrokerSession(brokerId, nowMs)
+	fmt.Println("Registered broker ", brokerId, " with epoch ", brokerEpoch)
+	return brokerEpoch, nil
+}
+
+// processBrokerHeartbeat keeps the session of a broker alive. A broker is
+// unfenced once it caught up with its registration in the metadata log,
+// and fenced when it asks to be or to shut down. Log directories it reports
+// offline are taken out of its registration.
+func processBrokerHeartbeat(request *BrokerHeartbeatRequestBody) (*BrokerHeartbeatResult, error) {
+	brokerId := int32(request.BrokerId)
+	nowMs := time.Now().UnixMilli()
+
+	metadataWriteMu.Lock()
+	defer metadataWriteMu.Unlock()
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	registration, ok := brokerRegistrations[brokerId]
+	if !ok {
+		return nil, newKafkaError(ERROR_CODE_BROKER_ID_NOT_REGISTERED, "broker %d is not registered", brokerId)
+	}
+	if registration.Epoch != int64(request.BrokerEpoch) {
+		return nil, newKafkaError(ERROR_CODE_STALE_BROKER_EPOCH, "broker epoch %d of broker %d is not %d", request.BrokerEpoch, brokerId, registration.Epoch)
+	}
+	touchBrokerSession(brokerId, nowMs)
+
+	if value, ok := request.TaggedFields[BROKER_HEARTBEAT_OFFLINE_LOG_DIRS_TAG]; ok {
+		var offlineLogDirs DirectoryIdList
+		if err := ktypes.NewKDecoder(value).Decode(&offlineLogDirs); err != nil {
+			return nil, newKafkaError(ERROR_CODE_INVALID_REQUEST, "invalid offline log dirs: %v", err)
+		}
+		if err := takeLogDirsOffline(registration, offlineLogDirs.Ids); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &BrokerHeartbeatResult{
+		IsCaughtUp: int64(request.CurrentMetadataOffset) >= registration.Epoch,
+	}
+	var err error
+	switch {
+	case bool(request.WantShutDown):
+		err = fenceBroker(brokerId)
+		result.ShouldShutDown = err == nil
+	case bool(request.WantFence):
+		err = fenceBroker(brokerId)
+	case registration.Fenced && result.IsCaughtUp:
+		err = unfenceBroker(brokerId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.IsFenced = brokerRegistrations[brokerId].Fenced
+	return result, nil
+}
+
+// takeLogDirsOffline removes log directories from a broker's registration,
+// then moves leadership and ISR membership off the replicas they held.
+// Callers hold metadataWriteMu and metadataMu.
+func takeLogDirsOffline(registration *BrokerRegistration, offlineLogDirs []ktypes.UUID) error {
+	logDirs := slices.DeleteFunc(slices.Clone(registration.LogDirs), func(id ktypes.UUID) bool {
+		return slices.Contains(offlineLogDirs, id)
+	})
+	if len(logDirs) == len(registration.LogDirs) {
+		return nil
+	}
+	err := appendMetadataRecord(&BrokerRegistrationChangeRecordValue{
+		Header: RecordValueHeader{
+			FrameVersion: ktypes.Int8(METADATA_RECORD_FRAME_VERSION),
+			RecordType:   ktypes.Int8(BROKER_REGISTRATION_CHANGE_RECORD_TYPE),
+		},
+		BrokerId:    ktypes.Int32(registration.Id),
+		BrokerEpoch: ktypes.Int64(registration.Epoch),
+		TaggedFields: ktypes.TaggedFieldValues{
+			BROKER_REGISTRATION_CHANGE_LOG_DIRS_TAG: newDirectoryIdListField(logDirs),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println("Broker ", registration.Id, " has offline log directories, ", len(logDirs), " left online")
+	return moveReplicasOff(registration.Id, func(partition *PartitionRecordValue) bool {
+		return isOfflineReplica(partition, registration.Id)
+	})
+}
+
+// isUnfencedBroker tells whether a broker is registered and not fenced,
+// callers hold metadataMu.
+func isUnfencedBroker(brokerId int32) bool {
+	registration, ok := brokerRegistrations[brokerId]
+	return ok && !registration.Fenced
+}
+
+// changeBrokerFencing records a broker being fenced or unfenced. Callers
+// hold metadataWriteMu and metadataMu.
+func changeBrokerFencing(registration *BrokerRegistration, fencing int8) error {
+	encoded, err := ktypes.NewKEncoder().Encode(&BrokerFencingValue{Fenced: ktypes.Int8(fencing)})
+	if err != nil {
+		return fmt.Errorf("failed to encode broker fencing: %w", err)
+	}
+	return appendMetadataRecord(&BrokerRegistrationChangeRecordValue{
+		Header: RecordValueHeader{
+			FrameVersion: ktypes.Int8(METADATA_RECORD_FRAME_VERSION),
+			RecordType:   ktypes.Int8(BROKER_REGISTRATION_CHANGE_RECORD_TYPE),
+		},
+		BrokerId:    ktypes.Int32(registration.Id),
+		BrokerEpoch: ktypes.Int64(registration.Epoch),
+		TaggedFields: ktypes.TaggedFieldValues{
+			BROKER_REGISTRATION_CHANGE_FENCED_TAG: encoded,
+		},
+	})
+}
+
+// fenceBroker moves the partitions off a broker, then fences it. Callers
+// hold metadataWriteMu and metadataMu.
+func fenceBroker(brokerId int32) error {
+	if err := moveLeadershipOff(brokerId); err != nil {
+		return err
+	}
+	registration := brokerRegistrations[brokerId]
+	if registration.Fenced {
+		return nil
+	}
+	if err := changeBrokerFencing(registration, BROKER_FENCING_FENCE); err != nil {
+		return err
+	}
+	fmt.Println("Fenced broker ", brokerId)
+	return nil
+}
+
+// unfenceBroker unfences a broker, then gives it the partitions left with
+// no leader that it is an in-sync or eligible leader replica of, outside of
+// its offline log directories. Callers hold metadataWriteMu and
+// metadataMu.
+func unfenceBroker(brokerId int32) error {
+	if err := changeBrokerFencing(brokerRegistrations[brokerId], BROKER_FENCING_UNFENCE); err != nil {
+		return err
+	}
+	fmt.Println("Unfenced broker ", brokerId)
+
+	type leaderlessPartition struct {
+		topicId     ktypes.UUID
+		partitionId int32
+		isr         []int32
+	}
+	leaderless := make([]leaderlessPartition, 0)
+	for topicId, partitions := range topicIdToPartitions {
+		for _, partition := range partitions {
+			isr := toInt32Slice(partition.InSyncReplicas)
+			if partition.Leader != NO_LEADER || isOfflineReplica(&partition, brokerId) {
+				continue
+			}
+			if slices.Contains(isr, brokerId) {
+				leaderless = append(leaderless, leaderlessPartition{topicId, int32(partition.PartitionId), isr})
+			} else if slices.Contains(partition.eligibleLeaderReplicas, brokerId) {
+				leaderless = append(leaderless, leaderlessPartition{topicId, int32(partition.PartitionId), []int32{brokerId}})
+			}
+		}
+	}
+	for _, partition := range leaderless {
+		if err := changePartition(partition.topicId, partition.partitionId, brokerId, partition.isr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveLeadershipOff takes a broker out of the ISR of its partitions and
+// hands those it leads to their first unfenced in-sync replica, else to
+// their first unfenced eligible leader replica which becomes the ISR. The
+// last in-sync replica stays in the ISR otherwise, its partition has no
+// leader until it is back. Callers hold metadataWriteMu and metadataMu.
+func moveLeadershipOff(brokerId int32) error {
+	return moveReplicasOff(brokerId, func(*PartitionRecordValue) bool { return true })
+}
+
+// moveReplicasOff moves leadership and ISR membership off a broker like
+// moveLeadershipOff, for the partitions matching a filter. Callers hold
+// metadataWriteMu and metadataMu.
+func moveReplicasOff(brokerId int32, filter func(*PartitionRecordValue) bool) error {
+	type partitionMove struct {
+		topicId     ktypes.UUID
+		partitionId int32
+		leaderId    int32
+		isr         []int32
+	}
+	moves := make([]partitionMove, 0)
+	for topicId, partitions := range topicIdToPartitions {
+		for _, partition := range partitions {
+			isr := toInt32Slice(partition.InSyncReplicas)
+			leaderId := int32(partition.Leader)
+			if leaderId != brokerId && !slices.Contains(isr, brokerId) || !filter(&partition) {
+				continue
+			}
+			newIsr := slices.DeleteFunc(slices.Clone(isr), func(id int32) bool { return id == brokerId })
+			if len(newIsr) == 0 {
+				newIsr = isr
+			}
+			if leaderId == brokerId {
+				replicas := slices.DeleteFunc(toInt32Slice(partition.Replicas), func(id int32) bool { return id == brokerId })
+				leaderId = electLeader(replicas, newIsr)
+				if leaderId == NO_LEADER {
+					if leaderId = electLeader(replicas, partition.eligibleLeaderReplicas); leaderId != NO_LEADER {
+						newIsr = []int32{leaderId}
+					}
+				}
+			}
+			moves = append(moves, partitionMove{topicId, int32(partition.PartitionId), leaderId, newIsr})
+		}
+	}
+	for _, move := range moves {
+		if err := changePartition(move.topicId, move.partitionId, move.leaderId, move.isr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fenceExpiredBrokers fences the brokers whose session expired, and moves
+// the partitions still on them off.
+func fenceExpiredBrokers(nowMs int64) error {
+	if !raftClient.isLeader() {
+		return nil
+	}
+
+	metadataWriteMu.Lock()
+	defer metadataWriteMu.Unlock()
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	expired := make([]int32, 0)
+	for brokerId := range brokerRegistrations {
+		if brokerSessionExpired(brokerId, nowMs) {
+			expired = append(expired, brokerId)
+		}
+	}
+	slices.Sort(expired)
+	for _, brokerId := range expired {
+		if err := fenceBroker(brokerId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startBrokerSessionTask() {
+	runPeriodicTask(time.Duration(brokerConfig.BrokerHeartbeatIntervalMs)*time.Millisecond, func() {
+		if err := fenceExpiredBrokers(time.Now().UnixMilli()); err != nil {
+			fmt.Println("Error fencing brokers: ", err.Error())
+		}
+	})
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+// BrokerLifecycle registers this broker with the active controller and
+// keeps its session alive with heartbeats.
+type BrokerLifecycle struct {
+	// Held while talking to the controller
+	mu            sync.Mutex
+	incarnationId ktypes.UUID
+	// -1 until the broker is registered
+	epoch   int64
+	fenced  bool
+	stopped bool
+}
+
+var brokerLifecycle = &BrokerLifecycle{epoch: -1, fenced: true}
+
+// start registers this broker, when it has the broker role, then heartbeats
+// every broker.heartbeat.interval.ms.
+func (b *BrokerLifecycle) start() error {
+	if !slices.Contains(brokerConfig.ProcessRoles, PROCESS_ROLE_BROKER) {
+		return nil
+	}
+	if _, err := rand.Read(b.incarnationId[:]); err != nil {
+		return err
+	}
+
+	go func() {
+		interval := time.Duration(brokerConfig.BrokerHeartbeatIntervalMs) * time.Millisecond
+		for {
+			b.mu.Lock()
+			if b.stopped {
+				b.mu.Unlock()
+				return
+			}
+			registered := b.epoch >= 0
+			err := b.poll()
+			b.mu.Unlock()
+			if err != nil {
+				fmt.Println("Error heartbeating to the controller: ", err.Error())
+			}
+			// A registered broker heartbeats right away, to be unfenced
+			if registered || err != nil {
+				time.Sleep(interval)
+			}
+		}
+	}()
+	return nil
+}
+
+// poll registers the broker or heartbeats, then sends the log directories
+// of its replicas the metadata is missing. Callers hold b.mu.
+func (b *BrokerLifecycle) poll() error {
+	if b.epoch < 0 {
+		return b.register()
+	}
+
+	result, err := b.heartbeat(false)
+	if err != nil {
+		switch errorCodeFromError(err) {
+		case ERROR_CODE_STALE_BROKER_EPOCH, ERROR_CODE_BROKER_ID_NOT_REGISTERED:
+			// The controller lost the registration, or it was replaced
+			b.epoch = -1
+		}
+		return err
+	}
+	if result.IsFenced != b.fenced {
+		b.fenced = result.IsFenced
+		if b.fenced {
+			fmt.Println("Fenced by the controller")
+		} else {
+			fmt.Println("Unfenced by the controller")
+		}
+	}
+	return b.assignReplicasToDirs()
+}
+
+// register sends the listeners and rack of this broker to the active
+// controller, callers hold b.mu.
+func (b *BrokerLifecycle) register() error {
+	listeners := make([]BrokerRegistrationRequestListener, len(brokerConfig.AdvertisedListeners))
+	for i, listener := range brokerConfig.AdvertisedListeners {
+		listeners[i] = BrokerRegistrationRequestListener{
+			Name:             ktypes.CompactString(listener.Name),
+			Host:             ktypes.CompactString(listener.Host),
+			Port:             ktypes.Uint16(listener.Port),
+			SecurityProtocol: ktypes.Int16(securityProtocolIds[listener.SecurityProtocol]),
+		}
+	}
+	requestBody := BrokerRegistrationRequestBody{
+		BrokerId:            ktypes.Int32(brokerConfig.NodeId),
+		ClusterId:           ktypes.CompactString(clusterId),
+		IncarnationId:       b.incarnationId,
+		Listeners:           listeners,
+		Features:            []BrokerRegistrationRequestFeature{},
+		Rack:                ktypes.CompactNullableString(brokerConfig.BrokerRack),
+		LogDirs:             logDirIds(false),
+		PreviousBrokerEpoch: ktypes.Int64(-1),
+	}
+
+	var epoch int64
+	if raftClient.isLeader() {
+		var err error
+		if epoch, err = registerBroker(&requestBody); err != nil {
+			return err
+		}
+	} else {
+		var responseBody BrokerRegistrationResponseBody
+		if err := sendToController(BROKER_REGISTRATION_REQUEST_KEY, BROKER_REGISTRATION_VERSION, &requestBody, &responseBody); err != nil {
+			return err
+		}
+		if responseBody.ErrorCode != ERROR_CODE_NONE {
+			return newKafkaError(responseBody.ErrorCode, "controller returned error %d", responseBody.ErrorCode)
+		}
+		epoch = int64(responseBody.BrokerEpoch)
+	}
+	b.epoch = epoch
+	fmt.Println("Registered with the controller in broker epoch ", epoch)
+	return nil
+}
+
+// heartbeat tells the active controller how far this broker applied the
+// metadata log and which of its log directories are offline, callers hold
+// b.mu.
+func (b *BrokerLifecycle) heartbeat(wantShutDown bool) (*BrokerHeartbeatResult, error) {
+	metadataMu.Lock()
+	currentMetadataOffset := metadataAppliedOffset - 1
+	metadataMu.Unlock()
+	requestBody := BrokerHeartbeatRequestBody{
+		BrokerId:              ktypes.Int32(brokerConfig.NodeId),
+		BrokerEpoch:           ktypes.Int64(b.epoch),
+		CurrentMetadataOffset: ktypes.Int64(currentMetadataOffset),
+		WantShutDown:          ktypes.Bool(wantShutDown),
+		TaggedFields:          ktypes.TaggedFieldValues{},
+	}
+	if offlineLogDirs := logDirIds(true); len(offlineLogDirs) > 0 {
+		requestBody.TaggedFields[BROKER_HEARTBEAT_OFFLINE_LOG_DIRS_TAG] = newDirectoryIdListField(offlineLogDirs)
+	}
+
+	if raftClient.isLeader() {
+		return processBrokerHeartbeat(&requestBody)
+	}
+	var responseBody BrokerHeartbeatResponseBody
+	if err := sendToController(BROKER_HEARTBEAT_REQUEST_KEY, BROKER_HEARTBEAT_VERSION, &requestBody, &responseBody); err != nil {
+		return nil, err
+	}
+	if responseBody.ErrorCode != ERROR_CODE_NONE {
+		return nil, newKafkaError(responseBody.ErrorCode, "controller returned error %d", responseBody.ErrorCode)
+	}
+	return &BrokerHeartbeatResult{
+		IsCaughtUp:     bool(responseBody.IsCaughtUp),
+		IsFenced:       bool(responseBody.IsFenced),
+		ShouldShutDown: bool(responseBody.ShouldShutDown),
+	}, nil
+}
+
+// assignReplicasToDirs sends the active controller the log directories of
+// the replicas queued by queueDirectoryAssignment, callers hold b.mu.
+func (b *BrokerLifecycle) assignReplicasToDirs() error {
+	assignments := takeDirectoryAssignments()
+	if len(assignments) == 0 {
+		return nil
+	}
+
+	topicsByDirectory := make(map[ktypes.UUID]map[ktypes.UUID][]ktypes.Int32)
+	for key, directoryId := range assignments {
+		if topicsByDirectory[directoryId] == nil {
+			topicsByDirectory[directoryId] = make(map[ktypes.UUID][]ktypes.Int32)
+		}
+		topicsByDirectory[directoryId][key.topicId] = append(topicsByDirectory[directoryId][key.topicId], ktypes.Int32(key.partition))
+	}
+	requestBody := AssignReplicasToDirsRequestBody{
+		ClusterId:   ktypes.CompactString(clusterId),
+		BrokerId:    ktypes.Int32(brokerConfig.NodeId),
+		BrokerEpoch: ktypes.Int64(b.epoch),
+		Directories: make([]AssignReplicasToDirsRequestDirectory, 0, len(topicsByDirectory)),
+	}
+	for directoryId, topics := range topicsByDirectory {
+		directory := AssignReplicasToDirsRequestDirectory{Id: directoryId, Topics: make([]AssignReplicasToDirsRequestTopic, 0, len(topics))}
+		for topicId, partitionIds := range topics {
+			partitions := make([]AssignReplicasToDirsRequestPartition, len(partitionIds))
+			for i, partitionId := range partitionIds {
+				partitions[i] = AssignReplicasToDirsRequestPartition{PartitionIndex: partitionId}
+			}
+			directory.Topics = append(directory.Topics, AssignReplicasToDirsRequestTopic{TopicId: topicId, Partitions: partitions})
+		}
+		requestBody.Directories = append(requestBody.Directories, directory)
+	}
+
+	if raftClient.isLeader() {
+		if _, err := assignReplicasToDirs(&requestBody); err != nil {
+			return err
+		}
+	} else {
+		var responseBody AssignReplicasToDirsResponseBody
+		if err := sendToController(ASSIGN_REPLICAS_TO_DIRS_REQUEST_KEY, ASSIGN_REPLICAS_TO_DIRS_VERSION, &requestBody, &responseBody); err != nil {
+			return err
+		}
+		if responseBody.ErrorCode != ERROR_CODE_NONE {
+			return newKafkaError(responseBody.ErrorCode, "controller returned error %d", responseBody.ErrorCode)
+		}
+	}
+	completeDirectoryAssignments(assignments)
+	return nil
+}
+
+// controlledShutdown stops heartbeating and has the active controller move
+// the partitions off this broker, for up to
+// controller.quorum.request.timeout.ms.
+func (b *BrokerLifecycle) controlledShutdown() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stopped = true
+	if b.epoch < 0 {
+		return
+	}
+
+	deadline := time.Now().Add(time.Duration(brokerConfig.ControllerQuorumRequestTimeoutMs) * time.Millisecond)
+	for time.Now().Before(deadline) {
+		result, err := b.heartbeat(true)
+		if err == nil && result.ShouldShutDown {
+			fmt.Println("Controlled shutdown complete")
+			return
+		}
+		if err != nil {
+			fmt.Println("Error during controlled shutdown: ", err.Error())
+		}
+		time.Sleep(QUORUM_RETRY_BACKOFF_MS * time.Millisecond)
+	}
+	fmt.Println("Controlled shutdown did not complete")
+}
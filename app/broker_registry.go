@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	REGISTER_BROKER_RECORD_VERSION = 3
+
+	BROKER_REGISTRATION_CHANGE_FENCED_TAG   = 0
+	BROKER_REGISTRATION_CHANGE_LOG_DIRS_TAG = 2
+
+	// Values of the Fenced tag of broker registration changes
+	BROKER_FENCING_UNFENCE = -1
+	BROKER_FENCING_FENCE   = 1
+)
+
+// Security protocols as numbered in broker registrations
+var securityProtocolIds = map[string]int16{
+	SECURITY_PROTOCOL_PLAINTEXT:      0,
+	SECURITY_PROTOCOL_SSL:            1,
+	SECURITY_PROTOCOL_SASL_PLAINTEXT: 2,
+	SECURITY_PROTOCOL_SASL_SSL:       3,
+}
+
+type RegisterBrokerRecordEndpoint struct {
+	Name             ktypes.CompactString `order:"1"`
+	Host             ktypes.CompactString `order:"2"`
+	Port             ktypes.Uint16        `order:"3"`
+	SecurityProtocol ktypes.Int16         `order:"4"`
+	TaggedFields     ktypes.TaggedFields  `order:"5"`
+}
+
+type RegisterBrokerRecordFeature struct {
+	Name                ktypes.CompactString `order:"1"`
+	MinSupportedVersion ktypes.Int16         `order:"2"`
+	MaxSupportedVersion ktypes.Int16         `order:"3"`
+	TaggedFields        ktypes.TaggedFields  `order:"4"`
+}
+
+// Registers a broker and the endpoints of its listeners, version 3
+type RegisterBrokerRecordValue struct {
+	Header               RecordValueHeader                                 `order:"1"`
+	BrokerId             ktypes.Int32                                      `order:"2"`
+	IsMigratingZkBroker  ktypes.Bool                                       `order:"3"`
+	IncarnationId        ktypes.UUID                                       `order:"4"`
+	BrokerEpoch          ktypes.Int64                                      `order:"5"`
+	EndPoints            ktypes.CompactArray[RegisterBrokerRecordEndpoint] `order:"6"`
+	Features             ktypes.CompactArray[RegisterBrokerRecordFeature]  `order:"7"`
+	Rack                 ktypes.CompactNullableString                      `order:"8"`
+	Fenced               ktypes.Bool                                       `order:"9"`
+	InControlledShutdown ktypes.Bool                                       `order:"10"`
+	LogDirs              ktypes.CompactArray[ktypes.UUID]                  `order:"11"`
+	TaggedFields         ktypes.TaggedFields                               `order:"12"`
+}
+
+// Fences or unfences a registered broker, or changes its online log
+// directories. Only the fields changed are set, as tagged fields.
+type BrokerRegistrationChangeRecordValue struct {
+	Header       RecordValueHeader        `order:"1"`
+	BrokerId     ktypes.Int32             `order:"2"`
+	BrokerEpoch  ktypes.Int64             `order:"3"`
+	TaggedFields ktypes.TaggedFieldValues `order:"4"`
+}
+
+// Payload of the tagged field fencing or unfencing a broker
+type BrokerFencingValue struct {
+	Fenced ktypes.Int8 `order:"1"`
+}
+
+// BrokerRegistration is what the cluster knows of a broker. The epoch is
+// the offset of its registration in the metadata log.
+type BrokerRegistration struct {
+	Id            int32
+	IncarnationId ktypes.UUID
+	Epoch         int64
+	Endpoints     []Listener
+	Rack          string
+	Fenced        bool
+	// Online log directories, empty for brokers not reporting them
+	LogDirs []ktypes.UUID
+}
+
+// Registered brokers by id, guarded by metadataMu
+var brokerRegistrations = make(map[int32]*BrokerRegistration)
+
+func applyRegisterBrokerRecord(record *RegisterBrokerRecordValue) {
+	registration := &BrokerRegistration{
+		Id:            int32(record.BrokerId),
+		IncarnationId: record.IncarnationId,
+		Epoch:         int64(record.BrokerEpoch),
+		Endpoints:     make([]Listener, 0, len(record.EndPoints)),
+		Rack:          string(record.Rack),
+		Fenced:        bool(record.Fenced),
+		LogDirs:       slices.Clone(record.LogDirs),
+	}
+	for _, endpoint := range record.EndPoints {
+		securityProtocol := ""
+		for name, id := range securityProtocolIds {
+			if id == int16(endpoint.SecurityProtocol) {
+				securityProtocol = name
+			}
+		}
+		registration.Endpoints = append(registration.Endpoints, Listener{
+			Name:             string(endpoint.Name),
+			SecurityProtocol: securityProtocol,
+			Host:             string(endpoint.Host),
+			Port:             int32(endpoint.Port),
+		})
+	}
+	brokerRegistrations[registration.Id] = registration
+}
+
+// applyBrokerRegistrationChangeRecord fences or unfences a broker or sets
+// its online log directories, changes to an earlier registration of the
+// broker are ignored.
+func applyBrokerRegistrationChangeRecord(record *BrokerRegistrationChangeRecordValue) error {
+	registration, ok := brokerRegistrations[int32(record.BrokerId)]
+	if !ok || registration.Epoch != int64(record.BrokerEpoch) {
+		return nil
+	}
+	if value, ok := record.TaggedFields[BROKER_REGISTRATION_CHANGE_FENCED_TAG]; ok {
+		var fencing BrokerFencingValue
+		if err := ktypes.NewKDecoder(value).Decode(&fencing); err != nil {
+			return fmt.Errorf("invalid broker registration change fencing: %w", err)
+		}
+		switch fencing.Fenced {
+		case BROKER_FENCING_FENCE:
+			registration.Fenced = true
+		case BROKER_FENCING_UNFENCE:
+			registration.Fenced = false
+		}
+	}
+	if value, ok := record.TaggedFields[BROKER_REGISTRATION_CHANGE_LOG_DIRS_TAG]; ok {
+		var logDirs DirectoryIdList
+		if err := ktypes.NewKDecoder(value).Decode(&logDirs); err != nil {
+			return fmt.Errorf("invalid broker registration change log dirs: %w", err)
+		}
+		registration.LogDirs = slices.Clone(logDirs.Ids)
+	}
+	return nil
+}
+
+// decodeRegisterBrokerRecord decodes a broker registration, only the latest
+// record version is understood.
+func decodeRegisterBrokerRecord(decoder *ktypes.KDecoder, header *RecordValueHeader) (*RegisterBrokerRecordValue, error) {
+	if header.Version != REGISTER_BROKER_RECORD_VERSION {
+		return nil, fmt.Errorf("unsupported broker registration version %d", header.Version)
+	}
+	var record RegisterBrokerRecordValue
+	if err := decoder.Decode(&record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// brokerEndpoint returns the address of a broker on a listener. This broker
+// is always known from its own advertised listeners.
+func brokerEndpoint(brokerId int32, listenerName string) (Listener, bool) {
+	if brokerId == int32(brokerConfig.NodeId) {
+		return advertisedListener(listenerName)
+	}
+
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	registration, ok := brokerRegistrations[brokerId]
+	if !ok || registration.Fenced {
+		return Listener{}, false
+	}
+	return findListener(registration.Endpoints, listenerName)
+}
+
+// liveBrokerIds returns, sorted, this broker and the registered brokers that
+// are not fenced.
+func liveBrokerIds() []int32 {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	ids := []int32{int32(brokerConfig.NodeId)}
+	for id, registration := range brokerRegistrations {
+		if !registration.Fenced && id != int32(brokerConfig.NodeId) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// brokerRack returns the rack of a broker, empty when unknown.
+func brokerRack(brokerId int32) string {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	if registration, ok := brokerRegistrations[brokerId]; ok {
+		return registration.Rack
+	}
+	return ""
+}
+
+// isOnlineDirectory tells whether a log directory of a broker holds usable
+// replicas. Replicas not assigned a directory yet, or being migrated to
+// one, count as online, as do all directories of a broker not reporting
+// them. Callers hold metadataMu.
+func isOnlineDirectory(registration *BrokerRegistration, directoryId ktypes.UUID) bool {
+	switch directoryId {
+	case DIRECTORY_ID_UNASSIGNED, DIRECTORY_ID_MIGRATING:
+		return true
+	case DIRECTORY_ID_LOST:
+		return false
+	}
+	return len(registration.LogDirs) == 0 || slices.Contains(registration.LogDirs, directoryId)
+}
+
+// isOfflineReplica tells whether an unfenced broker's replica of a
+// partition is in a log directory that is not online. Fenced brokers have
+// yet to report the directories of their replicas. Callers hold metadataMu.
+func isOfflineReplica(partition *PartitionRecordValue, brokerId int32) bool {
+	registration, ok := brokerRegistrations[brokerId]
+	if !ok || registration.Fenced {
+		return false
+	}
+	return !isOnlineDirectory(registration, replicaDirectory(partition, brokerId))
+}
+
+// offlineReplicas returns the replicas of a partition that are in an
+// offline log directory.
+func offlineReplicas(partition *PartitionRecordValue) ktypes.CompactArray[ktypes.Int32] {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	replicas := ktypes.CompactArray[ktypes.Int32]{}
+	for _, replica := range partition.Replicas {
+		if isOfflineReplica(partition, int32(replica)) {
+			replicas = append(replicas, replica)
+		}
+	}
+	return replicas
+}
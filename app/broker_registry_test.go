@@ -0,0 +1,241 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+// setTestBrokerRegistrations replaces the registered brokers for the test
+func setTestBrokerRegistrations(t *testing.T, registrations ...*BrokerRegistration) {
+	t.Helper()
+	metadataMu.Lock()
+	previous := brokerRegistrations
+	brokerRegistrations = make(map[int32]*BrokerRegistration)
+	for _, registration := range registrations {
+		brokerRegistrations[registration.Id] = registration
+	}
+	metadataMu.Unlock()
+	t.Cleanup(func() {
+		metadataMu.Lock()
+		defer metadataMu.Unlock()
+		brokerRegistrations = previous
+	})
+}
+
+// brokerTestChange returns a registration change of a broker epoch with the
+// given tagged fields
+func brokerTestChange(brokerId int32, epoch int64, fields ktypes.TaggedFieldValues) *BrokerRegistrationChangeRecordValue {
+	return &BrokerRegistrationChangeRecordValue{
+		BrokerId:     ktypes.Int32(brokerId),
+		BrokerEpoch:  ktypes.Int64(epoch),
+		TaggedFields: fields,
+	}
+}
+
+// brokerTestFencing encodes the fencing tagged field
+func brokerTestFencing(t *testing.T, fencing int8) ktypes.TaggedFieldValues {
+	t.Helper()
+	encoded, err := ktypes.NewKEncoder().Encode(&BrokerFencingValue{Fenced: ktypes.Int8(fencing)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ktypes.TaggedFieldValues{BROKER_REGISTRATION_CHANGE_FENCED_TAG: encoded}
+}
+
+func TestApplyRegisterBrokerRecord(t *testing.T) {
+	setTestBrokerRegistrations(t)
+	directory := ktypes.UUID{7}
+	applyRegisterBrokerRecord(&RegisterBrokerRecordValue{
+		BrokerId:    2,
+		BrokerEpoch: 42,
+		EndPoints: ktypes.CompactArray[RegisterBrokerRecordEndpoint]{
+			{Name: "PLAINTEXT", Host: "broker-2", Port: 9092, SecurityProtocol: 0},
+			{Name: "SECURE", Host: "broker-2", Port: 9093, SecurityProtocol: 3},
+		},
+		Rack:    "rack-b",
+		Fenced:  true,
+		LogDirs: ktypes.CompactArray[ktypes.UUID]{directory},
+	})
+
+	registration, ok := brokerRegistrations[2]
+	if !ok {
+		t.Fatal("broker 2 not registered")
+	}
+	if registration.Epoch != 42 || registration.Rack != "rack-b" || !registration.Fenced {
+		t.Errorf("got registration %+v, want a fenced broker of epoch 42 in rack-b", registration)
+	}
+	if !slices.Equal(registration.LogDirs, []ktypes.UUID{directory}) {
+		t.Errorf("got log dirs %v, want %v", registration.LogDirs, []ktypes.UUID{directory})
+	}
+	want := []Listener{
+		{Name: "PLAINTEXT", SecurityProtocol: SECURITY_PROTOCOL_PLAINTEXT, Host: "broker-2", Port: 9092},
+		{Name: "SECURE", SecurityProtocol: SECURITY_PROTOCOL_SASL_SSL, Host: "broker-2", Port: 9093},
+	}
+	if !slices.Equal(registration.Endpoints, want) {
+		t.Errorf("got endpoints %v, want %v", registration.Endpoints, want)
+	}
+}
+
+func TestApplyBrokerRegistrationChangeRecord(t *testing.T) {
+	registration := &BrokerRegistration{Id: 2, Epoch: 10, Fenced: true}
+	setTestBrokerRegistrations(t, registration)
+	online := ktypes.UUID{7}
+
+	steps := []struct {
+		name       string
+		change     *BrokerRegistrationChangeRecordValue
+		wantFenced bool
+		wantDirs   []ktypes.UUID
+	}{
+		{"unfence", brokerTestChange(2, 10, brokerTestFencing(t, BROKER_FENCING_UNFENCE)), false, nil},
+		{"earlier registration", brokerTestChange(2, 9, brokerTestFencing(t, BROKER_FENCING_FENCE)), false, nil},
+		{"unknown broker", brokerTestChange(3, 10, brokerTestFencing(t, BROKER_FENCING_FENCE)), false, nil},
+		{"log dirs only", brokerTestChange(2, 10, ktypes.TaggedFieldValues{
+			BROKER_REGISTRATION_CHANGE_LOG_DIRS_TAG: newDirectoryIdListField([]ktypes.UUID{online}),
+		}), false, []ktypes.UUID{online}},
+		{"fence", brokerTestChange(2, 10, brokerTestFencing(t, BROKER_FENCING_FENCE)), true, []ktypes.UUID{online}},
+	}
+	for _, step := range steps {
+		if err := applyBrokerRegistrationChangeRecord(step.change); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if registration.Fenced != step.wantFenced || !slices.Equal(registration.LogDirs, step.wantDirs) {
+			t.Errorf("%s: got fenced %v, log dirs %v, want %v, %v", step.name, registration.Fenced, registration.LogDirs, step.wantFenced, step.wantDirs)
+		}
+	}
+
+	invalid := brokerTestChange(2, 10, ktypes.TaggedFieldValues{BROKER_REGISTRATION_CHANGE_FENCED_TAG: nil})
+	if err := applyBrokerRegistrationChangeRecord(invalid); err == nil {
+		t.Error("got no error for an empty fencing field")
+	}
+}
+
+func TestLiveBrokers(t *testing.T) {
+	previousConfig := brokerConfig
+	t.Cleanup(func() { brokerConfig = previousConfig })
+	brokerConfig.NodeId = 1
+	brokerConfig.AdvertisedListeners = []Listener{{Name: "PLAINTEXT", SecurityProtocol: SECURITY_PROTOCOL_PLAINTEXT, Host: "localhost", Port: 9092}}
+
+	endpoints := []Listener{{Name: "PLAINTEXT", SecurityProtocol: SECURITY_PROTOCOL_PLAINTEXT, Host: "broker", Port: 9092}}
+	setTestBrokerRegistrations(t,
+		&BrokerRegistration{Id: 1, Fenced: true},
+		&BrokerRegistration{Id: 3, Endpoints: endpoints, Rack: "rack-c"},
+		&BrokerRegistration{Id: 2, Endpoints: endpoints, Rack: "rack-b", Fenced: true},
+		&BrokerRegistration{Id: 4, Endpoints: endpoints},
+	)
+
+	if got, want := liveBrokerIds(), []int32{1, 3, 4}; !slices.Equal(got, want) {
+		t.Errorf("got live brokers %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		brokerId int32
+		listener string
+		wantHost string
+		wantOk   bool
+	}{
+		{1, "PLAINTEXT", "localhost", true},
+		{3, "PLAINTEXT", "broker", true},
+		{3, "SSL", "", false},
+		{2, "PLAINTEXT", "", false},
+		{5, "PLAINTEXT", "", false},
+	}
+	for _, test := range tests {
+		endpoint, ok := brokerEndpoint(test.brokerId, test.listener)
+		if endpoint.Host != test.wantHost || ok != test.wantOk {
+			t.Errorf("broker %d on %s: got %q, %v, want %q, %v", test.brokerId, test.listener, endpoint.Host, ok, test.wantHost, test.wantOk)
+		}
+	}
+
+	for brokerId, want := range map[int32]string{2: "rack-b", 3: "rack-c", 4: "", 5: ""} {
+		if got := brokerRack(brokerId); got != want {
+			t.Errorf("broker %d: got rack %q, want %q", brokerId, got, want)
+		}
+	}
+}
+
+func TestOfflineReplicas(t *testing.T) {
+	online, offline := ktypes.UUID{7}, ktypes.UUID{8}
+	setTestBrokerRegistrations(t,
+		&BrokerRegistration{Id: 1, LogDirs: []ktypes.UUID{online}},
+		&BrokerRegistration{Id: 2},
+		&BrokerRegistration{Id: 3, LogDirs: []ktypes.UUID{online}, Fenced: true},
+		&BrokerRegistration{Id: 4, LogDirs: []ktypes.UUID{online}},
+		&BrokerRegistration{Id: 5, LogDirs: []ktypes.UUID{online}},
+	)
+
+	tests := []struct {
+		name      string
+		directory ktypes.UUID
+		want      bool
+	}{
+		{"online", online, true},
+		{"offline", offline, false},
+		{"unassigned", DIRECTORY_ID_UNASSIGNED, true},
+		{"migrating", DIRECTORY_ID_MIGRATING, true},
+		{"lost", DIRECTORY_ID_LOST, false},
+	}
+	for _, test := range tests {
+		if got := isOnlineDirectory(brokerRegistrations[1], test.directory); got != test.want {
+			t.Errorf("%s: got online %v, want %v", test.name, got, test.want)
+		}
+	}
+	if !isOnlineDirectory(brokerRegistrations[2], offline) {
+		t.Error("got an offline directory for a broker not reporting directories")
+	}
+
+	partition := &PartitionRecordValue{
+		Replicas:    ktypes.CompactArray[ktypes.Int32]{1, 2, 3, 4, 5, 6},
+		Directories: ktypes.CompactArray[ktypes.UUID]{offline, offline, offline, DIRECTORY_ID_LOST, online, offline},
+	}
+	// Broker 2 reports no directories, 3 is fenced and 6 is not registered
+	want := ktypes.CompactArray[ktypes.Int32]{1, 4}
+	if got := offlineReplicas(partition); !slices.Equal(got, want) {
+		t.Errorf("got offline replicas %v, want %v", got, want)
+	}
+}
+
+func TestBrokerSessions(t *testing.T) {
+	previousClient := raftClient
+	t.Cleanup(func() { raftClient = previousClient })
+	raftClient = newTestRaftClient(t)
+	brokerConfig.BrokerSessionTimeoutMs = 1000
+	brokerSessionsMu.Lock()
+	brokerSessionsEpoch = -1
+	brokerSessionsMu.Unlock()
+	t.Cleanup(func() {
+		brokerSessionsMu.Lock()
+		defer brokerSessionsMu.Unlock()
+		brokerSessionsEpoch = -1
+		clear(lastBrokerHeartbeatMs)
+	})
+
+	// Brokers are given a full session from when the epoch started
+	if brokerSessionExpired(2, 10000) || brokerSessionExpired(2, 11000) {
+		t.Error("got an expired session within the first session")
+	}
+	if !brokerSessionExpired(2, 11001) {
+		t.Error("got no expiry once a session passed without heartbeats")
+	}
+	if brokerSessionActive(2, 11001) {
+		t.Error("got an active session for a broker never heard from")
+	}
+
+	touchBrokerSession(2, 12000)
+	if !brokerSessionActive(2, 12500) || brokerSessionExpired(2, 13000) {
+		t.Error("got no active session after a heartbeat")
+	}
+	if !brokerSessionExpired(2, 13001) || brokerSessionActive(2, 13001) {
+		t.Error("got an active session past the timeout")
+	}
+
+	// A new controller epoch starts the sessions over
+	raftClient.mu.Lock()
+	raftClient.epoch++
+	raftClient.mu.Unlock()
+	if brokerSessionExpired(2, 20000) || brokerSessionActive(2, 20000) {
+		t.Error("got a session carried over to a new epoch")
+	}
+}
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// BrokerConfig holds the settings read from the server.properties file the
+// broker is started with.
+type BrokerConfig struct {
+	NodeId int
+	// Empty when the broker is in no rack
+	BrokerRack string
+	// Folders of log.dirs holding the partition logs and their checkpoints,
+	// each ending with a slash. The first also holds the metadata log.
+	LogDirs []string
+
+	Listeners           []Listener
+	AdvertisedListeners []Listener
+
+	SaslEnabledMechanisms []string
+
+	SslCertificateLocation string
+	SslKeyLocation         string
+	// PEM bundle of the CAs client certificates are verified against
+	SslTruststoreLocation string
+	SslClientAuth         string
+
+	// Empty when every request is allowed
+	AuthorizerClassName       string
+	SuperUsers                []string
+	AllowEveryoneIfNoAclFound bool
+
+	// Empty when consumers fetch from the leaders only
+	ReplicaSelectorClassName string
+
+	// Size of the request handler pool and of the request queue it consumes
+	NumIoThreads          int
+	QueuedMaxRequests     int
+	SocketRequestMaxBytes int
+
+	MaxConnections               int
+	MaxConnectionsPerIp          int
+	MaxConnectionsPerIpOverrides map[string]int
+
+	LogSegmentBytes int
+	LogRollMs       int
+	// -1 keeps segments regardless of their age or of the partition size
+	LogRetentionMs              int
+	LogRetentionBytes           int
+	LogRetentionCheckIntervalMs int
+
+	// Closed segments of topics with remote.storage.enable are copied to
+	// remote storage, then only kept locally for the local retention, -2
+	// standing for log.retention.ms and log.retention.bytes
+	RemoteLogStorageSystemEnable     bool
+	RemoteLogStorageManagerClassName string
+	// Folder of the LocalTieredStorage
+	RemoteStorageManagerDir string
+	// Folder listing the remote segments of each partition, shared by the
+	// brokers like the remote storage
+	RemoteLogMetadataDir           string
+	RemoteLogManagerTaskIntervalMs int
+	LogLocalRetentionMs            int
+	LogLocalRetentionBytes         int
+
+	// Records and milliseconds after which logs are written to disk, in
+	// addition to acks=all produce requests and segment rolls
+	LogFlushIntervalMessages    int
+	LogFlushIntervalMs          int
+	LogFlushSchedulerIntervalMs int
+
+	// Policies of topics not overriding cleanup.policy
+	LogCleanupPolicy            string
+	LogCleanerEnable            bool
+	LogCleanerDeleteRetentionMs int
+	LogCleanerMinCleanableRatio float64
+	LogCleanerBackoffMs         int
+
+	// Listener followers fetch from their leaders on
+	InterBrokerListenerName string
+	// Followers not caught up for this long leave the ISR
+	ReplicaLagTimeMaxMs                      int
+	ReplicaFetchWaitMaxMs                    int
+	ReplicaFetchMaxBytes                     int
+	ReplicaFetchBackoffMs                    int
+	ReplicaHighWatermarkCheckpointIntervalMs int
+	// Fetch sessions kept for incremental fetch requests, 0 disables them
+	MaxIncrementalFetchSessionCacheSlots int
+	// ISR size acks=all produce requests need, unless the topic overrides it
+	MinInsyncReplicas int
+
+	// broker and/or controller. Controllers vote in the metadata quorum,
+	// brokers only replicate the metadata log as observers.
+	ProcessRoles []string
+	// host:port of the controllers voting in the metadata quorum, by node id.
+	// Without voters this node is the only one.
+	ControllerQuorumVoters               map[int32]string
+	ControllerQuorumElectionTimeoutMs    int
+	ControllerQuorumFetchTimeoutMs       int
+	ControllerQuorumElectionBackoffMaxMs int
+	ControllerQuorumRequestTimeoutMs     int
+
+	// Brokers heartbeat to the active controller, which fences those it did
+	// not hear from within the session timeout
+	BrokerHeartbeatIntervalMs int
+	BrokerSessionTimeoutMs    int
+
+	// The active controller moves leaderships back to the preferred leaders
+	// of brokers leading too few of their partitions
+	AutoLeaderRebalanceEnable           bool
+	LeaderImbalanceCheckIntervalSeconds int
+	LeaderImbalancePerBrokerPercentage  int
+}
+
+var brokerConfig = BrokerConfig{
+	NodeId:  DEFAULT_NODE_ID,
+	LogDirs: []string{DEFAULT_LOG_DIR},
+
+	Listeners:             []Listener{DEFAULT_LISTENER},
+	AdvertisedListeners:   defaultAdvertisedListeners([]Listener{DEFAULT_LISTENER}),
+	SaslEnabledMechanisms: []string{},
+	SslClientAuth:         SSL_CLIENT_AUTH_NONE,
+
+	NumIoThreads:                 DEFAULT_NUM_IO_THREADS,
+	QueuedMaxRequests:            DEFAULT_QUEUED_MAX_REQUESTS,
+	SocketRequestMaxBytes:        DEFAULT_SOCKET_REQUEST_MAX_BYTES,
+	MaxConnections:               math.MaxInt32,
+	MaxConnectionsPerIp:          math.MaxInt32,
+	MaxConnectionsPerIpOverrides: map[string]int{},
+
+	LogSegmentBytes:             DEFAULT_LOG_SEGMENT_BYTES,
+	LogRollMs:                   DEFAULT_LOG_ROLL_MS,
+	LogRetentionMs:              DEFAULT_LOG_RETENTION_MS,
+	LogRetentionBytes:           -1,
+	LogRetentionCheckIntervalMs: DEFAULT_LOG_RETENTION_CHECK_INTERVAL_MS,
+
+	RemoteLogManagerTaskIntervalMs: DEFAULT_REMOTE_LOG_MANAGER_TASK_INTERVAL_MS,
+	LogLocalRetentionMs:            LOCAL_RETENTION_FROM_RETENTION,
+	LogLocalRetentionBytes:         LOCAL_RETENTION_FROM_RETENTION,
+
+	LogFlushIntervalMessages:    math.MaxInt,
+	LogFlushIntervalMs:          math.MaxInt,
+	LogFlushSchedulerIntervalMs: DEFAULT_LOG_FLUSH_SCHEDULER_INTERVAL_MS,
+
+	LogCleanupPolicy:            CLEANUP_POLICY_DELETE,
+	LogCleanerEnable:            true,
+	LogCleanerDeleteRetentionMs: DEFAULT_LOG_CLEANER_DELETE_RETENTION_MS,
+	LogCleanerMinCleanableRatio: DEFAULT_LOG_CLEANER_MIN_CLEANABLE_RATIO,
+	LogCleanerBackoffMs:         DEFAULT_LOG_CLEANER_BACKOFF_MS,
+
+	InterBrokerListenerName:                  SECURITY_PROTOCOL_PLAINTEXT,
+	ReplicaLagTimeMaxMs:                      DEFAULT_REPLICA_LAG_TIME_MAX_MS,
+	ReplicaFetchWaitMaxMs:                    DEFAULT_REPLICA_FETCH_WAIT_MAX_MS,
+	ReplicaFetchMaxBytes:                     DEFAULT_REPLICA_FETCH_MAX_BYTES,
+	ReplicaFetchBackoffMs:                    DEFAULT_REPLICA_FETCH_BACKOFF_MS,
+	ReplicaHighWatermarkCheckpointIntervalMs: DEFAULT_REPLICA_HIGH_WATERMARK_CHECKPOINT_INTERVAL_MS,
+	MaxIncrementalFetchSessionCacheSlots:     DEFAULT_MAX_INCREMENTAL_FETCH_SESSION_CACHE_SLOTS,
+	MinInsyncReplicas:                        1,
+
+	ProcessRoles:                         []string{PROCESS_ROLE_BROKER, PROCESS_ROLE_CONTROLLER},
+	ControllerQuorumVoters:               map[int32]string{},
+	ControllerQuorumElectionTimeoutMs:    DEFAULT_CONTROLLER_QUORUM_ELECTION_TIMEOUT_MS,
+	ControllerQuorumFetchTimeoutMs:       DEFAULT_CONTROLLER_QUORUM_FETCH_TIMEOUT_MS,
+	ControllerQuorumElectionBackoffMaxMs: DEFAULT_CONTROLLER_QUORUM_ELECTION_BACKOFF_MAX_MS,
+	ControllerQuorumRequestTimeoutMs:     DEFAULT_CONTROLLER_QUORUM_REQUEST_TIMEOUT_MS,
+
+	BrokerHeartbeatIntervalMs: DEFAULT_BROKER_HEARTBEAT_INTERVAL_MS,
+	BrokerSessionTimeoutMs:    DEFAULT_BROKER_SESSION_TIMEOUT_MS,
+
+	AutoLeaderRebalanceEnable:           true,
+	LeaderImbalanceCheckIntervalSeconds: DEFAULT_LEADER_IMBALANCE_CHECK_INTERVAL_SECONDS,
+	LeaderImbalancePerBrokerPercentage:  DEFAULT_LEADER_IMBALANCE_PER_BROKER_PERCENTAGE,
+}
+
+const (
+	PROCESS_ROLE_BROKER     = "broker"
+	PROCESS_ROLE_CONTROLLER = "controller"
+)
+
+var DEFAULT_LISTENER = Listener{
+	Name:             SECURITY_PROTOCOL_PLAINTEXT,
+	SecurityProtocol: SECURITY_PROTOCOL_PLAINTEXT,
+	Host:             "0.0.0.0",
+	Port:             9092,
+}
+
+// By default every security protocol is also a listener name
+const DEFAULT_LISTENER_SECURITY_PROTOCOL_MAP = "PLAINTEXT:PLAINTEXT,SSL:SSL,SASL_PLAINTEXT:SASL_PLAINTEXT,SASL_SSL:SASL_SSL"
+
+// parseProperties reads a Java properties file made of key=value lines.
+func parseProperties(data string) map[string]string {
+	properties := make(map[string]string)
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			key, value, _ = strings.Cut(line, ":")
+		}
+		properties[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return properties
+}
+
+// parseList splits a comma separated property value.
+func parseList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseIntProperty reads an integer property that must be at least min.
+func parseIntProperty(properties map[string]string, key string, min int, value *int) error {
+	s, ok := properties[key]
+	if !ok {
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < min {
+		return fmt.Errorf("invalid %s %s", key, s)
+	}
+	*value = n
+	return nil
+}
+
+// parseConnectionOverrides reads max.connections.per.ip.overrides, a list of
+// host:count pairs.
+func parseConnectionOverrides(value string) (map[string]int, error) {
+	overrides := make(map[string]int)
+	for _, entry := range parseList(value) {
+		separator := strings.LastIndex(entry, ":")
+		if separator <= 0 {
+			return nil, fmt.Errorf("invalid connection limit override %s", entry)
+		}
+		n, err := strconv.Atoi(entry[separator+1:])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid connection limit override %s", entry)
+		}
+		overrides[entry[:separator]] = n
+	}
+	return overrides, nil
+}
+
+// parseQuorumVoters reads controller.quorum.voters, a list of
+// id@host:port entries.
+func parseQuorumVoters(value string) (map[int32]string, error) {
+	voters := make(map[int32]string)
+	for _, entry := range parseList(value) {
+		id, address, ok := strings.Cut(entry, "@")
+		if !ok {
+			return nil, fmt.Errorf("invalid controller quorum voter %s", entry)
+		}
+		voterId, err := strconv.ParseInt(id, 10, 32)
+		if err != nil || voterId < 0 {
+			return nil, fmt.Errorf("invalid controller quorum voter %s", entry)
+		}
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return nil, fmt.Errorf("invalid controller quorum voter %s", entry)
+		}
+		if _, ok := voters[int32(voterId)]; ok {
+			return nil, fmt.Errorf("duplicate controller quorum voter %d", voterId)
+		}
+		voters[int32(voterId)] = address
+	}
+	return voters, nil
+}
+
+// loadBrokerConfig applies the properties file at path on top of the defaults.
+func loadBrokerConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read broker config: %w", err)
+	}
+	properties := parseProperties(string(data))
+
+	if value, ok := properties["sasl.enabled.mechanisms"]; ok {
+		mechanisms := parseList(value)
+		for _, mechanism := range mechanisms {
+			if !isSupportedSaslMechanism(mechanism) {
+				return fmt.Errorf("unsupported SASL mechanism %s", mechanism)
+			}
+		}
+		brokerConfig.SaslEnabledMechanisms = mechanisms
+	}
+
+	protocolMap, ok := properties["listener.security.protocol.map"]
+	if !ok {
+		protocolMap = DEFAULT_LISTENER_SECURITY_PROTOCOL_MAP
+	}
+	protocols, err := parseSecurityProtocolMap(protocolMap)
+	if err != nil {
+		return err
+	}
+	if value, ok := properties["listeners"]; ok {
+		if brokerConfig.Listeners, err = parseListeners(value, protocols); err != nil {
+			return err
+		}
+		if len(brokerConfig.Listeners) == 0 {
+			return fmt.Errorf("at least one listener is required")
+		}
+	}
+	brokerConfig.AdvertisedListeners = defaultAdvertisedListeners(brokerConfig.Listeners)
+	if value, ok := properties["advertised.listeners"]; ok {
+		if brokerConfig.AdvertisedListeners, err = parseListeners(value, protocols); err != nil {
+			return err
+		}
+	}
+	for _, advertised := range brokerConfig.AdvertisedListeners {
+		listener, ok := findListener(brokerConfig.Listeners, advertised.Name)
+		if !ok {
+			return fmt.Errorf("advertised listener %s is not a listener", advertised.Name)
+		}
+		if advertised.Host == "" || advertised.Host == "0.0.0.0" || advertised.Host == "::" {
+			return fmt.Errorf("advertised listener %s must have a routable host", advertised.Name)
+		}
+		if advertised.SecurityProtocol != listener.SecurityProtocol {
+			return fmt.Errorf("advertised listener %s uses a different security protocol", advertised.Name)
+		}
+	}
+
+	brokerConfig.SslCertificateLocation = properties["ssl.certificate.location"]
+	brokerConfig.SslKeyLocation = properties["ssl.key.location"]
+	brokerConfig.SslTruststoreLocation = properties["ssl.truststore.location"]
+	if value, ok := properties["ssl.client.auth"]; ok {
+		brokerConfig.SslClientAuth = value
+	}
+	switch brokerConfig.SslClientAuth {
+	case SSL_CLIENT_AUTH_NONE:
+	case SSL_CLIENT_AUTH_REQUESTED, SSL_CLIENT_AUTH_REQUIRED:
+		if brokerConfig.SslTruststoreLocation == "" {
+			return fmt.Errorf("ssl.client.auth=%s requires ssl.truststore.location", brokerConfig.SslClientAuth)
+		}
+	default:
+		return fmt.Errorf("invalid ssl.client.auth %s", brokerConfig.SslClientAuth)
+	}
+	for _, listener := range brokerConfig.Listeners {
+		if listener.usesTls() && (brokerConfig.SslCertificateLocation == "" || brokerConfig.SslKeyLocation == "") {
+			return fmt.Errorf("listener %s requires ssl.certificate.location and ssl.key.location", listener.Name)
+		}
+		if listener.usesSasl() && len(brokerConfig.SaslEnabledMechanisms) == 0 {
+			return fmt.Errorf("listener %s requires sasl.enabled.mechanisms", listener.Name)
+		}
+	}
+
+	// log.dirs takes precedence over the single log.dir
+	logDirs := parseList(properties["log.dirs"])
+	if len(logDirs) == 0 && properties["log.dir"] != "" {
+		logDirs = []string{properties["log.dir"]}
+	}
+	if len(logDirs) > 0 {
+		brokerConfig.LogDirs = make([]string, 0, len(logDirs))
+		for _, logDir := range logDirs {
+			logDir = strings.TrimSuffix(logDir, "/") + "/"
+			if slices.Contains(brokerConfig.LogDirs, logDir) {
+				return fmt.Errorf("duplicate log directory %s", logDir)
+			}
+			brokerConfig.LogDirs = append(brokerConfig.LogDirs, logDir)
+		}
+	}
+
+	intProperties := []struct {
+		key   string
+		min   int
+		value *int
+	}{
+		{"node.id", 0, &brokerConfig.NodeId},
+		{"num.io.threads", 1, &brokerConfig.NumIoThreads},
+		{"queued.max.requests", 1, &brokerConfig.QueuedMaxRequests},
+		{"socket.request.max.bytes", 1, &brokerConfig.SocketRequestMaxBytes},
+		{"max.connections", 1, &brokerConfig.MaxConnections},
+		{"max.connections.per.ip", 1, &brokerConfig.MaxConnectionsPerIp},
+		{"log.segment.bytes", RECORD_BATCH_HEADER_SIZE, &brokerConfig.LogSegmentBytes},
+		{"log.roll.ms", 1, &brokerConfig.LogRollMs},
+		{"log.retention.ms", -1, &brokerConfig.LogRetentionMs},
+		{"log.retention.bytes", -1, &brokerConfig.LogRetentionBytes},
+		{"log.retention.check.interval.ms", 1, &brokerConfig.LogRetentionCheckIntervalMs},
+		{"remote.log.manager.task.interval.ms", 1, &brokerConfig.RemoteLogManagerTaskIntervalMs},
+		{"log.local.retention.ms", LOCAL_RETENTION_FROM_RETENTION, &brokerConfig.LogLocalRetentionMs},
+		{"log.local.retention.bytes", LOCAL_RETENTION_FROM_RETENTION, &brokerConfig.LogLocalRetentionBytes},
+		{"log.flush.interval.messages", 1, &brokerConfig.LogFlushIntervalMessages},
+		{"log.flush.interval.ms", 0, &brokerConfig.LogFlushIntervalMs},
+		{"log.flush.scheduler.interval.ms", 1, &brokerConfig.LogFlushSchedulerIntervalMs},
+		{"log.cleaner.delete.retention.ms", 0, &brokerConfig.LogCleanerDeleteRetentionMs},
+		{"log.cleaner.backoff.ms", 1, &brokerConfig.LogCleanerBackoffMs},
+		{"replica.lag.time.max.ms", 2, &brokerConfig.ReplicaLagTimeMaxMs},
+		{"replica.fetch.wait.max.ms", 0, &brokerConfig.ReplicaFetchWaitMaxMs},
+		{"replica.fetch.max.bytes", 1, &brokerConfig.ReplicaFetchMaxBytes},
+		{"max.incremental.fetch.session.cache.slots", 0, &brokerConfig.MaxIncrementalFetchSessionCacheSlots},
+		{"replica.fetch.backoff.ms", 0, &brokerConfig.ReplicaFetchBackoffMs},
+		{"replica.high.watermark.checkpoint.interval.ms", 1, &brokerConfig.ReplicaHighWatermarkCheckpointIntervalMs},
+		{"min.insync.replicas", 1, &brokerConfig.MinInsyncReplicas},
+		{"controller.quorum.election.timeout.ms", 1, &brokerConfig.ControllerQuorumElectionTimeoutMs},
+		{"controller.quorum.fetch.timeout.ms", 1, &brokerConfig.ControllerQuorumFetchTimeoutMs},
+		{"controller.quorum.election.backoff.max.ms", 1, &brokerConfig.ControllerQuorumElectionBackoffMaxMs},
+		{"controller.quorum.request.timeout.ms", 1, &brokerConfig.ControllerQuorumRequestTimeoutMs},
+		{"broker.heartbeat.interval.ms", 1, &brokerConfig.BrokerHeartbeatIntervalMs},
+		{"broker.session.timeout.ms", 1, &brokerConfig.BrokerSessionTimeoutMs},
+		{"leader.imbalance.check.interval.seconds", 1, &brokerConfig.LeaderImbalanceCheckIntervalSeconds},
+		{"leader.imbalance.per.broker.percentage", 0, &brokerConfig.LeaderImbalancePerBrokerPercentage},
+	}
+	for _, property := range intProperties {
+		if err := parseIntProperty(properties, property.key, property.min, property.value); err != nil {
+			return err
+		}
+	}
+	if brokerConfig.BrokerSessionTimeoutMs <= brokerConfig.BrokerHeartbeatIntervalMs {
+		return fmt.Errorf("broker.session.timeout.ms must be above broker.heartbeat.interval.ms")
+	}
+	brokerConfig.BrokerRack = properties["broker.rack"]
+	if value, ok := properties["inter.broker.listener.name"]; ok {
+		brokerConfig.InterBrokerListenerName = value
+	}
+	if _, ok := findListener(brokerConfig.AdvertisedListeners, brokerConfig.InterBrokerListenerName); !ok {
+		return fmt.Errorf("inter.broker.listener.name %s is not an advertised listener", brokerConfig.InterBrokerListenerName)
+	}
+	if err := loadQuorumConfig(properties); err != nil {
+		return err
+	}
+	if value, ok := properties["log.cleanup.policy"]; ok {
+		for _, policy := range parseList(value) {
+			if policy != CLEANUP_POLICY_DELETE && policy != CLEANUP_POLICY_COMPACT {
+				return fmt.Errorf("invalid log.cleanup.policy %s", value)
+			}
+		}
+		brokerConfig.LogCleanupPolicy = value
+	}
+	if value, ok := properties["log.cleaner.enable"]; ok {
+		brokerConfig.LogCleanerEnable = value == "true"
+	}
+	if value, ok := properties["auto.leader.rebalance.enable"]; ok {
+		brokerConfig.AutoLeaderRebalanceEnable = value == "true"
+	}
+	if value, ok := properties["log.cleaner.min.cleanable.ratio"]; ok {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return fmt.Errorf("invalid log.cleaner.min.cleanable.ratio %s", value)
+		}
+		brokerConfig.LogCleanerMinCleanableRatio = ratio
+	}
+	if value, ok := properties["max.connections.per.ip.overrides"]; ok {
+		if brokerConfig.MaxConnectionsPerIpOverrides, err = parseConnectionOverrides(value); err != nil {
+			return err
+		}
+	}
+
+	brokerConfig.AuthorizerClassName = properties["authorizer.class.name"]
+	if value, ok := properties["super.users"]; ok {
+		// Principals may contain commas, so super users are separated by semicolons
+		brokerConfig.SuperUsers = make([]string, 0)
+		for _, superUser := range strings.Split(value, ";") {
+			if superUser = strings.TrimSpace(superUser); superUser != "" {
+				brokerConfig.SuperUsers = append(brokerConfig.SuperUsers, superUser)
+			}
+		}
+	}
+	brokerConfig.AllowEveryoneIfNoAclFound = properties["allow.everyone.if.no.acl.found"] == "true"
+	switch brokerConfig.AuthorizerClassName {
+	case "":
+		authorizer = nil
+	case STANDARD_AUTHORIZER_CLASS_NAME:
+		authorizer = &StandardAuthorizer{
+			SuperUsers:                brokerConfig.SuperUsers,
+			AllowEveryoneIfNoAclFound: brokerConfig.AllowEveryoneIfNoAclFound,
+		}
+	default:
+		return fmt.Errorf("unsupported authorizer %s", brokerConfig.AuthorizerClassName)
+	}
+
+	brokerConfig.ReplicaSelectorClassName = properties["replica.selector.class"]
+	switch brokerConfig.ReplicaSelectorClassName {
+	case "":
+		replicaSelector = nil
+	case RACK_AWARE_REPLICA_SELECTOR_CLASS_NAME:
+		replicaSelector = &RackAwareReplicaSelector{}
+	default:
+		return fmt.Errorf("unsupported replica selector %s", brokerConfig.ReplicaSelectorClassName)
+	}
+
+	brokerConfig.RemoteLogStorageSystemEnable = properties["remote.log.storage.system.enable"] == "true"
+	brokerConfig.RemoteLogStorageManagerClassName = properties["remote.log.storage.manager.class.name"]
+	brokerConfig.RemoteStorageManagerDir = properties["rsm.config.dir"]
+	brokerConfig.RemoteLogMetadataDir = properties["remote.log.metadata.dir"]
+	remoteStorageManager = nil
+	if brokerConfig.RemoteLogStorageSystemEnable {
+		switch brokerConfig.RemoteLogStorageManagerClassName {
+		case LOCAL_TIERED_STORAGE_CLASS_NAME:
+			if brokerConfig.RemoteStorageManagerDir == "" {
+				return fmt.Errorf("%s requires rsm.config.dir", LOCAL_TIERED_STORAGE_CLASS_NAME)
+			}
+			remoteStorageManager = &LocalTieredStorage{Dir: brokerConfig.RemoteStorageManagerDir}
+		default:
+			return fmt.Errorf("unsupported remote storage manager %s", brokerConfig.RemoteLogStorageManagerClassName)
+		}
+		if brokerConfig.RemoteLogMetadataDir == "" {
+			return fmt.Errorf("remote.log.storage.system.enable requires remote.log.metadata.dir")
+		}
+	}
+
+	return nil
+}
+
+// loadQuorumConfig reads the roles of this node and the voters of the
+// metadata quorum. Controllers must be voters, reachable on a PLAINTEXT
+// listener at their voter address, and brokers that are not controllers
+// need voters to fetch the metadata log from.
+func loadQuorumConfig(properties map[string]string) error {
+	if value, ok := properties["process.roles"]; ok {
+		roles := parseList(value)
+		if len(roles) == 0 {
+			return fmt.Errorf("process.roles requires at least one role")
+		}
+		for _, role := range roles {
+			if role != PROCESS_ROLE_BROKER && role != PROCESS_ROLE_CONTROLLER {
+				return fmt.Errorf("invalid process.roles %s", value)
+			}
+		}
+		brokerConfig.ProcessRoles = roles
+	}
+	if value, ok := properties["controller.quorum.voters"]; ok {
+		voters, err := parseQuorumVoters(value)
+		if err != nil {
+			return err
+		}
+		brokerConfig.ControllerQuorumVoters = voters
+	}
+
+	nodeId := int32(brokerConfig.NodeId)
+	isController := slices.Contains(brokerConfig.ProcessRoles, PROCESS_ROLE_CONTROLLER)
+	if len(brokerConfig.ControllerQuorumVoters) == 0 {
+		if !isController {
+			return fmt.Errorf("controller.quorum.voters is required when process.roles does not include controller")
+		}
+		return nil
+	}
+	address, isVoter := brokerConfig.ControllerQuorumVoters[nodeId]
+	if isController != isVoter {
+		return fmt.Errorf("node %d must be in controller.quorum.voters exactly when process.roles includes controller", nodeId)
+	}
+	if isVoter {
+		if _, ok := controllerListener(address); !ok {
+			return fmt.Errorf("controller.quorum.voters address %s of node %d is not a PLAINTEXT listener", address, nodeId)
+		}
+	}
+	return nil
+}
+
+// controllerListener returns the listener serving a voter address of this
+// node.
+func controllerListener(address string) (Listener, bool) {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return Listener{}, false
+	}
+	for _, listener := range brokerConfig.Listeners {
+		if listener.SecurityProtocol == SECURITY_PROTOCOL_PLAINTEXT && strconv.Itoa(int(listener.Port)) == port {
+			return listener, true
+		}
+	}
+	return Listener{}, false
+}
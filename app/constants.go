@@ -6,21 +6,214 @@ var NULL_UUID = ktypes.UUID{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
 
 const (
 	API_VERSIONS_REQUEST_KEY              = 18
+	METADATA_REQUEST_KEY                  = 3
+	LIST_OFFSETS_REQUEST_KEY              = 2
+	DELETE_RECORDS_REQUEST_KEY            = 21
+	DESCRIBE_ACLS_REQUEST_KEY             = 29
+	CREATE_ACLS_REQUEST_KEY               = 30
+	DELETE_ACLS_REQUEST_KEY               = 31
+	DESCRIBE_CLIENT_QUOTAS_REQUEST_KEY    = 48
+	ALTER_CLIENT_QUOTAS_REQUEST_KEY       = 49
 	DESCRIBE_TOPIC_PARTITIONS_REQUEST_KEY = 75
 	FETCH_REQUEST_KEY                      = 1
 	PRODUCE_REQUEST_KEY                    = 0
+	OFFSET_COMMIT_REQUEST_KEY              = 8
+	OFFSET_FETCH_REQUEST_KEY               = 9
+	CONSUMER_GROUP_HEARTBEAT_REQUEST_KEY   = 68
+	CONSUMER_GROUP_DESCRIBE_REQUEST_KEY    = 69
+	INIT_PRODUCER_ID_REQUEST_KEY           = 22
+	ADD_PARTITIONS_TO_TXN_REQUEST_KEY      = 24
+	ADD_OFFSETS_TO_TXN_REQUEST_KEY         = 25
+	END_TXN_REQUEST_KEY                    = 26
+	TXN_OFFSET_COMMIT_REQUEST_KEY          = 28
+	SASL_HANDSHAKE_REQUEST_KEY             = 17
+	SASL_AUTHENTICATE_REQUEST_KEY          = 36
+	DESCRIBE_USER_SCRAM_CREDENTIALS_REQUEST_KEY = 50
+	ALTER_USER_SCRAM_CREDENTIALS_REQUEST_KEY    = 51
+	OFFSET_FOR_LEADER_EPOCH_REQUEST_KEY         = 23
+	VOTE_REQUEST_KEY                            = 52
+	BEGIN_QUORUM_EPOCH_REQUEST_KEY              = 53
+	END_QUORUM_EPOCH_REQUEST_KEY                = 54
+	ALTER_PARTITION_REQUEST_KEY                 = 56
+	ENVELOPE_REQUEST_KEY                        = 58
+	FETCH_SNAPSHOT_REQUEST_KEY                  = 59
+	ALLOCATE_PRODUCER_IDS_REQUEST_KEY           = 67
+	BROKER_REGISTRATION_REQUEST_KEY             = 62
+	BROKER_HEARTBEAT_REQUEST_KEY                = 63
+	ALTER_PARTITION_REASSIGNMENTS_REQUEST_KEY   = 45
+	LIST_PARTITION_REASSIGNMENTS_REQUEST_KEY    = 46
+	ELECT_LEADERS_REQUEST_KEY                   = 43
+	ALTER_REPLICA_LOG_DIRS_REQUEST_KEY          = 34
+	DESCRIBE_LOG_DIRS_REQUEST_KEY               = 35
+	ASSIGN_REPLICAS_TO_DIRS_REQUEST_KEY         = 73
 )
 
+// First flexible version of each API, from which request headers carry tagged fields
+var flexibleRequestVersions = map[ktypes.Int16]ktypes.Int16{
+	API_VERSIONS_REQUEST_KEY:              3,
+	METADATA_REQUEST_KEY:                  9,
+	LIST_OFFSETS_REQUEST_KEY:              6,
+	DELETE_RECORDS_REQUEST_KEY:            2,
+	DESCRIBE_ACLS_REQUEST_KEY:             2,
+	CREATE_ACLS_REQUEST_KEY:               2,
+	DELETE_ACLS_REQUEST_KEY:               2,
+	DESCRIBE_CLIENT_QUOTAS_REQUEST_KEY:    1,
+	ALTER_CLIENT_QUOTAS_REQUEST_KEY:       1,
+	DESCRIBE_TOPIC_PARTITIONS_REQUEST_KEY: 0,
+	FETCH_REQUEST_KEY:                     12,
+	PRODUCE_REQUEST_KEY:                   9,
+	OFFSET_COMMIT_REQUEST_KEY:             8,
+	OFFSET_FETCH_REQUEST_KEY:              6,
+	CONSUMER_GROUP_HEARTBEAT_REQUEST_KEY:  0,
+	CONSUMER_GROUP_DESCRIBE_REQUEST_KEY:   0,
+	INIT_PRODUCER_ID_REQUEST_KEY:          2,
+	ADD_PARTITIONS_TO_TXN_REQUEST_KEY:     3,
+	ADD_OFFSETS_TO_TXN_REQUEST_KEY:        3,
+	END_TXN_REQUEST_KEY:                   3,
+	TXN_OFFSET_COMMIT_REQUEST_KEY:         3,
+	SASL_AUTHENTICATE_REQUEST_KEY:         2,
+	DESCRIBE_USER_SCRAM_CREDENTIALS_REQUEST_KEY: 0,
+	ALTER_USER_SCRAM_CREDENTIALS_REQUEST_KEY:    0,
+	OFFSET_FOR_LEADER_EPOCH_REQUEST_KEY:         4,
+	VOTE_REQUEST_KEY:                            0,
+	BEGIN_QUORUM_EPOCH_REQUEST_KEY:              1,
+	END_QUORUM_EPOCH_REQUEST_KEY:                1,
+	ALTER_PARTITION_REQUEST_KEY:                 0,
+	ENVELOPE_REQUEST_KEY:                        0,
+	FETCH_SNAPSHOT_REQUEST_KEY:                  0,
+	ALLOCATE_PRODUCER_IDS_REQUEST_KEY:           0,
+	BROKER_REGISTRATION_REQUEST_KEY:             0,
+	BROKER_HEARTBEAT_REQUEST_KEY:                0,
+	ALTER_PARTITION_REASSIGNMENTS_REQUEST_KEY:   0,
+	LIST_PARTITION_REASSIGNMENTS_REQUEST_KEY:    0,
+	ELECT_LEADERS_REQUEST_KEY:                   2,
+	ALTER_REPLICA_LOG_DIRS_REQUEST_KEY:          2,
+	DESCRIBE_LOG_DIRS_REQUEST_KEY:               2,
+	ASSIGN_REPLICAS_TO_DIRS_REQUEST_KEY:         0,
+}
+
 type ERROR_CODE = ktypes.Int16
 
 const (
+	ERROR_CODE_UNKNOWN_SERVER_ERROR       ERROR_CODE = -1
 	ERROR_CODE_NONE                       ERROR_CODE = 0
+	ERROR_CODE_OFFSET_OUT_OF_RANGE        ERROR_CODE = 1
+	ERROR_CODE_CORRUPT_MESSAGE            ERROR_CODE = 2
 	ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION ERROR_CODE = 3
+	ERROR_CODE_NOT_LEADER_OR_FOLLOWER     ERROR_CODE = 6
+	ERROR_CODE_REQUEST_TIMED_OUT          ERROR_CODE = 7
+	ERROR_CODE_OFFSET_METADATA_TOO_LARGE  ERROR_CODE = 12
+	ERROR_CODE_NOT_ENOUGH_REPLICAS        ERROR_CODE = 19
+	ERROR_CODE_NOT_ENOUGH_REPLICAS_AFTER_APPEND ERROR_CODE = 20
+	ERROR_CODE_INVALID_REPLICA_ASSIGNMENT ERROR_CODE = 39
+	ERROR_CODE_ILLEGAL_GENERATION         ERROR_CODE = 22
+	ERROR_CODE_INVALID_GROUP_ID           ERROR_CODE = 24
+	ERROR_CODE_UNKNOWN_MEMBER_ID          ERROR_CODE = 25
+	ERROR_CODE_NOT_CONTROLLER             ERROR_CODE = 41
+	ERROR_CODE_INVALID_REQUEST            ERROR_CODE = 42
+	ERROR_CODE_OUT_OF_ORDER_SEQUENCE_NUMBER ERROR_CODE = 45
+	ERROR_CODE_DUPLICATE_SEQUENCE_NUMBER  ERROR_CODE = 46
+	ERROR_CODE_INVALID_PRODUCER_EPOCH     ERROR_CODE = 47
+	ERROR_CODE_INVALID_TXN_STATE          ERROR_CODE = 48
+	ERROR_CODE_INVALID_PRODUCER_ID_MAPPING ERROR_CODE = 49
+	ERROR_CODE_INVALID_TRANSACTION_TIMEOUT ERROR_CODE = 50
+	ERROR_CODE_CONCURRENT_TRANSACTIONS    ERROR_CODE = 51
+	ERROR_CODE_OPERATION_NOT_ATTEMPTED    ERROR_CODE = 55
+	ERROR_CODE_KAFKA_STORAGE_ERROR        ERROR_CODE = 56
+	ERROR_CODE_LOG_DIR_NOT_FOUND          ERROR_CODE = 57
+	ERROR_CODE_PREFERRED_LEADER_NOT_AVAILABLE ERROR_CODE = 80
+	ERROR_CODE_ELIGIBLE_LEADERS_NOT_AVAILABLE ERROR_CODE = 83
+	ERROR_CODE_ELECTION_NOT_NEEDED        ERROR_CODE = 84
+	ERROR_CODE_NO_REASSIGNMENT_IN_PROGRESS ERROR_CODE = 85
+	ERROR_CODE_UNSTABLE_OFFSET_COMMIT     ERROR_CODE = 88
+	ERROR_CODE_PRODUCER_FENCED            ERROR_CODE = 90
+	ERROR_CODE_GROUP_ID_NOT_FOUND         ERROR_CODE = 69
+	ERROR_CODE_FETCH_SESSION_ID_NOT_FOUND ERROR_CODE = 70
+	ERROR_CODE_INVALID_FETCH_SESSION_EPOCH ERROR_CODE = 71
+	ERROR_CODE_FENCED_MEMBER_EPOCH        ERROR_CODE = 110
+	ERROR_CODE_UNSUPPORTED_ASSIGNOR       ERROR_CODE = 112
+	ERROR_CODE_STALE_MEMBER_EPOCH         ERROR_CODE = 113
 	ERROR_CODE_UNKNOWN_TOPIC_ID           ERROR_CODE = 100
 	ERROR_CODE_UNSUPPORTED_VERSION        ERROR_CODE = 35
+	ERROR_CODE_UNSUPPORTED_SASL_MECHANISM ERROR_CODE = 33
+	ERROR_CODE_ILLEGAL_SASL_STATE         ERROR_CODE = 34
+	ERROR_CODE_SASL_AUTHENTICATION_FAILED ERROR_CODE = 58
+	ERROR_CODE_RESOURCE_NOT_FOUND         ERROR_CODE = 91
+	ERROR_CODE_DUPLICATE_RESOURCE         ERROR_CODE = 92
+	ERROR_CODE_UNACCEPTABLE_CREDENTIAL    ERROR_CODE = 93
+	ERROR_CODE_INCONSISTENT_VOTER_SET     ERROR_CODE = 94
+	ERROR_CODE_INVALID_UPDATE_VERSION     ERROR_CODE = 95
+	ERROR_CODE_PRINCIPAL_DESERIALIZATION_FAILURE ERROR_CODE = 97
+	ERROR_CODE_SNAPSHOT_NOT_FOUND         ERROR_CODE = 98
+	ERROR_CODE_POSITION_OUT_OF_RANGE      ERROR_CODE = 99
+	ERROR_CODE_INCONSISTENT_CLUSTER_ID    ERROR_CODE = 104
+	ERROR_CODE_INELIGIBLE_REPLICA         ERROR_CODE = 107
+	ERROR_CODE_LISTENER_NOT_FOUND         ERROR_CODE = 72
+	ERROR_CODE_FENCED_LEADER_EPOCH        ERROR_CODE = 74
+	ERROR_CODE_STALE_BROKER_EPOCH         ERROR_CODE = 77
+	ERROR_CODE_DUPLICATE_BROKER_REGISTRATION ERROR_CODE = 101
+	ERROR_CODE_BROKER_ID_NOT_REGISTERED   ERROR_CODE = 102
+	ERROR_CODE_UNKNOWN_LEADER_EPOCH       ERROR_CODE = 75
+	ERROR_CODE_TOPIC_AUTHORIZATION_FAILED ERROR_CODE = 29
+	ERROR_CODE_GROUP_AUTHORIZATION_FAILED ERROR_CODE = 30
+	ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED ERROR_CODE = 31
+	ERROR_CODE_TRANSACTIONAL_ID_AUTHORIZATION_FAILED ERROR_CODE = 53
+	ERROR_CODE_SECURITY_DISABLED          ERROR_CODE = 54
 )
 
-const METADATA_TOPIC = "__clusters_metadata"
-const LOGS_BASE_FOLDER = "/tmp/kraft-combined-logs/"
-const TEST_LOG_FILE = "tmp/kraft-combined-logs/__cluster_metadata-0/kafka_sample.log"
-const LOGS_SRC_FOLDER = "/tmp/kraft-combined-logs/__cluster_metadata-0/00000000000000000000.log"
+const METADATA_TOPIC = "__cluster_metadata"
+
+// Fixed id of the metadata topic, which has a single partition
+var METADATA_TOPIC_ID = ktypes.UUID{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+
+const DEFAULT_NODE_ID = 1
+const PRODUCER_ID_BLOCK_SIZE = 1000
+const CONSUMER_OFFSETS_TOPIC = "__consumer_offsets"
+const CONSUMER_OFFSETS_PARTITIONS = 50
+const OFFSETS_RETENTION_MS = 7 * 24 * 60 * 60 * 1000
+const OFFSETS_RETENTION_CHECK_INTERVAL_MS = 10 * 60 * 1000
+const OFFSET_METADATA_MAX_BYTES = 4096
+const CONSUMER_GROUP_HEARTBEAT_INTERVAL_MS = 5000
+const CONSUMER_GROUP_SESSION_TIMEOUT_MS = 45000
+const CONSUMER_GROUP_SESSION_CHECK_INTERVAL_MS = 1000
+const TRANSACTION_STATE_TOPIC = "__transaction_state"
+const TRANSACTION_STATE_PARTITIONS = 50
+const TRANSACTION_MAX_TIMEOUT_MS = 15 * 60 * 1000
+const TRANSACTION_ABORT_CHECK_INTERVAL_MS = 10 * 1000
+const TLS_RELOAD_CHECK_INTERVAL_MS = 30 * 1000
+const TLS_HANDSHAKE_TIMEOUT_MS = 10 * 1000
+const QUOTA_WINDOW_NUM = 11
+const QUOTA_WINDOW_SIZE_MS = 1000
+const QUOTA_MAX_THROTTLE_TIME_MS = QUOTA_WINDOW_NUM * QUOTA_WINDOW_SIZE_MS
+const QUOTA_SENSOR_EXPIRY_MS = 60 * 60 * 1000
+const DEFAULT_NUM_IO_THREADS = 8
+const DEFAULT_QUEUED_MAX_REQUESTS = 500
+const DEFAULT_SOCKET_REQUEST_MAX_BYTES = 100 * 1024 * 1024
+const DEFAULT_LOG_SEGMENT_BYTES = 1024 * 1024 * 1024
+const DEFAULT_LOG_ROLL_MS = 7 * 24 * 60 * 60 * 1000
+const DEFAULT_LOG_RETENTION_MS = 7 * 24 * 60 * 60 * 1000
+const DEFAULT_LOG_RETENTION_CHECK_INTERVAL_MS = 5 * 60 * 1000
+const DEFAULT_REMOTE_LOG_MANAGER_TASK_INTERVAL_MS = 30 * 1000
+const DEFAULT_LOG_CLEANER_DELETE_RETENTION_MS = 24 * 60 * 60 * 1000
+const DEFAULT_LOG_CLEANER_MIN_CLEANABLE_RATIO = 0.5
+const DEFAULT_LOG_CLEANER_BACKOFF_MS = 15 * 1000
+const DEFAULT_LOG_FLUSH_SCHEDULER_INTERVAL_MS = 1000
+const DEFAULT_REPLICA_LAG_TIME_MAX_MS = 30 * 1000
+const DEFAULT_REPLICA_FETCH_WAIT_MAX_MS = 500
+const DEFAULT_REPLICA_FETCH_MAX_BYTES = 1024 * 1024
+const DEFAULT_REPLICA_FETCH_BACKOFF_MS = 1000
+const DEFAULT_MAX_INCREMENTAL_FETCH_SESSION_CACHE_SLOTS = 1000
+const REPLICA_SOCKET_TIMEOUT_MS = 30 * 1000
+const DEFAULT_REPLICA_HIGH_WATERMARK_CHECKPOINT_INTERVAL_MS = 5000
+const DEFAULT_CONTROLLER_QUORUM_ELECTION_TIMEOUT_MS = 1000
+const DEFAULT_CONTROLLER_QUORUM_FETCH_TIMEOUT_MS = 2000
+const DEFAULT_CONTROLLER_QUORUM_ELECTION_BACKOFF_MAX_MS = 1000
+const DEFAULT_CONTROLLER_QUORUM_REQUEST_TIMEOUT_MS = 2000
+const DEFAULT_BROKER_HEARTBEAT_INTERVAL_MS = 2000
+const DEFAULT_BROKER_SESSION_TIMEOUT_MS = 9000
+const DEFAULT_LEADER_IMBALANCE_CHECK_INTERVAL_SECONDS = 300
+const DEFAULT_LEADER_IMBALANCE_PER_BROKER_PERCENTAGE = 10
+const METRICS_REPORT_INTERVAL_MS = 60 * 1000
+const LOG_FLUSH_OFFSET_CHECKPOINT_INTERVAL_MS = 60 * 1000
+const SHUTDOWN_DRAIN_TIMEOUT_MS = 30 * 1000
+const DEFAULT_LOG_DIR = "/tmp/kraft-combined-logs/"
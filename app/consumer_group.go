@@ -0,0 +1,403 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+	"github.com/google/uuid"
+)
+
+const (
+	CONSUMER_GROUP_STATE_EMPTY       = "Empty"
+	CONSUMER_GROUP_STATE_ASSIGNING   = "Assigning"
+	CONSUMER_GROUP_STATE_RECONCILING = "Reconciling"
+	CONSUMER_GROUP_STATE_STABLE      = "Stable"
+
+	MEMBER_STATE_STABLE                = "Stable"
+	MEMBER_STATE_UNREVOKED_PARTITIONS  = "UnrevokedPartitions"
+	MEMBER_STATE_UNRELEASED_PARTITIONS = "UnreleasedPartitions"
+
+	// Member epochs with a special meaning in ConsumerGroupHeartbeat
+	MEMBER_EPOCH_JOIN         = 0
+	MEMBER_EPOCH_LEAVE        = -1
+	MEMBER_EPOCH_STATIC_LEAVE = -2
+)
+
+type ConsumerGroupMember struct {
+	MemberId             string
+	InstanceId           string
+	RackId               string
+	ClientId             string
+	ClientHost           string
+	MemberEpoch          int32
+	PreviousMemberEpoch  int32
+	State                string
+	RebalanceTimeoutMs   int32
+	SubscribedTopicNames []string
+	ServerAssignor       string
+
+	// Partitions the member owns, and the ones it has been asked to give up
+	AssignedPartitions          TopicPartitions
+	PartitionsPendingRevocation TopicPartitions
+
+	lastHeartbeatMs      int64
+	revocationDeadlineMs int64
+}
+
+type ConsumerGroup struct {
+	GroupId          string
+	GroupEpoch       int32
+	AssignmentEpoch  int32
+	Members          map[string]*ConsumerGroupMember
+	TargetAssignment map[string]TopicPartitions
+}
+
+var consumerGroups = make(map[string]*ConsumerGroup)
+var consumerGroupsMu sync.Mutex
+
+// getOrCreateConsumerGroup returns the group, callers hold consumerGroupsMu.
+func getOrCreateConsumerGroup(groupId string) *ConsumerGroup {
+	group, ok := consumerGroups[groupId]
+	if !ok {
+		group = &ConsumerGroup{
+			GroupId:          groupId,
+			Members:          make(map[string]*ConsumerGroupMember),
+			TargetAssignment: make(map[string]TopicPartitions),
+		}
+		consumerGroups[groupId] = group
+	}
+	return group
+}
+
+func (g *ConsumerGroup) state() string {
+	if len(g.Members) == 0 {
+		return CONSUMER_GROUP_STATE_EMPTY
+	}
+	if g.GroupEpoch > g.AssignmentEpoch {
+		return CONSUMER_GROUP_STATE_ASSIGNING
+	}
+	for _, member := range g.Members {
+		if member.MemberEpoch != g.AssignmentEpoch || member.State != MEMBER_STATE_STABLE {
+			return CONSUMER_GROUP_STATE_RECONCILING
+		}
+	}
+	return CONSUMER_GROUP_STATE_STABLE
+}
+
+// assignorName is the assignor requested by the members, the default when none asked.
+func (g *ConsumerGroup) assignorName() string {
+	for _, memberId := range g.sortedMemberIds() {
+		if assignor := g.Members[memberId].ServerAssignor; assignor != "" {
+			return assignor
+		}
+	}
+	return DEFAULT_CONSUMER_GROUP_ASSIGNOR
+}
+
+func (g *ConsumerGroup) sortedMemberIds() []string {
+	memberIds := make([]string, 0, len(g.Members))
+	for memberId := range g.Members {
+		memberIds = append(memberIds, memberId)
+	}
+	slices.Sort(memberIds)
+	return memberIds
+}
+
+// subscribedTopicPartitions returns the partitions of every known topic the group subscribes to.
+func (g *ConsumerGroup) subscribedTopicPartitions() TopicPartitions {
+	result := make(TopicPartitions)
+	for _, member := range g.Members {
+		for _, topicName := range member.SubscribedTopicNames {
+			topicId, ok := topicNameToTopicId[topicName]
+			if !ok {
+				continue
+			}
+			for _, partition := range topicIdToPartitionIds[topicId] {
+				result.add(topicId, partition)
+			}
+		}
+	}
+	return result
+}
+
+// computeTargetAssignment runs the assignor for the current group epoch.
+func (g *ConsumerGroup) computeTargetAssignment() {
+	specs := make([]AssignmentMemberSpec, 0, len(g.Members))
+	for _, memberId := range g.sortedMemberIds() {
+		member := g.Members[memberId]
+		topicIds := make([]ktypes.UUID, 0)
+		for _, topicName := range member.SubscribedTopicNames {
+			if topicId, ok := topicNameToTopicId[topicName]; ok {
+				topicIds = append(topicIds, topicId)
+			}
+		}
+		currentTarget, ok := g.TargetAssignment[memberId]
+		if !ok {
+			currentTarget = make(TopicPartitions)
+		}
+		specs = append(specs, AssignmentMemberSpec{
+			MemberId:           memberId,
+			SubscribedTopicIds: topicIds,
+			CurrentTarget:      currentTarget,
+		})
+	}
+
+	assignor := consumerGroupAssignors[g.assignorName()]
+	g.TargetAssignment = assignor(specs, g.subscribedTopicPartitions())
+	g.AssignmentEpoch = g.GroupEpoch
+}
+
+// ownedByOtherMember reports whether another member still holds the partition,
+// either assigned or waiting to be revoked.
+func (g *ConsumerGroup) ownedByOtherMember(memberId string, topicId ktypes.UUID, partition int32) bool {
+	for otherId, other := range g.Members {
+		if otherId == memberId {
+			continue
+		}
+		if other.AssignedPartitions.contains(topicId, partition) || other.PartitionsPendingRevocation.contains(topicId, partition) {
+			return true
+		}
+	}
+	return false
+}
+
+// reconcile moves the member one step closer to its target assignment and
+// reports whether its assignment changed.
+//
+// Partitions that are no longer targeted must be revoked by the member
+// before it may advance to the assignment epoch, and newly targeted
+// partitions are only handed out once their previous owner released them.
+func (g *ConsumerGroup) reconcile(member *ConsumerGroupMember, ownedPartitions TopicPartitions, nowMs int64) bool {
+	if member.PartitionsPendingRevocation.count() > 0 {
+		if ownedPartitions == nil || ownedPartitions.intersect(member.PartitionsPendingRevocation).count() > 0 {
+			// Still waiting for the member to acknowledge the revocation
+			return false
+		}
+		member.PartitionsPendingRevocation = make(TopicPartitions)
+		member.revocationDeadlineMs = 0
+	}
+
+	target, ok := g.TargetAssignment[member.MemberId]
+	if !ok {
+		target = make(TopicPartitions)
+	}
+
+	revoked := member.AssignedPartitions.minus(target)
+	if revoked.count() > 0 {
+		member.AssignedPartitions = member.AssignedPartitions.intersect(target)
+		member.PartitionsPendingRevocation = revoked
+		member.State = MEMBER_STATE_UNREVOKED_PARTITIONS
+		member.revocationDeadlineMs = nowMs + int64(member.RebalanceTimeoutMs)
+		return true
+	}
+
+	changed := false
+	unreleased := false
+	for topicId, partitions := range target.minus(member.AssignedPartitions) {
+		for _, partition := range partitions {
+			if g.ownedByOtherMember(member.MemberId, topicId, partition) {
+				unreleased = true
+				continue
+			}
+			member.AssignedPartitions.add(topicId, partition)
+			changed = true
+		}
+	}
+
+	if member.MemberEpoch != g.AssignmentEpoch {
+		member.PreviousMemberEpoch = member.MemberEpoch
+		member.MemberEpoch = g.AssignmentEpoch
+		changed = true
+	}
+	if unreleased {
+		member.State = MEMBER_STATE_UNRELEASED_PARTITIONS
+	} else {
+		member.State = MEMBER_STATE_STABLE
+	}
+	return changed
+}
+
+// removeMember drops the member and triggers a new assignment for the others.
+func (g *ConsumerGroup) removeMember(memberId string) {
+	if _, ok := g.Members[memberId]; !ok {
+		return
+	}
+	delete(g.Members, memberId)
+	delete(g.TargetAssignment, memberId)
+	g.GroupEpoch++
+	g.computeTargetAssignment()
+}
+
+// ConsumerGroupHeartbeat is the decoded heartbeat the group reacts to
+type ConsumerGroupHeartbeat struct {
+	GroupId              string
+	MemberId             string
+	MemberEpoch          int32
+	InstanceId           string
+	RackId               *string
+	RebalanceTimeoutMs   int32
+	SubscribedTopicNames []string // nil when unchanged
+	ServerAssignor       *string
+	OwnedPartitions      TopicPartitions // nil when not reported
+	ClientId             string
+	ClientHost           string
+}
+
+type ConsumerGroupHeartbeatResult struct {
+	ErrorCode   ERROR_CODE
+	MemberId    string
+	MemberEpoch int32
+	Assignment  TopicPartitions // nil when unchanged
+}
+
+// consumerGroupHeartbeat applies a heartbeat to the group and returns what to send back to the member.
+func consumerGroupHeartbeat(heartbeat *ConsumerGroupHeartbeat) ConsumerGroupHeartbeatResult {
+	consumerGroupsMu.Lock()
+	defer consumerGroupsMu.Unlock()
+
+	nowMs := time.Now().UnixMilli()
+	group := getOrCreateConsumerGroup(heartbeat.GroupId)
+
+	if heartbeat.MemberEpoch == MEMBER_EPOCH_LEAVE || heartbeat.MemberEpoch == MEMBER_EPOCH_STATIC_LEAVE {
+		if _, ok := group.Members[heartbeat.MemberId]; !ok {
+			return ConsumerGroupHeartbeatResult{ErrorCode: ERROR_CODE_UNKNOWN_MEMBER_ID, MemberId: heartbeat.MemberId}
+		}
+		group.removeMember(heartbeat.MemberId)
+		return ConsumerGroupHeartbeatResult{MemberId: heartbeat.MemberId, MemberEpoch: heartbeat.MemberEpoch}
+	}
+
+	var member *ConsumerGroupMember
+	if heartbeat.MemberEpoch == MEMBER_EPOCH_JOIN {
+		memberId := heartbeat.MemberId
+		if memberId == "" {
+			memberId = uuid.NewString()
+		}
+		member = group.Members[memberId]
+		if member == nil {
+			member = &ConsumerGroupMember{
+				MemberId:                    memberId,
+				AssignedPartitions:          make(TopicPartitions),
+				PartitionsPendingRevocation: make(TopicPartitions),
+				State:                       MEMBER_STATE_STABLE,
+			}
+			// The group epoch is bumped below once its subscription is recorded
+			group.Members[memberId] = member
+		} else {
+			// A member rejoining with epoch 0 lost its assignment
+			member.AssignedPartitions = make(TopicPartitions)
+			member.PartitionsPendingRevocation = make(TopicPartitions)
+			member.MemberEpoch = 0
+		}
+	} else {
+		member = group.Members[heartbeat.MemberId]
+		if member == nil {
+			return ConsumerGroupHeartbeatResult{ErrorCode: ERROR_CODE_UNKNOWN_MEMBER_ID, MemberId: heartbeat.MemberId}
+		}
+		if heartbeat.MemberEpoch != member.MemberEpoch {
+			// A member that missed the last response may retry with its previous epoch
+			retried := heartbeat.MemberEpoch == member.PreviousMemberEpoch &&
+				heartbeat.OwnedPartitions != nil && heartbeat.OwnedPartitions.equals(member.AssignedPartitions)
+			if !retried {
+				return ConsumerGroupHeartbeatResult{ErrorCode: ERROR_CODE_FENCED_MEMBER_EPOCH, MemberId: heartbeat.MemberId}
+			}
+		}
+	}
+
+	member.ClientId = heartbeat.ClientId
+	member.ClientHost = heartbeat.ClientHost
+	member.InstanceId = heartbeat.InstanceId
+	member.lastHeartbeatMs = nowMs
+	if heartbeat.RebalanceTimeoutMs > 0 {
+		member.RebalanceTimeoutMs = heartbeat.RebalanceTimeoutMs
+	}
+	if heartbeat.RackId != nil {
+		member.RackId = *heartbeat.RackId
+	}
+	if heartbeat.ServerAssignor != nil && *heartbeat.ServerAssignor != member.ServerAssignor {
+		member.ServerAssignor = *heartbeat.ServerAssignor
+		group.GroupEpoch++
+	}
+	if heartbeat.SubscribedTopicNames != nil && !slices.Equal(heartbeat.SubscribedTopicNames, member.SubscribedTopicNames) {
+		member.SubscribedTopicNames = slices.Sorted(slices.Values(heartbeat.SubscribedTopicNames))
+		group.GroupEpoch++
+	}
+
+	if group.GroupEpoch > group.AssignmentEpoch {
+		group.computeTargetAssignment()
+	}
+
+	changed := group.reconcile(member, heartbeat.OwnedPartitions, nowMs)
+
+	result := ConsumerGroupHeartbeatResult{
+		MemberId:    member.MemberId,
+		MemberEpoch: member.MemberEpoch,
+	}
+	joined := heartbeat.MemberEpoch == MEMBER_EPOCH_JOIN
+	outOfSync := heartbeat.OwnedPartitions != nil && !heartbeat.OwnedPartitions.equals(member.AssignedPartitions)
+	if changed || joined || outOfSync {
+		result.Assignment = member.AssignedPartitions.copy()
+	}
+	return result
+}
+
+// validateConsumerGroupOffsetCommit checks the member epoch an offset commit was sent with.
+func validateConsumerGroupOffsetCommit(groupId string, memberId string, memberEpoch int32) ERROR_CODE {
+	consumerGroupsMu.Lock()
+	defer consumerGroupsMu.Unlock()
+
+	group, ok := consumerGroups[groupId]
+	if !ok || len(group.Members) == 0 {
+		if memberEpoch < 0 {
+			// Standalone commit outside of any active group
+			return ERROR_CODE_NONE
+		}
+		return ERROR_CODE_ILLEGAL_GENERATION
+	}
+
+	member, ok := group.Members[memberId]
+	if !ok {
+		return ERROR_CODE_UNKNOWN_MEMBER_ID
+	}
+	if member.MemberEpoch != memberEpoch {
+		return ERROR_CODE_STALE_MEMBER_EPOCH
+	}
+	return ERROR_CODE_NONE
+}
+
+// consumerGroupHasMembers reports whether the group is active, in which case its offsets never expire.
+func consumerGroupHasMembers(groupId string) bool {
+	consumerGroupsMu.Lock()
+	defer consumerGroupsMu.Unlock()
+
+	group, ok := consumerGroups[groupId]
+	return ok && len(group.Members) > 0
+}
+
+// expireConsumerGroupMembers removes members that stopped heartbeating or
+// did not revoke partitions within their rebalance timeout.
+func expireConsumerGroupMembers(nowMs int64) {
+	consumerGroupsMu.Lock()
+	defer consumerGroupsMu.Unlock()
+
+	for _, group := range consumerGroups {
+		for _, memberId := range group.sortedMemberIds() {
+			member := group.Members[memberId]
+			sessionExpired := member.lastHeartbeatMs+CONSUMER_GROUP_SESSION_TIMEOUT_MS <= nowMs
+			revocationExpired := member.revocationDeadlineMs > 0 && member.revocationDeadlineMs <= nowMs
+			if sessionExpired || revocationExpired {
+				fmt.Println("Removing member ", memberId, " from consumer group ", group.GroupId)
+				group.removeMember(memberId)
+			}
+		}
+	}
+}
+
+// startConsumerGroupSessionTask periodically expires consumer group members.
+func startConsumerGroupSessionTask() {
+	runPeriodicTask(CONSUMER_GROUP_SESSION_CHECK_INTERVAL_MS*time.Millisecond, func() {
+		expireConsumerGroupMembers(time.Now().UnixMilli())
+	})
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	UNIFORM_ASSIGNOR_NAME = "uniform"
+	RANGE_ASSIGNOR_NAME   = "range"
+
+	DEFAULT_CONSUMER_GROUP_ASSIGNOR = UNIFORM_ASSIGNOR_NAME
+)
+
+// TopicPartitions maps a topic id to partition indexes
+type TopicPartitions map[ktypes.UUID][]int32
+
+// AssignmentMemberSpec is what an assignor knows about a member
+type AssignmentMemberSpec struct {
+	MemberId           string
+	SubscribedTopicIds []ktypes.UUID
+	CurrentTarget      TopicPartitions
+}
+
+type consumerGroupAssignor func(members []AssignmentMemberSpec, topicPartitions TopicPartitions) map[string]TopicPartitions
+
+// Server side assignors by the name clients pass in ServerAssignor
+var consumerGroupAssignors = map[string]consumerGroupAssignor{
+	UNIFORM_ASSIGNOR_NAME: uniformAssignor,
+	RANGE_ASSIGNOR_NAME:   rangeAssignor,
+}
+
+func (tp TopicPartitions) contains(topicId ktypes.UUID, partition int32) bool {
+	return slices.Contains(tp[topicId], partition)
+}
+
+func (tp TopicPartitions) add(topicId ktypes.UUID, partition int32) {
+	if !tp.contains(topicId, partition) {
+		tp[topicId] = append(tp[topicId], partition)
+		slices.Sort(tp[topicId])
+	}
+}
+
+func (tp TopicPartitions) count() int {
+	count := 0
+	for _, partitions := range tp {
+		count += len(partitions)
+	}
+	return count
+}
+
+func (tp TopicPartitions) copy() TopicPartitions {
+	result := make(TopicPartitions)
+	for topicId, partitions := range tp {
+		result[topicId] = slices.Clone(partitions)
+	}
+	return result
+}
+
+// minus returns the partitions of tp that are not in other.
+func (tp TopicPartitions) minus(other TopicPartitions) TopicPartitions {
+	result := make(TopicPartitions)
+	for topicId, partitions := range tp {
+		for _, partition := range partitions {
+			if !other.contains(topicId, partition) {
+				result.add(topicId, partition)
+			}
+		}
+	}
+	return result
+}
+
+// intersect returns the partitions present in both tp and other.
+func (tp TopicPartitions) intersect(other TopicPartitions) TopicPartitions {
+	result := make(TopicPartitions)
+	for topicId, partitions := range tp {
+		for _, partition := range partitions {
+			if other.contains(topicId, partition) {
+				result.add(topicId, partition)
+			}
+		}
+	}
+	return result
+}
+
+func (tp TopicPartitions) equals(other TopicPartitions) bool {
+	return tp.count() == other.count() && tp.minus(other).count() == 0
+}
+
+// sortedTopicIds returns the topic ids in a stable order.
+func (tp TopicPartitions) sortedTopicIds() []ktypes.UUID {
+	topicIds := make([]ktypes.UUID, 0, len(tp))
+	for topicId := range tp {
+		topicIds = append(topicIds, topicId)
+	}
+	slices.SortFunc(topicIds, func(a, b ktypes.UUID) int {
+		return bytes.Compare(a[:], b[:])
+	})
+	return topicIds
+}
+
+func sortedMemberSpecs(members []AssignmentMemberSpec) []AssignmentMemberSpec {
+	sorted := slices.Clone(members)
+	slices.SortFunc(sorted, func(a, b AssignmentMemberSpec) int {
+		return strings.Compare(a.MemberId, b.MemberId)
+	})
+	return sorted
+}
+
+func emptyAssignment(members []AssignmentMemberSpec) map[string]TopicPartitions {
+	assignment := make(map[string]TopicPartitions)
+	for _, member := range members {
+		assignment[member.MemberId] = make(TopicPartitions)
+	}
+	return assignment
+}
+
+// rangeAssignor gives each member a contiguous range of every topic it
+// subscribes to, the first members taking one extra partition when the
+// partitions do not divide evenly.
+func rangeAssignor(members []AssignmentMemberSpec, topicPartitions TopicPartitions) map[string]TopicPartitions {
+	assignment := emptyAssignment(members)
+	sorted := sortedMemberSpecs(members)
+
+	for _, topicId := range topicPartitions.sortedTopicIds() {
+		subscribers := make([]string, 0)
+		for _, member := range sorted {
+			if slices.Contains(member.SubscribedTopicIds, topicId) {
+				subscribers = append(subscribers, member.MemberId)
+			}
+		}
+		if len(subscribers) == 0 {
+			continue
+		}
+
+		partitions := slices.Sorted(slices.Values(topicPartitions[topicId]))
+		perMember := len(partitions) / len(subscribers)
+		extra := len(partitions) % len(subscribers)
+		start := 0
+		for i, memberId := range subscribers {
+			size := perMember
+			if i < extra {
+				size++
+			}
+			for _, partition := range partitions[start : start+size] {
+				assignment[memberId].add(topicId, partition)
+			}
+			start += size
+		}
+	}
+
+	return assignment
+}
+
+// uniformAssignor spreads all subscribed partitions evenly over the members,
+// keeping partitions where they were previously targeted as long as that
+// does not unbalance the group: every member keeps up to n/m of them, and
+// only n%m members one more.
+func uniformAssignor(members []AssignmentMemberSpec, topicPartitions TopicPartitions) map[string]TopicPartitions {
+	assignment := emptyAssignment(members)
+	sorted := sortedMemberSpecs(members)
+	if len(sorted) == 0 {
+		return assignment
+	}
+
+	// Every partition of a topic at least one member subscribes to
+	type topicPartition struct {
+		topicId   ktypes.UUID
+		partition int32
+	}
+	all := make([]topicPartition, 0)
+	for _, topicId := range topicPartitions.sortedTopicIds() {
+		subscribed := slices.ContainsFunc(sorted, func(member AssignmentMemberSpec) bool {
+			return slices.Contains(member.SubscribedTopicIds, topicId)
+		})
+		if !subscribed {
+			continue
+		}
+		for _, partition := range slices.Sorted(slices.Values(topicPartitions[topicId])) {
+			all = append(all, topicPartition{topicId, partition})
+		}
+	}
+
+	// previousOwner returns the member still subscribed to a partition it
+	// was previously targeted, empty when there is none
+	previousOwner := func(tp topicPartition) string {
+		for _, member := range sorted {
+			if member.CurrentTarget.contains(tp.topicId, tp.partition) &&
+				slices.Contains(member.SubscribedTopicIds, tp.topicId) {
+				return member.MemberId
+			}
+		}
+		return ""
+	}
+
+	minQuota := len(all) / len(sorted)
+	extra := len(all) % len(sorted)
+
+	// Keep the previous target up to the minimum quota, then one more for
+	// as many members as the partitions do not divide evenly over
+	overQuota := make([]topicPartition, 0)
+	for _, tp := range all {
+		owner := previousOwner(tp)
+		if owner != "" && assignment[owner].count() < minQuota {
+			assignment[owner].add(tp.topicId, tp.partition)
+		} else {
+			overQuota = append(overQuota, tp)
+		}
+	}
+	unassigned := make([]topicPartition, 0)
+	for _, tp := range overQuota {
+		owner := previousOwner(tp)
+		if owner != "" && extra > 0 && assignment[owner].count() == minQuota {
+			assignment[owner].add(tp.topicId, tp.partition)
+			extra--
+		} else {
+			unassigned = append(unassigned, tp)
+		}
+	}
+
+	// Hand out the rest to the least loaded subscribed member
+	for _, tp := range unassigned {
+		var target string
+		for _, member := range sorted {
+			if !slices.Contains(member.SubscribedTopicIds, tp.topicId) {
+				continue
+			}
+			if target == "" || assignment[member.MemberId].count() < assignment[target].count() {
+				target = member.MemberId
+			}
+		}
+		if target != "" {
+			assignment[target].add(tp.topicId, tp.partition)
+		}
+	}
+
+	return assignment
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+var assignorTestTopic = ktypes.UUID{1}
+
+func assignorTestMember(memberId string, partitions ...int32) AssignmentMemberSpec {
+	target := make(TopicPartitions)
+	for _, partition := range partitions {
+		target.add(assignorTestTopic, partition)
+	}
+	return AssignmentMemberSpec{
+		MemberId:           memberId,
+		SubscribedTopicIds: []ktypes.UUID{assignorTestTopic},
+		CurrentTarget:      target,
+	}
+}
+
+// checkBalanced fails unless every partition is assigned exactly once and
+// member counts differ by at most one
+func checkBalanced(t *testing.T, assignment map[string]TopicPartitions, partitionCount int) {
+	t.Helper()
+	owners := make(map[int32]string)
+	minCount, maxCount := partitionCount, 0
+	for memberId, partitions := range assignment {
+		for _, partition := range partitions[assignorTestTopic] {
+			if owner, ok := owners[partition]; ok {
+				t.Fatalf("partition %d assigned to both %s and %s", partition, owner, memberId)
+			}
+			owners[partition] = memberId
+		}
+		minCount = min(minCount, partitions.count())
+		maxCount = max(maxCount, partitions.count())
+	}
+	if len(owners) != partitionCount {
+		t.Fatalf("assigned %d of %d partitions: %v", len(owners), partitionCount, assignment)
+	}
+	if maxCount-minCount > 1 {
+		t.Fatalf("unbalanced assignment: %v", assignment)
+	}
+}
+
+// checkSticky fails unless a member only holds partitions it previously had
+// or that nobody kept
+func checkSticky(t *testing.T, members []AssignmentMemberSpec, assignment map[string]TopicPartitions, kept int) {
+	t.Helper()
+	retained := 0
+	for _, member := range members {
+		retained += assignment[member.MemberId].intersect(member.CurrentTarget).count()
+	}
+	if retained < kept {
+		t.Fatalf("only %d partitions kept their owner, want %d: %v", retained, kept, assignment)
+	}
+}
+
+func TestUniformAssignorMemberJoins(t *testing.T) {
+	members := []AssignmentMemberSpec{
+		assignorTestMember("a", 0, 2),
+		assignorTestMember("b", 1, 3),
+		assignorTestMember("c"),
+	}
+	topicPartitions := TopicPartitions{assignorTestTopic: {0, 1, 2, 3}}
+
+	assignment := uniformAssignor(members, topicPartitions)
+
+	checkBalanced(t, assignment, 4)
+	if assignment["c"].count() == 0 {
+		t.Fatalf("joining member got nothing: %v", assignment)
+	}
+	checkSticky(t, members, assignment, 3)
+}
+
+func TestUniformAssignorMemberLeaves(t *testing.T) {
+	members := []AssignmentMemberSpec{
+		assignorTestMember("a", 0, 3),
+		assignorTestMember("b", 1, 4),
+	}
+	topicPartitions := TopicPartitions{assignorTestTopic: {0, 1, 2, 3, 4, 5}}
+
+	// c, which held 2 and 5, has left
+	assignment := uniformAssignor(members, topicPartitions)
+
+	checkBalanced(t, assignment, 6)
+	checkSticky(t, members, assignment, 4)
+}
+
+func TestUniformAssignorUnevenSplit(t *testing.T) {
+	members := []AssignmentMemberSpec{
+		assignorTestMember("a", 0, 1, 2, 3, 4),
+		assignorTestMember("b", 5, 6),
+		assignorTestMember("c"),
+	}
+	topicPartitions := TopicPartitions{assignorTestTopic: {0, 1, 2, 3, 4, 5, 6}}
+
+	assignment := uniformAssignor(members, topicPartitions)
+
+	checkBalanced(t, assignment, 7)
+	checkSticky(t, members, assignment, 5)
+}
+
+func TestUniformAssignorSkipsUnsubscribedTopics(t *testing.T) {
+	otherTopic := ktypes.UUID{2}
+	members := []AssignmentMemberSpec{assignorTestMember("a"), assignorTestMember("b")}
+	topicPartitions := TopicPartitions{assignorTestTopic: {0, 1}, otherTopic: {0}}
+
+	assignment := uniformAssignor(members, topicPartitions)
+
+	checkBalanced(t, assignment, 2)
+	for memberId, partitions := range assignment {
+		if len(partitions[otherTopic]) > 0 {
+			t.Fatalf("%s was assigned an unsubscribed topic: %v", memberId, assignment)
+		}
+	}
+}
+
+func TestRangeAssignor(t *testing.T) {
+	members := []AssignmentMemberSpec{
+		assignorTestMember("b"),
+		assignorTestMember("a"),
+	}
+	topicPartitions := TopicPartitions{assignorTestTopic: {0, 1, 2, 3, 4}}
+
+	assignment := rangeAssignor(members, topicPartitions)
+
+	want := map[string][]int32{"a": {0, 1, 2}, "b": {3, 4}}
+	for memberId, partitions := range want {
+		if !assignment[memberId].equals(TopicPartitions{assignorTestTopic: partitions}) {
+			t.Fatalf("%s got %v, want %v", memberId, assignment[memberId], partitions)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+var groupTestTopic = ktypes.UUID{0xd}
+
+// setTestConsumerGroups empties the consumer groups and adds group-topic with
+// 4 partitions
+func setTestConsumerGroups(t *testing.T) {
+	t.Helper()
+	consumerGroupsMu.Lock()
+	previousGroups := consumerGroups
+	consumerGroups = make(map[string]*ConsumerGroup)
+	consumerGroupsMu.Unlock()
+	metadataMu.Lock()
+	topicNameToTopicId["group-topic"] = groupTestTopic
+	topicIdToPartitionIds[groupTestTopic] = []int32{0, 1, 2, 3}
+	metadataMu.Unlock()
+	t.Cleanup(func() {
+		metadataMu.Lock()
+		delete(topicNameToTopicId, "group-topic")
+		delete(topicIdToPartitionIds, groupTestTopic)
+		metadataMu.Unlock()
+		consumerGroupsMu.Lock()
+		defer consumerGroupsMu.Unlock()
+		consumerGroups = previousGroups
+	})
+}
+
+// groupTestHeartbeat sends a heartbeat of a member of test-group subscribed
+// to group-topic and owning partitions, nil when not reported
+func groupTestHeartbeat(memberId string, epoch int32, owned TopicPartitions) ConsumerGroupHeartbeatResult {
+	return consumerGroupHeartbeat(&ConsumerGroupHeartbeat{
+		GroupId:              "test-group",
+		MemberId:             memberId,
+		MemberEpoch:          epoch,
+		RebalanceTimeoutMs:   60000,
+		SubscribedTopicNames: []string{"group-topic"},
+		OwnedPartitions:      owned,
+	})
+}
+
+// groupTestTarget returns the partitions the assignor targets for a member
+func groupTestTarget(memberId string) TopicPartitions {
+	consumerGroupsMu.Lock()
+	defer consumerGroupsMu.Unlock()
+	return consumerGroups["test-group"].TargetAssignment[memberId].copy()
+}
+
+// checkGroupTestResult fails unless a heartbeat succeeded with the epoch and
+// assignment, nil when no assignment is expected
+func checkGroupTestResult(t *testing.T, step string, got ConsumerGroupHeartbeatResult, epoch int32, assignment TopicPartitions) {
+	t.Helper()
+	if got.ErrorCode != ERROR_CODE_NONE || got.MemberEpoch != epoch {
+		t.Fatalf("%s: got error %d at epoch %d, want epoch %d", step, got.ErrorCode, got.MemberEpoch, epoch)
+	}
+	if (got.Assignment == nil) != (assignment == nil) || (assignment != nil && !got.Assignment.equals(assignment)) {
+		t.Fatalf("%s: got assignment %v, want %v", step, got.Assignment, assignment)
+	}
+}
+
+func TestConsumerGroupHeartbeat(t *testing.T) {
+	setTestConsumerGroups(t)
+	all := TopicPartitions{groupTestTopic: {0, 1, 2, 3}}
+
+	// A new member gets an id, the next epoch and every partition
+	joined := groupTestHeartbeat("", MEMBER_EPOCH_JOIN, make(TopicPartitions))
+	a := joined.MemberId
+	if a == "" {
+		t.Fatal("got no member id for a new member")
+	}
+	checkGroupTestResult(t, "first member joins", joined, 1, all)
+	checkGroupTestResult(t, "first member stable", groupTestHeartbeat(a, 1, all), 1, nil)
+
+	// A second member waits for the partitions the first one must revoke
+	checkGroupTestResult(t, "second member joins", groupTestHeartbeat("b", MEMBER_EPOCH_JOIN, make(TopicPartitions)), 2, make(TopicPartitions))
+	targetA, targetB := groupTestTarget(a), groupTestTarget("b")
+	if targetA.count() != 2 || targetB.count() != 2 {
+		t.Fatalf("got targets %v and %v, want 2 partitions each", targetA, targetB)
+	}
+	checkGroupTestResult(t, "first member revokes", groupTestHeartbeat(a, 1, all), 1, targetA)
+	checkGroupTestResult(t, "second member before the revocation", groupTestHeartbeat("b", 2, make(TopicPartitions)), 2, nil)
+	checkGroupTestResult(t, "first member still revoking", groupTestHeartbeat(a, 1, all), 1, targetA)
+	checkGroupTestResult(t, "first member revoked", groupTestHeartbeat(a, 1, targetA), 2, targetA)
+	checkGroupTestResult(t, "second member after the revocation", groupTestHeartbeat("b", 2, make(TopicPartitions)), 2, targetB)
+
+	// A member that missed the response retries with its previous epoch and
+	// the partitions it was given, anything else is fenced
+	checkGroupTestResult(t, "retry at the previous epoch", groupTestHeartbeat(a, 1, targetA), 2, nil)
+	fencedTests := []struct {
+		name  string
+		epoch int32
+		owned TopicPartitions
+	}{
+		{"previous epoch with other partitions", 1, all},
+		{"previous epoch without partitions", 1, nil},
+		{"future epoch", 3, targetA},
+	}
+	for _, test := range fencedTests {
+		if got := groupTestHeartbeat(a, test.epoch, test.owned); got.ErrorCode != ERROR_CODE_FENCED_MEMBER_EPOCH {
+			t.Errorf("%s: got error %d, want %d", test.name, got.ErrorCode, ERROR_CODE_FENCED_MEMBER_EPOCH)
+		}
+	}
+	if got := groupTestHeartbeat("c", 2, nil); got.ErrorCode != ERROR_CODE_UNKNOWN_MEMBER_ID {
+		t.Errorf("unknown member: got error %d, want %d", got.ErrorCode, ERROR_CODE_UNKNOWN_MEMBER_ID)
+	}
+
+	// Rejoining at epoch 0 hands the member its target again
+	checkGroupTestResult(t, "first member rejoins", groupTestHeartbeat(a, MEMBER_EPOCH_JOIN, make(TopicPartitions)), 2, targetA)
+
+	// A member leaving gives its partitions to the others at the next epoch
+	left := groupTestHeartbeat("b", MEMBER_EPOCH_LEAVE, nil)
+	if left.ErrorCode != ERROR_CODE_NONE || left.MemberEpoch != MEMBER_EPOCH_LEAVE {
+		t.Fatalf("got error %d at epoch %d leaving, want epoch %d", left.ErrorCode, left.MemberEpoch, MEMBER_EPOCH_LEAVE)
+	}
+	checkGroupTestResult(t, "remaining member", groupTestHeartbeat(a, 2, targetA), 3, all)
+	if got := groupTestHeartbeat("b", MEMBER_EPOCH_LEAVE, nil); got.ErrorCode != ERROR_CODE_UNKNOWN_MEMBER_ID {
+		t.Errorf("leaving twice: got error %d, want %d", got.ErrorCode, ERROR_CODE_UNKNOWN_MEMBER_ID)
+	}
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	OFFSET_COMMIT_KEY_VERSION   = 1
+	OFFSET_COMMIT_VALUE_VERSION = 3
+)
+
+// Key of an offset commit record in __consumer_offsets
+type OffsetCommitRecordKey struct {
+	Version   ktypes.Int16  `order:"1"`
+	Group     ktypes.String `order:"2"`
+	Topic     ktypes.String `order:"3"`
+	Partition ktypes.Int32  `order:"4"`
+}
+
+// Value of an offset commit record in __consumer_offsets, empty for tombstones
+type OffsetCommitRecordValue struct {
+	Version         ktypes.Int16  `order:"1"`
+	Offset          ktypes.Int64  `order:"2"`
+	LeaderEpoch     ktypes.Int32  `order:"3"`
+	Metadata        ktypes.String `order:"4"`
+	CommitTimestamp ktypes.Int64  `order:"5"`
+}
+
+type CommittedOffset struct {
+	Offset          int64
+	LeaderEpoch     int32
+	Metadata        string
+	CommitTimestamp int64
+}
+
+type TopicPartitionOffset struct {
+	Topic     string
+	Partition int32
+	CommittedOffset
+}
+
+// group id -> topic name -> partition -> committed offset
+var groupToCommittedOffsets = make(map[string]map[string]map[int32]CommittedOffset)
+var committedOffsetsMu sync.RWMutex
+
+// producer id -> group id -> offsets committed in the producer's ongoing
+// transaction, only visible once the transaction commits
+var pendingTxnOffsets = make(map[int64]map[string][]TopicPartitionOffset)
+
+// javaStringHashCode matches Java's String.hashCode so groups land on the same
+// __consumer_offsets partition as they would on a real broker. Java hashes
+// UTF-16 code units, so characters outside the BMP count as surrogate pairs.
+func javaStringHashCode(s string) int32 {
+	var hash int32
+	for _, c := range utf16.Encode([]rune(s)) {
+		hash = 31*hash + int32(c)
+	}
+	return hash
+}
+
+// keyPartitionFor picks the partition of an internal topic that owns the key.
+func keyPartitionFor(key string, partitions int32) int32 {
+	hash := javaStringHashCode(key)
+	if hash == math.MinInt32 {
+		hash = 0
+	} else if hash < 0 {
+		hash = -hash
+	}
+	return hash % partitions
+}
+
+func consumerOffsetsPartitionFor(groupId string) int32 {
+	return keyPartitionFor(groupId, CONSUMER_OFFSETS_PARTITIONS)
+}
+
+func encodeOffsetCommitRecord(groupId string, topic string, partition int32, committed *CommittedOffset) (Record, error) {
+	encoder := ktypes.NewKEncoder()
+	key, err := encoder.Encode(&OffsetCommitRecordKey{
+		Version:   ktypes.Int16(OFFSET_COMMIT_KEY_VERSION),
+		Group:     ktypes.String(groupId),
+		Topic:     ktypes.String(topic),
+		Partition: ktypes.Int32(partition),
+	})
+	if err != nil {
+		return Record{}, fmt.Errorf("failed to encode offset commit key: %w", err)
+	}
+
+	record := Record{Key: key}
+	if committed == nil {
+		// Tombstone
+		return record, nil
+	}
+
+	value, err := ktypes.NewKEncoder().Encode(&OffsetCommitRecordValue{
+		Version:         ktypes.Int16(OFFSET_COMMIT_VALUE_VERSION),
+		Offset:          ktypes.Int64(committed.Offset),
+		LeaderEpoch:     ktypes.Int32(committed.LeaderEpoch),
+		Metadata:        ktypes.String(committed.Metadata),
+		CommitTimestamp: ktypes.Int64(committed.CommitTimestamp),
+	})
+	if err != nil {
+		return Record{}, fmt.Errorf("failed to encode offset commit value: %w", err)
+	}
+	record.Value = value
+	return record, nil
+}
+
+// commitOffsets persists the offsets to the group's __consumer_offsets
+// partition and only then makes them visible to OffsetFetch.
+func commitOffsets(groupId string, offsets []TopicPartitionOffset) error {
+	if len(offsets) == 0 {
+		return nil
+	}
+
+	records := make([]Record, len(offsets))
+	for i := range offsets {
+		record, err := encodeOffsetCommitRecord(groupId, offsets[i].Topic, offsets[i].Partition, &offsets[i].CommittedOffset)
+		if err != nil {
+			return err
+		}
+		records[i] = record
+	}
+
+	log, err := getPartitionLog(CONSUMER_OFFSETS_TOPIC, consumerOffsetsPartitionFor(groupId))
+	if err != nil {
+		return err
+	}
+
+	committedOffsetsMu.Lock()
+	defer committedOffsetsMu.Unlock()
+
+	if _, err := log.Append(records); err != nil {
+		return err
+	}
+	if err := log.Flush(); err != nil {
+		return err
+	}
+	for _, offset := range offsets {
+		storeCommittedOffset(groupId, offset.Topic, offset.Partition, offset.CommittedOffset)
+	}
+
+	return nil
+}
+
+// commitTxnOffsets persists offsets committed as part of the producer's
+// transaction. They are held back until the transaction's COMMIT marker.
+func commitTxnOffsets(groupId string, producerId int64, producerEpoch int16, offsets []TopicPartitionOffset) error {
+	if len(offsets) == 0 {
+		return nil
+	}
+
+	records := make([]Record, len(offsets))
+	for i := range offsets {
+		record, err := encodeOffsetCommitRecord(groupId, offsets[i].Topic, offsets[i].Partition, &offsets[i].CommittedOffset)
+		if err != nil {
+			return err
+		}
+		records[i] = record
+	}
+
+	log, err := getPartitionLog(CONSUMER_OFFSETS_TOPIC, consumerOffsetsPartitionFor(groupId))
+	if err != nil {
+		return err
+	}
+
+	committedOffsetsMu.Lock()
+	defer committedOffsetsMu.Unlock()
+
+	if _, err := log.AppendTransactional(producerId, producerEpoch, records); err != nil {
+		return err
+	}
+	if err := log.Flush(); err != nil {
+		return err
+	}
+	storePendingTxnOffsets(producerId, groupId, offsets)
+
+	return nil
+}
+
+// storePendingTxnOffsets holds offsets back until their transaction ends, callers hold committedOffsetsMu.
+func storePendingTxnOffsets(producerId int64, groupId string, offsets []TopicPartitionOffset) {
+	groups, ok := pendingTxnOffsets[producerId]
+	if !ok {
+		groups = make(map[string][]TopicPartitionOffset)
+		pendingTxnOffsets[producerId] = groups
+	}
+	groups[groupId] = append(groups[groupId], offsets...)
+}
+
+// applyTxnOffsetsMarker publishes or drops the producer's pending offsets of
+// groups stored in the __consumer_offsets partition, callers hold committedOffsetsMu.
+func applyTxnOffsetsMarker(producerId int64, partition int32, commit bool) {
+	groups := pendingTxnOffsets[producerId]
+	for groupId, offsets := range groups {
+		if consumerOffsetsPartitionFor(groupId) != partition {
+			continue
+		}
+		if commit {
+			for _, offset := range offsets {
+				storeCommittedOffset(groupId, offset.Topic, offset.Partition, offset.CommittedOffset)
+			}
+		}
+		delete(groups, groupId)
+	}
+	if len(groups) == 0 {
+		delete(pendingTxnOffsets, producerId)
+	}
+}
+
+// completeTxnOffsetCommit is called once the transaction marker was written
+// to the __consumer_offsets partition.
+func completeTxnOffsetCommit(producerId int64, partition int32, commit bool) {
+	committedOffsetsMu.Lock()
+	defer committedOffsetsMu.Unlock()
+
+	applyTxnOffsetsMarker(producerId, partition, commit)
+}
+
+// hasPendingTxnOffset reports whether an open transaction committed an offset for the partition.
+func hasPendingTxnOffset(groupId string, topic string, partition int32) bool {
+	committedOffsetsMu.RLock()
+	defer committedOffsetsMu.RUnlock()
+
+	for _, groups := range pendingTxnOffsets {
+		for _, offset := range groups[groupId] {
+			if offset.Topic == topic && offset.Partition == partition {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// storeCommittedOffset updates the in-memory view, callers hold committedOffsetsMu.
+func storeCommittedOffset(groupId string, topic string, partition int32, committed CommittedOffset) {
+	topics, ok := groupToCommittedOffsets[groupId]
+	if !ok {
+		topics = make(map[string]map[int32]CommittedOffset)
+		groupToCommittedOffsets[groupId] = topics
+	}
+	partitions, ok := topics[topic]
+	if !ok {
+		partitions = make(map[int32]CommittedOffset)
+		topics[topic] = partitions
+	}
+	partitions[partition] = committed
+}
+
+// removeCommittedOffset drops an offset from the in-memory view, callers hold committedOffsetsMu.
+func removeCommittedOffset(groupId string, topic string, partition int32) {
+	topics, ok := groupToCommittedOffsets[groupId]
+	if !ok {
+		return
+	}
+	delete(topics[topic], partition)
+	if len(topics[topic]) == 0 {
+		delete(topics, topic)
+	}
+	if len(topics) == 0 {
+		delete(groupToCommittedOffsets, groupId)
+	}
+}
+
+// getCommittedOffset returns the committed offset of one partition for the group.
+func getCommittedOffset(groupId string, topic string, partition int32) (CommittedOffset, bool) {
+	committedOffsetsMu.RLock()
+	defer committedOffsetsMu.RUnlock()
+
+	committed, ok := groupToCommittedOffsets[groupId][topic][partition]
+	return committed, ok
+}
+
+// getGroupCommittedOffsets returns every committed offset of the group.
+func getGroupCommittedOffsets(groupId string) []TopicPartitionOffset {
+	committedOffsetsMu.RLock()
+	defer committedOffsetsMu.RUnlock()
+
+	offsets := make([]TopicPartitionOffset, 0)
+	for topic, partitions := range groupToCommittedOffsets[groupId] {
+		for partition, committed := range partitions {
+			offsets = append(offsets, TopicPartitionOffset{
+				Topic:           topic,
+				Partition:       partition,
+				CommittedOffset: committed,
+			})
+		}
+	}
+	return offsets
+}
+
+// loadConsumerOffsets replays every __consumer_offsets partition on disk so
+// committed offsets survive a restart.
+func loadConsumerOffsets() error {
+	partitions, err := partitionFolders(CONSUMER_OFFSETS_TOPIC)
+	if err != nil {
+		return err
+	}
+
+	committedOffsetsMu.Lock()
+	defer committedOffsetsMu.Unlock()
+
+	now := time.Now().UnixMilli()
+	for _, partition := range partitions {
+		log, err := getPartitionLog(CONSUMER_OFFSETS_TOPIC, int32(partition))
+		if err != nil {
+			return err
+		}
+		batches, err := log.ReadBatches(0)
+		if err != nil {
+			return err
+		}
+		for _, batch := range batches {
+			if batch.isControl() {
+				markerType, err := controlRecordType(&batch.Records[0])
+				if err != nil {
+					return err
+				}
+				applyTxnOffsetsMarker(int64(batch.ProducerId), int32(partition), markerType == CONTROL_RECORD_COMMIT)
+				continue
+			}
+			for _, record := range batch.Records {
+				if err := replayOffsetCommitRecord(batch, record); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// Offsets that expired while the broker was down are not loaded
+	for _, expired := range collectExpiredOffsets(now) {
+		removeCommittedOffset(expired.groupId, expired.topic, expired.partition)
+	}
+
+	return nil
+}
+
+func replayOffsetCommitRecord(batch *RecordBatch, record Record) error {
+	var key OffsetCommitRecordKey
+	if err := ktypes.NewKDecoder(record.Key).Decode(&key); err != nil {
+		return fmt.Errorf("failed to decode offset commit key: %w", err)
+	}
+	if key.Version != OFFSET_COMMIT_KEY_VERSION {
+		// Not an offset commit record
+		return nil
+	}
+
+	if len(record.Value) == 0 {
+		removeCommittedOffset(string(key.Group), string(key.Topic), int32(key.Partition))
+		return nil
+	}
+
+	var value OffsetCommitRecordValue
+	if err := ktypes.NewKDecoder(record.Value).Decode(&value); err != nil {
+		return fmt.Errorf("failed to decode offset commit value: %w", err)
+	}
+	committed := CommittedOffset{
+		Offset:          int64(value.Offset),
+		LeaderEpoch:     int32(value.LeaderEpoch),
+		Metadata:        string(value.Metadata),
+		CommitTimestamp: int64(value.CommitTimestamp),
+	}
+	if batch.isTransactional() {
+		storePendingTxnOffsets(int64(batch.ProducerId), string(key.Group), []TopicPartitionOffset{{
+			Topic:           string(key.Topic),
+			Partition:       int32(key.Partition),
+			CommittedOffset: committed,
+		}})
+		return nil
+	}
+	storeCommittedOffset(string(key.Group), string(key.Topic), int32(key.Partition), committed)
+	return nil
+}
+
+type expiredOffset struct {
+	groupId   string
+	topic     string
+	partition int32
+}
+
+// collectExpiredOffsets lists offsets older than the retention period, callers hold committedOffsetsMu.
+// Offsets of groups with active members are kept regardless of age.
+func collectExpiredOffsets(now int64) []expiredOffset {
+	expired := make([]expiredOffset, 0)
+	for groupId, topics := range groupToCommittedOffsets {
+		if consumerGroupHasMembers(groupId) {
+			continue
+		}
+		for topic, partitions := range topics {
+			for partition, committed := range partitions {
+				if committed.CommitTimestamp+OFFSETS_RETENTION_MS <= now {
+					expired = append(expired, expiredOffset{groupId, topic, partition})
+				}
+			}
+		}
+	}
+	return expired
+}
+
+// expireCommittedOffsets writes tombstones for offsets past their retention
+// so the compacted topic eventually forgets them.
+func expireCommittedOffsets(now int64) error {
+	committedOffsetsMu.Lock()
+	defer committedOffsetsMu.Unlock()
+
+	tombstones := make(map[int32][]Record)
+	expired := collectExpiredOffsets(now)
+	for _, offset := range expired {
+		record, err := encodeOffsetCommitRecord(offset.groupId, offset.topic, offset.partition, nil)
+		if err != nil {
+			return err
+		}
+		partition := consumerOffsetsPartitionFor(offset.groupId)
+		tombstones[partition] = append(tombstones[partition], record)
+	}
+
+	for partition, records := range tombstones {
+		log, err := getPartitionLog(CONSUMER_OFFSETS_TOPIC, partition)
+		if err != nil {
+			return err
+		}
+		if _, err := log.Append(records); err != nil {
+			return err
+		}
+	}
+	for _, offset := range expired {
+		removeCommittedOffset(offset.groupId, offset.topic, offset.partition)
+	}
+
+	return nil
+}
+
+// startOffsetsRetentionTask periodically expires old committed offsets.
+func startOffsetsRetentionTask() {
+	runPeriodicTask(OFFSETS_RETENTION_CHECK_INTERVAL_MS*time.Millisecond, func() {
+		if err := expireCommittedOffsets(time.Now().UnixMilli()); err != nil {
+			fmt.Println("Error expiring committed offsets: ", err.Error())
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import "testing"
+
+func TestKeyPartitionForMatchesJava(t *testing.T) {
+	tests := []struct {
+		key  string
+		want int32
+	}{
+		// "hello".hashCode() is 99162322
+		{"hello", 99162322 % CONSUMER_OFFSETS_PARTITIONS},
+		// "polygenelubricants".hashCode() is Integer.MIN_VALUE
+		{"polygenelubricants", 0},
+		// "group-\uD83D\uDE00".hashCode() is 508083057
+		{"group-\U0001F600", 508083057 % CONSUMER_OFFSETS_PARTITIONS},
+		{"", 0},
+	}
+	for _, test := range tests {
+		if got := consumerOffsetsPartitionFor(test.key); got != test.want {
+			t.Errorf("partition of %q is %d, want %d", test.key, got, test.want)
+		}
+	}
+	// Negative hash codes are folded into the partition range
+	for _, key := range []string{"consumer-group-1", "zzzzzzzzzzzz", "group-with-a-long-name"} {
+		if partition := consumerOffsetsPartitionFor(key); partition < 0 || partition >= CONSUMER_OFFSETS_PARTITIONS {
+			t.Errorf("partition of %q is %d, out of range", key, partition)
+		}
+	}
+}
+
+// replayTestRecord replays an offset commit record as loading
+// __consumer_offsets would
+func replayTestRecord(t *testing.T, groupId string, committed *CommittedOffset) {
+	t.Helper()
+	record, err := encodeOffsetCommitRecord(groupId, "topic", 3, committed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := newRecordBatch(0, 0, []Record{record})
+	committedOffsetsMu.Lock()
+	defer committedOffsetsMu.Unlock()
+	if err := replayOffsetCommitRecord(batch, record); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayOffsetCommitRecords(t *testing.T) {
+	groupId := "test-replay-group"
+	committed := CommittedOffset{Offset: 42, LeaderEpoch: 7, Metadata: "meta", CommitTimestamp: 1000}
+
+	replayTestRecord(t, groupId, &committed)
+	got, ok := getCommittedOffset(groupId, "topic", 3)
+	if !ok || got != committed {
+		t.Fatalf("got committed offset %+v, %v, want %+v", got, ok, committed)
+	}
+
+	// A tombstone removes the offset
+	replayTestRecord(t, groupId, nil)
+	if got, ok := getCommittedOffset(groupId, "topic", 3); ok {
+		t.Fatalf("offset %+v still committed after its tombstone", got)
+	}
+	if offsets := getGroupCommittedOffsets(groupId); len(offsets) != 0 {
+		t.Fatalf("group still has offsets %v", offsets)
+	}
+}
+
+func TestCollectExpiredOffsets(t *testing.T) {
+	groupId := "test-expiry-group"
+	committedOffsetsMu.Lock()
+	defer committedOffsetsMu.Unlock()
+	storeCommittedOffset(groupId, "topic", 0, CommittedOffset{Offset: 1, CommitTimestamp: 1000})
+	storeCommittedOffset(groupId, "topic", 1, CommittedOffset{Offset: 1, CommitTimestamp: 5000})
+	defer removeCommittedOffset(groupId, "topic", 0)
+	defer removeCommittedOffset(groupId, "topic", 1)
+
+	expired := make(map[int32]bool)
+	for _, offset := range collectExpiredOffsets(1000 + OFFSETS_RETENTION_MS) {
+		if offset.groupId == groupId {
+			expired[offset.partition] = true
+		}
+	}
+	if !expired[0] || expired[1] || len(expired) != 1 {
+		t.Fatalf("expired partitions %v, want only 0", expired)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+// Controller requests run one at a time off the request handler pool, like
+// the event queue of Kafka's quorum controller. Their metadata records
+// commit once the voters fetch them, which needs the handlers free.
+var (
+	controllerEvents        chan func()
+	controllerEventsStarted sync.Once
+)
+
+// isControllerRequest reports whether a request is handled by the active
+// controller, writing metadata records.
+func isControllerRequest(apiKey ktypes.Int16) bool {
+	switch apiKey {
+	case ALTER_PARTITION_REQUEST_KEY, ALLOCATE_PRODUCER_IDS_REQUEST_KEY, BROKER_REGISTRATION_REQUEST_KEY,
+		BROKER_HEARTBEAT_REQUEST_KEY, ASSIGN_REPLICAS_TO_DIRS_REQUEST_KEY:
+		return true
+	}
+	return isForwardedRequest(apiKey)
+}
+
+// handleControllerRequest parks a controller request until the controller
+// has handled it, its handler waiting for the records it writes to commit.
+func handleControllerRequest(req *Request) *Response {
+	controllerEventsStarted.Do(func() {
+		controllerEvents = make(chan func(), brokerConfig.QueuedMaxRequests)
+		go func() {
+			for event := range controllerEvents {
+				event()
+			}
+		}()
+	})
+	return &Response{delayed: func(respond func(*Response)) {
+		controllerEvents <- func() {
+			res := handleControllerEvent(req)
+			if res != nil && res.delayed != nil {
+				res.delayed(respond)
+				return
+			}
+			respond(res)
+		}
+	}}
+}
+
+// handleControllerEvent runs the handler of a controller request, a failing
+// handler only closes the connection of the request.
+func handleControllerEvent(req *Request) (res *Response) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("Error handling controller request ", req.RequestApiKey, ": ", r)
+			req.Session.closeConnection = true
+			res = nil
+		}
+	}()
+	return callHandler(req)
+}
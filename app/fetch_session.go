@@ -0,0 +1,216 @@
+package main
+
+import (
+	"container/list"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	// Session id of fetch requests that do not use a fetch session
+	FETCH_SESSION_INVALID_ID = 0
+	// Session epoch of full fetch requests creating a fetch session
+	FETCH_SESSION_INITIAL_EPOCH = 0
+)
+
+// FetchSessionPartitionKey identifies a partition of a fetch session.
+type FetchSessionPartitionKey struct {
+	topicId   ktypes.UUID
+	partition int32
+}
+
+// FetchSessionPartition is what a fetch session knows of one of its
+// partitions: how the fetcher last asked for it, and what it last got.
+type FetchSessionPartition struct {
+	request FetchRequestPartition
+	// Last values sent to the fetcher, -1 until the partition is returned
+	highWatermark        int64
+	lastStableOffset     int64
+	logStartOffset       int64
+	preferredReadReplica int32
+}
+
+// FetchSession holds the partitions a fetcher reads, so its incremental
+// fetch requests only list the partitions whose fetch parameters changed
+// and get back only the partitions that changed (KIP-227).
+type FetchSession struct {
+	id int32
+	// Epoch the next incremental fetch request must have
+	epoch      int32
+	partitions map[FetchSessionPartitionKey]*FetchSessionPartition
+	// Partitions in the order they were added
+	keys []FetchSessionPartitionKey
+	// Element of the session in fetchSessionsLru
+	element *list.Element
+}
+
+// Fetch sessions by id, guarded by fetchSessionsMu. Once every slot of
+// max.incremental.fetch.session.cache.slots is used, the least recently
+// used session is evicted to make room.
+var (
+	fetchSessionsMu sync.Mutex
+	fetchSessions   = make(map[int32]*FetchSession)
+	// Sessions from the least to the most recently used
+	fetchSessionsLru = list.New()
+)
+
+// isIncrementalFetch tells whether a fetch request reads the partitions of
+// an existing session rather than listing all of them.
+func isIncrementalFetch(requestBody *FetchRequestBody) bool {
+	return requestBody.SessionId != FETCH_SESSION_INVALID_ID &&
+		requestBody.SessionEpoch != FETCH_SESSION_INITIAL_EPOCH &&
+		requestBody.SessionEpoch != FETCH_SESSION_FINAL_EPOCH
+}
+
+// nextFetchSessionEpoch returns the epoch following epoch, wrapping to 1.
+func nextFetchSessionEpoch(epoch int32) int32 {
+	if epoch == math.MaxInt32 {
+		return 1
+	}
+	return epoch + 1
+}
+
+// openFetchSession returns the session of a fetch request. Full requests
+// close the session they name, then create one unless their epoch is final
+// or the cache has no slots, which leaves them without a session. The topics
+// of incremental requests are replaced by every partition of their session.
+func openFetchSession(requestBody *FetchRequestBody) (*FetchSession, error) {
+	fetchSessionsMu.Lock()
+	defer fetchSessionsMu.Unlock()
+
+	if !isIncrementalFetch(requestBody) {
+		if session, ok := fetchSessions[int32(requestBody.SessionId)]; ok {
+			removeFetchSession(session)
+		}
+		if requestBody.SessionEpoch == FETCH_SESSION_FINAL_EPOCH || brokerConfig.MaxIncrementalFetchSessionCacheSlots == 0 {
+			return nil, nil
+		}
+		if len(fetchSessions) >= brokerConfig.MaxIncrementalFetchSessionCacheSlots {
+			removeFetchSession(fetchSessionsLru.Front().Value.(*FetchSession))
+		}
+		session := &FetchSession{
+			id:         newFetchSessionId(),
+			epoch:      nextFetchSessionEpoch(FETCH_SESSION_INITIAL_EPOCH),
+			partitions: make(map[FetchSessionPartitionKey]*FetchSessionPartition),
+		}
+		session.addPartitions(requestBody.Topics)
+		session.element = fetchSessionsLru.PushBack(session)
+		fetchSessions[session.id] = session
+		return session, nil
+	}
+
+	session, ok := fetchSessions[int32(requestBody.SessionId)]
+	if !ok {
+		return nil, newKafkaError(ERROR_CODE_FETCH_SESSION_ID_NOT_FOUND, "fetch session %d not found", requestBody.SessionId)
+	}
+	if int32(requestBody.SessionEpoch) != session.epoch {
+		return nil, newKafkaError(ERROR_CODE_INVALID_FETCH_SESSION_EPOCH, "fetch session %d expected epoch %d, got %d", session.id, session.epoch, requestBody.SessionEpoch)
+	}
+	session.epoch = nextFetchSessionEpoch(session.epoch)
+	fetchSessionsLru.MoveToBack(session.element)
+	session.addPartitions(requestBody.Topics)
+	for _, topic := range requestBody.ForgettenTopic {
+		for _, partition := range topic.Partitions {
+			key := FetchSessionPartitionKey{topic.TopicId, int32(partition)}
+			delete(session.partitions, key)
+			session.keys = slices.DeleteFunc(session.keys, func(k FetchSessionPartitionKey) bool { return k == key })
+		}
+	}
+	requestBody.Topics = session.requestTopics()
+	return session, nil
+}
+
+// newFetchSessionId returns a random positive id no session has. Callers
+// hold fetchSessionsMu.
+func newFetchSessionId() int32 {
+	for {
+		id := rand.Int32N(math.MaxInt32) + 1
+		if _, ok := fetchSessions[id]; !ok {
+			return id
+		}
+	}
+}
+
+// removeFetchSession closes a session. Callers hold fetchSessionsMu.
+func removeFetchSession(session *FetchSession) {
+	delete(fetchSessions, session.id)
+	fetchSessionsLru.Remove(session.element)
+}
+
+// addPartitions adds the partitions of a fetch request to the session, or
+// updates how they are fetched. Callers hold fetchSessionsMu.
+func (s *FetchSession) addPartitions(topics []FetchRequestTopic) {
+	for _, topic := range topics {
+		for _, partition := range topic.Partitions {
+			key := FetchSessionPartitionKey{topic.TopicId, int32(partition.Partition)}
+			if cached, ok := s.partitions[key]; ok {
+				cached.request = partition
+				continue
+			}
+			s.partitions[key] = &FetchSessionPartition{
+				request:              partition,
+				highWatermark:        -1,
+				lastStableOffset:     -1,
+				logStartOffset:       -1,
+				preferredReadReplica: -1,
+			}
+			s.keys = append(s.keys, key)
+		}
+	}
+}
+
+// requestTopics returns the partitions of the session as the topics of a
+// fetch request. Callers hold fetchSessionsMu.
+func (s *FetchSession) requestTopics() []FetchRequestTopic {
+	topics := []FetchRequestTopic{}
+	topicIndexes := make(map[ktypes.UUID]int)
+	for _, key := range s.keys {
+		i, ok := topicIndexes[key.topicId]
+		if !ok {
+			i = len(topics)
+			topicIndexes[key.topicId] = i
+			topics = append(topics, FetchRequestTopic{TopicId: key.topicId})
+		}
+		topics[i].Partitions = append(topics[i].Partitions, s.partitions[key].request)
+	}
+	return topics
+}
+
+// updateResponses records what the fetcher got of each partition of the
+// session. Incremental responses keep only the partitions with records, an
+// error, or offsets or a preferred read replica that changed since they
+// were last returned.
+func (s *FetchSession) updateResponses(responses []FetchResponseTopic, incremental bool) []FetchResponseTopic {
+	fetchSessionsMu.Lock()
+	defer fetchSessionsMu.Unlock()
+
+	updated := make([]FetchResponseTopic, 0, len(responses))
+	for _, topic := range responses {
+		partitions := make([]FetchResponsePartition, 0, len(topic.Partitions))
+		for _, partition := range topic.Partitions {
+			cached, ok := s.partitions[FetchSessionPartitionKey{topic.TopicId, int32(partition.PartitionIndex)}]
+			changed := !ok || len(partition.Records) > 0 || partition.ErrorCode != ERROR_CODE_NONE || partition.TaggedFields != nil ||
+				cached.highWatermark != int64(partition.HighWatermark) ||
+				cached.lastStableOffset != int64(partition.LastStableOffset) ||
+				cached.logStartOffset != int64(partition.LogStartOffset) ||
+				cached.preferredReadReplica != int32(partition.PreferredReadReplica)
+			if ok {
+				cached.highWatermark = int64(partition.HighWatermark)
+				cached.lastStableOffset = int64(partition.LastStableOffset)
+				cached.logStartOffset = int64(partition.LogStartOffset)
+				cached.preferredReadReplica = int32(partition.PreferredReadReplica)
+			}
+			if changed || !incremental {
+				partitions = append(partitions, partition)
+			}
+		}
+		if len(partitions) > 0 || !incremental {
+			updated = append(updated, FetchResponseTopic{TopicId: topic.TopicId, Partitions: partitions})
+		}
+	}
+	return updated
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+var (
+	fetchSessionTestTopicA = ktypes.UUID{0xa}
+	fetchSessionTestTopicB = ktypes.UUID{0xb}
+)
+
+// resetTestFetchSessions empties the fetch session cache, with slots
+// sessions at most
+func resetTestFetchSessions(t *testing.T, slots int) {
+	t.Helper()
+	previousConfig := brokerConfig
+	brokerConfig.MaxIncrementalFetchSessionCacheSlots = slots
+	fetchSessionsMu.Lock()
+	previousSessions, previousLru := fetchSessions, fetchSessionsLru
+	fetchSessions, fetchSessionsLru = make(map[int32]*FetchSession), list.New()
+	fetchSessionsMu.Unlock()
+	t.Cleanup(func() {
+		brokerConfig = previousConfig
+		fetchSessionsMu.Lock()
+		defer fetchSessionsMu.Unlock()
+		fetchSessions, fetchSessionsLru = previousSessions, previousLru
+	})
+}
+
+// fetchSessionTestTopic returns a topic of a fetch request reading the
+// partitions from offset
+func fetchSessionTestTopic(topicId ktypes.UUID, offset int64, partitions ...int32) FetchRequestTopic {
+	topic := FetchRequestTopic{TopicId: topicId}
+	for _, partition := range partitions {
+		topic.Partitions = append(topic.Partitions, FetchRequestPartition{Partition: ktypes.Int32(partition), FetchOffset: ktypes.Int64(offset)})
+	}
+	return topic
+}
+
+// fetchSessionTestKeys returns the partitions and offsets of request topics
+// as topic:partition@offset strings
+func fetchSessionTestKeys(topics []FetchRequestTopic) []string {
+	keys := make([]string, 0)
+	for _, topic := range topics {
+		for _, partition := range topic.Partitions {
+			keys = append(keys, fmt.Sprintf("%x:%d@%d", topic.TopicId[0], partition.Partition, partition.FetchOffset))
+		}
+	}
+	return keys
+}
+
+func TestIncrementalFetchSession(t *testing.T) {
+	resetTestFetchSessions(t, 10)
+	session, err := openFetchSession(&FetchRequestBody{
+		Topics: []FetchRequestTopic{fetchSessionTestTopic(fetchSessionTestTopicA, 0, 0, 1), fetchSessionTestTopic(fetchSessionTestTopicB, 0, 0)},
+	})
+	if err != nil || session == nil {
+		t.Fatalf("got session %v, %v, want a new session", session, err)
+	}
+	if session.id == FETCH_SESSION_INVALID_ID || session.epoch != 1 {
+		t.Fatalf("got session %d at epoch %d, want a valid id at epoch 1", session.id, session.epoch)
+	}
+
+	steps := []struct {
+		name      string
+		topics    []FetchRequestTopic
+		forgotten []FetchRequestForgettenTopic
+		want      []string
+	}{
+		{"no change", nil, nil, []string{"a:0@0", "a:1@0", "b:0@0"}},
+		{"offset moved", []FetchRequestTopic{fetchSessionTestTopic(fetchSessionTestTopicA, 5, 1)}, nil, []string{"a:0@0", "a:1@5", "b:0@0"}},
+		{"partition added", []FetchRequestTopic{fetchSessionTestTopic(fetchSessionTestTopicA, 2, 2)}, nil, []string{"a:0@0", "a:1@5", "a:2@2", "b:0@0"}},
+		{
+			"partitions forgotten", nil,
+			[]FetchRequestForgettenTopic{{TopicId: fetchSessionTestTopicA, Partitions: ktypes.CompactArray[ktypes.Int32]{0, 2}}},
+			[]string{"a:1@5", "b:0@0"},
+		},
+	}
+	for i, step := range steps {
+		request := &FetchRequestBody{
+			SessionId:      ktypes.Int32(session.id),
+			SessionEpoch:   ktypes.Int32(i + 1),
+			Topics:         step.topics,
+			ForgettenTopic: step.forgotten,
+		}
+		if _, err := openFetchSession(request); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got := fetchSessionTestKeys(request.Topics); !slices.Equal(got, step.want) {
+			t.Errorf("%s: got partitions %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	errorTests := []struct {
+		name      string
+		sessionId int32
+		epoch     int32
+		want      ERROR_CODE
+	}{
+		{"epoch replayed", session.id, 4, ERROR_CODE_INVALID_FETCH_SESSION_EPOCH},
+		{"epoch skipped", session.id, 6, ERROR_CODE_INVALID_FETCH_SESSION_EPOCH},
+		{"unknown session", session.id + 1, 5, ERROR_CODE_FETCH_SESSION_ID_NOT_FOUND},
+	}
+	for _, test := range errorTests {
+		_, err := openFetchSession(&FetchRequestBody{SessionId: ktypes.Int32(test.sessionId), SessionEpoch: ktypes.Int32(test.epoch)})
+		if got := errorCodeFromError(err); got != test.want {
+			t.Errorf("%s: got error %d, want %d", test.name, got, test.want)
+		}
+	}
+
+	// The final epoch closes the session without opening another
+	closed, err := openFetchSession(&FetchRequestBody{SessionId: ktypes.Int32(session.id), SessionEpoch: FETCH_SESSION_FINAL_EPOCH})
+	if err != nil || closed != nil {
+		t.Errorf("got session %v, %v for the final epoch, want none", closed, err)
+	}
+	if _, ok := fetchSessions[session.id]; ok {
+		t.Error("session still cached after its final epoch")
+	}
+}
+
+func TestFetchSessionEviction(t *testing.T) {
+	resetTestFetchSessions(t, 2)
+	open := func() *FetchSession {
+		t.Helper()
+		session, err := openFetchSession(&FetchRequestBody{Topics: []FetchRequestTopic{fetchSessionTestTopic(fetchSessionTestTopicA, 0, 0)}})
+		if err != nil || session == nil {
+			t.Fatalf("got session %v, %v, want a new session", session, err)
+		}
+		return session
+	}
+	first, second := open(), open()
+	// Using the first session makes the second the least recently used
+	if _, err := openFetchSession(&FetchRequestBody{SessionId: ktypes.Int32(first.id), SessionEpoch: 1}); err != nil {
+		t.Fatal(err)
+	}
+	third := open()
+	for _, session := range []*FetchSession{first, second, third} {
+		_, cached := fetchSessions[session.id]
+		if want := session != second; cached != want {
+			t.Errorf("session %d: got cached %v, want %v", session.id, cached, want)
+		}
+	}
+
+	// A full request naming its session replaces it
+	replaced, err := openFetchSession(&FetchRequestBody{SessionId: ktypes.Int32(first.id), SessionEpoch: FETCH_SESSION_INITIAL_EPOCH})
+	if err != nil || replaced == nil || replaced.id == first.id {
+		t.Fatalf("got session %v, %v, want a new session", replaced, err)
+	}
+	if _, ok := fetchSessions[first.id]; ok || len(fetchSessions) != 2 {
+		t.Errorf("got %d sessions with the replaced one cached %v, want 2 without it", len(fetchSessions), ok)
+	}
+
+	brokerConfig.MaxIncrementalFetchSessionCacheSlots = 0
+	if session, err := openFetchSession(&FetchRequestBody{}); err != nil || session != nil {
+		t.Errorf("got session %v, %v without cache slots, want none", session, err)
+	}
+}
+
+func TestFetchSessionUpdateResponses(t *testing.T) {
+	resetTestFetchSessions(t, 10)
+	session, err := openFetchSession(&FetchRequestBody{
+		Topics: []FetchRequestTopic{fetchSessionTestTopic(fetchSessionTestTopicA, 0, 0, 1, 2), fetchSessionTestTopic(fetchSessionTestTopicB, 0, 0)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	partition := func(index int32, highWatermark int64) FetchResponsePartition {
+		return FetchResponsePartition{PartitionIndex: ktypes.Int32(index), HighWatermark: ktypes.Int64(highWatermark), LastStableOffset: ktypes.Int64(highWatermark), PreferredReadReplica: -1}
+	}
+	responses := func(partitionsA ...FetchResponsePartition) []FetchResponseTopic {
+		return []FetchResponseTopic{
+			{TopicId: fetchSessionTestTopicA, Partitions: partitionsA},
+			{TopicId: fetchSessionTestTopicB, Partitions: ktypes.CompactArray[FetchResponsePartition]{partition(0, 3)}},
+		}
+	}
+	withRecords := partition(1, 5)
+	withRecords.Records = ktypes.CompactRecords{1}
+	withError := partition(2, 5)
+	withError.ErrorCode = ERROR_CODE_NOT_LEADER_OR_FOLLOWER
+	movedReplica := partition(0, 6)
+	movedReplica.PreferredReadReplica = 2
+
+	steps := []struct {
+		name        string
+		responses   []FetchResponseTopic
+		incremental bool
+		want        []string
+	}{
+		{"first response", responses(partition(0, 5), partition(1, 5), partition(2, 5)), true, []string{"a:0", "a:1", "a:2", "b:0"}},
+		{"nothing changed", responses(partition(0, 5), partition(1, 5), partition(2, 5)), true, []string{}},
+		{"records, error and high watermark", responses(partition(0, 6), withRecords, withError), true, []string{"a:0", "a:1", "a:2"}},
+		{"preferred read replica", responses(movedReplica, partition(1, 5), partition(2, 5)), true, []string{"a:0"}},
+		{"full request", responses(movedReplica, partition(1, 5), partition(2, 5)), false, []string{"a:0", "a:1", "a:2", "b:0"}},
+	}
+	for _, step := range steps {
+		updated := session.updateResponses(step.responses, step.incremental)
+		got := make([]string, 0)
+		for _, topic := range updated {
+			for _, p := range topic.Partitions {
+				got = append(got, fmt.Sprintf("%x:%d", topic.TopicId[0], p.PartitionIndex))
+			}
+		}
+		if !slices.Equal(got, step.want) {
+			t.Errorf("%s: got partitions %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestNextFetchSessionEpoch(t *testing.T) {
+	tests := []struct {
+		epoch int32
+		want  int32
+	}{
+		{0, 1},
+		{1, 2},
+		{math.MaxInt32 - 1, math.MaxInt32},
+		{math.MaxInt32, 1},
+	}
+	for _, test := range tests {
+		if got := nextFetchSessionEpoch(test.epoch); got != test.want {
+			t.Errorf("epoch %d: got next %d, want %d", test.epoch, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	ENVELOPE_VERSION          = 0
+	DEFAULT_PRINCIPAL_VERSION = 0
+)
+
+// Principal of a forwarded request, as serialized by Kafka's default
+// principal builder after a version
+type DefaultPrincipalData struct {
+	Type               ktypes.CompactString `order:"1"`
+	Name               ktypes.CompactString `order:"2"`
+	TokenAuthenticated ktypes.Bool          `order:"3"`
+	TaggedFields       ktypes.TaggedFields  `order:"4"`
+}
+
+type DefaultPrincipalDataWithVersion struct {
+	Version   ktypes.Int16         `order:"1"`
+	Principal DefaultPrincipalData `order:"2"`
+}
+
+// Connection to the active controller used to send it requests, guarded by
+// controllerClientMu
+var (
+	controllerClientMu sync.Mutex
+	controllerClient   *BrokerClient
+)
+
+// isForwardedRequest reports whether a request changes the metadata, which
+// brokers forward to the active controller.
+func isForwardedRequest(apiKey ktypes.Int16) bool {
+	switch apiKey {
+	case CREATE_ACLS_REQUEST_KEY, DELETE_ACLS_REQUEST_KEY, ALTER_CLIENT_QUOTAS_REQUEST_KEY, ALTER_USER_SCRAM_CREDENTIALS_REQUEST_KEY,
+		ALTER_PARTITION_REASSIGNMENTS_REQUEST_KEY, ELECT_LEADERS_REQUEST_KEY:
+		return true
+	}
+	return false
+}
+
+// sendToController sends a request to the active controller, waiting up to
+// controller.quorum.request.timeout.ms for one to be elected.
+func sendToController(apiKey ktypes.Int16, apiVersion ktypes.Int16, requestBody any, responseBody any) error {
+	controllerClientMu.Lock()
+	defer controllerClientMu.Unlock()
+
+	deadline := time.Now().Add(time.Duration(brokerConfig.ControllerQuorumRequestTimeoutMs) * time.Millisecond)
+	leaderId, _ := raftClient.currentLeader()
+	for leaderId == NO_LEADER || leaderId == int32(brokerConfig.NodeId) {
+		if !time.Now().Before(deadline) {
+			return newKafkaError(ERROR_CODE_NOT_CONTROLLER, "no active controller to send the request to")
+		}
+		time.Sleep(QUORUM_RETRY_BACKOFF_MS * time.Millisecond)
+		leaderId, _ = raftClient.currentLeader()
+	}
+
+	if controllerClient != nil && controllerClient.brokerId != leaderId {
+		controllerClient.Close()
+		controllerClient = nil
+	}
+	if controllerClient == nil {
+		client, err := dialVoter(leaderId)
+		if err != nil {
+			return err
+		}
+		controllerClient = client
+	}
+	if err := controllerClient.send(apiKey, apiVersion, requestBody, responseBody); err != nil {
+		controllerClient.Close()
+		controllerClient = nil
+		return err
+	}
+	return nil
+}
+
+// encodePrincipal serializes a principal such as User:alice for an
+// Envelope.
+func encodePrincipal(principal string) ([]byte, error) {
+	principalType, name, _ := strings.Cut(principal, ":")
+	return ktypes.NewKEncoder().Encode(&DefaultPrincipalDataWithVersion{
+		Version: ktypes.Int16(DEFAULT_PRINCIPAL_VERSION),
+		Principal: DefaultPrincipalData{
+			Type: ktypes.CompactString(principalType),
+			Name: ktypes.CompactString(name),
+		},
+	})
+}
+
+// decodePrincipal reads the principal of an Envelope.
+func decodePrincipal(data []byte) (string, error) {
+	var principal DefaultPrincipalDataWithVersion
+	if err := ktypes.NewKDecoder(data).Decode(&principal); err != nil {
+		return "", err
+	}
+	if principal.Version != DEFAULT_PRINCIPAL_VERSION {
+		return "", fmt.Errorf("unsupported principal version %d", principal.Version)
+	}
+	return string(principal.Principal.Type) + ":" + string(principal.Principal.Name), nil
+}
+
+// forwardToController sends a request changing the metadata to the active
+// controller in an Envelope, along with the principal and address of the
+// client, and returns the controller's response. The connection is closed
+// when the controller cannot be reached, clients then retry.
+func forwardToController(req *Request) *Response {
+	frame, err := encodeRequest(req, req.Body)
+	if err != nil {
+		fmt.Println("Error forwarding request: ", err.Error())
+		req.Session.closeConnection = true
+		return nil
+	}
+	principal, err := encodePrincipal(req.Session.Principal)
+	if err != nil {
+		fmt.Println("Error forwarding request: ", err.Error())
+		req.Session.closeConnection = true
+		return nil
+	}
+	clientAddress := net.ParseIP(clientAddress(req.ClientHost))
+	if ipv4 := clientAddress.To4(); ipv4 != nil {
+		clientAddress = ipv4
+	}
+
+	requestBody := EnvelopeRequestBody{
+		RequestData:       frame[4:],
+		RequestPrincipal:  principal,
+		ClientHostAddress: ktypes.CompactBytes(clientAddress),
+	}
+	var responseBody EnvelopeResponseBody
+	if err := sendToController(ENVELOPE_REQUEST_KEY, ENVELOPE_VERSION, &requestBody, &responseBody); err != nil {
+		fmt.Println("Error forwarding request to the controller: ", err.Error())
+		req.Session.closeConnection = true
+		return nil
+	}
+	if responseBody.ErrorCode != ERROR_CODE_NONE || len(responseBody.ResponseData) == 0 {
+		fmt.Println("Controller failed the forwarded request with error ", responseBody.ErrorCode)
+		req.Session.closeConnection = true
+		return nil
+	}
+
+	decoder := ktypes.NewKDecoder(responseBody.ResponseData)
+	var responseHeader ResponseHeaderV1
+	if err := decoder.Decode(&responseHeader); err != nil {
+		fmt.Println("Error decoding forwarded response: ", err.Error())
+		req.Session.closeConnection = true
+		return nil
+	}
+	return &Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+		Body:          responseBody.ResponseData[decoder.GetPosition():],
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type AddOffsetsToTxnRequestBody struct {
+	TransactionalId ktypes.CompactString `order:"1"`
+	ProducerId      ktypes.Int64         `order:"2"`
+	ProducerEpoch   ktypes.Int16         `order:"3"`
+	GroupId         ktypes.CompactString `order:"4"`
+	TaggedFields    ktypes.TaggedFields  `order:"5"`
+}
+
+type AddOffsetsToTxnResponseBody struct {
+	ThrottleTimeMs ktypes.Int32        `order:"1"`
+	ErrorCode      ERROR_CODE          `order:"2"`
+	TaggedFields   ktypes.TaggedFields `order:"3"`
+}
+
+func parseAddOffsetsToTxnRequestBody(body []byte) (*AddOffsetsToTxnRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody AddOffsetsToTxnRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode add offsets to txn request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromAddOffsetsToTxnResponseBody(body *AddOffsetsToTxnResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode add offsets to txn response: %v", err))
+	}
+	return encoded
+}
+
+func handleAddOffsetsToTxnRequest(req *Request) *Response {
+	requestBody, err := parseAddOffsetsToTxnRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	var errorCode ERROR_CODE
+	groupId := string(requestBody.GroupId)
+	if groupId == "" {
+		errorCode = ERROR_CODE_INVALID_GROUP_ID
+	} else if !authorize(req, ACL_OPERATION_WRITE, RESOURCE_TYPE_TRANSACTIONAL_ID, string(requestBody.TransactionalId)) {
+		errorCode = ERROR_CODE_TRANSACTIONAL_ID_AUTHORIZATION_FAILED
+	} else if !authorize(req, ACL_OPERATION_READ, RESOURCE_TYPE_GROUP, groupId) {
+		errorCode = ERROR_CODE_GROUP_AUTHORIZATION_FAILED
+	} else {
+		// The group's offsets partition takes part in the transaction like any other
+		errorCode = addPartitionsToTxn(string(requestBody.TransactionalId), int64(requestBody.ProducerId), int16(requestBody.ProducerEpoch), map[string][]int32{
+			CONSUMER_OFFSETS_TOPIC: {consumerOffsetsPartitionFor(groupId)},
+		})
+	}
+
+	responseBody := AddOffsetsToTxnResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		ErrorCode:      errorCode,
+	}
+
+	res.Body = generateBytesFromAddOffsetsToTxnResponseBody(&responseBody)
+	return &res
+}
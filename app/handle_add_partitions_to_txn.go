@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type AddPartitionsToTxnRequestTopic struct {
+	Name         ktypes.CompactString              `order:"1"`
+	Partitions   ktypes.CompactArray[ktypes.Int32] `order:"2"`
+	TaggedFields ktypes.TaggedFields               `order:"3"`
+}
+
+type AddPartitionsToTxnRequestBody struct {
+	TransactionalId ktypes.CompactString                                `order:"1"`
+	ProducerId      ktypes.Int64                                        `order:"2"`
+	ProducerEpoch   ktypes.Int16                                        `order:"3"`
+	Topics          ktypes.CompactArray[AddPartitionsToTxnRequestTopic] `order:"4"`
+	TaggedFields    ktypes.TaggedFields                                 `order:"5"`
+}
+
+type AddPartitionsToTxnPartitionResult struct {
+	PartitionIndex     ktypes.Int32        `order:"1"`
+	PartitionErrorCode ERROR_CODE          `order:"2"`
+	TaggedFields       ktypes.TaggedFields `order:"3"`
+}
+
+type AddPartitionsToTxnTopicResult struct {
+	Name               ktypes.CompactString                                   `order:"1"`
+	ResultsByPartition ktypes.CompactArray[AddPartitionsToTxnPartitionResult] `order:"2"`
+	TaggedFields       ktypes.TaggedFields                                    `order:"3"`
+}
+
+type AddPartitionsToTxnResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                       `order:"1"`
+	ResultsByTopic ktypes.CompactArray[AddPartitionsToTxnTopicResult] `order:"2"`
+	TaggedFields   ktypes.TaggedFields                                `order:"3"`
+}
+
+func parseAddPartitionsToTxnRequestBody(body []byte) (*AddPartitionsToTxnRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody AddPartitionsToTxnRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode add partitions to txn request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromAddPartitionsToTxnResponseBody(body *AddPartitionsToTxnResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode add partitions to txn response: %v", err))
+	}
+	return encoded
+}
+
+// addPartitionsToTxnResults answers every requested partition with its own
+// error code, or with defaultErrorCode when it has none.
+func addPartitionsToTxnResults(topics []AddPartitionsToTxnRequestTopic, errorCodes map[string]map[int32]ERROR_CODE, defaultErrorCode ERROR_CODE) []AddPartitionsToTxnTopicResult {
+	results := make([]AddPartitionsToTxnTopicResult, len(topics))
+	for i, topic := range topics {
+		partitions := make([]AddPartitionsToTxnPartitionResult, len(topic.Partitions))
+		for j, partition := range topic.Partitions {
+			errorCode, ok := errorCodes[string(topic.Name)][int32(partition)]
+			if !ok {
+				errorCode = defaultErrorCode
+			}
+			partitions[j] = AddPartitionsToTxnPartitionResult{
+				PartitionIndex:     partition,
+				PartitionErrorCode: errorCode,
+			}
+		}
+		results[i] = AddPartitionsToTxnTopicResult{
+			Name:               topic.Name,
+			ResultsByPartition: partitions,
+		}
+	}
+	return results
+}
+
+func handleAddPartitionsToTxnRequest(req *Request) *Response {
+	requestBody, err := parseAddPartitionsToTxnRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	// Nothing is added when any partition is unknown or not writable
+	failedPartitions := make(map[string]map[int32]ERROR_CODE)
+	partitions := make(map[string][]int32)
+	for _, topic := range requestBody.Topics {
+		topicName := string(topic.Name)
+		topicId, ok := topicNameToTopicId[topicName]
+		authorized := authorize(req, ACL_OPERATION_WRITE, RESOURCE_TYPE_TOPIC, topicName)
+		for _, partition := range topic.Partitions {
+			errorCode := ERROR_CODE_NONE
+			if !authorized {
+				errorCode = ERROR_CODE_TOPIC_AUTHORIZATION_FAILED
+			} else if !ok || !slices.Contains(topicIdToPartitionIds[topicId], int32(partition)) {
+				errorCode = ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION
+			}
+			if errorCode != ERROR_CODE_NONE {
+				if failedPartitions[topicName] == nil {
+					failedPartitions[topicName] = make(map[int32]ERROR_CODE)
+				}
+				failedPartitions[topicName][int32(partition)] = errorCode
+				continue
+			}
+			partitions[topicName] = append(partitions[topicName], int32(partition))
+		}
+	}
+
+	var results []AddPartitionsToTxnTopicResult
+	if !authorize(req, ACL_OPERATION_WRITE, RESOURCE_TYPE_TRANSACTIONAL_ID, string(requestBody.TransactionalId)) {
+		results = addPartitionsToTxnResults(requestBody.Topics, nil, ERROR_CODE_TRANSACTIONAL_ID_AUTHORIZATION_FAILED)
+	} else if len(failedPartitions) > 0 {
+		results = addPartitionsToTxnResults(requestBody.Topics, failedPartitions, ERROR_CODE_OPERATION_NOT_ATTEMPTED)
+	} else {
+		errorCode := addPartitionsToTxn(string(requestBody.TransactionalId), int64(requestBody.ProducerId), int16(requestBody.ProducerEpoch), partitions)
+		results = addPartitionsToTxnResults(requestBody.Topics, nil, errorCode)
+	}
+
+	responseBody := AddPartitionsToTxnResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		ResultsByTopic: results,
+	}
+
+	res.Body = generateBytesFromAddPartitionsToTxnResponseBody(&responseBody)
+	return &res
+}
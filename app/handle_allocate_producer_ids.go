@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const ALLOCATE_PRODUCER_IDS_VERSION = 0
+
+type AllocateProducerIdsRequestBody struct {
+	BrokerId     ktypes.Int32        `order:"1"`
+	BrokerEpoch  ktypes.Int64        `order:"2"`
+	TaggedFields ktypes.TaggedFields `order:"3"`
+}
+
+type AllocateProducerIdsResponseBody struct {
+	ThrottleTimeMs  ktypes.Int32        `order:"1"`
+	ErrorCode       ERROR_CODE          `order:"2"`
+	ProducerIdStart ktypes.Int64        `order:"3"`
+	ProducerIdLen   ktypes.Int32        `order:"4"`
+	TaggedFields    ktypes.TaggedFields `order:"5"`
+}
+
+func parseAllocateProducerIdsRequestBody(body []byte) (*AllocateProducerIdsRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody AllocateProducerIdsRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode allocate producer ids request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromAllocateProducerIdsResponseBody(body *AllocateProducerIdsResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode allocate producer ids response: %v", err))
+	}
+	return encoded
+}
+
+// handleAllocateProducerIdsRequest hands a block of producer ids out to a
+// broker, which the active controller alone can do.
+func handleAllocateProducerIdsRequest(req *Request) *Response {
+	requestBody, err := parseAllocateProducerIdsRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	responseBody := AllocateProducerIdsResponseBody{
+		ThrottleTimeMs:  ktypes.Int32(req.ThrottleTimeMs),
+		ErrorCode:       ERROR_CODE_NONE,
+		ProducerIdStart: ktypes.Int64(-1),
+		ProducerIdLen:   ktypes.Int32(0),
+	}
+	if !authorize(req, ACL_OPERATION_CLUSTER_ACTION, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME) {
+		responseBody.ErrorCode = ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED
+	} else {
+		metadataWriteMu.Lock()
+		metadataMu.Lock()
+		blockStart, err := allocateProducerIdBlock(int32(requestBody.BrokerId))
+		metadataMu.Unlock()
+		metadataWriteMu.Unlock()
+		responseBody.ErrorCode = errorCodeFromError(err)
+		if err == nil {
+			responseBody.ProducerIdStart = ktypes.Int64(blockStart)
+			responseBody.ProducerIdLen = ktypes.Int32(PRODUCER_ID_BLOCK_SIZE)
+		}
+	}
+
+	res.Body = generateBytesFromAllocateProducerIdsResponseBody(&responseBody)
+	return &res
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type AlterClientQuotasOp struct {
+	Key          ktypes.CompactString `order:"1"`
+	Value        ktypes.Float64       `order:"2"`
+	Remove       ktypes.Bool          `order:"3"`
+	TaggedFields ktypes.TaggedFields  `order:"4"`
+}
+
+type AlterClientQuotasRequestEntry struct {
+	Entity       ktypes.CompactArray[ClientQuotaRecordEntity] `order:"1"`
+	Ops          ktypes.CompactArray[AlterClientQuotasOp]     `order:"2"`
+	TaggedFields ktypes.TaggedFields                          `order:"3"`
+}
+
+type AlterClientQuotasRequestBody struct {
+	Entries      ktypes.CompactArray[AlterClientQuotasRequestEntry] `order:"1"`
+	ValidateOnly ktypes.Bool                                        `order:"2"`
+	TaggedFields ktypes.TaggedFields                                `order:"3"`
+}
+
+type AlterClientQuotasResponseEntry struct {
+	ErrorCode    ERROR_CODE                                   `order:"1"`
+	ErrorMessage ktypes.CompactNullableString                 `order:"2"`
+	Entity       ktypes.CompactArray[ClientQuotaRecordEntity] `order:"3"`
+	TaggedFields ktypes.TaggedFields                          `order:"4"`
+}
+
+type AlterClientQuotasResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                        `order:"1"`
+	Entries        ktypes.CompactArray[AlterClientQuotasResponseEntry] `order:"2"`
+	TaggedFields   ktypes.TaggedFields                                 `order:"3"`
+}
+
+func parseAlterClientQuotasRequestBody(body []byte) (*AlterClientQuotasRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody AlterClientQuotasRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode alter client quotas request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromAlterClientQuotasResponseBody(body *AlterClientQuotasResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode alter client quotas response: %v", err))
+	}
+	return encoded
+}
+
+func handleAlterClientQuotasRequest(req *Request) *Response {
+	requestBody, err := parseAlterClientQuotasRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	authorized := authorize(req, ACL_OPERATION_ALTER_CONFIGS, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME)
+	entries := make([]AlterClientQuotasResponseEntry, 0, len(requestBody.Entries))
+	for _, requestEntry := range requestBody.Entries {
+		entry := AlterClientQuotasResponseEntry{
+			ErrorCode: ERROR_CODE_NONE,
+			Entity:    requestEntry.Entity,
+		}
+		entity, err := quotaEntityFromRecord(requestEntry.Entity)
+		if !authorized {
+			entry.ErrorCode = ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED
+		} else if err != nil {
+			entry.ErrorCode = ERROR_CODE_INVALID_REQUEST
+			entry.ErrorMessage = ktypes.CompactNullableString(err.Error())
+		} else {
+			alterations := make([]ClientQuotaAlteration, 0, len(requestEntry.Ops))
+			for _, op := range requestEntry.Ops {
+				alterations = append(alterations, ClientQuotaAlteration{
+					Key:    string(op.Key),
+					Value:  float64(op.Value),
+					Remove: bool(op.Remove),
+				})
+			}
+			if err := alterClientQuotas(entity, alterations, bool(requestBody.ValidateOnly)); err != nil {
+				entry.ErrorCode = errorCodeFromError(err)
+				entry.ErrorMessage = ktypes.CompactNullableString(err.Error())
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	responseBody := AlterClientQuotasResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		Entries:        entries,
+	}
+
+	res.Body = generateBytesFromAlterClientQuotasResponseBody(&responseBody)
+	return &res
+}
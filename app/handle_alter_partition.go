@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const ALTER_PARTITION_VERSION = 3
+
+type AlterPartitionRequestBrokerState struct {
+	BrokerId     ktypes.Int32        `order:"1"`
+	BrokerEpoch  ktypes.Int64        `order:"2"`
+	TaggedFields ktypes.TaggedFields `order:"3"`
+}
+
+type AlterPartitionRequestPartition struct {
+	PartitionIndex      ktypes.Int32                                          `order:"1"`
+	LeaderEpoch         ktypes.Int32                                          `order:"2"`
+	NewIsrWithEpochs    ktypes.CompactArray[AlterPartitionRequestBrokerState] `order:"3"`
+	LeaderRecoveryState ktypes.Int8                                           `order:"4"`
+	PartitionEpoch      ktypes.Int32                                          `order:"5"`
+	TaggedFields        ktypes.TaggedFields                                   `order:"6"`
+}
+
+type AlterPartitionRequestTopic struct {
+	TopicId      ktypes.UUID                                         `order:"1"`
+	Partitions   ktypes.CompactArray[AlterPartitionRequestPartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                 `order:"3"`
+}
+
+type AlterPartitionRequestBody struct {
+	BrokerId     ktypes.Int32                                    `order:"1"`
+	BrokerEpoch  ktypes.Int64                                    `order:"2"`
+	Topics       ktypes.CompactArray[AlterPartitionRequestTopic] `order:"3"`
+	TaggedFields ktypes.TaggedFields                             `order:"4"`
+}
+
+type AlterPartitionResponsePartition struct {
+	PartitionIndex      ktypes.Int32                      `order:"1"`
+	ErrorCode           ERROR_CODE                        `order:"2"`
+	LeaderId            ktypes.Int32                      `order:"3"`
+	LeaderEpoch         ktypes.Int32                      `order:"4"`
+	Isr                 ktypes.CompactArray[ktypes.Int32] `order:"5"`
+	LeaderRecoveryState ktypes.Int8                       `order:"6"`
+	PartitionEpoch      ktypes.Int32                      `order:"7"`
+	TaggedFields        ktypes.TaggedFields               `order:"8"`
+}
+
+type AlterPartitionResponseTopic struct {
+	TopicId      ktypes.UUID                                          `order:"1"`
+	Partitions   ktypes.CompactArray[AlterPartitionResponsePartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                  `order:"3"`
+}
+
+type AlterPartitionResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                     `order:"1"`
+	ErrorCode      ERROR_CODE                                       `order:"2"`
+	Topics         ktypes.CompactArray[AlterPartitionResponseTopic] `order:"3"`
+	TaggedFields   ktypes.TaggedFields                              `order:"4"`
+}
+
+func parseAlterPartitionRequestBody(body []byte) (*AlterPartitionRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody AlterPartitionRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode alter partition request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromAlterPartitionResponseBody(body *AlterPartitionResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode alter partition response: %v", err))
+	}
+	return encoded
+}
+
+// alterPartition applies the ISR a partition leader asks for.
+func alterPartition(brokerId int32, topicId ktypes.UUID, partition *AlterPartitionRequestPartition) AlterPartitionResponsePartition {
+	isr := make([]int32, len(partition.NewIsrWithEpochs))
+	for i, replica := range partition.NewIsrWithEpochs {
+		isr[i] = int32(replica.BrokerId)
+	}
+
+	metadataWriteMu.Lock()
+	defer metadataWriteMu.Unlock()
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	record, err := changePartitionIsr(topicId, int32(partition.PartitionIndex), brokerId, int32(partition.LeaderEpoch), int32(partition.PartitionEpoch), isr)
+	if err != nil {
+		return AlterPartitionResponsePartition{
+			PartitionIndex: partition.PartitionIndex,
+			ErrorCode:      errorCodeFromError(err),
+			LeaderId:       ktypes.Int32(NO_LEADER),
+			LeaderEpoch:    ktypes.Int32(-1),
+			Isr:            []ktypes.Int32{},
+			PartitionEpoch: ktypes.Int32(-1),
+		}
+	}
+	return AlterPartitionResponsePartition{
+		PartitionIndex: partition.PartitionIndex,
+		ErrorCode:      ERROR_CODE_NONE,
+		LeaderId:       record.Leader,
+		LeaderEpoch:    record.LeaderEpoch,
+		Isr:            record.InSyncReplicas,
+		PartitionEpoch: record.PartitionEpoch,
+	}
+}
+
+// handleAlterPartitionRequest changes the ISR of partitions on behalf of
+// their leaders, which the active controller alone can do.
+func handleAlterPartitionRequest(req *Request) *Response {
+	requestBody, err := parseAlterPartitionRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	responseBody := AlterPartitionResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		ErrorCode:      ERROR_CODE_NONE,
+		Topics:         []AlterPartitionResponseTopic{},
+	}
+	switch {
+	case !authorize(req, ACL_OPERATION_CLUSTER_ACTION, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME):
+		responseBody.ErrorCode = ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED
+	case !raftClient.isLeader():
+		responseBody.ErrorCode = ERROR_CODE_NOT_CONTROLLER
+	default:
+		for _, topic := range requestBody.Topics {
+			partitions := make([]AlterPartitionResponsePartition, 0, len(topic.Partitions))
+			for _, partition := range topic.Partitions {
+				partitions = append(partitions, alterPartition(int32(requestBody.BrokerId), topic.TopicId, &partition))
+			}
+			responseBody.Topics = append(responseBody.Topics, AlterPartitionResponseTopic{
+				TopicId:    topic.TopicId,
+				Partitions: partitions,
+			})
+		}
+	}
+
+	res.Body = generateBytesFromAlterPartitionResponseBody(&responseBody)
+	return &res
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type AlterPartitionReassignmentsRequestPartition struct {
+	PartitionIndex ktypes.Int32 `order:"1"`
+	// Null cancels the ongoing reassignment
+	Replicas     ktypes.CompactArray[ktypes.Int32] `order:"2"`
+	TaggedFields ktypes.TaggedFields               `order:"3"`
+}
+
+type AlterPartitionReassignmentsRequestTopic struct {
+	Name         ktypes.CompactString                                             `order:"1"`
+	Partitions   ktypes.CompactArray[AlterPartitionReassignmentsRequestPartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                              `order:"3"`
+}
+
+type AlterPartitionReassignmentsRequestBody struct {
+	TimeoutMs    ktypes.Int32                                                 `order:"1"`
+	Topics       ktypes.CompactArray[AlterPartitionReassignmentsRequestTopic] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                          `order:"3"`
+}
+
+type AlterPartitionReassignmentsResponsePartition struct {
+	PartitionIndex ktypes.Int32                 `order:"1"`
+	ErrorCode      ERROR_CODE                   `order:"2"`
+	ErrorMessage   ktypes.CompactNullableString `order:"3"`
+	TaggedFields   ktypes.TaggedFields          `order:"4"`
+}
+
+type AlterPartitionReassignmentsResponseTopic struct {
+	Name         ktypes.CompactString                                              `order:"1"`
+	Partitions   ktypes.CompactArray[AlterPartitionReassignmentsResponsePartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                               `order:"3"`
+}
+
+type AlterPartitionReassignmentsResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                                  `order:"1"`
+	ErrorCode      ERROR_CODE                                                    `order:"2"`
+	ErrorMessage   ktypes.CompactNullableString                                  `order:"3"`
+	Responses      ktypes.CompactArray[AlterPartitionReassignmentsResponseTopic] `order:"4"`
+	TaggedFields   ktypes.TaggedFields                                           `order:"5"`
+}
+
+func parseAlterPartitionReassignmentsRequestBody(body []byte) (*AlterPartitionReassignmentsRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody AlterPartitionReassignmentsRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode alter partition reassignments request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromAlterPartitionReassignmentsResponseBody(body *AlterPartitionReassignmentsResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode alter partition reassignments response: %v", err))
+	}
+	return encoded
+}
+
+// alterReassignment starts, replaces or cancels the reassignment of a
+// partition.
+func alterReassignment(topicName string, partition *AlterPartitionReassignmentsRequestPartition) AlterPartitionReassignmentsResponsePartition {
+	var target []int32
+	if partition.Replicas != nil {
+		target = toInt32Slice(partition.Replicas)
+	}
+
+	metadataWriteMu.Lock()
+	defer metadataWriteMu.Unlock()
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	response := AlterPartitionReassignmentsResponsePartition{
+		PartitionIndex: partition.PartitionIndex,
+		ErrorCode:      ERROR_CODE_NONE,
+	}
+	if err := alterPartitionReassignment(topicName, int32(partition.PartitionIndex), target); err != nil {
+		response.ErrorCode = errorCodeFromError(err)
+		response.ErrorMessage = ktypes.CompactNullableString(err.Error())
+	}
+	return response
+}
+
+// handleAlterPartitionReassignmentsRequest moves the replicas of partitions
+// to other brokers. Brokers forward it to the active controller.
+func handleAlterPartitionReassignmentsRequest(req *Request) *Response {
+	requestBody, err := parseAlterPartitionReassignmentsRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	responseBody := AlterPartitionReassignmentsResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		ErrorCode:      ERROR_CODE_NONE,
+		Responses:      []AlterPartitionReassignmentsResponseTopic{},
+	}
+	if !authorize(req, ACL_OPERATION_ALTER, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME) {
+		responseBody.ErrorCode = ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED
+	} else {
+		for _, topic := range requestBody.Topics {
+			partitions := make([]AlterPartitionReassignmentsResponsePartition, 0, len(topic.Partitions))
+			for _, partition := range topic.Partitions {
+				partitions = append(partitions, alterReassignment(string(topic.Name), &partition))
+			}
+			responseBody.Responses = append(responseBody.Responses, AlterPartitionReassignmentsResponseTopic{
+				Name:       topic.Name,
+				Partitions: partitions,
+			})
+		}
+	}
+
+	res.Body = generateBytesFromAlterPartitionReassignmentsResponseBody(&responseBody)
+	return &res
+}
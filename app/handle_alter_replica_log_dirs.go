@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type AlterReplicaLogDirsRequestTopic struct {
+	Name         ktypes.CompactString              `order:"1"`
+	Partitions   ktypes.CompactArray[ktypes.Int32] `order:"2"`
+	TaggedFields ktypes.TaggedFields               `order:"3"`
+}
+
+type AlterReplicaLogDirsRequestDir struct {
+	Path         ktypes.CompactString                                 `order:"1"`
+	Topics       ktypes.CompactArray[AlterReplicaLogDirsRequestTopic] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                  `order:"3"`
+}
+
+type AlterReplicaLogDirsRequestBody struct {
+	Dirs         ktypes.CompactArray[AlterReplicaLogDirsRequestDir] `order:"1"`
+	TaggedFields ktypes.TaggedFields                                `order:"2"`
+}
+
+type AlterReplicaLogDirsResponsePartition struct {
+	PartitionIndex ktypes.Int32        `order:"1"`
+	ErrorCode      ERROR_CODE          `order:"2"`
+	TaggedFields   ktypes.TaggedFields `order:"3"`
+}
+
+type AlterReplicaLogDirsResponseResult struct {
+	TopicName    ktypes.CompactString                                      `order:"1"`
+	Partitions   ktypes.CompactArray[AlterReplicaLogDirsResponsePartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                       `order:"3"`
+}
+
+type AlterReplicaLogDirsResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                           `order:"1"`
+	Results        ktypes.CompactArray[AlterReplicaLogDirsResponseResult] `order:"2"`
+	TaggedFields   ktypes.TaggedFields                                    `order:"3"`
+}
+
+func parseAlterReplicaLogDirsRequestBody(body []byte) (*AlterReplicaLogDirsRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody AlterReplicaLogDirsRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode alter replica log dirs request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromAlterReplicaLogDirsResponseBody(body *AlterReplicaLogDirsResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode alter replica log dirs response: %v", err))
+	}
+	return encoded
+}
+
+// alterReplicaLogDir moves this broker's replica of a partition to another
+// log directory, then has the controller record it there. A partition this
+// broker has no replica of yet is created in that directory later.
+func alterReplicaLogDir(topicName string, partition int32, target *LogDirectory) error {
+	partitionRolesMu.Lock()
+	defer partitionRolesMu.Unlock()
+
+	if target.isOffline() {
+		return newKafkaError(ERROR_CODE_KAFKA_STORAGE_ERROR, "log directory %s is offline", target.path)
+	}
+	state, ok := partitionState(topicName, partition)
+	if !ok || (!state.isLeader() && !state.isFollower()) {
+		preferPartitionLogDir(topicName, partition, target)
+		return newKafkaError(ERROR_CODE_NOT_LEADER_OR_FOLLOWER, "broker %d has no replica of %s-%d", brokerConfig.NodeId, topicName, partition)
+	}
+	dir := partitionLogDir(topicName, partition)
+	current := logDirectoryFor(dir)
+	if current.isOffline() {
+		return newKafkaError(ERROR_CODE_KAFKA_STORAGE_ERROR, "log directory %s of %s is offline", current.path, dir)
+	}
+	if current == target {
+		return nil
+	}
+
+	newDir, err := moveReplicaLogDir(topicName, partition, dir, target)
+	if isStorageError(err) {
+		return newKafkaError(ERROR_CODE_KAFKA_STORAGE_ERROR, "%v", err)
+	}
+	if err != nil {
+		return err
+	}
+	if leaderEpoch, ok := partitionRoles[dir]; ok {
+		delete(partitionRoles, dir)
+		partitionRoles[newDir] = leaderEpoch
+	}
+	followerStatesMu.Lock()
+	if followers, ok := followerStates[dir]; ok {
+		delete(followerStates, dir)
+		followerStates[newDir] = followers
+	}
+	followerStatesMu.Unlock()
+	cleanerOffsetsMu.Lock()
+	if offset, ok := cleanerOffsets[dir]; ok {
+		delete(cleanerOffsets, dir)
+		cleanerOffsets[newDir] = offset
+	}
+	cleanerOffsetsMu.Unlock()
+	queueDirectoryAssignment(state.TopicId, partition, target.id)
+	fmt.Println("Moved ", dir, " to ", target.path)
+	return nil
+}
+
+// handleAlterReplicaLogDirsRequest moves replicas of this broker between its
+// log directories.
+func handleAlterReplicaLogDirsRequest(req *Request) *Response {
+	requestBody, err := parseAlterReplicaLogDirsRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	authorized := authorize(req, ACL_OPERATION_ALTER, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME)
+	results := make([]AlterReplicaLogDirsResponseResult, 0)
+	for _, dir := range requestBody.Dirs {
+		target, found := findLogDirectory(string(dir.Path))
+		for _, topic := range dir.Topics {
+			partitions := make([]AlterReplicaLogDirsResponsePartition, 0, len(topic.Partitions))
+			for _, partition := range topic.Partitions {
+				errorCode := ERROR_CODE_NONE
+				switch {
+				case !authorized:
+					errorCode = ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED
+				case !found:
+					errorCode = ERROR_CODE_LOG_DIR_NOT_FOUND
+				default:
+					if _, ok := topicNameToTopicId[string(topic.Name)]; !ok {
+						errorCode = ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION
+					} else {
+						err := alterReplicaLogDir(string(topic.Name), int32(partition), target)
+						errorCode = errorCodeFromError(err)
+						if err != nil && errorCode == ERROR_CODE_UNKNOWN_SERVER_ERROR {
+							fmt.Println("Error moving ", topic.Name, "-", partition, ": ", err.Error())
+						}
+					}
+				}
+				partitions = append(partitions, AlterReplicaLogDirsResponsePartition{PartitionIndex: partition, ErrorCode: errorCode})
+			}
+			results = append(results, AlterReplicaLogDirsResponseResult{TopicName: topic.Name, Partitions: partitions})
+		}
+	}
+
+	responseBody := AlterReplicaLogDirsResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		Results:        results,
+	}
+	res.Body = generateBytesFromAlterReplicaLogDirsResponseBody(&responseBody)
+	return &res
+}
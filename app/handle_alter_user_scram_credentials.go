@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type ScramCredentialDeletionRequest struct {
+	Name         ktypes.CompactString `order:"1"`
+	Mechanism    ktypes.Int8          `order:"2"`
+	TaggedFields ktypes.TaggedFields  `order:"3"`
+}
+
+type ScramCredentialUpsertionRequest struct {
+	Name           ktypes.CompactString `order:"1"`
+	Mechanism      ktypes.Int8          `order:"2"`
+	Iterations     ktypes.Int32         `order:"3"`
+	Salt           ktypes.CompactBytes  `order:"4"`
+	SaltedPassword ktypes.CompactBytes  `order:"5"`
+	TaggedFields   ktypes.TaggedFields  `order:"6"`
+}
+
+type AlterUserScramCredentialsRequestBody struct {
+	Deletions    ktypes.CompactArray[ScramCredentialDeletionRequest]  `order:"1"`
+	Upsertions   ktypes.CompactArray[ScramCredentialUpsertionRequest] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                  `order:"3"`
+}
+
+type AlterUserScramCredentialsResult struct {
+	User         ktypes.CompactString         `order:"1"`
+	ErrorCode    ERROR_CODE                   `order:"2"`
+	ErrorMessage ktypes.CompactNullableString `order:"3"`
+	TaggedFields ktypes.TaggedFields          `order:"4"`
+}
+
+type AlterUserScramCredentialsResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                         `order:"1"`
+	Results        ktypes.CompactArray[AlterUserScramCredentialsResult] `order:"2"`
+	TaggedFields   ktypes.TaggedFields                                  `order:"3"`
+}
+
+func parseAlterUserScramCredentialsRequestBody(body []byte) (*AlterUserScramCredentialsRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody AlterUserScramCredentialsRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode alter user scram credentials request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromAlterUserScramCredentialsResponseBody(body *AlterUserScramCredentialsResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode alter user scram credentials response: %v", err))
+	}
+	return encoded
+}
+
+func handleAlterUserScramCredentialsRequest(req *Request) *Response {
+	requestBody, err := parseAlterUserScramCredentialsRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	// Every user named in the request gets one result, in request order
+	users := make([]string, 0)
+	seen := make(map[string]bool)
+	addUser := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			users = append(users, name)
+		}
+	}
+
+	deletions := make([]ScramCredentialDeletion, 0, len(requestBody.Deletions))
+	for _, deletion := range requestBody.Deletions {
+		addUser(string(deletion.Name))
+		deletions = append(deletions, ScramCredentialDeletion{
+			Name:      string(deletion.Name),
+			Mechanism: int8(deletion.Mechanism),
+		})
+	}
+	upsertions := make([]ScramCredentialUpsertion, 0, len(requestBody.Upsertions))
+	for _, upsertion := range requestBody.Upsertions {
+		addUser(string(upsertion.Name))
+		upsertions = append(upsertions, ScramCredentialUpsertion{
+			Name:           string(upsertion.Name),
+			Mechanism:      int8(upsertion.Mechanism),
+			Iterations:     int32(upsertion.Iterations),
+			Salt:           upsertion.Salt,
+			SaltedPassword: upsertion.SaltedPassword,
+		})
+	}
+
+	var userErrors map[string]*KafkaError
+	if !authorize(req, ACL_OPERATION_ALTER, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME) {
+		userErrors = make(map[string]*KafkaError)
+		for _, user := range users {
+			userErrors[user] = newKafkaError(ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED, "cluster authorization failed")
+		}
+	} else {
+		userErrors, err = alterScramCredentials(deletions, upsertions)
+		if err != nil {
+			fmt.Println("Error altering SCRAM credentials: ", err.Error())
+		}
+	}
+
+	results := make([]AlterUserScramCredentialsResult, 0, len(users))
+	for _, user := range users {
+		result := AlterUserScramCredentialsResult{User: ktypes.CompactString(user)}
+		if userError, ok := userErrors[user]; ok {
+			result.ErrorCode = userError.Code
+			result.ErrorMessage = ktypes.CompactNullableString(userError.Message)
+		} else if err != nil {
+			result.ErrorCode = ERROR_CODE_UNKNOWN_SERVER_ERROR
+		}
+		results = append(results, result)
+	}
+
+	responseBody := AlterUserScramCredentialsResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		Results:        results,
+	}
+
+	res.Body = generateBytesFromAlterUserScramCredentialsResponseBody(&responseBody)
+	return &res
+}
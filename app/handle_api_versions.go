@@ -42,9 +42,46 @@ func handleApiVersionsRequest(req *Request) *Response {
 
 	apiVersions := []SupportedAPIsKType{
 		{ApiKey: ktypes.Int16(API_VERSIONS_REQUEST_KEY), MinAPIVersion: ktypes.Int16(0), MaxAPIVersion: ktypes.Int16(4), ApiName: ktypes.String("ApiVersions")},
+		{ApiKey: ktypes.Int16(METADATA_REQUEST_KEY), MinAPIVersion: ktypes.Int16(12), MaxAPIVersion: ktypes.Int16(12), ApiName: ktypes.String("Metadata")},
+		{ApiKey: ktypes.Int16(LIST_OFFSETS_REQUEST_KEY), MinAPIVersion: ktypes.Int16(7), MaxAPIVersion: ktypes.Int16(7), ApiName: ktypes.String("ListOffsets")},
+		{ApiKey: ktypes.Int16(DELETE_RECORDS_REQUEST_KEY), MinAPIVersion: ktypes.Int16(2), MaxAPIVersion: ktypes.Int16(2), ApiName: ktypes.String("DeleteRecords")},
+		{ApiKey: ktypes.Int16(DESCRIBE_ACLS_REQUEST_KEY), MinAPIVersion: ktypes.Int16(3), MaxAPIVersion: ktypes.Int16(3), ApiName: ktypes.String("DescribeAcls")},
+		{ApiKey: ktypes.Int16(CREATE_ACLS_REQUEST_KEY), MinAPIVersion: ktypes.Int16(3), MaxAPIVersion: ktypes.Int16(3), ApiName: ktypes.String("CreateAcls")},
+		{ApiKey: ktypes.Int16(DELETE_ACLS_REQUEST_KEY), MinAPIVersion: ktypes.Int16(3), MaxAPIVersion: ktypes.Int16(3), ApiName: ktypes.String("DeleteAcls")},
+		{ApiKey: ktypes.Int16(DESCRIBE_CLIENT_QUOTAS_REQUEST_KEY), MinAPIVersion: ktypes.Int16(1), MaxAPIVersion: ktypes.Int16(1), ApiName: ktypes.String("DescribeClientQuotas")},
+		{ApiKey: ktypes.Int16(ALTER_CLIENT_QUOTAS_REQUEST_KEY), MinAPIVersion: ktypes.Int16(1), MaxAPIVersion: ktypes.Int16(1), ApiName: ktypes.String("AlterClientQuotas")},
 		{ApiKey: ktypes.Int16(DESCRIBE_TOPIC_PARTITIONS_REQUEST_KEY), MinAPIVersion: ktypes.Int16(0), MaxAPIVersion: ktypes.Int16(0), ApiName: ktypes.String("DescribeTopicPartitions")},
 		{ApiKey: ktypes.Int16(FETCH_REQUEST_KEY), MinAPIVersion: ktypes.Int16(0), MaxAPIVersion: ktypes.Int16(16), ApiName: ktypes.String("Fetch")},
-		{ApiKey: ktypes.Int16(PRODUCE_REQUEST_KEY), MinAPIVersion: ktypes.Int16(0), MaxAPIVersion: ktypes.Int16(11), ApiName: ktypes.String("Produce")},
+		{ApiKey: ktypes.Int16(PRODUCE_REQUEST_KEY), MinAPIVersion: ktypes.Int16(9), MaxAPIVersion: ktypes.Int16(11), ApiName: ktypes.String("Produce")},
+		{ApiKey: ktypes.Int16(OFFSET_COMMIT_REQUEST_KEY), MinAPIVersion: ktypes.Int16(8), MaxAPIVersion: ktypes.Int16(8), ApiName: ktypes.String("OffsetCommit")},
+		{ApiKey: ktypes.Int16(OFFSET_FETCH_REQUEST_KEY), MinAPIVersion: ktypes.Int16(8), MaxAPIVersion: ktypes.Int16(8), ApiName: ktypes.String("OffsetFetch")},
+		{ApiKey: ktypes.Int16(CONSUMER_GROUP_HEARTBEAT_REQUEST_KEY), MinAPIVersion: ktypes.Int16(0), MaxAPIVersion: ktypes.Int16(0), ApiName: ktypes.String("ConsumerGroupHeartbeat")},
+		{ApiKey: ktypes.Int16(CONSUMER_GROUP_DESCRIBE_REQUEST_KEY), MinAPIVersion: ktypes.Int16(0), MaxAPIVersion: ktypes.Int16(0), ApiName: ktypes.String("ConsumerGroupDescribe")},
+		{ApiKey: ktypes.Int16(INIT_PRODUCER_ID_REQUEST_KEY), MinAPIVersion: ktypes.Int16(4), MaxAPIVersion: ktypes.Int16(4), ApiName: ktypes.String("InitProducerId")},
+		{ApiKey: ktypes.Int16(ADD_PARTITIONS_TO_TXN_REQUEST_KEY), MinAPIVersion: ktypes.Int16(3), MaxAPIVersion: ktypes.Int16(3), ApiName: ktypes.String("AddPartitionsToTxn")},
+		{ApiKey: ktypes.Int16(ADD_OFFSETS_TO_TXN_REQUEST_KEY), MinAPIVersion: ktypes.Int16(3), MaxAPIVersion: ktypes.Int16(3), ApiName: ktypes.String("AddOffsetsToTxn")},
+		{ApiKey: ktypes.Int16(END_TXN_REQUEST_KEY), MinAPIVersion: ktypes.Int16(3), MaxAPIVersion: ktypes.Int16(3), ApiName: ktypes.String("EndTxn")},
+		{ApiKey: ktypes.Int16(TXN_OFFSET_COMMIT_REQUEST_KEY), MinAPIVersion: ktypes.Int16(3), MaxAPIVersion: ktypes.Int16(3), ApiName: ktypes.String("TxnOffsetCommit")},
+		{ApiKey: ktypes.Int16(SASL_HANDSHAKE_REQUEST_KEY), MinAPIVersion: ktypes.Int16(1), MaxAPIVersion: ktypes.Int16(1), ApiName: ktypes.String("SaslHandshake")},
+		{ApiKey: ktypes.Int16(SASL_AUTHENTICATE_REQUEST_KEY), MinAPIVersion: ktypes.Int16(2), MaxAPIVersion: ktypes.Int16(2), ApiName: ktypes.String("SaslAuthenticate")},
+		{ApiKey: ktypes.Int16(DESCRIBE_USER_SCRAM_CREDENTIALS_REQUEST_KEY), MinAPIVersion: ktypes.Int16(0), MaxAPIVersion: ktypes.Int16(0), ApiName: ktypes.String("DescribeUserScramCredentials")},
+		{ApiKey: ktypes.Int16(ALTER_USER_SCRAM_CREDENTIALS_REQUEST_KEY), MinAPIVersion: ktypes.Int16(0), MaxAPIVersion: ktypes.Int16(0), ApiName: ktypes.String("AlterUserScramCredentials")},
+		{ApiKey: ktypes.Int16(OFFSET_FOR_LEADER_EPOCH_REQUEST_KEY), MinAPIVersion: ktypes.Int16(4), MaxAPIVersion: ktypes.Int16(4), ApiName: ktypes.String("OffsetForLeaderEpoch")},
+		{ApiKey: ktypes.Int16(VOTE_REQUEST_KEY), MinAPIVersion: ktypes.Int16(0), MaxAPIVersion: ktypes.Int16(0), ApiName: ktypes.String("Vote")},
+		{ApiKey: ktypes.Int16(BEGIN_QUORUM_EPOCH_REQUEST_KEY), MinAPIVersion: ktypes.Int16(1), MaxAPIVersion: ktypes.Int16(1), ApiName: ktypes.String("BeginQuorumEpoch")},
+		{ApiKey: ktypes.Int16(END_QUORUM_EPOCH_REQUEST_KEY), MinAPIVersion: ktypes.Int16(1), MaxAPIVersion: ktypes.Int16(1), ApiName: ktypes.String("EndQuorumEpoch")},
+		{ApiKey: ktypes.Int16(ALTER_PARTITION_REQUEST_KEY), MinAPIVersion: ktypes.Int16(3), MaxAPIVersion: ktypes.Int16(3), ApiName: ktypes.String("AlterPartition")},
+		{ApiKey: ktypes.Int16(ENVELOPE_REQUEST_KEY), MinAPIVersion: ktypes.Int16(0), MaxAPIVersion: ktypes.Int16(0), ApiName: ktypes.String("Envelope")},
+		{ApiKey: ktypes.Int16(FETCH_SNAPSHOT_REQUEST_KEY), MinAPIVersion: ktypes.Int16(0), MaxAPIVersion: ktypes.Int16(0), ApiName: ktypes.String("FetchSnapshot")},
+		{ApiKey: ktypes.Int16(ALLOCATE_PRODUCER_IDS_REQUEST_KEY), MinAPIVersion: ktypes.Int16(0), MaxAPIVersion: ktypes.Int16(0), ApiName: ktypes.String("AllocateProducerIds")},
+		{ApiKey: ktypes.Int16(BROKER_REGISTRATION_REQUEST_KEY), MinAPIVersion: ktypes.Int16(3), MaxAPIVersion: ktypes.Int16(3), ApiName: ktypes.String("BrokerRegistration")},
+		{ApiKey: ktypes.Int16(BROKER_HEARTBEAT_REQUEST_KEY), MinAPIVersion: ktypes.Int16(0), MaxAPIVersion: ktypes.Int16(1), ApiName: ktypes.String("BrokerHeartbeat")},
+		{ApiKey: ktypes.Int16(ALTER_PARTITION_REASSIGNMENTS_REQUEST_KEY), MinAPIVersion: ktypes.Int16(0), MaxAPIVersion: ktypes.Int16(0), ApiName: ktypes.String("AlterPartitionReassignments")},
+		{ApiKey: ktypes.Int16(LIST_PARTITION_REASSIGNMENTS_REQUEST_KEY), MinAPIVersion: ktypes.Int16(0), MaxAPIVersion: ktypes.Int16(0), ApiName: ktypes.String("ListPartitionReassignments")},
+		{ApiKey: ktypes.Int16(ELECT_LEADERS_REQUEST_KEY), MinAPIVersion: ktypes.Int16(2), MaxAPIVersion: ktypes.Int16(2), ApiName: ktypes.String("ElectLeaders")},
+		{ApiKey: ktypes.Int16(ALTER_REPLICA_LOG_DIRS_REQUEST_KEY), MinAPIVersion: ktypes.Int16(2), MaxAPIVersion: ktypes.Int16(2), ApiName: ktypes.String("AlterReplicaLogDirs")},
+		{ApiKey: ktypes.Int16(DESCRIBE_LOG_DIRS_REQUEST_KEY), MinAPIVersion: ktypes.Int16(4), MaxAPIVersion: ktypes.Int16(4), ApiName: ktypes.String("DescribeLogDirs")},
+		{ApiKey: ktypes.Int16(ASSIGN_REPLICAS_TO_DIRS_REQUEST_KEY), MinAPIVersion: ktypes.Int16(0), MaxAPIVersion: ktypes.Int16(0), ApiName: ktypes.String("AssignReplicasToDirs")},
 	}
 
 	responseBody := ApiVersionsResponseBody{
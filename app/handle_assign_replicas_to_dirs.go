@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const ASSIGN_REPLICAS_TO_DIRS_VERSION = 0
+
+type AssignReplicasToDirsRequestPartition struct {
+	PartitionIndex ktypes.Int32        `order:"1"`
+	TaggedFields   ktypes.TaggedFields `order:"2"`
+}
+
+type AssignReplicasToDirsRequestTopic struct {
+	TopicId      ktypes.UUID                                               `order:"1"`
+	Partitions   ktypes.CompactArray[AssignReplicasToDirsRequestPartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                       `order:"3"`
+}
+
+type AssignReplicasToDirsRequestDirectory struct {
+	Id           ktypes.UUID                                           `order:"1"`
+	Topics       ktypes.CompactArray[AssignReplicasToDirsRequestTopic] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                   `order:"3"`
+}
+
+type AssignReplicasToDirsRequestBody struct {
+	ClusterId    ktypes.CompactString                                      `order:"1"`
+	BrokerId     ktypes.Int32                                              `order:"2"`
+	BrokerEpoch  ktypes.Int64                                              `order:"3"`
+	Directories  ktypes.CompactArray[AssignReplicasToDirsRequestDirectory] `order:"4"`
+	TaggedFields ktypes.TaggedFields                                       `order:"5"`
+}
+
+type AssignReplicasToDirsResponsePartition struct {
+	PartitionIndex ktypes.Int32        `order:"1"`
+	ErrorCode      ERROR_CODE          `order:"2"`
+	TaggedFields   ktypes.TaggedFields `order:"3"`
+}
+
+type AssignReplicasToDirsResponseTopic struct {
+	TopicId      ktypes.UUID                                                `order:"1"`
+	Partitions   ktypes.CompactArray[AssignReplicasToDirsResponsePartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                        `order:"3"`
+}
+
+type AssignReplicasToDirsResponseDirectory struct {
+	Id           ktypes.UUID                                            `order:"1"`
+	Topics       ktypes.CompactArray[AssignReplicasToDirsResponseTopic] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                    `order:"3"`
+}
+
+type AssignReplicasToDirsResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                               `order:"1"`
+	ErrorCode      ERROR_CODE                                                 `order:"2"`
+	Directories    ktypes.CompactArray[AssignReplicasToDirsResponseDirectory] `order:"3"`
+	TaggedFields   ktypes.TaggedFields                                        `order:"4"`
+}
+
+func parseAssignReplicasToDirsRequestBody(body []byte) (*AssignReplicasToDirsRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody AssignReplicasToDirsRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode assign replicas to dirs request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromAssignReplicasToDirsResponseBody(body *AssignReplicasToDirsResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode assign replicas to dirs response: %v", err))
+	}
+	return encoded
+}
+
+// assignReplicasToDirs records the log directories holding the replicas of
+// a broker in the metadata of their partitions.
+func assignReplicasToDirs(request *AssignReplicasToDirsRequestBody) ([]AssignReplicasToDirsResponseDirectory, error) {
+	brokerId := int32(request.BrokerId)
+
+	metadataWriteMu.Lock()
+	defer metadataWriteMu.Unlock()
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	registration, ok := brokerRegistrations[brokerId]
+	if !ok {
+		return nil, newKafkaError(ERROR_CODE_BROKER_ID_NOT_REGISTERED, "broker %d is not registered", brokerId)
+	}
+	if registration.Epoch != int64(request.BrokerEpoch) {
+		return nil, newKafkaError(ERROR_CODE_STALE_BROKER_EPOCH, "broker epoch %d of broker %d is not %d", request.BrokerEpoch, brokerId, registration.Epoch)
+	}
+
+	results := make([]AssignReplicasToDirsResponseDirectory, 0, len(request.Directories))
+	for _, directory := range request.Directories {
+		topics := make([]AssignReplicasToDirsResponseTopic, 0, len(directory.Topics))
+		for _, topic := range directory.Topics {
+			partitions := make([]AssignReplicasToDirsResponsePartition, 0, len(topic.Partitions))
+			for _, partition := range topic.Partitions {
+				err := assignReplicaToDir(topic.TopicId, int32(partition.PartitionIndex), brokerId, directory.Id)
+				partitions = append(partitions, AssignReplicasToDirsResponsePartition{
+					PartitionIndex: partition.PartitionIndex,
+					ErrorCode:      errorCodeFromError(err),
+				})
+			}
+			topics = append(topics, AssignReplicasToDirsResponseTopic{TopicId: topic.TopicId, Partitions: partitions})
+		}
+		results = append(results, AssignReplicasToDirsResponseDirectory{Id: directory.Id, Topics: topics})
+	}
+	return results, nil
+}
+
+// assignReplicaToDir sets the log directory of a broker's replica of a
+// partition. Callers hold metadataWriteMu and metadataMu.
+func assignReplicaToDir(topicId ktypes.UUID, partitionId int32, brokerId int32, directoryId ktypes.UUID) error {
+	if _, ok := topicIdToTopicName[topicId]; !ok {
+		return newKafkaError(ERROR_CODE_UNKNOWN_TOPIC_ID, "unknown topic id %s", topicId)
+	}
+	partition, ok := partitionRecordFor(topicId, partitionId)
+	if !ok {
+		return newKafkaError(ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION, "unknown partition %d of topic %s", partitionId, topicId)
+	}
+	i := slices.Index(toInt32Slice(partition.Replicas), brokerId)
+	if i < 0 {
+		return newKafkaError(ERROR_CODE_NOT_LEADER_OR_FOLLOWER, "broker %d is not a replica of partition %d of topic %s", brokerId, partitionId, topicId)
+	}
+	if replicaDirectory(partition, brokerId) == directoryId {
+		return nil
+	}
+
+	directories := make([]ktypes.UUID, len(partition.Replicas))
+	for j := range directories {
+		directories[j] = replicaDirectory(partition, int32(partition.Replicas[j]))
+	}
+	directories[i] = directoryId
+	return appendMetadataRecord(&PartitionChangeRecordValue{
+		Header: RecordValueHeader{
+			FrameVersion: ktypes.Int8(METADATA_RECORD_FRAME_VERSION),
+			RecordType:   ktypes.Int8(PARTITION_CHANGE_RECORD_TYPE),
+		},
+		PartitionId: ktypes.Int32(partitionId),
+		TopicId:     topicId,
+		TaggedFields: ktypes.TaggedFieldValues{
+			PARTITION_CHANGE_DIRECTORIES_TAG: newDirectoryIdListField(directories),
+		},
+	})
+}
+
+// handleAssignReplicasToDirsRequest records where brokers keep their
+// replicas, which the active controller alone can do.
+func handleAssignReplicasToDirsRequest(req *Request) *Response {
+	requestBody, err := parseAssignReplicasToDirsRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	responseBody := AssignReplicasToDirsResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		ErrorCode:      ERROR_CODE_NONE,
+		Directories:    []AssignReplicasToDirsResponseDirectory{},
+	}
+	switch {
+	case !authorize(req, ACL_OPERATION_CLUSTER_ACTION, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME):
+		responseBody.ErrorCode = ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED
+	case !raftClient.isLeader():
+		responseBody.ErrorCode = ERROR_CODE_NOT_CONTROLLER
+	default:
+		directories, err := assignReplicasToDirs(requestBody)
+		responseBody.ErrorCode = errorCodeFromError(err)
+		if err == nil {
+			responseBody.Directories = directories
+		}
+	}
+
+	res.Body = generateBytesFromAssignReplicasToDirsResponseBody(&responseBody)
+	return &res
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type BeginQuorumEpochRequestPartition struct {
+	PartitionIndex   ktypes.Int32        `order:"1"`
+	VoterDirectoryId ktypes.UUID         `order:"2"`
+	LeaderId         ktypes.Int32        `order:"3"`
+	LeaderEpoch      ktypes.Int32        `order:"4"`
+	TaggedFields     ktypes.TaggedFields `order:"5"`
+}
+
+type BeginQuorumEpochRequestTopic struct {
+	TopicName    ktypes.CompactString                                  `order:"1"`
+	Partitions   ktypes.CompactArray[BeginQuorumEpochRequestPartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                   `order:"3"`
+}
+
+// Listener a leader serves the quorum on
+type QuorumLeaderEndpoint struct {
+	Name         ktypes.CompactString `order:"1"`
+	Host         ktypes.CompactString `order:"2"`
+	Port         ktypes.Uint16        `order:"3"`
+	TaggedFields ktypes.TaggedFields  `order:"4"`
+}
+
+type BeginQuorumEpochRequestBody struct {
+	ClusterId       ktypes.CompactNullableString                      `order:"1"`
+	VoterId         ktypes.Int32                                      `order:"2"`
+	Topics          ktypes.CompactArray[BeginQuorumEpochRequestTopic] `order:"3"`
+	LeaderEndpoints ktypes.CompactArray[QuorumLeaderEndpoint]         `order:"4"`
+	TaggedFields    ktypes.TaggedFields                               `order:"5"`
+}
+
+type BeginQuorumEpochResponsePartition struct {
+	PartitionIndex ktypes.Int32        `order:"1"`
+	ErrorCode      ERROR_CODE          `order:"2"`
+	LeaderId       ktypes.Int32        `order:"3"`
+	LeaderEpoch    ktypes.Int32        `order:"4"`
+	TaggedFields   ktypes.TaggedFields `order:"5"`
+}
+
+type BeginQuorumEpochResponseTopic struct {
+	TopicName    ktypes.CompactString                                   `order:"1"`
+	Partitions   ktypes.CompactArray[BeginQuorumEpochResponsePartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                    `order:"3"`
+}
+
+type BeginQuorumEpochResponseBody struct {
+	ErrorCode    ERROR_CODE                                         `order:"1"`
+	Topics       ktypes.CompactArray[BeginQuorumEpochResponseTopic] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                `order:"3"`
+}
+
+func parseBeginQuorumEpochRequestBody(body []byte) (*BeginQuorumEpochRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody BeginQuorumEpochRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode begin quorum epoch request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromBeginQuorumEpochResponseBody(body *BeginQuorumEpochResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode begin quorum epoch response: %v", err))
+	}
+	return encoded
+}
+
+func handleBeginQuorumEpochRequest(req *Request) *Response {
+	requestBody, err := parseBeginQuorumEpochRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	responseBody := BeginQuorumEpochResponseBody{
+		ErrorCode: validateQuorumRequest(req, requestBody.ClusterId),
+		Topics:    []BeginQuorumEpochResponseTopic{},
+	}
+	if responseBody.ErrorCode == ERROR_CODE_NONE && int32(requestBody.VoterId) != int32(brokerConfig.NodeId) {
+		responseBody.ErrorCode = ERROR_CODE_INCONSISTENT_VOTER_SET
+	}
+	if responseBody.ErrorCode == ERROR_CODE_NONE {
+		for _, topic := range requestBody.Topics {
+			partitions := make([]BeginQuorumEpochResponsePartition, 0, len(topic.Partitions))
+			for _, partition := range topic.Partitions {
+				errorCode := ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION
+				if string(topic.TopicName) == METADATA_TOPIC && partition.PartitionIndex == 0 {
+					errorCode = raftClient.handleBeginQuorumEpoch(int32(partition.LeaderId), int32(partition.LeaderEpoch))
+				}
+				leaderId, epoch := raftClient.currentLeader()
+				partitions = append(partitions, BeginQuorumEpochResponsePartition{
+					PartitionIndex: partition.PartitionIndex,
+					ErrorCode:      errorCode,
+					LeaderId:       ktypes.Int32(leaderId),
+					LeaderEpoch:    ktypes.Int32(epoch),
+				})
+			}
+			responseBody.Topics = append(responseBody.Topics, BeginQuorumEpochResponseTopic{
+				TopicName:  topic.TopicName,
+				Partitions: partitions,
+			})
+		}
+	}
+
+	res.Body = generateBytesFromBeginQuorumEpochResponseBody(&responseBody)
+	return &res
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	BROKER_HEARTBEAT_VERSION = 1
+
+	BROKER_HEARTBEAT_OFFLINE_LOG_DIRS_TAG = 0
+)
+
+// Request of version 1, listing the offline log directories of the broker
+// in a tagged field
+type BrokerHeartbeatRequestBody struct {
+	BrokerId              ktypes.Int32             `order:"1"`
+	BrokerEpoch           ktypes.Int64             `order:"2"`
+	CurrentMetadataOffset ktypes.Int64             `order:"3"`
+	WantFence             ktypes.Bool              `order:"4"`
+	WantShutDown          ktypes.Bool              `order:"5"`
+	TaggedFields          ktypes.TaggedFieldValues `order:"6"`
+}
+
+type BrokerHeartbeatResponseBody struct {
+	ThrottleTimeMs ktypes.Int32        `order:"1"`
+	ErrorCode      ERROR_CODE          `order:"2"`
+	IsCaughtUp     ktypes.Bool         `order:"3"`
+	IsFenced       ktypes.Bool         `order:"4"`
+	ShouldShutDown ktypes.Bool         `order:"5"`
+	TaggedFields   ktypes.TaggedFields `order:"6"`
+}
+
+func parseBrokerHeartbeatRequestBody(body []byte) (*BrokerHeartbeatRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody BrokerHeartbeatRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode broker heartbeat request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromBrokerHeartbeatResponseBody(body *BrokerHeartbeatResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode broker heartbeat response: %v", err))
+	}
+	return encoded
+}
+
+// handleBrokerHeartbeatRequest keeps the session of a registered broker
+// alive, which the active controller alone tracks.
+func handleBrokerHeartbeatRequest(req *Request) *Response {
+	requestBody, err := parseBrokerHeartbeatRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	responseBody := BrokerHeartbeatResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		ErrorCode:      ERROR_CODE_NONE,
+		IsFenced:       true,
+	}
+	switch {
+	case !authorize(req, ACL_OPERATION_CLUSTER_ACTION, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME):
+		responseBody.ErrorCode = ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED
+	case !raftClient.isLeader():
+		responseBody.ErrorCode = ERROR_CODE_NOT_CONTROLLER
+	default:
+		result, err := processBrokerHeartbeat(requestBody)
+		responseBody.ErrorCode = errorCodeFromError(err)
+		if err == nil {
+			responseBody.IsCaughtUp = ktypes.Bool(result.IsCaughtUp)
+			responseBody.IsFenced = ktypes.Bool(result.IsFenced)
+			responseBody.ShouldShutDown = ktypes.Bool(result.ShouldShutDown)
+		}
+	}
+
+	res.Body = generateBytesFromBrokerHeartbeatResponseBody(&responseBody)
+	return &res
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const BROKER_REGISTRATION_VERSION = 3
+
+type BrokerRegistrationRequestListener struct {
+	Name             ktypes.CompactString `order:"1"`
+	Host             ktypes.CompactString `order:"2"`
+	Port             ktypes.Uint16        `order:"3"`
+	SecurityProtocol ktypes.Int16         `order:"4"`
+	TaggedFields     ktypes.TaggedFields  `order:"5"`
+}
+
+type BrokerRegistrationRequestFeature struct {
+	Name                ktypes.CompactString `order:"1"`
+	MinSupportedVersion ktypes.Int16         `order:"2"`
+	MaxSupportedVersion ktypes.Int16         `order:"3"`
+	TaggedFields        ktypes.TaggedFields  `order:"4"`
+}
+
+type BrokerRegistrationRequestBody struct {
+	BrokerId            ktypes.Int32                                           `order:"1"`
+	ClusterId           ktypes.CompactString                                   `order:"2"`
+	IncarnationId       ktypes.UUID                                            `order:"3"`
+	Listeners           ktypes.CompactArray[BrokerRegistrationRequestListener] `order:"4"`
+	Features            ktypes.CompactArray[BrokerRegistrationRequestFeature]  `order:"5"`
+	Rack                ktypes.CompactNullableString                           `order:"6"`
+	IsMigratingZkBroker ktypes.Bool                                            `order:"7"`
+	LogDirs             ktypes.CompactArray[ktypes.UUID]                       `order:"8"`
+	PreviousBrokerEpoch ktypes.Int64                                           `order:"9"`
+	TaggedFields        ktypes.TaggedFields                                    `order:"10"`
+}
+
+type BrokerRegistrationResponseBody struct {
+	ThrottleTimeMs ktypes.Int32        `order:"1"`
+	ErrorCode      ERROR_CODE          `order:"2"`
+	BrokerEpoch    ktypes.Int64        `order:"3"`
+	TaggedFields   ktypes.TaggedFields `order:"4"`
+}
+
+func parseBrokerRegistrationRequestBody(body []byte) (*BrokerRegistrationRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody BrokerRegistrationRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode broker registration request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromBrokerRegistrationResponseBody(body *BrokerRegistrationResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode broker registration response: %v", err))
+	}
+	return encoded
+}
+
+// handleBrokerRegistrationRequest registers a starting broker, which the
+// active controller alone can do.
+func handleBrokerRegistrationRequest(req *Request) *Response {
+	requestBody, err := parseBrokerRegistrationRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	responseBody := BrokerRegistrationResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		ErrorCode:      ERROR_CODE_NONE,
+		BrokerEpoch:    ktypes.Int64(-1),
+	}
+	switch {
+	case !authorize(req, ACL_OPERATION_CLUSTER_ACTION, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME):
+		responseBody.ErrorCode = ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED
+	case !raftClient.isLeader():
+		responseBody.ErrorCode = ERROR_CODE_NOT_CONTROLLER
+	default:
+		brokerEpoch, err := registerBroker(requestBody)
+		responseBody.ErrorCode = errorCodeFromError(err)
+		if err == nil {
+			responseBody.BrokerEpoch = ktypes.Int64(brokerEpoch)
+		}
+	}
+
+	res.Body = generateBytesFromBrokerRegistrationResponseBody(&responseBody)
+	return &res
+}
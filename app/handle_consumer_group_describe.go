@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type ConsumerGroupDescribeRequestBody struct {
+	GroupIds                    ktypes.CompactArray[ktypes.CompactString] `order:"1"`
+	IncludeAuthorizedOperations ktypes.Bool                               `order:"2"`
+	TaggedFields                ktypes.TaggedFields                       `order:"3"`
+}
+
+type ConsumerGroupDescribeTopicPartitions struct {
+	TopicId      ktypes.UUID                       `order:"1"`
+	TopicName    ktypes.CompactString              `order:"2"`
+	Partitions   ktypes.CompactArray[ktypes.Int32] `order:"3"`
+	TaggedFields ktypes.TaggedFields               `order:"4"`
+}
+
+type ConsumerGroupDescribeAssignment struct {
+	TopicPartitions ktypes.CompactArray[ConsumerGroupDescribeTopicPartitions] `order:"1"`
+	TaggedFields    ktypes.TaggedFields                                       `order:"2"`
+}
+
+type ConsumerGroupDescribeMember struct {
+	MemberId             ktypes.CompactString                      `order:"1"`
+	InstanceId           ktypes.CompactNullableString              `order:"2"`
+	RackId               ktypes.CompactNullableString              `order:"3"`
+	MemberEpoch          ktypes.Int32                              `order:"4"`
+	ClientId             ktypes.CompactString                      `order:"5"`
+	ClientHost           ktypes.CompactString                      `order:"6"`
+	SubscribedTopicNames ktypes.CompactArray[ktypes.CompactString] `order:"7"`
+	SubscribedTopicRegex ktypes.CompactNullableString              `order:"8"`
+	Assignment           ConsumerGroupDescribeAssignment           `order:"9"`
+	TargetAssignment     ConsumerGroupDescribeAssignment           `order:"10"`
+	TaggedFields         ktypes.TaggedFields                       `order:"11"`
+}
+
+type ConsumerGroupDescribeGroup struct {
+	ErrorCode            ERROR_CODE                                       `order:"1"`
+	ErrorMessage         ktypes.CompactNullableString                     `order:"2"`
+	GroupId              ktypes.CompactString                             `order:"3"`
+	GroupState           ktypes.CompactString                             `order:"4"`
+	GroupEpoch           ktypes.Int32                                     `order:"5"`
+	AssignmentEpoch      ktypes.Int32                                     `order:"6"`
+	AssignorName         ktypes.CompactString                             `order:"7"`
+	Members              ktypes.CompactArray[ConsumerGroupDescribeMember] `order:"8"`
+	AuthorizedOperations ktypes.Int32                                     `order:"9"`
+	TaggedFields         ktypes.TaggedFields                              `order:"10"`
+}
+
+type ConsumerGroupDescribeResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                    `order:"1"`
+	Groups         ktypes.CompactArray[ConsumerGroupDescribeGroup] `order:"2"`
+	TaggedFields   ktypes.TaggedFields                             `order:"3"`
+}
+
+func parseConsumerGroupDescribeRequestBody(body []byte) (*ConsumerGroupDescribeRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody ConsumerGroupDescribeRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode consumer group describe request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromConsumerGroupDescribeResponseBody(body *ConsumerGroupDescribeResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode consumer group describe response: %v", err))
+	}
+	return encoded
+}
+
+func describeAssignment(topicPartitions TopicPartitions) ConsumerGroupDescribeAssignment {
+	assignment := ConsumerGroupDescribeAssignment{
+		TopicPartitions: []ConsumerGroupDescribeTopicPartitions{},
+	}
+	for _, topicId := range topicPartitions.sortedTopicIds() {
+		partitions := make([]ktypes.Int32, len(topicPartitions[topicId]))
+		for i, partition := range topicPartitions[topicId] {
+			partitions[i] = ktypes.Int32(partition)
+		}
+		assignment.TopicPartitions = append(assignment.TopicPartitions, ConsumerGroupDescribeTopicPartitions{
+			TopicId:    topicId,
+			TopicName:  ktypes.CompactString(topicIdToTopicName[topicId]),
+			Partitions: partitions,
+		})
+	}
+	return assignment
+}
+
+// describeConsumerGroup snapshots a single group under consumerGroupsMu.
+func describeConsumerGroup(groupId string) ConsumerGroupDescribeGroup {
+	consumerGroupsMu.Lock()
+	defer consumerGroupsMu.Unlock()
+
+	described := ConsumerGroupDescribeGroup{
+		GroupId:              ktypes.CompactString(groupId),
+		Members:              []ConsumerGroupDescribeMember{},
+		AuthorizedOperations: ktypes.Int32(math.MinInt32), // not requested
+	}
+
+	group, ok := consumerGroups[groupId]
+	if !ok {
+		described.ErrorCode = ERROR_CODE_GROUP_ID_NOT_FOUND
+		described.ErrorMessage = ktypes.CompactNullableString(fmt.Sprintf("Group %s not found.", groupId))
+		return described
+	}
+
+	described.GroupState = ktypes.CompactString(group.state())
+	described.GroupEpoch = ktypes.Int32(group.GroupEpoch)
+	described.AssignmentEpoch = ktypes.Int32(group.AssignmentEpoch)
+	described.AssignorName = ktypes.CompactString(group.assignorName())
+	for _, memberId := range group.sortedMemberIds() {
+		member := group.Members[memberId]
+		subscribedTopicNames := make([]ktypes.CompactString, len(member.SubscribedTopicNames))
+		for i, topicName := range member.SubscribedTopicNames {
+			subscribedTopicNames[i] = ktypes.CompactString(topicName)
+		}
+		described.Members = append(described.Members, ConsumerGroupDescribeMember{
+			MemberId:             ktypes.CompactString(member.MemberId),
+			InstanceId:           ktypes.CompactNullableString(member.InstanceId),
+			RackId:               ktypes.CompactNullableString(member.RackId),
+			MemberEpoch:          ktypes.Int32(member.MemberEpoch),
+			ClientId:             ktypes.CompactString(member.ClientId),
+			ClientHost:           ktypes.CompactString(member.ClientHost),
+			SubscribedTopicNames: subscribedTopicNames,
+			Assignment:           describeAssignment(member.AssignedPartitions),
+			TargetAssignment:     describeAssignment(group.TargetAssignment[memberId]),
+		})
+	}
+
+	return described
+}
+
+func handleConsumerGroupDescribeRequest(req *Request) *Response {
+	requestBody, err := parseConsumerGroupDescribeRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	groups := make([]ConsumerGroupDescribeGroup, len(requestBody.GroupIds))
+	for i, groupId := range requestBody.GroupIds {
+		if !authorize(req, ACL_OPERATION_DESCRIBE, RESOURCE_TYPE_GROUP, string(groupId)) {
+			groups[i] = ConsumerGroupDescribeGroup{
+				ErrorCode:            ERROR_CODE_GROUP_AUTHORIZATION_FAILED,
+				GroupId:              groupId,
+				Members:              []ConsumerGroupDescribeMember{},
+				AuthorizedOperations: ktypes.Int32(math.MinInt32),
+			}
+			continue
+		}
+		groups[i] = describeConsumerGroup(string(groupId))
+		if requestBody.IncludeAuthorizedOperations && groups[i].ErrorCode == ERROR_CODE_NONE {
+			groups[i].AuthorizedOperations = ktypes.Int32(authorizedOperations(req, RESOURCE_TYPE_GROUP, string(groupId)))
+		}
+	}
+
+	responseBody := ConsumerGroupDescribeResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		Groups:         groups,
+	}
+
+	res.Body = generateBytesFromConsumerGroupDescribeResponseBody(&responseBody)
+	return &res
+}
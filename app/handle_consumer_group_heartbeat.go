@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type ConsumerGroupHeartbeatTopicPartitions struct {
+	TopicId      ktypes.UUID                       `order:"1"`
+	Partitions   ktypes.CompactArray[ktypes.Int32] `order:"2"`
+	TaggedFields ktypes.TaggedFields               `order:"3"`
+}
+
+type ConsumerGroupHeartbeatRequestBody struct {
+	GroupId              ktypes.CompactString                                       `order:"1"`
+	MemberId             ktypes.CompactString                                       `order:"2"`
+	MemberEpoch          ktypes.Int32                                               `order:"3"`
+	InstanceId           ktypes.CompactNullableString                               `order:"4"`
+	RackId               ktypes.CompactNullableString                               `order:"5"`
+	RebalanceTimeoutMs   ktypes.Int32                                               `order:"6"`
+	SubscribedTopicNames ktypes.CompactArray[ktypes.CompactString]                  `order:"7"`
+	ServerAssignor       ktypes.CompactNullableString                               `order:"8"`
+	TopicPartitions      ktypes.CompactArray[ConsumerGroupHeartbeatTopicPartitions] `order:"9"`
+	TaggedFields         ktypes.TaggedFields                                        `order:"10"`
+}
+
+type ConsumerGroupHeartbeatAssignment struct {
+	TopicPartitions ktypes.CompactArray[ConsumerGroupHeartbeatTopicPartitions] `order:"1"`
+	TaggedFields    ktypes.TaggedFields                                        `order:"2"`
+}
+
+type ConsumerGroupHeartbeatResponseBody struct {
+	ThrottleTimeMs      ktypes.Int32                                            `order:"1"`
+	ErrorCode           ERROR_CODE                                              `order:"2"`
+	ErrorMessage        ktypes.CompactNullableString                            `order:"3"`
+	MemberId            ktypes.CompactNullableString                            `order:"4"`
+	MemberEpoch         ktypes.Int32                                            `order:"5"`
+	HeartbeatIntervalMs ktypes.Int32                                            `order:"6"`
+	Assignment          ktypes.NullableStruct[ConsumerGroupHeartbeatAssignment] `order:"7"`
+	TaggedFields        ktypes.TaggedFields                                     `order:"8"`
+}
+
+func parseConsumerGroupHeartbeatRequestBody(body []byte) (*ConsumerGroupHeartbeatRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody ConsumerGroupHeartbeatRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode consumer group heartbeat request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromConsumerGroupHeartbeatResponseBody(body *ConsumerGroupHeartbeatResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode consumer group heartbeat response: %v", err))
+	}
+	return encoded
+}
+
+// validateConsumerGroupHeartbeat checks the fields required for the member epoch sent.
+func validateConsumerGroupHeartbeat(requestBody *ConsumerGroupHeartbeatRequestBody) (ERROR_CODE, string) {
+	if requestBody.GroupId == "" {
+		return ERROR_CODE_INVALID_REQUEST, "GroupId can't be empty."
+	}
+	if requestBody.MemberEpoch > 0 && requestBody.MemberId == "" {
+		return ERROR_CODE_INVALID_REQUEST, "MemberId can't be empty."
+	}
+	if requestBody.MemberEpoch == MEMBER_EPOCH_JOIN {
+		if requestBody.RebalanceTimeoutMs == -1 {
+			return ERROR_CODE_INVALID_REQUEST, "RebalanceTimeoutMs must be provided in first request."
+		}
+		if requestBody.TopicPartitions == nil || len(requestBody.TopicPartitions) > 0 {
+			return ERROR_CODE_INVALID_REQUEST, "TopicPartitions must be empty when (re-)joining."
+		}
+		if requestBody.SubscribedTopicNames == nil {
+			return ERROR_CODE_INVALID_REQUEST, "SubscribedTopicNames must be set in first request."
+		}
+	}
+	if requestBody.MemberEpoch < MEMBER_EPOCH_STATIC_LEAVE {
+		return ERROR_CODE_INVALID_REQUEST, "MemberEpoch is invalid."
+	}
+	if requestBody.ServerAssignor != "" {
+		if _, ok := consumerGroupAssignors[string(requestBody.ServerAssignor)]; !ok {
+			return ERROR_CODE_UNSUPPORTED_ASSIGNOR, fmt.Sprintf("ServerAssignor %s is not supported.", requestBody.ServerAssignor)
+		}
+	}
+	return ERROR_CODE_NONE, ""
+}
+
+func toTopicPartitions(topicPartitions []ConsumerGroupHeartbeatTopicPartitions) TopicPartitions {
+	if topicPartitions == nil {
+		return nil
+	}
+	result := make(TopicPartitions)
+	for _, topic := range topicPartitions {
+		for _, partition := range topic.Partitions {
+			result.add(topic.TopicId, int32(partition))
+		}
+	}
+	return result
+}
+
+func fromTopicPartitions(topicPartitions TopicPartitions) []ConsumerGroupHeartbeatTopicPartitions {
+	result := make([]ConsumerGroupHeartbeatTopicPartitions, 0, len(topicPartitions))
+	for _, topicId := range topicPartitions.sortedTopicIds() {
+		partitions := make([]ktypes.Int32, len(topicPartitions[topicId]))
+		for i, partition := range topicPartitions[topicId] {
+			partitions[i] = ktypes.Int32(partition)
+		}
+		result = append(result, ConsumerGroupHeartbeatTopicPartitions{
+			TopicId:    topicId,
+			Partitions: partitions,
+		})
+	}
+	return result
+}
+
+func handleConsumerGroupHeartbeatRequest(req *Request) *Response {
+	requestBody, err := parseConsumerGroupHeartbeatRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	responseBody := ConsumerGroupHeartbeatResponseBody{
+		ThrottleTimeMs:      ktypes.Int32(req.ThrottleTimeMs),
+		HeartbeatIntervalMs: ktypes.Int32(CONSUMER_GROUP_HEARTBEAT_INTERVAL_MS),
+	}
+
+	errorCode, errorMessage := validateConsumerGroupHeartbeat(requestBody)
+	if errorCode == ERROR_CODE_NONE && !authorize(req, ACL_OPERATION_READ, RESOURCE_TYPE_GROUP, string(requestBody.GroupId)) {
+		errorCode = ERROR_CODE_GROUP_AUTHORIZATION_FAILED
+	}
+	if errorCode != ERROR_CODE_NONE {
+		responseBody.ErrorCode = errorCode
+		responseBody.ErrorMessage = ktypes.CompactNullableString(errorMessage)
+		res.Body = generateBytesFromConsumerGroupHeartbeatResponseBody(&responseBody)
+		return &res
+	}
+
+	heartbeat := ConsumerGroupHeartbeat{
+		GroupId:            string(requestBody.GroupId),
+		MemberId:           string(requestBody.MemberId),
+		MemberEpoch:        int32(requestBody.MemberEpoch),
+		InstanceId:         string(requestBody.InstanceId),
+		RebalanceTimeoutMs: int32(requestBody.RebalanceTimeoutMs),
+		OwnedPartitions:    toTopicPartitions(requestBody.TopicPartitions),
+		ClientId:           string(req.ClientId),
+		ClientHost:         req.ClientHost,
+	}
+	// Null fields mean "unchanged since the last heartbeat"
+	if requestBody.RackId != "" {
+		rackId := string(requestBody.RackId)
+		heartbeat.RackId = &rackId
+	}
+	if requestBody.ServerAssignor != "" {
+		serverAssignor := string(requestBody.ServerAssignor)
+		heartbeat.ServerAssignor = &serverAssignor
+	}
+	if requestBody.SubscribedTopicNames != nil {
+		heartbeat.SubscribedTopicNames = make([]string, len(requestBody.SubscribedTopicNames))
+		for i, topicName := range requestBody.SubscribedTopicNames {
+			heartbeat.SubscribedTopicNames[i] = string(topicName)
+		}
+	}
+
+	result := consumerGroupHeartbeat(&heartbeat)
+	responseBody.ErrorCode = result.ErrorCode
+	responseBody.MemberId = ktypes.CompactNullableString(result.MemberId)
+	responseBody.MemberEpoch = ktypes.Int32(result.MemberEpoch)
+	if result.Assignment != nil {
+		responseBody.Assignment.Value = &ConsumerGroupHeartbeatAssignment{
+			TopicPartitions: fromTopicPartitions(result.Assignment),
+		}
+	}
+
+	res.Body = generateBytesFromConsumerGroupHeartbeatResponseBody(&responseBody)
+	return &res
+}
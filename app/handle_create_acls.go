@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type AclCreation struct {
+	ResourceType        ktypes.Int8          `order:"1"`
+	ResourceName        ktypes.CompactString `order:"2"`
+	ResourcePatternType ktypes.Int8          `order:"3"`
+	Principal           ktypes.CompactString `order:"4"`
+	Host                ktypes.CompactString `order:"5"`
+	Operation           ktypes.Int8          `order:"6"`
+	PermissionType      ktypes.Int8          `order:"7"`
+	TaggedFields        ktypes.TaggedFields  `order:"8"`
+}
+
+type CreateAclsRequestBody struct {
+	Creations    ktypes.CompactArray[AclCreation] `order:"1"`
+	TaggedFields ktypes.TaggedFields              `order:"2"`
+}
+
+type AclCreationResult struct {
+	ErrorCode    ERROR_CODE                   `order:"1"`
+	ErrorMessage ktypes.CompactNullableString `order:"2"`
+	TaggedFields ktypes.TaggedFields          `order:"3"`
+}
+
+type CreateAclsResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                           `order:"1"`
+	Results        ktypes.CompactArray[AclCreationResult] `order:"2"`
+	TaggedFields   ktypes.TaggedFields                    `order:"3"`
+}
+
+func parseCreateAclsRequestBody(body []byte) (*CreateAclsRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody CreateAclsRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode create acls request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromCreateAclsResponseBody(body *CreateAclsResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode create acls response: %v", err))
+	}
+	return encoded
+}
+
+func handleCreateAclsRequest(req *Request) *Response {
+	requestBody, err := parseCreateAclsRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	authorized := authorize(req, ACL_OPERATION_ALTER, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME)
+	results := make([]AclCreationResult, 0, len(requestBody.Creations))
+	for _, creation := range requestBody.Creations {
+		result := AclCreationResult{ErrorCode: ERROR_CODE_NONE}
+		if authorizer == nil {
+			result.ErrorCode = ERROR_CODE_SECURITY_DISABLED
+			result.ErrorMessage = "no authorizer is configured on the broker"
+		} else if !authorized {
+			result.ErrorCode = ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED
+		} else {
+			err := createAcl(Acl{
+				ResourceType:   int8(creation.ResourceType),
+				ResourceName:   string(creation.ResourceName),
+				PatternType:    int8(creation.ResourcePatternType),
+				Principal:      string(creation.Principal),
+				Host:           string(creation.Host),
+				Operation:      int8(creation.Operation),
+				PermissionType: int8(creation.PermissionType),
+			})
+			if err != nil {
+				result.ErrorCode = errorCodeFromError(err)
+				result.ErrorMessage = ktypes.CompactNullableString(err.Error())
+			}
+		}
+		results = append(results, result)
+	}
+
+	responseBody := CreateAclsResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		Results:        results,
+	}
+
+	res.Body = generateBytesFromCreateAclsResponseBody(&responseBody)
+	return &res
+}
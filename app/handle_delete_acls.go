@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type DeleteAclsFilter struct {
+	ResourceTypeFilter ktypes.Int8                  `order:"1"`
+	ResourceNameFilter ktypes.CompactNullableString `order:"2"`
+	PatternTypeFilter  ktypes.Int8                  `order:"3"`
+	PrincipalFilter    ktypes.CompactNullableString `order:"4"`
+	HostFilter         ktypes.CompactNullableString `order:"5"`
+	Operation          ktypes.Int8                  `order:"6"`
+	PermissionType     ktypes.Int8                  `order:"7"`
+	TaggedFields       ktypes.TaggedFields          `order:"8"`
+}
+
+type DeleteAclsRequestBody struct {
+	Filters      ktypes.CompactArray[DeleteAclsFilter] `order:"1"`
+	TaggedFields ktypes.TaggedFields                   `order:"2"`
+}
+
+type DeleteAclsMatchingAcl struct {
+	ErrorCode      ERROR_CODE                   `order:"1"`
+	ErrorMessage   ktypes.CompactNullableString `order:"2"`
+	ResourceType   ktypes.Int8                  `order:"3"`
+	ResourceName   ktypes.CompactString         `order:"4"`
+	PatternType    ktypes.Int8                  `order:"5"`
+	Principal      ktypes.CompactString         `order:"6"`
+	Host           ktypes.CompactString         `order:"7"`
+	Operation      ktypes.Int8                  `order:"8"`
+	PermissionType ktypes.Int8                  `order:"9"`
+	TaggedFields   ktypes.TaggedFields          `order:"10"`
+}
+
+type DeleteAclsFilterResult struct {
+	ErrorCode    ERROR_CODE                                 `order:"1"`
+	ErrorMessage ktypes.CompactNullableString               `order:"2"`
+	MatchingAcls ktypes.CompactArray[DeleteAclsMatchingAcl] `order:"3"`
+	TaggedFields ktypes.TaggedFields                        `order:"4"`
+}
+
+type DeleteAclsResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                `order:"1"`
+	FilterResults  ktypes.CompactArray[DeleteAclsFilterResult] `order:"2"`
+	TaggedFields   ktypes.TaggedFields                         `order:"3"`
+}
+
+func parseDeleteAclsRequestBody(body []byte) (*DeleteAclsRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody DeleteAclsRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode delete acls request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromDeleteAclsResponseBody(body *DeleteAclsResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode delete acls response: %v", err))
+	}
+	return encoded
+}
+
+func handleDeleteAclsRequest(req *Request) *Response {
+	requestBody, err := parseDeleteAclsRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	authorized := authorize(req, ACL_OPERATION_ALTER, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME)
+	filterResults := make([]DeleteAclsFilterResult, 0, len(requestBody.Filters))
+	for _, requestFilter := range requestBody.Filters {
+		result := DeleteAclsFilterResult{
+			ErrorCode:    ERROR_CODE_NONE,
+			MatchingAcls: []DeleteAclsMatchingAcl{},
+		}
+		filter := AclFilter{
+			ResourceType:   int8(requestFilter.ResourceTypeFilter),
+			ResourceName:   string(requestFilter.ResourceNameFilter),
+			PatternType:    int8(requestFilter.PatternTypeFilter),
+			Principal:      string(requestFilter.PrincipalFilter),
+			Host:           string(requestFilter.HostFilter),
+			Operation:      int8(requestFilter.Operation),
+			PermissionType: int8(requestFilter.PermissionType),
+		}
+		if authorizer == nil {
+			result.ErrorCode = ERROR_CODE_SECURITY_DISABLED
+			result.ErrorMessage = "no authorizer is configured on the broker"
+		} else if !authorized {
+			result.ErrorCode = ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED
+		} else if err := validateAclFilter(filter); err != nil {
+			result.ErrorCode = err.Code
+			result.ErrorMessage = ktypes.CompactNullableString(err.Message)
+		} else {
+			deleted, err := deleteAcls(filter)
+			if err != nil {
+				result.ErrorCode = errorCodeFromError(err)
+				result.ErrorMessage = ktypes.CompactNullableString(err.Error())
+			}
+			for _, acl := range deleted {
+				result.MatchingAcls = append(result.MatchingAcls, DeleteAclsMatchingAcl{
+					ErrorCode:      ERROR_CODE_NONE,
+					ResourceType:   ktypes.Int8(acl.ResourceType),
+					ResourceName:   ktypes.CompactString(acl.ResourceName),
+					PatternType:    ktypes.Int8(acl.PatternType),
+					Principal:      ktypes.CompactString(acl.Principal),
+					Host:           ktypes.CompactString(acl.Host),
+					Operation:      ktypes.Int8(acl.Operation),
+					PermissionType: ktypes.Int8(acl.PermissionType),
+				})
+			}
+		}
+		filterResults = append(filterResults, result)
+	}
+
+	responseBody := DeleteAclsResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		FilterResults:  filterResults,
+	}
+
+	res.Body = generateBytesFromDeleteAclsResponseBody(&responseBody)
+	return &res
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type DeleteRecordsRequestPartition struct {
+	PartitionIndex ktypes.Int32        `order:"1"`
+	Offset         ktypes.Int64        `order:"2"`
+	TaggedFields   ktypes.TaggedFields `order:"3"`
+}
+
+type DeleteRecordsRequestTopic struct {
+	Name         ktypes.CompactString                               `order:"1"`
+	Partitions   ktypes.CompactArray[DeleteRecordsRequestPartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                `order:"3"`
+}
+
+type DeleteRecordsRequestBody struct {
+	Topics       ktypes.CompactArray[DeleteRecordsRequestTopic] `order:"1"`
+	TimeoutMs    ktypes.Int32                                   `order:"2"`
+	TaggedFields ktypes.TaggedFields                            `order:"3"`
+}
+
+type DeleteRecordsResponsePartition struct {
+	PartitionIndex ktypes.Int32        `order:"1"`
+	LowWatermark   ktypes.Int64        `order:"2"`
+	ErrorCode      ERROR_CODE          `order:"3"`
+	TaggedFields   ktypes.TaggedFields `order:"4"`
+}
+
+type DeleteRecordsResponseTopic struct {
+	Name         ktypes.CompactString                                `order:"1"`
+	Partitions   ktypes.CompactArray[DeleteRecordsResponsePartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                 `order:"3"`
+}
+
+type DeleteRecordsResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                    `order:"1"`
+	Topics         ktypes.CompactArray[DeleteRecordsResponseTopic] `order:"2"`
+	TaggedFields   ktypes.TaggedFields                             `order:"3"`
+}
+
+func parseDeleteRecordsRequestBody(body []byte) (*DeleteRecordsRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody DeleteRecordsRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode delete records request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromDeleteRecordsResponseBody(body *DeleteRecordsResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode delete records response: %v", err))
+	}
+	return encoded
+}
+
+// deletePartitionRecords moves the partition's log start offset up to the
+// requested offset.
+func deletePartitionRecords(topicName string, partition DeleteRecordsRequestPartition) DeleteRecordsResponsePartition {
+	response := DeleteRecordsResponsePartition{
+		PartitionIndex: partition.PartitionIndex,
+		LowWatermark:   ktypes.Int64(-1),
+		ErrorCode:      ERROR_CODE_NONE,
+	}
+
+	topicId, ok := topicNameToTopicId[topicName]
+	if !ok || !slices.Contains(topicIdToPartitionIds[topicId], int32(partition.PartitionIndex)) {
+		response.ErrorCode = ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION
+		return response
+	}
+
+	log, err := getPartitionLog(topicName, int32(partition.PartitionIndex))
+	if err != nil {
+		fmt.Println("Error opening partition log: ", err.Error())
+		response.ErrorCode = errorCodeFromError(err)
+		return response
+	}
+
+	lowWatermark, err := log.DeleteRecordsBefore(int64(partition.Offset))
+	response.ErrorCode = errorCodeFromError(err)
+	if err == nil {
+		response.LowWatermark = ktypes.Int64(lowWatermark)
+	}
+	return response
+}
+
+func handleDeleteRecordsRequest(req *Request) *Response {
+	requestBody, err := parseDeleteRecordsRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	topics := make([]DeleteRecordsResponseTopic, 0, len(requestBody.Topics))
+	for _, topic := range requestBody.Topics {
+		authorized := authorize(req, ACL_OPERATION_DELETE, RESOURCE_TYPE_TOPIC, string(topic.Name))
+		partitions := make([]DeleteRecordsResponsePartition, 0, len(topic.Partitions))
+		for _, partition := range topic.Partitions {
+			if !authorized {
+				partitions = append(partitions, DeleteRecordsResponsePartition{
+					PartitionIndex: partition.PartitionIndex,
+					LowWatermark:   ktypes.Int64(-1),
+					ErrorCode:      ERROR_CODE_TOPIC_AUTHORIZATION_FAILED,
+				})
+				continue
+			}
+			partitions = append(partitions, deletePartitionRecords(string(topic.Name), partition))
+		}
+		topics = append(topics, DeleteRecordsResponseTopic{
+			Name:       topic.Name,
+			Partitions: partitions,
+		})
+	}
+
+	// The new log start offsets must survive a restart
+	if err := checkpointLogStartOffsets(); err != nil {
+		fmt.Println("Error writing log start offset checkpoint: ", err.Error())
+	}
+
+	responseBody := DeleteRecordsResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		Topics:         topics,
+	}
+
+	res.Body = generateBytesFromDeleteRecordsResponseBody(&responseBody)
+	return &res
+}
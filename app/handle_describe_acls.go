@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type DescribeAclsRequestBody struct {
+	ResourceTypeFilter ktypes.Int8                  `order:"1"`
+	ResourceNameFilter ktypes.CompactNullableString `order:"2"`
+	PatternTypeFilter  ktypes.Int8                  `order:"3"`
+	PrincipalFilter    ktypes.CompactNullableString `order:"4"`
+	HostFilter         ktypes.CompactNullableString `order:"5"`
+	Operation          ktypes.Int8                  `order:"6"`
+	PermissionType     ktypes.Int8                  `order:"7"`
+	TaggedFields       ktypes.TaggedFields          `order:"8"`
+}
+
+type AclDescription struct {
+	Principal      ktypes.CompactString `order:"1"`
+	Host           ktypes.CompactString `order:"2"`
+	Operation      ktypes.Int8          `order:"3"`
+	PermissionType ktypes.Int8          `order:"4"`
+	TaggedFields   ktypes.TaggedFields  `order:"5"`
+}
+
+type DescribeAclsResource struct {
+	ResourceType ktypes.Int8                         `order:"1"`
+	ResourceName ktypes.CompactString                `order:"2"`
+	PatternType  ktypes.Int8                         `order:"3"`
+	Acls         ktypes.CompactArray[AclDescription] `order:"4"`
+	TaggedFields ktypes.TaggedFields                 `order:"5"`
+}
+
+type DescribeAclsResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                              `order:"1"`
+	ErrorCode      ERROR_CODE                                `order:"2"`
+	ErrorMessage   ktypes.CompactNullableString              `order:"3"`
+	Resources      ktypes.CompactArray[DescribeAclsResource] `order:"4"`
+	TaggedFields   ktypes.TaggedFields                       `order:"5"`
+}
+
+func parseDescribeAclsRequestBody(body []byte) (*DescribeAclsRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody DescribeAclsRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode describe acls request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromDescribeAclsResponseBody(body *DescribeAclsResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode describe acls response: %v", err))
+	}
+	return encoded
+}
+
+func handleDescribeAclsRequest(req *Request) *Response {
+	requestBody, err := parseDescribeAclsRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	responseBody := DescribeAclsResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		ErrorCode:      ERROR_CODE_NONE,
+		Resources:      []DescribeAclsResource{},
+	}
+
+	filter := AclFilter{
+		ResourceType:   int8(requestBody.ResourceTypeFilter),
+		ResourceName:   string(requestBody.ResourceNameFilter),
+		PatternType:    int8(requestBody.PatternTypeFilter),
+		Principal:      string(requestBody.PrincipalFilter),
+		Host:           string(requestBody.HostFilter),
+		Operation:      int8(requestBody.Operation),
+		PermissionType: int8(requestBody.PermissionType),
+	}
+	if authorizer == nil {
+		responseBody.ErrorCode = ERROR_CODE_SECURITY_DISABLED
+		responseBody.ErrorMessage = "no authorizer is configured on the broker"
+	} else if !authorize(req, ACL_OPERATION_DESCRIBE, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME) {
+		responseBody.ErrorCode = ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED
+	} else if err := validateAclFilter(filter); err != nil {
+		responseBody.ErrorCode = err.Code
+		responseBody.ErrorMessage = ktypes.CompactNullableString(err.Message)
+	} else {
+		// ACLs come back sorted, so those of a resource are next to each other
+		for _, acl := range describeAcls(filter) {
+			last := len(responseBody.Resources) - 1
+			if last < 0 || int8(responseBody.Resources[last].ResourceType) != acl.ResourceType ||
+				string(responseBody.Resources[last].ResourceName) != acl.ResourceName ||
+				int8(responseBody.Resources[last].PatternType) != acl.PatternType {
+				responseBody.Resources = append(responseBody.Resources, DescribeAclsResource{
+					ResourceType: ktypes.Int8(acl.ResourceType),
+					ResourceName: ktypes.CompactString(acl.ResourceName),
+					PatternType:  ktypes.Int8(acl.PatternType),
+					Acls:         []AclDescription{},
+				})
+				last++
+			}
+			responseBody.Resources[last].Acls = append(responseBody.Resources[last].Acls, AclDescription{
+				Principal:      ktypes.CompactString(acl.Principal),
+				Host:           ktypes.CompactString(acl.Host),
+				Operation:      ktypes.Int8(acl.Operation),
+				PermissionType: ktypes.Int8(acl.PermissionType),
+			})
+		}
+	}
+
+	res.Body = generateBytesFromDescribeAclsResponseBody(&responseBody)
+	return &res
+}
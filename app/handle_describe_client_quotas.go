@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type DescribeClientQuotasComponent struct {
+	EntityType   ktypes.CompactString         `order:"1"`
+	MatchType    ktypes.Int8                  `order:"2"`
+	Match        ktypes.CompactNullableString `order:"3"`
+	TaggedFields ktypes.TaggedFields          `order:"4"`
+}
+
+type DescribeClientQuotasRequestBody struct {
+	Components   ktypes.CompactArray[DescribeClientQuotasComponent] `order:"1"`
+	Strict       ktypes.Bool                                        `order:"2"`
+	TaggedFields ktypes.TaggedFields                                `order:"3"`
+}
+
+type ClientQuotaValue struct {
+	Key          ktypes.CompactString `order:"1"`
+	Value        ktypes.Float64       `order:"2"`
+	TaggedFields ktypes.TaggedFields  `order:"3"`
+}
+
+type DescribeClientQuotasEntry struct {
+	Entity       ktypes.CompactArray[ClientQuotaRecordEntity] `order:"1"`
+	Values       ktypes.CompactArray[ClientQuotaValue]        `order:"2"`
+	TaggedFields ktypes.TaggedFields                          `order:"3"`
+}
+
+type DescribeClientQuotasResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                   `order:"1"`
+	ErrorCode      ERROR_CODE                                     `order:"2"`
+	ErrorMessage   ktypes.CompactNullableString                   `order:"3"`
+	Entries        ktypes.CompactArray[DescribeClientQuotasEntry] `order:"4"`
+	TaggedFields   ktypes.TaggedFields                            `order:"5"`
+}
+
+func parseDescribeClientQuotasRequestBody(body []byte) (*DescribeClientQuotasRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody DescribeClientQuotasRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode describe client quotas request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromDescribeClientQuotasResponseBody(body *DescribeClientQuotasResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode describe client quotas response: %v", err))
+	}
+	return encoded
+}
+
+// validateClientQuotaFilter returns the error for an invalid filter.
+func validateClientQuotaFilter(components []ClientQuotaFilterComponent) *KafkaError {
+	seen := make(map[string]bool)
+	for _, component := range components {
+		if component.EntityType != QUOTA_ENTITY_USER && component.EntityType != QUOTA_ENTITY_CLIENT_ID {
+			return newKafkaError(ERROR_CODE_INVALID_REQUEST, "unsupported quota entity type %s", component.EntityType)
+		}
+		if seen[component.EntityType] {
+			return newKafkaError(ERROR_CODE_INVALID_REQUEST, "duplicate filter component for %s", component.EntityType)
+		}
+		seen[component.EntityType] = true
+		switch component.MatchType {
+		case QUOTA_MATCH_TYPE_EXACT:
+			if component.Match == "" {
+				return newKafkaError(ERROR_CODE_INVALID_REQUEST, "exact match of %s requires a name", component.EntityType)
+			}
+		case QUOTA_MATCH_TYPE_DEFAULT, QUOTA_MATCH_TYPE_ANY:
+		default:
+			return newKafkaError(ERROR_CODE_INVALID_REQUEST, "unknown match type %d", component.MatchType)
+		}
+	}
+	return nil
+}
+
+func handleDescribeClientQuotasRequest(req *Request) *Response {
+	requestBody, err := parseDescribeClientQuotasRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	responseBody := DescribeClientQuotasResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		ErrorCode:      ERROR_CODE_NONE,
+		Entries:        []DescribeClientQuotasEntry{},
+	}
+
+	components := make([]ClientQuotaFilterComponent, 0, len(requestBody.Components))
+	for _, component := range requestBody.Components {
+		components = append(components, ClientQuotaFilterComponent{
+			EntityType: string(component.EntityType),
+			MatchType:  int8(component.MatchType),
+			Match:      string(component.Match),
+		})
+	}
+
+	if !authorize(req, ACL_OPERATION_DESCRIBE_CONFIGS, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME) {
+		responseBody.ErrorCode = ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED
+	} else if err := validateClientQuotaFilter(components); err != nil {
+		responseBody.ErrorCode = err.Code
+		responseBody.ErrorMessage = ktypes.CompactNullableString(err.Message)
+	} else {
+		for entity, values := range describeClientQuotas(components, bool(requestBody.Strict)) {
+			keys := make([]string, 0, len(values))
+			for key := range values {
+				keys = append(keys, key)
+			}
+			slices.Sort(keys)
+			entry := DescribeClientQuotasEntry{
+				Entity: entity.recordComponents(),
+				Values: []ClientQuotaValue{},
+			}
+			for _, key := range keys {
+				entry.Values = append(entry.Values, ClientQuotaValue{
+					Key:   ktypes.CompactString(key),
+					Value: ktypes.Float64(values[key]),
+				})
+			}
+			responseBody.Entries = append(responseBody.Entries, entry)
+		}
+		slices.SortFunc(responseBody.Entries, func(a, b DescribeClientQuotasEntry) int {
+			return strings.Compare(fmt.Sprint(a.Entity), fmt.Sprint(b.Entity))
+		})
+	}
+
+	res.Body = generateBytesFromDescribeClientQuotasResponseBody(&responseBody)
+	return &res
+}
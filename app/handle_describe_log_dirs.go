@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type DescribeLogDirsRequestTopic struct {
+	Topic        ktypes.CompactString              `order:"1"`
+	Partitions   ktypes.CompactArray[ktypes.Int32] `order:"2"`
+	TaggedFields ktypes.TaggedFields               `order:"3"`
+}
+
+type DescribeLogDirsRequestBody struct {
+	// Null describes every partition
+	Topics       ktypes.CompactArray[DescribeLogDirsRequestTopic] `order:"1"`
+	TaggedFields ktypes.TaggedFields                              `order:"2"`
+}
+
+type DescribeLogDirsResponsePartition struct {
+	PartitionIndex ktypes.Int32        `order:"1"`
+	PartitionSize  ktypes.Int64        `order:"2"`
+	OffsetLag      ktypes.Int64        `order:"3"`
+	IsFutureKey    ktypes.Bool         `order:"4"`
+	TaggedFields   ktypes.TaggedFields `order:"5"`
+}
+
+type DescribeLogDirsResponseTopic struct {
+	Name         ktypes.CompactString                                  `order:"1"`
+	Partitions   ktypes.CompactArray[DescribeLogDirsResponsePartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                   `order:"3"`
+}
+
+type DescribeLogDirsResponseResult struct {
+	ErrorCode    ERROR_CODE                                        `order:"1"`
+	LogDir       ktypes.CompactString                              `order:"2"`
+	Topics       ktypes.CompactArray[DescribeLogDirsResponseTopic] `order:"3"`
+	TotalBytes   ktypes.Int64                                      `order:"4"`
+	UsableBytes  ktypes.Int64                                      `order:"5"`
+	TaggedFields ktypes.TaggedFields                               `order:"6"`
+}
+
+type DescribeLogDirsResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                       `order:"1"`
+	ErrorCode      ERROR_CODE                                         `order:"2"`
+	Results        ktypes.CompactArray[DescribeLogDirsResponseResult] `order:"3"`
+	TaggedFields   ktypes.TaggedFields                                `order:"4"`
+}
+
+func parseDescribeLogDirsRequestBody(body []byte) (*DescribeLogDirsRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody DescribeLogDirsRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode describe log dirs request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromDescribeLogDirsResponseBody(body *DescribeLogDirsResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode describe log dirs response: %v", err))
+	}
+	return encoded
+}
+
+// describeLogDir lists the partition folders of a log directory with their
+// size, those of the requested partitions when topics is not nil. Offline
+// directories report KAFKA_STORAGE_ERROR.
+func describeLogDir(logDir *LogDirectory, topics map[string][]int32, openLogs map[string]*PartitionLog) DescribeLogDirsResponseResult {
+	result := DescribeLogDirsResponseResult{
+		ErrorCode:   ERROR_CODE_NONE,
+		LogDir:      ktypes.CompactString(filepath.Clean(logDir.path)),
+		Topics:      []DescribeLogDirsResponseTopic{},
+		TotalBytes:  ktypes.Int64(-1),
+		UsableBytes: ktypes.Int64(-1),
+	}
+	if logDir.isOffline() {
+		result.ErrorCode = ERROR_CODE_KAFKA_STORAGE_ERROR
+		return result
+	}
+	folders, err := os.ReadDir(logDir.path)
+	if err != nil {
+		markLogDirOffline(logDir, err)
+		result.ErrorCode = ERROR_CODE_KAFKA_STORAGE_ERROR
+		return result
+	}
+
+	partitionsByTopic := make(map[string][]DescribeLogDirsResponsePartition)
+	for _, folder := range folders {
+		if !folder.IsDir() {
+			continue
+		}
+		name, isFuture := strings.CutSuffix(folder.Name(), FUTURE_DIR_SUFFIX)
+		i := strings.LastIndex(name, "-")
+		if i < 0 {
+			continue
+		}
+		topicName := name[:i]
+		partition, err := strconv.Atoi(name[i+1:])
+		if err != nil || topicName == METADATA_TOPIC {
+			continue
+		}
+		if topics != nil && !slices.Contains(topics[topicName], int32(partition)) {
+			continue
+		}
+
+		dir := logDir.path + folder.Name()
+		var offsetLag int64
+		if log, ok := openLogs[dir]; ok && !isFuture {
+			offsetLag = max(0, log.HighWatermark()-log.LogEndOffset())
+		}
+		partitionsByTopic[topicName] = append(partitionsByTopic[topicName], DescribeLogDirsResponsePartition{
+			PartitionIndex: ktypes.Int32(partition),
+			PartitionSize:  ktypes.Int64(partitionFolderSize(dir)),
+			OffsetLag:      ktypes.Int64(offsetLag),
+			IsFutureKey:    ktypes.Bool(isFuture),
+		})
+	}
+	topicNames := make([]string, 0, len(partitionsByTopic))
+	for topicName := range partitionsByTopic {
+		topicNames = append(topicNames, topicName)
+	}
+	slices.Sort(topicNames)
+	for _, topicName := range topicNames {
+		partitions := partitionsByTopic[topicName]
+		slices.SortFunc(partitions, func(a, b DescribeLogDirsResponsePartition) int {
+			return int(a.PartitionIndex - b.PartitionIndex)
+		})
+		result.Topics = append(result.Topics, DescribeLogDirsResponseTopic{Name: ktypes.CompactString(topicName), Partitions: partitions})
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(logDir.path, &stat); err == nil {
+		result.TotalBytes = ktypes.Int64(int64(stat.Blocks) * int64(stat.Bsize))
+		result.UsableBytes = ktypes.Int64(int64(stat.Bavail) * int64(stat.Bsize))
+	}
+	return result
+}
+
+// partitionFolderSize returns the bytes taken by the segments of a
+// partition folder.
+func partitionFolderSize(dir string) int64 {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	var size int64
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".log") {
+			continue
+		}
+		if info, err := file.Info(); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// handleDescribeLogDirsRequest describes the log directories of this broker
+// and the partitions they hold.
+func handleDescribeLogDirsRequest(req *Request) *Response {
+	requestBody, err := parseDescribeLogDirsRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	responseBody := DescribeLogDirsResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		ErrorCode:      ERROR_CODE_NONE,
+		Results:        []DescribeLogDirsResponseResult{},
+	}
+	if !authorize(req, ACL_OPERATION_DESCRIBE, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME) {
+		responseBody.ErrorCode = ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED
+	} else {
+		var topics map[string][]int32
+		if requestBody.Topics != nil {
+			topics = make(map[string][]int32, len(requestBody.Topics))
+			for _, topic := range requestBody.Topics {
+				topics[string(topic.Topic)] = append(topics[string(topic.Topic)], toInt32Slice(topic.Partitions)...)
+			}
+		}
+		openLogs := make(map[string]*PartitionLog)
+		for _, log := range openPartitionLogs() {
+			openLogs[log.dir] = log
+		}
+		for _, logDir := range logDirectories {
+			responseBody.Results = append(responseBody.Results, describeLogDir(logDir, topics, openLogs))
+		}
+	}
+
+	res.Body = generateBytesFromDescribeLogDirsResponseBody(&responseBody)
+	return &res
+}
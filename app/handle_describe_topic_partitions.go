@@ -8,10 +8,16 @@ import (
 	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
 )
 
+type DescribeTopicPartitionsRequestTopic struct {
+	Name         ktypes.CompactString `order:"1"`
+	TaggedFields ktypes.TaggedFields  `order:"2"`
+}
+
 type DescribeTopicPartitionsRequestBody struct {
-	Topics                 ktypes.CompactArray[ktypes.CompactString] `order:"1"`
+	Topics                 ktypes.CompactArray[DescribeTopicPartitionsRequestTopic] `order:"1"`
 	ResponsePartitionLimit ktypes.Int32                             `order:"2"`
 	Cursor                 ktypes.Int8                              `order:"3"`
+	TaggedFields           ktypes.TaggedFields                      `order:"4"`
 }
 
 type DescribeTopicPartitionsResponsePartition struct {
@@ -58,8 +64,8 @@ func parseDescribeTopicPartitionsRequestBody(body []byte) (*DescribeTopicPartiti
 		return nil, fmt.Errorf("failed to decode describe topic partitions request: %v", err)
 	}
 	
-	slices.SortFunc(requestBody.Topics, func(a, b ktypes.CompactString) int {
-		return strings.Compare(string(a), string(b))
+	slices.SortFunc(requestBody.Topics, func(a, b DescribeTopicPartitionsRequestTopic) int {
+		return strings.Compare(string(a.Name), string(b.Name))
 	})
 	
 	return &requestBody, nil
@@ -77,14 +83,18 @@ func handleDescribeTopicPartitionsRequest(req *Request) *Response {
 	}
 
 	responseBody := DescribeTopicPartitionsResponseBody{
-		ThrottleTimeMs: ktypes.Int32(0),
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
 		Topics: func() []DescribeTopicPartitionsResponseTopic {
 			topics := make([]DescribeTopicPartitionsResponseTopic, len(requestBody.Topics))
 			for i := range requestBody.Topics {
-				topicName := string(requestBody.Topics[i])
+				topicName := string(requestBody.Topics[i].Name)
 				topicId := topicNameToTopicId[topicName]
 				errorCode := ERROR_CODE_NONE
-				if topicId == NULL_UUID {
+				if !authorize(req, ACL_OPERATION_DESCRIBE, RESOURCE_TYPE_TOPIC, topicName) {
+					// Unauthorized clients cannot tell whether the topic exists
+					errorCode = ERROR_CODE_TOPIC_AUTHORIZATION_FAILED
+					topicId = NULL_UUID
+				} else if topicId == NULL_UUID {
 					errorCode = ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION
 				}
 				
@@ -103,6 +113,16 @@ func handleDescribeTopicPartitionsRequest(req *Request) *Response {
 					for k, isr := range partition.InSyncReplicas {
 						isrNodes[k] = ktypes.Int32(isr)
 					}
+
+					eligibleLeaderReplicas := make([]ktypes.Int32, len(partition.eligibleLeaderReplicas))
+					for k, replica := range partition.eligibleLeaderReplicas {
+						eligibleLeaderReplicas[k] = ktypes.Int32(replica)
+					}
+
+					lastKnownELR := make([]ktypes.Int32, len(partition.lastKnownElr))
+					for k, replica := range partition.lastKnownElr {
+						lastKnownELR[k] = ktypes.Int32(replica)
+					}
 					
 					partitions[j] = DescribeTopicPartitionsResponsePartition{
 						ErrorCode:              ERROR_CODE_NONE,
@@ -111,9 +131,9 @@ func handleDescribeTopicPartitionsRequest(req *Request) *Response {
 						LeaderEpoch:            ktypes.Int32(partition.LeaderEpoch),
 						ReplicaNodes:           replicaNodes,
 						ISRNodes:               isrNodes,
-						EligibleLeaderReplicas: []ktypes.Int32{}, // empty for now
-						LastKnownELR:           []ktypes.Int32{}, // empty for now
-						OfflineReplicas:        []ktypes.Int32{}, // empty for now
+						EligibleLeaderReplicas: eligibleLeaderReplicas,
+						LastKnownELR:           lastKnownELR,
+						OfflineReplicas:        offlineReplicas(&partition),
 					}
 				}
 				
@@ -123,7 +143,7 @@ func handleDescribeTopicPartitionsRequest(req *Request) *Response {
 					Id:                        ktypes.UUID(topicId),
 					IsInternal:                ktypes.Bool(false),
 					Partitions:                partitions,
-					TopicAuthorizedOperations: ktypes.Int32(0), // for now, lets this be some constant value
+					TopicAuthorizedOperations: ktypes.Int32(authorizedOperations(req, RESOURCE_TYPE_TOPIC, topicName)),
 				}
 			}
 			return topics
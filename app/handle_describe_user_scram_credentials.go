@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type DescribeUserScramCredentialsUser struct {
+	Name         ktypes.CompactString `order:"1"`
+	TaggedFields ktypes.TaggedFields  `order:"2"`
+}
+
+type DescribeUserScramCredentialsRequestBody struct {
+	Users        ktypes.CompactArray[DescribeUserScramCredentialsUser] `order:"1"`
+	TaggedFields ktypes.TaggedFields                                   `order:"2"`
+}
+
+type CredentialInfo struct {
+	Mechanism    ktypes.Int8         `order:"1"`
+	Iterations   ktypes.Int32        `order:"2"`
+	TaggedFields ktypes.TaggedFields `order:"3"`
+}
+
+type DescribeUserScramCredentialsResult struct {
+	User            ktypes.CompactString                `order:"1"`
+	ErrorCode       ERROR_CODE                          `order:"2"`
+	ErrorMessage    ktypes.CompactNullableString        `order:"3"`
+	CredentialInfos ktypes.CompactArray[CredentialInfo] `order:"4"`
+	TaggedFields    ktypes.TaggedFields                 `order:"5"`
+}
+
+type DescribeUserScramCredentialsResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                            `order:"1"`
+	ErrorCode      ERROR_CODE                                              `order:"2"`
+	ErrorMessage   ktypes.CompactNullableString                            `order:"3"`
+	Results        ktypes.CompactArray[DescribeUserScramCredentialsResult] `order:"4"`
+	TaggedFields   ktypes.TaggedFields                                     `order:"5"`
+}
+
+func parseDescribeUserScramCredentialsRequestBody(body []byte) (*DescribeUserScramCredentialsRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody DescribeUserScramCredentialsRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode describe user scram credentials request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromDescribeUserScramCredentialsResponseBody(body *DescribeUserScramCredentialsResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode describe user scram credentials response: %v", err))
+	}
+	return encoded
+}
+
+func handleDescribeUserScramCredentialsRequest(req *Request) *Response {
+	requestBody, err := parseDescribeUserScramCredentialsRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	if !authorize(req, ACL_OPERATION_DESCRIBE, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME) {
+		responseBody := DescribeUserScramCredentialsResponseBody{
+			ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+			ErrorCode:      ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED,
+			Results:        []DescribeUserScramCredentialsResult{},
+		}
+		res.Body = generateBytesFromDescribeUserScramCredentialsResponseBody(&responseBody)
+		return &res
+	}
+
+	// A null user list describes every user
+	var names []string
+	if requestBody.Users != nil {
+		names = make([]string, 0, len(requestBody.Users))
+		for _, user := range requestBody.Users {
+			names = append(names, string(user.Name))
+		}
+	}
+
+	results := make([]DescribeUserScramCredentialsResult, 0)
+	for _, description := range describeScramCredentials(names) {
+		credentialInfos := make([]CredentialInfo, 0, len(description.Mechanisms))
+		for i, mechanism := range description.Mechanisms {
+			credentialInfos = append(credentialInfos, CredentialInfo{
+				Mechanism:  ktypes.Int8(mechanism.Type),
+				Iterations: ktypes.Int32(description.Iterations[i]),
+			})
+		}
+		results = append(results, DescribeUserScramCredentialsResult{
+			User:            ktypes.CompactString(description.Name),
+			ErrorCode:       description.ErrorCode,
+			ErrorMessage:    ktypes.CompactNullableString(description.ErrorString),
+			CredentialInfos: credentialInfos,
+		})
+	}
+
+	responseBody := DescribeUserScramCredentialsResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		ErrorCode:      ERROR_CODE_NONE,
+		Results:        results,
+	}
+
+	res.Body = generateBytesFromDescribeUserScramCredentialsResponseBody(&responseBody)
+	return &res
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type ElectLeadersRequestTopic struct {
+	Topic        ktypes.CompactString              `order:"1"`
+	Partitions   ktypes.CompactArray[ktypes.Int32] `order:"2"`
+	TaggedFields ktypes.TaggedFields               `order:"3"`
+}
+
+type ElectLeadersRequestBody struct {
+	ElectionType ktypes.Int8 `order:"1"`
+	// Null elects the leaders of every partition
+	TopicPartitions ktypes.CompactArray[ElectLeadersRequestTopic] `order:"2"`
+	TimeoutMs       ktypes.Int32                                  `order:"3"`
+	TaggedFields    ktypes.TaggedFields                           `order:"4"`
+}
+
+type ElectLeadersResponsePartition struct {
+	PartitionId  ktypes.Int32                 `order:"1"`
+	ErrorCode    ERROR_CODE                   `order:"2"`
+	ErrorMessage ktypes.CompactNullableString `order:"3"`
+	TaggedFields ktypes.TaggedFields          `order:"4"`
+}
+
+type ElectLeadersResponseTopic struct {
+	Topic           ktypes.CompactString                               `order:"1"`
+	PartitionResult ktypes.CompactArray[ElectLeadersResponsePartition] `order:"2"`
+	TaggedFields    ktypes.TaggedFields                                `order:"3"`
+}
+
+type ElectLeadersResponseBody struct {
+	ThrottleTimeMs         ktypes.Int32                                   `order:"1"`
+	ErrorCode              ERROR_CODE                                     `order:"2"`
+	ReplicaElectionResults ktypes.CompactArray[ElectLeadersResponseTopic] `order:"3"`
+	TaggedFields           ktypes.TaggedFields                            `order:"4"`
+}
+
+func parseElectLeadersRequestBody(body []byte) (*ElectLeadersRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody ElectLeadersRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode elect leaders request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromElectLeadersResponseBody(body *ElectLeadersResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode elect leaders response: %v", err))
+	}
+	return encoded
+}
+
+// electLeaders runs the elections of the requested partitions. Every
+// partition is elected when topics is nil, leaving out those whose election
+// was not needed.
+func electLeaders(electionType int8, topics []ElectLeadersRequestTopic) []ElectLeadersResponseTopic {
+	metadataWriteMu.Lock()
+	defer metadataWriteMu.Unlock()
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	all := topics == nil
+	if all {
+		topics = make([]ElectLeadersRequestTopic, 0, len(topicNameToTopicId))
+		for topicName, topicId := range topicNameToTopicId {
+			partitions := make([]ktypes.Int32, 0, len(topicIdToPartitionIds[topicId]))
+			for _, partitionId := range topicIdToPartitionIds[topicId] {
+				partitions = append(partitions, ktypes.Int32(partitionId))
+			}
+			topics = append(topics, ElectLeadersRequestTopic{Topic: ktypes.CompactString(topicName), Partitions: partitions})
+		}
+	}
+
+	results := make([]ElectLeadersResponseTopic, 0, len(topics))
+	for _, topic := range topics {
+		partitions := make([]ElectLeadersResponsePartition, 0, len(topic.Partitions))
+		for _, partitionId := range topic.Partitions {
+			err := electPartitionLeader(string(topic.Topic), int32(partitionId), electionType)
+			if all && errorCodeFromError(err) == ERROR_CODE_ELECTION_NOT_NEEDED {
+				continue
+			}
+			partition := ElectLeadersResponsePartition{
+				PartitionId: partitionId,
+				ErrorCode:   errorCodeFromError(err),
+			}
+			if err != nil {
+				partition.ErrorMessage = ktypes.CompactNullableString(err.Error())
+			}
+			partitions = append(partitions, partition)
+		}
+		if all && len(partitions) == 0 {
+			continue
+		}
+		results = append(results, ElectLeadersResponseTopic{
+			Topic:           topic.Topic,
+			PartitionResult: partitions,
+		})
+	}
+	return results
+}
+
+// handleElectLeadersRequest moves leaderships back to the preferred
+// replicas, or elects leaders for partitions with none. Brokers forward it
+// to the active controller.
+func handleElectLeadersRequest(req *Request) *Response {
+	requestBody, err := parseElectLeadersRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	responseBody := ElectLeadersResponseBody{
+		ThrottleTimeMs:         ktypes.Int32(req.ThrottleTimeMs),
+		ErrorCode:              ERROR_CODE_NONE,
+		ReplicaElectionResults: []ElectLeadersResponseTopic{},
+	}
+	switch {
+	case !authorize(req, ACL_OPERATION_ALTER, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME):
+		responseBody.ErrorCode = ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED
+	case requestBody.ElectionType != ELECTION_TYPE_PREFERRED && requestBody.ElectionType != ELECTION_TYPE_UNCLEAN:
+		responseBody.ErrorCode = ERROR_CODE_INVALID_REQUEST
+	default:
+		responseBody.ReplicaElectionResults = electLeaders(int8(requestBody.ElectionType), requestBody.TopicPartitions)
+	}
+
+	res.Body = generateBytesFromElectLeadersResponseBody(&responseBody)
+	return &res
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type EndQuorumEpochRequestCandidate struct {
+	CandidateId          ktypes.Int32        `order:"1"`
+	CandidateDirectoryId ktypes.UUID         `order:"2"`
+	TaggedFields         ktypes.TaggedFields `order:"3"`
+}
+
+type EndQuorumEpochRequestPartition struct {
+	PartitionIndex ktypes.Int32 `order:"1"`
+	LeaderId       ktypes.Int32 `order:"2"`
+	LeaderEpoch    ktypes.Int32 `order:"3"`
+	// Voters the resigning leader would like to see elected, the most
+	// caught up first
+	PreferredCandidates ktypes.CompactArray[EndQuorumEpochRequestCandidate] `order:"4"`
+	TaggedFields        ktypes.TaggedFields                                 `order:"5"`
+}
+
+type EndQuorumEpochRequestTopic struct {
+	TopicName    ktypes.CompactString                                `order:"1"`
+	Partitions   ktypes.CompactArray[EndQuorumEpochRequestPartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                 `order:"3"`
+}
+
+type EndQuorumEpochRequestBody struct {
+	ClusterId       ktypes.CompactNullableString                    `order:"1"`
+	Topics          ktypes.CompactArray[EndQuorumEpochRequestTopic] `order:"2"`
+	LeaderEndpoints ktypes.CompactArray[QuorumLeaderEndpoint]       `order:"3"`
+	TaggedFields    ktypes.TaggedFields                             `order:"4"`
+}
+
+type EndQuorumEpochResponsePartition struct {
+	PartitionIndex ktypes.Int32        `order:"1"`
+	ErrorCode      ERROR_CODE          `order:"2"`
+	LeaderId       ktypes.Int32        `order:"3"`
+	LeaderEpoch    ktypes.Int32        `order:"4"`
+	TaggedFields   ktypes.TaggedFields `order:"5"`
+}
+
+type EndQuorumEpochResponseTopic struct {
+	TopicName    ktypes.CompactString                                 `order:"1"`
+	Partitions   ktypes.CompactArray[EndQuorumEpochResponsePartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                  `order:"3"`
+}
+
+type EndQuorumEpochResponseBody struct {
+	ErrorCode    ERROR_CODE                                       `order:"1"`
+	Topics       ktypes.CompactArray[EndQuorumEpochResponseTopic] `order:"2"`
+	TaggedFields ktypes.TaggedFields                              `order:"3"`
+}
+
+func parseEndQuorumEpochRequestBody(body []byte) (*EndQuorumEpochRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody EndQuorumEpochRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode end quorum epoch request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromEndQuorumEpochResponseBody(body *EndQuorumEpochResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode end quorum epoch response: %v", err))
+	}
+	return encoded
+}
+
+func handleEndQuorumEpochRequest(req *Request) *Response {
+	requestBody, err := parseEndQuorumEpochRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	responseBody := EndQuorumEpochResponseBody{
+		ErrorCode: validateQuorumRequest(req, requestBody.ClusterId),
+		Topics:    []EndQuorumEpochResponseTopic{},
+	}
+	if responseBody.ErrorCode == ERROR_CODE_NONE {
+		for _, topic := range requestBody.Topics {
+			partitions := make([]EndQuorumEpochResponsePartition, 0, len(topic.Partitions))
+			for _, partition := range topic.Partitions {
+				errorCode := ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION
+				if string(topic.TopicName) == METADATA_TOPIC && partition.PartitionIndex == 0 {
+					successors := make([]int32, len(partition.PreferredCandidates))
+					for i, candidate := range partition.PreferredCandidates {
+						successors[i] = int32(candidate.CandidateId)
+					}
+					errorCode = raftClient.handleEndQuorumEpoch(int32(partition.LeaderId), int32(partition.LeaderEpoch), successors)
+				}
+				leaderId, epoch := raftClient.currentLeader()
+				partitions = append(partitions, EndQuorumEpochResponsePartition{
+					PartitionIndex: partition.PartitionIndex,
+					ErrorCode:      errorCode,
+					LeaderId:       ktypes.Int32(leaderId),
+					LeaderEpoch:    ktypes.Int32(epoch),
+				})
+			}
+			responseBody.Topics = append(responseBody.Topics, EndQuorumEpochResponseTopic{
+				TopicName:  topic.TopicName,
+				Partitions: partitions,
+			})
+		}
+	}
+
+	res.Body = generateBytesFromEndQuorumEpochResponseBody(&responseBody)
+	return &res
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type EndTxnRequestBody struct {
+	TransactionalId ktypes.CompactString `order:"1"`
+	ProducerId      ktypes.Int64         `order:"2"`
+	ProducerEpoch   ktypes.Int16         `order:"3"`
+	Committed       ktypes.Bool          `order:"4"`
+	TaggedFields    ktypes.TaggedFields  `order:"5"`
+}
+
+type EndTxnResponseBody struct {
+	ThrottleTimeMs ktypes.Int32        `order:"1"`
+	ErrorCode      ERROR_CODE          `order:"2"`
+	TaggedFields   ktypes.TaggedFields `order:"3"`
+}
+
+func parseEndTxnRequestBody(body []byte) (*EndTxnRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody EndTxnRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode end txn request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromEndTxnResponseBody(body *EndTxnResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode end txn response: %v", err))
+	}
+	return encoded
+}
+
+func handleEndTxnRequest(req *Request) *Response {
+	requestBody, err := parseEndTxnRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	responseBody := EndTxnResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+	}
+	if !authorize(req, ACL_OPERATION_WRITE, RESOURCE_TYPE_TRANSACTIONAL_ID, string(requestBody.TransactionalId)) {
+		responseBody.ErrorCode = ERROR_CODE_TRANSACTIONAL_ID_AUTHORIZATION_FAILED
+	} else {
+		responseBody.ErrorCode = endTxn(string(requestBody.TransactionalId), int64(requestBody.ProducerId), int16(requestBody.ProducerEpoch), bool(requestBody.Committed))
+	}
+
+	res.Body = generateBytesFromEndTxnResponseBody(&responseBody)
+	return &res
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type EnvelopeRequestBody struct {
+	// Forwarded request, header included
+	RequestData       ktypes.CompactBytes         `order:"1"`
+	RequestPrincipal  ktypes.CompactNullableBytes `order:"2"`
+	ClientHostAddress ktypes.CompactBytes         `order:"3"`
+	TaggedFields      ktypes.TaggedFields         `order:"4"`
+}
+
+type EnvelopeResponseBody struct {
+	// Response to the forwarded request, header included
+	ResponseData ktypes.CompactNullableBytes `order:"1"`
+	ErrorCode    ERROR_CODE                  `order:"2"`
+	TaggedFields ktypes.TaggedFields         `order:"3"`
+}
+
+func parseEnvelopeRequestBody(body []byte) (*EnvelopeRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody EnvelopeRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode envelope request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromEnvelopeResponseBody(body *EnvelopeResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode envelope response: %v", err))
+	}
+	return encoded
+}
+
+// handleForwardedRequest runs a request forwarded by a broker as if its
+// client had sent it to the controller, returning its response.
+func handleForwardedRequest(req *Request, requestBody *EnvelopeRequestBody) (*Response, ERROR_CODE) {
+	principal, err := decodePrincipal(requestBody.RequestPrincipal)
+	if err != nil {
+		fmt.Println("Error decoding forwarded principal: ", err.Error())
+		return nil, ERROR_CODE_PRINCIPAL_DESERIALIZATION_FAILURE
+	}
+
+	frame := make([]byte, 4, 4+len(requestBody.RequestData))
+	binary.BigEndian.PutUint32(frame, uint32(len(requestBody.RequestData)))
+	forwarded, err := parseRequest(append(frame, requestBody.RequestData...))
+	if err != nil || !isForwardedRequest(forwarded.RequestApiKey) {
+		return nil, ERROR_CODE_INVALID_REQUEST
+	}
+	forwarded.ClientHost = net.IP(requestBody.ClientHostAddress).String()
+	forwarded.Session = &ClientSession{Listener: req.Session.Listener, Principal: principal, Authenticated: true}
+
+	return handleRequest(forwarded), ERROR_CODE_NONE
+}
+
+func handleEnvelopeRequest(req *Request) *Response {
+	requestBody, err := parseEnvelopeRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	var responseBody EnvelopeResponseBody
+	switch {
+	case !authorize(req, ACL_OPERATION_CLUSTER_ACTION, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME):
+		responseBody.ErrorCode = ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED
+	case !raftClient.isLeader():
+		responseBody.ErrorCode = ERROR_CODE_NOT_CONTROLLER
+	default:
+		var forwarded *Response
+		forwarded, responseBody.ErrorCode = handleForwardedRequest(req, requestBody)
+		if responseBody.ErrorCode == ERROR_CODE_NONE {
+			// The forwarded request is parked until the controller handled it
+			return thenResponse(forwarded, func(forwarded *Response) *Response {
+				if forwarded == nil {
+					responseBody.ErrorCode = ERROR_CODE_UNKNOWN_SERVER_ERROR
+				} else {
+					responseBody.ResponseData = encodeResponse(forwarded)[4:]
+				}
+				res.Body = generateBytesFromEnvelopeResponseBody(&responseBody)
+				return &res
+			})
+		}
+	}
+
+	res.Body = generateBytesFromEnvelopeResponseBody(&responseBody)
+	return &res
+}
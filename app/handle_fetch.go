@@ -3,13 +3,41 @@ package main
 import (
 	"fmt"
 	"slices"
+	"time"
 
 	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
 )
 
+const (
+	ISOLATION_LEVEL_READ_UNCOMMITTED = 0
+	ISOLATION_LEVEL_READ_COMMITTED   = 1
+
+	FETCH_REPLICA_STATE_TAG = 1
+
+	FETCH_DIVERGING_EPOCH_TAG = 0
+	FETCH_CURRENT_LEADER_TAG  = 1
+	FETCH_SNAPSHOT_ID_TAG     = 2
+)
+
 type FetchResponsePartitionAbortedTransaction struct {
-	ProducerId  ktypes.Int64 `order:"1"`
-	FirstOffset ktypes.Int64 `order:"2"`
+	ProducerId   ktypes.Int64 `order:"1"`
+	FirstOffset  ktypes.Int64 `order:"2"`
+	TaggedFields ktypes.TaggedFields `order:"3"`
+}
+
+// Where the fetcher's log diverges from the leader's
+type FetchResponseEpochEndOffset struct {
+	Epoch        ktypes.Int32 `order:"1"`
+	EndOffset    ktypes.Int64 `order:"2"`
+	TaggedFields ktypes.TaggedFields `order:"3"`
+}
+
+// Leader of the metadata quorum, as known by the node answering a fetch of
+// the metadata log
+type FetchResponseLeaderIdAndEpoch struct {
+	LeaderId     ktypes.Int32 `order:"1"`
+	LeaderEpoch  ktypes.Int32 `order:"2"`
+	TaggedFields ktypes.TaggedFields `order:"3"`
 }
 
 type FetchResponsePartition struct {
@@ -21,11 +49,14 @@ type FetchResponsePartition struct {
 	AbortedTransactions  ktypes.CompactArray[FetchResponsePartitionAbortedTransaction] `order:"6"`
 	PreferredReadReplica ktypes.Int32  `order:"7"`
 	Records              ktypes.CompactRecords `order:"8"`
+	// Holds the DivergingEpoch, CurrentLeader and SnapshotId tags
+	TaggedFields         ktypes.TaggedFieldValues `order:"9"`
 }
 
 type FetchResponseTopic struct {
 	TopicId    ktypes.UUID `order:"1"`
 	Partitions ktypes.CompactArray[FetchResponsePartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields `order:"3"`
 }
 
 type FetchResponseBody struct {
@@ -33,11 +64,13 @@ type FetchResponseBody struct {
 	ThrottleTimeMs ktypes.Int32 `order:"2"`
 	SessionId      ktypes.Int32 `order:"3"`
 	Responses      ktypes.CompactArray[FetchResponseTopic] `order:"4"`
+	TaggedFields   ktypes.TaggedFields `order:"5"`
 }
 
 type FetchRequestTopic struct {
-	TopicId    ktypes.UUID `order:"1"`
-	Partitions ktypes.CompactArray[FetchRequestPartition] `order:"2"`
+	TopicId      ktypes.UUID `order:"1"`
+	Partitions   ktypes.CompactArray[FetchRequestPartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields `order:"3"`
 }
 
 type FetchRequestPartition struct {
@@ -47,11 +80,13 @@ type FetchRequestPartition struct {
 	LastFetchedEpoch  ktypes.Int32 `order:"4"`
 	LogStartOffset    ktypes.Int64 `order:"5"`
 	PartitionMaxBytes ktypes.Int32 `order:"6"`
+	TaggedFields      ktypes.TaggedFields `order:"7"`
 }
 
 type FetchRequestForgettenTopic struct {
-	TopicId    ktypes.UUID `order:"1"`
-	Partitions ktypes.CompactArray[ktypes.Int32] `order:"2"`
+	TopicId      ktypes.UUID `order:"1"`
+	Partitions   ktypes.CompactArray[ktypes.Int32] `order:"2"`
+	TaggedFields ktypes.TaggedFields `order:"3"`
 }
 
 type FetchRequestBody struct {
@@ -64,6 +99,29 @@ type FetchRequestBody struct {
 	Topics          ktypes.CompactArray[FetchRequestTopic] `order:"7"`
 	ForgettenTopic  ktypes.CompactArray[FetchRequestForgettenTopic] `order:"8"`
 	RackId          ktypes.CompactString `order:"9"`
+	// Followers set the ReplicaState tag
+	TaggedFields    ktypes.TaggedFieldValues `order:"10"`
+}
+
+// Identifies the follower sending a fetch request
+type FetchRequestReplicaState struct {
+	ReplicaId    ktypes.Int32 `order:"1"`
+	ReplicaEpoch ktypes.Int64 `order:"2"`
+	TaggedFields ktypes.TaggedFields `order:"3"`
+}
+
+// fetchReplicaId returns the broker id of the follower sending the fetch
+// request, -1 for consumers.
+func fetchReplicaId(requestBody *FetchRequestBody) (int32, error) {
+	value, ok := requestBody.TaggedFields[FETCH_REPLICA_STATE_TAG]
+	if !ok {
+		return -1, nil
+	}
+	var replicaState FetchRequestReplicaState
+	if err := ktypes.NewKDecoder(value).Decode(&replicaState); err != nil {
+		return -1, fmt.Errorf("failed to decode fetch replica state: %v", err)
+	}
+	return int32(replicaState.ReplicaId), nil
 }
 
 func parseFetchRequestBody(body []byte) (*FetchRequestBody, error) {
@@ -85,30 +143,150 @@ func generateBytesFromFetchResponseBody(body *FetchResponseBody) []byte {
 	return encoded
 }
 
-func handleFetchRequest(req *Request) *Response {
-	requestBody, err := parseFetchRequestBody(req.Body)
+// fetchPartitionError returns a partition of a fetch response failing with
+// errorCode, sending the fetcher to no other replica.
+func fetchPartitionError(partitionId int32, errorCode ERROR_CODE) FetchResponsePartition {
+	return FetchResponsePartition{
+		PartitionIndex: ktypes.Int32(partitionId),
+		ErrorCode: errorCode,
+		HighWatermark: ktypes.Int64(-1),
+		LastStableOffset: ktypes.Int64(-1),
+		LogStartOffset: ktypes.Int64(-1),
+		PreferredReadReplica: ktypes.Int32(-1),
+	}
+}
+
+// fetchPartition reads the partition from fetchOffset. Consumers read up to
+// the high watermark, followers up to the log end. read_committed fetches
+// stop at the last stable offset and list the aborted transactions in the
+// returned range so consumers can drop their records. When the log does not
+// end lastFetchedEpoch where the fetcher's does, the fetcher gets the epoch
+// and offset to truncate to instead of records. Consumers, described by
+// client, get no records when the replica selector sends them to a
+// follower. At most maxBytes of records are returned, but for the first
+// batch, and none once maxBytes is used up.
+func fetchPartition(topicName string, partitionId int32, fetchOffset int64, lastFetchedEpoch int32, isolationLevel ktypes.Int8, replicaId int32, client *ClientMetadata, maxBytes int) FetchResponsePartition {
+	log, err := getPartitionLog(topicName, partitionId)
 	if err != nil {
-		return nil
+		fmt.Println("Error opening partition log: ", err.Error())
+		return fetchPartitionError(partitionId, errorCodeFromError(err))
 	}
 
-	res := Response{
-		CorrelationId: req.CorrelationId,
-		HeaderVersion: 1,
+	highWatermark := log.HighWatermark()
+	lastStableOffset := log.LastStableOffset()
+	logStartOffset := log.LogStartOffset()
+	logEndOffset := log.LogEndOffset()
+	maxOffset := highWatermark
+	if replicaId >= 0 {
+		maxOffset = logEndOffset
+	} else if isolationLevel == ISOLATION_LEVEL_READ_COMMITTED {
+		maxOffset = lastStableOffset
+	}
+	if lastFetchedEpoch != UNDEFINED_EPOCH {
+		epoch, endOffset := log.EndOffsetForEpoch(lastFetchedEpoch)
+		if epoch != lastFetchedEpoch || endOffset < fetchOffset {
+			divergingEpoch, err := ktypes.NewKEncoder().Encode(&FetchResponseEpochEndOffset{
+				Epoch: ktypes.Int32(epoch),
+				EndOffset: ktypes.Int64(endOffset),
+			})
+			if err != nil {
+				panic(fmt.Sprintf("Failed to encode diverging epoch: %v", err))
+			}
+			return FetchResponsePartition{
+				PartitionIndex: ktypes.Int32(partitionId),
+				ErrorCode: ERROR_CODE_NONE,
+				HighWatermark: ktypes.Int64(highWatermark),
+				LastStableOffset: ktypes.Int64(lastStableOffset),
+				LogStartOffset: ktypes.Int64(logStartOffset),
+				AbortedTransactions: []FetchResponsePartitionAbortedTransaction{},
+				PreferredReadReplica: ktypes.Int32(-1),
+				TaggedFields: ktypes.TaggedFieldValues{FETCH_DIVERGING_EPOCH_TAG: divergingEpoch},
+			}
+		}
+	}
+	// Offsets past the high watermark are in range for consumers too, the
+	// high watermark of a follower lagging the leader's they were sent from
+	if fetchOffset < logStartOffset || fetchOffset > max(logEndOffset, highWatermark) {
+		return FetchResponsePartition{
+			PartitionIndex: ktypes.Int32(partitionId),
+			ErrorCode: ERROR_CODE_OFFSET_OUT_OF_RANGE,
+			HighWatermark: ktypes.Int64(highWatermark),
+			LastStableOffset: ktypes.Int64(lastStableOffset),
+			LogStartOffset: ktypes.Int64(logStartOffset),
+			AbortedTransactions: []FetchResponsePartitionAbortedTransaction{},
+			PreferredReadReplica: ktypes.Int32(-1),
+		}
+	}
+	if client != nil {
+		if readReplica := preferredReadReplica(client, log, fetchOffset); readReplica >= 0 {
+			return FetchResponsePartition{
+				PartitionIndex: ktypes.Int32(partitionId),
+				ErrorCode: ERROR_CODE_NONE,
+				HighWatermark: ktypes.Int64(highWatermark),
+				LastStableOffset: ktypes.Int64(lastStableOffset),
+				LogStartOffset: ktypes.Int64(logStartOffset),
+				AbortedTransactions: []FetchResponsePartitionAbortedTransaction{},
+				PreferredReadReplica: ktypes.Int32(readReplica),
+			}
+		}
 	}
 
+	records, nextOffset := []byte{}, fetchOffset
+	if maxBytes > 0 {
+		records, nextOffset, err = log.ReadRecords(fetchOffset, maxOffset, maxBytes)
+		if err != nil {
+			fmt.Println("Error reading partition log: ", err.Error())
+			return fetchPartitionError(partitionId, ERROR_CODE_UNKNOWN_SERVER_ERROR)
+		}
+	}
+
+	abortedTransactions := []FetchResponsePartitionAbortedTransaction{}
+	if isolationLevel == ISOLATION_LEVEL_READ_COMMITTED {
+		abortedTxns, err := log.AbortedTxns(fetchOffset, nextOffset)
+		if err != nil {
+			fmt.Println("Error reading aborted transactions: ", err.Error())
+			return fetchPartitionError(partitionId, ERROR_CODE_UNKNOWN_SERVER_ERROR)
+		}
+		for _, abortedTxn := range abortedTxns {
+			abortedTransactions = append(abortedTransactions, FetchResponsePartitionAbortedTransaction{
+				ProducerId:  ktypes.Int64(abortedTxn.ProducerId),
+				FirstOffset: ktypes.Int64(abortedTxn.FirstOffset),
+			})
+		}
+	}
+
+	return FetchResponsePartition{
+		PartitionIndex: ktypes.Int32(partitionId),
+		ErrorCode: ERROR_CODE_NONE,
+		HighWatermark: ktypes.Int64(highWatermark),
+		LastStableOffset: ktypes.Int64(lastStableOffset),
+		LogStartOffset: ktypes.Int64(logStartOffset),
+		AbortedTransactions: abortedTransactions,
+		PreferredReadReplica: ktypes.Int32(-1),
+		Records: ktypes.CompactRecords(records),
+	}
+}
+
+// fetchTopics reads the partitions of a fetch request, each up to its
+// PartitionMaxBytes and all of them up to MaxBytes. The first read of a
+// follower fetch records the follower's fetch state, which may change the
+// ISR; reads of the request parked in the purgatory do not.
+func fetchTopics(req *Request, requestBody *FetchRequestBody, replicaId int32, firstRead bool) []FetchResponseTopic {
+	var client *ClientMetadata
+	if replicaId < 0 {
+		client = newClientMetadata(req, string(requestBody.RackId))
+	}
 	responses := []FetchResponseTopic{}
+	remainingBytes := int(requestBody.MaxBytes)
 	for _, topic := range requestBody.Topics {
 		topicId := ktypes.UUID(topic.TopicId)
-		_, ok := topicIdToTopicName[topicId]
+		topicName, ok := topicIdToTopicName[topicId]
 		if !ok {
 			// Topic not found
 			responses = append(responses, FetchResponseTopic{
 				TopicId: topicId,
 				Partitions: []FetchResponsePartition{
-					{
-						PartitionIndex: ktypes.Int32(0),
-						ErrorCode: ERROR_CODE_UNKNOWN_TOPIC_ID,
-					},
+					fetchPartitionError(0, ERROR_CODE_UNKNOWN_TOPIC_ID),
 				},
 			})
 			continue
@@ -124,49 +302,163 @@ func handleFetchRequest(req *Request) *Response {
 			continue
 		}
 
+		// Followers are authorized on the cluster by the caller
+		authorized := replicaId >= 0 || authorize(req, ACL_OPERATION_READ, RESOURCE_TYPE_TOPIC, topicName)
 		partitions := []FetchResponsePartition{}
 		for _, partition := range topic.Partitions {
 			partitionId := int32(partition.Partition)
+			if !authorized {
+				partitions = append(partitions, fetchPartitionError(partitionId, ERROR_CODE_TOPIC_AUTHORIZATION_FAILED))
+				continue
+			}
 			hasPartition := slices.Contains(partitionIds, partitionId)
 			if !hasPartition {
 				// Partition not found
-				partitions = append(partitions, FetchResponsePartition{
-					PartitionIndex: ktypes.Int32(partitionId),
-					ErrorCode: ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION,
-				})
+				partitions = append(partitions, fetchPartitionError(partitionId, ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION))
 				continue
 			}
 
-			records, err := getTopicPartitionRecords(topicId, partitionId)
-			if err != nil {
-				// Error getting partition records
-				partitions = append(partitions, FetchResponsePartition{
-					PartitionIndex: ktypes.Int32(partitionId),
-					ErrorCode: ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION,
-				})
+			// Consumers may fetch from followers, up to their high watermark
+			var kafkaErr *KafkaError
+			if replicaId >= 0 {
+				kafkaErr = checkLeader(topicName, partitionId)
+			} else {
+				kafkaErr = checkLeaderOrFollower(topicName, partitionId)
+			}
+			if kafkaErr == nil {
+				kafkaErr = checkLeaderEpoch(topicName, partitionId, int32(partition.CurrentLeaderEpoch))
+			}
+			if kafkaErr != nil {
+				partitions = append(partitions, fetchPartitionError(partitionId, kafkaErr.Code))
 				continue
 			}
-			partitions = append(partitions, FetchResponsePartition{
-				PartitionIndex: ktypes.Int32(partitionId),
-				ErrorCode: ERROR_CODE_NONE,
-				Records: ktypes.CompactRecords(records),
-			})
+			fetchOffset := int64(partition.FetchOffset)
+			maxBytes := min(int(partition.PartitionMaxBytes), remainingBytes)
+			response := fetchPartition(topicName, partitionId, fetchOffset, int32(partition.LastFetchedEpoch), requestBody.IsolationLevel, replicaId, client, maxBytes)
+			remainingBytes -= len(response.Records)
+			if firstRead && replicaId >= 0 && response.ErrorCode == ERROR_CODE_NONE && response.TaggedFields == nil {
+				// Fetching from an offset without diverging tells the leader
+				// the follower has every record before it
+				if err := updateFollowerFetchState(topicName, partitionId, replicaId, fetchOffset); err != nil {
+					response = fetchPartitionError(partitionId, errorCodeFromError(err))
+				}
+			}
+			partitions = append(partitions, response)
 		}
 		responses = append(responses, FetchResponseTopic{
 			TopicId: topicId,
 			Partitions: partitions,
 		})
 	}
+	return responses
+}
 
-	responseBody := FetchResponseBody{
-		ErrorCode: ERROR_CODE_NONE,
-		ThrottleTimeMs: ktypes.Int32(0),
-		SessionId: requestBody.SessionId,
-		Responses: responses,
+// fetchedBytes returns the size of the records in a fetch response.
+func fetchedBytes(responses []FetchResponseTopic) int {
+	size := 0
+	for _, topic := range responses {
+		for _, partition := range topic.Partitions {
+			size += len(partition.Records)
+		}
+	}
+	return size
+}
+
+// fetchSatisfied reports whether a fetch response is sent without waiting:
+// it holds minBytes of records, or a partition the fetcher has to act on,
+// failing, diverging or read from another replica.
+func fetchSatisfied(responses []FetchResponseTopic, minBytes int) bool {
+	if fetchedBytes(responses) >= max(minBytes, 1) {
+		return true
+	}
+	for _, topic := range responses {
+		for _, partition := range topic.Partitions {
+			if partition.ErrorCode != ERROR_CODE_NONE || len(partition.TaggedFields) > 0 || partition.PreferredReadReplica >= 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func handleFetchRequest(req *Request) *Response {
+	requestBody, err := parseFetchRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	replicaId, err := fetchReplicaId(requestBody)
+	if err != nil {
+		fmt.Println("Error parsing fetch request: ", err.Error())
+		return nil
+	}
+	if replicaId >= 0 && !authorize(req, ACL_OPERATION_CLUSTER_ACTION, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME) {
+		res.Body = generateBytesFromFetchResponseBody(&FetchResponseBody{
+			ErrorCode: ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED,
+			ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+			SessionId: requestBody.SessionId,
+			Responses: []FetchResponseTopic{},
+		})
+		return &res
+	}
+	if replicaId >= 0 && len(requestBody.Topics) == 1 && requestBody.Topics[0].TopicId == METADATA_TOPIC_ID {
+		return handleMetadataFetchRequest(req, requestBody, replicaId)
 	}
 
-	responseBodyBytes := generateBytesFromFetchResponseBody(&responseBody)
-	res.Body = responseBodyBytes
+	incremental := isIncrementalFetch(requestBody)
+	session, err := openFetchSession(requestBody)
+	if err != nil {
+		res.Body = generateBytesFromFetchResponseBody(&FetchResponseBody{
+			ErrorCode: errorCodeFromError(err),
+			ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+			SessionId: ktypes.Int32(FETCH_SESSION_INVALID_ID),
+			Responses: []FetchResponseTopic{},
+		})
+		return &res
+	}
+
+	responses := fetchTopics(req, requestBody, replicaId, true)
+	respond := func() *Response {
+		if replicaId < 0 {
+			req.ThrottleTimeMs = max(req.ThrottleTimeMs, recordQuotaUsage(req, QUOTA_CONSUMER_BYTE_RATE, float64(fetchedBytes(responses))))
+		}
+
+		sessionId := int32(FETCH_SESSION_INVALID_ID)
+		if session != nil {
+			sessionId = session.id
+			responses = session.updateResponses(responses, incremental)
+		}
 
-	return &res
+		responseBody := FetchResponseBody{
+			ErrorCode: ERROR_CODE_NONE,
+			ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+			SessionId: ktypes.Int32(sessionId),
+			Responses: responses,
+		}
+
+		responseBodyBytes := generateBytesFromFetchResponseBody(&responseBody)
+		res.Body = responseBodyBytes
+
+		return &res
+	}
+	if fetchSatisfied(responses, int(requestBody.MinBytes)) {
+		return respond()
+	}
+	// Fetchers wait up to MaxWaitTimeMs for MinBytes of records rather than
+	// poll, followers for records to replicate and consumers for the high
+	// watermark or last stable offset to move. The request is parked in the
+	// purgatory meanwhile, the follower fetches moving the high watermark
+	// must not wait behind it for a handler.
+	return delayResponse(req, time.Duration(requestBody.MaxWaitTimeMs)*time.Millisecond, func() *Response {
+		responses = fetchTopics(req, requestBody, replicaId, false)
+		if !fetchSatisfied(responses, int(requestBody.MinBytes)) {
+			return nil
+		}
+		return respond()
+	}, respond)
 }
\ No newline at end of file
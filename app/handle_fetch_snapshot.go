@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	METADATA_SNAPSHOT_SUFFIX = ".checkpoint"
+
+	FETCH_SNAPSHOT_CLUSTER_ID_TAG     = 0
+	FETCH_SNAPSHOT_CURRENT_LEADER_TAG = 0
+)
+
+// Identifies a snapshot of the metadata log by the offset and epoch it ends
+// at
+type SnapshotId struct {
+	EndOffset    ktypes.Int64        `order:"1"`
+	Epoch        ktypes.Int32        `order:"2"`
+	TaggedFields ktypes.TaggedFields `order:"3"`
+}
+
+type FetchSnapshotRequestPartition struct {
+	Partition          ktypes.Int32        `order:"1"`
+	CurrentLeaderEpoch ktypes.Int32        `order:"2"`
+	SnapshotId         SnapshotId          `order:"3"`
+	Position           ktypes.Int64        `order:"4"`
+	TaggedFields       ktypes.TaggedFields `order:"5"`
+}
+
+type FetchSnapshotRequestTopic struct {
+	Name         ktypes.CompactString                               `order:"1"`
+	Partitions   ktypes.CompactArray[FetchSnapshotRequestPartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                `order:"3"`
+}
+
+type FetchSnapshotRequestBody struct {
+	ReplicaId ktypes.Int32                                   `order:"1"`
+	MaxBytes  ktypes.Int32                                   `order:"2"`
+	Topics    ktypes.CompactArray[FetchSnapshotRequestTopic] `order:"3"`
+	// Holds the ClusterId tag
+	TaggedFields ktypes.TaggedFieldValues `order:"4"`
+}
+
+// Payload of the ClusterId tag
+type FetchSnapshotClusterId struct {
+	ClusterId ktypes.CompactNullableString `order:"1"`
+}
+
+type FetchSnapshotResponsePartition struct {
+	Index      ktypes.Int32 `order:"1"`
+	ErrorCode  ERROR_CODE   `order:"2"`
+	SnapshotId SnapshotId   `order:"3"`
+	// Size of the whole snapshot, and where the returned bytes start in it
+	Size             ktypes.Int64          `order:"4"`
+	Position         ktypes.Int64          `order:"5"`
+	UnalignedRecords ktypes.CompactRecords `order:"6"`
+	// Holds the CurrentLeader tag
+	TaggedFields ktypes.TaggedFieldValues `order:"7"`
+}
+
+type FetchSnapshotResponseTopic struct {
+	Name         ktypes.CompactString                                `order:"1"`
+	Partitions   ktypes.CompactArray[FetchSnapshotResponsePartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                 `order:"3"`
+}
+
+type FetchSnapshotResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                    `order:"1"`
+	ErrorCode      ERROR_CODE                                      `order:"2"`
+	Topics         ktypes.CompactArray[FetchSnapshotResponseTopic] `order:"3"`
+	TaggedFields   ktypes.TaggedFields                             `order:"4"`
+}
+
+func parseFetchSnapshotRequestBody(body []byte) (*FetchSnapshotRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody FetchSnapshotRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode fetch snapshot request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromFetchSnapshotResponseBody(body *FetchSnapshotResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode fetch snapshot response: %v", err))
+	}
+	return encoded
+}
+
+// snapshotFileName returns the name of the snapshot file ending at
+// endOffset in epoch, zero padded like segment names.
+func snapshotFileName(endOffset int64, epoch int32) string {
+	return fmt.Sprintf("%020d-%010d%s", endOffset, epoch, METADATA_SNAPSHOT_SUFFIX)
+}
+
+// latestSnapshotId returns the snapshot of the metadata log folder ending
+// the furthest, if there is any.
+func latestSnapshotId(dir string) (SnapshotId, bool) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return SnapshotId{}, false
+	}
+	var latest SnapshotId
+	found := false
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), METADATA_SNAPSHOT_SUFFIX)
+		if !ok {
+			continue
+		}
+		offsetPart, epochPart, ok := strings.Cut(name, "-")
+		if !ok {
+			continue
+		}
+		endOffset, err := strconv.ParseInt(offsetPart, 10, 64)
+		if err != nil {
+			continue
+		}
+		epoch, err := strconv.ParseInt(epochPart, 10, 32)
+		if err != nil {
+			continue
+		}
+		if !found || endOffset > int64(latest.EndOffset) {
+			latest = SnapshotId{EndOffset: ktypes.Int64(endOffset), Epoch: ktypes.Int32(epoch)}
+			found = true
+		}
+	}
+	return latest, found
+}
+
+// readSnapshotChunk reads up to maxBytes of a snapshot from position,
+// returning them along with the size of the snapshot.
+func readSnapshotChunk(path string, position int64, maxBytes int32) ([]byte, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if position < 0 || position > info.Size() {
+		return nil, info.Size(), newKafkaError(ERROR_CODE_POSITION_OUT_OF_RANGE, "position %d is outside of snapshot %s", position, path)
+	}
+	chunk := make([]byte, min(int64(maxBytes), info.Size()-position))
+	if _, err := file.ReadAt(chunk, position); err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	return chunk, info.Size(), nil
+}
+
+// fetchSnapshotPartition serves a chunk of a snapshot of the metadata log
+// to a fetcher behind the leader's log start.
+func fetchSnapshotPartition(partition *FetchSnapshotRequestPartition, maxBytes int32) FetchSnapshotResponsePartition {
+	leaderId, epoch := raftClient.currentLeader()
+	currentLeader, err := ktypes.NewKEncoder().Encode(&FetchResponseLeaderIdAndEpoch{
+		LeaderId:    ktypes.Int32(leaderId),
+		LeaderEpoch: ktypes.Int32(epoch),
+	})
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode current leader: %v", err))
+	}
+	response := FetchSnapshotResponsePartition{
+		Index:        partition.Partition,
+		ErrorCode:    raftClient.validateFetch(int32(partition.CurrentLeaderEpoch)),
+		SnapshotId:   SnapshotId{EndOffset: partition.SnapshotId.EndOffset, Epoch: partition.SnapshotId.Epoch},
+		Size:         ktypes.Int64(-1),
+		Position:     partition.Position,
+		TaggedFields: ktypes.TaggedFieldValues{FETCH_SNAPSHOT_CURRENT_LEADER_TAG: currentLeader},
+	}
+	if response.ErrorCode != ERROR_CODE_NONE {
+		return response
+	}
+
+	path := filepath.Join(raftClient.log.dir, snapshotFileName(int64(partition.SnapshotId.EndOffset), int32(partition.SnapshotId.Epoch)))
+	chunk, size, err := readSnapshotChunk(path, int64(partition.Position), maxBytes)
+	if os.IsNotExist(err) {
+		response.ErrorCode = ERROR_CODE_SNAPSHOT_NOT_FOUND
+		return response
+	}
+	if err != nil {
+		fmt.Println("Error reading snapshot: ", err.Error())
+		response.ErrorCode = errorCodeFromError(err)
+		return response
+	}
+	response.Size = ktypes.Int64(size)
+	response.UnalignedRecords = chunk
+	return response
+}
+
+func handleFetchSnapshotRequest(req *Request) *Response {
+	requestBody, err := parseFetchSnapshotRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	var requestClusterId FetchSnapshotClusterId
+	if value, ok := requestBody.TaggedFields[FETCH_SNAPSHOT_CLUSTER_ID_TAG]; ok {
+		if err := ktypes.NewKDecoder(value).Decode(&requestClusterId); err != nil {
+			fmt.Println("Error parsing fetch snapshot request: ", err.Error())
+			return nil
+		}
+	}
+	responseBody := FetchSnapshotResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		ErrorCode:      validateQuorumRequest(req, requestClusterId.ClusterId),
+		Topics:         []FetchSnapshotResponseTopic{},
+	}
+	if responseBody.ErrorCode == ERROR_CODE_NONE {
+		for _, topic := range requestBody.Topics {
+			partitions := make([]FetchSnapshotResponsePartition, 0, len(topic.Partitions))
+			for _, partition := range topic.Partitions {
+				if string(topic.Name) != METADATA_TOPIC || partition.Partition != 0 {
+					partitions = append(partitions, FetchSnapshotResponsePartition{
+						Index:     partition.Partition,
+						ErrorCode: ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION,
+						Size:      ktypes.Int64(-1),
+						Position:  partition.Position,
+					})
+					continue
+				}
+				partitions = append(partitions, fetchSnapshotPartition(&partition, int32(requestBody.MaxBytes)))
+			}
+			responseBody.Topics = append(responseBody.Topics, FetchSnapshotResponseTopic{
+				Name:       topic.Name,
+				Partitions: partitions,
+			})
+		}
+	}
+
+	res.Body = generateBytesFromFetchSnapshotResponseBody(&responseBody)
+	return &res
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+func fetchTestResponses(partitions ...FetchResponsePartition) []FetchResponseTopic {
+	return []FetchResponseTopic{{TopicId: ktypes.UUID{1}, Partitions: partitions}}
+}
+
+func fetchTestPartition(records int) FetchResponsePartition {
+	return FetchResponsePartition{
+		ErrorCode:            ERROR_CODE_NONE,
+		PreferredReadReplica: ktypes.Int32(-1),
+		Records:              make(ktypes.CompactRecords, records),
+	}
+}
+
+func TestFetchSatisfied(t *testing.T) {
+	diverging := fetchTestPartition(0)
+	diverging.TaggedFields = ktypes.TaggedFieldValues{FETCH_DIVERGING_EPOCH_TAG: []byte{0}}
+	redirected := fetchTestPartition(0)
+	redirected.PreferredReadReplica = 2
+
+	tests := []struct {
+		name      string
+		responses []FetchResponseTopic
+		minBytes  int
+		want      bool
+	}{
+		{"no records", fetchTestResponses(fetchTestPartition(0)), 1, false},
+		{"no records and no min bytes", fetchTestResponses(fetchTestPartition(0)), 0, false},
+		{"some records", fetchTestResponses(fetchTestPartition(10)), 1, true},
+		{"below min bytes", fetchTestResponses(fetchTestPartition(10), fetchTestPartition(10)), 50, false},
+		{"min bytes over partitions", fetchTestResponses(fetchTestPartition(30), fetchTestPartition(20)), 50, true},
+		{"failing partition", fetchTestResponses(fetchPartitionError(0, ERROR_CODE_NOT_LEADER_OR_FOLLOWER)), 1, true},
+		{"diverging partition", fetchTestResponses(diverging), 1, true},
+		{"read from another replica", fetchTestResponses(redirected), 1, true},
+	}
+	for _, test := range tests {
+		if got := fetchSatisfied(test.responses, test.minBytes); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
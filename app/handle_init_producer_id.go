@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type InitProducerIdRequestBody struct {
+	TransactionalId      ktypes.CompactNullableString `order:"1"`
+	TransactionTimeoutMs ktypes.Int32                 `order:"2"`
+	ProducerId           ktypes.Int64                 `order:"3"`
+	ProducerEpoch        ktypes.Int16                 `order:"4"`
+	TaggedFields         ktypes.TaggedFields          `order:"5"`
+}
+
+type InitProducerIdResponseBody struct {
+	ThrottleTimeMs ktypes.Int32        `order:"1"`
+	ErrorCode      ERROR_CODE          `order:"2"`
+	ProducerId     ktypes.Int64        `order:"3"`
+	ProducerEpoch  ktypes.Int16        `order:"4"`
+	TaggedFields   ktypes.TaggedFields `order:"5"`
+}
+
+func parseInitProducerIdRequestBody(body []byte) (*InitProducerIdRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody InitProducerIdRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode init producer id request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromInitProducerIdResponseBody(body *InitProducerIdResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode init producer id response: %v", err))
+	}
+	return encoded
+}
+
+// authorizeIdempotentWrite reports whether the client may produce
+// idempotently, which writing to any topic also allows.
+func authorizeIdempotentWrite(req *Request) bool {
+	if authorize(req, ACL_OPERATION_IDEMPOTENT_WRITE, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME) {
+		return true
+	}
+	for topicName := range topicNameToTopicId {
+		if authorize(req, ACL_OPERATION_WRITE, RESOURCE_TYPE_TOPIC, topicName) {
+			return true
+		}
+	}
+	return false
+}
+
+func handleInitProducerIdRequest(req *Request) *Response {
+	requestBody, err := parseInitProducerIdRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	responseBody := InitProducerIdResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		ErrorCode:      ERROR_CODE_NONE,
+		ProducerId:     ktypes.Int64(NO_PRODUCER_ID),
+		ProducerEpoch:  ktypes.Int16(NO_PRODUCER_EPOCH),
+	}
+
+	if requestBody.TransactionalId != "" && !authorize(req, ACL_OPERATION_WRITE, RESOURCE_TYPE_TRANSACTIONAL_ID, string(requestBody.TransactionalId)) {
+		responseBody.ErrorCode = ERROR_CODE_TRANSACTIONAL_ID_AUTHORIZATION_FAILED
+	} else if requestBody.TransactionalId == "" && !authorizeIdempotentWrite(req) {
+		responseBody.ErrorCode = ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED
+	} else if requestBody.TransactionalId != "" {
+		producerId, producerEpoch, errorCode := initTransactionalProducerId(string(requestBody.TransactionalId), int32(requestBody.TransactionTimeoutMs),
+			int64(requestBody.ProducerId), int16(requestBody.ProducerEpoch))
+		responseBody.ErrorCode = errorCode
+		responseBody.ProducerId = ktypes.Int64(producerId)
+		responseBody.ProducerEpoch = ktypes.Int16(producerEpoch)
+	} else {
+		// Idempotent producers always get a fresh id, which starts over at epoch 0
+		producerId, err := allocateProducerId()
+		if err != nil {
+			fmt.Println("Error allocating producer id: ", err.Error())
+			responseBody.ErrorCode = ERROR_CODE_UNKNOWN_SERVER_ERROR
+		} else {
+			responseBody.ProducerId = ktypes.Int64(producerId)
+			responseBody.ProducerEpoch = ktypes.Int16(0)
+		}
+	}
+
+	res.Body = generateBytesFromInitProducerIdResponseBody(&responseBody)
+	return &res
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+// Special timestamps of ListOffsets requests
+const (
+	LATEST_TIMESTAMP   = -1
+	EARLIEST_TIMESTAMP = -2
+	MAX_TIMESTAMP      = -3
+)
+
+type ListOffsetsRequestPartition struct {
+	PartitionIndex     ktypes.Int32        `order:"1"`
+	CurrentLeaderEpoch ktypes.Int32        `order:"2"`
+	Timestamp          ktypes.Int64        `order:"3"`
+	TaggedFields       ktypes.TaggedFields `order:"4"`
+}
+
+type ListOffsetsRequestTopic struct {
+	Name         ktypes.CompactString                             `order:"1"`
+	Partitions   ktypes.CompactArray[ListOffsetsRequestPartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                              `order:"3"`
+}
+
+type ListOffsetsRequestBody struct {
+	ReplicaId      ktypes.Int32                                 `order:"1"`
+	IsolationLevel ktypes.Int8                                  `order:"2"`
+	Topics         ktypes.CompactArray[ListOffsetsRequestTopic] `order:"3"`
+	TaggedFields   ktypes.TaggedFields                          `order:"4"`
+}
+
+type ListOffsetsResponsePartition struct {
+	PartitionIndex ktypes.Int32        `order:"1"`
+	ErrorCode      ERROR_CODE          `order:"2"`
+	Timestamp      ktypes.Int64        `order:"3"`
+	Offset         ktypes.Int64        `order:"4"`
+	LeaderEpoch    ktypes.Int32        `order:"5"`
+	TaggedFields   ktypes.TaggedFields `order:"6"`
+}
+
+type ListOffsetsResponseTopic struct {
+	Name         ktypes.CompactString                              `order:"1"`
+	Partitions   ktypes.CompactArray[ListOffsetsResponsePartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                               `order:"3"`
+}
+
+type ListOffsetsResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                  `order:"1"`
+	Topics         ktypes.CompactArray[ListOffsetsResponseTopic] `order:"2"`
+	TaggedFields   ktypes.TaggedFields                           `order:"3"`
+}
+
+func parseListOffsetsRequestBody(body []byte) (*ListOffsetsRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody ListOffsetsRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode list offsets request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromListOffsetsResponseBody(body *ListOffsetsResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode list offsets response: %v", err))
+	}
+	return encoded
+}
+
+// listPartitionOffset looks up the offset matching the requested timestamp.
+func listPartitionOffset(topicName string, partition ListOffsetsRequestPartition, isolationLevel ktypes.Int8) ListOffsetsResponsePartition {
+	response := ListOffsetsResponsePartition{
+		PartitionIndex: partition.PartitionIndex,
+		ErrorCode:      ERROR_CODE_NONE,
+		Timestamp:      ktypes.Int64(-1),
+		Offset:         ktypes.Int64(-1),
+		LeaderEpoch:    ktypes.Int32(-1),
+	}
+
+	topicId, ok := topicNameToTopicId[topicName]
+	if !ok || !slices.Contains(topicIdToPartitionIds[topicId], int32(partition.PartitionIndex)) {
+		response.ErrorCode = ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION
+		return response
+	}
+
+	log, err := getPartitionLog(topicName, int32(partition.PartitionIndex))
+	if err != nil {
+		fmt.Println("Error opening partition log: ", err.Error())
+		response.ErrorCode = errorCodeFromError(err)
+		return response
+	}
+
+	switch partition.Timestamp {
+	case EARLIEST_TIMESTAMP:
+		response.Offset = ktypes.Int64(log.LogStartOffset())
+	case LATEST_TIMESTAMP:
+		if isolationLevel == ISOLATION_LEVEL_READ_COMMITTED {
+			response.Offset = ktypes.Int64(log.LastStableOffset())
+		} else {
+			response.Offset = ktypes.Int64(log.HighWatermark())
+		}
+	default:
+		offset, timestamp, found, err := log.OffsetForTimestamp(int64(partition.Timestamp))
+		if err != nil {
+			fmt.Println("Error reading partition log: ", err.Error())
+			response.ErrorCode = ERROR_CODE_UNKNOWN_SERVER_ERROR
+		} else if found {
+			response.Offset = ktypes.Int64(offset)
+			response.Timestamp = ktypes.Int64(timestamp)
+		}
+	}
+	return response
+}
+
+func handleListOffsetsRequest(req *Request) *Response {
+	requestBody, err := parseListOffsetsRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	topics := make([]ListOffsetsResponseTopic, 0, len(requestBody.Topics))
+	for _, topic := range requestBody.Topics {
+		authorized := authorize(req, ACL_OPERATION_DESCRIBE, RESOURCE_TYPE_TOPIC, string(topic.Name))
+		partitions := make([]ListOffsetsResponsePartition, 0, len(topic.Partitions))
+		for _, partition := range topic.Partitions {
+			if !authorized {
+				partitions = append(partitions, ListOffsetsResponsePartition{
+					PartitionIndex: partition.PartitionIndex,
+					ErrorCode:      ERROR_CODE_TOPIC_AUTHORIZATION_FAILED,
+					Timestamp:      ktypes.Int64(-1),
+					Offset:         ktypes.Int64(-1),
+					LeaderEpoch:    ktypes.Int32(-1),
+				})
+				continue
+			}
+			partitions = append(partitions, listPartitionOffset(string(topic.Name), partition, requestBody.IsolationLevel))
+		}
+		topics = append(topics, ListOffsetsResponseTopic{
+			Name:       topic.Name,
+			Partitions: partitions,
+		})
+	}
+
+	responseBody := ListOffsetsResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		Topics:         topics,
+	}
+
+	res.Body = generateBytesFromListOffsetsResponseBody(&responseBody)
+	return &res
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type ListPartitionReassignmentsRequestTopic struct {
+	Name             ktypes.CompactString              `order:"1"`
+	PartitionIndexes ktypes.CompactArray[ktypes.Int32] `order:"2"`
+	TaggedFields     ktypes.TaggedFields               `order:"3"`
+}
+
+type ListPartitionReassignmentsRequestBody struct {
+	TimeoutMs ktypes.Int32 `order:"1"`
+	// Null lists the reassignments of every topic
+	Topics       ktypes.CompactArray[ListPartitionReassignmentsRequestTopic] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                         `order:"3"`
+}
+
+type ListPartitionReassignmentsResponsePartition struct {
+	PartitionIndex   ktypes.Int32                      `order:"1"`
+	Replicas         ktypes.CompactArray[ktypes.Int32] `order:"2"`
+	AddingReplicas   ktypes.CompactArray[ktypes.Int32] `order:"3"`
+	RemovingReplicas ktypes.CompactArray[ktypes.Int32] `order:"4"`
+	TaggedFields     ktypes.TaggedFields               `order:"5"`
+}
+
+type ListPartitionReassignmentsResponseTopic struct {
+	Name         ktypes.CompactString                                             `order:"1"`
+	Partitions   ktypes.CompactArray[ListPartitionReassignmentsResponsePartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                              `order:"3"`
+}
+
+type ListPartitionReassignmentsResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                                 `order:"1"`
+	ErrorCode      ERROR_CODE                                                   `order:"2"`
+	ErrorMessage   ktypes.CompactNullableString                                 `order:"3"`
+	Topics         ktypes.CompactArray[ListPartitionReassignmentsResponseTopic] `order:"4"`
+	TaggedFields   ktypes.TaggedFields                                          `order:"5"`
+}
+
+func parseListPartitionReassignmentsRequestBody(body []byte) (*ListPartitionReassignmentsRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody ListPartitionReassignmentsRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode list partition reassignments request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromListPartitionReassignmentsResponseBody(body *ListPartitionReassignmentsResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode list partition reassignments response: %v", err))
+	}
+	return encoded
+}
+
+// listReassignments returns the ongoing reassignments of the requested
+// topics, skipping the unknown ones.
+func listReassignments(topics []ListPartitionReassignmentsRequestTopic) []ListPartitionReassignmentsResponseTopic {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	if topics == nil {
+		topics = make([]ListPartitionReassignmentsRequestTopic, 0, len(topicNameToTopicId))
+		for topicName := range topicNameToTopicId {
+			topics = append(topics, ListPartitionReassignmentsRequestTopic{Name: ktypes.CompactString(topicName)})
+		}
+	}
+	responseTopics := make([]ListPartitionReassignmentsResponseTopic, 0)
+	for _, topic := range topics {
+		topicId, ok := topicNameToTopicId[string(topic.Name)]
+		if !ok {
+			continue
+		}
+		var partitionIds []int32
+		if topic.PartitionIndexes != nil {
+			partitionIds = toInt32Slice(topic.PartitionIndexes)
+		}
+		reassignments := listPartitionReassignments(topicId, partitionIds)
+		if len(reassignments) == 0 {
+			continue
+		}
+		partitions := make([]ListPartitionReassignmentsResponsePartition, len(reassignments))
+		for i, partition := range reassignments {
+			partitions[i] = ListPartitionReassignmentsResponsePartition{
+				PartitionIndex:   partition.PartitionId,
+				Replicas:         toInt32Array(partition.Replicas),
+				AddingReplicas:   toInt32Array(partition.AddingReplicas),
+				RemovingReplicas: toInt32Array(partition.RemovingReplicas),
+			}
+		}
+		responseTopics = append(responseTopics, ListPartitionReassignmentsResponseTopic{
+			Name:       topic.Name,
+			Partitions: partitions,
+		})
+	}
+	return responseTopics
+}
+
+// handleListPartitionReassignmentsRequest lists the ongoing reassignments,
+// as the metadata log applied by this broker has them.
+func handleListPartitionReassignmentsRequest(req *Request) *Response {
+	requestBody, err := parseListPartitionReassignmentsRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	responseBody := ListPartitionReassignmentsResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		ErrorCode:      ERROR_CODE_NONE,
+		Topics:         []ListPartitionReassignmentsResponseTopic{},
+	}
+	if !authorize(req, ACL_OPERATION_DESCRIBE, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME) {
+		responseBody.ErrorCode = ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED
+	} else {
+		responseBody.Topics = listReassignments(requestBody.Topics)
+	}
+
+	res.Body = generateBytesFromListPartitionReassignmentsResponseBody(&responseBody)
+	return &res
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type MetadataRequestTopic struct {
+	TopicId      ktypes.UUID                  `order:"1"`
+	Name         ktypes.CompactNullableString `order:"2"`
+	TaggedFields ktypes.TaggedFields          `order:"3"`
+}
+
+type MetadataRequestBody struct {
+	Topics                           ktypes.CompactArray[MetadataRequestTopic] `order:"1"`
+	AllowAutoTopicCreation           ktypes.Bool                               `order:"2"`
+	IncludeTopicAuthorizedOperations ktypes.Bool                               `order:"3"`
+	TaggedFields                     ktypes.TaggedFields                       `order:"4"`
+}
+
+type MetadataResponseBroker struct {
+	NodeId       ktypes.Int32                 `order:"1"`
+	Host         ktypes.CompactString         `order:"2"`
+	Port         ktypes.Int32                 `order:"3"`
+	Rack         ktypes.CompactNullableString `order:"4"`
+	TaggedFields ktypes.TaggedFields          `order:"5"`
+}
+
+type MetadataResponsePartition struct {
+	ErrorCode       ERROR_CODE                        `order:"1"`
+	PartitionIndex  ktypes.Int32                      `order:"2"`
+	LeaderId        ktypes.Int32                      `order:"3"`
+	LeaderEpoch     ktypes.Int32                      `order:"4"`
+	ReplicaNodes    ktypes.CompactArray[ktypes.Int32] `order:"5"`
+	IsrNodes        ktypes.CompactArray[ktypes.Int32] `order:"6"`
+	OfflineReplicas ktypes.CompactArray[ktypes.Int32] `order:"7"`
+	TaggedFields    ktypes.TaggedFields               `order:"8"`
+}
+
+type MetadataResponseTopic struct {
+	ErrorCode                 ERROR_CODE                                     `order:"1"`
+	Name                      ktypes.CompactNullableString                   `order:"2"`
+	TopicId                   ktypes.UUID                                    `order:"3"`
+	IsInternal                ktypes.Bool                                    `order:"4"`
+	Partitions                ktypes.CompactArray[MetadataResponsePartition] `order:"5"`
+	TopicAuthorizedOperations ktypes.Int32                                   `order:"6"`
+	TaggedFields              ktypes.TaggedFields                            `order:"7"`
+}
+
+type MetadataResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                `order:"1"`
+	Brokers        ktypes.CompactArray[MetadataResponseBroker] `order:"2"`
+	ClusterId      ktypes.CompactNullableString                `order:"3"`
+	ControllerId   ktypes.Int32                                `order:"4"`
+	Topics         ktypes.CompactArray[MetadataResponseTopic]  `order:"5"`
+	TaggedFields   ktypes.TaggedFields                         `order:"6"`
+}
+
+func parseMetadataRequestBody(body []byte) (*MetadataRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody MetadataRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode metadata request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromMetadataResponseBody(body *MetadataResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode metadata response: %v", err))
+	}
+	return encoded
+}
+
+func toInt32Array(values ktypes.CompactArray[ktypes.Int32]) ktypes.CompactArray[ktypes.Int32] {
+	if values == nil {
+		return []ktypes.Int32{}
+	}
+	return values
+}
+
+// metadataTopic describes a topic's partitions. Leaders without an
+// advertised address on the client's listener cannot be reached, which is
+// reported per partition.
+func metadataTopic(req *Request, topicName string, topicId ktypes.UUID, reachable map[int32]bool, includeAuthorizedOperations bool) MetadataResponseTopic {
+	topic := MetadataResponseTopic{
+		ErrorCode:                 ERROR_CODE_NONE,
+		Name:                      ktypes.CompactNullableString(topicName),
+		TopicId:                   topicId,
+		IsInternal:                ktypes.Bool(false),
+		Partitions:                []MetadataResponsePartition{},
+		TopicAuthorizedOperations: ktypes.Int32(math.MinInt32), // not requested
+	}
+	// Unauthorized clients cannot tell whether the topic exists
+	if !authorize(req, ACL_OPERATION_DESCRIBE, RESOURCE_TYPE_TOPIC, topicName) {
+		topic.ErrorCode = ERROR_CODE_TOPIC_AUTHORIZATION_FAILED
+		topic.TopicId = NULL_UUID
+		return topic
+	}
+	if topicId == NULL_UUID {
+		topic.ErrorCode = ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION
+		return topic
+	}
+	if includeAuthorizedOperations {
+		topic.TopicAuthorizedOperations = ktypes.Int32(authorizedOperations(req, RESOURCE_TYPE_TOPIC, topicName))
+	}
+
+	for _, partition := range topicIdToPartitions[topicId] {
+		errorCode := ERROR_CODE_NONE
+		if partition.Leader != NO_LEADER && !reachable[int32(partition.Leader)] {
+			errorCode = ERROR_CODE_LISTENER_NOT_FOUND
+		}
+		topic.Partitions = append(topic.Partitions, MetadataResponsePartition{
+			ErrorCode:       errorCode,
+			PartitionIndex:  partition.PartitionId,
+			LeaderId:        partition.Leader,
+			LeaderEpoch:     partition.LeaderEpoch,
+			ReplicaNodes:    toInt32Array(partition.Replicas),
+			IsrNodes:        toInt32Array(partition.InSyncReplicas),
+			OfflineReplicas: offlineReplicas(&partition),
+		})
+	}
+	return topic
+}
+
+func handleMetadataRequest(req *Request) *Response {
+	requestBody, err := parseMetadataRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	// Clients only learn the addresses on the listener they connected to
+	brokers := make([]MetadataResponseBroker, 0, 1)
+	reachable := make(map[int32]bool)
+	for _, brokerId := range liveBrokerIds() {
+		listener, ok := brokerEndpoint(brokerId, req.Session.Listener.Name)
+		if !ok {
+			continue
+		}
+		reachable[brokerId] = true
+		broker := MetadataResponseBroker{
+			NodeId: ktypes.Int32(brokerId),
+			Host:   ktypes.CompactString(listener.Host),
+			Port:   ktypes.Int32(listener.Port),
+		}
+		if rack := brokerRack(brokerId); rack != "" {
+			broker.Rack = ktypes.CompactNullableString(rack)
+		}
+		brokers = append(brokers, broker)
+	}
+
+	topics := make([]MetadataResponseTopic, 0)
+	if requestBody.Topics == nil {
+		// A null topic list asks for every topic
+		names := make([]string, 0, len(topicNameToTopicId))
+		for name := range topicNameToTopicId {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			// Topics the client may not describe are left out of the list
+			if !authorize(req, ACL_OPERATION_DESCRIBE, RESOURCE_TYPE_TOPIC, name) {
+				continue
+			}
+			topics = append(topics, metadataTopic(req, name, topicNameToTopicId[name], reachable, bool(requestBody.IncludeTopicAuthorizedOperations)))
+		}
+	} else {
+		for _, requestTopic := range requestBody.Topics {
+			name := string(requestTopic.Name)
+			topicId := requestTopic.TopicId
+			if name != "" {
+				topicId = topicNameToTopicId[name]
+			} else {
+				name = topicIdToTopicName[topicId]
+			}
+			topics = append(topics, metadataTopic(req, name, topicId, reachable, bool(requestBody.IncludeTopicAuthorizedOperations)))
+		}
+	}
+
+	// Admin requests reach the active controller, through any broker
+	controllerId, _ := raftClient.currentLeader()
+	responseBody := MetadataResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		Brokers:        brokers,
+		ClusterId:      ktypes.CompactNullableString(clusterId),
+		ControllerId:   ktypes.Int32(controllerId),
+		Topics:         topics,
+	}
+
+	res.Body = generateBytesFromMetadataResponseBody(&responseBody)
+	return &res
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type OffsetCommitRequestPartition struct {
+	PartitionIndex       ktypes.Int32                 `order:"1"`
+	CommittedOffset      ktypes.Int64                 `order:"2"`
+	CommittedLeaderEpoch ktypes.Int32                 `order:"3"`
+	CommittedMetadata    ktypes.CompactNullableString `order:"4"`
+	TaggedFields         ktypes.TaggedFields          `order:"5"`
+}
+
+type OffsetCommitRequestTopic struct {
+	Name         ktypes.CompactString                              `order:"1"`
+	Partitions   ktypes.CompactArray[OffsetCommitRequestPartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                               `order:"3"`
+}
+
+type OffsetCommitRequestBody struct {
+	GroupId                   ktypes.CompactString                          `order:"1"`
+	GenerationIdOrMemberEpoch ktypes.Int32                                  `order:"2"`
+	MemberId                  ktypes.CompactString                          `order:"3"`
+	GroupInstanceId           ktypes.CompactNullableString                  `order:"4"`
+	Topics                    ktypes.CompactArray[OffsetCommitRequestTopic] `order:"5"`
+	TaggedFields              ktypes.TaggedFields                           `order:"6"`
+}
+
+type OffsetCommitResponsePartition struct {
+	PartitionIndex ktypes.Int32        `order:"1"`
+	ErrorCode      ERROR_CODE          `order:"2"`
+	TaggedFields   ktypes.TaggedFields `order:"3"`
+}
+
+type OffsetCommitResponseTopic struct {
+	Name         ktypes.CompactString                               `order:"1"`
+	Partitions   ktypes.CompactArray[OffsetCommitResponsePartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                `order:"3"`
+}
+
+type OffsetCommitResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                   `order:"1"`
+	Topics         ktypes.CompactArray[OffsetCommitResponseTopic] `order:"2"`
+	TaggedFields   ktypes.TaggedFields                            `order:"3"`
+}
+
+func parseOffsetCommitRequestBody(body []byte) (*OffsetCommitRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody OffsetCommitRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode offset commit request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromOffsetCommitResponseBody(body *OffsetCommitResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode offset commit response: %v", err))
+	}
+	return encoded
+}
+
+// offsetCommitResponseTopics answers every requested partition with the same error code.
+func offsetCommitResponseTopics(topics []OffsetCommitRequestTopic, errorCode ERROR_CODE) []OffsetCommitResponseTopic {
+	responseTopics := make([]OffsetCommitResponseTopic, len(topics))
+	for i, topic := range topics {
+		partitions := make([]OffsetCommitResponsePartition, len(topic.Partitions))
+		for j, partition := range topic.Partitions {
+			partitions[j] = OffsetCommitResponsePartition{
+				PartitionIndex: partition.PartitionIndex,
+				ErrorCode:      errorCode,
+			}
+		}
+		responseTopics[i] = OffsetCommitResponseTopic{
+			Name:       topic.Name,
+			Partitions: partitions,
+		}
+	}
+	return responseTopics
+}
+
+// validateOffsetCommitPartition returns the error code for a single partition of the commit.
+func validateOffsetCommitPartition(topicName string, partition OffsetCommitRequestPartition) ERROR_CODE {
+	topicId, ok := topicNameToTopicId[topicName]
+	if !ok {
+		return ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION
+	}
+	if !slices.Contains(topicIdToPartitionIds[topicId], int32(partition.PartitionIndex)) {
+		return ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION
+	}
+	if len(partition.CommittedMetadata) > OFFSET_METADATA_MAX_BYTES {
+		return ERROR_CODE_OFFSET_METADATA_TOO_LARGE
+	}
+	return ERROR_CODE_NONE
+}
+
+func handleOffsetCommitRequest(req *Request) *Response {
+	requestBody, err := parseOffsetCommitRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	groupId := string(requestBody.GroupId)
+	var groupErrorCode ERROR_CODE = ERROR_CODE_NONE
+	if groupId == "" {
+		groupErrorCode = ERROR_CODE_INVALID_GROUP_ID
+	} else if !authorize(req, ACL_OPERATION_READ, RESOURCE_TYPE_GROUP, groupId) {
+		groupErrorCode = ERROR_CODE_GROUP_AUTHORIZATION_FAILED
+	} else {
+		groupErrorCode = validateConsumerGroupOffsetCommit(groupId, string(requestBody.MemberId), int32(requestBody.GenerationIdOrMemberEpoch))
+	}
+
+	var responseTopics []OffsetCommitResponseTopic
+	if groupErrorCode != ERROR_CODE_NONE {
+		responseTopics = offsetCommitResponseTopics(requestBody.Topics, groupErrorCode)
+	} else {
+		commitTimestamp := time.Now().UnixMilli()
+		offsets := make([]TopicPartitionOffset, 0)
+		responseTopics = make([]OffsetCommitResponseTopic, len(requestBody.Topics))
+		for i, topic := range requestBody.Topics {
+			authorized := authorize(req, ACL_OPERATION_READ, RESOURCE_TYPE_TOPIC, string(topic.Name))
+			partitions := make([]OffsetCommitResponsePartition, len(topic.Partitions))
+			for j, partition := range topic.Partitions {
+				errorCode := ERROR_CODE_TOPIC_AUTHORIZATION_FAILED
+				if authorized {
+					errorCode = validateOffsetCommitPartition(string(topic.Name), partition)
+				}
+				if errorCode == ERROR_CODE_NONE {
+					offsets = append(offsets, TopicPartitionOffset{
+						Topic:     string(topic.Name),
+						Partition: int32(partition.PartitionIndex),
+						CommittedOffset: CommittedOffset{
+							Offset:          int64(partition.CommittedOffset),
+							LeaderEpoch:     int32(partition.CommittedLeaderEpoch),
+							Metadata:        string(partition.CommittedMetadata),
+							CommitTimestamp: commitTimestamp,
+						},
+					})
+				}
+				partitions[j] = OffsetCommitResponsePartition{
+					PartitionIndex: partition.PartitionIndex,
+					ErrorCode:      errorCode,
+				}
+			}
+			responseTopics[i] = OffsetCommitResponseTopic{
+				Name:       topic.Name,
+				Partitions: partitions,
+			}
+		}
+
+		if err := commitOffsets(groupId, offsets); err != nil {
+			fmt.Println("Error committing offsets: ", err.Error())
+			responseTopics = offsetCommitResponseTopics(requestBody.Topics, ERROR_CODE_UNKNOWN_SERVER_ERROR)
+		}
+	}
+
+	responseBody := OffsetCommitResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		Topics:         responseTopics,
+	}
+
+	res.Body = generateBytesFromOffsetCommitResponseBody(&responseBody)
+	return &res
+}
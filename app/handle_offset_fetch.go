@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type OffsetFetchRequestTopic struct {
+	Name             ktypes.CompactString              `order:"1"`
+	PartitionIndexes ktypes.CompactArray[ktypes.Int32] `order:"2"`
+	TaggedFields     ktypes.TaggedFields               `order:"3"`
+}
+
+type OffsetFetchRequestGroup struct {
+	GroupId      ktypes.CompactString                         `order:"1"`
+	Topics       ktypes.CompactArray[OffsetFetchRequestTopic] `order:"2"` // null fetches every committed partition
+	TaggedFields ktypes.TaggedFields                          `order:"3"`
+}
+
+type OffsetFetchRequestBody struct {
+	Groups        ktypes.CompactArray[OffsetFetchRequestGroup] `order:"1"`
+	RequireStable ktypes.Bool                                  `order:"2"`
+	TaggedFields  ktypes.TaggedFields                          `order:"3"`
+}
+
+type OffsetFetchResponsePartition struct {
+	PartitionIndex       ktypes.Int32                 `order:"1"`
+	CommittedOffset      ktypes.Int64                 `order:"2"`
+	CommittedLeaderEpoch ktypes.Int32                 `order:"3"`
+	Metadata             ktypes.CompactNullableString `order:"4"`
+	ErrorCode            ERROR_CODE                   `order:"5"`
+	TaggedFields         ktypes.TaggedFields          `order:"6"`
+}
+
+type OffsetFetchResponseTopic struct {
+	Name         ktypes.CompactString                              `order:"1"`
+	Partitions   ktypes.CompactArray[OffsetFetchResponsePartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                               `order:"3"`
+}
+
+type OffsetFetchResponseGroup struct {
+	GroupId      ktypes.CompactString                          `order:"1"`
+	Topics       ktypes.CompactArray[OffsetFetchResponseTopic] `order:"2"`
+	ErrorCode    ERROR_CODE                                    `order:"3"`
+	TaggedFields ktypes.TaggedFields                           `order:"4"`
+}
+
+type OffsetFetchResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                  `order:"1"`
+	Groups         ktypes.CompactArray[OffsetFetchResponseGroup] `order:"2"`
+	TaggedFields   ktypes.TaggedFields                           `order:"3"`
+}
+
+func parseOffsetFetchRequestBody(body []byte) (*OffsetFetchRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody OffsetFetchRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode offset fetch request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromOffsetFetchResponseBody(body *OffsetFetchResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode offset fetch response: %v", err))
+	}
+	return encoded
+}
+
+func offsetFetchResponsePartition(partitionIndex int32, committed CommittedOffset, ok bool) OffsetFetchResponsePartition {
+	if !ok {
+		// No committed offset is reported as -1 without an error
+		return OffsetFetchResponsePartition{
+			PartitionIndex:       ktypes.Int32(partitionIndex),
+			CommittedOffset:      ktypes.Int64(-1),
+			CommittedLeaderEpoch: ktypes.Int32(-1),
+			ErrorCode:            ERROR_CODE_NONE,
+		}
+	}
+	return OffsetFetchResponsePartition{
+		PartitionIndex:       ktypes.Int32(partitionIndex),
+		CommittedOffset:      ktypes.Int64(committed.Offset),
+		CommittedLeaderEpoch: ktypes.Int32(committed.LeaderEpoch),
+		Metadata:             ktypes.CompactNullableString(committed.Metadata),
+		ErrorCode:            ERROR_CODE_NONE,
+	}
+}
+
+// fetchGroupOffsets builds the response for one group of the request. With
+// requireStable, partitions with offsets pending in an open transaction are
+// reported as UNSTABLE_OFFSET_COMMIT so the client retries.
+func fetchGroupOffsets(req *Request, group OffsetFetchRequestGroup, requireStable bool) OffsetFetchResponseGroup {
+	groupId := string(group.GroupId)
+	if groupId == "" {
+		return OffsetFetchResponseGroup{
+			GroupId:   group.GroupId,
+			Topics:    []OffsetFetchResponseTopic{},
+			ErrorCode: ERROR_CODE_INVALID_GROUP_ID,
+		}
+	}
+	if !authorize(req, ACL_OPERATION_DESCRIBE, RESOURCE_TYPE_GROUP, groupId) {
+		return OffsetFetchResponseGroup{
+			GroupId:   group.GroupId,
+			Topics:    []OffsetFetchResponseTopic{},
+			ErrorCode: ERROR_CODE_GROUP_AUTHORIZATION_FAILED,
+		}
+	}
+
+	topics := []OffsetFetchResponseTopic{}
+	if group.Topics == nil {
+		// All committed offsets of the group, grouped by topic
+		committedOffsets := getGroupCommittedOffsets(groupId)
+		slices.SortFunc(committedOffsets, func(a, b TopicPartitionOffset) int {
+			if c := strings.Compare(a.Topic, b.Topic); c != 0 {
+				return c
+			}
+			return int(a.Partition - b.Partition)
+		})
+		for _, committed := range committedOffsets {
+			// Topics the client may not describe are left out
+			if !authorize(req, ACL_OPERATION_DESCRIBE, RESOURCE_TYPE_TOPIC, committed.Topic) {
+				continue
+			}
+			if len(topics) == 0 || string(topics[len(topics)-1].Name) != committed.Topic {
+				topics = append(topics, OffsetFetchResponseTopic{
+					Name:       ktypes.CompactString(committed.Topic),
+					Partitions: []OffsetFetchResponsePartition{},
+				})
+			}
+			last := &topics[len(topics)-1]
+			last.Partitions = append(last.Partitions, offsetFetchResponsePartition(committed.Partition, committed.CommittedOffset, true))
+		}
+	} else {
+		for _, topic := range group.Topics {
+			authorized := authorize(req, ACL_OPERATION_DESCRIBE, RESOURCE_TYPE_TOPIC, string(topic.Name))
+			partitions := make([]OffsetFetchResponsePartition, len(topic.PartitionIndexes))
+			for i, partitionIndex := range topic.PartitionIndexes {
+				if !authorized {
+					partitions[i] = offsetFetchResponsePartition(int32(partitionIndex), CommittedOffset{}, false)
+					partitions[i].ErrorCode = ERROR_CODE_TOPIC_AUTHORIZATION_FAILED
+					continue
+				}
+				committed, ok := getCommittedOffset(groupId, string(topic.Name), int32(partitionIndex))
+				partitions[i] = offsetFetchResponsePartition(int32(partitionIndex), committed, ok)
+				if requireStable && hasPendingTxnOffset(groupId, string(topic.Name), int32(partitionIndex)) {
+					partitions[i].ErrorCode = ERROR_CODE_UNSTABLE_OFFSET_COMMIT
+				}
+			}
+			topics = append(topics, OffsetFetchResponseTopic{
+				Name:       topic.Name,
+				Partitions: partitions,
+			})
+		}
+	}
+
+	return OffsetFetchResponseGroup{
+		GroupId:   group.GroupId,
+		Topics:    topics,
+		ErrorCode: ERROR_CODE_NONE,
+	}
+}
+
+func handleOffsetFetchRequest(req *Request) *Response {
+	requestBody, err := parseOffsetFetchRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	groups := make([]OffsetFetchResponseGroup, len(requestBody.Groups))
+	for i, group := range requestBody.Groups {
+		groups[i] = fetchGroupOffsets(req, group, bool(requestBody.RequireStable))
+	}
+
+	responseBody := OffsetFetchResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		Groups:         groups,
+	}
+
+	res.Body = generateBytesFromOffsetFetchResponseBody(&responseBody)
+	return &res
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type OffsetForLeaderEpochRequestPartition struct {
+	Partition          ktypes.Int32        `order:"1"`
+	CurrentLeaderEpoch ktypes.Int32        `order:"2"`
+	LeaderEpoch        ktypes.Int32        `order:"3"`
+	TaggedFields       ktypes.TaggedFields `order:"4"`
+}
+
+type OffsetForLeaderEpochRequestTopic struct {
+	Topic        ktypes.CompactString                                      `order:"1"`
+	Partitions   ktypes.CompactArray[OffsetForLeaderEpochRequestPartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                       `order:"3"`
+}
+
+type OffsetForLeaderEpochRequestBody struct {
+	// Broker id of followers, -1 for consumers
+	ReplicaId    ktypes.Int32                                          `order:"1"`
+	Topics       ktypes.CompactArray[OffsetForLeaderEpochRequestTopic] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                   `order:"3"`
+}
+
+type OffsetForLeaderEpochResponsePartition struct {
+	ErrorCode    ERROR_CODE          `order:"1"`
+	Partition    ktypes.Int32        `order:"2"`
+	LeaderEpoch  ktypes.Int32        `order:"3"`
+	EndOffset    ktypes.Int64        `order:"4"`
+	TaggedFields ktypes.TaggedFields `order:"5"`
+}
+
+type OffsetForLeaderEpochResponseTopic struct {
+	Topic        ktypes.CompactString                                       `order:"1"`
+	Partitions   ktypes.CompactArray[OffsetForLeaderEpochResponsePartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                                        `order:"3"`
+}
+
+type OffsetForLeaderEpochResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                           `order:"1"`
+	Topics         ktypes.CompactArray[OffsetForLeaderEpochResponseTopic] `order:"2"`
+	TaggedFields   ktypes.TaggedFields                                    `order:"3"`
+}
+
+func parseOffsetForLeaderEpochRequestBody(body []byte) (*OffsetForLeaderEpochRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody OffsetForLeaderEpochRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode offset for leader epoch request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromOffsetForLeaderEpochResponseBody(body *OffsetForLeaderEpochResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode offset for leader epoch response: %v", err))
+	}
+	return encoded
+}
+
+// partitionEpochEndOffset looks up where the requested leader epoch ends in
+// the partition's log, which clients truncate to when theirs goes further.
+func partitionEpochEndOffset(topicName string, partition OffsetForLeaderEpochRequestPartition) OffsetForLeaderEpochResponsePartition {
+	response := OffsetForLeaderEpochResponsePartition{
+		ErrorCode:   ERROR_CODE_NONE,
+		Partition:   partition.Partition,
+		LeaderEpoch: ktypes.Int32(UNDEFINED_EPOCH),
+		EndOffset:   ktypes.Int64(UNDEFINED_EPOCH_OFFSET),
+	}
+
+	topicId, ok := topicNameToTopicId[topicName]
+	if !ok || !slices.Contains(topicIdToPartitionIds[topicId], int32(partition.Partition)) {
+		response.ErrorCode = ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION
+		return response
+	}
+	kafkaErr := checkLeader(topicName, int32(partition.Partition))
+	if kafkaErr == nil {
+		kafkaErr = checkLeaderEpoch(topicName, int32(partition.Partition), int32(partition.CurrentLeaderEpoch))
+	}
+	if kafkaErr != nil {
+		response.ErrorCode = kafkaErr.Code
+		return response
+	}
+
+	log, err := getPartitionLog(topicName, int32(partition.Partition))
+	if err != nil {
+		fmt.Println("Error opening partition log: ", err.Error())
+		response.ErrorCode = errorCodeFromError(err)
+		return response
+	}
+	epoch, endOffset := log.EndOffsetForEpoch(int32(partition.LeaderEpoch))
+	response.LeaderEpoch = ktypes.Int32(epoch)
+	response.EndOffset = ktypes.Int64(endOffset)
+	return response
+}
+
+func handleOffsetForLeaderEpochRequest(req *Request) *Response {
+	requestBody, err := parseOffsetForLeaderEpochRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	// Followers are authorized on the cluster, consumers on each topic
+	clusterAuthorized := requestBody.ReplicaId < 0 ||
+		authorize(req, ACL_OPERATION_CLUSTER_ACTION, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME)
+
+	topics := make([]OffsetForLeaderEpochResponseTopic, 0, len(requestBody.Topics))
+	for _, topic := range requestBody.Topics {
+		errorCode := ERROR_CODE_NONE
+		if !clusterAuthorized {
+			errorCode = ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED
+		} else if requestBody.ReplicaId < 0 && !authorize(req, ACL_OPERATION_DESCRIBE, RESOURCE_TYPE_TOPIC, string(topic.Topic)) {
+			errorCode = ERROR_CODE_TOPIC_AUTHORIZATION_FAILED
+		}
+
+		partitions := make([]OffsetForLeaderEpochResponsePartition, 0, len(topic.Partitions))
+		for _, partition := range topic.Partitions {
+			if errorCode != ERROR_CODE_NONE {
+				partitions = append(partitions, OffsetForLeaderEpochResponsePartition{
+					ErrorCode:   errorCode,
+					Partition:   partition.Partition,
+					LeaderEpoch: ktypes.Int32(UNDEFINED_EPOCH),
+					EndOffset:   ktypes.Int64(UNDEFINED_EPOCH_OFFSET),
+				})
+				continue
+			}
+			partitions = append(partitions, partitionEpochEndOffset(string(topic.Topic), partition))
+		}
+		topics = append(topics, OffsetForLeaderEpochResponseTopic{
+			Topic:      topic.Topic,
+			Partitions: partitions,
+		})
+	}
+
+	responseBody := OffsetForLeaderEpochResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		Topics:         topics,
+	}
+
+	res.Body = generateBytesFromOffsetForLeaderEpochResponseBody(&responseBody)
+	return &res
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+// Produce requests waiting for the records to be durable
+const PRODUCE_ACKS_ALL = -1
+
+type ProduceRequestPartition struct {
+	Index        ktypes.Int32          `order:"1"`
+	Records      ktypes.CompactRecords `order:"2"`
+	TaggedFields ktypes.TaggedFields   `order:"3"`
+}
+
+type ProduceRequestTopic struct {
+	Name          ktypes.CompactString                         `order:"1"`
+	PartitionData ktypes.CompactArray[ProduceRequestPartition] `order:"2"`
+	TaggedFields  ktypes.TaggedFields                          `order:"3"`
+}
+
+type ProduceRequestBody struct {
+	TransactionalId ktypes.CompactNullableString             `order:"1"`
+	Acks            ktypes.Int16                             `order:"2"`
+	TimeoutMs       ktypes.Int32                             `order:"3"`
+	TopicData       ktypes.CompactArray[ProduceRequestTopic] `order:"4"`
+	TaggedFields    ktypes.TaggedFields                      `order:"5"`
+}
+
+type ProduceResponseRecordError struct {
+	BatchIndex             ktypes.Int32                 `order:"1"`
+	BatchIndexErrorMessage ktypes.CompactNullableString `order:"2"`
+	TaggedFields           ktypes.TaggedFields          `order:"3"`
+}
+
+type ProduceResponsePartition struct {
+	Index           ktypes.Int32                                    `order:"1"`
+	ErrorCode       ERROR_CODE                                      `order:"2"`
+	BaseOffset      ktypes.Int64                                    `order:"3"`
+	LogAppendTimeMs ktypes.Int64                                    `order:"4"`
+	LogStartOffset  ktypes.Int64                                    `order:"5"`
+	RecordErrors    ktypes.CompactArray[ProduceResponseRecordError] `order:"6"`
+	ErrorMessage    ktypes.CompactNullableString                    `order:"7"`
+	TaggedFields    ktypes.TaggedFields                             `order:"8"`
+}
+
+type ProduceResponseTopic struct {
+	Name               ktypes.CompactString                          `order:"1"`
+	PartitionResponses ktypes.CompactArray[ProduceResponsePartition] `order:"2"`
+	TaggedFields       ktypes.TaggedFields                           `order:"3"`
+}
+
+type ProduceResponseBody struct {
+	Responses      ktypes.CompactArray[ProduceResponseTopic] `order:"1"`
+	ThrottleTimeMs ktypes.Int32                              `order:"2"`
+	TaggedFields   ktypes.TaggedFields                       `order:"3"`
+}
+
+func parseProduceRequestBody(body []byte) (*ProduceRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody ProduceRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode produce request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromProduceResponseBody(body *ProduceResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode produce response: %v", err))
+	}
+	return encoded
+}
+
+// ReplicationWait is an acks=all write waiting for the in-sync replicas to
+// have its records before its partition response is complete.
+type ReplicationWait struct {
+	TopicName  string
+	Log        *PartitionLog
+	BaseOffset int64
+	EndOffset  int64
+	Response   *ProduceResponsePartition
+}
+
+// producePartition appends the partition's batches to its log and builds the
+// partition response. With acks=all the batches are on disk once it returns,
+// and the wait for the in-sync replicas is returned to be completed with
+// completeReplication.
+func producePartition(topicName string, partition ProduceRequestPartition, acks int16) (ProduceResponsePartition, *ReplicationWait) {
+	response := ProduceResponsePartition{
+		Index:           partition.Index,
+		ErrorCode:       ERROR_CODE_NONE,
+		BaseOffset:      ktypes.Int64(-1),
+		LogAppendTimeMs: ktypes.Int64(-1),
+		LogStartOffset:  ktypes.Int64(-1),
+		RecordErrors:    []ProduceResponseRecordError{},
+	}
+
+	topicId, ok := topicNameToTopicId[topicName]
+	if !ok || !slices.Contains(topicIdToPartitionIds[topicId], int32(partition.Index)) {
+		response.ErrorCode = ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION
+		return response, nil
+	}
+	if kafkaErr := checkLeader(topicName, int32(partition.Index)); kafkaErr != nil {
+		response.ErrorCode = kafkaErr.Code
+		return response, nil
+	}
+	if acks == PRODUCE_ACKS_ALL {
+		if kafkaErr := checkMinInsyncReplicas(topicName, int32(partition.Index)); kafkaErr != nil {
+			response.ErrorCode = kafkaErr.Code
+			response.ErrorMessage = ktypes.CompactNullableString(kafkaErr.Error())
+			return response, nil
+		}
+	}
+
+	log, err := getPartitionLog(topicName, int32(partition.Index))
+	if err != nil {
+		fmt.Println("Error opening partition log: ", err.Error())
+		response.ErrorCode = errorCodeFromError(err)
+		return response, nil
+	}
+
+	baseOffset, err := log.AppendBatches(partition.Records)
+	err = checkStorageError(log, err)
+	if err == nil && acks == PRODUCE_ACKS_ALL {
+		// The records are acknowledged once on disk and on every in-sync
+		// replica
+		err = checkStorageError(log, log.Flush())
+		if err == nil {
+			return response, &ReplicationWait{TopicName: topicName, Log: log, BaseOffset: baseOffset, EndOffset: log.LogEndOffset()}
+		}
+	}
+	setProduceResult(&response, log, baseOffset, err)
+	return response, nil
+}
+
+// setProduceResult fills a partition response in once its records are
+// written, or failed to be.
+func setProduceResult(response *ProduceResponsePartition, log *PartitionLog, baseOffset int64, err error) {
+	response.ErrorCode = errorCodeFromError(err)
+	response.LogStartOffset = ktypes.Int64(log.LogStartOffset())
+	switch response.ErrorCode {
+	case ERROR_CODE_NONE:
+		response.BaseOffset = ktypes.Int64(baseOffset)
+	case ERROR_CODE_DUPLICATE_SEQUENCE_NUMBER:
+		// Retries carry the offset of the original write
+		response.BaseOffset = ktypes.Int64(baseOffset)
+		response.ErrorMessage = ktypes.CompactNullableString(err.Error())
+	default:
+		response.ErrorMessage = ktypes.CompactNullableString(err.Error())
+	}
+}
+
+// completeReplication completes the partition response of an acks=all
+// write once the in-sync replicas have its records, reporting whether it did.
+func (w *ReplicationWait) completeReplication() bool {
+	replicated, err := checkReplicated(w.TopicName, int32(w.Response.Index), w.Log, w.EndOffset)
+	if !replicated {
+		return false
+	}
+	setProduceResult(w.Response, w.Log, w.BaseOffset, err)
+	return true
+}
+
+func handleProduceRequest(req *Request) *Response {
+	requestBody, err := parseProduceRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	transactionalIdAuthorized := requestBody.TransactionalId == "" ||
+		authorize(req, ACL_OPERATION_WRITE, RESOURCE_TYPE_TRANSACTIONAL_ID, string(requestBody.TransactionalId))
+
+	responseTopics := make([]ProduceResponseTopic, len(requestBody.TopicData))
+	var waits []*ReplicationWait
+	for i, topic := range requestBody.TopicData {
+		errorCode := ERROR_CODE_NONE
+		if !transactionalIdAuthorized {
+			errorCode = ERROR_CODE_TRANSACTIONAL_ID_AUTHORIZATION_FAILED
+		} else if !authorize(req, ACL_OPERATION_WRITE, RESOURCE_TYPE_TOPIC, string(topic.Name)) {
+			errorCode = ERROR_CODE_TOPIC_AUTHORIZATION_FAILED
+		}
+
+		partitions := make([]ProduceResponsePartition, len(topic.PartitionData))
+		for j, partition := range topic.PartitionData {
+			if errorCode != ERROR_CODE_NONE {
+				partitions[j] = ProduceResponsePartition{
+					Index:           partition.Index,
+					ErrorCode:       errorCode,
+					BaseOffset:      ktypes.Int64(-1),
+					LogAppendTimeMs: ktypes.Int64(-1),
+					LogStartOffset:  ktypes.Int64(-1),
+					RecordErrors:    []ProduceResponseRecordError{},
+				}
+				continue
+			}
+			var wait *ReplicationWait
+			partitions[j], wait = producePartition(string(topic.Name), partition, int16(requestBody.Acks))
+			if wait != nil {
+				wait.Response = &partitions[j]
+				waits = append(waits, wait)
+			}
+		}
+		responseTopics[i] = ProduceResponseTopic{
+			Name:               topic.Name,
+			PartitionResponses: partitions,
+		}
+	}
+
+	producedBytes := 0
+	for _, topic := range requestBody.TopicData {
+		for _, partition := range topic.PartitionData {
+			producedBytes += len(partition.Records)
+		}
+	}
+	req.ThrottleTimeMs = max(req.ThrottleTimeMs, recordQuotaUsage(req, QUOTA_PRODUCER_BYTE_RATE, float64(producedBytes)))
+
+	if requestBody.Acks == 0 {
+		return noResponse
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+	respond := func() *Response {
+		responseBody := ProduceResponseBody{
+			Responses:      responseTopics,
+			ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		}
+		res.Body = generateBytesFromProduceResponseBody(&responseBody)
+		return &res
+	}
+	// acks=all requests are parked in the purgatory until the in-sync
+	// replicas have the records, rather than hold a handler the follower
+	// fetches replicating them need
+	tryComplete := func() *Response {
+		waits = slices.DeleteFunc(waits, (*ReplicationWait).completeReplication)
+		if len(waits) > 0 {
+			return nil
+		}
+		return respond()
+	}
+	if res := tryComplete(); res != nil {
+		return res
+	}
+	timeoutMs := int32(requestBody.TimeoutMs)
+	return delayResponse(req, time.Duration(timeoutMs)*time.Millisecond, tryComplete, func() *Response {
+		for _, wait := range waits {
+			if !wait.completeReplication() {
+				err := newKafkaError(ERROR_CODE_REQUEST_TIMED_OUT, "records of %s-%d were not replicated within %dms", wait.TopicName, wait.Response.Index, timeoutMs)
+				setProduceResult(wait.Response, wait.Log, wait.BaseOffset, err)
+			}
+		}
+		return respond()
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type SaslAuthenticateRequestBody struct {
+	AuthBytes    ktypes.CompactBytes `order:"1"`
+	TaggedFields ktypes.TaggedFields `order:"2"`
+}
+
+type SaslAuthenticateResponseBody struct {
+	ErrorCode         ERROR_CODE                   `order:"1"`
+	ErrorMessage      ktypes.CompactNullableString `order:"2"`
+	AuthBytes         ktypes.CompactBytes          `order:"3"`
+	SessionLifetimeMs ktypes.Int64                 `order:"4"`
+	TaggedFields      ktypes.TaggedFields          `order:"5"`
+}
+
+func parseSaslAuthenticateRequestBody(body []byte) (*SaslAuthenticateRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody SaslAuthenticateRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode sasl authenticate request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromSaslAuthenticateResponseBody(body *SaslAuthenticateResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode sasl authenticate response: %v", err))
+	}
+	return encoded
+}
+
+func handleSaslAuthenticateRequest(req *Request) *Response {
+	requestBody, err := parseSaslAuthenticateRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	responseBody := SaslAuthenticateResponseBody{
+		ErrorCode:         ERROR_CODE_NONE,
+		AuthBytes:         ktypes.CompactBytes{},
+		SessionLifetimeMs: ktypes.Int64(0),
+	}
+
+	challenge, err := req.Session.saslAuthenticate(requestBody.AuthBytes)
+	if err != nil {
+		responseBody.ErrorCode = errorCodeFromError(err)
+		responseBody.ErrorMessage = ktypes.CompactNullableString(err.Error())
+	} else if challenge != nil {
+		responseBody.AuthBytes = challenge
+	}
+
+	res.Body = generateBytesFromSaslAuthenticateResponseBody(&responseBody)
+	return &res
+}
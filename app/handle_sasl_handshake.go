@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type SaslHandshakeRequestBody struct {
+	Mechanism ktypes.String `order:"1"`
+}
+
+type SaslHandshakeResponseBody struct {
+	ErrorCode  ERROR_CODE                  `order:"1"`
+	Mechanisms ktypes.Array[ktypes.String] `order:"2"`
+}
+
+func parseSaslHandshakeRequestBody(body []byte) (*SaslHandshakeRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody SaslHandshakeRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode sasl handshake request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromSaslHandshakeResponseBody(body *SaslHandshakeResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode sasl handshake response: %v", err))
+	}
+	return encoded
+}
+
+func handleSaslHandshakeRequest(req *Request) *Response {
+	requestBody, err := parseSaslHandshakeRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 0,
+	}
+
+	mechanisms := make([]ktypes.String, 0, len(brokerConfig.SaslEnabledMechanisms))
+	for _, mechanism := range brokerConfig.SaslEnabledMechanisms {
+		mechanisms = append(mechanisms, ktypes.String(mechanism))
+	}
+
+	responseBody := SaslHandshakeResponseBody{
+		ErrorCode:  req.Session.saslHandshake(string(requestBody.Mechanism)),
+		Mechanisms: mechanisms,
+	}
+
+	res.Body = generateBytesFromSaslHandshakeResponseBody(&responseBody)
+	return &res
+}
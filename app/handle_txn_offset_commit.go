@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+// Topics and partitions have the same layout as in OffsetCommit
+type TxnOffsetCommitRequestBody struct {
+	TransactionalId ktypes.CompactString                          `order:"1"`
+	GroupId         ktypes.CompactString                          `order:"2"`
+	ProducerId      ktypes.Int64                                  `order:"3"`
+	ProducerEpoch   ktypes.Int16                                  `order:"4"`
+	GenerationId    ktypes.Int32                                  `order:"5"`
+	MemberId        ktypes.CompactString                          `order:"6"`
+	GroupInstanceId ktypes.CompactNullableString                  `order:"7"`
+	Topics          ktypes.CompactArray[OffsetCommitRequestTopic] `order:"8"`
+	TaggedFields    ktypes.TaggedFields                           `order:"9"`
+}
+
+type TxnOffsetCommitResponseBody struct {
+	ThrottleTimeMs ktypes.Int32                                   `order:"1"`
+	Topics         ktypes.CompactArray[OffsetCommitResponseTopic] `order:"2"`
+	TaggedFields   ktypes.TaggedFields                            `order:"3"`
+}
+
+func parseTxnOffsetCommitRequestBody(body []byte) (*TxnOffsetCommitRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody TxnOffsetCommitRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode txn offset commit request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromTxnOffsetCommitResponseBody(body *TxnOffsetCommitResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode txn offset commit response: %v", err))
+	}
+	return encoded
+}
+
+func handleTxnOffsetCommitRequest(req *Request) *Response {
+	requestBody, err := parseTxnOffsetCommitRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	groupId := string(requestBody.GroupId)
+	producerId := int64(requestBody.ProducerId)
+	producerEpoch := int16(requestBody.ProducerEpoch)
+	var errorCode ERROR_CODE = ERROR_CODE_NONE
+	if groupId == "" {
+		errorCode = ERROR_CODE_INVALID_GROUP_ID
+	} else if !authorize(req, ACL_OPERATION_WRITE, RESOURCE_TYPE_TRANSACTIONAL_ID, string(requestBody.TransactionalId)) {
+		errorCode = ERROR_CODE_TRANSACTIONAL_ID_AUTHORIZATION_FAILED
+	} else if !authorize(req, ACL_OPERATION_READ, RESOURCE_TYPE_GROUP, groupId) {
+		errorCode = ERROR_CODE_GROUP_AUTHORIZATION_FAILED
+	} else {
+		errorCode = validateTxnOffsetCommit(string(requestBody.TransactionalId), producerId, producerEpoch, groupId)
+	}
+	if errorCode == ERROR_CODE_NONE {
+		errorCode = validateConsumerGroupOffsetCommit(groupId, string(requestBody.MemberId), int32(requestBody.GenerationId))
+	}
+
+	var responseTopics []OffsetCommitResponseTopic
+	if errorCode != ERROR_CODE_NONE {
+		responseTopics = offsetCommitResponseTopics(requestBody.Topics, errorCode)
+	} else {
+		commitTimestamp := time.Now().UnixMilli()
+		offsets := make([]TopicPartitionOffset, 0)
+		responseTopics = make([]OffsetCommitResponseTopic, len(requestBody.Topics))
+		for i, topic := range requestBody.Topics {
+			authorized := authorize(req, ACL_OPERATION_READ, RESOURCE_TYPE_TOPIC, string(topic.Name))
+			partitions := make([]OffsetCommitResponsePartition, len(topic.Partitions))
+			for j, partition := range topic.Partitions {
+				errorCode := ERROR_CODE_TOPIC_AUTHORIZATION_FAILED
+				if authorized {
+					errorCode = validateOffsetCommitPartition(string(topic.Name), partition)
+				}
+				if errorCode == ERROR_CODE_NONE {
+					offsets = append(offsets, TopicPartitionOffset{
+						Topic:     string(topic.Name),
+						Partition: int32(partition.PartitionIndex),
+						CommittedOffset: CommittedOffset{
+							Offset:          int64(partition.CommittedOffset),
+							LeaderEpoch:     int32(partition.CommittedLeaderEpoch),
+							Metadata:        string(partition.CommittedMetadata),
+							CommitTimestamp: commitTimestamp,
+						},
+					})
+				}
+				partitions[j] = OffsetCommitResponsePartition{
+					PartitionIndex: partition.PartitionIndex,
+					ErrorCode:      errorCode,
+				}
+			}
+			responseTopics[i] = OffsetCommitResponseTopic{
+				Name:       topic.Name,
+				Partitions: partitions,
+			}
+		}
+
+		if err := commitTxnOffsets(groupId, producerId, producerEpoch, offsets); err != nil {
+			fmt.Println("Error committing transactional offsets: ", err.Error())
+			responseTopics = offsetCommitResponseTopics(requestBody.Topics, errorCodeFromError(err))
+		}
+	}
+
+	responseBody := TxnOffsetCommitResponseBody{
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		Topics:         responseTopics,
+	}
+
+	res.Body = generateBytesFromTxnOffsetCommitResponseBody(&responseBody)
+	return &res
+}
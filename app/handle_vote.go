@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+type VoteRequestPartition struct {
+	PartitionIndex  ktypes.Int32        `order:"1"`
+	CandidateEpoch  ktypes.Int32        `order:"2"`
+	CandidateId     ktypes.Int32        `order:"3"`
+	LastOffsetEpoch ktypes.Int32        `order:"4"`
+	LastOffset      ktypes.Int64        `order:"5"`
+	TaggedFields    ktypes.TaggedFields `order:"6"`
+}
+
+type VoteRequestTopic struct {
+	TopicName    ktypes.CompactString                      `order:"1"`
+	Partitions   ktypes.CompactArray[VoteRequestPartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                       `order:"3"`
+}
+
+type VoteRequestBody struct {
+	ClusterId    ktypes.CompactNullableString          `order:"1"`
+	Topics       ktypes.CompactArray[VoteRequestTopic] `order:"2"`
+	TaggedFields ktypes.TaggedFields                   `order:"3"`
+}
+
+type VoteResponsePartition struct {
+	PartitionIndex ktypes.Int32        `order:"1"`
+	ErrorCode      ERROR_CODE          `order:"2"`
+	LeaderId       ktypes.Int32        `order:"3"`
+	LeaderEpoch    ktypes.Int32        `order:"4"`
+	VoteGranted    ktypes.Bool         `order:"5"`
+	TaggedFields   ktypes.TaggedFields `order:"6"`
+}
+
+type VoteResponseTopic struct {
+	TopicName    ktypes.CompactString                       `order:"1"`
+	Partitions   ktypes.CompactArray[VoteResponsePartition] `order:"2"`
+	TaggedFields ktypes.TaggedFields                        `order:"3"`
+}
+
+type VoteResponseBody struct {
+	ErrorCode    ERROR_CODE                             `order:"1"`
+	Topics       ktypes.CompactArray[VoteResponseTopic] `order:"2"`
+	TaggedFields ktypes.TaggedFields                    `order:"3"`
+}
+
+func parseVoteRequestBody(body []byte) (*VoteRequestBody, error) {
+	decoder := ktypes.NewKDecoder(body)
+	var requestBody VoteRequestBody
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode vote request: %v", err)
+	}
+	return &requestBody, nil
+}
+
+func generateBytesFromVoteResponseBody(body *VoteResponseBody) []byte {
+	encoder := ktypes.NewKEncoder()
+	encoded, err := encoder.Encode(body)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode vote response: %v", err))
+	}
+	return encoded
+}
+
+func handleVoteRequest(req *Request) *Response {
+	requestBody, err := parseVoteRequestBody(req.Body)
+	if err != nil {
+		return nil
+	}
+
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+
+	responseBody := VoteResponseBody{
+		ErrorCode: validateQuorumRequest(req, requestBody.ClusterId),
+		Topics:    []VoteResponseTopic{},
+	}
+	if responseBody.ErrorCode == ERROR_CODE_NONE {
+		for _, topic := range requestBody.Topics {
+			partitions := make([]VoteResponsePartition, 0, len(topic.Partitions))
+			for _, partition := range topic.Partitions {
+				errorCode, granted := ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION, false
+				if string(topic.TopicName) == METADATA_TOPIC && partition.PartitionIndex == 0 {
+					errorCode, granted = raftClient.handleVote(int32(partition.CandidateId), int32(partition.CandidateEpoch), int32(partition.LastOffsetEpoch), int64(partition.LastOffset))
+				}
+				leaderId, epoch := raftClient.currentLeader()
+				partitions = append(partitions, VoteResponsePartition{
+					PartitionIndex: partition.PartitionIndex,
+					ErrorCode:      errorCode,
+					LeaderId:       ktypes.Int32(leaderId),
+					LeaderEpoch:    ktypes.Int32(epoch),
+					VoteGranted:    ktypes.Bool(granted),
+				})
+			}
+			responseBody.Topics = append(responseBody.Topics, VoteResponseTopic{
+				TopicName:  topic.TopicName,
+				Partitions: partitions,
+			})
+		}
+	}
+
+	res.Body = generateBytesFromVoteResponseBody(&responseBody)
+	return &res
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// KafkaError is an error that is reported to clients with a protocol error code
+type KafkaError struct {
+	Code    ERROR_CODE
+	Message string
+}
+
+func (e *KafkaError) Error() string {
+	return e.Message
+}
+
+func newKafkaError(code ERROR_CODE, format string, args ...any) *KafkaError {
+	return &KafkaError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// errorCodeFromError maps an error to the code sent to clients, unexpected
+// errors being reported as UNKNOWN_SERVER_ERROR.
+func errorCodeFromError(err error) ERROR_CODE {
+	if err == nil {
+		return ERROR_CODE_NONE
+	}
+	var kafkaError *KafkaError
+	if errors.As(err, &kafkaError) {
+		return kafkaError.Code
+	}
+	return ERROR_CODE_UNKNOWN_SERVER_ERROR
+}
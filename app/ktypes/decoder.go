@@ -98,9 +98,18 @@ func (d *KDecoder) decodeKType(fv reflect.Value, field reflect.StructField) erro
 
 	// Check if it's a ktype by looking at the package
 	if actualType.PkgPath() != "github.com/codecrafters-io/kafka-starter-go/app/ktypes" {
+		// Nested structs made of ktypes are decoded field by field
+		if actualType.Kind() == reflect.Struct {
+			return d.decodeStruct(fv)
+		}
 		return fmt.Errorf("field %s is not a ktype", field.Name)
 	}
 
+	// Check if it's a generic NullableStruct type
+	if actualType.Kind() == reflect.Struct && strings.HasPrefix(actualType.Name(), "NullableStruct[") {
+		return d.decodeNullableStruct(fv)
+	}
+
 	// Check if it's a generic Array or CompactArray type
 	if actualType.Kind() == reflect.Slice {
 		// This is a slice type, check if it's Array[T] or CompactArray[T]
@@ -109,6 +118,9 @@ func (d *KDecoder) decodeKType(fv reflect.Value, field reflect.StructField) erro
 			isCompact := strings.HasPrefix(typeName, "CompactArray[")
 			return d.decodeGenericArray(fv, isCompact)
 		}
+		if strings.HasPrefix(typeName, "VarIntArray[") {
+			return d.decodeVarIntArray(fv)
+		}
 	}
 
 	switch actualType.Name() {
@@ -293,6 +305,22 @@ func (d *KDecoder) decodeKType(fv reflect.Value, field reflect.StructField) erro
 		fv.SetBytes(val)
 		return nil
 
+	case "VarIntBytes":
+		val, err := d.readVarIntBytes()
+		if err != nil {
+			return err
+		}
+		fv.SetBytes(val)
+		return nil
+
+	case "VarIntString":
+		val, err := d.readVarIntBytes()
+		if err != nil {
+			return err
+		}
+		fv.SetString(string(val))
+		return nil
+
 	// Records types
 	case "Records":
 		val, err := d.readRecords()
@@ -310,6 +338,18 @@ func (d *KDecoder) decodeKType(fv reflect.Value, field reflect.StructField) erro
 		fv.SetBytes(val)
 		return nil
 
+	// Tagged fields
+	case "TaggedFields":
+		return d.skipTaggedFields()
+
+	case "TaggedFieldValues":
+		val, err := d.readTaggedFieldValues()
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(val))
+		return nil
+
 	// Array types (non-generic)
 	case "Array":
 		return d.decodeGenericArray(fv, false) // false = regular array
@@ -359,6 +399,27 @@ func (d *KDecoder) HasMoreData() bool {
 	return d.pos < len(d.data)
 }
 
+// decodeNullableStruct decodes a NullableStruct[T], leaving Value nil when the struct is null
+func (d *KDecoder) decodeNullableStruct(fv reflect.Value) error {
+	marker, err := d.readInt8()
+	if err != nil {
+		return err
+	}
+
+	value := fv.FieldByName("Value")
+	if marker < 0 {
+		value.Set(reflect.Zero(value.Type()))
+		return nil
+	}
+
+	elem := reflect.New(value.Type().Elem())
+	if err := d.decodeStruct(elem.Elem()); err != nil {
+		return err
+	}
+	value.Set(elem)
+	return nil
+}
+
 // decodeGenericArray decodes a generic array (Array[T] or CompactArray[T])
 func (d *KDecoder) decodeGenericArray(fv reflect.Value, isCompact bool) error {
 	// Get the element type from the generic type
@@ -417,6 +478,30 @@ func (d *KDecoder) decodeGenericArray(fv reflect.Value, isCompact bool) error {
 	return nil
 }
 
+// decodeVarIntArray decodes a VarIntArray[T], the count being a signed varint
+func (d *KDecoder) decodeVarIntArray(fv reflect.Value) error {
+	length, err := d.readVarInt()
+	if err != nil {
+		return fmt.Errorf("failed to read array length: %v", err)
+	}
+	if length < 0 {
+		// Null array
+		fv.Set(reflect.Zero(fv.Type()))
+		return nil
+	}
+
+	elemType := fv.Type().Elem()
+	slice := reflect.MakeSlice(fv.Type(), int(length), int(length))
+	for i := 0; i < int(length); i++ {
+		if err := d.decodeArrayElement(slice.Index(i), elemType); err != nil {
+			return fmt.Errorf("failed to decode array element %d: %v", i, err)
+		}
+	}
+
+	fv.Set(slice)
+	return nil
+}
+
 // decodeArrayElement decodes a single element in an array
 func (d *KDecoder) decodeArrayElement(elem reflect.Value, elemType reflect.Type) error {
 	// Check if it's a ktype
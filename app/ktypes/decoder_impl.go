@@ -343,6 +343,30 @@ func (d *KDecoder) readCompactNullableBytes() ([]byte, error) {
 	return val, nil
 }
 
+func (d *KDecoder) readVarIntBytes() ([]byte, error) {
+	length, err := d.readVarInt()
+	if err != nil {
+		return nil, err
+	}
+
+	if length == -1 {
+		return nil, nil
+	}
+
+	if length < 0 {
+		return nil, errors.New("invalid bytes length")
+	}
+
+	if d.pos+int(length) > len(d.data) {
+		return nil, errors.New("out of bounds: cannot read varint bytes")
+	}
+
+	val := make([]byte, length)
+	copy(val, d.data[d.pos:d.pos+int(length)])
+	d.pos += int(length)
+	return val, nil
+}
+
 func (d *KDecoder) readRecords() ([]byte, error) {
 	return d.readNullableBytes()
 }
@@ -362,3 +386,51 @@ func (d *KDecoder) readUUID() (UUID, error) {
 	return uuid, nil
 }
 
+
+func (d *KDecoder) skipTaggedFields() error {
+	count, err := d.readUnsignedVarInt()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < int(count); i++ {
+		if _, err := d.readUnsignedVarInt(); err != nil {
+			return err
+		}
+		size, err := d.readUnsignedVarInt()
+		if err != nil {
+			return err
+		}
+		if err := d.SkipBytes(int(size)); err != nil {
+			return errors.New("out of bounds: cannot skip tagged field")
+		}
+	}
+
+	return nil
+}
+
+func (d *KDecoder) readTaggedFieldValues() (TaggedFieldValues, error) {
+	count, err := d.readUnsignedVarInt()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(TaggedFieldValues, count)
+	for i := 0; i < int(count); i++ {
+		tag, err := d.readUnsignedVarInt()
+		if err != nil {
+			return nil, err
+		}
+		size, err := d.readUnsignedVarInt()
+		if err != nil {
+			return nil, err
+		}
+		if d.pos+int(size) > len(d.data) {
+			return nil, errors.New("out of bounds: cannot read tagged field")
+		}
+		fields[tag] = append([]byte(nil), d.data[d.pos:d.pos+int(size)]...)
+		d.pos += int(size)
+	}
+
+	return fields, nil
+}
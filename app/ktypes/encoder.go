@@ -103,9 +103,18 @@ func (e *KEncoder) encodeKType(fv reflect.Value, field reflect.StructField) erro
 
 	// Check if it's a ktype by looking at the package
 	if actualType.PkgPath() != "github.com/codecrafters-io/kafka-starter-go/app/ktypes" {
+		// Nested structs made of ktypes are encoded field by field
+		if actualType.Kind() == reflect.Struct {
+			return e.encodeStruct(fv)
+		}
 		return fmt.Errorf("field %s is not a ktype (pkg: %s, type: %s)", field.Name, actualType.PkgPath(), actualType.Name())
 	}
 
+	// Check if it's a generic NullableStruct type
+	if actualType.Kind() == reflect.Struct && strings.HasPrefix(actualType.Name(), "NullableStruct[") {
+		return e.encodeNullableStruct(fv)
+	}
+
 	// Check if it's a generic Array or CompactArray type
 	if actualType.Kind() == reflect.Slice {
 		// This is a slice type, check if it's Array[T] or CompactArray[T]
@@ -114,6 +123,9 @@ func (e *KEncoder) encodeKType(fv reflect.Value, field reflect.StructField) erro
 			isCompact := strings.HasPrefix(typeName, "CompactArray[")
 			return e.encodeGenericArray(fv, isCompact)
 		}
+		if strings.HasPrefix(typeName, "VarIntArray[") {
+			return e.encodeVarIntArray(fv)
+		}
 	}
 
 	switch actualType.Name() {
@@ -235,6 +247,16 @@ func (e *KEncoder) encodeKType(fv reflect.Value, field reflect.StructField) erro
 		e.writeCompactNullableBytes(val)
 		return nil
 
+	case "VarIntBytes":
+		val := fv.Bytes()
+		e.writeVarIntBytes(val)
+		return nil
+
+	case "VarIntString":
+		val := fv.String()
+		e.writeVarIntBytes([]byte(val))
+		return nil
+
 	// Records types
 	case "Records":
 		val := fv.Bytes()
@@ -246,6 +268,15 @@ func (e *KEncoder) encodeKType(fv reflect.Value, field reflect.StructField) erro
 		e.writeCompactRecords(val)
 		return nil
 
+	// Tagged fields
+	case "TaggedFields":
+		e.writeTaggedFields()
+		return nil
+
+	case "TaggedFieldValues":
+		e.writeTaggedFieldValues(fv.Interface().(TaggedFieldValues))
+		return nil
+
 	// Array types
 	case "Array":
 		return e.encodeGenericArray(fv, false) // false = regular array
@@ -272,6 +303,18 @@ func (e *KEncoder) Reset() {
 	e.buf = make([]byte, 0)
 }
 
+// encodeNullableStruct encodes a NullableStruct[T] as -1 when null, or 1 followed by the struct
+func (e *KEncoder) encodeNullableStruct(fv reflect.Value) error {
+	value := fv.FieldByName("Value")
+	if value.IsNil() {
+		e.writeInt8(-1)
+		return nil
+	}
+
+	e.writeInt8(1)
+	return e.encodeStruct(value.Elem())
+}
+
 // encodeGenericArray encodes a generic array (Array[T] or CompactArray[T])
 func (e *KEncoder) encodeGenericArray(fv reflect.Value, isCompact bool) error {
 	// Check if the slice is nil
@@ -307,6 +350,20 @@ func (e *KEncoder) encodeGenericArray(fv reflect.Value, isCompact bool) error {
 	return nil
 }
 
+// encodeVarIntArray encodes a VarIntArray[T], the count being a signed varint
+func (e *KEncoder) encodeVarIntArray(fv reflect.Value) error {
+	length := fv.Len()
+	e.writeVarInt(int32(length))
+
+	for i := 0; i < length; i++ {
+		if err := e.encodeArrayElement(fv.Index(i)); err != nil {
+			return fmt.Errorf("failed to encode array element %d: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
 // encodeArrayElement encodes a single element in an array
 func (e *KEncoder) encodeArrayElement(elem reflect.Value) error {
 	elemType := elem.Type()
@@ -2,6 +2,7 @@ package ktypes
 
 import (
 	"encoding/binary"
+	"slices"
 )
 
 // Basic integer writing methods
@@ -154,6 +155,15 @@ func (e *KEncoder) writeCompactNullableBytes(val []byte) {
 	}
 }
 
+func (e *KEncoder) writeVarIntBytes(val []byte) {
+	if val == nil {
+		e.writeVarInt(-1)
+		return
+	}
+	e.writeVarInt(int32(len(val)))
+	e.buf = append(e.buf, val...)
+}
+
 func (e *KEncoder) writeRecords(val []byte) {
 	e.writeNullableBytes(val)
 }
@@ -166,3 +176,23 @@ func (e *KEncoder) writeUUID(val UUID) {
 	e.buf = append(e.buf, val[:]...)
 }
 
+
+func (e *KEncoder) writeTaggedFields() {
+	// No tagged fields are ever written, only the zero count
+	e.writeUnsignedVarInt(0)
+}
+
+func (e *KEncoder) writeTaggedFieldValues(fields TaggedFieldValues) {
+	tags := make([]uint32, 0, len(fields))
+	for tag := range fields {
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+
+	e.writeUnsignedVarInt(uint32(len(tags)))
+	for _, tag := range tags {
+		e.writeUnsignedVarInt(tag)
+		e.writeUnsignedVarInt(uint32(len(fields[tag])))
+		e.buf = append(e.buf, fields[tag]...)
+	}
+}
@@ -42,6 +42,10 @@ type NullableBytes []byte
 type CompactBytes []byte
 type CompactNullableBytes []byte
 
+// Varint length prefixed types used inside records, a -1 length is null
+type VarIntBytes []byte
+type VarIntString string
+
 // Records types
 type Records []byte
 type CompactRecords []byte
@@ -49,3 +53,18 @@ type CompactRecords []byte
 // Array types
 type Array[T any] []T
 type CompactArray[T any] []T
+type VarIntArray[T any] []T
+
+// NullableStruct is a struct that may be null on the wire, in which case Value is nil
+type NullableStruct[T any] struct {
+	Value *T
+}
+
+// TaggedFields is the tagged field buffer that terminates every flexible
+// version struct. Unknown tags are skipped on decode and nothing is written
+// on encode.
+type TaggedFields struct{}
+
+// TaggedFieldValues is a tagged field buffer whose fields are kept, encoded,
+// by tag. Fields are written in increasing tag order.
+type TaggedFieldValues map[uint32][]byte
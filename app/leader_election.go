@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	// Moves the leadership back to the first replica
+	ELECTION_TYPE_PREFERRED = 0
+	// Elects a leader for a partition with none, out of the ISR if need be
+	ELECTION_TYPE_UNCLEAN = 1
+)
+
+// electPreferredLeader hands the leadership of a partition to its first
+// replica, which must be an unfenced in-sync replica. Callers hold
+// metadataWriteMu and metadataMu.
+func electPreferredLeader(topicId ktypes.UUID, partition *PartitionRecordValue) error {
+	if len(partition.Replicas) == 0 {
+		return newKafkaError(ERROR_CODE_PREFERRED_LEADER_NOT_AVAILABLE, "%s-%d has no replicas", topicIdToTopicName[topicId], partition.PartitionId)
+	}
+	preferredId := int32(partition.Replicas[0])
+	if int32(partition.Leader) == preferredId {
+		return newKafkaError(ERROR_CODE_ELECTION_NOT_NEEDED, "broker %d already leads %s-%d", preferredId, topicIdToTopicName[topicId], partition.PartitionId)
+	}
+	isr := toInt32Slice(partition.InSyncReplicas)
+	if !slices.Contains(isr, preferredId) || !isUnfencedBroker(preferredId) {
+		return newKafkaError(ERROR_CODE_PREFERRED_LEADER_NOT_AVAILABLE, "preferred leader %d of %s-%d is not an unfenced in-sync replica", preferredId, topicIdToTopicName[topicId], partition.PartitionId)
+	}
+	return changePartition(topicId, int32(partition.PartitionId), preferredId, isr)
+}
+
+// electUncleanLeader elects a leader for a partition with none: an in-sync
+// replica, else an eligible leader replica, else any unfenced replica,
+// which may lose the records it did not have. Callers hold metadataWriteMu
+// and metadataMu.
+func electUncleanLeader(topicId ktypes.UUID, partition *PartitionRecordValue) error {
+	if partition.Leader != NO_LEADER {
+		return newKafkaError(ERROR_CODE_ELECTION_NOT_NEEDED, "%s-%d has leader %d", topicIdToTopicName[topicId], partition.PartitionId, partition.Leader)
+	}
+	replicas := toInt32Slice(partition.Replicas)
+	isr := toInt32Slice(partition.InSyncReplicas)
+	if leaderId := electLeader(replicas, isr); leaderId != NO_LEADER {
+		return changePartition(topicId, int32(partition.PartitionId), leaderId, isr)
+	}
+	if leaderId := electLeader(replicas, partition.eligibleLeaderReplicas); leaderId != NO_LEADER {
+		return changePartition(topicId, int32(partition.PartitionId), leaderId, []int32{leaderId})
+	}
+	for _, replicaId := range replicas {
+		if isUnfencedBroker(replicaId) {
+			fmt.Println("Electing out of sync broker ", replicaId, " to lead ", topicIdToTopicName[topicId], "-", partition.PartitionId)
+			return changePartition(topicId, int32(partition.PartitionId), replicaId, []int32{replicaId})
+		}
+	}
+	return newKafkaError(ERROR_CODE_ELIGIBLE_LEADERS_NOT_AVAILABLE, "no replica of %s-%d is on an unfenced broker", topicIdToTopicName[topicId], partition.PartitionId)
+}
+
+// electPartitionLeader runs an election of the given type for a partition.
+// Callers hold metadataWriteMu and metadataMu.
+func electPartitionLeader(topicName string, partitionId int32, electionType int8) error {
+	topicId, ok := topicNameToTopicId[topicName]
+	if !ok {
+		return newKafkaError(ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION, "unknown topic %s", topicName)
+	}
+	partition, ok := partitionRecordFor(topicId, partitionId)
+	if !ok {
+		return newKafkaError(ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION, "unknown partition %d of topic %s", partitionId, topicName)
+	}
+	switch electionType {
+	case ELECTION_TYPE_PREFERRED:
+		return electPreferredLeader(topicId, partition)
+	case ELECTION_TYPE_UNCLEAN:
+		return electUncleanLeader(topicId, partition)
+	}
+	return newKafkaError(ERROR_CODE_INVALID_REQUEST, "unknown election type %d", electionType)
+}
+
+// imbalancedPartitions returns the partitions not led by their preferred
+// leader, of the unfenced brokers leading fewer of their preferred
+// partitions than leader.imbalance.per.broker.percentage allows. Callers
+// hold metadataMu.
+func imbalancedPartitions() []TopicPartition {
+	preferred := make(map[int32][]TopicPartition)
+	notLed := make(map[int32][]TopicPartition)
+	for topicId, partitions := range topicIdToPartitions {
+		for _, partition := range partitions {
+			if len(partition.Replicas) == 0 {
+				continue
+			}
+			preferredId := int32(partition.Replicas[0])
+			tp := TopicPartition{topicIdToTopicName[topicId], int32(partition.PartitionId)}
+			preferred[preferredId] = append(preferred[preferredId], tp)
+			if partition.Leader != partition.Replicas[0] {
+				notLed[preferredId] = append(notLed[preferredId], tp)
+			}
+		}
+	}
+
+	imbalanced := make([]TopicPartition, 0)
+	for brokerId, partitions := range notLed {
+		ratio := float64(len(partitions)) / float64(len(preferred[brokerId]))
+		if isUnfencedBroker(brokerId) && ratio*100 > float64(brokerConfig.LeaderImbalancePerBrokerPercentage) {
+			imbalanced = append(imbalanced, partitions...)
+		}
+	}
+	return imbalanced
+}
+
+// rebalanceLeaders moves the leadership of imbalanced partitions back to
+// their preferred leaders, when this node is the active controller.
+func rebalanceLeaders() {
+	if !raftClient.isLeader() {
+		return
+	}
+	metadataWriteMu.Lock()
+	defer metadataWriteMu.Unlock()
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	for _, tp := range imbalancedPartitions() {
+		err := electPartitionLeader(tp.topicName, tp.partition, ELECTION_TYPE_PREFERRED)
+		switch errorCodeFromError(err) {
+		case ERROR_CODE_NONE:
+			fmt.Println("Moved the leadership of ", tp.topicName, "-", tp.partition, " back to its preferred leader")
+		case ERROR_CODE_PREFERRED_LEADER_NOT_AVAILABLE, ERROR_CODE_ELECTION_NOT_NEEDED:
+		default:
+			fmt.Println("Error electing the preferred leader of ", tp.topicName, "-", tp.partition, ": ", err.Error())
+			return
+		}
+	}
+}
+
+// startLeaderRebalanceTask checks the leader imbalance every
+// leader.imbalance.check.interval.seconds, when auto.leader.rebalance.enable
+// is set.
+func startLeaderRebalanceTask() {
+	if !brokerConfig.AutoLeaderRebalanceEnable {
+		return
+	}
+	runPeriodicTask(time.Duration(brokerConfig.LeaderImbalanceCheckIntervalSeconds)*time.Second, func() {
+		rebalanceLeaders()
+	})
+}
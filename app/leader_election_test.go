@@ -0,0 +1,148 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+// setTestPartitionLeadership sets the ISR, leader and eligible leader
+// replicas of the test partition and fences the given brokers, callers hold
+// metadataMu
+func setTestPartitionLeadership(t *testing.T, isr []int32, leader int32, elr []int32, fenced []int32) {
+	t.Helper()
+	partition, ok := partitionRecordFor(reassignTestTopicId, 0)
+	if !ok {
+		t.Fatal("test partition not found")
+	}
+	partition.InSyncReplicas = ktypes.CompactArray[ktypes.Int32]{}
+	for _, id := range isr {
+		partition.InSyncReplicas = append(partition.InSyncReplicas, ktypes.Int32(id))
+	}
+	partition.Leader = ktypes.Int32(leader)
+	partition.eligibleLeaderReplicas = elr
+	for _, id := range fenced {
+		brokerRegistrations[id].Fenced = true
+	}
+}
+
+func TestElectPartitionLeader(t *testing.T) {
+	tests := []struct {
+		name         string
+		electionType int8
+		isr          []int32
+		leader       int32
+		elr          []int32
+		fenced       []int32
+		wantError    ERROR_CODE
+		wantLeader   int32
+		wantIsr      []int32
+	}{
+		{"preferred", ELECTION_TYPE_PREFERRED, []int32{1, 2, 3}, 2, nil, nil, ERROR_CODE_NONE, 1, []int32{1, 2, 3}},
+		{"preferred already leading", ELECTION_TYPE_PREFERRED, []int32{1, 2, 3}, 1, nil, nil, ERROR_CODE_ELECTION_NOT_NEEDED, 1, []int32{1, 2, 3}},
+		{"preferred out of sync", ELECTION_TYPE_PREFERRED, []int32{2, 3}, 2, nil, nil, ERROR_CODE_PREFERRED_LEADER_NOT_AVAILABLE, 2, []int32{2, 3}},
+		{"preferred fenced", ELECTION_TYPE_PREFERRED, []int32{1, 2, 3}, 2, nil, []int32{1}, ERROR_CODE_PREFERRED_LEADER_NOT_AVAILABLE, 2, []int32{1, 2, 3}},
+		{"unclean with a leader", ELECTION_TYPE_UNCLEAN, []int32{1, 2, 3}, 1, nil, nil, ERROR_CODE_ELECTION_NOT_NEEDED, 1, []int32{1, 2, 3}},
+		{"unclean in sync", ELECTION_TYPE_UNCLEAN, []int32{2, 3}, NO_LEADER, nil, []int32{2}, ERROR_CODE_NONE, 3, []int32{2, 3}},
+		{"unclean eligible", ELECTION_TYPE_UNCLEAN, []int32{1}, NO_LEADER, []int32{3}, []int32{1}, ERROR_CODE_NONE, 3, []int32{3}},
+		{"unclean out of sync", ELECTION_TYPE_UNCLEAN, []int32{1}, NO_LEADER, nil, []int32{1}, ERROR_CODE_NONE, 2, []int32{2}},
+		{"unclean all fenced", ELECTION_TYPE_UNCLEAN, []int32{1}, NO_LEADER, nil, []int32{1, 2, 3}, ERROR_CODE_ELIGIBLE_LEADERS_NOT_AVAILABLE, NO_LEADER, []int32{1}},
+		{"unknown type", 5, []int32{1, 2, 3}, 2, nil, nil, ERROR_CODE_INVALID_REQUEST, 2, []int32{1, 2, 3}},
+	}
+	for _, test := range tests {
+		setTestPartition(t)
+		metadataMu.Lock()
+		setTestPartitionLeadership(t, test.isr, test.leader, test.elr, test.fenced)
+		err := electPartitionLeader("reassign-topic", 0, test.electionType)
+		_, _, _, isr, leader := testPartitionReplicas(t)
+		metadataMu.Unlock()
+		if got := errorCodeFromError(err); got != test.wantError {
+			t.Errorf("%s: got error %d (%v), want %d", test.name, got, err, test.wantError)
+		}
+		if leader != test.wantLeader || !slices.Equal(isr, test.wantIsr) {
+			t.Errorf("%s: got leader %d with ISR %v, want %d with %v", test.name, leader, isr, test.wantLeader, test.wantIsr)
+		}
+	}
+}
+
+func TestEligibleLeaderReplicas(t *testing.T) {
+	setTestPartition(t)
+	brokerConfig.MinInsyncReplicas = 2
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	steps := []struct {
+		name    string
+		leader  int32
+		isr     []int32
+		wantElr []int32
+	}{
+		{"ISR at min.insync.replicas", 1, []int32{1, 2}, nil},
+		{"ISR below min.insync.replicas", 1, []int32{1}, []int32{2}},
+		{"leader lost", NO_LEADER, []int32{}, []int32{2, 1}},
+		{"ISR back to min.insync.replicas", 1, []int32{1, 3}, []int32{}},
+	}
+	for _, step := range steps {
+		if err := changePartition(reassignTestTopicId, 0, step.leader, step.isr); err != nil {
+			t.Fatal(err)
+		}
+		partition, _ := partitionRecordFor(reassignTestTopicId, 0)
+		if !slices.Equal(partition.eligibleLeaderReplicas, step.wantElr) {
+			t.Errorf("%s: got eligible leader replicas %v, want %v", step.name, partition.eligibleLeaderReplicas, step.wantElr)
+		}
+	}
+
+	// An eligible leader replica is elected before replicas out of sync
+	if err := changePartition(reassignTestTopicId, 0, NO_LEADER, []int32{}); err != nil {
+		t.Fatal(err)
+	}
+	brokerRegistrations[1].Fenced = true
+	if err := electPartitionLeader("reassign-topic", 0, ELECTION_TYPE_UNCLEAN); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, isr, leader := testPartitionReplicas(t); leader != 3 || !slices.Equal(isr, []int32{3}) {
+		t.Errorf("got leader %d with ISR %v, want the eligible 3", leader, isr)
+	}
+}
+
+func TestRebalanceLeaders(t *testing.T) {
+	setTestPartition(t)
+	metadataMu.Lock()
+	// Broker 1 prefers partitions 0 and 1 but only leads 1, broker 2
+	// prefers partition 2, which it does not lead
+	topicIdToPartitions[reassignTestTopicId] = []PartitionRecordValue{
+		{PartitionId: 0, Replicas: ktypes.CompactArray[ktypes.Int32]{1, 2, 3}, InSyncReplicas: ktypes.CompactArray[ktypes.Int32]{1, 2, 3}, Leader: 2},
+		{PartitionId: 1, Replicas: ktypes.CompactArray[ktypes.Int32]{1, 3, 2}, InSyncReplicas: ktypes.CompactArray[ktypes.Int32]{1, 2, 3}, Leader: 1},
+		{PartitionId: 2, Replicas: ktypes.CompactArray[ktypes.Int32]{2, 3, 1}, InSyncReplicas: ktypes.CompactArray[ktypes.Int32]{1, 3}, Leader: 3},
+	}
+	metadataMu.Unlock()
+
+	tests := []struct {
+		percentage int
+		want       []TopicPartition
+	}{
+		{50, []TopicPartition{{"reassign-topic", 2}}},
+		{10, []TopicPartition{{"reassign-topic", 0}, {"reassign-topic", 2}}},
+	}
+	for _, test := range tests {
+		brokerConfig.LeaderImbalancePerBrokerPercentage = test.percentage
+		metadataMu.Lock()
+		got := imbalancedPartitions()
+		metadataMu.Unlock()
+		slices.SortFunc(got, func(a, b TopicPartition) int { return int(a.partition - b.partition) })
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%d%% imbalance: got %v, want %v", test.percentage, got, test.want)
+		}
+	}
+
+	// Broker 2 is out of sync for partition 2, which keeps its leader
+	rebalanceLeaders()
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	for partitionId, want := range []int32{1, 1, 3} {
+		if partition, _ := partitionRecordFor(reassignTestTopicId, int32(partitionId)); int32(partition.Leader) != want {
+			t.Errorf("partition %d: got leader %d after rebalancing, want %d", partitionId, partition.Leader, want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	LEADER_EPOCH_CHECKPOINT_FILE = "leader-epoch-checkpoint"
+
+	UNDEFINED_EPOCH        = -1
+	UNDEFINED_EPOCH_OFFSET = -1
+)
+
+// EpochEntry is the first offset written by the leader of an epoch.
+type EpochEntry struct {
+	Epoch       int32
+	StartOffset int64
+}
+
+// LeaderEpochCache maps the leader epochs of a partition to the offsets
+// they start at, so replicas can find where their logs diverge after a
+// leader change. It is checkpointed to the partition folder on every change
+// and guarded by the mu of its log.
+type LeaderEpochCache struct {
+	path    string
+	entries []EpochEntry
+}
+
+// loadLeaderEpochCache reads the leader epoch checkpoint of a partition, a
+// version line, an entry count line and one "epoch offset" line per epoch.
+func loadLeaderEpochCache(dir string) (*LeaderEpochCache, error) {
+	cache := &LeaderEpochCache{path: filepath.Join(dir, LEADER_EPOCH_CHECKPOINT_FILE), entries: []EpochEntry{}}
+	data, err := os.ReadFile(cache.path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read checkpoint %s: %w", cache.path, err)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) < 2 || lines[0] != strconv.Itoa(OFFSET_CHECKPOINT_VERSION) {
+		return nil, fmt.Errorf("malformed checkpoint %s", cache.path)
+	}
+	count, err := strconv.Atoi(lines[1])
+	if err != nil || count != len(lines)-2 {
+		return nil, fmt.Errorf("malformed checkpoint %s: unexpected entry count", cache.path)
+	}
+	for _, line := range lines[2:] {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed checkpoint %s: %q", cache.path, line)
+		}
+		epoch, err := strconv.ParseInt(fields[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("malformed checkpoint %s: %q", cache.path, line)
+		}
+		startOffset, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed checkpoint %s: %q", cache.path, line)
+		}
+		cache.entries = append(cache.entries, EpochEntry{int32(epoch), startOffset})
+	}
+	return cache, nil
+}
+
+func (c *LeaderEpochCache) checkpoint() error {
+	return writeCheckpointFile(c.path, formatEpochEntries(c.entries))
+}
+
+// formatEpochEntries encodes epochs in the leader epoch checkpoint format.
+func formatEpochEntries(entries []EpochEntry) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%d\n%d\n", OFFSET_CHECKPOINT_VERSION, len(entries))
+	for _, entry := range entries {
+		fmt.Fprintf(&builder, "%d %d\n", entry.Epoch, entry.StartOffset)
+	}
+	return builder.String()
+}
+
+// epochsBetween returns the epochs of the offsets from startOffset up to
+// endOffset, the first one starting at startOffset.
+func (c *LeaderEpochCache) epochsBetween(startOffset int64, endOffset int64) []EpochEntry {
+	entries := make([]EpochEntry, 0)
+	for i, entry := range c.entries {
+		if entry.StartOffset >= endOffset {
+			break
+		}
+		if i+1 < len(c.entries) && c.entries[i+1].StartOffset <= startOffset {
+			continue
+		}
+		entries = append(entries, EpochEntry{entry.Epoch, max(entry.StartOffset, startOffset)})
+	}
+	return entries
+}
+
+// latestEpoch returns the epoch of the last records written,
+// UNDEFINED_EPOCH when there are none.
+func (c *LeaderEpochCache) latestEpoch() int32 {
+	if len(c.entries) == 0 {
+		return UNDEFINED_EPOCH
+	}
+	return c.entries[len(c.entries)-1].Epoch
+}
+
+// assign records that epoch starts at startOffset. Batches of earlier or
+// current epochs are already covered.
+func (c *LeaderEpochCache) assign(epoch int32, startOffset int64) error {
+	if epoch < 0 || epoch <= c.latestEpoch() {
+		return nil
+	}
+	c.entries = append(c.entries, EpochEntry{epoch, startOffset})
+	return c.checkpoint()
+}
+
+// endOffsetFor returns the largest epoch at or below epoch and the offset
+// it ends at, which is the start of the next epoch or logEndOffset for the
+// latest one. Epochs past the latest are undefined.
+func (c *LeaderEpochCache) endOffsetFor(epoch int32, logEndOffset int64) (int32, int64) {
+	if epoch == UNDEFINED_EPOCH {
+		return UNDEFINED_EPOCH, UNDEFINED_EPOCH_OFFSET
+	}
+	if epoch == c.latestEpoch() {
+		return epoch, logEndOffset
+	}
+	for i, entry := range c.entries {
+		if entry.Epoch <= epoch {
+			continue
+		}
+		// entry is the first epoch after the requested one
+		if i == 0 {
+			return epoch, entry.StartOffset
+		}
+		return c.entries[i-1].Epoch, entry.StartOffset
+	}
+	return UNDEFINED_EPOCH, UNDEFINED_EPOCH_OFFSET
+}
+
+// truncateFromEnd removes the epochs starting at or past endOffset, after
+// the log was truncated there.
+func (c *LeaderEpochCache) truncateFromEnd(endOffset int64) error {
+	kept := len(c.entries)
+	for kept > 0 && c.entries[kept-1].StartOffset >= endOffset {
+		kept--
+	}
+	if kept == len(c.entries) {
+		return nil
+	}
+	c.entries = c.entries[:kept]
+	return c.checkpoint()
+}
+
+// truncateFromStart removes the epochs ending before startOffset, after the
+// log start moved there. The epoch startOffset belongs to now starts at it.
+func (c *LeaderEpochCache) truncateFromStart(startOffset int64) error {
+	first := 0
+	for first+1 < len(c.entries) && c.entries[first+1].StartOffset <= startOffset {
+		first++
+	}
+	if first == 0 && (len(c.entries) == 0 || c.entries[0].StartOffset >= startOffset) {
+		return nil
+	}
+	c.entries = c.entries[first:]
+	c.entries[0].StartOffset = max(c.entries[0].StartOffset, startOffset)
+	return c.checkpoint()
+}
+
+// clear removes every epoch, after the log was emptied.
+func (c *LeaderEpochCache) clear() error {
+	if len(c.entries) == 0 {
+		return nil
+	}
+	c.entries = []EpochEntry{}
+	return c.checkpoint()
+}
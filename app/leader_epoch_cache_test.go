@@ -0,0 +1,110 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// newTestEpochCache returns a cache in a temporary folder with epochs 1, 3
+// and 4 starting at offsets 0, 10 and 20
+func newTestEpochCache(t *testing.T) *LeaderEpochCache {
+	t.Helper()
+	cache, err := loadLeaderEpochCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []EpochEntry{{1, 0}, {3, 10}, {4, 20}} {
+		if err := cache.assign(entry.Epoch, entry.StartOffset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cache
+}
+
+func TestEpochCacheAssign(t *testing.T) {
+	cache := newTestEpochCache(t)
+	// Batches of the current or an older epoch add nothing
+	cache.assign(4, 22)
+	cache.assign(2, 23)
+	cache.assign(UNDEFINED_EPOCH, 24)
+	want := []EpochEntry{{1, 0}, {3, 10}, {4, 20}}
+	if !slices.Equal(cache.entries, want) {
+		t.Fatalf("got epochs %v, want %v", cache.entries, want)
+	}
+
+	reloaded, err := loadLeaderEpochCache(filepath.Dir(cache.path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(reloaded.entries, want) {
+		t.Errorf("got checkpointed epochs %v, want %v", reloaded.entries, want)
+	}
+}
+
+func TestEpochCacheEndOffsetFor(t *testing.T) {
+	cache := newTestEpochCache(t)
+	tests := []struct {
+		epoch     int32
+		wantEpoch int32
+		wantEnd   int64
+	}{
+		{4, 4, 25},
+		{3, 3, 20},
+		{2, 1, 10},
+		{1, 1, 10},
+		{0, 0, 0},
+		{5, UNDEFINED_EPOCH, UNDEFINED_EPOCH_OFFSET},
+		{UNDEFINED_EPOCH, UNDEFINED_EPOCH, UNDEFINED_EPOCH_OFFSET},
+	}
+	for _, test := range tests {
+		epoch, end := cache.endOffsetFor(test.epoch, 25)
+		if epoch != test.wantEpoch || end != test.wantEnd {
+			t.Errorf("epoch %d: got %d ending at %d, want %d ending at %d", test.epoch, epoch, end, test.wantEpoch, test.wantEnd)
+		}
+	}
+}
+
+func TestEpochCacheTruncation(t *testing.T) {
+	tests := []struct {
+		name     string
+		truncate func(*LeaderEpochCache) error
+		want     []EpochEntry
+	}{
+		{"from end", func(c *LeaderEpochCache) error { return c.truncateFromEnd(15) }, []EpochEntry{{1, 0}, {3, 10}}},
+		{"from end at epoch start", func(c *LeaderEpochCache) error { return c.truncateFromEnd(10) }, []EpochEntry{{1, 0}}},
+		{"from end past the log", func(c *LeaderEpochCache) error { return c.truncateFromEnd(30) }, []EpochEntry{{1, 0}, {3, 10}, {4, 20}}},
+		{"from start", func(c *LeaderEpochCache) error { return c.truncateFromStart(12) }, []EpochEntry{{3, 12}, {4, 20}}},
+		{"from start in first epoch", func(c *LeaderEpochCache) error { return c.truncateFromStart(5) }, []EpochEntry{{1, 5}, {3, 10}, {4, 20}}},
+		{"from start at epoch start", func(c *LeaderEpochCache) error { return c.truncateFromStart(20) }, []EpochEntry{{4, 20}}},
+		{"clear", func(c *LeaderEpochCache) error { return c.clear() }, []EpochEntry{}},
+	}
+	for _, test := range tests {
+		cache := newTestEpochCache(t)
+		if err := test.truncate(cache); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(cache.entries, test.want) {
+			t.Errorf("%s: got epochs %v, want %v", test.name, cache.entries, test.want)
+		}
+	}
+}
+
+func TestEpochCacheEpochsBetween(t *testing.T) {
+	cache := newTestEpochCache(t)
+	tests := []struct {
+		start int64
+		end   int64
+		want  []EpochEntry
+	}{
+		{0, 25, []EpochEntry{{1, 0}, {3, 10}, {4, 20}}},
+		{5, 22, []EpochEntry{{1, 5}, {3, 10}, {4, 20}}},
+		{12, 15, []EpochEntry{{3, 12}}},
+		{10, 20, []EpochEntry{{3, 10}}},
+	}
+	for _, test := range tests {
+		if got := cache.epochsBetween(test.start, test.end); !slices.Equal(got, test.want) {
+			t.Errorf("epochs from %d to %d: got %v, want %v", test.start, test.end, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	SECURITY_PROTOCOL_PLAINTEXT      = "PLAINTEXT"
+	SECURITY_PROTOCOL_SSL            = "SSL"
+	SECURITY_PROTOCOL_SASL_PLAINTEXT = "SASL_PLAINTEXT"
+	SECURITY_PROTOCOL_SASL_SSL       = "SASL_SSL"
+)
+
+// Listener is an endpoint the broker accepts or advertises connections on.
+type Listener struct {
+	Name             string
+	SecurityProtocol string
+	Host             string
+	Port             int32
+}
+
+func (l Listener) usesTls() bool {
+	return l.SecurityProtocol == SECURITY_PROTOCOL_SSL || l.SecurityProtocol == SECURITY_PROTOCOL_SASL_SSL
+}
+
+func (l Listener) usesSasl() bool {
+	return l.SecurityProtocol == SECURITY_PROTOCOL_SASL_PLAINTEXT || l.SecurityProtocol == SECURITY_PROTOCOL_SASL_SSL
+}
+
+func (l Listener) address() string {
+	return net.JoinHostPort(l.Host, strconv.Itoa(int(l.Port)))
+}
+
+func isSecurityProtocol(protocol string) bool {
+	switch protocol {
+	case SECURITY_PROTOCOL_PLAINTEXT, SECURITY_PROTOCOL_SSL, SECURITY_PROTOCOL_SASL_PLAINTEXT, SECURITY_PROTOCOL_SASL_SSL:
+		return true
+	}
+	return false
+}
+
+// parseSecurityProtocolMap reads listener.security.protocol.map, a list of
+// NAME:PROTOCOL pairs.
+func parseSecurityProtocolMap(value string) (map[string]string, error) {
+	protocols := make(map[string]string)
+	for _, entry := range parseList(value) {
+		name, protocol, ok := strings.Cut(entry, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid listener security protocol mapping %s", entry)
+		}
+		if !isSecurityProtocol(protocol) {
+			return nil, fmt.Errorf("unknown security protocol %s for listener %s", protocol, name)
+		}
+		protocols[name] = protocol
+	}
+	return protocols, nil
+}
+
+// parseListeners reads a list of NAME://host:port endpoints. The security
+// protocol of each listener comes from the protocol map.
+func parseListeners(value string, protocols map[string]string) ([]Listener, error) {
+	listeners := make([]Listener, 0)
+	seen := make(map[string]bool)
+	for _, entry := range parseList(value) {
+		name, address, ok := strings.Cut(entry, "://")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid listener %s", entry)
+		}
+		host, portString, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listener %s: %w", entry, err)
+		}
+		port, err := strconv.ParseInt(portString, 10, 32)
+		if err != nil || port < 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid port in listener %s", entry)
+		}
+		protocol, ok := protocols[name]
+		if !ok {
+			return nil, fmt.Errorf("no security protocol defined for listener %s", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("listener %s is defined twice", name)
+		}
+		seen[name] = true
+		listeners = append(listeners, Listener{Name: name, SecurityProtocol: protocol, Host: host, Port: int32(port)})
+	}
+	return listeners, nil
+}
+
+// defaultAdvertisedListeners advertises the listeners themselves, with the
+// machine's host name for those bound to every interface.
+func defaultAdvertisedListeners(listeners []Listener) []Listener {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	advertised := make([]Listener, 0, len(listeners))
+	for _, listener := range listeners {
+		if listener.Host == "" || listener.Host == "0.0.0.0" || listener.Host == "::" {
+			listener.Host = hostname
+		}
+		advertised = append(advertised, listener)
+	}
+	return advertised
+}
+
+func findListener(listeners []Listener, name string) (Listener, bool) {
+	for _, listener := range listeners {
+		if listener.Name == name {
+			return listener, true
+		}
+	}
+	return Listener{}, false
+}
+
+// advertisedListener returns the address clients connected to the named
+// listener should use to reach the broker.
+func advertisedListener(name string) (Listener, bool) {
+	return findListener(brokerConfig.AdvertisedListeners, name)
+}
+
+// startListeners binds every listener and runs its accept loop, returning
+// the bound listeners so they can be closed on shutdown.
+func startListeners() ([]net.Listener, error) {
+	for _, listener := range brokerConfig.Listeners {
+		if listener.usesTls() {
+			if err := loadTlsCertificates(); err != nil {
+				return nil, err
+			}
+			startTlsReloadTask()
+			break
+		}
+	}
+
+	netListeners := make([]net.Listener, 0, len(brokerConfig.Listeners))
+	for _, listener := range brokerConfig.Listeners {
+		var l net.Listener
+		var err error
+		if listener.usesTls() {
+			l, err = listenTls(listener.address())
+		} else {
+			l, err = net.Listen("tcp", listener.address())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to bind listener %s to %s: %w", listener.Name, listener.address(), err)
+		}
+		netListeners = append(netListeners, l)
+	}
+
+	for i, listener := range brokerConfig.Listeners {
+		go acceptConnections(netListeners[i], listener)
+	}
+	return netListeners, nil
+}
+
+func acceptConnections(l net.Listener, listener Listener) {
+	for {
+		connectionQuotas.reserveConnectionSlot()
+		fmt.Println("Waiting for connection on listener ", listener.Name, "...")
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			connectionQuotas.releaseConnectionSlot()
+			return
+		}
+		if err != nil {
+			fmt.Println("Error accepting connection: ", err.Error())
+			connectionQuotas.releaseConnectionSlot()
+			continue
+		}
+		if !connectionQuotas.inc(conn) {
+			fmt.Println("Rejecting connection from ", conn.RemoteAddr().String(), ", max.connections.per.ip reached")
+			connectionQuotas.releaseConnectionSlot()
+			conn.Close()
+			continue
+		}
+		go handleConnection(conn, listener)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestParseSecurityProtocolMap(t *testing.T) {
+	protocols, err := parseSecurityProtocolMap("INTERNAL:PLAINTEXT, EXTERNAL:SASL_SSL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(protocols) != 2 || protocols["INTERNAL"] != SECURITY_PROTOCOL_PLAINTEXT || protocols["EXTERNAL"] != SECURITY_PROTOCOL_SASL_SSL {
+		t.Errorf("got protocols %v", protocols)
+	}
+
+	for _, value := range []string{"INTERNAL", ":PLAINTEXT", "INTERNAL:HTTP"} {
+		if _, err := parseSecurityProtocolMap(value); err == nil {
+			t.Errorf("protocol map %q parsed, want an error", value)
+		}
+	}
+}
+
+func TestParseListeners(t *testing.T) {
+	protocols := map[string]string{"INTERNAL": SECURITY_PROTOCOL_PLAINTEXT, "EXTERNAL": SECURITY_PROTOCOL_SSL}
+	listeners, err := parseListeners("INTERNAL://:9092,EXTERNAL://[::1]:9093", protocols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Listener{
+		{Name: "INTERNAL", SecurityProtocol: SECURITY_PROTOCOL_PLAINTEXT, Host: "", Port: 9092},
+		{Name: "EXTERNAL", SecurityProtocol: SECURITY_PROTOCOL_SSL, Host: "::1", Port: 9093},
+	}
+	if !slices.Equal(listeners, want) {
+		t.Errorf("got listeners %+v, want %+v", listeners, want)
+	}
+	if address := listeners[1].address(); address != "[::1]:9093" {
+		t.Errorf("got address %s, want [::1]:9093", address)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"no scheme", "INTERNAL:9092"},
+		{"no port", "INTERNAL://localhost"},
+		{"port out of range", "INTERNAL://localhost:65536"},
+		{"no protocol", "OTHER://localhost:9092"},
+		{"defined twice", "INTERNAL://localhost:9092,INTERNAL://localhost:9093"},
+	}
+	for _, test := range tests {
+		if _, err := parseListeners(test.value, protocols); err == nil {
+			t.Errorf("%s: %q parsed, want an error", test.name, test.value)
+		}
+	}
+}
+
+func TestDefaultAdvertisedListeners(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skip("no host name")
+	}
+	listeners := []Listener{
+		{Name: "INTERNAL", SecurityProtocol: SECURITY_PROTOCOL_PLAINTEXT, Host: "0.0.0.0", Port: 9092},
+		{Name: "EXTERNAL", SecurityProtocol: SECURITY_PROTOCOL_SSL, Host: "broker.example.com", Port: 9093},
+	}
+	advertised := defaultAdvertisedListeners(listeners)
+	if advertised[0].Host != hostname || advertised[1].Host != "broker.example.com" {
+		t.Errorf("got advertised listeners %+v", advertised)
+	}
+	if listeners[0].Host != "0.0.0.0" {
+		t.Errorf("the listeners were changed")
+	}
+
+	if listener, ok := findListener(advertised, "EXTERNAL"); !ok || listener.Port != 9093 {
+		t.Errorf("got listener %+v, %v, want EXTERNAL", listener, ok)
+	}
+	if _, ok := findListener(advertised, "OTHER"); ok {
+		t.Errorf("found an unknown listener")
+	}
+}
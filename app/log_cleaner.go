@@ -0,0 +1,399 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	CLEANUP_POLICY_DELETE  = "delete"
+	CLEANUP_POLICY_COMPACT = "compact"
+
+	CLEANER_OFFSET_CHECKPOINT_FILE = "cleaner-offset-checkpoint"
+	CLEANED_SEGMENT_SUFFIX         = ".cleaned"
+)
+
+// Offset from which each compacted log has not been cleaned yet, by
+// partition folder, guarded by cleanerOffsetsMu
+var (
+	cleanerOffsetsMu sync.Mutex
+	cleanerOffsets   = make(map[string]int64)
+)
+
+// cleanupPolicies returns how old records of a topic are removed. The
+// metadata log is replayed whole on startup so nothing is ever removed from
+// it, while the coordinators only need the latest record of each key.
+func cleanupPolicies(topicName string) []string {
+	switch topicName {
+	case METADATA_TOPIC:
+		return []string{}
+	case CONSUMER_OFFSETS_TOPIC, TRANSACTION_STATE_TOPIC:
+		return []string{CLEANUP_POLICY_COMPACT}
+	}
+	return parseList(topicConfig(topicName, "cleanup.policy", brokerConfig.LogCleanupPolicy))
+}
+
+func hasCleanupPolicy(topicName string, policy string) bool {
+	return slices.Contains(cleanupPolicies(topicName), policy)
+}
+
+// topicDeleteRetentionMs returns how long tombstones of the topic are kept.
+func topicDeleteRetentionMs(topicName string) int64 {
+	value := topicConfig(topicName, "delete.retention.ms", "")
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+		return n
+	}
+	return int64(brokerConfig.LogCleanerDeleteRetentionMs)
+}
+
+// topicMinCleanableRatio returns the share of the log that must be dirty
+// before the topic is cleaned.
+func topicMinCleanableRatio(topicName string) float64 {
+	value := topicConfig(topicName, "min.cleanable.dirty.ratio", "")
+	if ratio, err := strconv.ParseFloat(value, 64); err == nil && ratio >= 0 && ratio <= 1 {
+		return ratio
+	}
+	return brokerConfig.LogCleanerMinCleanableRatio
+}
+
+// loadCleanerOffsets reads the cleaner checkpoint. Without it compacted logs
+// are cleaned from their start.
+func loadCleanerOffsets() {
+	offsets, err := readOffsetCheckpoints(CLEANER_OFFSET_CHECKPOINT_FILE)
+	if err != nil {
+		fmt.Println("Ignoring cleaner offset checkpoint: ", err.Error())
+		return
+	}
+	cleanerOffsetsMu.Lock()
+	defer cleanerOffsetsMu.Unlock()
+	cleanerOffsets = offsets
+}
+
+// checkpointCleanerOffsets writes the first dirty offset of every open log
+// that was cleaned.
+func checkpointCleanerOffsets() error {
+	cleanerOffsetsMu.Lock()
+	defer cleanerOffsetsMu.Unlock()
+
+	logs := make([]*PartitionLog, 0)
+	offsets := make([]int64, 0)
+	for _, log := range openPartitionLogs() {
+		if offset, ok := cleanerOffsets[log.dir]; ok {
+			logs = append(logs, log)
+			offsets = append(offsets, offset)
+		}
+	}
+	return writeOffsetCheckpoints(CLEANER_OFFSET_CHECKPOINT_FILE, logs, offsets)
+}
+
+// decodeRecordBatch decodes the records of an encoded batch, decompressing
+// them first.
+func decodeRecordBatch(data []byte) (*RecordBatch, error) {
+	header, err := decodeRecordBatchHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if codec := header.compressionCodec(); codec != RECORD_BATCH_COMPRESSION_NONE {
+		records, err := decompressRecords(codec, data[RECORD_BATCH_HEADER_SIZE:])
+		if err != nil {
+			return nil, err
+		}
+		data = append(data[:RECORD_BATCH_HEADER_SIZE:RECORD_BATCH_HEADER_SIZE], records...)
+	}
+	var batch RecordBatch
+	if err := ktypes.NewKDecoder(data).Decode(&batch); err != nil {
+		return nil, fmt.Errorf("unable to decode the record batch: %w", err)
+	}
+	return &batch, nil
+}
+
+// isAbortedBatch reports whether a batch belongs to an aborted transaction,
+// callers hold l.mu.
+func (l *PartitionLog) isAbortedBatch(header *RecordBatchHeader) bool {
+	if !header.isTransactional() || header.isControl() {
+		return false
+	}
+	for _, abortedTxn := range l.abortedTxns {
+		if abortedTxn.ProducerId == int64(header.ProducerId) &&
+			abortedTxn.FirstOffset <= int64(header.BaseOffset) && int64(header.BaseOffset) <= abortedTxn.LastOffset {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanableBatch reports whether the cleaner can read the batch records.
+// Control batches, and batches compressed with a codec the broker does not
+// support, are left as they are.
+func cleanableBatch(header *RecordBatchHeader) bool {
+	return !header.isControl() && supportedCompressionCodec(header.compressionCodec())
+}
+
+// CleanerStats describes what a cleaning pass did.
+type CleanerStats struct {
+	SegmentsCleaned int
+	RecordsRemoved  int
+	// Dirty batches kept whole as their compression codec is not supported
+	BatchesSkipped int
+}
+
+// clean compacts the log: every record followed by a newer record with the
+// same key in the dirty section, from firstDirtyOffset, is removed, as well
+// as tombstones older than deleteRetentionMs and records of aborted
+// transactions. Offsets of the records kept do not change. Only segments
+// below both the active segment and the last stable offset are cleaned.
+// Gzip batches are decompressed and compressed again, while batches
+// compressed with snappy, lz4 or zstd are kept whole and their records do
+// not supersede older ones. It returns the offset the next pass starts
+// from, unchanged when the dirty section is below minCleanableRatio of the
+// log.
+func (l *PartitionLog) clean(firstDirtyOffset int64, nowMs int64, deleteRetentionMs int64, minCleanableRatio float64) (int64, CleanerStats, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var stats CleanerStats
+	segments, err := l.segmentFiles()
+	if err != nil || len(segments) < 2 {
+		return firstDirtyOffset, stats, err
+	}
+
+	// Segments end where the next one starts
+	firstUncleanableOffset := l.lastStableOffset()
+	endOffsets := make([]int64, 0, len(segments)-1)
+	for i := range segments[:len(segments)-1] {
+		nextBaseOffset, err := segmentBaseOffset(segments[i+1])
+		if err != nil {
+			return firstDirtyOffset, stats, err
+		}
+		if nextBaseOffset > firstUncleanableOffset {
+			break
+		}
+		endOffsets = append(endOffsets, nextBaseOffset)
+	}
+	segments = segments[:len(endOffsets)]
+	if len(segments) == 0 {
+		return firstDirtyOffset, stats, nil
+	}
+	firstDirtyOffset = max(firstDirtyOffset, l.logStartOffset)
+	cleanEndOffset := endOffsets[len(endOffsets)-1]
+	if firstDirtyOffset >= cleanEndOffset {
+		return firstDirtyOffset, stats, nil
+	}
+
+	var dirtyBytes, totalBytes int64
+	for i, segment := range segments {
+		info, err := os.Stat(segment)
+		if err != nil {
+			return firstDirtyOffset, stats, err
+		}
+		totalBytes += info.Size()
+		if endOffsets[i] > firstDirtyOffset {
+			dirtyBytes += info.Size()
+		}
+	}
+	if totalBytes == 0 || float64(dirtyBytes)/float64(totalBytes) < minCleanableRatio {
+		return firstDirtyOffset, stats, nil
+	}
+
+	// Offset of the latest record of each key in the dirty section
+	latestOffsets := make(map[string]int64)
+	for i, segment := range segments {
+		if endOffsets[i] <= firstDirtyOffset {
+			continue
+		}
+		batches, err := readSegmentBatches(segment)
+		if err != nil {
+			return firstDirtyOffset, stats, err
+		}
+		for _, raw := range batches {
+			if raw.Header.lastOffset() < firstDirtyOffset {
+				continue
+			}
+			if !raw.Header.isControl() && !supportedCompressionCodec(raw.Header.compressionCodec()) {
+				stats.BatchesSkipped++
+			}
+			if !cleanableBatch(&raw.Header) || l.isAbortedBatch(&raw.Header) {
+				continue
+			}
+			batch, err := decodeRecordBatch(raw.Data)
+			if err != nil {
+				return firstDirtyOffset, stats, err
+			}
+			for _, record := range batch.Records {
+				offset := int64(batch.BaseOffset) + int64(record.OffsetDelta)
+				if record.Key != nil && offset >= firstDirtyOffset {
+					latestOffsets[string(record.Key)] = offset
+				}
+			}
+		}
+	}
+
+	for _, segment := range segments {
+		removed, err := l.cleanSegment(segment, latestOffsets, nowMs-deleteRetentionMs)
+		if err != nil {
+			return firstDirtyOffset, stats, err
+		}
+		if removed > 0 {
+			stats.SegmentsCleaned++
+			stats.RecordsRemoved += removed
+		}
+	}
+	return cleanEndOffset, stats, nil
+}
+
+// cleanSegment rewrites a segment without the records superseded in
+// latestOffsets, and without its tombstones if it is older than
+// deleteHorizonMs. The cleaned copy replaces the segment with a rename, so
+// a crash leaves either version. It returns the number of records removed.
+func (l *PartitionLog) cleanSegment(segment string, latestOffsets map[string]int64, deleteHorizonMs int64) (int, error) {
+	batches, err := readSegmentBatches(segment)
+	if err != nil {
+		return 0, err
+	}
+	largestTimestamp, err := segmentLargestTimestamp(segment)
+	if err != nil {
+		return 0, err
+	}
+	dropTombstones := largestTimestamp < deleteHorizonMs
+
+	removed := 0
+	cleaned := make([]byte, 0)
+	for _, raw := range batches {
+		if !cleanableBatch(&raw.Header) {
+			cleaned = append(cleaned, raw.Data...)
+			continue
+		}
+		batch, err := decodeRecordBatch(raw.Data)
+		if err != nil {
+			return 0, err
+		}
+		aborted := l.isAbortedBatch(&raw.Header)
+		kept := make([]Record, 0, len(batch.Records))
+		for _, record := range batch.Records {
+			offset := int64(batch.BaseOffset) + int64(record.OffsetDelta)
+			if aborted {
+				continue
+			}
+			if latestOffset, ok := latestOffsets[string(record.Key)]; record.Key != nil && ok && offset < latestOffset {
+				continue
+			}
+			if record.Value == nil && dropTombstones {
+				continue
+			}
+			kept = append(kept, record)
+		}
+		removed += len(batch.Records) - len(kept)
+		switch {
+		case len(kept) == len(batch.Records):
+			cleaned = append(cleaned, raw.Data...)
+		case len(kept) == 0 && !l.producerState.isLastBatch(&raw.Header):
+		default:
+			// The last offset delta is kept, so the batch still ends at the
+			// same offset. The last batch of a producer is kept even when
+			// empty, its header holds the producer epoch and sequence that
+			// duplicates are detected with after a restart.
+			batch.Records = kept
+			encoded, err := encodeRecordBatch(batch)
+			if err != nil {
+				return 0, err
+			}
+			cleaned = append(cleaned, encoded...)
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	cleanedPath := segment + CLEANED_SEGMENT_SUFFIX
+	file, err := os.Create(cleanedPath)
+	if err != nil {
+		return 0, fmt.Errorf("unable to create cleaned segment: %w", err)
+	}
+	if _, err := file.Write(cleaned); err != nil {
+		file.Close()
+		return 0, fmt.Errorf("unable to write cleaned segment: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return 0, fmt.Errorf("unable to sync cleaned segment: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(cleanedPath, segment); err != nil {
+		return 0, fmt.Errorf("unable to swap cleaned segment: %w", err)
+	}
+	return removed, nil
+}
+
+// removeCleanedSegments deletes the copies left by a cleaner interrupted
+// before swapping them in.
+func removeCleanedSegments(dir string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), CLEANED_SEGMENT_SUFFIX) {
+			if err := os.Remove(dir + "/" + file.Name()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cleanLogs compacts the partitions of every topic with the compact policy.
+func cleanLogs(nowMs int64) error {
+	logs, err := partitionLogsWithPolicy(CLEANUP_POLICY_COMPACT)
+	if err != nil {
+		return err
+	}
+
+	cleaned := false
+	for _, log := range logs {
+		cleanerOffsetsMu.Lock()
+		firstDirtyOffset := cleanerOffsets[log.dir]
+		cleanerOffsetsMu.Unlock()
+
+		nextDirtyOffset, stats, err := log.clean(firstDirtyOffset, nowMs, topicDeleteRetentionMs(log.topicName), topicMinCleanableRatio(log.topicName))
+		if err != nil {
+			return fmt.Errorf("unable to clean %s: %w", log.dir, err)
+		}
+		if nextDirtyOffset == firstDirtyOffset {
+			continue
+		}
+		if stats.RecordsRemoved > 0 {
+			fmt.Println("Cleaned ", log.dir, ": removed ", stats.RecordsRemoved, " records from ", stats.SegmentsCleaned, " segments")
+		}
+		if stats.BatchesSkipped > 0 {
+			fmt.Println("Not cleaning ", stats.BatchesSkipped, " batches of ", log.dir, " compressed with snappy, lz4 or zstd")
+		}
+		cleanerOffsetsMu.Lock()
+		cleanerOffsets[log.dir] = nextDirtyOffset
+		cleanerOffsetsMu.Unlock()
+		cleaned = true
+	}
+	if cleaned {
+		return checkpointCleanerOffsets()
+	}
+	return nil
+}
+
+func startLogCleanerTask() {
+	if !brokerConfig.LogCleanerEnable {
+		return
+	}
+	runPeriodicTask(time.Duration(brokerConfig.LogCleanerBackoffMs)*time.Millisecond, func() {
+		if err := cleanLogs(time.Now().UnixMilli()); err != nil {
+			fmt.Println("Error cleaning logs: ", err.Error())
+		}
+	})
+}
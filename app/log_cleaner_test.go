@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"maps"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+// appendTestRecord appends a single record batch, written by producerId
+// with sequence unless producerId is NO_PRODUCER_ID. A nil value is a
+// tombstone.
+func appendTestRecord(t *testing.T, log *PartitionLog, producerId int64, sequence int32, key string, value []byte) {
+	t.Helper()
+	batch := newRecordBatch(0, time.Now().UnixMilli(), []Record{{Key: []byte(key), Value: value}})
+	if producerId != NO_PRODUCER_ID {
+		batch.ProducerId = ktypes.Int64(producerId)
+		batch.ProducerEpoch = ktypes.Int16(0)
+		batch.FirstSequence = ktypes.Int32(sequence)
+	}
+	data, err := encodeRecordBatch(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := log.AppendBatches(data); err != nil {
+		t.Fatalf("appending %s: %v", key, err)
+	}
+}
+
+// keysByOffset returns the key of every record left in the log, and the
+// batches left by base offset
+func keysByOffset(t *testing.T, log *PartitionLog) (map[int64]string, map[int64]*RecordBatch) {
+	t.Helper()
+	batches, err := log.ReadBatches(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make(map[int64]string)
+	byOffset := make(map[int64]*RecordBatch)
+	for _, batch := range batches {
+		byOffset[int64(batch.BaseOffset)] = batch
+		for _, record := range batch.Records {
+			keys[int64(batch.BaseOffset)+int64(record.OffsetDelta)] = string(record.Key)
+		}
+	}
+	return keys, byOffset
+}
+
+func TestCleanKeepsLatestRecordOfEachKey(t *testing.T) {
+	// Every batch gets a segment of its own
+	log := openTestPartitionLog(t, 1)
+	appendTestRecord(t, log, NO_PRODUCER_ID, 0, "a", []byte("1"))
+	appendTestRecord(t, log, NO_PRODUCER_ID, 0, "b", []byte("1"))
+	appendTestRecord(t, log, NO_PRODUCER_ID, 0, "a", []byte("2"))
+	appendTestRecord(t, log, NO_PRODUCER_ID, 0, "b", nil)
+	// The active segment is never cleaned
+	appendTestRecord(t, log, NO_PRODUCER_ID, 0, "c", []byte("1"))
+
+	nextDirtyOffset, stats, err := log.clean(0, time.Now().UnixMilli(), math.MaxInt32, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextDirtyOffset != 4 {
+		t.Errorf("got next dirty offset %d, want 4", nextDirtyOffset)
+	}
+	if stats.RecordsRemoved != 2 {
+		t.Errorf("removed %d records, want 2", stats.RecordsRemoved)
+	}
+	keys, _ := keysByOffset(t, log)
+	want := map[int64]string{2: "a", 3: "b", 4: "c"}
+	if len(keys) != len(want) {
+		t.Fatalf("got records %v, want %v", keys, want)
+	}
+	for offset, key := range want {
+		if keys[offset] != key {
+			t.Errorf("got records %v, want %v", keys, want)
+		}
+	}
+}
+
+func TestCleanDropsOldTombstones(t *testing.T) {
+	log := openTestPartitionLog(t, 1)
+	appendTestRecord(t, log, NO_PRODUCER_ID, 0, "a", []byte("1"))
+	appendTestRecord(t, log, NO_PRODUCER_ID, 0, "a", nil)
+	appendTestRecord(t, log, NO_PRODUCER_ID, 0, "c", []byte("1"))
+
+	// Tombstones are past delete.retention.ms once it is 0 and time moved on
+	if _, _, err := log.clean(0, time.Now().UnixMilli()+1000, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	keys, _ := keysByOffset(t, log)
+	if len(keys) != 1 || keys[2] != "c" {
+		t.Fatalf("got records %v, want only c at 2", keys)
+	}
+}
+
+func TestCleanKeepsLastBatchOfProducer(t *testing.T) {
+	log := openTestPartitionLog(t, 1)
+	appendTestRecord(t, log, 1, 0, "a", []byte("1"))
+	appendTestRecord(t, log, 2, 0, "c", []byte("1"))
+	appendTestRecord(t, log, 2, 1, "c", []byte("2"))
+	appendTestRecord(t, log, NO_PRODUCER_ID, 0, "a", []byte("2"))
+	appendTestRecord(t, log, NO_PRODUCER_ID, 0, "z", []byte("1"))
+
+	if _, _, err := log.clean(0, time.Now().UnixMilli(), math.MaxInt32, 0); err != nil {
+		t.Fatal(err)
+	}
+	keys, batches := keysByOffset(t, log)
+
+	// Producer 1 wrote nothing after its superseded record, the batch is
+	// kept empty with its producer id, epoch and sequence
+	empty, ok := batches[0]
+	if !ok {
+		t.Fatalf("the last batch of producer 1 was removed: %v", keys)
+	}
+	if len(empty.Records) != 0 || empty.ProducerId != 1 || empty.ProducerEpoch != 0 || empty.FirstSequence != 0 || empty.lastOffset() != 0 {
+		t.Errorf("got batch %+v, want an empty batch of producer 1 ending at 0", empty)
+	}
+	// The earlier batch of producer 2 goes
+	if _, ok := batches[1]; ok {
+		t.Errorf("superseded batch of producer 2 was kept: %v", keys)
+	}
+	if keys[2] != "c" || keys[3] != "a" || keys[4] != "z" || len(keys) != 3 {
+		t.Errorf("got records %v, want c at 2, a at 3 and z at 4", keys)
+	}
+}
+
+// appendCompressedTestBatch appends a batch of records with the keys and
+// values, compressed with codec. Batches of unsupported codecs only have
+// their attributes set, the broker never reads their records.
+func appendCompressedTestBatch(t *testing.T, log *PartitionLog, codec int16, keyValues ...string) {
+	t.Helper()
+	records := make([]Record, 0, len(keyValues)/2)
+	for i := 0; i < len(keyValues); i += 2 {
+		records = append(records, Record{Key: []byte(keyValues[i]), Value: []byte(keyValues[i+1])})
+	}
+	batch := newRecordBatch(0, time.Now().UnixMilli(), records)
+	if supportedCompressionCodec(codec) {
+		batch.Attributes = ktypes.Int16(codec)
+	}
+	data, err := encodeRecordBatch(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !supportedCompressionCodec(codec) {
+		binary.BigEndian.PutUint16(data[RECORD_BATCH_ATTRIBUTES_OFFSET:], uint16(codec))
+		binary.BigEndian.PutUint32(data[RECORD_BATCH_CRC_OFFSET:], crc32.Checksum(data[RECORD_BATCH_ATTRIBUTES_OFFSET:], crc32cTable))
+	}
+	if _, err := log.AppendBatches(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCleanCompressedBatches(t *testing.T) {
+	log := openTestPartitionLog(t, 1)
+	appendCompressedTestBatch(t, log, RECORD_BATCH_COMPRESSION_GZIP, "a", "1", "b", "1", "c", "1")
+	appendCompressedTestBatch(t, log, RECORD_BATCH_COMPRESSION_NONE, "a", "2")
+	appendCompressedTestBatch(t, log, RECORD_BATCH_COMPRESSION_GZIP, "b", "2")
+	appendCompressedTestBatch(t, log, RECORD_BATCH_COMPRESSION_ZSTD, "c", "2")
+	appendCompressedTestBatch(t, log, RECORD_BATCH_COMPRESSION_NONE, "z", "1")
+
+	_, stats, err := log.clean(0, time.Now().UnixMilli(), math.MaxInt32, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.RecordsRemoved != 2 || stats.BatchesSkipped != 1 {
+		t.Errorf("removed %d records and skipped %d batches, want 2 and 1", stats.RecordsRemoved, stats.BatchesSkipped)
+	}
+
+	// The gzip batch keeps its codec, while c in the zstd batch does not
+	// supersede the older c
+	segments, err := log.segmentFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make(map[int64]string)
+	codecs := make(map[int64]int16)
+	for _, segment := range segments {
+		batches, err := readSegmentBatches(segment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, raw := range batches {
+			codecs[int64(raw.Header.BaseOffset)] = raw.Header.compressionCodec()
+			if !supportedCompressionCodec(raw.Header.compressionCodec()) {
+				keys[int64(raw.Header.BaseOffset)] = "?"
+				continue
+			}
+			batch, err := decodeRecordBatch(raw.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, record := range batch.Records {
+				keys[int64(batch.BaseOffset)+int64(record.OffsetDelta)] = string(record.Key) + "=" + string(record.Value)
+			}
+		}
+	}
+	want := map[int64]string{2: "c=1", 3: "a=2", 4: "b=2", 5: "?", 6: "z=1"}
+	if !maps.Equal(keys, want) {
+		t.Errorf("got records %v, want %v", keys, want)
+	}
+	if codecs[0] != RECORD_BATCH_COMPRESSION_GZIP || codecs[5] != RECORD_BATCH_COMPRESSION_ZSTD {
+		t.Errorf("got codecs %v, want gzip at 0 and zstd at 5", codecs)
+	}
+}
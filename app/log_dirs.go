@@ -0,0 +1,625 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	META_PROPERTIES_FILE    = "meta.properties"
+	META_PROPERTIES_VERSION = 1
+
+	// Suffix of a partition folder being copied to another log directory
+	FUTURE_DIR_SUFFIX = "-future"
+)
+
+// Reserved directory ids (KIP-858). Replicas are UNASSIGNED until their
+// broker reports the directory holding them, LOST replicas are in a
+// directory the broker no longer has.
+var (
+	DIRECTORY_ID_UNASSIGNED = ktypes.UUID{}
+	DIRECTORY_ID_LOST       = ktypes.UUID{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+	DIRECTORY_ID_MIGRATING  = ktypes.UUID{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2}
+)
+
+// LogDirectory is one of the folders of log.dirs, identified by the
+// directory id of its meta.properties.
+type LogDirectory struct {
+	// Ending with a slash
+	path string
+	id   ktypes.UUID
+	// Set once an I/O error took the directory out of use, until the broker
+	// restarts
+	offline bool
+}
+
+// Log directories in log.dirs order, the first holding the metadata log.
+// The list is set once at startup, offline flags and the directory of each
+// partition folder are guarded by logDirsMu.
+var (
+	logDirsMu      sync.Mutex
+	logDirectories []*LogDirectory
+	// Directory of each partition folder name, once placed
+	partitionPlacements = make(map[string]*LogDirectory)
+)
+
+// A partition of a topic, by topic id
+type TopicIdPartition struct {
+	topicId   ktypes.UUID
+	partition int32
+}
+
+// Directories of replicas the partition metadata does not have yet, sent to
+// the active controller after the heartbeats, guarded by logDirsMu
+var pendingDirectoryAssignments = make(map[TopicIdPartition]ktypes.UUID)
+
+// Payload of the tagged fields listing directories
+type DirectoryIdList struct {
+	Ids ktypes.CompactArray[ktypes.UUID] `order:"1"`
+}
+
+// newDirectoryIdListField encodes a list of directories as a tagged field
+// value.
+func newDirectoryIdListField(ids []ktypes.UUID) []byte {
+	encoded, err := ktypes.NewKEncoder().Encode(&DirectoryIdList{Ids: ids})
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode directory id list: %v", err))
+	}
+	return encoded
+}
+
+// formatDirectoryId returns a directory id as Kafka prints uuids, in
+// unpadded URL-safe base64.
+func formatDirectoryId(id ktypes.UUID) string {
+	return base64.RawURLEncoding.EncodeToString(id[:])
+}
+
+func parseDirectoryId(value string) (ktypes.UUID, error) {
+	var id ktypes.UUID
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(decoded) != len(id) {
+		return id, fmt.Errorf("invalid directory id %q", value)
+	}
+	copy(id[:], decoded)
+	return id, nil
+}
+
+// newDirectoryId returns a random directory id, outside of the range
+// reserved by Kafka.
+func newDirectoryId() (ktypes.UUID, error) {
+	var id ktypes.UUID
+	for {
+		if _, err := rand.Read(id[:]); err != nil {
+			return id, err
+		}
+		if binary.BigEndian.Uint64(id[:8]) != 0 {
+			return id, nil
+		}
+	}
+}
+
+// loadLogDirs reads the meta.properties of every log directory, writing one
+// with a new directory id where there is none, and the cluster id. A
+// directory that cannot be loaded is offline, unless it holds the metadata
+// log which the broker cannot run without.
+func loadLogDirs() error {
+	logDirsMu.Lock()
+	defer logDirsMu.Unlock()
+
+	logDirectories = make([]*LogDirectory, 0, len(brokerConfig.LogDirs))
+	properties := make([]map[string]string, len(brokerConfig.LogDirs))
+	for i, path := range brokerConfig.LogDirs {
+		logDirectories = append(logDirectories, &LogDirectory{path: path})
+		if err := os.MkdirAll(path, 0755); err != nil {
+			continue
+		}
+		data, err := os.ReadFile(path + META_PROPERTIES_FILE)
+		if err != nil && !os.IsNotExist(err) {
+			continue
+		}
+		properties[i] = parseProperties(string(data))
+		if id := properties[i]["cluster.id"]; id != "" && clusterId == "" {
+			clusterId = id
+		}
+	}
+
+	for i, dir := range logDirectories {
+		err := dir.load(properties[i])
+		if err == nil {
+			continue
+		}
+		if i == 0 {
+			return fmt.Errorf("unable to load metadata log directory %s: %w", dir.path, err)
+		}
+		dir.offline = true
+		fmt.Println("Log directory ", dir.path, " is offline: ", err.Error())
+	}
+	return nil
+}
+
+// load sets up a log directory from its meta.properties, nil when it could
+// not be read. Copies of partitions to the directory that were interrupted
+// are deleted. Callers hold logDirsMu.
+func (d *LogDirectory) load(properties map[string]string) error {
+	if properties == nil {
+		return fmt.Errorf("unable to read %s", META_PROPERTIES_FILE)
+	}
+	if value := properties["node.id"]; value != "" && value != strconv.Itoa(brokerConfig.NodeId) {
+		return fmt.Errorf("node id %s does not match node.id %d", value, brokerConfig.NodeId)
+	}
+	if value := properties["cluster.id"]; value != "" && value != clusterId {
+		return fmt.Errorf("cluster id %s does not match %s", value, clusterId)
+	}
+
+	if value := properties["directory.id"]; value != "" {
+		id, err := parseDirectoryId(value)
+		if err != nil {
+			return err
+		}
+		d.id = id
+	} else {
+		id, err := newDirectoryId()
+		if err != nil {
+			return err
+		}
+		d.id = id
+	}
+	if properties["directory.id"] == "" || properties["cluster.id"] != clusterId {
+		if err := d.writeMetaProperties(); err != nil {
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasSuffix(entry.Name(), FUTURE_DIR_SUFFIX) {
+			if err := os.RemoveAll(d.path + entry.Name()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *LogDirectory) writeMetaProperties() error {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "version=%d\n", META_PROPERTIES_VERSION)
+	fmt.Fprintf(&builder, "node.id=%d\n", brokerConfig.NodeId)
+	fmt.Fprintf(&builder, "directory.id=%s\n", formatDirectoryId(d.id))
+	if clusterId != "" {
+		fmt.Fprintf(&builder, "cluster.id=%s\n", clusterId)
+	}
+	if err := writeCheckpointFile(d.path+META_PROPERTIES_FILE, builder.String()); err != nil {
+		return fmt.Errorf("unable to write %s: %w", META_PROPERTIES_FILE, err)
+	}
+	return nil
+}
+
+func (d *LogDirectory) isOffline() bool {
+	logDirsMu.Lock()
+	defer logDirsMu.Unlock()
+	return d.offline
+}
+
+// metadataLogDir returns the folder of the metadata log, the first of
+// log.dirs.
+func metadataLogDir() string {
+	return brokerConfig.LogDirs[0]
+}
+
+// logDirectoryFor returns the log directory of a partition folder.
+func logDirectoryFor(dir string) *LogDirectory {
+	for _, logDir := range logDirectories {
+		if strings.HasPrefix(dir, logDir.path) {
+			return logDir
+		}
+	}
+	return logDirectories[0]
+}
+
+// findLogDirectory returns the log directory at a path of log.dirs.
+func findLogDirectory(path string) (*LogDirectory, bool) {
+	path = filepath.Clean(path)
+	for _, logDir := range logDirectories {
+		if filepath.Clean(logDir.path) == path {
+			return logDir, true
+		}
+	}
+	return nil, false
+}
+
+// onlineLogDirectories returns the log directories still in use.
+func onlineLogDirectories() []*LogDirectory {
+	logDirsMu.Lock()
+	defer logDirsMu.Unlock()
+
+	online := make([]*LogDirectory, 0, len(logDirectories))
+	for _, logDir := range logDirectories {
+		if !logDir.offline {
+			online = append(online, logDir)
+		}
+	}
+	return online
+}
+
+// logDirIds returns the ids of the online or of the offline log
+// directories. Directories whose meta.properties could not be read have no
+// id to report.
+func logDirIds(offline bool) []ktypes.UUID {
+	logDirsMu.Lock()
+	defer logDirsMu.Unlock()
+
+	ids := make([]ktypes.UUID, 0, len(logDirectories))
+	for _, logDir := range logDirectories {
+		if logDir.offline == offline && logDir.id != DIRECTORY_ID_UNASSIGNED {
+			ids = append(ids, logDir.id)
+		}
+	}
+	return ids
+}
+
+func partitionFolderName(topicName string, partition int32) string {
+	return topicName + "-" + strconv.Itoa(int(partition))
+}
+
+// partitionLogDir returns the folder of a partition's log, placing the
+// partition in a log directory the first time. A partition stays where its
+// folder already is, else goes where the controller recorded it or where
+// AlterReplicaLogDirs asked for, else to the online directory using the
+// least space. Replicas recorded in an offline directory stay there. The
+// metadata log is always in the first directory.
+func partitionLogDir(topicName string, partition int32) string {
+	name := partitionFolderName(topicName, partition)
+
+	logDirsMu.Lock()
+	defer logDirsMu.Unlock()
+	if logDir, ok := partitionPlacements[name]; ok {
+		return logDir.path + name
+	}
+	logDir := placePartition(topicName, partition, name)
+	partitionPlacements[name] = logDir
+	return logDir.path + name
+}
+
+// placedPartitionLogDir returns the folder of a partition's log when the
+// partition was placed in a log directory.
+func placedPartitionLogDir(topicName string, partition int32) (string, bool) {
+	name := partitionFolderName(topicName, partition)
+
+	logDirsMu.Lock()
+	defer logDirsMu.Unlock()
+	logDir, ok := partitionPlacements[name]
+	if !ok {
+		return "", false
+	}
+	return logDir.path + name, true
+}
+
+// placePartition picks the log directory of a partition, callers hold
+// logDirsMu.
+func placePartition(topicName string, partition int32, name string) *LogDirectory {
+	for _, logDir := range logDirectories {
+		if info, err := os.Stat(logDir.path + name); err == nil && info.IsDir() {
+			return logDir
+		}
+	}
+	if topicName == METADATA_TOPIC {
+		return logDirectories[0]
+	}
+	if state, ok := partitionState(topicName, partition); ok && state.Directory != DIRECTORY_ID_UNASSIGNED && state.Directory != DIRECTORY_ID_MIGRATING {
+		for _, logDir := range logDirectories {
+			if logDir.id == state.Directory {
+				return logDir
+			}
+		}
+		// The replica may be in a directory whose meta.properties could not
+		// be read, it is not recreated empty elsewhere
+		for _, logDir := range logDirectories {
+			if logDir.offline {
+				return logDir
+			}
+		}
+	}
+
+	var leastUsed *LogDirectory
+	leastUsage := int64(-1)
+	for _, logDir := range logDirectories {
+		if logDir.offline {
+			continue
+		}
+		if usage := logDirUsage(logDir.path); leastUsed == nil || usage < leastUsage {
+			leastUsed, leastUsage = logDir, usage
+		}
+	}
+	return leastUsed
+}
+
+// logDirUsage returns the bytes taken by the files of a log directory.
+func logDirUsage(path string) int64 {
+	usage := int64(0)
+	filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			usage += info.Size()
+		}
+		return nil
+	})
+	return usage
+}
+
+// forgetPartitionLogDir drops the placement of a partition whose log was
+// deleted.
+func forgetPartitionLogDir(topicName string, partition int32) {
+	logDirsMu.Lock()
+	defer logDirsMu.Unlock()
+	delete(partitionPlacements, partitionFolderName(topicName, partition))
+}
+
+// preferPartitionLogDir places a partition this broker has no folder of
+// yet, for its log to be created in the log directory AlterReplicaLogDirs
+// asked for.
+func preferPartitionLogDir(topicName string, partition int32, target *LogDirectory) {
+	name := partitionFolderName(topicName, partition)
+
+	logDirsMu.Lock()
+	defer logDirsMu.Unlock()
+	if _, ok := partitionPlacements[name]; ok {
+		return
+	}
+	for _, logDir := range logDirectories {
+		if _, err := os.Stat(logDir.path + name); err == nil {
+			return
+		}
+	}
+	partitionPlacements[name] = target
+}
+
+// partitionFolders returns the partitions of a topic with a folder in an
+// online log directory.
+func partitionFolders(topicName string) ([]int32, error) {
+	partitions := make([]int32, 0)
+	for _, logDir := range onlineLogDirectories() {
+		folders, err := os.ReadDir(logDir.path)
+		if err != nil {
+			return nil, err
+		}
+		for _, folder := range folders {
+			partitionSuffix, ok := strings.CutPrefix(folder.Name(), topicName+"-")
+			if !folder.IsDir() || !ok {
+				continue
+			}
+			if partition, err := strconv.Atoi(partitionSuffix); err == nil {
+				partitions = append(partitions, int32(partition))
+			}
+		}
+	}
+	return partitions, nil
+}
+
+// readOffsetCheckpoints reads a checkpoint file of every online log
+// directory, returning offsets by partition folder.
+func readOffsetCheckpoints(name string) (map[string]int64, error) {
+	offsets := make(map[string]int64)
+	for _, logDir := range onlineLogDirectories() {
+		dirOffsets, err := readOffsetCheckpoint(logDir.path, name)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(offsets, dirOffsets)
+	}
+	return offsets, nil
+}
+
+// writeOffsetCheckpoints writes a checkpoint file in every online log
+// directory, with the offsets of the logs it holds. A directory the
+// checkpoint cannot be written to goes offline.
+func writeOffsetCheckpoints(name string, logs []*PartitionLog, offsets []int64) error {
+	var errs []error
+	for _, logDir := range onlineLogDirectories() {
+		dirLogs := make([]*PartitionLog, 0)
+		dirOffsets := make([]int64, 0)
+		for i, log := range logs {
+			if logDirectoryFor(log.dir) == logDir {
+				dirLogs = append(dirLogs, log)
+				dirOffsets = append(dirOffsets, offsets[i])
+			}
+		}
+		if err := writeOffsetCheckpoint(logDir.path+name, dirLogs, dirOffsets); err != nil {
+			markLogDirOffline(logDir, err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isStorageError tells whether an error comes from the disk failing.
+// Files closed or deleted under a request belong to logs that were closed
+// or moved meanwhile.
+func isStorageError(err error) bool {
+	if err == nil || errors.Is(err, os.ErrClosed) || errors.Is(err, fs.ErrNotExist) {
+		return false
+	}
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+	var syscallErr *os.SyscallError
+	return errors.As(err, &pathErr) || errors.As(err, &linkErr) || errors.As(err, &syscallErr)
+}
+
+// checkStorageError takes the log directory of a log offline when err is
+// an I/O error, which is then reported as KAFKA_STORAGE_ERROR.
+func checkStorageError(log *PartitionLog, err error) error {
+	if !isStorageError(err) {
+		return err
+	}
+	markLogDirOffline(logDirectoryFor(log.dir), err)
+	return newKafkaError(ERROR_CODE_KAFKA_STORAGE_ERROR, "log directory of %s failed: %v", log.dir, err)
+}
+
+// markLogDirOffline takes a log directory out of use after an I/O error. Its
+// logs are closed and their replicas stop fetching, the heartbeats then
+// report the directory to the controller which moves the replicas out of
+// their ISR. The broker stops when it is the metadata log directory.
+func markLogDirOffline(logDir *LogDirectory, cause error) {
+	logDirsMu.Lock()
+	if logDir.offline {
+		logDirsMu.Unlock()
+		return
+	}
+	logDir.offline = true
+	logDirsMu.Unlock()
+
+	fmt.Println("Log directory ", logDir.path, " is offline: ", cause.Error())
+	if logDir == logDirectories[0] {
+		fmt.Println("Shutting down, the metadata log directory is offline")
+		os.Exit(1)
+	}
+
+	partitionLogsMu.Lock()
+	offline := make([]*PartitionLog, 0)
+	for dir, log := range partitionLogs {
+		if logDirectoryFor(dir) == logDir {
+			delete(partitionLogs, dir)
+			offline = append(offline, log)
+		}
+	}
+	partitionLogsMu.Unlock()
+
+	for _, log := range offline {
+		removeFetcherPartition(log.topicName, log.partition)
+		followerStatesMu.Lock()
+		delete(followerStates, log.dir)
+		followerStatesMu.Unlock()
+		// The directory failed, its files may not be written anymore
+		log.Close()
+	}
+}
+
+// moveReplicaLogDir moves the folder of a partition to another log
+// directory, renaming it when both are on the same file system and copying
+// it otherwise. An open log is closed for the move, then reopened from its
+// new folder with the same offsets and role. Callers hold partitionRolesMu.
+func moveReplicaLogDir(topicName string, partition int32, dir string, target *LogDirectory) (string, error) {
+	partitionLogsMu.Lock()
+	defer partitionLogsMu.Unlock()
+
+	newDir := target.path + partitionFolderName(topicName, partition)
+	log, open := partitionLogs[dir]
+	if open {
+		if err := log.Close(); err != nil {
+			return "", fmt.Errorf("unable to close %s: %w", dir, err)
+		}
+		delete(partitionLogs, dir)
+	}
+
+	moveErr := os.Rename(dir, newDir)
+	if moveErr != nil {
+		futureDir := newDir + FUTURE_DIR_SUFFIX
+		if moveErr = copyDir(dir, futureDir); moveErr == nil {
+			moveErr = os.Rename(futureDir, newDir)
+		}
+		if moveErr != nil {
+			os.RemoveAll(futureDir)
+			newDir = dir
+		}
+	}
+	if moveErr == nil {
+		if err := os.RemoveAll(dir); err != nil {
+			fmt.Println("Error deleting ", dir, " after moving it: ", err.Error())
+		}
+		logDirsMu.Lock()
+		partitionPlacements[partitionFolderName(topicName, partition)] = target
+		logDirsMu.Unlock()
+	}
+
+	if open {
+		reopened, err := openPartitionLog(topicName, partition, newDir)
+		if err != nil {
+			return "", err
+		}
+		reopened.takeStateFrom(log)
+		partitionLogs[newDir] = reopened
+	}
+	if moveErr != nil {
+		return "", fmt.Errorf("unable to move %s to %s: %w", dir, target.path, moveErr)
+	}
+	return newDir, nil
+}
+
+// takeStateFrom gives a log reopened from a moved folder the offsets and
+// role of the closed log it replaces.
+func (l *PartitionLog) takeStateFrom(closed *PartitionLog) {
+	closed.mu.Lock()
+	defer closed.mu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.logStartOffset = max(l.logStartOffset, closed.logStartOffset)
+	l.replicated = closed.replicated
+	l.highWatermark = min(closed.highWatermark, l.logEndOffset)
+	l.leaderEpoch = closed.leaderEpoch
+}
+
+// copyDir copies the files of a partition folder to a new folder, synced.
+func copyDir(source string, target string) error {
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("unable to create %s: %w", target, err)
+	}
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if err := copyFile(filepath.Join(source, entry.Name()), filepath.Join(target, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return syncDir(target)
+}
+
+// queueDirectoryAssignment records the directory holding a replica of this
+// broker, for the controller to put in the partition metadata.
+func queueDirectoryAssignment(topicId ktypes.UUID, partition int32, directoryId ktypes.UUID) {
+	logDirsMu.Lock()
+	defer logDirsMu.Unlock()
+	pendingDirectoryAssignments[TopicIdPartition{topicId, partition}] = directoryId
+}
+
+// takeDirectoryAssignments returns the assignments to send to the
+// controller.
+func takeDirectoryAssignments() map[TopicIdPartition]ktypes.UUID {
+	logDirsMu.Lock()
+	defer logDirsMu.Unlock()
+	return maps.Clone(pendingDirectoryAssignments)
+}
+
+// completeDirectoryAssignments drops the assignments the controller
+// answered, unless they were replaced meanwhile.
+func completeDirectoryAssignments(sent map[TopicIdPartition]ktypes.UUID) {
+	logDirsMu.Lock()
+	defer logDirsMu.Unlock()
+	for key, directoryId := range sent {
+		if pendingDirectoryAssignments[key] == directoryId {
+			delete(pendingDirectoryAssignments, key)
+		}
+	}
+}
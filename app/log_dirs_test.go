@@ -0,0 +1,293 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+// setTestLogDirs sets count empty log directories in log.dirs and loads
+// them
+func setTestLogDirs(t *testing.T, count int) []*LogDirectory {
+	t.Helper()
+	previousConfig, previousClusterId := brokerConfig, clusterId
+	logDirsMu.Lock()
+	previousDirectories, previousPlacements, previousAssignments := logDirectories, partitionPlacements, pendingDirectoryAssignments
+	partitionPlacements = make(map[string]*LogDirectory)
+	pendingDirectoryAssignments = make(map[TopicIdPartition]ktypes.UUID)
+	logDirsMu.Unlock()
+	t.Cleanup(func() {
+		brokerConfig, clusterId = previousConfig, previousClusterId
+		logDirsMu.Lock()
+		defer logDirsMu.Unlock()
+		logDirectories, partitionPlacements, pendingDirectoryAssignments = previousDirectories, previousPlacements, previousAssignments
+	})
+
+	brokerConfig.NodeId = 1
+	clusterId = "test-cluster"
+	brokerConfig.LogDirs = make([]string, count)
+	for i := range brokerConfig.LogDirs {
+		brokerConfig.LogDirs[i] = t.TempDir() + "/"
+	}
+	if err := loadLogDirs(); err != nil {
+		t.Fatal(err)
+	}
+	return logDirectories
+}
+
+func TestLoadLogDirs(t *testing.T) {
+	logDirs := setTestLogDirs(t, 3)
+	ids := make([]ktypes.UUID, len(logDirs))
+	for i, logDir := range logDirs {
+		if logDir.offline || logDir.id == DIRECTORY_ID_UNASSIGNED || slices.Contains(ids, logDir.id) {
+			t.Fatalf("log dir %d: got offline %v with id %s, want online with a new id", i, logDir.offline, logDir.id)
+		}
+		ids[i] = logDir.id
+	}
+
+	// Ids are kept across restarts, interrupted copies are dropped and a
+	// directory of another broker is not used
+	future := brokerConfig.LogDirs[1] + "test-topic-0" + FUTURE_DIR_SUFFIX
+	if err := os.Mkdir(future, 0755); err != nil {
+		t.Fatal(err)
+	}
+	foreign := "version=1\nnode.id=2\ndirectory.id=" + formatDirectoryId(ids[2]) + "\n"
+	if err := os.WriteFile(brokerConfig.LogDirs[2]+META_PROPERTIES_FILE, []byte(foreign), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadLogDirs(); err != nil {
+		t.Fatal(err)
+	}
+	for i, logDir := range logDirectories[:2] {
+		if logDir.id != ids[i] || logDir.offline {
+			t.Errorf("log dir %d: got id %s, offline %v after reloading, want %s online", i, logDir.id, logDir.offline, ids[i])
+		}
+	}
+	if !logDirectories[2].offline {
+		t.Error("got a log dir of another broker online")
+	}
+	if _, err := os.Stat(future); !os.IsNotExist(err) {
+		t.Errorf("got interrupted copy left, %v", err)
+	}
+	if got, want := logDirIds(false), ids[:2]; !slices.Equal(got, want) {
+		t.Errorf("got online ids %v, want %v", got, want)
+	}
+	// Directories that could not be loaded have no id to report
+	if got := logDirIds(true); len(got) != 0 {
+		t.Errorf("got offline ids %v, want none", got)
+	}
+
+	// The broker does not run without its metadata log directory
+	if err := os.WriteFile(brokerConfig.LogDirs[0]+META_PROPERTIES_FILE, []byte("version=1\ncluster.id=other\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadLogDirs(); err == nil {
+		t.Error("got no error for a metadata log dir of another cluster")
+	}
+}
+
+func TestDirectoryIdFormat(t *testing.T) {
+	id, err := newDirectoryId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parseDirectoryId(formatDirectoryId(id))
+	if err != nil || parsed != id {
+		t.Errorf("got %s, %v parsing %s back", parsed, err, formatDirectoryId(id))
+	}
+	for _, value := range []string{"", "not base64!", "AAAA"} {
+		if _, err := parseDirectoryId(value); err == nil {
+			t.Errorf("got no error parsing %q", value)
+		}
+	}
+}
+
+func TestPartitionLogDir(t *testing.T) {
+	setTestPartition(t)
+	logDirs := setTestLogDirs(t, 3)
+	// The first directory is the most used
+	if err := os.WriteFile(logDirs[0].path+"data", make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(logDirs[2].path+"existing-topic-0", 0755); err != nil {
+		t.Fatal(err)
+	}
+	setTestDirectory := func(directoryId ktypes.UUID) {
+		metadataMu.Lock()
+		defer metadataMu.Unlock()
+		partition, _ := partitionRecordFor(reassignTestTopicId, 0)
+		partition.Directories = ktypes.CompactArray[ktypes.UUID]{directoryId, DIRECTORY_ID_UNASSIGNED, DIRECTORY_ID_UNASSIGNED}
+	}
+
+	tests := []struct {
+		name      string
+		topic     string
+		directory ktypes.UUID
+		offline   bool
+		want      *LogDirectory
+	}{
+		{"existing folder", "existing-topic", DIRECTORY_ID_UNASSIGNED, false, logDirs[2]},
+		{"metadata log", METADATA_TOPIC, DIRECTORY_ID_UNASSIGNED, false, logDirs[0]},
+		{"least used", "reassign-topic", DIRECTORY_ID_UNASSIGNED, false, logDirs[1]},
+		{"migrating", "reassign-topic", DIRECTORY_ID_MIGRATING, false, logDirs[1]},
+		{"assigned by the controller", "reassign-topic", logDirs[2].id, false, logDirs[2]},
+		{"assigned to an unreadable directory", "reassign-topic", ktypes.UUID{0xdd}, true, logDirs[2]},
+	}
+	for _, test := range tests {
+		setTestDirectory(test.directory)
+		logDirs[2].offline = test.offline
+		forgetPartitionLogDir(test.topic, 0)
+		if got := partitionLogDir(test.topic, 0); got != test.want.path+test.topic+"-0" {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want.path+test.topic+"-0")
+		}
+	}
+	logDirs[2].offline = false
+
+	// Placements hold until forgotten
+	setTestDirectory(logDirs[0].id)
+	if got, ok := placedPartitionLogDir("reassign-topic", 0); !ok || !strings.HasPrefix(got, logDirs[2].path) {
+		t.Errorf("got placement %s, %v, want the last one", got, ok)
+	}
+	forgetPartitionLogDir("reassign-topic", 0)
+	if _, ok := placedPartitionLogDir("reassign-topic", 0); ok {
+		t.Error("got a placement after forgetting it")
+	}
+
+	// AlterReplicaLogDirs places partitions without a folder only
+	preferPartitionLogDir("reassign-topic", 0, logDirs[1])
+	preferPartitionLogDir("existing-topic", 0, logDirs[1])
+	forgetPartitionLogDir("existing-topic", 0)
+	if got := partitionLogDir("reassign-topic", 0); !strings.HasPrefix(got, logDirs[1].path) {
+		t.Errorf("got %s, want the preferred %s", got, logDirs[1].path)
+	}
+	if got := partitionLogDir("existing-topic", 0); !strings.HasPrefix(got, logDirs[2].path) {
+		t.Errorf("got %s, want the existing folder in %s", got, logDirs[2].path)
+	}
+}
+
+func TestMoveReplicaLogDir(t *testing.T) {
+	logDirs := setTestLogDirs(t, 2)
+	dir := logDirs[0].path + "test-topic-0"
+	log, err := openPartitionLog("test-topic", 0, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendTestBatches(t, log, 3)
+	log.setReplicated(true, 2)
+	partitionLogsMu.Lock()
+	partitionLogs[dir] = log
+	partitionLogsMu.Unlock()
+
+	newDir, err := moveReplicaLogDir("test-topic", 0, dir, logDirs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	partitionLogsMu.Lock()
+	moved, ok := partitionLogs[newDir]
+	_, stale := partitionLogs[dir]
+	delete(partitionLogs, newDir)
+	partitionLogsMu.Unlock()
+	if !ok || stale {
+		t.Fatalf("got the moved log open %v, the old one %v, want only the moved one", ok, stale)
+	}
+	defer moved.Close()
+
+	if newDir != logDirs[1].path+"test-topic-0" {
+		t.Errorf("got %s, want %s", newDir, logDirs[1].path+"test-topic-0")
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("got the old folder left, %v", err)
+	}
+	if moved.LogEndOffset() != 3 || moved.HighWatermark() != 2 {
+		t.Errorf("got log end offset %d, high watermark %d, want 3 and 2", moved.LogEndOffset(), moved.HighWatermark())
+	}
+	if got, ok := placedPartitionLogDir("test-topic", 0); !ok || got != newDir {
+		t.Errorf("got placement %s, %v, want %s", got, ok, newDir)
+	}
+}
+
+func TestDirectoryAssignmentQueue(t *testing.T) {
+	setTestLogDirs(t, 1)
+	first, second := ktypes.UUID{0xd1}, ktypes.UUID{0xd2}
+	queueDirectoryAssignment(reassignTestTopicId, 0, first)
+	queueDirectoryAssignment(reassignTestTopicId, 1, first)
+	sent := takeDirectoryAssignments()
+	if len(sent) != 2 {
+		t.Fatalf("got %d assignments to send, want 2", len(sent))
+	}
+	// Partition 1 moved again while the assignment was being sent
+	queueDirectoryAssignment(reassignTestTopicId, 1, second)
+	completeDirectoryAssignments(sent)
+	want := map[TopicIdPartition]ktypes.UUID{{reassignTestTopicId, 1}: second}
+	if got := takeDirectoryAssignments(); len(got) != 1 || got[TopicIdPartition{reassignTestTopicId, 1}] != second {
+		t.Errorf("got pending assignments %v, want %v", got, want)
+	}
+}
+
+func TestAssignReplicasToDirs(t *testing.T) {
+	setTestPartition(t)
+	metadataMu.Lock()
+	brokerRegistrations[2].Epoch = 7
+	metadataMu.Unlock()
+	directory := ktypes.UUID{0xd1}
+	request := func(brokerId int32, epoch int64, topicId ktypes.UUID, partitions ...int32) *AssignReplicasToDirsRequestBody {
+		topic := AssignReplicasToDirsRequestTopic{TopicId: topicId}
+		for _, partition := range partitions {
+			topic.Partitions = append(topic.Partitions, AssignReplicasToDirsRequestPartition{PartitionIndex: ktypes.Int32(partition)})
+		}
+		return &AssignReplicasToDirsRequestBody{
+			BrokerId:    ktypes.Int32(brokerId),
+			BrokerEpoch: ktypes.Int64(epoch),
+			Directories: ktypes.CompactArray[AssignReplicasToDirsRequestDirectory]{{Id: directory, Topics: ktypes.CompactArray[AssignReplicasToDirsRequestTopic]{topic}}},
+		}
+	}
+
+	errorTests := []struct {
+		name    string
+		request *AssignReplicasToDirsRequestBody
+		want    ERROR_CODE
+	}{
+		{"unregistered broker", request(6, 0, reassignTestTopicId, 0), ERROR_CODE_BROKER_ID_NOT_REGISTERED},
+		{"stale broker epoch", request(2, 6, reassignTestTopicId, 0), ERROR_CODE_STALE_BROKER_EPOCH},
+	}
+	for _, test := range errorTests {
+		if _, err := assignReplicasToDirs(test.request); errorCodeFromError(err) != test.want {
+			t.Errorf("%s: got error %v, want %d", test.name, err, test.want)
+		}
+	}
+
+	partitionTests := []struct {
+		name    string
+		request *AssignReplicasToDirsRequestBody
+		want    []ERROR_CODE
+	}{
+		{"unknown topic", request(2, 7, ktypes.UUID{0xee}, 0), []ERROR_CODE{ERROR_CODE_UNKNOWN_TOPIC_ID}},
+		{"assigned and unknown partition", request(2, 7, reassignTestTopicId, 0, 1), []ERROR_CODE{ERROR_CODE_NONE, ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION}},
+		{"assigned again", request(2, 7, reassignTestTopicId, 0), []ERROR_CODE{ERROR_CODE_NONE}},
+		{"not a replica", request(4, 0, reassignTestTopicId, 0), []ERROR_CODE{ERROR_CODE_NOT_LEADER_OR_FOLLOWER}},
+	}
+	for _, test := range partitionTests {
+		results, err := assignReplicasToDirs(test.request)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		got := make([]ERROR_CODE, 0)
+		for _, partition := range results[0].Topics[0].Partitions {
+			got = append(got, partition.ErrorCode)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: got errors %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	partition, _ := partitionRecordFor(reassignTestTopicId, 0)
+	want := []ktypes.UUID{DIRECTORY_ID_UNASSIGNED, directory, DIRECTORY_ID_UNASSIGNED}
+	if !slices.Equal(partition.Directories, want) {
+		t.Errorf("got directories %v, want %v", partition.Directories, want)
+	}
+}
@@ -3,8 +3,6 @@ package main
 import (
 	"fmt"
 	"os"
-	"strconv"
-	"strings"
 
 	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
 )
@@ -38,17 +36,27 @@ type PartitionRecordValue struct {
 	Leader ktypes.Int32 `order:"8"`
 	LeaderEpoch ktypes.Int32 `order:"9"`
 	PartitionEpoch ktypes.Int32 `order:"10"`
-	Directories ktypes.CompactBytes `order:"11"` // implement this later properly
+	// Log directory of each replica, in the order of Replicas
+	Directories ktypes.CompactArray[ktypes.UUID] `order:"11"`
+	// Eligible leader replicas (KIP-966) and last known ELR, which only
+	// partition changes set
+	eligibleLeaderReplicas []int32
+	lastKnownElr           []int32
+}
+
+type RecordHeader struct {
+	Key   ktypes.VarIntString `order:"1"`
+	Value ktypes.VarIntBytes  `order:"2"`
 }
 
 type Record struct {
 	Length         ktypes.VarInt `order:"1"`
 	Attributes     ktypes.Int8 `order:"2"`
-	TimestampDelta ktypes.VarInt `order:"3"`
+	TimestampDelta ktypes.VarLong `order:"3"`
 	OffsetDelta    ktypes.VarInt `order:"4"`
-	Key            ktypes.Bytes `order:"5"`
-	Value          ktypes.Bytes `order:"6"`
-	Headers        ktypes.Bytes `order:"7"` // To be fixed later
+	Key            ktypes.VarIntBytes `order:"5"`
+	Value          ktypes.VarIntBytes `order:"6"`
+	Headers        ktypes.VarIntArray[RecordHeader] `order:"7"`
 }
 
 type RecordBatch struct {
@@ -104,89 +112,108 @@ func readLogFile(filePath string) ([]*RecordBatch, error) {
 	return batches, nil
 }
 
-func prepareLogFileData(fileName string) (error) {
-	batches, err := readLogFile(fileName)
-	if err != nil {
+// applyMetadataRecord updates the in-memory metadata with a single record value.
+func applyMetadataRecord(value []byte) error {
+	valueDecoder := ktypes.NewKDecoder(value)
+	var header RecordValueHeader
+	if err := valueDecoder.Decode(&header); err != nil {
 		return err
 	}
-
-	for _, batch := range batches {
-		for _, record := range batch.Records {
-			value := record.Value
-			
-			// Skip records with no value data
-			if len(value) == 0 {
-				continue
-			}
-			
-			valueDecoder := ktypes.NewKDecoder(value)
-			var header RecordValueHeader
-			if err := valueDecoder.Decode(&header); err != nil {
-				return err
-			}
-			if header.RecordType == ktypes.Int8(12) {
-				// Feature level record
-				var featureLevelRecord FeatureLevelRecordValue
-				featureLevelRecord.Header = header
-				if err := valueDecoder.Decode(&featureLevelRecord); err != nil {
-					return err
-				}
-				featureLevelRecordValues = append(featureLevelRecordValues, featureLevelRecord)
-			} else if header.RecordType == ktypes.Int8(2) {
-				// Topic record
-				var topicRecord TopicRecordValue
-				topicRecord.Header = header
-				if err := valueDecoder.Decode(&topicRecord); err != nil {
-					return err
-				}
-				topicNameToTopicId[string(topicRecord.Name)] = topicRecord.Id
-				topicIdToTopicName[topicRecord.Id] = string(topicRecord.Name)
-				topicIdToTopicRecord[topicRecord.Id] = append(topicIdToTopicRecord[topicRecord.Id], topicRecord)
-			} else if header.RecordType == ktypes.Int8(3) {
-				// Partition record
-				var partitionRecord PartitionRecordValue
-				partitionRecord.Header = header
-				if err := valueDecoder.Decode(&partitionRecord); err != nil {
-					return err
-				}
-				topicIdToPartitions[partitionRecord.TopicId] = append(topicIdToPartitions[partitionRecord.TopicId], partitionRecord)
-				topicIdToPartitionIds[partitionRecord.TopicId] = append(topicIdToPartitionIds[partitionRecord.TopicId], int32(partitionRecord.PartitionId))
-			}
+	// Records are decoded whole, header included
+	valueDecoder.SetPosition(0)
+
+	switch header.RecordType {
+	case FEATURE_LEVEL_RECORD_TYPE:
+		var featureLevelRecord FeatureLevelRecordValue
+		if err := valueDecoder.Decode(&featureLevelRecord); err != nil {
+			return err
 		}
-	}
-
-	return nil
-}
-
-
-func getTopicPartitionRecords(topicId ktypes.UUID, partitionIndex int32) ([]byte, error) {
-	topicName := topicIdToTopicName[topicId]
-	folderPath := LOGS_BASE_FOLDER + topicName + "-" + strconv.Itoa(int(partitionIndex))
-	if _, err := os.Stat(folderPath); os.IsNotExist(err) {
-		return nil, err
-	}
-
-	files, err := os.ReadDir(folderPath)
-	if err != nil {
-		return nil, err
-	}
-	if len(files) == 0 {
-		return []byte{}, nil
-	}
-
-	records := make([]byte, 0)
-	for _, file := range files {
-		if file.IsDir() || !strings.HasSuffix(file.Name(), ".log") {
-			continue
+		featureLevelRecordValues = append(featureLevelRecordValues, featureLevelRecord)
+	case TOPIC_RECORD_TYPE:
+		var topicRecord TopicRecordValue
+		if err := valueDecoder.Decode(&topicRecord); err != nil {
+			return err
 		}
-
-		filePath := folderPath + "/" + file.Name()
-		fileBytes, err := os.ReadFile(filePath)
+		topicNameToTopicId[string(topicRecord.Name)] = topicRecord.Id
+		topicIdToTopicName[topicRecord.Id] = string(topicRecord.Name)
+		topicIdToTopicRecord[topicRecord.Id] = append(topicIdToTopicRecord[topicRecord.Id], topicRecord)
+	case PARTITION_RECORD_TYPE:
+		var partitionRecord PartitionRecordValue
+		if err := valueDecoder.Decode(&partitionRecord); err != nil {
+			return err
+		}
+		topicIdToPartitions[partitionRecord.TopicId] = append(topicIdToPartitions[partitionRecord.TopicId], partitionRecord)
+		topicIdToPartitionIds[partitionRecord.TopicId] = append(topicIdToPartitionIds[partitionRecord.TopicId], int32(partitionRecord.PartitionId))
+		partitionChanged(partitionRecord.TopicId, int32(partitionRecord.PartitionId))
+	case REGISTER_BROKER_RECORD_TYPE:
+		registerBrokerRecord, err := decodeRegisterBrokerRecord(valueDecoder, &header)
 		if err != nil {
-			return nil, err
+			fmt.Println("Ignoring broker registration: ", err.Error())
+			return nil
+		}
+		applyRegisterBrokerRecord(registerBrokerRecord)
+	case BROKER_REGISTRATION_CHANGE_RECORD_TYPE:
+		var brokerRegistrationChangeRecord BrokerRegistrationChangeRecordValue
+		if err := valueDecoder.Decode(&brokerRegistrationChangeRecord); err != nil {
+			return err
+		}
+		if err := applyBrokerRegistrationChangeRecord(&brokerRegistrationChangeRecord); err != nil {
+			return err
+		}
+	case PARTITION_CHANGE_RECORD_TYPE:
+		var partitionChangeRecord PartitionChangeRecordValue
+		if err := valueDecoder.Decode(&partitionChangeRecord); err != nil {
+			return err
+		}
+		if err := applyPartitionChangeRecord(&partitionChangeRecord); err != nil {
+			return err
+		}
+		partitionChanged(partitionChangeRecord.TopicId, int32(partitionChangeRecord.PartitionId))
+	case CONFIG_RECORD_TYPE:
+		var configRecord ConfigRecordValue
+		if err := valueDecoder.Decode(&configRecord); err != nil {
+			return err
+		}
+		applyConfigRecord(&configRecord)
+	case PRODUCER_IDS_RECORD_TYPE:
+		var producerIdsRecord ProducerIdsRecordValue
+		if err := valueDecoder.Decode(&producerIdsRecord); err != nil {
+			return err
 		}
-		records = append(records, fileBytes...)
+		applyProducerIdsRecord(&producerIdsRecord)
+	case USER_SCRAM_CREDENTIAL_RECORD_TYPE:
+		var userScramCredentialRecord UserScramCredentialRecordValue
+		if err := valueDecoder.Decode(&userScramCredentialRecord); err != nil {
+			return err
+		}
+		applyUserScramCredentialRecord(&userScramCredentialRecord)
+	case REMOVE_USER_SCRAM_CREDENTIAL_RECORD_TYPE:
+		var removeUserScramCredentialRecord RemoveUserScramCredentialRecordValue
+		if err := valueDecoder.Decode(&removeUserScramCredentialRecord); err != nil {
+			return err
+		}
+		applyRemoveUserScramCredentialRecord(&removeUserScramCredentialRecord)
+	case ACCESS_CONTROL_ENTRY_RECORD_TYPE:
+		var accessControlEntryRecord AccessControlEntryRecordValue
+		if err := valueDecoder.Decode(&accessControlEntryRecord); err != nil {
+			return err
+		}
+		applyAccessControlEntryRecord(&accessControlEntryRecord)
+	case REMOVE_ACCESS_CONTROL_ENTRY_RECORD_TYPE:
+		var removeAccessControlEntryRecord RemoveAccessControlEntryRecordValue
+		if err := valueDecoder.Decode(&removeAccessControlEntryRecord); err != nil {
+			return err
+		}
+		applyRemoveAccessControlEntryRecord(&removeAccessControlEntryRecord)
+	case CLIENT_QUOTA_RECORD_TYPE:
+		var clientQuotaRecord ClientQuotaRecordValue
+		if err := valueDecoder.Decode(&clientQuotaRecord); err != nil {
+			return err
+		}
+		applyClientQuotaRecord(&clientQuotaRecord)
+	case NO_OP_RECORD_TYPE:
 	}
 
-	return records, nil
-}
\ No newline at end of file
+	return nil
+}
+
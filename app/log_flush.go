@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// topicFlushIntervals returns after how many records and how many
+// milliseconds the topic's logs are written to disk. Metadata records are
+// only applied once durable, so the metadata log is flushed on every append.
+func topicFlushIntervals(topicName string) (int64, int64) {
+	if topicName == METADATA_TOPIC {
+		return 1, int64(brokerConfig.LogFlushIntervalMs)
+	}
+	flushIntervalMessages := int64(brokerConfig.LogFlushIntervalMessages)
+	if n, err := strconv.ParseInt(topicConfig(topicName, "flush.messages", ""), 10, 64); err == nil && n >= 1 {
+		flushIntervalMessages = n
+	}
+	flushIntervalMs := int64(brokerConfig.LogFlushIntervalMs)
+	if n, err := strconv.ParseInt(topicConfig(topicName, "flush.ms", ""), 10, 64); err == nil && n >= 0 {
+		flushIntervalMs = n
+	}
+	return flushIntervalMessages, flushIntervalMs
+}
+
+// syncDir writes a directory entry changes, such as a new segment, to disk.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("unable to open folder: %w", err)
+	}
+	defer file.Close()
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("unable to sync folder: %w", err)
+	}
+	return nil
+}
+
+// LatencyMetric tracks the number, average and maximum of durations measured
+// since it was last reported.
+type LatencyMetric struct {
+	mu      sync.Mutex
+	name    string
+	count   int64
+	totalMs float64
+	maxMs   float64
+}
+
+func (m *LatencyMetric) record(duration time.Duration) {
+	ms := float64(duration) / float64(time.Millisecond)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.count++
+	m.totalMs += ms
+	m.maxMs = math.Max(m.maxMs, ms)
+}
+
+// report prints the metric and starts a new window, nothing is printed when
+// no duration was measured.
+func (m *LatencyMetric) report() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.count == 0 {
+		return
+	}
+	fmt.Printf("%s count=%d avg=%.3fms max=%.3fms\n", m.name, m.count, m.totalMs/float64(m.count), m.maxMs)
+	m.count, m.totalMs, m.maxMs = 0, 0, 0
+}
+
+// Time taken by each sync of a partition log
+var logFlushTimeMs = &LatencyMetric{name: "kafka.log:type=LogFlushStats,name=LogFlushRateAndTimeMs"}
+
+// flushLogs flushes the open logs due under their topic's flush.ms. A log
+// that fails to sync takes its log directory offline.
+func flushLogs(nowMs int64) error {
+	for _, log := range openPartitionLogs() {
+		flushIntervalMessages, flushIntervalMs := topicFlushIntervals(log.topicName)
+		if err := checkStorageError(log, log.flushIfDue(nowMs, flushIntervalMessages, flushIntervalMs)); err != nil {
+			return fmt.Errorf("unable to flush %s: %w", log.dir, err)
+		}
+	}
+	return nil
+}
+
+func startLogFlushTask() {
+	runPeriodicTask(time.Duration(brokerConfig.LogFlushSchedulerIntervalMs)*time.Millisecond, func() {
+		if err := flushLogs(time.Now().UnixMilli()); err != nil {
+			fmt.Println("Error flushing logs: ", err.Error())
+		}
+	})
+}
+
+func startMetricsReporterTask() {
+	runPeriodicTask(METRICS_REPORT_INTERVAL_MS*time.Millisecond, func() {
+		logFlushTimeMs.report()
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTopicFlushIntervals(t *testing.T) {
+	previousConfig := brokerConfig
+	t.Cleanup(func() { brokerConfig = previousConfig })
+	brokerConfig.LogFlushIntervalMessages = 100
+	brokerConfig.LogFlushIntervalMs = 2000
+
+	metadataMu.Lock()
+	topicConfigs["flush-test-topic"] = map[string]string{"flush.messages": "5", "flush.ms": "0"}
+	topicConfigs["flush-invalid-topic"] = map[string]string{"flush.messages": "0", "flush.ms": "soon"}
+	metadataMu.Unlock()
+	t.Cleanup(func() {
+		metadataMu.Lock()
+		defer metadataMu.Unlock()
+		delete(topicConfigs, "flush-test-topic")
+		delete(topicConfigs, "flush-invalid-topic")
+	})
+
+	tests := []struct {
+		topic        string
+		wantMessages int64
+		wantMs       int64
+	}{
+		{"flush-default-topic", 100, 2000},
+		{"flush-test-topic", 5, 0},
+		{"flush-invalid-topic", 100, 2000},
+		{METADATA_TOPIC, 1, 2000},
+	}
+	for _, test := range tests {
+		messages, ms := topicFlushIntervals(test.topic)
+		if messages != test.wantMessages || ms != test.wantMs {
+			t.Errorf("%s: got %d messages, %dms, want %d, %dms", test.topic, messages, ms, test.wantMessages, test.wantMs)
+		}
+	}
+}
+
+func TestFlushAfterIntervalMessages(t *testing.T) {
+	previousConfig := brokerConfig
+	t.Cleanup(func() { brokerConfig = previousConfig })
+	brokerConfig.LogFlushIntervalMessages = 3
+	brokerConfig.LogFlushIntervalMs = math.MaxInt32
+
+	log := openTestPartitionLog(t, 1<<20)
+	appendTestBatches(t, log, 2)
+	if recoveryPoint := log.RecoveryPoint(); recoveryPoint != 0 {
+		t.Errorf("got recovery point %d after 2 records, want 0", recoveryPoint)
+	}
+	appendTestBatches(t, log, 1)
+	if recoveryPoint := log.RecoveryPoint(); recoveryPoint != 3 {
+		t.Errorf("got recovery point %d after 3 records, want 3", recoveryPoint)
+	}
+}
+
+func TestFlushIfDue(t *testing.T) {
+	log := openTestPartitionLog(t, 1<<20)
+	nowMs := time.Now().UnixMilli()
+	if err := log.flushIfDue(nowMs, math.MaxInt64, 1000); err != nil {
+		t.Fatal(err)
+	}
+	appendTestBatches(t, log, 2)
+
+	tests := []struct {
+		name              string
+		nowMs             int64
+		intervalMessages  int64
+		wantRecoveryPoint int64
+	}{
+		{"before flush.ms", nowMs, math.MaxInt64, 0},
+		{"flush.messages reached", nowMs, 2, 2},
+	}
+	for _, test := range tests {
+		if err := log.flushIfDue(test.nowMs, test.intervalMessages, 1000); err != nil {
+			t.Fatal(err)
+		}
+		if recoveryPoint := log.RecoveryPoint(); recoveryPoint != test.wantRecoveryPoint {
+			t.Errorf("%s: got recovery point %d, want %d", test.name, recoveryPoint, test.wantRecoveryPoint)
+		}
+	}
+
+	appendTestBatches(t, log, 1)
+	if err := log.flushIfDue(time.Now().UnixMilli(), math.MaxInt64, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if recoveryPoint := log.RecoveryPoint(); recoveryPoint != 2 {
+		t.Errorf("got recovery point %d right after a flush, want 2", recoveryPoint)
+	}
+	if err := log.flushIfDue(time.Now().UnixMilli()+2000, math.MaxInt64, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if recoveryPoint := log.RecoveryPoint(); recoveryPoint != 3 {
+		t.Errorf("got recovery point %d past flush.ms, want 3", recoveryPoint)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RECOVERY_POINT_CHECKPOINT_FILE = "recovery-point-offset-checkpoint"
+	OFFSET_CHECKPOINT_VERSION      = 0
+)
+
+// Offsets below which each log was flushed before the last run stopped, by
+// partition folder. Loaded once at startup, before any log is opened.
+var recoveryPoints = make(map[string]int64)
+
+// readOffsetCheckpoint reads a checkpoint file of a log directory, made of a
+// version line, an entry count line and one "topic partition offset" line
+// per partition. Offsets are returned by partition folder.
+func readOffsetCheckpoint(logDir string, name string) (map[string]int64, error) {
+	path := logDir + name
+	offsets := make(map[string]int64)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return offsets, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read checkpoint %s: %w", path, err)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) < 2 || lines[0] != strconv.Itoa(OFFSET_CHECKPOINT_VERSION) {
+		return nil, fmt.Errorf("malformed checkpoint %s", path)
+	}
+	count, err := strconv.Atoi(lines[1])
+	if err != nil || count != len(lines)-2 {
+		return nil, fmt.Errorf("malformed checkpoint %s: unexpected entry count", path)
+	}
+	for _, line := range lines[2:] {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("malformed checkpoint %s: %q", path, line)
+		}
+		partition, err := strconv.ParseInt(fields[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("malformed checkpoint %s: %q", path, line)
+		}
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed checkpoint %s: %q", path, line)
+		}
+		offsets[logDir+partitionFolderName(fields[0], int32(partition))] = offset
+	}
+	return offsets, nil
+}
+
+// writeOffsetCheckpoint replaces a checkpoint file atomically.
+func writeOffsetCheckpoint(path string, logs []*PartitionLog, offsets []int64) error {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%d\n%d\n", OFFSET_CHECKPOINT_VERSION, len(logs))
+	for i, log := range logs {
+		fmt.Fprintf(&builder, "%s %d %d\n", log.topicName, log.partition, offsets[i])
+	}
+	return writeCheckpointFile(path, builder.String())
+}
+
+// writeCheckpointFile replaces a file with data atomically.
+func writeCheckpointFile(path string, data string) error {
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// loadRecoveryPoints reads the recovery point checkpoint. An unreadable
+// checkpoint only means every log is recovered from its start.
+func loadRecoveryPoints() {
+	offsets, err := readOffsetCheckpoints(RECOVERY_POINT_CHECKPOINT_FILE)
+	if err != nil {
+		fmt.Println("Ignoring recovery point checkpoint: ", err.Error())
+		return
+	}
+	recoveryPoints = offsets
+}
+
+// checkpointRecoveryPoints writes the recovery point of every open log.
+func checkpointRecoveryPoints() error {
+	logs := openPartitionLogs()
+	offsets := make([]int64, len(logs))
+	for i, log := range logs {
+		offsets[i] = log.RecoveryPoint()
+	}
+	return writeOffsetCheckpoints(RECOVERY_POINT_CHECKPOINT_FILE, logs, offsets)
+}
+
+func startRecoveryPointCheckpointTask() {
+	runPeriodicTask(LOG_FLUSH_OFFSET_CHECKPOINT_INTERVAL_MS*time.Millisecond, func() {
+		if err := checkpointRecoveryPoints(); err != nil {
+			fmt.Println("Error writing recovery point checkpoint: ", err.Error())
+		}
+	})
+}
+
+// segmentBaseOffset returns the base offset a segment file is named after.
+func segmentBaseOffset(segment string) (int64, error) {
+	name := filepath.Base(segment)
+	return strconv.ParseInt(strings.TrimSuffix(name, filepath.Ext(name)), 10, 64)
+}
+
+// validSegmentLength returns the length of the segment data made of valid
+// batches: complete, with a matching CRC and offsets increasing from
+// nextOffset. It also returns the offset following the last valid batch.
+func validSegmentLength(data []byte, nextOffset int64) (int, int64, error) {
+	position := 0
+	for position < len(data) {
+		header, err := decodeRecordBatchHeader(data[position:])
+		if err != nil {
+			return position, nextOffset, err
+		}
+		size := RECORD_BATCH_LOG_OVERHEAD + int(header.BatchLength)
+		if header.BatchLength < RECORD_BATCH_HEADER_SIZE-RECORD_BATCH_LOG_OVERHEAD || position+size > len(data) {
+			return position, nextOffset, fmt.Errorf("invalid record batch length %d", header.BatchLength)
+		}
+		batch := RawRecordBatch{Header: *header, Data: data[position : position+size], Position: int64(position)}
+		if err := validateRecordBatch(&batch); err != nil {
+			return position, nextOffset, err
+		}
+		if int64(header.BaseOffset) < nextOffset || header.LastOffsetDelta < 0 {
+			return position, nextOffset, fmt.Errorf("record batch offset %d is not past %d", header.BaseOffset, nextOffset)
+		}
+		nextOffset = header.lastOffset() + 1
+		position += size
+	}
+	return position, nextOffset, nil
+}
+
+// recoverSegments validates the segments holding offsets past the recovery
+// point and truncates the log at the first invalid batch, dropping the
+// segments after it. Transaction indexes are rebuilt to only reference the
+// offsets kept. It returns the segments left.
+func (l *PartitionLog) recoverSegments(segments []string, recoveryPoint int64) ([]string, error) {
+	for i, segment := range segments {
+		baseOffset, err := segmentBaseOffset(segment)
+		if err != nil {
+			return nil, fmt.Errorf("invalid segment name %s: %w", segment, err)
+		}
+		// Segments followed by one starting at or below the recovery point were flushed
+		if i+1 < len(segments) {
+			nextBaseOffset, err := segmentBaseOffset(segments[i+1])
+			if err == nil && nextBaseOffset <= recoveryPoint {
+				continue
+			}
+		}
+
+		data, err := os.ReadFile(segment)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read segment: %w", err)
+		}
+		validLength, nextOffset, invalidErr := validSegmentLength(data, baseOffset)
+		if err := rebuildTransactionIndex(transactionIndexPath(segment), nextOffset); err != nil {
+			return nil, err
+		}
+		if invalidErr == nil {
+			continue
+		}
+
+		fmt.Println("Truncating ", segment, " at position ", validLength, " after unclean shutdown: ", invalidErr.Error())
+		if err := os.Truncate(segment, int64(validLength)); err != nil {
+			return nil, fmt.Errorf("unable to truncate segment: %w", err)
+		}
+		for _, dropped := range segments[i+1:] {
+			if err := os.Remove(dropped); err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("unable to delete segment: %w", err)
+			}
+			if err := os.Remove(transactionIndexPath(dropped)); err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("unable to delete transaction index: %w", err)
+			}
+		}
+		if err := l.producerState.deleteSnapshotsAfter(nextOffset); err != nil {
+			return nil, err
+		}
+		return segments[:i+1], nil
+	}
+	return segments, nil
+}
+
+// rebuildTransactionIndex rewrites a segment's index without torn entries
+// and without transactions aborted at or past endOffset.
+func rebuildTransactionIndex(path string, endOffset int64) error {
+	abortedTxns, err := readTransactionIndex(path)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to delete transaction index: %w", err)
+	}
+	kept := 0
+	for _, abortedTxn := range abortedTxns {
+		if abortedTxn.LastOffset >= endOffset {
+			continue
+		}
+		if err := appendTransactionIndex(tmpPath, abortedTxn); err != nil {
+			return err
+		}
+		kept++
+	}
+	if kept == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to delete transaction index: %w", err)
+		}
+		return nil
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// encodeTestBatch encodes a batch of records starting at baseOffset
+func encodeTestBatch(t *testing.T, baseOffset int64, records int) []byte {
+	t.Helper()
+	batch := newRecordBatch(baseOffset, 0, make([]Record, records))
+	data, err := encodeRecordBatch(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestValidSegmentLength(t *testing.T) {
+	first := encodeTestBatch(t, 0, 2)
+	second := encodeTestBatch(t, 2, 1)
+	corrupted := append([]byte(nil), second...)
+	corrupted[len(corrupted)-1] ^= 0xff
+
+	tests := []struct {
+		name       string
+		data       []byte
+		wantLength int
+		wantNext   int64
+		wantErr    bool
+	}{
+		{"valid", append(append([]byte(nil), first...), second...), len(first) + len(second), 3, false},
+		{"empty", nil, 0, 0, false},
+		{"torn batch", append(append([]byte(nil), first...), second[:len(second)-5]...), len(first), 2, true},
+		{"torn header", append(append([]byte(nil), first...), second[:10]...), len(first), 2, true},
+		{"bad CRC", append(append([]byte(nil), first...), corrupted...), len(first), 2, true},
+		{"offsets going back", append(append([]byte(nil), first...), first...), len(first), 2, true},
+		{"zeroed tail", append(append([]byte(nil), first...), make([]byte, 100)...), len(first), 2, true},
+	}
+	for _, test := range tests {
+		length, next, err := validSegmentLength(test.data, 0)
+		if length != test.wantLength || next != test.wantNext || (err != nil) != test.wantErr {
+			t.Errorf("%s: got %d, %d, %v, want %d, %d, error %v", test.name, length, next, err, test.wantLength, test.wantNext, test.wantErr)
+		}
+	}
+}
+
+func TestRecoverTruncatesTornTail(t *testing.T) {
+	log := openTestPartitionLog(t, 1<<20)
+	appendTestBatches(t, log, 3)
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+	segments, err := log.segmentFiles()
+	if err != nil || len(segments) != 1 {
+		t.Fatalf("got segments %v, %v, want one", segments, err)
+	}
+	info, err := os.Stat(segments[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	validSize := info.Size()
+
+	// A crash in the middle of an append leaves part of a batch
+	torn := encodeTestBatch(t, 3, 1)
+	file, err := os.OpenFile(segments[0], os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(torn[:len(torn)/2])
+	file.Close()
+
+	// No clean shutdown marker was read, so the log is recovered
+	recovered, err := openPartitionLog(log.topicName, log.partition, log.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+	if end := recovered.LogEndOffset(); end != 3 {
+		t.Errorf("got log end offset %d, want 3", end)
+	}
+	if info, err := os.Stat(segments[0]); err != nil {
+		t.Error(err)
+	} else if info.Size() != validSize {
+		t.Errorf("got segment size %d, want %d", info.Size(), validSize)
+	}
+	appendTestBatches(t, recovered, 1)
+	records, _, err := recovered.ReadRecords(0, recovered.LogEndOffset(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offsets := batchOffsets(t, records); len(offsets) != 4 || offsets[3] != 3 {
+		t.Errorf("got batches at %v, want 0 to 3", offsets)
+	}
+}
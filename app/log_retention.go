@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const LOG_START_OFFSET_CHECKPOINT_FILE = "log-start-offset-checkpoint"
+
+// Log start offsets checkpointed by the last run, by partition folder.
+// Loaded once at startup, before any log is opened.
+var logStartOffsets = make(map[string]int64)
+
+// loadLogStartOffsets reads the log start offset checkpoint. Without it logs
+// start at their first segment.
+func loadLogStartOffsets() {
+	offsets, err := readOffsetCheckpoints(LOG_START_OFFSET_CHECKPOINT_FILE)
+	if err != nil {
+		fmt.Println("Ignoring log start offset checkpoint: ", err.Error())
+		return
+	}
+	logStartOffsets = offsets
+}
+
+// checkpointLogStartOffsets writes the log start offset of every open log.
+func checkpointLogStartOffsets() error {
+	logs := openPartitionLogs()
+	offsets := make([]int64, len(logs))
+	for i, log := range logs {
+		offsets[i] = log.LogStartOffset()
+	}
+	return writeOffsetCheckpoints(LOG_START_OFFSET_CHECKPOINT_FILE, logs, offsets)
+}
+
+// segmentLargestTimestamp returns the largest batch timestamp of a segment,
+// its modification time when no batch has a timestamp.
+func segmentLargestTimestamp(segment string) (int64, error) {
+	batches, err := readSegmentBatches(segment)
+	if err != nil {
+		return 0, err
+	}
+	largest := int64(-1)
+	for _, batch := range batches {
+		largest = max(largest, int64(batch.Header.MaxTimestamp))
+	}
+	if largest > 0 {
+		return largest, nil
+	}
+	info, err := os.Stat(segment)
+	if err != nil {
+		return 0, err
+	}
+	return info.ModTime().UnixMilli(), nil
+}
+
+// topicRetention returns how long and up to what size the topic keeps its
+// records, from retention.ms and retention.bytes or the broker's
+// log.retention.ms and log.retention.bytes, -1 for no limit.
+func topicRetention(topicName string) (int64, int64) {
+	retentionMs := int64(brokerConfig.LogRetentionMs)
+	if n, err := strconv.ParseInt(topicConfig(topicName, "retention.ms", ""), 10, 64); err == nil && n >= -1 {
+		retentionMs = n
+	}
+	retentionBytes := int64(brokerConfig.LogRetentionBytes)
+	if n, err := strconv.ParseInt(topicConfig(topicName, "retention.bytes", ""), 10, 64); err == nil && n >= -1 {
+		retentionBytes = n
+	}
+	return retentionMs, retentionBytes
+}
+
+// deleteOldSegments deletes, oldest first, the segments older than the
+// topic's retention.ms, those beyond retention.bytes and those wholly below
+// the log start offset, which then moves up to the first segment kept.
+// Segments are only deleted for their age or size once wholly below the
+// high watermark. The active segment is rolled first when it expired.
+// Topics with remote storage only delete the segments copied to it, after
+// local.retention.ms and local.retention.bytes, and keep the remote log
+// start offset. It returns the number of segments deleted.
+func (l *PartitionLog) deleteOldSegments(nowMs int64) (int, error) {
+	remote := topicRemoteStorageEnabled(l.topicName)
+	retentionMs, retentionBytes := topicRetention(l.topicName)
+	if remote {
+		retentionMs, retentionBytes = topicLocalRetention(l.topicName)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	segments, err := l.segmentFiles()
+	if err != nil {
+		return 0, err
+	}
+	sizes := make([]int64, len(segments))
+	totalSize := int64(0)
+	for i, segment := range segments {
+		info, err := os.Stat(segment)
+		if err != nil {
+			return 0, err
+		}
+		sizes[i] = info.Size()
+		totalSize += sizes[i]
+	}
+
+	remoteLogEndOffset := l.remoteLogEndOffset()
+	deleted := 0
+	for i, segment := range segments {
+		active := i == len(segments)-1
+		if active && l.activeSize == 0 {
+			break
+		}
+		nextBaseOffset := l.logEndOffset
+		if !active {
+			if nextBaseOffset, err = segmentBaseOffset(segments[i+1]); err != nil {
+				return deleted, err
+			}
+		}
+
+		belowStart := nextBaseOffset <= l.logStartOffset
+		// Age and size only delete records every in-sync replica has
+		if !belowStart && nextBaseOffset > l.highWatermark {
+			break
+		}
+		expired := false
+		if retentionMs >= 0 {
+			largestTimestamp, err := segmentLargestTimestamp(segment)
+			if err != nil {
+				return deleted, err
+			}
+			expired = nowMs-largestTimestamp > retentionMs
+		}
+		// The active segment is never deleted for size alone
+		oversized := !active && retentionBytes >= 0 && totalSize-sizes[i] >= retentionBytes
+		if !belowStart && !expired && !oversized {
+			break
+		}
+		// Records not in remote storage yet are kept, an expired active
+		// segment is only rolled so it can be copied
+		if remote && !belowStart && (active || nextBaseOffset > remoteLogEndOffset+1) {
+			if active {
+				if err := l.roll(); err != nil {
+					return deleted, err
+				}
+			}
+			break
+		}
+
+		if active {
+			if err := l.roll(); err != nil {
+				return deleted, err
+			}
+		}
+		if err := os.Remove(segment); err != nil {
+			return deleted, fmt.Errorf("unable to delete segment: %w", err)
+		}
+		if err := os.Remove(transactionIndexPath(segment)); err != nil && !os.IsNotExist(err) {
+			return deleted, fmt.Errorf("unable to delete transaction index: %w", err)
+		}
+		fmt.Println("Deleted segment ", segment)
+		totalSize -= sizes[i]
+		deleted++
+		if !remote {
+			l.logStartOffset = max(l.logStartOffset, nextBaseOffset)
+		}
+	}
+
+	if deleted > 0 {
+		if err := l.epochCache.truncateFromStart(l.logStartOffset); err != nil {
+			return deleted, err
+		}
+		abortedTxns := make([]AbortedTxn, 0, len(l.abortedTxns))
+		for _, abortedTxn := range l.abortedTxns {
+			if abortedTxn.LastOffset >= l.logStartOffset {
+				abortedTxns = append(abortedTxns, abortedTxn)
+			}
+		}
+		l.abortedTxns = abortedTxns
+	}
+	return deleted, nil
+}
+
+// DeleteRecordsBefore moves the log start offset up to offset, -1 standing
+// for the high watermark. Segments left wholly below it are deleted by the
+// next retention run. It returns the new log start offset.
+func (l *PartitionLog) DeleteRecordsBefore(offset int64) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if offset == -1 {
+		offset = l.highWatermark
+	}
+	if offset < 0 || offset > l.highWatermark {
+		return l.logStartOffset, newKafkaError(ERROR_CODE_OFFSET_OUT_OF_RANGE, "offset %d is past the high watermark %d", offset, l.highWatermark)
+	}
+	l.logStartOffset = max(l.logStartOffset, offset)
+	return l.logStartOffset, l.epochCache.truncateFromStart(l.logStartOffset)
+}
+
+// partitionLogsWithPolicy returns the logs of every partition whose topic
+// has the cleanup policy: the partitions of user topics, along with the
+// partitions of internal topics opened so far.
+func partitionLogsWithPolicy(policy string) ([]*PartitionLog, error) {
+	metadataMu.Lock()
+	type topicPartition struct {
+		topicName string
+		partition int32
+	}
+	partitions := make([]topicPartition, 0)
+	for topicId, partitionIds := range topicIdToPartitionIds {
+		topicName := topicIdToTopicName[topicId]
+		for _, partitionId := range partitionIds {
+			partitions = append(partitions, topicPartition{topicName, partitionId})
+		}
+	}
+	metadataMu.Unlock()
+
+	logs := make([]*PartitionLog, 0)
+	seen := make(map[string]bool)
+	for _, tp := range partitions {
+		if !hasCleanupPolicy(tp.topicName, policy) {
+			continue
+		}
+		log, err := getPartitionLog(tp.topicName, tp.partition)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+		seen[log.dir] = true
+	}
+	for _, log := range openPartitionLogs() {
+		if !seen[log.dir] && hasCleanupPolicy(log.topicName, policy) {
+			logs = append(logs, log)
+		}
+	}
+	return logs, nil
+}
+
+// cleanupLogs applies retention to the partitions of every topic with the
+// delete policy. Internal topics are compacted instead, as they are replayed
+// from their first offset on startup.
+func cleanupLogs(nowMs int64) error {
+	logs, err := partitionLogsWithPolicy(CLEANUP_POLICY_DELETE)
+	if err != nil {
+		return err
+	}
+
+	deleted := 0
+	for _, log := range logs {
+		n, err := log.deleteOldSegments(nowMs)
+		deleted += n
+		if err != nil {
+			return err
+		}
+	}
+	if deleted > 0 {
+		return checkpointLogStartOffsets()
+	}
+	return nil
+}
+
+func startLogRetentionTask() {
+	runPeriodicTask(time.Duration(brokerConfig.LogRetentionCheckIntervalMs)*time.Millisecond, func() {
+		if err := cleanupLogs(time.Now().UnixMilli()); err != nil {
+			fmt.Println("Error applying log retention: ", err.Error())
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDeleteOldSegmentsByAge(t *testing.T) {
+	tests := []struct {
+		name            string
+		highWatermark   int64
+		wantDeleted     int
+		wantStartOffset int64
+	}{
+		{"not replicated", -1, 5, 5},
+		{"below the high watermark", 2, 2, 2},
+		{"nothing replicated", 0, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Every batch gets a segment of its own
+			log := openTestPartitionLog(t, 1)
+			brokerConfig.LogRetentionMs = 0
+			brokerConfig.LogRetentionBytes = -1
+			appendTestBatches(t, log, 5)
+			if test.highWatermark >= 0 {
+				log.setReplicated(true, test.highWatermark)
+			}
+
+			deleted, err := log.deleteOldSegments(time.Now().UnixMilli() + 1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != test.wantDeleted {
+				t.Errorf("deleted %d segments, want %d", deleted, test.wantDeleted)
+			}
+			if startOffset := log.LogStartOffset(); startOffset != test.wantStartOffset {
+				t.Errorf("got log start offset %d, want %d", startOffset, test.wantStartOffset)
+			}
+		})
+	}
+}
+
+func TestDeleteOldSegmentsBySize(t *testing.T) {
+	log := openTestPartitionLog(t, 1)
+	appendTestBatches(t, log, 5)
+	segments, err := log.segmentFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	segmentSize := log.activeSize
+	// Two segments are kept, the high watermark holds back the third
+	brokerConfig.LogRetentionMs = -1
+	brokerConfig.LogRetentionBytes = int(2 * segmentSize)
+	log.setReplicated(true, 1)
+
+	deleted, err := log.deleteOldSegments(time.Now().UnixMilli())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 || log.LogStartOffset() != 1 {
+		t.Errorf("deleted %d of %d segments up to %d, want 1 up to 1", deleted, len(segments), log.LogStartOffset())
+	}
+
+	log.setReplicated(false, 0)
+	deleted, err = log.deleteOldSegments(time.Now().UnixMilli())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 || log.LogStartOffset() != 3 {
+		t.Errorf("deleted %d more segments up to %d, want 2 up to 3", deleted, log.LogStartOffset())
+	}
+}
+
+func TestDeleteOldSegmentsTopicRetention(t *testing.T) {
+	// Test batches are all the same size
+	sizing := openTestPartitionLog(t, 1)
+	appendTestBatches(t, sizing, 1)
+	twoSegments := int(2 * sizing.activeSize)
+
+	tests := []struct {
+		name        string
+		brokerMs    int
+		brokerBytes int
+		topicConfig map[string]string
+		wantDeleted int
+	}{
+		{"stricter retention.ms", -1, -1, map[string]string{"retention.ms": "0"}, 5},
+		{"looser retention.ms", 0, -1, map[string]string{"retention.ms": "-1"}, 0},
+		{"stricter retention.bytes", -1, -1, map[string]string{"retention.bytes": strconv.Itoa(twoSegments)}, 3},
+		{"looser retention.bytes", -1, twoSegments, map[string]string{"retention.bytes": "-1"}, 0},
+		{"invalid retention.ms", 0, -1, map[string]string{"retention.ms": "soon"}, 5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := openTestPartitionLog(t, 1)
+			brokerConfig.LogRetentionMs = test.brokerMs
+			brokerConfig.LogRetentionBytes = test.brokerBytes
+			metadataMu.Lock()
+			topicConfigs[log.topicName] = test.topicConfig
+			metadataMu.Unlock()
+			t.Cleanup(func() {
+				metadataMu.Lock()
+				defer metadataMu.Unlock()
+				delete(topicConfigs, log.topicName)
+			})
+			appendTestBatches(t, log, 5)
+
+			deleted, err := log.deleteOldSegments(time.Now().UnixMilli() + 1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != test.wantDeleted {
+				t.Errorf("deleted %d segments, want %d", deleted, test.wantDeleted)
+			}
+		})
+	}
+}
@@ -2,77 +2,212 @@ package main
 
 import (
 	"fmt"
-	"net"
 	"os"
+	"time"
 )
 
-func handleConnection(conn net.Conn) {
-	defer conn.Close()
-	fmt.Println("Connection accepted")
+// handleRequest runs the handler of the request's API, on the controller
+// for the requests writing metadata, returning noResponse when there is no
+// response to send and nil when the request failed.
+func handleRequest(req *Request) *Response {
+	if isForwardedRequest(req.RequestApiKey) && !raftClient.isLeader() {
+		return forwardToController(req)
+	}
 
-	for {
-		reqData := make([]byte, 1024)
-		n, err := conn.Read(reqData)
-		if err != nil {
-			fmt.Println("Error reading data: ", err.Error())
-			return
-		}
-		if n == 0 {
-			fmt.Println("Connection closed by client")
-			return
-		}
-		reqData = reqData[:n]
-		req, err := parseRequest(reqData)
-		if err != nil {
-			fmt.Println("Error parsing request: ", err.Error())
-			return
-		}
+	req.QuotaExempt = isQuotaExempt(req)
+	if !req.QuotaExempt {
+		// Time spent on earlier requests may already call for throttling
+		req.ThrottleTimeMs = recordRequestTime(req, 0)
+	}
+	// Only the handler's processing time is charged: requests parked in the
+	// purgatory or on the controller return right away
+	handleStart := time.Now()
 
-		var res *Response = nil
-		switch req.RequestApiKey {
-		case API_VERSIONS_REQUEST_KEY:
-			res = handleApiVersionsRequest(req)
-		case DESCRIBE_TOPIC_PARTITIONS_REQUEST_KEY:
-			res = handleDescribeTopicPartitionsRequest(req)
-		case FETCH_REQUEST_KEY:
-			res = handleFetchRequest(req)
-		default:
-			fmt.Println("Unknown API key: ", req.RequestApiKey)
-			return
-		}
+	var res *Response
+	if isControllerRequest(req.RequestApiKey) {
+		res = handleControllerRequest(req)
+	} else {
+		res = callHandler(req)
+	}
+	if !req.QuotaExempt {
+		recordRequestTime(req, time.Since(handleStart))
+	}
+	return res
+}
 
-		result := encodeResponse(res)
+// callHandler runs the handler of the request's API.
+func callHandler(req *Request) *Response {
+	var res *Response = nil
+	switch req.RequestApiKey {
+	case API_VERSIONS_REQUEST_KEY:
+		res = handleApiVersionsRequest(req)
+	case METADATA_REQUEST_KEY:
+		res = handleMetadataRequest(req)
+	case LIST_OFFSETS_REQUEST_KEY:
+		res = handleListOffsetsRequest(req)
+	case DELETE_RECORDS_REQUEST_KEY:
+		res = handleDeleteRecordsRequest(req)
+	case DESCRIBE_ACLS_REQUEST_KEY:
+		res = handleDescribeAclsRequest(req)
+	case CREATE_ACLS_REQUEST_KEY:
+		res = handleCreateAclsRequest(req)
+	case DELETE_ACLS_REQUEST_KEY:
+		res = handleDeleteAclsRequest(req)
+	case DESCRIBE_CLIENT_QUOTAS_REQUEST_KEY:
+		res = handleDescribeClientQuotasRequest(req)
+	case ALTER_CLIENT_QUOTAS_REQUEST_KEY:
+		res = handleAlterClientQuotasRequest(req)
+	case DESCRIBE_TOPIC_PARTITIONS_REQUEST_KEY:
+		res = handleDescribeTopicPartitionsRequest(req)
+	case FETCH_REQUEST_KEY:
+		res = handleFetchRequest(req)
+	case OFFSET_COMMIT_REQUEST_KEY:
+		res = handleOffsetCommitRequest(req)
+	case OFFSET_FETCH_REQUEST_KEY:
+		res = handleOffsetFetchRequest(req)
+	case CONSUMER_GROUP_HEARTBEAT_REQUEST_KEY:
+		res = handleConsumerGroupHeartbeatRequest(req)
+	case CONSUMER_GROUP_DESCRIBE_REQUEST_KEY:
+		res = handleConsumerGroupDescribeRequest(req)
+	case PRODUCE_REQUEST_KEY:
+		res = handleProduceRequest(req)
+	case INIT_PRODUCER_ID_REQUEST_KEY:
+		res = handleInitProducerIdRequest(req)
+	case ADD_PARTITIONS_TO_TXN_REQUEST_KEY:
+		res = handleAddPartitionsToTxnRequest(req)
+	case ADD_OFFSETS_TO_TXN_REQUEST_KEY:
+		res = handleAddOffsetsToTxnRequest(req)
+	case END_TXN_REQUEST_KEY:
+		res = handleEndTxnRequest(req)
+	case TXN_OFFSET_COMMIT_REQUEST_KEY:
+		res = handleTxnOffsetCommitRequest(req)
+	case SASL_HANDSHAKE_REQUEST_KEY:
+		res = handleSaslHandshakeRequest(req)
+	case SASL_AUTHENTICATE_REQUEST_KEY:
+		res = handleSaslAuthenticateRequest(req)
+	case DESCRIBE_USER_SCRAM_CREDENTIALS_REQUEST_KEY:
+		res = handleDescribeUserScramCredentialsRequest(req)
+	case ALTER_USER_SCRAM_CREDENTIALS_REQUEST_KEY:
+		res = handleAlterUserScramCredentialsRequest(req)
+	case OFFSET_FOR_LEADER_EPOCH_REQUEST_KEY:
+		res = handleOffsetForLeaderEpochRequest(req)
+	case VOTE_REQUEST_KEY:
+		res = handleVoteRequest(req)
+	case BEGIN_QUORUM_EPOCH_REQUEST_KEY:
+		res = handleBeginQuorumEpochRequest(req)
+	case END_QUORUM_EPOCH_REQUEST_KEY:
+		res = handleEndQuorumEpochRequest(req)
+	case ALTER_PARTITION_REQUEST_KEY:
+		res = handleAlterPartitionRequest(req)
+	case ENVELOPE_REQUEST_KEY:
+		res = handleEnvelopeRequest(req)
+	case FETCH_SNAPSHOT_REQUEST_KEY:
+		res = handleFetchSnapshotRequest(req)
+	case ALLOCATE_PRODUCER_IDS_REQUEST_KEY:
+		res = handleAllocateProducerIdsRequest(req)
+	case BROKER_REGISTRATION_REQUEST_KEY:
+		res = handleBrokerRegistrationRequest(req)
+	case BROKER_HEARTBEAT_REQUEST_KEY:
+		res = handleBrokerHeartbeatRequest(req)
+	case ALTER_PARTITION_REASSIGNMENTS_REQUEST_KEY:
+		res = handleAlterPartitionReassignmentsRequest(req)
+	case LIST_PARTITION_REASSIGNMENTS_REQUEST_KEY:
+		res = handleListPartitionReassignmentsRequest(req)
+	case ELECT_LEADERS_REQUEST_KEY:
+		res = handleElectLeadersRequest(req)
+	case ALTER_REPLICA_LOG_DIRS_REQUEST_KEY:
+		res = handleAlterReplicaLogDirsRequest(req)
+	case DESCRIBE_LOG_DIRS_REQUEST_KEY:
+		res = handleDescribeLogDirsRequest(req)
+	case ASSIGN_REPLICAS_TO_DIRS_REQUEST_KEY:
+		res = handleAssignReplicasToDirsRequest(req)
+	default:
+		fmt.Println("Unknown API key: ", req.RequestApiKey)
+		req.Session.closeConnection = true
+		return nil
+	}
+	return res
+}
 
-		// Send the response
-		_, err = conn.Write(result)
-		if err != nil {
-			fmt.Println("Error sending response: ", err.Error())
+func main() {
+	// The broker is started with the path of its server.properties file
+	if len(os.Args) > 1 {
+		if err := loadBrokerConfig(os.Args[1]); err != nil {
+			fmt.Println("Error loading broker config: ", err.Error())
 			os.Exit(1)
 		}
 	}
-}
 
-func main() {
-	l, err := net.Listen("tcp", "0.0.0.0:9092")
+	err := loadLogDirs()
+	if err != nil {
+		fmt.Println("Error loading log directories: ", err.Error())
+		os.Exit(1)
+	}
+	err = readCleanShutdownMarker()
 	if err != nil {
-		fmt.Println("Failed to bind to port 9092")
+		fmt.Println("Error reading clean shutdown marker: ", err.Error())
 		os.Exit(1)
 	}
 
-	err = prepareLogFileData(TEST_LOG_FILE)
+	loadRecoveryPoints()
+	loadLogStartOffsets()
+	loadCleanerOffsets()
+	loadHighWatermarks()
+
+	err = initRaftClient()
 	if err != nil {
-		fmt.Println("Error preparing log file data: ", err.Error())
+		fmt.Println("Error starting metadata quorum: ", err.Error())
+		os.Exit(1)
+	}
+	err = loadClusterMetadata()
+	if err != nil {
+		fmt.Println("Error loading cluster metadata: ", err.Error())
 		os.Exit(1)
 	}
-	
 
-	for {
-		fmt.Println("Waiting for connection...")
-		conn, err := l.Accept()
-		if err != nil {
-			fmt.Println("Error accepting connection: ", err.Error())
-			os.Exit(1)
-		}
-		go handleConnection(conn)
+	err = startReplication()
+	if err != nil {
+		fmt.Println("Error starting replication: ", err.Error())
+		os.Exit(1)
+	}
+
+	err = loadConsumerOffsets()
+	if err != nil {
+		fmt.Println("Error loading consumer offsets: ", err.Error())
+		os.Exit(1)
+	}
+	err = loadTransactionState()
+	if err != nil {
+		fmt.Println("Error loading transaction state: ", err.Error())
+		os.Exit(1)
+	}
+	startOffsetsRetentionTask()
+	startConsumerGroupSessionTask()
+	startTransactionTimeoutTask()
+	startQuotaSensorExpiryTask()
+	startRecoveryPointCheckpointTask()
+	startLogFlushTask()
+	startMetricsReporterTask()
+	startLogRetentionTask()
+	startRemoteLogManagerTask()
+	startLogCleanerTask()
+	startIsrShrinkTask()
+	startHighWatermarkCheckpointTask()
+	raftClient.start()
+	startMetadataApplyTask()
+	startBrokerSessionTask()
+	startLeaderRebalanceTask()
+	err = brokerLifecycle.start()
+	if err != nil {
+		fmt.Println("Error starting broker lifecycle: ", err.Error())
+		os.Exit(1)
+	}
+	startRequestHandlers()
+
+	listeners, err := startListeners()
+	if err != nil {
+		fmt.Println("Error starting listeners: ", err.Error())
+		os.Exit(1)
 	}
+	waitForShutdownSignal(listeners)
 }
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	METADATA_RECORD_FRAME_VERSION = 1
+
+	REGISTER_BROKER_RECORD_TYPE              = 0
+	TOPIC_RECORD_TYPE                        = 2
+	PARTITION_RECORD_TYPE                    = 3
+	CONFIG_RECORD_TYPE                       = 4
+	PARTITION_CHANGE_RECORD_TYPE             = 5
+	ACCESS_CONTROL_ENTRY_RECORD_TYPE         = 6
+	REMOVE_ACCESS_CONTROL_ENTRY_RECORD_TYPE  = 7
+	USER_SCRAM_CREDENTIAL_RECORD_TYPE        = 11
+	FEATURE_LEVEL_RECORD_TYPE                = 12
+	CLIENT_QUOTA_RECORD_TYPE                 = 14
+	PRODUCER_IDS_RECORD_TYPE                 = 15
+	BROKER_REGISTRATION_CHANGE_RECORD_TYPE   = 17
+	REMOVE_USER_SCRAM_CREDENTIAL_RECORD_TYPE = 22
+	NO_OP_RECORD_TYPE                        = 23
+)
+
+// Written by the active controller when its epoch starts, so the records of
+// earlier epochs get committed
+type NoOpRecordValue struct {
+	Header       RecordValueHeader   `order:"1"`
+	TaggedFields ktypes.TaggedFields `order:"2"`
+}
+
+// Records a block of producer ids handed out to a broker
+type ProducerIdsRecordValue struct {
+	Header         RecordValueHeader   `order:"1"`
+	BrokerId       ktypes.Int32        `order:"2"`
+	BrokerEpoch    ktypes.Int64        `order:"3"`
+	NextProducerId ktypes.Int64        `order:"4"`
+	TaggedFields   ktypes.TaggedFields `order:"5"`
+}
+
+// Sets, or removes when Value is null, one config of a resource
+type ConfigRecordValue struct {
+	Header       RecordValueHeader            `order:"1"`
+	ResourceType ktypes.Int8                  `order:"2"`
+	ResourceName ktypes.CompactString         `order:"3"`
+	Name         ktypes.CompactString         `order:"4"`
+	Value        ktypes.CompactNullableString `order:"5"`
+	TaggedFields ktypes.TaggedFields          `order:"6"`
+}
+
+// Configs overriding the broker defaults, by topic name then config name,
+// guarded by metadataMu
+var topicConfigs = make(map[string]map[string]string)
+
+func applyConfigRecord(record *ConfigRecordValue) {
+	if record.ResourceType != RESOURCE_TYPE_TOPIC {
+		return
+	}
+	topicName := string(record.ResourceName)
+	if record.Value == "" {
+		delete(topicConfigs[topicName], string(record.Name))
+		if len(topicConfigs[topicName]) == 0 {
+			delete(topicConfigs, topicName)
+		}
+		return
+	}
+	if topicConfigs[topicName] == nil {
+		topicConfigs[topicName] = make(map[string]string)
+	}
+	topicConfigs[topicName][string(record.Name)] = string(record.Value)
+}
+
+// topicConfig returns the value of a topic config, defaultValue when the
+// topic does not override it.
+func topicConfig(topicName string, name string, defaultValue string) string {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	return lookupTopicConfig(topicName, name, defaultValue)
+}
+
+// lookupTopicConfig is topicConfig for callers holding metadataMu.
+func lookupTopicConfig(topicName string, name string, defaultValue string) string {
+	if value, ok := topicConfigs[topicName][name]; ok {
+		return value
+	}
+	return defaultValue
+}
+
+// Serializes writes to the metadata log with the in-memory state they update
+var metadataMu sync.Mutex
+
+// Serializes the writers of the metadata log from their checks until their
+// records are applied. Taken before metadataMu.
+var metadataWriteMu sync.Mutex
+
+// Cluster id written to meta.properties when the log directories were
+// formatted, empty when unknown
+var clusterId string
+
+// Offset following the last metadata record applied to the in-memory
+// metadata, guarded by metadataMu
+var metadataAppliedOffset int64
+
+// loadClusterMetadata applies the committed records of the cluster metadata
+// partition, starting from its snapshot when the log was truncated.
+func loadClusterMetadata() error {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	return applyCommittedMetadata()
+}
+
+// applyCommittedMetadata applies the metadata records up to the high
+// watermark of the metadata log. Callers hold metadataMu.
+func applyCommittedMetadata() error {
+	log := raftClient.log
+	if metadataAppliedOffset < log.LogStartOffset() {
+		if err := loadMetadataSnapshot(log.dir); err != nil {
+			return err
+		}
+	}
+
+	highWatermark := log.HighWatermark()
+	if metadataAppliedOffset >= highWatermark {
+		return nil
+	}
+	batches, err := log.ReadBatches(metadataAppliedOffset)
+	if err != nil {
+		return err
+	}
+	for _, batch := range batches {
+		if int64(batch.BaseOffset) >= highWatermark {
+			break
+		}
+		if batch.isControl() {
+			metadataAppliedOffset = batch.lastOffset() + 1
+			continue
+		}
+		for _, record := range batch.Records {
+			offset := int64(batch.BaseOffset) + int64(record.OffsetDelta)
+			if offset < metadataAppliedOffset || len(record.Value) == 0 {
+				continue
+			}
+			if err := applyMetadataRecord(record.Value); err != nil {
+				return fmt.Errorf("unable to apply metadata record at offset %d: %w", offset, err)
+			}
+		}
+		metadataAppliedOffset = batch.lastOffset() + 1
+	}
+	return nil
+}
+
+// loadMetadataSnapshot applies the latest snapshot of the metadata log,
+// which holds the whole metadata up to where the log now starts. Callers
+// hold metadataMu.
+func loadMetadataSnapshot(dir string) error {
+	snapshotId, ok := latestSnapshotId(dir)
+	if !ok {
+		return fmt.Errorf("metadata log starts at %d with no snapshot", raftClient.log.LogStartOffset())
+	}
+	if metadataAppliedOffset > 0 {
+		return fmt.Errorf("metadata applied up to %d is behind snapshot %d, a restart is needed", metadataAppliedOffset, snapshotId.EndOffset)
+	}
+	path := filepath.Join(dir, snapshotFileName(int64(snapshotId.EndOffset), int32(snapshotId.Epoch)))
+	batches, err := readLogFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to load metadata snapshot %s: %w", path, err)
+	}
+	for _, batch := range batches {
+		if batch.isControl() {
+			continue
+		}
+		for _, record := range batch.Records {
+			if len(record.Value) == 0 {
+				continue
+			}
+			if err := applyMetadataRecord(record.Value); err != nil {
+				return fmt.Errorf("unable to load metadata snapshot %s: %w", path, err)
+			}
+		}
+	}
+	metadataAppliedOffset = int64(snapshotId.EndOffset)
+	return nil
+}
+
+// appendMetadataRecord writes a metadata record value through the active
+// controller, which must be this node, and applies it once a majority of
+// the quorum has it. Callers hold metadataWriteMu and metadataMu; the latter
+// is released while the record commits, since serving the quorum's fetches
+// needs it.
+func appendMetadataRecord(value any) error {
+	encoded, err := ktypes.NewKEncoder().Encode(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata record: %w", err)
+	}
+
+	endOffset, epoch, err := raftClient.appendAsLeader(encoded)
+	if err != nil {
+		return err
+	}
+	metadataMu.Unlock()
+	err = raftClient.waitForCommit(endOffset, epoch)
+	metadataMu.Lock()
+	if err != nil {
+		return err
+	}
+	return applyCommittedMetadata()
+}
+
+// Next producer id to hand out and the end of the block owned by this
+// broker, guarded by producerIdBlockMu
+var (
+	producerIdBlockMu  sync.Mutex
+	nextProducerId     int64
+	producerIdBlockEnd int64
+)
+
+// Start of the next unallocated block, as recorded in the metadata log
+var nextProducerIdBlockStart int64
+
+func applyProducerIdsRecord(record *ProducerIdsRecordValue) {
+	nextProducerIdBlockStart = int64(record.NextProducerId)
+}
+
+// allocateProducerId returns a fresh producer id, claiming a new block of ids
+// from the active controller when the current one is used up.
+func allocateProducerId() (int64, error) {
+	producerIdBlockMu.Lock()
+	defer producerIdBlockMu.Unlock()
+
+	if nextProducerId >= producerIdBlockEnd {
+		blockStart, err := requestProducerIdBlock()
+		if err != nil {
+			return 0, fmt.Errorf("unable to allocate producer id block: %w", err)
+		}
+		nextProducerId = blockStart
+		producerIdBlockEnd = blockStart + PRODUCER_ID_BLOCK_SIZE
+	}
+
+	producerId := nextProducerId
+	nextProducerId++
+	return producerId, nil
+}
+
+// requestProducerIdBlock claims a block of producer ids, in the metadata log
+// when this node is the active controller and with AllocateProducerIds
+// otherwise.
+func requestProducerIdBlock() (int64, error) {
+	if raftClient.isLeader() {
+		metadataWriteMu.Lock()
+		defer metadataWriteMu.Unlock()
+		metadataMu.Lock()
+		defer metadataMu.Unlock()
+		return allocateProducerIdBlock(int32(brokerConfig.NodeId))
+	}
+
+	requestBody := AllocateProducerIdsRequestBody{
+		BrokerId:    ktypes.Int32(brokerConfig.NodeId),
+		BrokerEpoch: ktypes.Int64(-1),
+	}
+	var responseBody AllocateProducerIdsResponseBody
+	if err := sendToController(ALLOCATE_PRODUCER_IDS_REQUEST_KEY, ALLOCATE_PRODUCER_IDS_VERSION, &requestBody, &responseBody); err != nil {
+		return 0, err
+	}
+	if responseBody.ErrorCode != ERROR_CODE_NONE {
+		return 0, newKafkaError(responseBody.ErrorCode, "controller returned error %d", responseBody.ErrorCode)
+	}
+	return int64(responseBody.ProducerIdStart), nil
+}
+
+// allocateProducerIdBlock hands the next block of producer ids out to a
+// broker. Callers hold metadataWriteMu and metadataMu.
+func allocateProducerIdBlock(brokerId int32) (int64, error) {
+	blockStart := nextProducerIdBlockStart
+	err := appendMetadataRecord(&ProducerIdsRecordValue{
+		Header: RecordValueHeader{
+			FrameVersion: ktypes.Int8(METADATA_RECORD_FRAME_VERSION),
+			RecordType:   ktypes.Int8(PRODUCER_IDS_RECORD_TYPE),
+			Version:      ktypes.Int8(0),
+		},
+		BrokerId:       ktypes.Int32(brokerId),
+		BrokerEpoch:    ktypes.Int64(0),
+		NextProducerId: ktypes.Int64(blockStart + PRODUCER_ID_BLOCK_SIZE),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return blockStart, nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// QueuedRequest is a request waiting in the request queue for a handler,
+// with the channel its response is sent back on.
+type QueuedRequest struct {
+	Request  *Request
+	Response chan *Response
+}
+
+// Requests read by the connections, consumed by the handler pool
+var requestQueue chan *QueuedRequest
+
+// startRequestHandlers creates the bounded request queue and the pool of
+// handlers processing it.
+func startRequestHandlers() {
+	requestQueue = make(chan *QueuedRequest, brokerConfig.QueuedMaxRequests)
+	for i := 0; i < brokerConfig.NumIoThreads; i++ {
+		go runRequestHandler(requestQueue)
+	}
+}
+
+func runRequestHandler(queue <-chan *QueuedRequest) {
+	for queued := range queue {
+		res := handleQueuedRequest(queued.Request)
+		if res != nil && res.delayed != nil {
+			// The handler is free for other requests while this one waits
+			responses := queued.Response
+			res.delayed(func(res *Response) { responses <- res })
+			continue
+		}
+		queued.Response <- res
+	}
+}
+
+// handleQueuedRequest handles a request, a failing handler only closes the
+// connection of the request.
+func handleQueuedRequest(req *Request) (res *Response) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("Error handling request ", req.RequestApiKey, ": ", r)
+			req.Session.closeConnection = true
+			res = nil
+		}
+	}()
+	return handleRequest(req)
+}
+
+// readRequestFrame reads one size prefixed request, size included.
+func readRequestFrame(r io.Reader) ([]byte, error) {
+	var sizeBytes [4]byte
+	if _, err := io.ReadFull(r, sizeBytes[:]); err != nil {
+		return nil, err
+	}
+	size := int32(binary.BigEndian.Uint32(sizeBytes[:]))
+	if size < 0 || int(size) > brokerConfig.SocketRequestMaxBytes {
+		return nil, fmt.Errorf("request size %d is larger than socket.request.max.bytes %d", size, brokerConfig.SocketRequestMaxBytes)
+	}
+	frame := make([]byte, 4+int(size))
+	copy(frame, sizeBytes[:])
+	if _, err := io.ReadFull(r, frame[4:]); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// ConnectionQuotas counts the open connections, in total and per client IP.
+type ConnectionQuotas struct {
+	mu        sync.Mutex
+	slotFreed *sync.Cond
+	total     int
+	perIp     map[string]int
+	conns     map[net.Conn]bool
+}
+
+var connectionQuotas = newConnectionQuotas()
+
+func newConnectionQuotas() *ConnectionQuotas {
+	q := &ConnectionQuotas{perIp: make(map[string]int), conns: make(map[net.Conn]bool)}
+	q.slotFreed = sync.NewCond(&q.mu)
+	return q
+}
+
+func maxConnectionsPerIp(ip string) int {
+	if max, ok := brokerConfig.MaxConnectionsPerIpOverrides[ip]; ok {
+		return max
+	}
+	return brokerConfig.MaxConnectionsPerIp
+}
+
+// reserveConnectionSlot blocks the acceptor while max.connections are open,
+// new clients then wait in the listen backlog instead of being refused. The
+// slot is taken before accepting, so listeners accepting at the same time
+// cannot go over the limit together.
+func (q *ConnectionQuotas) reserveConnectionSlot() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.total >= brokerConfig.MaxConnections {
+		q.slotFreed.Wait()
+	}
+	q.total++
+}
+
+// releaseConnectionSlot gives back a reserved slot no connection ended up
+// using.
+func (q *ConnectionQuotas) releaseConnectionSlot() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.total--
+	q.slotFreed.Signal()
+}
+
+// inc registers a connection in a reserved slot, reporting false when its
+// IP already has as many connections as it is allowed.
+func (q *ConnectionQuotas) inc(conn net.Conn) bool {
+	ip := clientAddress(conn.RemoteAddr().String())
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.perIp[ip] >= maxConnectionsPerIp(ip) {
+		return false
+	}
+	q.perIp[ip]++
+	q.conns[conn] = true
+	return true
+}
+
+func (q *ConnectionQuotas) dec(conn net.Conn) {
+	ip := clientAddress(conn.RemoteAddr().String())
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.conns, conn)
+	q.perIp[ip]--
+	if q.perIp[ip] <= 0 {
+		delete(q.perIp, ip)
+	}
+	q.total--
+	q.slotFreed.Signal()
+}
+
+// interruptReads makes the pending and future reads of every connection
+// fail, while responses can still be written.
+func (q *ConnectionQuotas) interruptReads() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for conn := range q.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+}
+
+// handleConnection reads the requests of one connection and hands them to
+// the request handlers. Like Kafka's processors, the connection is muted
+// while a request is in flight, so responses go back in request order.
+func handleConnection(conn net.Conn, listener Listener) {
+	defer connectionQuotas.dec(conn)
+	defer conn.Close()
+	fmt.Println("Connection accepted on listener ", listener.Name)
+	session := newClientSession(listener)
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsHandshake(tlsConn, session); err != nil {
+			fmt.Println("TLS handshake failed: ", err.Error())
+			return
+		}
+	}
+
+	responses := make(chan *Response, 1)
+	for {
+		reqData, err := readRequestFrame(conn)
+		if isShuttingDown() {
+			return
+		}
+		if err == io.EOF {
+			fmt.Println("Connection closed by client")
+			return
+		}
+		if err != nil {
+			fmt.Println("Error reading data: ", err.Error())
+			return
+		}
+		req, err := parseRequest(reqData)
+		if err != nil {
+			fmt.Println("Error parsing request: ", err.Error())
+			return
+		}
+		req.ClientHost = conn.RemoteAddr().String()
+		req.Session = session
+
+		if !session.Authenticated && !isAllowedBeforeAuthentication(int16(req.RequestApiKey)) {
+			fmt.Println("Closing connection, request ", req.RequestApiKey, " sent before authentication")
+			return
+		}
+
+		if !beginRequest() {
+			return
+		}
+		requestQueue <- &QueuedRequest{Request: req, Response: responses}
+		res := <-responses
+
+		if res == nil {
+			session.closeConnection = true
+		} else if res != noResponse {
+			if _, err := conn.Write(encodeResponse(res)); err != nil {
+				endRequest()
+				fmt.Println("Error sending response: ", err.Error())
+				return
+			}
+		}
+		endRequest()
+		if session.closeConnection {
+			fmt.Println("Closing connection after request ", req.RequestApiKey)
+			return
+		}
+
+		// Throttled clients are muted, no request is read until the throttle time is over
+		if req.ThrottleTimeMs > 0 {
+			time.Sleep(time.Duration(req.ThrottleTimeMs) * time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// requestTestFrame prefixes body with its size
+func requestTestFrame(size int32, body []byte) []byte {
+	frame := binary.BigEndian.AppendUint32(nil, uint32(size))
+	return append(frame, body...)
+}
+
+func TestReadRequestFrame(t *testing.T) {
+	previousConfig := brokerConfig
+	t.Cleanup(func() { brokerConfig = previousConfig })
+	brokerConfig.SocketRequestMaxBytes = 8
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"request", requestTestFrame(3, []byte{1, 2, 3}), false},
+		{"largest request", requestTestFrame(8, make([]byte, 8)), false},
+		{"too large", requestTestFrame(9, make([]byte, 9)), true},
+		{"negative size", requestTestFrame(-1, nil), true},
+		{"truncated", requestTestFrame(3, []byte{1}), true},
+		{"truncated size", []byte{0, 0}, true},
+	}
+	for _, test := range tests {
+		frame, err := readRequestFrame(bytes.NewReader(test.data))
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.wantErr)
+		}
+		if err == nil && !bytes.Equal(frame, test.data) {
+			t.Errorf("%s: got frame %v, want %v", test.name, frame, test.data)
+		}
+	}
+
+	// The next request is read from where the previous one ended
+	r := bytes.NewReader(append(requestTestFrame(1, []byte{1}), requestTestFrame(2, []byte{2, 3})...))
+	readRequestFrame(r)
+	if frame, err := readRequestFrame(r); err != nil || !bytes.Equal(frame, requestTestFrame(2, []byte{2, 3})) {
+		t.Errorf("got second frame %v, %v", frame, err)
+	}
+	if _, err := readRequestFrame(r); err != io.EOF {
+		t.Errorf("got %v at the end of the stream, want EOF", err)
+	}
+}
+
+// quotaTestConn is a connection from addr
+type quotaTestConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c quotaTestConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func newQuotaTestConn(ip string) net.Conn {
+	return &quotaTestConn{addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}}
+}
+
+func TestConnectionQuotasPerIp(t *testing.T) {
+	previousConfig := brokerConfig
+	t.Cleanup(func() { brokerConfig = previousConfig })
+	brokerConfig.MaxConnectionsPerIp = 2
+	brokerConfig.MaxConnectionsPerIpOverrides = map[string]int{"10.0.0.2": 0}
+
+	brokerConfig.MaxConnections = 10
+
+	q := newConnectionQuotas()
+	// inc accepts the connection in a reserved slot, or gives the slot back
+	inc := func(conn net.Conn) bool {
+		q.reserveConnectionSlot()
+		if !q.inc(conn) {
+			q.releaseConnectionSlot()
+			return false
+		}
+		return true
+	}
+	first, second, third := newQuotaTestConn("10.0.0.1"), newQuotaTestConn("10.0.0.1"), newQuotaTestConn("10.0.0.1")
+	if !inc(first) || !inc(second) {
+		t.Fatal("connections under the limit refused")
+	}
+	if inc(third) {
+		t.Fatal("connection over the per IP limit accepted")
+	}
+	if inc(newQuotaTestConn("10.0.0.2")) {
+		t.Error("connection from an IP overridden to 0 accepted")
+	}
+	if !inc(newQuotaTestConn("10.0.0.3")) {
+		t.Error("connection from another IP refused")
+	}
+	if q.total != 3 {
+		t.Errorf("got %d connections, want 3", q.total)
+	}
+
+	q.dec(first)
+	if !inc(third) {
+		t.Error("connection refused after another one from the IP closed")
+	}
+}
+
+func TestConnectionSlots(t *testing.T) {
+	previousConfig := brokerConfig
+	t.Cleanup(func() { brokerConfig = previousConfig })
+	brokerConfig.MaxConnections = 2
+
+	q := newConnectionQuotas()
+	q.reserveConnectionSlot()
+	q.reserveConnectionSlot()
+	reserved := make(chan struct{})
+	go func() {
+		q.reserveConnectionSlot()
+		close(reserved)
+	}()
+	select {
+	case <-reserved:
+		t.Fatal("got a slot over max.connections")
+	case <-time.After(50 * time.Millisecond):
+	}
+	q.releaseConnectionSlot()
+	select {
+	case <-reserved:
+	case <-time.After(5 * time.Second):
+		t.Fatal("got no slot after one was released")
+	}
+	if q.total != 2 {
+		t.Errorf("got %d slots taken, want 2", q.total)
+	}
+}
+
+// acceptTestListener fails to accept with each of errs in turn, then with
+// net.ErrClosed
+type acceptTestListener struct {
+	net.Listener
+	errs []error
+}
+
+func (l *acceptTestListener) Accept() (net.Conn, error) {
+	if len(l.errs) == 0 {
+		return nil, net.ErrClosed
+	}
+	err := l.errs[0]
+	l.errs = l.errs[1:]
+	return nil, err
+}
+
+func TestAcceptConnectionsReleasesSlots(t *testing.T) {
+	previousConfig, previousQuotas := brokerConfig, connectionQuotas
+	t.Cleanup(func() { brokerConfig, connectionQuotas = previousConfig, previousQuotas })
+	brokerConfig.MaxConnections = 1
+	connectionQuotas = newConnectionQuotas()
+
+	// With a single slot, a failed accept that kept it would block the next
+	l := &acceptTestListener{errs: []error{errors.New("accept failed"), errors.New("accept failed")}}
+	done := make(chan struct{})
+	go func() {
+		acceptConnections(l, Listener{Name: "PLAINTEXT"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("accept loop blocked by slots of failed accepts")
+	}
+	if connectionQuotas.total != 0 {
+		t.Errorf("got %d slots taken after the listener closed, want 0", connectionQuotas.total)
+	}
+}
+
+func TestRequestHandlersCloseFailedConnections(t *testing.T) {
+	previousConfig := brokerConfig
+	brokerConfig.QueuedMaxRequests = 1
+	brokerConfig.NumIoThreads = 2
+	startRequestHandlers()
+	// The handlers may not have read the queue yet, it is closed for them to
+	// stop rather than replaced
+	t.Cleanup(func() {
+		close(requestQueue)
+		brokerConfig = previousConfig
+	})
+
+	session := newClientSession(Listener{Name: "PLAINTEXT", SecurityProtocol: SECURITY_PROTOCOL_PLAINTEXT})
+	responses := make(chan *Response, 1)
+	requestQueue <- &QueuedRequest{Request: &Request{RequestApiKey: -1, Session: session}, Response: responses}
+	if res := <-responses; res != nil {
+		t.Errorf("got response %+v to an unknown API, want nil", res)
+	}
+	if !session.closeConnection {
+		t.Error("connection of a failed request left open")
+	}
+}
+
+func TestControllerRequestsFreeTheHandlers(t *testing.T) {
+	previousConfig := brokerConfig
+	brokerConfig.QueuedMaxRequests = 2
+	brokerConfig.NumIoThreads = 1
+	startRequestHandlers()
+	t.Cleanup(func() {
+		close(requestQueue)
+		brokerConfig = previousConfig
+	})
+
+	// Hold the controller until the other request is handled
+	handleControllerRequest(&Request{})
+	release := make(chan struct{})
+	controllerEvents <- func() { <-release }
+
+	session := newClientSession(Listener{Name: "PLAINTEXT", SecurityProtocol: SECURITY_PROTOCOL_PLAINTEXT})
+	controllerResponses, otherResponses := make(chan *Response, 1), make(chan *Response, 1)
+	requestQueue <- &QueuedRequest{Request: &Request{RequestApiKey: BROKER_HEARTBEAT_REQUEST_KEY, Session: session}, Response: controllerResponses}
+	requestQueue <- &QueuedRequest{Request: &Request{RequestApiKey: -1, Session: newClientSession(session.Listener)}, Response: otherResponses}
+	select {
+	case <-otherResponses:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request waited for the handler held by a controller request")
+	}
+	select {
+	case res := <-controllerResponses:
+		t.Fatalf("got response %+v before the controller handled the request", res)
+	default:
+	}
+	close(release)
+	select {
+	case res := <-controllerResponses:
+		// The request has no body to parse
+		if res != nil {
+			t.Errorf("got response %+v to a malformed request, want nil", res)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("controller request never handled")
+	}
+}
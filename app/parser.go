@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/binary"
 	"fmt"
 	"os"
 
@@ -18,21 +19,47 @@ func parseRequest(reqData []byte) (*Request, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode request: %w", err)
 	}
+	// Request header v2 (flexible versions) carries a tagged field buffer after the client id
+	if isFlexibleRequest(req.RequestApiKey, req.RequestApiVersion) {
+		var headerTags RequestHeaderTaggedFields
+		if err := decoder.Decode(&headerTags); err != nil {
+			return nil, fmt.Errorf("failed to decode request header tagged fields: %w", err)
+		}
+	}
+
 	req.Body = make([]byte, decoder.RemainingBytes())
+	copy(req.Body, reqData[decoder.GetPosition():])
 
 	return &req, nil
 }
 
+// isFlexibleRequest reports whether the request uses the flexible (compact, tagged) encoding.
+func isFlexibleRequest(apiKey ktypes.Int16, apiVersion ktypes.Int16) bool {
+	firstFlexibleVersion, ok := flexibleRequestVersions[apiKey]
+	if !ok {
+		return false
+	}
+	return apiVersion >= firstFlexibleVersion
+}
+
 func encodeResponse(res *Response) []byte {
 	if res == nil {
 		fmt.Println("Error sending response: response is nil")
 		os.Exit(1)
 	}
 
+	var header any = &ResponseHeaderV0{CorrelationId: res.CorrelationId}
+	if res.HeaderVersion == 1 {
+		header = &ResponseHeaderV1{CorrelationId: res.CorrelationId}
+	}
 	encoder := ktypes.NewKEncoder()
-	encoded, err := encoder.Encode(res)
+	encodedHeader, err := encoder.Encode(header)
 	if err != nil {
 		panic(fmt.Sprintf("Failed to encode response: %v", err))
 	}
-	return encoded
+
+	encoded := make([]byte, 4, 4+len(encodedHeader)+len(res.Body))
+	binary.BigEndian.PutUint32(encoded, uint32(len(encodedHeader)+len(res.Body)))
+	encoded = append(encoded, encodedHeader...)
+	return append(encoded, res.Body...)
 }
@@ -0,0 +1,1126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PartitionLog is the append-only log of record batches for a single topic
+// partition, stored as segment files in the partition's folder.
+type PartitionLog struct {
+	mu           sync.Mutex
+	topicName    string
+	partition    int32
+	dir          string
+	activeFile   *os.File
+	logEndOffset int64
+	// First offset still readable, raised by retention and DeleteRecords
+	logStartOffset int64
+	// Size of the active segment and timestamp of its first batch, 0 while
+	// it is empty, which decide when it is rolled
+	activeSize             int64
+	activeFirstTimestampMs int64
+	// Offset below which every in-sync replica has the records, the end of
+	// what consumers can read. It follows the log end while no other replica
+	// is in sync.
+	highWatermark int64
+	replicated    bool
+	// Offset below which the log is flushed to disk
+	recoveryPoint int64
+	lastFlushMs   int64
+	// Set while a flush syncs the active segment without holding l.mu,
+	// others wait on flushed to share it rather than start their own
+	flushing bool
+	flushed  *sync.Cond
+	// flush.messages and flush.ms of the topic
+	flushIntervalMessages int64
+	flushIntervalMs       int64
+	// Epoch of the leader, written in the batches it appends
+	leaderEpoch   int32
+	epochCache    *LeaderEpochCache
+	producerState *ProducerStateManager
+	abortedTxns   []AbortedTxn
+	// Segments of the log in remote storage by start offset, read below the
+	// local segments. The log start offset is the remote one.
+	remoteSegments []RemoteLogSegmentMetadata
+}
+
+var partitionLogs = make(map[string]*PartitionLog)
+var partitionLogsMu sync.Mutex
+
+// Closed and replaced whenever a log is appended to or its high watermark
+// moves, waking up the requests waiting for either
+var logChanged = make(chan struct{})
+var logChangedMu sync.Mutex
+
+func notifyLogChanged() {
+	logChangedMu.Lock()
+	defer logChangedMu.Unlock()
+	close(logChanged)
+	logChanged = make(chan struct{})
+}
+
+// logChangedChannel returns the channel closed on the next log change.
+func logChangedChannel() <-chan struct{} {
+	logChangedMu.Lock()
+	defer logChangedMu.Unlock()
+	return logChanged
+}
+
+func segmentFileName(baseOffset int64) string {
+	return fmt.Sprintf("%020d.log", baseOffset)
+}
+
+// getPartitionLog returns the open log for the partition, creating its folder
+// on first use. Logs in an offline log directory cannot be opened.
+func getPartitionLog(topicName string, partition int32) (*PartitionLog, error) {
+	partitionLogsMu.Lock()
+	defer partitionLogsMu.Unlock()
+
+	dir := partitionLogDir(topicName, partition)
+	if log, ok := partitionLogs[dir]; ok {
+		return log, nil
+	}
+	if logDir := logDirectoryFor(dir); logDir.isOffline() {
+		return nil, newKafkaError(ERROR_CODE_KAFKA_STORAGE_ERROR, "log directory %s of %s is offline", logDir.path, dir)
+	}
+
+	log, err := openPartitionLog(topicName, partition, dir)
+	if err != nil {
+		return nil, err
+	}
+	partitionLogs[dir] = log
+	return log, nil
+}
+
+// deletePartitionLog closes the log of a partition and deletes its folder,
+// once this broker no longer has a replica of the partition.
+func deletePartitionLog(topicName string, partition int32) error {
+	partitionLogsMu.Lock()
+	defer partitionLogsMu.Unlock()
+
+	dir, ok := placedPartitionLogDir(topicName, partition)
+	if !ok {
+		return nil
+	}
+	if log, ok := partitionLogs[dir]; ok {
+		delete(partitionLogs, dir)
+		if err := log.Close(); err != nil {
+			return fmt.Errorf("unable to close %s: %w", dir, err)
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("unable to delete partition folder: %w", err)
+	}
+	forgetPartitionLogDir(topicName, partition)
+	return nil
+}
+
+// openPartitionLogs returns every log opened so far.
+func openPartitionLogs() []*PartitionLog {
+	partitionLogsMu.Lock()
+	defer partitionLogsMu.Unlock()
+
+	logs := make([]*PartitionLog, 0, len(partitionLogs))
+	for _, log := range partitionLogs {
+		logs = append(logs, log)
+	}
+	return logs
+}
+
+func openPartitionLog(topicName string, partition int32, dir string) (*PartitionLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create partition folder: %w", err)
+	}
+	if err := removeCleanedSegments(dir); err != nil {
+		return nil, fmt.Errorf("unable to delete cleaned segments: %w", err)
+	}
+	epochCache, err := loadLeaderEpochCache(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	log := &PartitionLog{
+		topicName:     topicName,
+		partition:     partition,
+		dir:           dir,
+		epochCache:    epochCache,
+		producerState: newProducerStateManager(dir),
+		lastFlushMs:   time.Now().UnixMilli(),
+	}
+	log.flushed = sync.NewCond(&log.mu)
+	log.flushIntervalMessages, log.flushIntervalMs = topicFlushIntervals(topicName)
+
+	segments, err := log.segmentFiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		segments = []string{filepath.Join(dir, segmentFileName(0))}
+	}
+	if !hadCleanShutdown {
+		if segments, err = log.recoverSegments(segments, recoveryPoints[dir]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Recover the log end offset from the last batch on disk. After a clean
+	// shutdown the producer state snapshot is at the log end, so only the
+	// active segment needs to be read.
+	scannedSegments := segments
+	if hadCleanShutdown {
+		scannedSegments = segments[len(segments)-1:]
+		for _, segment := range segments[:len(segments)-1] {
+			abortedTxns, err := readTransactionIndex(transactionIndexPath(segment))
+			if err != nil {
+				return nil, err
+			}
+			log.abortedTxns = append(log.abortedTxns, abortedTxns...)
+		}
+	}
+	batches := make([]RawRecordBatch, 0)
+	for _, segment := range scannedSegments {
+		abortedTxns, err := readTransactionIndex(transactionIndexPath(segment))
+		if err != nil {
+			return nil, err
+		}
+		log.abortedTxns = append(log.abortedTxns, abortedTxns...)
+
+		if _, err := os.Stat(segment); os.IsNotExist(err) {
+			continue
+		}
+		segmentBatches, err := readSegmentBatches(segment)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, segmentBatches...)
+	}
+	// A log whose records were all deleted ends where its empty segment starts
+	activeBaseOffset, err := segmentBaseOffset(segments[len(segments)-1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid segment name %s: %w", segments[len(segments)-1], err)
+	}
+	log.logEndOffset = activeBaseOffset
+	for _, batch := range batches {
+		log.logEndOffset = batch.Header.lastOffset() + 1
+		// Epochs written after the last checkpoint are found again
+		if err := log.epochCache.assign(int32(batch.Header.PartitionLeaderEpoch), int64(batch.Header.BaseOffset)); err != nil {
+			return nil, err
+		}
+	}
+	log.highWatermark = log.logEndOffset
+
+	firstBaseOffset, err := segmentBaseOffset(segments[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid segment name %s: %w", segments[0], err)
+	}
+	if topicRemoteStorageEnabled(topicName) {
+		if state, ok := partitionState(topicName, partition); ok {
+			if log.remoteSegments, err = readRemoteLogMetadata(remoteLogMetadataPath(topicName, partition, state.TopicId), topicName, partition, state.TopicId); err != nil {
+				return nil, err
+			}
+		}
+	}
+	firstOffset := firstBaseOffset
+	if copied := log.copiedRemoteSegments(); len(copied) > 0 {
+		firstOffset = min(firstOffset, copied[0].StartOffset)
+	}
+	log.logStartOffset = max(firstOffset, min(logStartOffsets[dir], log.logEndOffset))
+	if err := log.epochCache.truncateFromEnd(log.logEndOffset); err != nil {
+		return nil, err
+	}
+	if err := log.epochCache.truncateFromStart(log.logStartOffset); err != nil {
+		return nil, err
+	}
+	log.leaderEpoch = max(log.epochCache.latestEpoch(), 0)
+
+	if err := log.openActiveSegment(segments[len(segments)-1]); err != nil {
+		return nil, err
+	}
+	activeFile := log.activeFile
+	if !hadCleanShutdown {
+		if err := activeFile.Sync(); err != nil {
+			return nil, fmt.Errorf("unable to sync segment: %w", err)
+		}
+	}
+	log.recoveryPoint = log.logEndOffset
+
+	// Rebuild producer state from the latest snapshot and the batches written after it
+	snapshotOffset, err := log.producerState.loadSnapshot(log.logEndOffset)
+	if err != nil {
+		return nil, err
+	}
+	for i := range batches {
+		if int64(batches[i].Header.BaseOffset) >= snapshotOffset {
+			if err := log.applyBatch(&batches[i], true); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return log, nil
+}
+
+// openActiveSegment opens the segment appended to.
+func (l *PartitionLog) openActiveSegment(segment string) error {
+	activeFile, err := os.OpenFile(segment, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open segment: %w", err)
+	}
+	info, err := activeFile.Stat()
+	if err != nil {
+		activeFile.Close()
+		return fmt.Errorf("unable to open segment: %w", err)
+	}
+	l.activeFile = activeFile
+	l.activeSize = info.Size()
+	l.activeFirstTimestampMs = 0
+	if l.activeSize > 0 {
+		batches, err := readSegmentBatches(segment)
+		if err != nil {
+			return err
+		}
+		l.activeFirstTimestampMs = rollTimestamp(&batches[0].Header)
+	}
+	return nil
+}
+
+// rollTimestamp returns the time a batch counts as written at for rolling
+// and retention, the wall clock for batches without a timestamp.
+func rollTimestamp(header *RecordBatchHeader) int64 {
+	if header.MaxTimestamp > 0 {
+		return int64(header.MaxTimestamp)
+	}
+	return time.Now().UnixMilli()
+}
+
+// maybeRoll starts a new segment before a batch of size bytes when the
+// active one would grow past log.segment.bytes or is older than
+// log.roll.ms, callers hold l.mu.
+func (l *PartitionLog) maybeRoll(size int) error {
+	if l.activeSize == 0 {
+		return nil
+	}
+	if l.activeSize+int64(size) <= int64(brokerConfig.LogSegmentBytes) &&
+		time.Now().UnixMilli()-l.activeFirstTimestampMs < int64(brokerConfig.LogRollMs) {
+		return nil
+	}
+	return l.roll()
+}
+
+// roll flushes and closes the active segment and starts a new one at the
+// log end offset, callers hold l.mu.
+func (l *PartitionLog) roll() error {
+	if err := l.flush(); err != nil {
+		return err
+	}
+	if err := l.activeFile.Close(); err != nil {
+		return fmt.Errorf("unable to close segment: %w", err)
+	}
+	if err := l.openActiveSegment(filepath.Join(l.dir, segmentFileName(l.logEndOffset))); err != nil {
+		return err
+	}
+	if err := syncDir(l.dir); err != nil {
+		return err
+	}
+	// Older segments may be deleted, producer state must not need them
+	if err := l.producerState.takeSnapshot(l.logEndOffset); err != nil {
+		fmt.Println("Error writing producer snapshot: ", err.Error())
+	}
+	return nil
+}
+
+// readSegmentBatches returns the encoded batches of a segment file.
+func readSegmentBatches(segment string) ([]RawRecordBatch, error) {
+	data, err := os.ReadFile(segment)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read segment: %w", err)
+	}
+	batches, err := splitRecordBatches(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read segment %s: %w", segment, err)
+	}
+	return batches, nil
+}
+
+// segmentFiles returns the paths of the partition's segments ordered by base offset.
+func (l *PartitionLog) segmentFiles() ([]string, error) {
+	files, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]string, 0)
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".log") {
+			continue
+		}
+		segments = append(segments, filepath.Join(l.dir, file.Name()))
+	}
+	// Segment names are zero padded base offsets, so lexical order is offset order
+	slices.Sort(segments)
+
+	return segments, nil
+}
+
+// Append writes the records as a single batch at the end of the log and
+// returns the offset assigned to the first record.
+func (l *PartitionLog) Append(records []Record) (int64, error) {
+	if len(records) == 0 {
+		return 0, fmt.Errorf("cannot append an empty batch")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	batch := newRecordBatch(l.logEndOffset, time.Now().UnixMilli(), records)
+	encoded, err := encodeRecordBatch(batch)
+	if err != nil {
+		return 0, err
+	}
+
+	return l.appendBatches(encoded)
+}
+
+// AppendTransactional writes the records as part of the producer's ongoing
+// transaction, e.g. offsets committed by TxnOffsetCommit.
+func (l *PartitionLog) AppendTransactional(producerId int64, producerEpoch int16, records []Record) (int64, error) {
+	if len(records) == 0 {
+		return 0, fmt.Errorf("cannot append an empty batch")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	batch := newTransactionalRecordBatch(l.logEndOffset, time.Now().UnixMilli(), producerId, producerEpoch, records)
+	encoded, err := encodeRecordBatch(batch)
+	if err != nil {
+		return 0, err
+	}
+
+	return l.appendBatches(encoded)
+}
+
+// AppendControlBatch writes the COMMIT or ABORT marker ending the producer's
+// transaction in this partition.
+func (l *PartitionLog) AppendControlBatch(producerId int64, producerEpoch int16, commit bool, coordinatorEpoch int32) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	batch, err := newControlBatch(l.logEndOffset, time.Now().UnixMilli(), producerId, producerEpoch, commit, coordinatorEpoch)
+	if err != nil {
+		return 0, err
+	}
+	encoded, err := encodeRecordBatch(batch)
+	if err != nil {
+		return 0, err
+	}
+
+	return l.appendBatches(encoded)
+}
+
+// AppendBatches writes record batches encoded by a client, assigning them
+// offsets at the end of the log. It returns the offset of the first record.
+func (l *PartitionLog) AppendBatches(data []byte) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.appendBatches(data)
+}
+
+// appendBatches validates and writes encoded batches, callers hold l.mu.
+func (l *PartitionLog) appendBatches(data []byte) (int64, error) {
+	batches, err := splitRecordBatches(data)
+	if err != nil {
+		return 0, newKafkaError(ERROR_CODE_CORRUPT_MESSAGE, "%s", err.Error())
+	}
+	if len(batches) == 0 {
+		return 0, newKafkaError(ERROR_CODE_CORRUPT_MESSAGE, "no record batches to append")
+	}
+
+	startOffset := l.logEndOffset
+	firstOffset, err := l.writeBatches(batches)
+	if l.logEndOffset == startOffset {
+		return firstOffset, err
+	}
+	// Batches written before one failed are in the log, so they complete
+	// like any other append
+	if completeErr := l.completeAppend(); err == nil {
+		err = completeErr
+	}
+	return firstOffset, err
+}
+
+// writeBatches validates and writes batches one by one, stopping at the
+// first that fails, callers hold l.mu. It returns the offset of the first
+// record written, or of the record a retried first batch was written at.
+func (l *PartitionLog) writeBatches(batches []RawRecordBatch) (int64, error) {
+	firstOffset := int64(-1)
+	for i := range batches {
+		batch := &batches[i]
+		if err := validateRecordBatch(batch); err != nil {
+			return firstOffset, err
+		}
+
+		duplicate, err := l.producerState.validate(&batch.Header)
+		if err != nil {
+			if duplicate != nil && firstOffset == -1 {
+				return duplicate.FirstOffset, err
+			}
+			return firstOffset, err
+		}
+
+		batch.setBaseOffset(l.logEndOffset)
+		batch.setPartitionLeaderEpoch(l.leaderEpoch)
+		if firstOffset == -1 {
+			firstOffset = l.logEndOffset
+		}
+		if err := l.writeBatch(batch); err != nil {
+			return firstOffset, err
+		}
+	}
+	return firstOffset, nil
+}
+
+// AppendReplicaBatches writes batches fetched from the leader, keeping
+// their offsets. Offsets may skip ahead, past records the leader compacted,
+// but never go back.
+func (l *PartitionLog) AppendReplicaBatches(data []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	batches, err := splitRecordBatches(data)
+	if err != nil {
+		return newKafkaError(ERROR_CODE_CORRUPT_MESSAGE, "%s", err.Error())
+	}
+	if len(batches) == 0 {
+		return nil
+	}
+	for i := range batches {
+		batch := &batches[i]
+		if err := validateRecordBatch(batch); err != nil {
+			return err
+		}
+		if int64(batch.Header.BaseOffset) < l.logEndOffset {
+			return fmt.Errorf("replicated batch offset %d is below the log end offset %d", batch.Header.BaseOffset, l.logEndOffset)
+		}
+		if err := l.writeBatch(batch); err != nil {
+			return err
+		}
+	}
+	return l.completeAppend()
+}
+
+// TruncateTo removes the records at and past offset, such as those a
+// follower has but its leader does not. Batches are removed whole, so the
+// log may end below offset. It returns the new log end offset.
+func (l *PartitionLog) TruncateTo(offset int64) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if offset >= l.logEndOffset {
+		return l.logEndOffset, nil
+	}
+	segments, err := l.segmentFiles()
+	if err != nil {
+		return l.logEndOffset, err
+	}
+	if err := l.activeFile.Close(); err != nil {
+		return l.logEndOffset, fmt.Errorf("unable to close segment: %w", err)
+	}
+
+	logEndOffset := offset
+	for i := len(segments) - 1; i >= 0; i-- {
+		baseOffset, err := segmentBaseOffset(segments[i])
+		if err != nil {
+			return l.logEndOffset, fmt.Errorf("invalid segment name %s: %w", segments[i], err)
+		}
+		if baseOffset >= offset && i > 0 {
+			if err := os.Remove(segments[i]); err != nil && !os.IsNotExist(err) {
+				return l.logEndOffset, fmt.Errorf("unable to delete segment: %w", err)
+			}
+			if err := os.Remove(transactionIndexPath(segments[i])); err != nil && !os.IsNotExist(err) {
+				return l.logEndOffset, fmt.Errorf("unable to delete transaction index: %w", err)
+			}
+			segments = segments[:i]
+			continue
+		}
+
+		batches, err := readSegmentBatches(segments[i])
+		if err != nil {
+			return l.logEndOffset, err
+		}
+		size := int64(0)
+		logEndOffset = baseOffset
+		for _, batch := range batches {
+			if batch.Header.lastOffset() >= offset {
+				break
+			}
+			size = batch.Position + int64(len(batch.Data))
+			logEndOffset = batch.Header.lastOffset() + 1
+		}
+		if err := os.Truncate(segments[i], size); err != nil {
+			return l.logEndOffset, fmt.Errorf("unable to truncate segment: %w", err)
+		}
+		if err := rebuildTransactionIndex(transactionIndexPath(segments[i]), logEndOffset); err != nil {
+			return l.logEndOffset, err
+		}
+		break
+	}
+	if err := l.openActiveSegment(segments[len(segments)-1]); err != nil {
+		return l.logEndOffset, err
+	}
+
+	l.logEndOffset = logEndOffset
+	if err := l.epochCache.truncateFromEnd(logEndOffset); err != nil {
+		return logEndOffset, err
+	}
+	l.highWatermark = min(l.highWatermark, logEndOffset)
+	l.recoveryPoint = min(l.recoveryPoint, logEndOffset)
+	abortedTxns := make([]AbortedTxn, 0, len(l.abortedTxns))
+	for _, abortedTxn := range l.abortedTxns {
+		if abortedTxn.LastOffset < logEndOffset {
+			abortedTxns = append(abortedTxns, abortedTxn)
+		}
+	}
+	l.abortedTxns = abortedTxns
+
+	// Rebuild producer state from the latest snapshot left and the batches kept after it
+	if err := l.producerState.deleteSnapshotsAfter(logEndOffset); err != nil {
+		return logEndOffset, err
+	}
+	snapshotOffset, err := l.producerState.loadSnapshot(logEndOffset)
+	if err != nil {
+		return logEndOffset, err
+	}
+	for _, segment := range segments {
+		batches, err := readSegmentBatches(segment)
+		if err != nil {
+			return logEndOffset, err
+		}
+		for i := range batches {
+			if int64(batches[i].Header.BaseOffset) >= snapshotOffset {
+				if err := l.applyBatch(&batches[i], true); err != nil {
+					return logEndOffset, err
+				}
+			}
+		}
+	}
+	return logEndOffset, nil
+}
+
+// TruncateFullyAndStartAt empties the log, which then starts at offset, such
+// as when a follower fell behind the start of its leader's log.
+func (l *PartitionLog) TruncateFullyAndStartAt(offset int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	segments, err := l.segmentFiles()
+	if err != nil {
+		return err
+	}
+	if err := l.activeFile.Close(); err != nil {
+		return fmt.Errorf("unable to close segment: %w", err)
+	}
+	for _, segment := range segments {
+		if err := os.Remove(segment); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to delete segment: %w", err)
+		}
+		if err := os.Remove(transactionIndexPath(segment)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to delete transaction index: %w", err)
+		}
+	}
+	if err := l.producerState.deleteSnapshotsAfter(-1); err != nil {
+		return err
+	}
+	if _, err := l.producerState.loadSnapshot(offset); err != nil {
+		return err
+	}
+	if err := l.openActiveSegment(filepath.Join(l.dir, segmentFileName(offset))); err != nil {
+		return err
+	}
+	if err := l.epochCache.clear(); err != nil {
+		return err
+	}
+
+	l.logStartOffset = offset
+	l.logEndOffset = offset
+	l.highWatermark = offset
+	l.recoveryPoint = offset
+	l.abortedTxns = nil
+	return nil
+}
+
+// writeBatch writes a batch at the end of the active segment, rolling it
+// first when full, callers hold l.mu.
+func (l *PartitionLog) writeBatch(batch *RawRecordBatch) error {
+	if err := l.maybeRoll(len(batch.Data)); err != nil {
+		return err
+	}
+	if _, err := l.activeFile.Write(batch.Data); err != nil {
+		return fmt.Errorf("unable to write to segment: %w", err)
+	}
+	if l.activeSize == 0 {
+		l.activeFirstTimestampMs = rollTimestamp(&batch.Header)
+	}
+	l.activeSize += int64(len(batch.Data))
+	l.logEndOffset = batch.Header.lastOffset() + 1
+	if err := l.epochCache.assign(int32(batch.Header.PartitionLeaderEpoch), int64(batch.Header.BaseOffset)); err != nil {
+		return err
+	}
+	return l.applyBatch(batch, false)
+}
+
+// completeAppend snapshots the producer state and flushes the log when due
+// after an append, and wakes up the requests waiting for records, callers
+// hold l.mu.
+func (l *PartitionLog) completeAppend() error {
+	if !l.replicated {
+		l.highWatermark = l.logEndOffset
+	}
+	notifyLogChanged()
+
+	if l.producerState.batchesSinceSnapshot >= PRODUCER_SNAPSHOT_INTERVAL {
+		if err := l.producerState.takeSnapshot(l.logEndOffset); err != nil {
+			fmt.Println("Error writing producer snapshot: ", err.Error())
+		}
+	}
+	if l.logEndOffset-l.recoveryPoint >= l.flushIntervalMessages {
+		return l.flushTo(l.logEndOffset)
+	}
+	return nil
+}
+
+// applyBatch updates the producer state with a batch in the log. ABORT
+// markers also add the transaction to the aborted transaction index, unless
+// it is already there because the batch is replayed during recovery.
+func (l *PartitionLog) applyBatch(batch *RawRecordBatch, recovering bool) error {
+	if int64(batch.Header.ProducerId) == NO_PRODUCER_ID {
+		return nil
+	}
+	if !batch.Header.isControl() {
+		l.producerState.update(&batch.Header)
+		return nil
+	}
+
+	commit, err := batch.isCommitMarker()
+	if err != nil {
+		return err
+	}
+	firstOffset := l.producerState.completeTxn(&batch.Header)
+	if commit || firstOffset == NO_OFFSET {
+		return nil
+	}
+
+	abortedTxn := AbortedTxn{
+		ProducerId:       int64(batch.Header.ProducerId),
+		FirstOffset:      firstOffset,
+		LastOffset:       int64(batch.Header.BaseOffset),
+		LastStableOffset: l.lastStableOffset(),
+	}
+	if recovering && slices.ContainsFunc(l.abortedTxns, func(indexed AbortedTxn) bool {
+		return indexed.ProducerId == abortedTxn.ProducerId && indexed.LastOffset == abortedTxn.LastOffset
+	}) {
+		return nil
+	}
+	if err := appendTransactionIndex(transactionIndexPath(l.activeFile.Name()), abortedTxn); err != nil {
+		return err
+	}
+	l.abortedTxns = append(l.abortedTxns, abortedTxn)
+	return nil
+}
+
+// lastStableOffset returns the offset below which every transaction is
+// complete, never past the high watermark, callers hold l.mu.
+func (l *PartitionLog) lastStableOffset() int64 {
+	if firstUnstableOffset := l.producerState.firstUnstableOffset(); firstUnstableOffset != NO_OFFSET {
+		return min(firstUnstableOffset, l.highWatermark)
+	}
+	return l.highWatermark
+}
+
+// LastStableOffset returns the offset read_committed consumers can read up to.
+func (l *PartitionLog) LastStableOffset() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastStableOffset()
+}
+
+// AbortedTxns returns the aborted transactions overlapping the offsets from
+// fromOffset up to upperBoundOffset, including those only indexed in remote
+// storage.
+func (l *PartitionLog) AbortedTxns(fromOffset int64, upperBoundOffset int64) ([]AbortedTxn, error) {
+	remoteAbortedTxns, err := l.remoteAbortedTxns(fromOffset, upperBoundOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	abortedTxns := make([]AbortedTxn, 0)
+	for _, abortedTxn := range slices.Concat(remoteAbortedTxns, l.abortedTxns) {
+		if abortedTxn.LastOffset < fromOffset || abortedTxn.FirstOffset >= upperBoundOffset {
+			continue
+		}
+		if slices.ContainsFunc(abortedTxns, func(found AbortedTxn) bool {
+			return found.ProducerId == abortedTxn.ProducerId && found.LastOffset == abortedTxn.LastOffset
+		}) {
+			continue
+		}
+		abortedTxns = append(abortedTxns, abortedTxn)
+	}
+	return abortedTxns, nil
+}
+
+// ReadRecords returns the encoded batches holding offsets from fromOffset,
+// stopping at the first batch at or past maxOffset or that would take them
+// past maxBytes. The first batch is returned whatever its size, so readers
+// always make progress. It also returns the offset following the last batch
+// returned. Offsets below the local segments are read from a single remote
+// segment.
+func (l *PartitionLog) ReadRecords(fromOffset int64, maxOffset int64, maxBytes int) ([]byte, int64, error) {
+	remoteSegment, err := l.remoteSegmentFor(fromOffset)
+	if err != nil {
+		return nil, fromOffset, err
+	}
+	if remoteSegment != nil {
+		return readRemoteRecords(remoteSegment, fromOffset, maxOffset, maxBytes)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	segments, err := l.segmentFiles()
+	if err != nil {
+		return nil, fromOffset, err
+	}
+	// The last segment starting at or before fromOffset holds it
+	start := 0
+	for i := 1; i < len(segments); i++ {
+		baseOffset, err := segmentBaseOffset(segments[i])
+		if err != nil {
+			return nil, fromOffset, err
+		}
+		if baseOffset > fromOffset {
+			break
+		}
+		start = i
+	}
+
+	reader := newRecordsReader(fromOffset, maxOffset, maxBytes)
+	for _, segment := range segments[start:] {
+		if err := reader.readSegmentFile(segment); err != nil {
+			return nil, fromOffset, err
+		}
+		if reader.done {
+			break
+		}
+	}
+	return reader.records, reader.nextOffset, nil
+}
+
+// recordsReader collects the encoded batches holding offsets from
+// fromOffset up to maxOffset and maxBytes, segment after segment. Only the
+// headers of the batches before fromOffset are read.
+type recordsReader struct {
+	fromOffset int64
+	maxOffset  int64
+	maxBytes   int
+	records    []byte
+	// Offset following the last batch collected
+	nextOffset int64
+	// Set once a batch past maxOffset or maxBytes is reached
+	done bool
+}
+
+func newRecordsReader(fromOffset int64, maxOffset int64, maxBytes int) *recordsReader {
+	return &recordsReader{
+		fromOffset: fromOffset,
+		maxOffset:  maxOffset,
+		maxBytes:   maxBytes,
+		records:    make([]byte, 0),
+		nextOffset: fromOffset,
+	}
+}
+
+// readSegmentFile collects the batches of a segment file.
+func (r *recordsReader) readSegmentFile(segment string) error {
+	file, err := os.Open(segment)
+	if err != nil {
+		return fmt.Errorf("unable to read segment: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("unable to read segment: %w", err)
+	}
+	if err := r.readSegment(file, info.Size()); err != nil {
+		return fmt.Errorf("unable to read segment %s: %w", segment, err)
+	}
+	return nil
+}
+
+// readSegment collects the batches of size bytes of segment data.
+func (r *recordsReader) readSegment(segment io.ReaderAt, size int64) error {
+	header := make([]byte, RECORD_BATCH_HEADER_SIZE)
+	for position := int64(0); position < size && !r.done; {
+		if _, err := segment.ReadAt(header, position); err != nil {
+			return fmt.Errorf("unable to read record batch at position %d: %w", position, err)
+		}
+		batchHeader, err := decodeRecordBatchHeader(header)
+		if err != nil {
+			return err
+		}
+		batchSize := int64(RECORD_BATCH_LOG_OVERHEAD) + int64(batchHeader.BatchLength)
+		if batchHeader.BatchLength < RECORD_BATCH_HEADER_SIZE-RECORD_BATCH_LOG_OVERHEAD || position+batchSize > size {
+			return fmt.Errorf("invalid record batch length %d at position %d", batchHeader.BatchLength, position)
+		}
+		switch {
+		case int64(batchHeader.BaseOffset) >= r.maxOffset:
+			r.done = true
+		case len(r.records) > 0 && int64(len(r.records))+batchSize > int64(r.maxBytes):
+			r.done = true
+		case batchHeader.lastOffset() >= r.fromOffset:
+			data := make([]byte, batchSize)
+			if _, err := segment.ReadAt(data, position); err != nil {
+				return fmt.Errorf("unable to read record batch at position %d: %w", position, err)
+			}
+			r.records = append(r.records, data...)
+			r.nextOffset = batchHeader.lastOffset() + 1
+		}
+		position += batchSize
+	}
+	return nil
+}
+
+// ReadBatches returns every batch that contains offsets at or after fromOffset.
+func (l *PartitionLog) ReadBatches(fromOffset int64) ([]*RecordBatch, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	segments, err := l.segmentFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*RecordBatch, 0)
+	for _, segment := range segments {
+		batches, err := readLogFile(segment)
+		if err != nil {
+			return nil, err
+		}
+		for _, batch := range batches {
+			if batch.lastOffset() >= fromOffset {
+				result = append(result, batch)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// flush writes the active segment to disk and moves the recovery point to
+// the log end, callers hold l.mu.
+func (l *PartitionLog) flush() error {
+	start := time.Now()
+	if err := l.activeFile.Sync(); err != nil {
+		return fmt.Errorf("unable to sync segment: %w", err)
+	}
+	logFlushTimeMs.record(time.Since(start))
+	l.recoveryPoint = l.logEndOffset
+	l.lastFlushMs = time.Now().UnixMilli()
+	return nil
+}
+
+// flushTo writes the log to disk up to offset, callers hold l.mu. The
+// segment is synced without l.mu so appends go on meanwhile, and appends
+// made while a sync runs are written by a single following sync, however
+// many callers wait for them.
+func (l *PartitionLog) flushTo(offset int64) error {
+	for l.recoveryPoint < offset {
+		if l.flushing {
+			l.flushed.Wait()
+			continue
+		}
+
+		l.flushing = true
+		activeFile, flushOffset := l.activeFile, l.logEndOffset
+		l.mu.Unlock()
+		start := time.Now()
+		err := activeFile.Sync()
+		logFlushTimeMs.record(time.Since(start))
+		l.mu.Lock()
+		l.flushing = false
+		l.flushed.Broadcast()
+
+		// A segment closed meanwhile was flushed by whoever closed it
+		if err != nil && !errors.Is(err, os.ErrClosed) {
+			return fmt.Errorf("unable to sync segment: %w", err)
+		}
+		l.recoveryPoint = max(l.recoveryPoint, min(flushOffset, l.logEndOffset))
+		l.lastFlushMs = time.Now().UnixMilli()
+	}
+	return nil
+}
+
+// Flush waits until every record appended so far is on disk.
+func (l *PartitionLog) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.flushTo(l.logEndOffset)
+}
+
+// flushIfDue flushes the log when it holds unflushed records and was last
+// flushed more than flushIntervalMs ago. The intervals passed become the
+// log's flush.messages and flush.ms.
+func (l *PartitionLog) flushIfDue(nowMs int64, flushIntervalMessages int64, flushIntervalMs int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.flushIntervalMessages, l.flushIntervalMs = flushIntervalMessages, flushIntervalMs
+	if l.logEndOffset == l.recoveryPoint {
+		return nil
+	}
+	if l.logEndOffset-l.recoveryPoint < l.flushIntervalMessages && nowMs-l.lastFlushMs < l.flushIntervalMs {
+		return nil
+	}
+	return l.flushTo(l.logEndOffset)
+}
+
+// RecoveryPoint returns the offset below which the log is on disk.
+func (l *PartitionLog) RecoveryPoint() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.recoveryPoint
+}
+
+// Close snapshots the producer state and flushes the active segment to disk.
+func (l *PartitionLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.producerState.takeSnapshot(l.logEndOffset); err != nil {
+		return err
+	}
+	if err := l.flush(); err != nil {
+		return err
+	}
+	return l.activeFile.Close()
+}
+
+// LogStartOffset returns the first offset that can be read.
+func (l *PartitionLog) LogStartOffset() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.logStartOffset
+}
+
+// HighWatermark returns the offset consumers can read up to.
+func (l *PartitionLog) HighWatermark() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.highWatermark
+}
+
+// updateHighWatermark moves the high watermark up to offset, or to the log
+// end when the log is shorter.
+func (l *PartitionLog) updateHighWatermark(offset int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	offset = min(offset, l.logEndOffset)
+	if offset > l.highWatermark {
+		l.highWatermark = offset
+		notifyLogChanged()
+	}
+}
+
+// setReplicated tells whether other replicas must have the records before
+// they are committed. A log no other replica is in sync with commits them
+// as they are appended, otherwise the high watermark restarts from
+// highWatermark, a checkpointed one not past the log end.
+func (l *PartitionLog) setReplicated(replicated bool, highWatermark int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if replicated && !l.replicated {
+		l.highWatermark = min(highWatermark, l.logEndOffset)
+	}
+	l.replicated = replicated
+	if !replicated {
+		l.highWatermark = l.logEndOffset
+	}
+	notifyLogChanged()
+}
+
+// setLeaderEpoch sets the epoch of the batches appended from now on, when
+// this broker becomes the leader.
+func (l *PartitionLog) setLeaderEpoch(epoch int32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.leaderEpoch = epoch
+}
+
+// LatestEpoch returns the leader epoch of the last records in the log.
+func (l *PartitionLog) LatestEpoch() int32 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.epochCache.latestEpoch()
+}
+
+// EndOffsetForEpoch returns the largest epoch of the log at or below epoch
+// and the offset it ends at.
+func (l *PartitionLog) EndOffsetForEpoch(epoch int32) (int32, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.epochCache.endOffsetFor(epoch, l.logEndOffset)
+}
+
+// LogEndOffset returns the offset the next appended record will get.
+func (l *PartitionLog) LogEndOffset() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.logEndOffset
+}
+
+// OffsetForTimestamp returns the first readable record with a timestamp at
+// or after timestamp, or the one with the largest timestamp for
+// MAX_TIMESTAMP. found is false when no record qualifies.
+func (l *PartitionLog) OffsetForTimestamp(timestamp int64) (offset int64, recordTimestamp int64, found bool, err error) {
+	batches, err := l.ReadBatches(l.LogStartOffset())
+	if err != nil {
+		return 0, 0, false, err
+	}
+	logStartOffset := l.LogStartOffset()
+
+	for _, batch := range batches {
+		if timestamp != MAX_TIMESTAMP && int64(batch.MaxTimestamp) < timestamp {
+			continue
+		}
+		for _, record := range batch.Records {
+			recordOffset := int64(batch.BaseOffset) + int64(record.OffsetDelta)
+			if recordOffset < logStartOffset {
+				continue
+			}
+			t := int64(batch.BaseTimestamp) + int64(record.TimestampDelta)
+			if timestamp == MAX_TIMESTAMP {
+				if !found || t > recordTimestamp {
+					offset, recordTimestamp, found = recordOffset, t, true
+				}
+				continue
+			}
+			if t >= timestamp {
+				return recordOffset, t, true, nil
+			}
+		}
+	}
+	return offset, recordTimestamp, found, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+// openTestPartitionLog opens an empty log in a temporary folder, rolling
+// its segments past segmentBytes
+func openTestPartitionLog(t *testing.T, segmentBytes int) *PartitionLog {
+	t.Helper()
+	previousConfig := brokerConfig
+	brokerConfig.LogSegmentBytes = segmentBytes
+	t.Cleanup(func() { brokerConfig = previousConfig })
+
+	log, err := openPartitionLog("test-topic", 0, t.TempDir())
+	if err != nil {
+		t.Fatalf("opening log: %v", err)
+	}
+	t.Cleanup(func() { log.Close() })
+	return log
+}
+
+// appendTestBatches appends count single record batches
+func appendTestBatches(t *testing.T, log *PartitionLog, count int) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		value := []byte(fmt.Sprintf("value-%d", i))
+		if _, err := log.Append([]Record{{Value: value}}); err != nil {
+			t.Fatalf("appending batch %d: %v", i, err)
+		}
+	}
+}
+
+// batchOffsets returns the base offsets of encoded batches
+func batchOffsets(t *testing.T, records []byte) []int64 {
+	t.Helper()
+	batches, err := splitRecordBatches(records)
+	if err != nil {
+		t.Fatalf("splitting batches: %v", err)
+	}
+	offsets := make([]int64, 0, len(batches))
+	for _, batch := range batches {
+		offsets = append(offsets, int64(batch.Header.BaseOffset))
+	}
+	return offsets
+}
+
+func TestReadRecordsAcrossSegments(t *testing.T) {
+	// Small segments hold a couple of batches each
+	log := openTestPartitionLog(t, 200)
+	appendTestBatches(t, log, 10)
+	segments, err := log.segmentFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) < 3 {
+		t.Fatalf("expected the log to roll, got %d segments", len(segments))
+	}
+
+	tests := []struct {
+		fromOffset int64
+		maxOffset  int64
+		want       []int64
+	}{
+		{0, 10, []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{5, 10, []int64{5, 6, 7, 8, 9}},
+		{3, 6, []int64{3, 4, 5}},
+		{9, 10, []int64{9}},
+		{10, 10, []int64{}},
+	}
+	for _, test := range tests {
+		records, nextOffset, err := log.ReadRecords(test.fromOffset, test.maxOffset, math.MaxInt32)
+		if err != nil {
+			t.Fatalf("reading from %d: %v", test.fromOffset, err)
+		}
+		got := batchOffsets(t, records)
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("reading %d to %d got batches %v, want %v", test.fromOffset, test.maxOffset, got, test.want)
+		}
+		wantNext := test.fromOffset
+		if len(test.want) > 0 {
+			wantNext = test.want[len(test.want)-1] + 1
+		}
+		if nextOffset != wantNext {
+			t.Errorf("reading %d to %d got next offset %d, want %d", test.fromOffset, test.maxOffset, nextOffset, wantNext)
+		}
+	}
+}
+
+func TestReadRecordsMaxBytes(t *testing.T) {
+	log := openTestPartitionLog(t, 200)
+	appendTestBatches(t, log, 10)
+	all, _, err := log.ReadRecords(0, 10, math.MaxInt32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batchSize := len(all) / 10
+
+	tests := []struct {
+		maxBytes int
+		want     []int64
+	}{
+		// The first batch is returned even when larger than maxBytes
+		{1, []int64{2}},
+		{batchSize, []int64{2}},
+		{3*batchSize - 1, []int64{2, 3}},
+		{3 * batchSize, []int64{2, 3, 4}},
+	}
+	for _, test := range tests {
+		records, nextOffset, err := log.ReadRecords(2, 10, test.maxBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := batchOffsets(t, records)
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("reading %d bytes got batches %v, want %v", test.maxBytes, got, test.want)
+		}
+		if want := test.want[len(test.want)-1] + 1; nextOffset != want {
+			t.Errorf("reading %d bytes got next offset %d, want %d", test.maxBytes, nextOffset, want)
+		}
+	}
+}
+
+func TestLastStableOffsetAndAbortedTxns(t *testing.T) {
+	log := openTestPartitionLog(t, 1024*1024)
+	if _, err := log.AppendTransactional(1, 0, []Record{{Value: []byte("a")}, {Value: []byte("b")}}); err != nil {
+		t.Fatal(err)
+	}
+	appendTestBatches(t, log, 1)
+	// The open transaction holds back read_committed consumers
+	if lso := log.LastStableOffset(); lso != 0 {
+		t.Fatalf("got last stable offset %d with a transaction open at 0, want 0", lso)
+	}
+
+	if _, err := log.AppendControlBatch(1, 0, false, 0); err != nil {
+		t.Fatal(err)
+	}
+	if lso := log.LastStableOffset(); lso != 4 {
+		t.Fatalf("got last stable offset %d after the abort, want 4", lso)
+	}
+	if _, err := log.AppendTransactional(2, 0, []Record{{Value: []byte("c")}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := log.AppendControlBatch(2, 0, true, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	abortedTxns, err := log.AbortedTxns(0, log.LogEndOffset())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(abortedTxns) != 1 || abortedTxns[0].ProducerId != 1 || abortedTxns[0].FirstOffset != 0 || abortedTxns[0].LastOffset != 3 {
+		t.Fatalf("got aborted transactions %+v, want producer 1 from 0 to 3", abortedTxns)
+	}
+	// Committed transactions and ranges past the abort list nothing
+	if abortedTxns, err := log.AbortedTxns(4, log.LogEndOffset()); err != nil || len(abortedTxns) != 0 {
+		t.Fatalf("got aborted transactions %+v, %v from 4, want none", abortedTxns, err)
+	}
+
+	// The transaction index is read back on startup
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := openPartitionLog(log.topicName, log.partition, log.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	abortedTxns, err = reopened.AbortedTxns(0, reopened.LogEndOffset())
+	if err != nil || len(abortedTxns) != 1 || abortedTxns[0].LastOffset != 3 {
+		t.Fatalf("got aborted transactions %+v, %v after reopening, want the abort at 3", abortedTxns, err)
+	}
+}
+
+func TestAppendBatchesFailingPartway(t *testing.T) {
+	log := openTestPartitionLog(t, 1<<20)
+	data := make([]byte, 0)
+	for _, sequence := range []int32{0, 1, 5} {
+		batch := newRecordBatch(0, 0, []Record{{Value: []byte("value")}})
+		batch.ProducerId, batch.ProducerEpoch, batch.FirstSequence = 1, 0, ktypes.Int32(sequence)
+		encoded, err := encodeRecordBatch(batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, encoded...)
+	}
+
+	// The batches before the out of order one are written and visible
+	firstOffset, err := log.AppendBatches(data)
+	if errorCodeFromError(err) != ERROR_CODE_OUT_OF_ORDER_SEQUENCE_NUMBER || firstOffset != 0 {
+		t.Fatalf("got first offset %d, %v, want 0 and an out of order sequence", firstOffset, err)
+	}
+	if log.LogEndOffset() != 2 || log.HighWatermark() != 2 {
+		t.Errorf("got log end offset %d, high watermark %d, want 2 and 2", log.LogEndOffset(), log.HighWatermark())
+	}
+	records, _, err := log.ReadRecords(0, log.HighWatermark(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := batchOffsets(t, records); len(got) != 2 || got[1] != 1 {
+		t.Errorf("got batches at %v, want 0 and 1", got)
+	}
+	// The producer goes on after the last batch written
+	if _, err := log.AppendBatches(data[len(data)/3 : 2*len(data)/3]); errorCodeFromError(err) != ERROR_CODE_DUPLICATE_SEQUENCE_NUMBER {
+		t.Errorf("got %v appending the last written batch again, want a duplicate", err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+// A reassignment puts the target replicas of a partition next to the ones
+// they replace, as adding and removing replicas. Once every adding replica
+// joined the ISR the removing ones are dropped, with the leadership moving
+// off them.
+
+// isReassigning reports whether a partition has an ongoing reassignment.
+func isReassigning(partition *PartitionRecordValue) bool {
+	return len(partition.AddingReplicas) > 0 || len(partition.RemovingReplicas) > 0
+}
+
+// checkReplicaAssignment checks the target replicas of a partition are
+// distinct registered brokers. Callers hold metadataMu.
+func checkReplicaAssignment(replicas []int32) error {
+	if len(replicas) == 0 {
+		return newKafkaError(ERROR_CODE_INVALID_REPLICA_ASSIGNMENT, "the replicas cannot be empty")
+	}
+	for i, replicaId := range replicas {
+		if slices.Contains(replicas[:i], replicaId) {
+			return newKafkaError(ERROR_CODE_INVALID_REPLICA_ASSIGNMENT, "broker %d is listed twice in the replicas", replicaId)
+		}
+		if _, ok := brokerRegistrations[replicaId]; !ok {
+			return newKafkaError(ERROR_CODE_INVALID_REPLICA_ASSIGNMENT, "broker %d is not registered", replicaId)
+		}
+	}
+	return nil
+}
+
+// reassignPartition records the replicas of a partition with those being
+// added and removed, keeping the ISR and the leader among the replicas.
+// Callers hold metadataWriteMu and metadataMu.
+func reassignPartition(topicId ktypes.UUID, partition *PartitionRecordValue, replicas []int32, adding []int32, removing []int32) error {
+	isr := toInt32Slice(partition.InSyncReplicas)
+	newIsr := slices.DeleteFunc(slices.Clone(isr), func(id int32) bool { return !slices.Contains(replicas, id) })
+	if len(newIsr) == 0 {
+		return newKafkaError(ERROR_CODE_INVALID_REPLICA_ASSIGNMENT, "none of the replicas %v of partition %d of topic %s is in sync", replicas, partition.PartitionId, topicId)
+	}
+	leaderId := int32(partition.Leader)
+	if !slices.Contains(replicas, leaderId) {
+		leaderId = electLeader(replicas, newIsr)
+	}
+
+	fields := ktypes.TaggedFieldValues{
+		PARTITION_CHANGE_REPLICAS_TAG:          newBrokerIdListField(replicas),
+		PARTITION_CHANGE_REMOVING_REPLICAS_TAG: newBrokerIdListField(removing),
+		PARTITION_CHANGE_ADDING_REPLICAS_TAG:   newBrokerIdListField(adding),
+	}
+	if !slices.Equal(isr, newIsr) {
+		fields[PARTITION_CHANGE_ISR_TAG] = newBrokerIdListField(newIsr)
+	}
+	if leaderId != int32(partition.Leader) {
+		fields[PARTITION_CHANGE_LEADER_TAG] = newBrokerIdField(leaderId)
+	}
+	setEligibleLeaderReplicas(fields, topicId, partition, replicas, newIsr, leaderId)
+	return appendMetadataRecord(&PartitionChangeRecordValue{
+		Header: RecordValueHeader{
+			FrameVersion: ktypes.Int8(METADATA_RECORD_FRAME_VERSION),
+			RecordType:   ktypes.Int8(PARTITION_CHANGE_RECORD_TYPE),
+		},
+		PartitionId:  partition.PartitionId,
+		TopicId:      topicId,
+		TaggedFields: fields,
+	})
+}
+
+// alterPartitionReassignment starts moving a partition to the target
+// replicas, replacing its ongoing reassignment, or cancels the ongoing
+// reassignment when target is nil. A target that adds no replica takes
+// effect at once. Callers hold metadataWriteMu and metadataMu.
+func alterPartitionReassignment(topicName string, partitionId int32, target []int32) error {
+	topicId, ok := topicNameToTopicId[topicName]
+	if !ok {
+		return newKafkaError(ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION, "unknown topic %s", topicName)
+	}
+	partition, ok := partitionRecordFor(topicId, partitionId)
+	if !ok {
+		return newKafkaError(ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION, "unknown partition %d of topic %s", partitionId, topicName)
+	}
+	adding := toInt32Slice(partition.AddingReplicas)
+	// The replicas of the partition before its ongoing reassignment
+	original := slices.DeleteFunc(toInt32Slice(partition.Replicas), func(id int32) bool { return slices.Contains(adding, id) })
+
+	if target == nil {
+		if !isReassigning(partition) {
+			return newKafkaError(ERROR_CODE_NO_REASSIGNMENT_IN_PROGRESS, "no reassignment of %s-%d is in progress", topicName, partitionId)
+		}
+		return reassignPartition(topicId, partition, original, []int32{}, []int32{})
+	}
+	if err := checkReplicaAssignment(target); err != nil {
+		return err
+	}
+	newAdding := slices.DeleteFunc(slices.Clone(target), func(id int32) bool { return slices.Contains(original, id) })
+	newRemoving := slices.DeleteFunc(slices.Clone(original), func(id int32) bool { return slices.Contains(target, id) })
+	if len(newAdding) == 0 {
+		return reassignPartition(topicId, partition, target, []int32{}, []int32{})
+	}
+	replicas := append(slices.Clone(target), newRemoving...)
+	if err := reassignPartition(topicId, partition, replicas, newAdding, newRemoving); err != nil {
+		return err
+	}
+	// Replicas added by the replaced reassignment may already be in sync
+	return maybeCompleteReassignment(topicId, partitionId)
+}
+
+// maybeCompleteReassignment drops the removing replicas of a partition once
+// every adding replica is in the ISR. Callers hold metadataWriteMu and
+// metadataMu.
+func maybeCompleteReassignment(topicId ktypes.UUID, partitionId int32) error {
+	partition, ok := partitionRecordFor(topicId, partitionId)
+	if !ok || len(partition.AddingReplicas) == 0 {
+		return nil
+	}
+	isr := toInt32Slice(partition.InSyncReplicas)
+	for _, replicaId := range partition.AddingReplicas {
+		if !slices.Contains(isr, int32(replicaId)) {
+			return nil
+		}
+	}
+	removing := toInt32Slice(partition.RemovingReplicas)
+	target := slices.DeleteFunc(toInt32Slice(partition.Replicas), func(id int32) bool { return slices.Contains(removing, id) })
+	return reassignPartition(topicId, partition, target, []int32{}, []int32{})
+}
+
+// listPartitionReassignments returns the partitions of a topic with an
+// ongoing reassignment, among partitionIds unless it is nil. Callers hold
+// metadataMu.
+func listPartitionReassignments(topicId ktypes.UUID, partitionIds []int32) []PartitionRecordValue {
+	partitions := make([]PartitionRecordValue, 0)
+	for _, partition := range topicIdToPartitions[topicId] {
+		if !isReassigning(&partition) || (partitionIds != nil && !slices.Contains(partitionIds, int32(partition.PartitionId))) {
+			continue
+		}
+		partitions = append(partitions, partition)
+	}
+	return partitions
+}
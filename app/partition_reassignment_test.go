@@ -0,0 +1,201 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+var reassignTestTopicId = ktypes.UUID{0xaa}
+
+// newTestController makes this broker the only voter and the active
+// controller, over empty metadata where records commit as they are appended
+func newTestController(t *testing.T) *RaftClient {
+	t.Helper()
+	log := openTestPartitionLog(t, 1<<20)
+	brokerConfig.NodeId = 1
+	brokerConfig.ControllerQuorumVoters = map[int32]string{1: "localhost:19091"}
+	brokerConfig.ControllerQuorumRequestTimeoutMs = 1000
+	brokerConfig.MinInsyncReplicas = 1
+	log.setLeaderEpoch(1)
+	log.setReplicated(false, 0)
+	// The record starting the epoch, applied before the controller writes
+	if _, err := log.Append([]Record{{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	previousClient, previousApplied := raftClient, metadataAppliedOffset
+	metadataMu.Lock()
+	previousTopicIds, previousTopicNames, previousPartitions := topicNameToTopicId, topicIdToTopicName, topicIdToPartitions
+	previousRegistrations, previousChanged := brokerRegistrations, changedPartitions
+	topicNameToTopicId = make(map[string]ktypes.UUID)
+	topicIdToTopicName = make(map[ktypes.UUID]string)
+	topicIdToPartitions = make(map[ktypes.UUID][]PartitionRecordValue)
+	brokerRegistrations = make(map[int32]*BrokerRegistration)
+	changedPartitions = make([]TopicPartition, 0)
+	metadataAppliedOffset = log.LogEndOffset()
+	metadataMu.Unlock()
+	t.Cleanup(func() {
+		metadataMu.Lock()
+		defer metadataMu.Unlock()
+		topicNameToTopicId, topicIdToTopicName, topicIdToPartitions = previousTopicIds, previousTopicNames, previousPartitions
+		brokerRegistrations, changedPartitions = previousRegistrations, previousChanged
+		raftClient, metadataAppliedOffset = previousClient, previousApplied
+	})
+
+	raftClient = &RaftClient{
+		log:      log,
+		role:     QUORUM_ROLE_LEADER,
+		epoch:    1,
+		leaderId: 1,
+		votedId:  NO_VOTE,
+		wakeup:   make(chan struct{}, 1),
+		stopped:  make(chan struct{}),
+	}
+	return raftClient
+}
+
+// setTestPartition registers brokers 1 to 5 and a partition of
+// reassign-topic with replicas 1, 2 and 3 in sync, led by 1
+func setTestPartition(t *testing.T) {
+	t.Helper()
+	newTestController(t)
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	for id := int32(1); id <= 5; id++ {
+		brokerRegistrations[id] = &BrokerRegistration{Id: id}
+	}
+	topicNameToTopicId["reassign-topic"] = reassignTestTopicId
+	topicIdToTopicName[reassignTestTopicId] = "reassign-topic"
+	topicIdToPartitions[reassignTestTopicId] = []PartitionRecordValue{{
+		TopicId:        reassignTestTopicId,
+		Replicas:       ktypes.CompactArray[ktypes.Int32]{1, 2, 3},
+		InSyncReplicas: ktypes.CompactArray[ktypes.Int32]{1, 2, 3},
+		Leader:         1,
+	}}
+}
+
+// testPartitionReplicas returns the replicas, adding and removing replicas,
+// ISR and leader of the test partition
+func testPartitionReplicas(t *testing.T) ([]int32, []int32, []int32, []int32, int32) {
+	t.Helper()
+	partition, ok := partitionRecordFor(reassignTestTopicId, 0)
+	if !ok {
+		t.Fatal("test partition not found")
+	}
+	return toInt32Slice(partition.Replicas), toInt32Slice(partition.AddingReplicas), toInt32Slice(partition.RemovingReplicas),
+		toInt32Slice(partition.InSyncReplicas), int32(partition.Leader)
+}
+
+func TestAlterPartitionReassignment(t *testing.T) {
+	tests := []struct {
+		name         string
+		targets      [][]int32
+		wantReplicas []int32
+		wantAdding   []int32
+		wantRemoving []int32
+		wantIsr      []int32
+		wantLeader   int32
+	}{
+		{"move", [][]int32{{3, 4, 5}}, []int32{3, 4, 5, 1, 2}, []int32{4, 5}, []int32{1, 2}, []int32{1, 2, 3}, 1},
+		{"no replica added", [][]int32{{3, 2}}, []int32{3, 2}, []int32{}, []int32{}, []int32{2, 3}, 3},
+		{"same replicas", [][]int32{{1, 2, 3}}, []int32{1, 2, 3}, []int32{}, []int32{}, []int32{1, 2, 3}, 1},
+		{"cancel", [][]int32{{1, 4}, nil}, []int32{1, 2, 3}, []int32{}, []int32{}, []int32{1, 2, 3}, 1},
+		{"replace", [][]int32{{1, 4}, {2, 5}}, []int32{2, 5, 1, 3}, []int32{5}, []int32{1, 3}, []int32{1, 2, 3}, 1},
+	}
+	for _, test := range tests {
+		setTestPartition(t)
+		metadataMu.Lock()
+		for _, target := range test.targets {
+			if err := alterPartitionReassignment("reassign-topic", 0, target); err != nil {
+				metadataMu.Unlock()
+				t.Fatalf("%s: reassigning to %v: %v", test.name, target, err)
+			}
+		}
+		replicas, adding, removing, isr, leader := testPartitionReplicas(t)
+		metadataMu.Unlock()
+		if !slices.Equal(replicas, test.wantReplicas) || !slices.Equal(adding, test.wantAdding) || !slices.Equal(removing, test.wantRemoving) {
+			t.Errorf("%s: got replicas %v adding %v removing %v, want %v adding %v removing %v", test.name, replicas, adding, removing, test.wantReplicas, test.wantAdding, test.wantRemoving)
+		}
+		if !slices.Equal(isr, test.wantIsr) || leader != test.wantLeader {
+			t.Errorf("%s: got ISR %v led by %d, want %v led by %d", test.name, isr, leader, test.wantIsr, test.wantLeader)
+		}
+	}
+}
+
+func TestAlterPartitionReassignmentErrors(t *testing.T) {
+	setTestPartition(t)
+	tests := []struct {
+		name      string
+		topic     string
+		partition int32
+		target    []int32
+		want      ERROR_CODE
+	}{
+		{"unknown topic", "other-topic", 0, []int32{1}, ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION},
+		{"unknown partition", "reassign-topic", 1, []int32{1}, ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION},
+		{"no replicas", "reassign-topic", 0, []int32{}, ERROR_CODE_INVALID_REPLICA_ASSIGNMENT},
+		{"replica listed twice", "reassign-topic", 0, []int32{1, 4, 1}, ERROR_CODE_INVALID_REPLICA_ASSIGNMENT},
+		{"unregistered broker", "reassign-topic", 0, []int32{1, 6}, ERROR_CODE_INVALID_REPLICA_ASSIGNMENT},
+		{"nothing to cancel", "reassign-topic", 0, nil, ERROR_CODE_NO_REASSIGNMENT_IN_PROGRESS},
+	}
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	for _, test := range tests {
+		err := alterPartitionReassignment(test.topic, test.partition, test.target)
+		if got := errorCodeFromError(err); got != test.want {
+			t.Errorf("%s: got error %d (%v), want %d", test.name, got, err, test.want)
+		}
+	}
+
+	// Replicas out of sync cannot take over at once
+	if err := changePartition(reassignTestTopicId, 0, 1, []int32{1}); err != nil {
+		t.Fatal(err)
+	}
+	err := alterPartitionReassignment("reassign-topic", 0, []int32{2, 3})
+	if got := errorCodeFromError(err); got != ERROR_CODE_INVALID_REPLICA_ASSIGNMENT {
+		t.Errorf("got error %d (%v) moving to replicas out of sync, want %d", got, err, ERROR_CODE_INVALID_REPLICA_ASSIGNMENT)
+	}
+}
+
+func TestCompleteReassignment(t *testing.T) {
+	setTestPartition(t)
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	if err := alterPartitionReassignment("reassign-topic", 0, []int32{3, 4, 5}); err != nil {
+		t.Fatal(err)
+	}
+	if got := listPartitionReassignments(reassignTestTopicId, nil); len(got) != 1 {
+		t.Fatalf("got %d reassignments, want 1", len(got))
+	}
+	if got := listPartitionReassignments(reassignTestTopicId, []int32{1}); len(got) != 0 {
+		t.Errorf("got %d reassignments of partition 1, want none", len(got))
+	}
+
+	steps := []struct {
+		name         string
+		isr          []int32
+		wantReplicas []int32
+		wantIsr      []int32
+		wantLeader   int32
+	}{
+		{"one adding replica in sync", []int32{1, 2, 3, 4}, []int32{3, 4, 5, 1, 2}, []int32{1, 2, 3, 4}, 1},
+		{"every adding replica in sync", []int32{1, 2, 3, 4, 5}, []int32{3, 4, 5}, []int32{3, 4, 5}, 3},
+	}
+	for _, step := range steps {
+		if err := changePartition(reassignTestTopicId, 0, 1, step.isr); err != nil {
+			t.Fatal(err)
+		}
+		if err := maybeCompleteReassignment(reassignTestTopicId, 0); err != nil {
+			t.Fatal(err)
+		}
+		replicas, _, _, isr, leader := testPartitionReplicas(t)
+		if !slices.Equal(replicas, step.wantReplicas) || !slices.Equal(isr, step.wantIsr) || leader != step.wantLeader {
+			t.Errorf("%s: got replicas %v, ISR %v led by %d, want %v, %v led by %d", step.name, replicas, isr, leader, step.wantReplicas, step.wantIsr, step.wantLeader)
+		}
+	}
+	if got := listPartitionReassignments(reassignTestTopicId, nil); len(got) != 0 {
+		t.Errorf("got %d reassignments once complete, want none", len(got))
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	NO_PRODUCER_ID    = -1
+	NO_PRODUCER_EPOCH = -1
+	NO_SEQUENCE       = -1
+	NO_OFFSET         = -1
+
+	// Batches remembered per producer to answer retries
+	PRODUCER_BATCHES_TO_RETAIN = 5
+
+	PRODUCER_SNAPSHOT_VERSION  = 1
+	PRODUCER_SNAPSHOT_SUFFIX   = ".snapshot"
+	PRODUCER_SNAPSHOTS_TO_KEEP = 2
+
+	// Idempotent batches appended between two snapshots
+	PRODUCER_SNAPSHOT_INTERVAL = 100
+)
+
+type ProducerBatchMetadata struct {
+	FirstSequence   int32
+	LastSequence    int32
+	FirstOffset     int64
+	LastOffsetDelta int32
+	Timestamp       int64
+}
+
+type ProducerStateEntry struct {
+	ProducerId            int64
+	ProducerEpoch         int16
+	CoordinatorEpoch      int32
+	CurrentTxnFirstOffset int64
+	batches               []ProducerBatchMetadata
+}
+
+func (entry *ProducerStateEntry) lastSequence() int32 {
+	if len(entry.batches) == 0 {
+		return NO_SEQUENCE
+	}
+	return entry.batches[len(entry.batches)-1].LastSequence
+}
+
+// isLastBatch reports whether a batch is the last one written by its
+// producer.
+func (m *ProducerStateManager) isLastBatch(header *RecordBatchHeader) bool {
+	entry, ok := m.producers[int64(header.ProducerId)]
+	if !ok || len(entry.batches) == 0 {
+		return false
+	}
+	last := entry.batches[len(entry.batches)-1]
+	return last.FirstOffset+int64(last.LastOffsetDelta) == header.lastOffset()
+}
+
+// duplicateOf returns the retained batch with the same sequence range, if any.
+func (entry *ProducerStateEntry) duplicateOf(header *RecordBatchHeader) *ProducerBatchMetadata {
+	for i := range entry.batches {
+		batch := &entry.batches[i]
+		if batch.FirstSequence == int32(header.FirstSequence) && batch.LastSequence == header.lastSequence() {
+			return batch
+		}
+	}
+	return nil
+}
+
+type ProducerSnapshotEntry struct {
+	ProducerId            ktypes.Int64 `order:"1"`
+	ProducerEpoch         ktypes.Int16 `order:"2"`
+	LastSequence          ktypes.Int32 `order:"3"`
+	LastOffset            ktypes.Int64 `order:"4"`
+	OffsetDelta           ktypes.Int32 `order:"5"`
+	Timestamp             ktypes.Int64 `order:"6"`
+	CoordinatorEpoch      ktypes.Int32 `order:"7"`
+	CurrentTxnFirstOffset ktypes.Int64 `order:"8"`
+}
+
+type ProducerSnapshot struct {
+	Version ktypes.Int16                        `order:"1"`
+	Entries ktypes.Array[ProducerSnapshotEntry] `order:"2"`
+}
+
+// ProducerStateManager tracks idempotent producers writing to one partition
+// so retried batches are detected and gaps in sequences rejected.
+type ProducerStateManager struct {
+	dir       string
+	producers map[int64]*ProducerStateEntry
+
+	// Log offset up to which the state is reflected in a snapshot
+	lastSnapshotOffset   int64
+	batchesSinceSnapshot int
+}
+
+func newProducerStateManager(dir string) *ProducerStateManager {
+	return &ProducerStateManager{
+		dir:       dir,
+		producers: make(map[int64]*ProducerStateEntry),
+	}
+}
+
+// isInSequence reports whether nextSequence directly follows lastSequence.
+func isInSequence(lastSequence int32, nextSequence int32) bool {
+	return nextSequence == lastSequence+1 || (lastSequence == math.MaxInt32 && nextSequence == 0)
+}
+
+// validate checks an incoming batch against the producer's state. A retried
+// batch is reported as DUPLICATE_SEQUENCE_NUMBER together with the offset it
+// was originally written at.
+func (m *ProducerStateManager) validate(header *RecordBatchHeader) (*ProducerBatchMetadata, error) {
+	producerId := int64(header.ProducerId)
+	if producerId == NO_PRODUCER_ID {
+		return nil, nil
+	}
+
+	entry, ok := m.producers[producerId]
+	if ok && int16(header.ProducerEpoch) < entry.ProducerEpoch {
+		return nil, newKafkaError(ERROR_CODE_INVALID_PRODUCER_EPOCH,
+			"producer %d epoch %d is older than current epoch %d", producerId, header.ProducerEpoch, entry.ProducerEpoch)
+	}
+
+	if header.isControl() || header.FirstSequence == NO_SEQUENCE {
+		// Markers and coordinator writes carry no sequence numbers
+		return nil, nil
+	}
+
+	if !ok {
+		if header.FirstSequence != 0 {
+			return nil, newKafkaError(ERROR_CODE_OUT_OF_ORDER_SEQUENCE_NUMBER,
+				"unknown producer %d sent sequence %d, expected 0", producerId, header.FirstSequence)
+		}
+		return nil, nil
+	}
+
+	if int16(header.ProducerEpoch) > entry.ProducerEpoch {
+		// A bumped epoch restarts the sequence
+		if header.FirstSequence != 0 {
+			return nil, newKafkaError(ERROR_CODE_OUT_OF_ORDER_SEQUENCE_NUMBER,
+				"producer %d sent sequence %d for new epoch %d, expected 0", producerId, header.FirstSequence, header.ProducerEpoch)
+		}
+		return nil, nil
+	}
+
+	if duplicate := entry.duplicateOf(header); duplicate != nil {
+		return duplicate, newKafkaError(ERROR_CODE_DUPLICATE_SEQUENCE_NUMBER,
+			"producer %d batch with sequence %d was already written at offset %d", producerId, header.FirstSequence, duplicate.FirstOffset)
+	}
+
+	lastSequence := entry.lastSequence()
+	if lastSequence == NO_SEQUENCE {
+		if header.FirstSequence != 0 {
+			return nil, newKafkaError(ERROR_CODE_OUT_OF_ORDER_SEQUENCE_NUMBER,
+				"producer %d sent sequence %d, expected 0", producerId, header.FirstSequence)
+		}
+	} else if !isInSequence(lastSequence, int32(header.FirstSequence)) {
+		return nil, newKafkaError(ERROR_CODE_OUT_OF_ORDER_SEQUENCE_NUMBER,
+			"producer %d sent sequence %d, expected %d", producerId, header.FirstSequence, lastSequence+1)
+	}
+
+	return nil, nil
+}
+
+// update records a batch that was written to the log at its base offset.
+func (m *ProducerStateManager) update(header *RecordBatchHeader) {
+	producerId := int64(header.ProducerId)
+	if producerId == NO_PRODUCER_ID {
+		return
+	}
+
+	entry, ok := m.producers[producerId]
+	if !ok || int16(header.ProducerEpoch) > entry.ProducerEpoch {
+		entry = &ProducerStateEntry{
+			ProducerId:            producerId,
+			ProducerEpoch:         int16(header.ProducerEpoch),
+			CoordinatorEpoch:      -1,
+			CurrentTxnFirstOffset: NO_OFFSET,
+		}
+		m.producers[producerId] = entry
+	}
+
+	if header.isTransactional() && entry.CurrentTxnFirstOffset == NO_OFFSET {
+		entry.CurrentTxnFirstOffset = int64(header.BaseOffset)
+	}
+
+	if header.FirstSequence != NO_SEQUENCE {
+		entry.batches = append(entry.batches, ProducerBatchMetadata{
+			FirstSequence:   int32(header.FirstSequence),
+			LastSequence:    header.lastSequence(),
+			FirstOffset:     int64(header.BaseOffset),
+			LastOffsetDelta: int32(header.LastOffsetDelta),
+			Timestamp:       int64(header.MaxTimestamp),
+		})
+		if len(entry.batches) > PRODUCER_BATCHES_TO_RETAIN {
+			entry.batches = entry.batches[len(entry.batches)-PRODUCER_BATCHES_TO_RETAIN:]
+		}
+	}
+	m.batchesSinceSnapshot++
+}
+
+// completeTxn records the COMMIT or ABORT marker of the producer's ongoing
+// transaction and returns the offset the transaction started at, or
+// NO_OFFSET when the producer had none.
+func (m *ProducerStateManager) completeTxn(header *RecordBatchHeader) int64 {
+	producerId := int64(header.ProducerId)
+	entry, ok := m.producers[producerId]
+	if !ok {
+		entry = &ProducerStateEntry{
+			ProducerId:            producerId,
+			ProducerEpoch:         int16(header.ProducerEpoch),
+			CoordinatorEpoch:      -1,
+			CurrentTxnFirstOffset: NO_OFFSET,
+		}
+		m.producers[producerId] = entry
+	}
+
+	firstOffset := entry.CurrentTxnFirstOffset
+	entry.CurrentTxnFirstOffset = NO_OFFSET
+	if int16(header.ProducerEpoch) > entry.ProducerEpoch {
+		// Markers written after fencing carry the bumped epoch
+		entry.ProducerEpoch = int16(header.ProducerEpoch)
+		entry.batches = nil
+	}
+	m.batchesSinceSnapshot++
+	return firstOffset
+}
+
+// firstUnstableOffset returns the first offset of the oldest ongoing
+// transaction, or NO_OFFSET when no transaction is open.
+func (m *ProducerStateManager) firstUnstableOffset() int64 {
+	firstUnstableOffset := int64(NO_OFFSET)
+	for _, entry := range m.producers {
+		if entry.CurrentTxnFirstOffset == NO_OFFSET {
+			continue
+		}
+		if firstUnstableOffset == NO_OFFSET || entry.CurrentTxnFirstOffset < firstUnstableOffset {
+			firstUnstableOffset = entry.CurrentTxnFirstOffset
+		}
+	}
+	return firstUnstableOffset
+}
+
+func producerSnapshotFileName(offset int64) string {
+	return fmt.Sprintf("%020d%s", offset, PRODUCER_SNAPSHOT_SUFFIX)
+}
+
+// snapshotOffsets returns the offsets of the snapshots on disk, oldest first.
+func (m *ProducerStateManager) snapshotOffsets() ([]int64, error) {
+	files, err := os.ReadDir(m.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make([]int64, 0)
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), PRODUCER_SNAPSHOT_SUFFIX)
+		if file.IsDir() || !ok {
+			continue
+		}
+		offset, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		offsets = append(offsets, offset)
+	}
+	slices.Sort(offsets)
+	return offsets, nil
+}
+
+// loadSnapshot restores the latest snapshot not past logEndOffset and
+// returns the offset from which the log must be replayed.
+func (m *ProducerStateManager) loadSnapshot(logEndOffset int64) (int64, error) {
+	offsets, err := m.snapshotOffsets()
+	if err != nil {
+		return 0, err
+	}
+
+	for i := len(offsets) - 1; i >= 0; i-- {
+		if offsets[i] > logEndOffset {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(m.dir, producerSnapshotFileName(offsets[i])))
+		if err != nil {
+			return 0, err
+		}
+		var snapshot ProducerSnapshot
+		if err := ktypes.NewKDecoder(data).Decode(&snapshot); err != nil || snapshot.Version != PRODUCER_SNAPSHOT_VERSION {
+			// Unreadable snapshot, try an older one
+			continue
+		}
+
+		m.producers = make(map[int64]*ProducerStateEntry)
+		for _, snapshotEntry := range snapshot.Entries {
+			lastOffset := int64(snapshotEntry.LastOffset)
+			entry := &ProducerStateEntry{
+				ProducerId:            int64(snapshotEntry.ProducerId),
+				ProducerEpoch:         int16(snapshotEntry.ProducerEpoch),
+				CoordinatorEpoch:      int32(snapshotEntry.CoordinatorEpoch),
+				CurrentTxnFirstOffset: int64(snapshotEntry.CurrentTxnFirstOffset),
+			}
+			if snapshotEntry.LastSequence != NO_SEQUENCE {
+				entry.batches = []ProducerBatchMetadata{{
+					FirstSequence:   int32(snapshotEntry.LastSequence) - int32(snapshotEntry.OffsetDelta),
+					LastSequence:    int32(snapshotEntry.LastSequence),
+					FirstOffset:     lastOffset - int64(snapshotEntry.OffsetDelta),
+					LastOffsetDelta: int32(snapshotEntry.OffsetDelta),
+					Timestamp:       int64(snapshotEntry.Timestamp),
+				}}
+			}
+			m.producers[entry.ProducerId] = entry
+		}
+		m.lastSnapshotOffset = offsets[i]
+		return offsets[i], nil
+	}
+
+	m.producers = make(map[int64]*ProducerStateEntry)
+	return 0, nil
+}
+
+// deleteSnapshotsAfter removes the snapshots taken past offset, e.g. of
+// batches truncated from the log.
+func (m *ProducerStateManager) deleteSnapshotsAfter(offset int64) error {
+	offsets, err := m.snapshotOffsets()
+	if err != nil {
+		return err
+	}
+	for _, snapshotOffset := range offsets {
+		if snapshotOffset <= offset {
+			continue
+		}
+		if err := os.Remove(filepath.Join(m.dir, producerSnapshotFileName(snapshotOffset))); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to delete producer snapshot: %w", err)
+		}
+	}
+	return nil
+}
+
+// takeSnapshot writes the current state as of logEndOffset and removes old snapshots.
+func (m *ProducerStateManager) takeSnapshot(logEndOffset int64) error {
+	snapshot := ProducerSnapshot{
+		Version: ktypes.Int16(PRODUCER_SNAPSHOT_VERSION),
+		Entries: []ProducerSnapshotEntry{},
+	}
+	producerIds := make([]int64, 0, len(m.producers))
+	for producerId := range m.producers {
+		producerIds = append(producerIds, producerId)
+	}
+	slices.Sort(producerIds)
+	for _, producerId := range producerIds {
+		entry := m.producers[producerId]
+		if len(entry.batches) == 0 && entry.CurrentTxnFirstOffset == NO_OFFSET {
+			continue
+		}
+		// Producers that only wrote without sequences still matter for their open transaction
+		last := ProducerBatchMetadata{FirstSequence: NO_SEQUENCE, LastSequence: NO_SEQUENCE, FirstOffset: NO_OFFSET}
+		if len(entry.batches) > 0 {
+			last = entry.batches[len(entry.batches)-1]
+		}
+		snapshot.Entries = append(snapshot.Entries, ProducerSnapshotEntry{
+			ProducerId:            ktypes.Int64(entry.ProducerId),
+			ProducerEpoch:         ktypes.Int16(entry.ProducerEpoch),
+			LastSequence:          ktypes.Int32(last.LastSequence),
+			LastOffset:            ktypes.Int64(last.FirstOffset + int64(last.LastOffsetDelta)),
+			OffsetDelta:           ktypes.Int32(last.LastOffsetDelta),
+			Timestamp:             ktypes.Int64(last.Timestamp),
+			CoordinatorEpoch:      ktypes.Int32(entry.CoordinatorEpoch),
+			CurrentTxnFirstOffset: ktypes.Int64(entry.CurrentTxnFirstOffset),
+		})
+	}
+
+	encoded, err := ktypes.NewKEncoder().Encode(&snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode producer snapshot: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a torn snapshot
+	path := filepath.Join(m.dir, producerSnapshotFileName(logEndOffset))
+	if err := os.WriteFile(path+".tmp", encoded, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	m.lastSnapshotOffset = logEndOffset
+	m.batchesSinceSnapshot = 0
+
+	offsets, err := m.snapshotOffsets()
+	if err != nil {
+		return err
+	}
+	for len(offsets) > PRODUCER_SNAPSHOTS_TO_KEEP {
+		if err := os.Remove(filepath.Join(m.dir, producerSnapshotFileName(offsets[0]))); err != nil {
+			return err
+		}
+		offsets = offsets[1:]
+	}
+	return nil
+}
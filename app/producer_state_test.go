@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+// producerTestHeader describes a batch of records records from producerId
+// at epoch, starting at firstSequence and written at baseOffset
+func producerTestHeader(producerId int64, epoch int16, firstSequence int32, records int32, baseOffset int64) *RecordBatchHeader {
+	return &RecordBatchHeader{
+		BaseOffset:      ktypes.Int64(baseOffset),
+		LastOffsetDelta: ktypes.Int32(records - 1),
+		ProducerId:      ktypes.Int64(producerId),
+		ProducerEpoch:   ktypes.Int16(epoch),
+		FirstSequence:   ktypes.Int32(firstSequence),
+		RecordCount:     ktypes.Int32(records),
+	}
+}
+
+func TestProducerSequenceValidation(t *testing.T) {
+	steps := []struct {
+		name          string
+		header        *RecordBatchHeader
+		wantError     ERROR_CODE
+		wantDuplicate int64
+	}{
+		{"first batch", producerTestHeader(1, 0, 0, 3, 0), ERROR_CODE_NONE, -1},
+		{"next batch", producerTestHeader(1, 0, 3, 2, 3), ERROR_CODE_NONE, -1},
+		{"retried batch", producerTestHeader(1, 0, 0, 3, 5), ERROR_CODE_DUPLICATE_SEQUENCE_NUMBER, 0},
+		{"retried last batch", producerTestHeader(1, 0, 3, 2, 5), ERROR_CODE_DUPLICATE_SEQUENCE_NUMBER, 3},
+		{"gap", producerTestHeader(1, 0, 7, 1, 5), ERROR_CODE_OUT_OF_ORDER_SEQUENCE_NUMBER, -1},
+		{"fenced epoch", producerTestHeader(1, -1, 5, 1, 5), ERROR_CODE_INVALID_PRODUCER_EPOCH, -1},
+		{"bumped epoch not from 0", producerTestHeader(1, 1, 5, 1, 5), ERROR_CODE_OUT_OF_ORDER_SEQUENCE_NUMBER, -1},
+		{"bumped epoch", producerTestHeader(1, 1, 0, 1, 5), ERROR_CODE_NONE, -1},
+		{"old epoch", producerTestHeader(1, 0, 5, 1, 6), ERROR_CODE_INVALID_PRODUCER_EPOCH, -1},
+		{"unknown producer not from 0", producerTestHeader(2, 0, 4, 1, 6), ERROR_CODE_OUT_OF_ORDER_SEQUENCE_NUMBER, -1},
+		{"other producer", producerTestHeader(2, 0, 0, 1, 6), ERROR_CODE_NONE, -1},
+		{"no producer", producerTestHeader(NO_PRODUCER_ID, NO_PRODUCER_EPOCH, NO_SEQUENCE, 1, 7), ERROR_CODE_NONE, -1},
+	}
+
+	m := newProducerStateManager(t.TempDir())
+	for _, step := range steps {
+		duplicate, err := m.validate(step.header)
+		if errorCode := errorCodeFromError(err); errorCode != step.wantError {
+			t.Fatalf("%s: got error %d (%v), want %d", step.name, errorCode, err, step.wantError)
+		}
+		if step.wantDuplicate >= 0 && (duplicate == nil || duplicate.FirstOffset != step.wantDuplicate) {
+			t.Fatalf("%s: got duplicate %+v, want one at offset %d", step.name, duplicate, step.wantDuplicate)
+		}
+		if err == nil {
+			m.update(step.header)
+		}
+	}
+}
+
+func TestProducerSequenceWrapsAround(t *testing.T) {
+	m := newProducerStateManager(t.TempDir())
+	m.update(producerTestHeader(1, 0, 0, 1, 0))
+	// Pretend the producer got to the largest sequence
+	m.producers[1].batches[0].LastSequence = math.MaxInt32
+
+	if _, err := m.validate(producerTestHeader(1, 0, 0, 1, 1)); err != nil {
+		t.Fatalf("sequence 0 after %d rejected: %v", math.MaxInt32, err)
+	}
+}
+
+func TestProducerStateSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	m := newProducerStateManager(dir)
+	m.update(producerTestHeader(1, 0, 0, 3, 0))
+	m.update(producerTestHeader(1, 0, 3, 2, 3))
+	m.update(producerTestHeader(2, 4, 0, 1, 5))
+	if err := m.takeSnapshot(6); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newProducerStateManager(dir)
+	snapshotOffset, err := restored.loadSnapshot(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshotOffset != 6 {
+		t.Fatalf("got snapshot offset %d, want 6", snapshotOffset)
+	}
+	// The last batch of each producer is still detected as a duplicate
+	duplicate, err := restored.validate(producerTestHeader(1, 0, 3, 2, 6))
+	if errorCodeFromError(err) != ERROR_CODE_DUPLICATE_SEQUENCE_NUMBER || duplicate.FirstOffset != 3 {
+		t.Fatalf("got duplicate %+v, %v, want the batch at 3", duplicate, err)
+	}
+	if _, err := restored.validate(producerTestHeader(2, 4, 1, 1, 6)); err != nil {
+		t.Fatalf("next batch of producer 2 rejected: %v", err)
+	}
+	// Snapshots past the log end are ignored
+	if snapshotOffset, err := newProducerStateManager(dir).loadSnapshot(5); err != nil || snapshotOffset != 0 {
+		t.Fatalf("got snapshot offset %d, %v below the snapshot, want 0", snapshotOffset, err)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DelayedOperation is work parked in the purgatory until it can complete
+// or its deadline passes. Like Kafka's delayed fetches and produces, the
+// operations are checked again on every log change, so waiting for records
+// or for the high watermark does not hold one of the request handlers.
+type DelayedOperation struct {
+	deadline time.Time
+	// tryComplete reports whether the operation can complete now
+	tryComplete func() bool
+	// onComplete finishes the operation, expired when its deadline passed
+	// before it could complete
+	onComplete func(expired bool)
+}
+
+// Purgatory holds the delayed operations, checked by a single goroutine.
+type Purgatory struct {
+	mu      sync.Mutex
+	added   []*DelayedOperation
+	wakeup  chan struct{}
+	started sync.Once
+}
+
+var purgatory = &Purgatory{wakeup: make(chan struct{}, 1)}
+
+// delayOperation parks an operation for up to timeout. It is checked once
+// in the purgatory before waiting for log changes.
+func delayOperation(timeout time.Duration, tryComplete func() bool, onComplete func(expired bool)) {
+	purgatory.started.Do(func() { go purgatory.run() })
+	purgatory.mu.Lock()
+	purgatory.added = append(purgatory.added, &DelayedOperation{
+		deadline:    time.Now().Add(timeout),
+		tryComplete: tryComplete,
+		onComplete:  onComplete,
+	})
+	purgatory.mu.Unlock()
+	select {
+	case purgatory.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// run checks the parked operations whenever a log changes, an operation is
+// added or the earliest deadline passes.
+func (p *Purgatory) run() {
+	var waiting []*DelayedOperation
+	timer := time.NewTimer(time.Hour)
+	for {
+		// Taken before the checks, a change during them is not missed
+		changed := logChangedChannel()
+		p.mu.Lock()
+		waiting = append(waiting, p.added...)
+		p.added = nil
+		p.mu.Unlock()
+
+		now := time.Now()
+		remaining := waiting[:0]
+		var nextDeadline time.Time
+		for _, op := range waiting {
+			if completed, expired := op.check(now); completed || expired {
+				op.complete(expired)
+				continue
+			}
+			remaining = append(remaining, op)
+			if nextDeadline.IsZero() || op.deadline.Before(nextDeadline) {
+				nextDeadline = op.deadline
+			}
+		}
+		clear(waiting[len(remaining):])
+		waiting = remaining
+
+		timer.Stop()
+		if !nextDeadline.IsZero() {
+			timer.Reset(time.Until(nextDeadline))
+		}
+		select {
+		case <-changed:
+		case <-p.wakeup:
+		case <-timer.C:
+		}
+	}
+}
+
+// check reports whether the operation completed or expired. A failing check
+// completes it, the failure is left to onComplete to report.
+func (op *DelayedOperation) check(now time.Time) (completed bool, expired bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("Error checking delayed operation: ", r)
+			completed = true
+		}
+	}()
+	if op.tryComplete() {
+		return true, false
+	}
+	return false, !now.Before(op.deadline)
+}
+
+func (op *DelayedOperation) complete(expired bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("Error completing delayed operation: ", r)
+		}
+	}()
+	op.onComplete(expired)
+}
+
+// delayResponse parks a request rather than have its handler wait. The
+// response is the first non-nil one tryComplete returns, or onExpire's when
+// timeout passes first. A request that cannot wait gets onExpire's response
+// right away. The request_percentage quota is charged for building the
+// response, not for the wait.
+func delayResponse(req *Request, timeout time.Duration, tryComplete func() *Response, onExpire func() *Response) *Response {
+	if timeout <= 0 {
+		return onExpire()
+	}
+	return &Response{delayed: func(respond func(*Response)) {
+		var res *Response
+		var elapsed time.Duration
+		failed := true
+		delayOperation(timeout, func() bool {
+			start := time.Now()
+			res = tryComplete()
+			elapsed = time.Since(start)
+			return res != nil
+		}, func(expired bool) {
+			// A panic in the checks or onExpire fails the request like one in
+			// its handler
+			defer func() {
+				if failed {
+					req.Session.closeConnection = true
+					respond(nil)
+				}
+			}()
+			if expired {
+				start := time.Now()
+				res = onExpire()
+				elapsed = time.Since(start)
+			}
+			if !req.QuotaExempt {
+				recordRequestTime(req, elapsed)
+			}
+			if res != nil {
+				respond(res)
+				failed = false
+			}
+		})
+	}}
+}
+
+// thenResponse applies f to the response of a request, once it is built
+// for a parked one.
+func thenResponse(res *Response, f func(*Response) *Response) *Response {
+	if res == nil || res.delayed == nil {
+		return f(res)
+	}
+	return &Response{delayed: func(respond func(*Response)) {
+		res.delayed(func(completed *Response) {
+			respond(f(completed))
+		})
+	}}
+}
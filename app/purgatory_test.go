@@ -0,0 +1,109 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// purgatoryTestResult waits for the result sent by a delayed operation
+func purgatoryTestResult[T any](t *testing.T, results <-chan T) T {
+	t.Helper()
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("delayed operation never completed")
+	}
+	var zero T
+	return zero
+}
+
+func TestDelayOperation(t *testing.T) {
+	var ready atomic.Bool
+	completed := make(chan bool, 1)
+	delayOperation(time.Minute, ready.Load, func(expired bool) { completed <- expired })
+	select {
+	case <-completed:
+		t.Fatal("operation completed before it could")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// Operations are checked again when a log changes
+	ready.Store(true)
+	notifyLogChanged()
+	if expired := purgatoryTestResult(t, completed); expired {
+		t.Error("got an expired operation, want it completed")
+	}
+
+	expiring := make(chan bool, 1)
+	delayOperation(20*time.Millisecond, func() bool { return false }, func(expired bool) { expiring <- expired })
+	if expired := purgatoryTestResult(t, expiring); !expired {
+		t.Error("got a completed operation past its deadline, want it expired")
+	}
+}
+
+func TestDelayResponse(t *testing.T) {
+	req := &Request{CorrelationId: 7, Session: &ClientSession{}}
+	expire := func() *Response { return &Response{CorrelationId: -1} }
+
+	// A request that cannot wait gets its response from the handler
+	if res := delayResponse(req, 0, func() *Response { return nil }, expire); res.delayed != nil || res.CorrelationId != -1 {
+		t.Errorf("got response %+v without a timeout, want the expired one", res)
+	}
+
+	var ready atomic.Bool
+	res := delayResponse(req, time.Minute, func() *Response {
+		if !ready.Load() {
+			return nil
+		}
+		return &Response{CorrelationId: req.CorrelationId}
+	}, expire)
+	res = thenResponse(res, func(res *Response) *Response {
+		return &Response{CorrelationId: res.CorrelationId + 1}
+	})
+	if res.delayed == nil {
+		t.Fatal("got a response while the request has to wait")
+	}
+	responses := make(chan *Response, 1)
+	res.delayed(func(res *Response) { responses <- res })
+	ready.Store(true)
+	notifyLogChanged()
+	if got := purgatoryTestResult(t, responses); got == nil || got.CorrelationId != 8 {
+		t.Errorf("got response %+v, want the completed one mapped", got)
+	}
+
+	// A failing check fails the request like a failing handler
+	failing := delayResponse(req, time.Minute, func() *Response { panic("check failed") }, expire)
+	failing.delayed(func(res *Response) { responses <- res })
+	if got := purgatoryTestResult(t, responses); got != nil || !req.Session.closeConnection {
+		t.Errorf("got response %+v, connection closed %v, want the request failed", got, req.Session.closeConnection)
+	}
+}
+
+func TestDelayResponseChargesProcessingTime(t *testing.T) {
+	setTestClientQuotas(t, map[ClientQuotaEntity]map[string]float64{
+		{HasUser: true, User: "parked"}: {QUOTA_REQUEST_PERCENTAGE: 1},
+	})
+	quotaSensorsMu.Lock()
+	previousSensors := quotaSensors
+	quotaSensors = make(map[quotaSensorKey]*RateSensor)
+	quotaSensorsMu.Unlock()
+	t.Cleanup(func() {
+		quotaSensorsMu.Lock()
+		defer quotaSensorsMu.Unlock()
+		quotaSensors = previousSensors
+	})
+
+	// The wait is not charged, 200ms of it would call for throttling
+	req := &Request{Session: &ClientSession{Principal: "User:parked"}}
+	res := delayResponse(req, 200*time.Millisecond, func() *Response { return nil }, func() *Response { return &Response{} })
+	responses := make(chan *Response, 1)
+	res.delayed(func(res *Response) { responses <- res })
+	purgatoryTestResult(t, responses)
+	if throttleTimeMs := recordRequestTime(req, 0); throttleTimeMs != 0 {
+		t.Errorf("got throttled %dms after a parked request, want no throttling", throttleTimeMs)
+	}
+	if throttleTimeMs := recordRequestTime(req, 200*time.Millisecond); throttleTimeMs == 0 {
+		t.Error("got no throttling for 200ms of processing")
+	}
+}
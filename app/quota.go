@@ -0,0 +1,409 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	QUOTA_ENTITY_USER      = "user"
+	QUOTA_ENTITY_CLIENT_ID = "client-id"
+
+	QUOTA_PRODUCER_BYTE_RATE = "producer_byte_rate"
+	QUOTA_CONSUMER_BYTE_RATE = "consumer_byte_rate"
+	QUOTA_REQUEST_PERCENTAGE = "request_percentage"
+)
+
+type ClientQuotaRecordEntity struct {
+	EntityType   ktypes.CompactString         `order:"1"`
+	EntityName   ktypes.CompactNullableString `order:"2"`
+	TaggedFields ktypes.TaggedFields          `order:"3"`
+}
+
+type ClientQuotaRecordValue struct {
+	Header       RecordValueHeader                            `order:"1"`
+	Entity       ktypes.CompactArray[ClientQuotaRecordEntity] `order:"2"`
+	Key          ktypes.CompactString                         `order:"3"`
+	Value        ktypes.Float64                               `order:"4"`
+	Remove       ktypes.Bool                                  `order:"5"`
+	TaggedFields ktypes.TaggedFields                          `order:"6"`
+}
+
+// ClientQuotaEntity is what a quota applies to: a user, a client id, or a
+// client id of a user. An empty name stands for the default entity.
+type ClientQuotaEntity struct {
+	HasUser     bool
+	User        string
+	HasClientId bool
+	ClientId    string
+}
+
+// Quota values by entity and key, guarded by metadataMu
+var clientQuotas = make(map[ClientQuotaEntity]map[string]float64)
+
+func isQuotaKey(key string) bool {
+	switch key {
+	case QUOTA_PRODUCER_BYTE_RATE, QUOTA_CONSUMER_BYTE_RATE, QUOTA_REQUEST_PERCENTAGE:
+		return true
+	}
+	return false
+}
+
+// quotaEntityFromRecord converts the entity of a quota record or request.
+func quotaEntityFromRecord(components []ClientQuotaRecordEntity) (ClientQuotaEntity, error) {
+	var entity ClientQuotaEntity
+	for _, component := range components {
+		switch string(component.EntityType) {
+		case QUOTA_ENTITY_USER:
+			if entity.HasUser {
+				return entity, fmt.Errorf("duplicate user entity")
+			}
+			entity.HasUser = true
+			entity.User = string(component.EntityName)
+		case QUOTA_ENTITY_CLIENT_ID:
+			if entity.HasClientId {
+				return entity, fmt.Errorf("duplicate client-id entity")
+			}
+			entity.HasClientId = true
+			entity.ClientId = string(component.EntityName)
+		default:
+			return entity, fmt.Errorf("unsupported quota entity type %s", component.EntityType)
+		}
+	}
+	if !entity.HasUser && !entity.HasClientId {
+		return entity, fmt.Errorf("quota entity must not be empty")
+	}
+	return entity, nil
+}
+
+// recordComponents is the inverse of quotaEntityFromRecord.
+func (e ClientQuotaEntity) recordComponents() []ClientQuotaRecordEntity {
+	components := make([]ClientQuotaRecordEntity, 0, 2)
+	if e.HasUser {
+		components = append(components, ClientQuotaRecordEntity{
+			EntityType: ktypes.CompactString(QUOTA_ENTITY_USER),
+			EntityName: ktypes.CompactNullableString(e.User),
+		})
+	}
+	if e.HasClientId {
+		components = append(components, ClientQuotaRecordEntity{
+			EntityType: ktypes.CompactString(QUOTA_ENTITY_CLIENT_ID),
+			EntityName: ktypes.CompactNullableString(e.ClientId),
+		})
+	}
+	return components
+}
+
+func applyClientQuotaRecord(record *ClientQuotaRecordValue) {
+	entity, err := quotaEntityFromRecord(record.Entity)
+	if err != nil {
+		fmt.Println("Ignoring invalid client quota record: ", err.Error())
+		return
+	}
+	key := string(record.Key)
+	if record.Remove {
+		delete(clientQuotas[entity], key)
+		if len(clientQuotas[entity]) == 0 {
+			delete(clientQuotas, entity)
+		}
+		return
+	}
+	if clientQuotas[entity] == nil {
+		clientQuotas[entity] = make(map[string]float64)
+	}
+	clientQuotas[entity][key] = float64(record.Value)
+}
+
+// ClientQuotaAlteration sets, or removes, one quota value of an entity.
+type ClientQuotaAlteration struct {
+	Key    string
+	Value  float64
+	Remove bool
+}
+
+// validateClientQuotaAlterations returns the error for an invalid change.
+func validateClientQuotaAlterations(alterations []ClientQuotaAlteration) *KafkaError {
+	seen := make(map[string]bool)
+	for _, alteration := range alterations {
+		if !isQuotaKey(alteration.Key) {
+			return newKafkaError(ERROR_CODE_INVALID_REQUEST, "unsupported quota key %s", alteration.Key)
+		}
+		if seen[alteration.Key] {
+			return newKafkaError(ERROR_CODE_INVALID_REQUEST, "quota key %s is altered twice", alteration.Key)
+		}
+		seen[alteration.Key] = true
+		if !alteration.Remove && alteration.Value <= 0 {
+			return newKafkaError(ERROR_CODE_INVALID_REQUEST, "quota %s must be positive", alteration.Key)
+		}
+	}
+	return nil
+}
+
+// alterClientQuotas writes the changes of one entity to the metadata log.
+func alterClientQuotas(entity ClientQuotaEntity, alterations []ClientQuotaAlteration, validateOnly bool) error {
+	if err := validateClientQuotaAlterations(alterations); err != nil {
+		return err
+	}
+	if validateOnly {
+		return nil
+	}
+
+	metadataWriteMu.Lock()
+	defer metadataWriteMu.Unlock()
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	for _, alteration := range alterations {
+		err := appendMetadataRecord(&ClientQuotaRecordValue{
+			Header: RecordValueHeader{
+				FrameVersion: ktypes.Int8(METADATA_RECORD_FRAME_VERSION),
+				RecordType:   ktypes.Int8(CLIENT_QUOTA_RECORD_TYPE),
+				Version:      ktypes.Int8(0),
+			},
+			Entity: entity.recordComponents(),
+			Key:    ktypes.CompactString(alteration.Key),
+			Value:  ktypes.Float64(alteration.Value),
+			Remove: ktypes.Bool(alteration.Remove),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClientQuotaFilterComponent restricts one entity type of DescribeClientQuotas.
+type ClientQuotaFilterComponent struct {
+	EntityType string
+	MatchType  int8
+	Match      string
+}
+
+const (
+	QUOTA_MATCH_TYPE_EXACT   = 0
+	QUOTA_MATCH_TYPE_DEFAULT = 1
+	QUOTA_MATCH_TYPE_ANY     = 2
+)
+
+// matchesEntityPart checks one dimension of an entity against the filter
+// component for it, if any.
+func matchesEntityPart(components []ClientQuotaFilterComponent, entityType string, has bool, name string, strict bool) bool {
+	for _, component := range components {
+		if component.EntityType != entityType {
+			continue
+		}
+		if !has {
+			return false
+		}
+		switch component.MatchType {
+		case QUOTA_MATCH_TYPE_EXACT:
+			return name == component.Match
+		case QUOTA_MATCH_TYPE_DEFAULT:
+			return name == ""
+		}
+		return true
+	}
+	// Without strict matching, entities may have more parts than filtered on
+	return !has || !strict
+}
+
+// describeClientQuotas returns the quotas of the entities matching the filter.
+func describeClientQuotas(components []ClientQuotaFilterComponent, strict bool) map[ClientQuotaEntity]map[string]float64 {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	matching := make(map[ClientQuotaEntity]map[string]float64)
+	for entity, values := range clientQuotas {
+		if !matchesEntityPart(components, QUOTA_ENTITY_USER, entity.HasUser, entity.User, strict) ||
+			!matchesEntityPart(components, QUOTA_ENTITY_CLIENT_ID, entity.HasClientId, entity.ClientId, strict) {
+			continue
+		}
+		copied := make(map[string]float64, len(values))
+		for key, value := range values {
+			copied[key] = value
+		}
+		matching[entity] = copied
+	}
+	return matching
+}
+
+// quotaFor returns the quota that applies to a client and the entity usage
+// is measured against, in the precedence order Kafka uses. Usage is tracked
+// per actual user and client id, over the dimensions of the matching quota.
+func quotaFor(user string, clientId string, key string) (ClientQuotaEntity, float64, bool) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	candidates := []ClientQuotaEntity{
+		{HasUser: true, User: user, HasClientId: true, ClientId: clientId},
+		{HasUser: true, User: user, HasClientId: true},
+		{HasUser: true, User: user},
+		{HasUser: true, HasClientId: true, ClientId: clientId},
+		{HasUser: true, HasClientId: true},
+		{HasUser: true},
+		{HasClientId: true, ClientId: clientId},
+		{HasClientId: true},
+	}
+	for _, candidate := range candidates {
+		if value, ok := clientQuotas[candidate][key]; ok {
+			measured := ClientQuotaEntity{HasUser: candidate.HasUser, HasClientId: candidate.HasClientId}
+			if candidate.HasUser {
+				measured.User = user
+			}
+			if candidate.HasClientId {
+				measured.ClientId = clientId
+			}
+			return measured, value, true
+		}
+	}
+	return ClientQuotaEntity{}, 0, false
+}
+
+// RateSensor measures a rate over a sliding window of samples.
+type RateSensor struct {
+	sampleStarts [QUOTA_WINDOW_NUM]int64
+	sampleValues [QUOTA_WINDOW_NUM]float64
+	lastRecordMs int64
+}
+
+func (s *RateSensor) record(value float64, nowMs int64) {
+	sampleStart := nowMs - nowMs%QUOTA_WINDOW_SIZE_MS
+	i := (nowMs / QUOTA_WINDOW_SIZE_MS) % QUOTA_WINDOW_NUM
+	if s.sampleStarts[i] != sampleStart {
+		s.sampleStarts[i] = sampleStart
+		s.sampleValues[i] = 0
+	}
+	s.sampleValues[i] += value
+	s.lastRecordMs = nowMs
+}
+
+// rate returns the per second rate over the samples still in the window.
+// The window is never considered shorter than all but one full sample, so
+// a burst right after startup is not judged over a few milliseconds.
+func (s *RateSensor) rate(nowMs int64) float64 {
+	total := 0.0
+	oldest := nowMs
+	for i := range s.sampleStarts {
+		if s.sampleStarts[i] > nowMs-QUOTA_WINDOW_NUM*QUOTA_WINDOW_SIZE_MS {
+			total += s.sampleValues[i]
+			oldest = min(oldest, s.sampleStarts[i])
+		}
+	}
+	elapsedMs := max(nowMs-oldest, (QUOTA_WINDOW_NUM-1)*QUOTA_WINDOW_SIZE_MS)
+	return total * 1000 / float64(elapsedMs)
+}
+
+// throttleTimeMs returns how long the client must wait for its rate to
+// come back under the quota.
+func (s *RateSensor) throttleTimeMs(quota float64, nowMs int64) int32 {
+	rate := s.rate(nowMs)
+	if rate <= quota {
+		return 0
+	}
+	windowMs := float64(QUOTA_WINDOW_NUM * QUOTA_WINDOW_SIZE_MS)
+	return int32(min((rate-quota)/quota*windowMs, QUOTA_MAX_THROTTLE_TIME_MS))
+}
+
+type quotaSensorKey struct {
+	Entity ClientQuotaEntity
+	Key    string
+}
+
+// Usage of each quota entity, guarded by quotaSensorsMu
+var (
+	quotaSensorsMu sync.Mutex
+	quotaSensors   = make(map[quotaSensorKey]*RateSensor)
+)
+
+// quotaUser is the user name quotas are set for, the principal without its type.
+func quotaUser(principal string) string {
+	_, name, _ := strings.Cut(principal, ":")
+	return name
+}
+
+// recordQuotaUsage adds to the client's usage of a quota and returns the
+// throttle time it earned, 0 when no quota applies.
+func recordQuotaUsage(req *Request, key string, value float64) int32 {
+	entity, quota, ok := quotaFor(quotaUser(req.Session.Principal), string(req.ClientId), key)
+	if !ok {
+		return 0
+	}
+
+	quotaSensorsMu.Lock()
+	defer quotaSensorsMu.Unlock()
+
+	sensorKey := quotaSensorKey{Entity: entity, Key: key}
+	sensor, ok := quotaSensors[sensorKey]
+	if !ok {
+		sensor = &RateSensor{}
+		quotaSensors[sensorKey] = sensor
+	}
+	nowMs := time.Now().UnixMilli()
+	sensor.record(value, nowMs)
+	return sensor.throttleTimeMs(quota, nowMs)
+}
+
+// recordRequestTime charges the time spent handling a request against the
+// request_percentage quota, a percentage of one handler's time.
+func recordRequestTime(req *Request, elapsed time.Duration) int32 {
+	return recordQuotaUsage(req, QUOTA_REQUEST_PERCENTAGE, elapsed.Seconds()*100)
+}
+
+// isQuotaExempt reports whether a request is never throttled. Like in
+// Kafka, clients must be able to connect and authenticate whatever their
+// usage, and the traffic between brokers and controllers must not be held
+// back by client quotas: requests on the inter-broker listener, and the
+// inter-broker requests of principals authorized for CLUSTER_ACTION.
+func isQuotaExempt(req *Request) bool {
+	switch {
+	case isAllowedBeforeAuthentication(int16(req.RequestApiKey)):
+		return true
+	case req.Session.Listener.Name == brokerConfig.InterBrokerListenerName:
+		return true
+	case isClusterActionRequest(req):
+		return authorize(req, ACL_OPERATION_CLUSTER_ACTION, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME)
+	}
+	return false
+}
+
+// isClusterActionRequest reports whether a request is one brokers and
+// controllers send each other: raft, broker lifecycle and replication
+// requests, and the fetches of followers.
+func isClusterActionRequest(req *Request) bool {
+	switch req.RequestApiKey {
+	case VOTE_REQUEST_KEY, BEGIN_QUORUM_EPOCH_REQUEST_KEY, END_QUORUM_EPOCH_REQUEST_KEY, FETCH_SNAPSHOT_REQUEST_KEY,
+		BROKER_REGISTRATION_REQUEST_KEY, BROKER_HEARTBEAT_REQUEST_KEY, ALTER_PARTITION_REQUEST_KEY,
+		ALLOCATE_PRODUCER_IDS_REQUEST_KEY, ASSIGN_REPLICAS_TO_DIRS_REQUEST_KEY, ENVELOPE_REQUEST_KEY:
+		return true
+	case FETCH_REQUEST_KEY:
+		requestBody, err := parseFetchRequestBody(req.Body)
+		if err != nil {
+			return false
+		}
+		replicaId, err := fetchReplicaId(requestBody)
+		return err == nil && replicaId >= 0
+	}
+	return false
+}
+
+// expireQuotaSensors forgets the usage of clients idle for a while.
+func expireQuotaSensors(nowMs int64) {
+	quotaSensorsMu.Lock()
+	defer quotaSensorsMu.Unlock()
+
+	for key, sensor := range quotaSensors {
+		if nowMs-sensor.lastRecordMs > QUOTA_SENSOR_EXPIRY_MS {
+			delete(quotaSensors, key)
+		}
+	}
+}
+
+func startQuotaSensorExpiryTask() {
+	runPeriodicTask(QUOTA_SENSOR_EXPIRY_MS*time.Millisecond, func() {
+		expireQuotaSensors(time.Now().UnixMilli())
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+// setTestClientQuotas replaces the client quotas until the test ends
+func setTestClientQuotas(t *testing.T, quotas map[ClientQuotaEntity]map[string]float64) {
+	t.Helper()
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	previousQuotas := clientQuotas
+	clientQuotas = quotas
+	t.Cleanup(func() {
+		metadataMu.Lock()
+		defer metadataMu.Unlock()
+		clientQuotas = previousQuotas
+	})
+}
+
+func TestRateSensor(t *testing.T) {
+	start := int64(1_000_000)
+
+	var burst RateSensor
+	burst.record(10000, start)
+	// A burst is spread over the window rather than the time since it began
+	if rate := burst.rate(start); rate != 1000 {
+		t.Errorf("got rate %v after a burst, want 1000", rate)
+	}
+	if throttle := burst.throttleTimeMs(1000, start); throttle != 0 {
+		t.Errorf("got throttle %d at the quota, want 0", throttle)
+	}
+	if throttle := burst.throttleTimeMs(800, start); throttle != 2750 {
+		t.Errorf("got throttle %d, want 2750", throttle)
+	}
+	if throttle := burst.throttleTimeMs(100, start); throttle != QUOTA_MAX_THROTTLE_TIME_MS {
+		t.Errorf("got throttle %d, want the maximum %d", throttle, QUOTA_MAX_THROTTLE_TIME_MS)
+	}
+	// Samples leave the window once it moved past them
+	if rate := burst.rate(start + QUOTA_WINDOW_NUM*QUOTA_WINDOW_SIZE_MS); rate != 0 {
+		t.Errorf("got rate %v once the burst left the window, want 0", rate)
+	}
+
+	var steady RateSensor
+	for i := int64(0); i < 30; i++ {
+		steady.record(500, start+i*QUOTA_WINDOW_SIZE_MS/2)
+	}
+	if rate := steady.rate(start + 15*QUOTA_WINDOW_SIZE_MS); math.Abs(rate-1000) > 100 {
+		t.Errorf("got rate %v for 1000 per second, want about 1000", rate)
+	}
+}
+
+func TestQuotaForPrecedence(t *testing.T) {
+	quotas := map[ClientQuotaEntity]map[string]float64{
+		{HasUser: true, User: "alice", HasClientId: true, ClientId: "c1"}: {QUOTA_PRODUCER_BYTE_RATE: 100},
+		{HasUser: true, User: "alice"}:                                    {QUOTA_PRODUCER_BYTE_RATE: 200},
+		{HasClientId: true, ClientId: "c2"}:                               {QUOTA_PRODUCER_BYTE_RATE: 400},
+		{HasClientId: true}:                                               {QUOTA_PRODUCER_BYTE_RATE: 500, QUOTA_CONSUMER_BYTE_RATE: 50},
+	}
+	setTestClientQuotas(t, quotas)
+
+	tests := []struct {
+		user         string
+		clientId     string
+		key          string
+		wantValue    float64
+		wantMeasured ClientQuotaEntity
+	}{
+		{"alice", "c1", QUOTA_PRODUCER_BYTE_RATE, 100, ClientQuotaEntity{HasUser: true, User: "alice", HasClientId: true, ClientId: "c1"}},
+		{"alice", "c2", QUOTA_PRODUCER_BYTE_RATE, 200, ClientQuotaEntity{HasUser: true, User: "alice"}},
+		{"bob", "c2", QUOTA_PRODUCER_BYTE_RATE, 400, ClientQuotaEntity{HasClientId: true, ClientId: "c2"}},
+		// The default client id quota is measured per client id
+		{"bob", "c3", QUOTA_PRODUCER_BYTE_RATE, 500, ClientQuotaEntity{HasClientId: true, ClientId: "c3"}},
+		{"alice", "c1", QUOTA_CONSUMER_BYTE_RATE, 50, ClientQuotaEntity{HasClientId: true, ClientId: "c1"}},
+	}
+	for _, test := range tests {
+		measured, value, ok := quotaFor(test.user, test.clientId, test.key)
+		if !ok || value != test.wantValue || measured != test.wantMeasured {
+			t.Errorf("%s/%s %s: got %v, %+v, %v, want %v, %+v", test.user, test.clientId, test.key, value, measured, ok, test.wantValue, test.wantMeasured)
+		}
+	}
+	if _, _, ok := quotaFor("alice", "c1", QUOTA_REQUEST_PERCENTAGE); ok {
+		t.Errorf("got a request_percentage quota, want none")
+	}
+
+	// A default user quota comes before any client id quota
+	quotas[ClientQuotaEntity{HasUser: true}] = map[string]float64{QUOTA_PRODUCER_BYTE_RATE: 300}
+	measured, value, _ := quotaFor("bob", "c2", QUOTA_PRODUCER_BYTE_RATE)
+	if value != 300 || measured != (ClientQuotaEntity{HasUser: true, User: "bob"}) {
+		t.Errorf("got %v, %+v, want the default user quota measured for bob", value, measured)
+	}
+}
+
+func TestDescribeClientQuotas(t *testing.T) {
+	setTestClientQuotas(t, map[ClientQuotaEntity]map[string]float64{
+		{HasUser: true, User: "alice"}:                                    {QUOTA_PRODUCER_BYTE_RATE: 1},
+		{HasUser: true, User: "alice", HasClientId: true, ClientId: "c1"}: {QUOTA_PRODUCER_BYTE_RATE: 2},
+		{HasUser: true}:                     {QUOTA_PRODUCER_BYTE_RATE: 3},
+		{HasClientId: true, ClientId: "c1"}: {QUOTA_PRODUCER_BYTE_RATE: 4},
+	})
+
+	tests := []struct {
+		name       string
+		components []ClientQuotaFilterComponent
+		strict     bool
+		want       []float64
+	}{
+		{"exact user", []ClientQuotaFilterComponent{{EntityType: QUOTA_ENTITY_USER, MatchType: QUOTA_MATCH_TYPE_EXACT, Match: "alice"}}, false, []float64{1, 2}},
+		{"exact user strict", []ClientQuotaFilterComponent{{EntityType: QUOTA_ENTITY_USER, MatchType: QUOTA_MATCH_TYPE_EXACT, Match: "alice"}}, true, []float64{1}},
+		{"default user", []ClientQuotaFilterComponent{{EntityType: QUOTA_ENTITY_USER, MatchType: QUOTA_MATCH_TYPE_DEFAULT}}, false, []float64{3}},
+		{"any user", []ClientQuotaFilterComponent{{EntityType: QUOTA_ENTITY_USER, MatchType: QUOTA_MATCH_TYPE_ANY}}, true, []float64{1, 3}},
+		{"client id", []ClientQuotaFilterComponent{{EntityType: QUOTA_ENTITY_CLIENT_ID, MatchType: QUOTA_MATCH_TYPE_EXACT, Match: "c1"}}, false, []float64{2, 4}},
+		{"everything", nil, false, []float64{1, 2, 3, 4}},
+	}
+	for _, test := range tests {
+		matching := describeClientQuotas(test.components, test.strict)
+		got := make(map[float64]bool)
+		for _, values := range matching {
+			got[values[QUOTA_PRODUCER_BYTE_RATE]] = true
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got quotas %v, want %v", test.name, got, test.want)
+			continue
+		}
+		for _, value := range test.want {
+			if !got[value] {
+				t.Errorf("%s: got quotas %v, want %v", test.name, got, test.want)
+			}
+		}
+	}
+}
+
+func TestIsQuotaExempt(t *testing.T) {
+	previousConfig, previousAuthorizer := brokerConfig, authorizer
+	t.Cleanup(func() { brokerConfig, authorizer = previousConfig, previousAuthorizer })
+	brokerConfig.InterBrokerListenerName = "INTERNAL"
+	authorizer = &StandardAuthorizer{SuperUsers: []string{"User:broker"}}
+	setTestAcls(t)
+
+	replicaState, err := ktypes.NewKEncoder().Encode(&FetchRequestReplicaState{ReplicaId: 2, ReplicaEpoch: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicaFetch, err := ktypes.NewKEncoder().Encode(&FetchRequestBody{TaggedFields: ktypes.TaggedFieldValues{FETCH_REPLICA_STATE_TAG: replicaState}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumerFetch, err := ktypes.NewKEncoder().Encode(&FetchRequestBody{TaggedFields: ktypes.TaggedFieldValues{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		apiKey    ktypes.Int16
+		body      []byte
+		listener  string
+		principal string
+		want      bool
+	}{
+		{"before authentication", API_VERSIONS_REQUEST_KEY, nil, "CLIENT", "User:alice", true},
+		{"client request", METADATA_REQUEST_KEY, nil, "CLIENT", "User:alice", false},
+		{"inter-broker listener", METADATA_REQUEST_KEY, nil, "INTERNAL", "User:alice", true},
+		{"raft request of a broker", VOTE_REQUEST_KEY, nil, "CLIENT", "User:broker", true},
+		{"heartbeat of a broker", BROKER_HEARTBEAT_REQUEST_KEY, nil, "CLIENT", "User:broker", true},
+		{"raft request of a client", VOTE_REQUEST_KEY, nil, "CLIENT", "User:alice", false},
+		{"client request of a broker", METADATA_REQUEST_KEY, nil, "CLIENT", "User:broker", false},
+		{"replica fetch", FETCH_REQUEST_KEY, replicaFetch, "CLIENT", "User:broker", true},
+		{"consumer fetch", FETCH_REQUEST_KEY, consumerFetch, "CLIENT", "User:broker", false},
+	}
+	for _, test := range tests {
+		req := &Request{
+			RequestApiKey: test.apiKey,
+			Body:          test.body,
+			ClientHost:    "10.0.0.1:40000",
+			Session:       &ClientSession{Listener: Listener{Name: test.listener}, Principal: test.principal, Authenticated: true},
+		}
+		if got := isQuotaExempt(req); got != test.want {
+			t.Errorf("%s: got exempt %v, want %v", test.name, got, test.want)
+		}
+	}
+}
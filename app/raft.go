@@ -0,0 +1,1130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	QUORUM_STATE_FILE = "quorum-state"
+
+	// Versions of the requests controllers send each other
+	VOTE_VERSION               = 0
+	BEGIN_QUORUM_EPOCH_VERSION = 1
+	END_QUORUM_EPOCH_VERSION   = 1
+	FETCH_SNAPSHOT_VERSION     = 0
+
+	// Longest a metadata fetch waits at the leader for new records
+	QUORUM_FETCH_MAX_WAIT_MS = 500
+	QUORUM_RETRY_BACKOFF_MS  = 100
+
+	NO_VOTE = -1
+)
+
+// Roles of a node in the metadata quorum. Unattached nodes know of no
+// leader in their epoch, observers are brokers that are not voters and
+// follow the leader without ever being candidates.
+const (
+	QUORUM_ROLE_UNATTACHED int8 = iota
+	QUORUM_ROLE_FOLLOWER
+	QUORUM_ROLE_CANDIDATE
+	QUORUM_ROLE_LEADER
+)
+
+// The quorum-state file of the metadata log folder, as written by Kafka
+type QuorumStateData struct {
+	ClusterId     string             `json:"clusterId"`
+	LeaderId      int32              `json:"leaderId"`
+	LeaderEpoch   int32              `json:"leaderEpoch"`
+	VotedId       int32              `json:"votedId"`
+	AppliedOffset int64              `json:"appliedOffset"`
+	CurrentVoters []QuorumStateVoter `json:"currentVoters"`
+	DataVersion   int                `json:"data_version"`
+}
+
+type QuorumStateVoter struct {
+	VoterId int32 `json:"voterId"`
+}
+
+// VoterState is what the leader knows of another voter.
+type VoterState struct {
+	// Offset the voter last fetched from, -1 until it fetches
+	endOffset       int64
+	lastFetchTimeMs int64
+	// BeginQuorumEpoch is sent again to voters that stop fetching
+	lastBeginQuorumEpochMs int64
+}
+
+// RaftClient replicates the metadata log between the controllers of the
+// quorum, following KIP-595. Voters elect a leader, the active controller,
+// which alone appends records. Records are committed, and applied to the
+// in-memory metadata, once a majority of voters has them, the high
+// watermark of the metadata log.
+type RaftClient struct {
+	mu       sync.Mutex
+	log      *PartitionLog
+	role     int8
+	epoch    int32
+	leaderId int32
+	votedId  int32
+
+	// Voters that granted their vote to this candidate
+	votes map[int32]bool
+	// Other voters of this leader, and the offset its epoch starts at
+	voterStates      map[int32]*VoterState
+	epochStartOffset int64
+	// Voters that know of no leader become candidates past this deadline
+	electionDeadline time.Time
+	// Last time a follower heard from its leader
+	lastFetchTimeMs int64
+
+	// Connection of the fetch loop, and the voter observers fetch from
+	// while they know of no leader
+	client         *BrokerClient
+	observerTarget int
+
+	wakeup  chan struct{}
+	closed  bool
+	stopped chan struct{}
+}
+
+var raftClient *RaftClient
+
+// quorumVoterIds returns the voters of the metadata quorum, this node alone
+// when none are configured.
+func quorumVoterIds() []int32 {
+	if len(brokerConfig.ControllerQuorumVoters) == 0 {
+		return []int32{int32(brokerConfig.NodeId)}
+	}
+	ids := make([]int32, 0, len(brokerConfig.ControllerQuorumVoters))
+	for id := range brokerConfig.ControllerQuorumVoters {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func isQuorumVoter(id int32) bool {
+	return slices.Contains(quorumVoterIds(), id)
+}
+
+// quorumMajority returns how many voters elect a leader and commit records.
+func quorumMajority() int {
+	return len(quorumVoterIds())/2 + 1
+}
+
+// initRaftClient restores the quorum state of the metadata log. A node that
+// led before a restart does not resume its leadership, and a single voter
+// elects itself right away.
+func initRaftClient() error {
+	log, err := getPartitionLog(METADATA_TOPIC, 0)
+	if err != nil {
+		return err
+	}
+	r := &RaftClient{
+		log:      log,
+		leaderId: NO_LEADER,
+		votedId:  NO_VOTE,
+		wakeup:   make(chan struct{}, 1),
+		stopped:  make(chan struct{}),
+	}
+
+	state, err := readQuorumState(filepath.Join(log.dir, QUORUM_STATE_FILE))
+	if err != nil {
+		return err
+	}
+	if state != nil {
+		r.epoch, r.leaderId, r.votedId = state.LeaderEpoch, state.LeaderId, state.VotedId
+	}
+	if latestEpoch := log.LatestEpoch(); latestEpoch > r.epoch {
+		r.epoch, r.leaderId, r.votedId = latestEpoch, NO_LEADER, NO_VOTE
+	}
+	nodeId := int32(brokerConfig.NodeId)
+	if r.leaderId == nodeId {
+		r.leaderId, r.votedId = NO_LEADER, nodeId
+	}
+	if r.leaderId != NO_LEADER {
+		r.role = QUORUM_ROLE_FOLLOWER
+		r.lastFetchTimeMs = time.Now().UnixMilli()
+	}
+
+	// Only records a majority of voters has are committed
+	highWatermark, ok := highWatermarks[log.dir]
+	if !ok {
+		highWatermark = 0
+	}
+	log.setReplicated(true, highWatermark)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resetElectionTimer()
+	r.writeQuorumState()
+	if len(quorumVoterIds()) == 1 && isQuorumVoter(nodeId) {
+		r.transitionToCandidate()
+	}
+	raftClient = r
+	return nil
+}
+
+// readQuorumState reads the quorum-state file, nil when there is none.
+func readQuorumState(path string) (*QuorumStateData, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read quorum state %s: %w", path, err)
+	}
+	var state QuorumStateData
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("malformed quorum state %s: %w", path, err)
+	}
+	return &state, nil
+}
+
+// writeQuorumState persists the epoch, leader and vote, which must survive
+// restarts so a voter never votes twice in an epoch. Callers hold r.mu.
+func (r *RaftClient) writeQuorumState() {
+	state := QuorumStateData{
+		LeaderId:      r.leaderId,
+		LeaderEpoch:   r.epoch,
+		VotedId:       r.votedId,
+		CurrentVoters: []QuorumStateVoter{},
+	}
+	for _, id := range quorumVoterIds() {
+		state.CurrentVoters = append(state.CurrentVoters, QuorumStateVoter{VoterId: id})
+	}
+	data, err := json.Marshal(&state)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode quorum state: %v", err))
+	}
+	if err := writeCheckpointFile(filepath.Join(r.log.dir, QUORUM_STATE_FILE), string(data)); err != nil {
+		fmt.Println("Error writing quorum state: ", err.Error())
+	}
+}
+
+// resetElectionTimer sets when an election starts if no leader shows up,
+// randomized so voters rarely stand at the same time. Callers hold r.mu.
+func (r *RaftClient) resetElectionTimer() {
+	timeoutMs := brokerConfig.ControllerQuorumElectionTimeoutMs
+	r.electionDeadline = time.Now().Add(time.Duration(timeoutMs+rand.IntN(timeoutMs)) * time.Millisecond)
+}
+
+// signal wakes up the loop of the client after a state change.
+func (r *RaftClient) signal() {
+	select {
+	case r.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (r *RaftClient) wait(d time.Duration) {
+	timer := time.NewTimer(max(d, 0))
+	defer timer.Stop()
+	select {
+	case <-r.wakeup:
+	case <-timer.C:
+	}
+}
+
+// stepDown ends the leadership of this node, whose records now wait for
+// the next leader to be committed. Callers hold r.mu.
+func (r *RaftClient) stepDown() {
+	if r.role != QUORUM_ROLE_LEADER {
+		return
+	}
+	r.voterStates = nil
+	r.log.setReplicated(true, r.log.HighWatermark())
+}
+
+// transitionToUnattached moves to a newer epoch with no known leader.
+// Callers hold r.mu.
+func (r *RaftClient) transitionToUnattached(epoch int32) {
+	r.stepDown()
+	r.role, r.epoch, r.leaderId, r.votedId = QUORUM_ROLE_UNATTACHED, epoch, NO_LEADER, NO_VOTE
+	r.resetElectionTimer()
+	r.writeQuorumState()
+	r.signal()
+}
+
+// transitionToFollower starts following the leader of an epoch. Callers
+// hold r.mu.
+func (r *RaftClient) transitionToFollower(epoch int32, leaderId int32) {
+	r.stepDown()
+	if epoch != r.epoch {
+		r.votedId = NO_VOTE
+	}
+	r.role, r.epoch, r.leaderId = QUORUM_ROLE_FOLLOWER, epoch, leaderId
+	r.lastFetchTimeMs = time.Now().UnixMilli()
+	r.writeQuorumState()
+	fmt.Println("Following controller ", leaderId, " in epoch ", epoch)
+	r.signal()
+}
+
+// transitionToCandidate starts an election in the next epoch, voting for
+// this node and asking the other voters for their vote. Callers hold r.mu.
+func (r *RaftClient) transitionToCandidate() {
+	nodeId := int32(brokerConfig.NodeId)
+	r.role, r.epoch, r.leaderId, r.votedId = QUORUM_ROLE_CANDIDATE, r.epoch+1, NO_LEADER, nodeId
+	r.votes = map[int32]bool{nodeId: true}
+	r.resetElectionTimer()
+	r.writeQuorumState()
+	fmt.Println("Starting election for epoch ", r.epoch)
+
+	if len(r.votes) >= quorumMajority() {
+		r.transitionToLeader()
+		return
+	}
+	lastEpoch, lastOffset := r.log.LatestEpoch(), r.log.LogEndOffset()
+	for _, voterId := range quorumVoterIds() {
+		if voterId != nodeId {
+			go r.requestVote(voterId, r.epoch, lastEpoch, lastOffset)
+		}
+	}
+}
+
+// transitionToLeader makes this node the active controller. Records of
+// earlier epochs are only committed along with one of the new epoch, so a
+// record is written right away. Callers hold r.mu.
+func (r *RaftClient) transitionToLeader() {
+	nowMs := time.Now().UnixMilli()
+	r.role, r.leaderId = QUORUM_ROLE_LEADER, int32(brokerConfig.NodeId)
+	r.writeQuorumState()
+
+	r.voterStates = make(map[int32]*VoterState)
+	for _, voterId := range quorumVoterIds() {
+		if voterId != r.leaderId {
+			r.voterStates[voterId] = &VoterState{endOffset: -1, lastFetchTimeMs: nowMs}
+		}
+	}
+	r.log.setLeaderEpoch(r.epoch)
+	r.epochStartOffset = r.log.LogEndOffset()
+	r.log.setReplicated(len(r.voterStates) > 0, r.log.HighWatermark())
+
+	encoded, err := ktypes.NewKEncoder().Encode(&NoOpRecordValue{
+		Header: RecordValueHeader{
+			FrameVersion: ktypes.Int8(METADATA_RECORD_FRAME_VERSION),
+			RecordType:   ktypes.Int8(NO_OP_RECORD_TYPE),
+		},
+	})
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode no-op record: %v", err))
+	}
+	if _, err := r.log.Append([]Record{{Value: encoded}}); err != nil {
+		fmt.Println("Error writing the first record of epoch ", r.epoch, ": ", err.Error())
+	}
+	fmt.Println("Became the active controller in epoch ", r.epoch)
+	r.signal()
+}
+
+// resign gives up the leadership, letting the other voters know so they
+// elect a new leader without waiting for their fetch timeout. Callers hold
+// r.mu.
+func (r *RaftClient) resign() {
+	successors := r.preferredSuccessors()
+	epoch := r.epoch
+	r.stepDown()
+	r.role, r.leaderId = QUORUM_ROLE_UNATTACHED, NO_LEADER
+	r.resetElectionTimer()
+	r.writeQuorumState()
+	go r.sendEndQuorumEpochs(epoch, successors)
+}
+
+// preferredSuccessors returns the other voters, the most caught up first.
+// Callers hold r.mu.
+func (r *RaftClient) preferredSuccessors() []int32 {
+	successors := make([]int32, 0, len(r.voterStates))
+	for voterId := range r.voterStates {
+		successors = append(successors, voterId)
+	}
+	slices.SortFunc(successors, func(a, b int32) int {
+		return int(r.voterStates[b].endOffset - r.voterStates[a].endOffset)
+	})
+	return successors
+}
+
+// maybeTransition follows what a request or response says of the leader of
+// an epoch, when it is news to this node. Callers hold r.mu.
+func (r *RaftClient) maybeTransition(leaderId int32, epoch int32) {
+	nodeId := int32(brokerConfig.NodeId)
+	switch {
+	case epoch < r.epoch:
+	case epoch > r.epoch && (leaderId == NO_LEADER || leaderId == nodeId):
+		r.transitionToUnattached(epoch)
+	case epoch > r.epoch:
+		r.transitionToFollower(epoch, leaderId)
+	case leaderId != NO_LEADER && leaderId != nodeId && r.leaderId == NO_LEADER:
+		r.transitionToFollower(epoch, leaderId)
+	}
+}
+
+// isLeader reports whether this node is the active controller.
+func (r *RaftClient) isLeader() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.role == QUORUM_ROLE_LEADER
+}
+
+// currentLeader returns the active controller and the epoch of this node,
+// NO_LEADER when the leader is unknown.
+func (r *RaftClient) currentLeader() (int32, int32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.leaderId, r.epoch
+}
+
+// handleVote decides whether this voter votes for a candidate: once per
+// epoch, only in epochs with no known leader, and only for candidates whose
+// log is at least as up to date as its own.
+func (r *RaftClient) handleVote(candidateId int32, candidateEpoch int32, lastEpoch int32, lastOffset int64) (ERROR_CODE, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !isQuorumVoter(candidateId) || !isQuorumVoter(int32(brokerConfig.NodeId)) {
+		return ERROR_CODE_INCONSISTENT_VOTER_SET, false
+	}
+	if candidateEpoch < r.epoch {
+		return ERROR_CODE_FENCED_LEADER_EPOCH, false
+	}
+	if candidateEpoch > r.epoch {
+		r.transitionToUnattached(candidateEpoch)
+	}
+	if r.role == QUORUM_ROLE_LEADER || r.leaderId != NO_LEADER {
+		return ERROR_CODE_NONE, false
+	}
+	if r.votedId != NO_VOTE {
+		return ERROR_CODE_NONE, r.votedId == candidateId
+	}
+	latestEpoch := r.log.LatestEpoch()
+	if lastEpoch < latestEpoch || (lastEpoch == latestEpoch && lastOffset < r.log.LogEndOffset()) {
+		return ERROR_CODE_NONE, false
+	}
+
+	r.votedId = candidateId
+	r.resetElectionTimer()
+	r.writeQuorumState()
+	fmt.Println("Voted for controller ", candidateId, " in epoch ", candidateEpoch)
+	return ERROR_CODE_NONE, true
+}
+
+// requestVote asks a voter for its vote in epoch. Unanswered requests are
+// not retried, the candidate starts a new election when it times out.
+func (r *RaftClient) requestVote(voterId int32, epoch int32, lastEpoch int32, lastOffset int64) {
+	client, err := dialVoter(voterId)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+
+	requestBody := VoteRequestBody{
+		ClusterId: ktypes.CompactNullableString(clusterId),
+		Topics: []VoteRequestTopic{{
+			TopicName: ktypes.CompactString(METADATA_TOPIC),
+			Partitions: []VoteRequestPartition{{
+				PartitionIndex:  ktypes.Int32(0),
+				CandidateEpoch:  ktypes.Int32(epoch),
+				CandidateId:     ktypes.Int32(brokerConfig.NodeId),
+				LastOffsetEpoch: ktypes.Int32(lastEpoch),
+				LastOffset:      ktypes.Int64(lastOffset),
+			}},
+		}},
+	}
+	var responseBody VoteResponseBody
+	if err := client.send(VOTE_REQUEST_KEY, VOTE_VERSION, &requestBody, &responseBody); err != nil {
+		fmt.Println("Error requesting the vote of controller ", voterId, ": ", err.Error())
+		return
+	}
+	if responseBody.ErrorCode != ERROR_CODE_NONE || len(responseBody.Topics) != 1 || len(responseBody.Topics[0].Partitions) != 1 {
+		return
+	}
+	partition := responseBody.Topics[0].Partitions[0]
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.maybeTransition(int32(partition.LeaderId), int32(partition.LeaderEpoch))
+	if r.role != QUORUM_ROLE_CANDIDATE || r.epoch != epoch || partition.ErrorCode != ERROR_CODE_NONE || !partition.VoteGranted {
+		return
+	}
+	r.votes[voterId] = true
+	if len(r.votes) >= quorumMajority() {
+		r.transitionToLeader()
+	}
+}
+
+// handleBeginQuorumEpoch learns of the leader of an epoch.
+func (r *RaftClient) handleBeginQuorumEpoch(leaderId int32, epoch int32) ERROR_CODE {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !isQuorumVoter(leaderId) {
+		return ERROR_CODE_INCONSISTENT_VOTER_SET
+	}
+	if epoch < r.epoch {
+		return ERROR_CODE_FENCED_LEADER_EPOCH
+	}
+	r.maybeTransition(leaderId, epoch)
+	return ERROR_CODE_NONE
+}
+
+// handleEndQuorumEpoch starts an election once the leader resigned. Its
+// preferred successors stand first, the most caught up voters.
+func (r *RaftClient) handleEndQuorumEpoch(leaderId int32, epoch int32, preferredSuccessors []int32) ERROR_CODE {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if epoch < r.epoch {
+		return ERROR_CODE_FENCED_LEADER_EPOCH
+	}
+	if epoch > r.epoch {
+		r.transitionToUnattached(epoch)
+		return ERROR_CODE_NONE
+	}
+	if r.role != QUORUM_ROLE_FOLLOWER || r.leaderId != leaderId {
+		return ERROR_CODE_NONE
+	}
+
+	position := slices.Index(preferredSuccessors, int32(brokerConfig.NodeId))
+	if position < 0 {
+		position = len(preferredSuccessors)
+	}
+	backoffMs := brokerConfig.ControllerQuorumElectionBackoffMaxMs * position / max(len(preferredSuccessors), 1)
+	r.role, r.leaderId = QUORUM_ROLE_UNATTACHED, NO_LEADER
+	r.electionDeadline = time.Now().Add(time.Duration(backoffMs+rand.IntN(QUORUM_RETRY_BACKOFF_MS)) * time.Millisecond)
+	r.writeQuorumState()
+	fmt.Println("Controller ", leaderId, " resigned from epoch ", epoch)
+	r.signal()
+	return ERROR_CODE_NONE
+}
+
+// sendBeginQuorumEpoch tells a voter this node leads epoch.
+func (r *RaftClient) sendBeginQuorumEpoch(voterId int32, epoch int32) {
+	client, err := dialVoter(voterId)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+
+	requestBody := BeginQuorumEpochRequestBody{
+		ClusterId: ktypes.CompactNullableString(clusterId),
+		VoterId:   ktypes.Int32(voterId),
+		Topics: []BeginQuorumEpochRequestTopic{{
+			TopicName: ktypes.CompactString(METADATA_TOPIC),
+			Partitions: []BeginQuorumEpochRequestPartition{{
+				PartitionIndex:   ktypes.Int32(0),
+				VoterDirectoryId: NULL_UUID,
+				LeaderId:         ktypes.Int32(brokerConfig.NodeId),
+				LeaderEpoch:      ktypes.Int32(epoch),
+			}},
+		}},
+		LeaderEndpoints: quorumLeaderEndpoints(),
+	}
+	var responseBody BeginQuorumEpochResponseBody
+	if err := client.send(BEGIN_QUORUM_EPOCH_REQUEST_KEY, BEGIN_QUORUM_EPOCH_VERSION, &requestBody, &responseBody); err != nil {
+		return
+	}
+	if len(responseBody.Topics) == 1 && len(responseBody.Topics[0].Partitions) == 1 {
+		partition := responseBody.Topics[0].Partitions[0]
+		r.mu.Lock()
+		r.maybeTransition(int32(partition.LeaderId), int32(partition.LeaderEpoch))
+		r.mu.Unlock()
+	}
+}
+
+// sendEndQuorumEpochs tells the other voters this node resigned from
+// epoch, waiting for their answers.
+func (r *RaftClient) sendEndQuorumEpochs(epoch int32, preferredSuccessors []int32) {
+	candidates := make([]EndQuorumEpochRequestCandidate, len(preferredSuccessors))
+	for i, voterId := range preferredSuccessors {
+		candidates[i] = EndQuorumEpochRequestCandidate{CandidateId: ktypes.Int32(voterId), CandidateDirectoryId: NULL_UUID}
+	}
+	requestBody := EndQuorumEpochRequestBody{
+		ClusterId: ktypes.CompactNullableString(clusterId),
+		Topics: []EndQuorumEpochRequestTopic{{
+			TopicName: ktypes.CompactString(METADATA_TOPIC),
+			Partitions: []EndQuorumEpochRequestPartition{{
+				PartitionIndex:      ktypes.Int32(0),
+				LeaderId:            ktypes.Int32(brokerConfig.NodeId),
+				LeaderEpoch:         ktypes.Int32(epoch),
+				PreferredCandidates: candidates,
+			}},
+		}},
+		LeaderEndpoints: quorumLeaderEndpoints(),
+	}
+
+	var wg sync.WaitGroup
+	for _, voterId := range preferredSuccessors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client, err := dialVoter(voterId)
+			if err != nil {
+				return
+			}
+			defer client.Close()
+			var responseBody EndQuorumEpochResponseBody
+			client.send(END_QUORUM_EPOCH_REQUEST_KEY, END_QUORUM_EPOCH_VERSION, &requestBody, &responseBody)
+		}()
+	}
+	wg.Wait()
+}
+
+// quorumLeaderEndpoints returns the listener this node serves the quorum
+// on, sent along with the requests of a leader.
+func quorumLeaderEndpoints() []QuorumLeaderEndpoint {
+	address, ok := brokerConfig.ControllerQuorumVoters[int32(brokerConfig.NodeId)]
+	if !ok {
+		return []QuorumLeaderEndpoint{}
+	}
+	listener, _ := controllerListener(address)
+	host, _, _ := strings.Cut(address, ":")
+	return []QuorumLeaderEndpoint{{
+		Name: ktypes.CompactString(listener.Name),
+		Host: ktypes.CompactString(host),
+		Port: ktypes.Uint16(listener.Port),
+	}}
+}
+
+// validateFetch checks a fetch of the metadata log is sent to the leader of
+// the fetcher's epoch.
+func (r *RaftClient) validateFetch(epoch int32) ERROR_CODE {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch {
+	case r.role != QUORUM_ROLE_LEADER:
+		return ERROR_CODE_NOT_LEADER_OR_FOLLOWER
+	case epoch < r.epoch:
+		return ERROR_CODE_FENCED_LEADER_EPOCH
+	case epoch > r.epoch:
+		return ERROR_CODE_UNKNOWN_LEADER_EPOCH
+	}
+	return ERROR_CODE_NONE
+}
+
+// updateVoterState records a voter fetching from fetchOffset, which tells
+// the leader the voter has every record before it, and moves the high
+// watermark to the offset a majority of voters reached. Only records of
+// the leader's epoch commit those before them.
+func (r *RaftClient) updateVoterState(voterId int32, epoch int32, fetchOffset int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	voter, ok := r.voterStates[voterId]
+	if r.role != QUORUM_ROLE_LEADER || r.epoch != epoch || !ok {
+		return
+	}
+	voter.endOffset = fetchOffset
+	voter.lastFetchTimeMs = time.Now().UnixMilli()
+
+	endOffsets := []int64{r.log.LogEndOffset()}
+	for _, voter := range r.voterStates {
+		endOffsets = append(endOffsets, voter.endOffset)
+	}
+	slices.Sort(endOffsets)
+	highWatermark := endOffsets[len(endOffsets)-quorumMajority()]
+	if highWatermark > r.epochStartOffset {
+		r.log.updateHighWatermark(highWatermark)
+	}
+}
+
+// fetch answers a fetch of the metadata log by a voter or an observer. The
+// response always says who leads, so fetchers find the leader.
+func (r *RaftClient) fetch(replicaId int32, partition *FetchRequestPartition) FetchResponsePartition {
+	epoch := int32(partition.CurrentLeaderEpoch)
+	errorCode := r.validateFetch(epoch)
+	leaderId, currentEpoch := r.currentLeader()
+	currentLeader, err := ktypes.NewKEncoder().Encode(&FetchResponseLeaderIdAndEpoch{
+		LeaderId:    ktypes.Int32(leaderId),
+		LeaderEpoch: ktypes.Int32(currentEpoch),
+	})
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode current leader: %v", err))
+	}
+	if errorCode != ERROR_CODE_NONE {
+		return FetchResponsePartition{
+			PartitionIndex:       ktypes.Int32(0),
+			ErrorCode:            errorCode,
+			HighWatermark:        ktypes.Int64(-1),
+			LastStableOffset:     ktypes.Int64(-1),
+			LogStartOffset:       ktypes.Int64(-1),
+			PreferredReadReplica: ktypes.Int32(-1),
+			TaggedFields:         ktypes.TaggedFieldValues{FETCH_CURRENT_LEADER_TAG: currentLeader},
+		}
+	}
+
+	fetchOffset := int64(partition.FetchOffset)
+	if fetchOffset < r.log.LogStartOffset() {
+		if snapshotId, ok := latestSnapshotId(r.log.dir); ok {
+			encoded, err := ktypes.NewKEncoder().Encode(&snapshotId)
+			if err != nil {
+				panic(fmt.Sprintf("Failed to encode snapshot id: %v", err))
+			}
+			return FetchResponsePartition{
+				PartitionIndex:       ktypes.Int32(0),
+				ErrorCode:            ERROR_CODE_NONE,
+				HighWatermark:        ktypes.Int64(r.log.HighWatermark()),
+				LastStableOffset:     ktypes.Int64(-1),
+				LogStartOffset:       ktypes.Int64(r.log.LogStartOffset()),
+				PreferredReadReplica: ktypes.Int32(-1),
+				TaggedFields: ktypes.TaggedFieldValues{
+					FETCH_CURRENT_LEADER_TAG: currentLeader,
+					FETCH_SNAPSHOT_ID_TAG:    encoded,
+				},
+			}
+		}
+	}
+
+	response := fetchPartition(METADATA_TOPIC, 0, fetchOffset, int32(partition.LastFetchedEpoch), ISOLATION_LEVEL_READ_UNCOMMITTED, replicaId, nil, int(partition.PartitionMaxBytes))
+	if response.ErrorCode == ERROR_CODE_NONE && response.TaggedFields == nil && isQuorumVoter(replicaId) {
+		r.updateVoterState(replicaId, epoch, fetchOffset)
+	}
+	if response.TaggedFields == nil {
+		response.TaggedFields = ktypes.TaggedFieldValues{}
+	}
+	response.TaggedFields[FETCH_CURRENT_LEADER_TAG] = currentLeader
+	return response
+}
+
+// handleMetadataFetchRequest serves the metadata log to the other nodes,
+// waiting up to MaxWaitTimeMs for new records.
+func handleMetadataFetchRequest(req *Request, requestBody *FetchRequestBody, replicaId int32) *Response {
+	res := Response{
+		CorrelationId: req.CorrelationId,
+		HeaderVersion: 1,
+	}
+	responseBody := FetchResponseBody{
+		ErrorCode:      ERROR_CODE_NONE,
+		ThrottleTimeMs: ktypes.Int32(req.ThrottleTimeMs),
+		SessionId:      requestBody.SessionId,
+		Responses:      []FetchResponseTopic{},
+	}
+	topic := requestBody.Topics[0]
+	if len(topic.Partitions) != 1 || topic.Partitions[0].Partition != 0 {
+		responseBody.ErrorCode = ERROR_CODE_INVALID_REQUEST
+		res.Body = generateBytesFromFetchResponseBody(&responseBody)
+		return &res
+	}
+
+	partition := &topic.Partitions[0]
+	response := raftClient.fetch(replicaId, partition)
+	highWatermark := response.HighWatermark
+	respond := func() *Response {
+		responseBody.Responses = []FetchResponseTopic{{
+			TopicId:    METADATA_TOPIC_ID,
+			Partitions: []FetchResponsePartition{response},
+		}}
+		res.Body = generateBytesFromFetchResponseBody(&responseBody)
+		return &res
+	}
+	waiting := func() bool {
+		return response.ErrorCode == ERROR_CODE_NONE && len(response.Records) == 0 && len(response.TaggedFields) == 1 && response.HighWatermark == highWatermark
+	}
+	if !waiting() {
+		return respond()
+	}
+	// Fetchers wait for new records, or for the records they have to commit.
+	// The request is parked in the purgatory meanwhile, the fetches of the
+	// other voters committing them must not wait behind it for a handler.
+	return delayResponse(req, time.Duration(requestBody.MaxWaitTimeMs)*time.Millisecond, func() *Response {
+		response = raftClient.fetch(replicaId, partition)
+		if waiting() {
+			return nil
+		}
+		return respond()
+	}, respond)
+}
+
+// appendAsLeader writes a metadata record when this node is the active
+// controller and caught up with the records of earlier epochs, returning
+// the offset following it and the epoch it was written in. Callers hold
+// metadataMu.
+func (r *RaftClient) appendAsLeader(value []byte) (int64, int32, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.role != QUORUM_ROLE_LEADER {
+		return 0, 0, newKafkaError(ERROR_CODE_NOT_CONTROLLER, "node %d is not the active controller", brokerConfig.NodeId)
+	}
+	if metadataAppliedOffset <= r.epochStartOffset {
+		return 0, 0, newKafkaError(ERROR_CODE_NOT_CONTROLLER, "controller %d has not caught up with the metadata log yet", brokerConfig.NodeId)
+	}
+	offset, err := r.log.Append([]Record{{Value: value}})
+	if err != nil {
+		return 0, 0, err
+	}
+	return offset + 1, r.epoch, nil
+}
+
+// waitForCommit waits for the records before offset, written in epoch, to
+// be committed. They may be lost when the leadership is. The wait is parked
+// in the purgatory, checked as the voters' fetches move the high watermark.
+func (r *RaftClient) waitForCommit(offset int64, epoch int32) error {
+	timeoutMs := brokerConfig.ControllerQuorumRequestTimeoutMs
+	committed := func() bool {
+		return r.log.HighWatermark() >= offset
+	}
+	lost := func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return r.role != QUORUM_ROLE_LEADER || r.epoch != epoch
+	}
+	result := make(chan error, 1)
+	delayOperation(time.Duration(timeoutMs)*time.Millisecond, func() bool {
+		return committed() || lost()
+	}, func(expired bool) {
+		switch {
+		case committed():
+			result <- nil
+		case lost():
+			result <- newKafkaError(ERROR_CODE_NOT_CONTROLLER, "controller %d lost its leadership before the record was committed", brokerConfig.NodeId)
+		default:
+			result <- newKafkaError(ERROR_CODE_REQUEST_TIMED_OUT, "metadata record was not committed within %dms", timeoutMs)
+		}
+	})
+	return <-result
+}
+
+// start runs the client in the background: leaders check they still have
+// a majority, followers and observers fetch from the leader and voters
+// that know of no leader stand in elections.
+func (r *RaftClient) start() {
+	go func() {
+		defer close(r.stopped)
+		for {
+			r.mu.Lock()
+			closed, role, epoch, leaderId := r.closed, r.role, r.epoch, r.leaderId
+			r.mu.Unlock()
+
+			switch {
+			case closed:
+				if r.client != nil {
+					r.client.Close()
+				}
+				return
+			case role == QUORUM_ROLE_LEADER:
+				r.pollLeader(epoch)
+			case leaderId != NO_LEADER || !isQuorumVoter(int32(brokerConfig.NodeId)):
+				r.pollFollower(epoch, leaderId)
+			default:
+				r.pollElection()
+			}
+		}
+	}()
+}
+
+// pollLeader resigns when a majority of voters stopped fetching within
+// controller.quorum.fetch.timeout.ms, and tells the voters that do not
+// fetch who leads.
+func (r *RaftClient) pollLeader(epoch int32) {
+	nowMs := time.Now().UnixMilli()
+	halfElectionTimeoutMs := int64(brokerConfig.ControllerQuorumElectionTimeoutMs / 2)
+
+	r.mu.Lock()
+	if r.role == QUORUM_ROLE_LEADER && r.epoch == epoch {
+		fetching := 1
+		for voterId, voter := range r.voterStates {
+			if nowMs-voter.lastFetchTimeMs <= int64(brokerConfig.ControllerQuorumFetchTimeoutMs) {
+				fetching++
+			}
+			if (voter.endOffset < 0 || nowMs-voter.lastFetchTimeMs >= halfElectionTimeoutMs) && nowMs-voter.lastBeginQuorumEpochMs >= halfElectionTimeoutMs {
+				voter.lastBeginQuorumEpochMs = nowMs
+				go r.sendBeginQuorumEpoch(voterId, epoch)
+			}
+		}
+		if fetching < quorumMajority() {
+			fmt.Println("Resigning from epoch ", epoch, ", a majority of the voters stopped fetching")
+			r.resign()
+		}
+	}
+	r.mu.Unlock()
+	r.wait(time.Duration(brokerConfig.ControllerQuorumElectionTimeoutMs/4) * time.Millisecond)
+}
+
+// pollElection starts an election once the election timer expires.
+func (r *RaftClient) pollElection() {
+	r.mu.Lock()
+	if r.role != QUORUM_ROLE_LEADER && r.leaderId == NO_LEADER && !time.Now().Before(r.electionDeadline) {
+		r.transitionToCandidate()
+	}
+	deadline := r.electionDeadline
+	r.mu.Unlock()
+	r.wait(time.Until(deadline))
+}
+
+// pollFollower fetches the metadata log once. Voters that stop hearing from
+// their leader stand in an election, observers look for the new leader.
+func (r *RaftClient) pollFollower(epoch int32, leaderId int32) {
+	target := leaderId
+	if target == NO_LEADER {
+		voterIds := quorumVoterIds()
+		target = voterIds[r.observerTarget%len(voterIds)]
+	}
+	if err := r.fetchFrom(target, epoch); err != nil {
+		fmt.Println("Error fetching metadata from controller ", target, ": ", err.Error())
+		if r.client != nil {
+			r.client.Close()
+			r.client = nil
+		}
+		r.observerTarget++
+		r.wait(QUORUM_RETRY_BACKOFF_MS * time.Millisecond)
+	}
+
+	nowMs := time.Now().UnixMilli()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.role != QUORUM_ROLE_FOLLOWER || r.epoch != epoch || r.leaderId != leaderId {
+		return
+	}
+	if nowMs-r.lastFetchTimeMs <= int64(brokerConfig.ControllerQuorumFetchTimeoutMs) {
+		return
+	}
+	if isQuorumVoter(int32(brokerConfig.NodeId)) {
+		fmt.Println("Controller ", leaderId, " did not answer within controller.quorum.fetch.timeout.ms")
+		r.transitionToCandidate()
+		return
+	}
+	r.role, r.leaderId = QUORUM_ROLE_UNATTACHED, NO_LEADER
+	r.observerTarget++
+}
+
+// fetchFrom sends one fetch of the metadata log to target and appends the
+// records it returns.
+func (r *RaftClient) fetchFrom(target int32, epoch int32) error {
+	if r.client != nil && r.client.brokerId != target {
+		r.client.Close()
+		r.client = nil
+	}
+	if r.client == nil {
+		client, err := dialVoter(target)
+		if err != nil {
+			return err
+		}
+		r.client = client
+	}
+
+	replicaState, err := ktypes.NewKEncoder().Encode(&FetchRequestReplicaState{
+		ReplicaId:    ktypes.Int32(brokerConfig.NodeId),
+		ReplicaEpoch: ktypes.Int64(-1),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode fetch replica state: %v", err)
+	}
+	requestBody := FetchRequestBody{
+		MaxWaitTimeMs:  ktypes.Int32(QUORUM_FETCH_MAX_WAIT_MS),
+		MinBytes:       ktypes.Int32(1),
+		MaxBytes:       ktypes.Int32(brokerConfig.ReplicaFetchMaxBytes),
+		IsolationLevel: ktypes.Int8(ISOLATION_LEVEL_READ_UNCOMMITTED),
+		SessionEpoch:   ktypes.Int32(FETCH_SESSION_FINAL_EPOCH),
+		Topics: []FetchRequestTopic{{
+			TopicId: METADATA_TOPIC_ID,
+			Partitions: []FetchRequestPartition{{
+				Partition:          ktypes.Int32(0),
+				CurrentLeaderEpoch: ktypes.Int32(epoch),
+				FetchOffset:        ktypes.Int64(r.log.LogEndOffset()),
+				LastFetchedEpoch:   ktypes.Int32(r.log.LatestEpoch()),
+				LogStartOffset:     ktypes.Int64(r.log.LogStartOffset()),
+				PartitionMaxBytes:  ktypes.Int32(brokerConfig.ReplicaFetchMaxBytes),
+			}},
+		}},
+		ForgettenTopic: []FetchRequestForgettenTopic{},
+		TaggedFields:   ktypes.TaggedFieldValues{FETCH_REPLICA_STATE_TAG: replicaState},
+	}
+	var responseBody FetchResponseBody
+	if err := r.client.send(FETCH_REQUEST_KEY, REPLICA_FETCH_VERSION, &requestBody, &responseBody); err != nil {
+		return err
+	}
+	if responseBody.ErrorCode != ERROR_CODE_NONE {
+		return newKafkaError(responseBody.ErrorCode, "fetch failed with error %d", responseBody.ErrorCode)
+	}
+	if len(responseBody.Responses) != 1 || len(responseBody.Responses[0].Partitions) != 1 {
+		return fmt.Errorf("unexpected metadata fetch response")
+	}
+	partition := &responseBody.Responses[0].Partitions[0]
+
+	r.mu.Lock()
+	if value, ok := partition.TaggedFields[FETCH_CURRENT_LEADER_TAG]; ok {
+		var currentLeader FetchResponseLeaderIdAndEpoch
+		if err := ktypes.NewKDecoder(value).Decode(&currentLeader); err != nil {
+			r.mu.Unlock()
+			return fmt.Errorf("failed to decode current leader: %v", err)
+		}
+		r.maybeTransition(int32(currentLeader.LeaderId), int32(currentLeader.LeaderEpoch))
+	}
+	current := r.role == QUORUM_ROLE_FOLLOWER && r.epoch == epoch && r.leaderId == target
+	if current && partition.ErrorCode == ERROR_CODE_NONE {
+		r.lastFetchTimeMs = time.Now().UnixMilli()
+	}
+	r.mu.Unlock()
+
+	switch {
+	case partition.ErrorCode == ERROR_CODE_NOT_LEADER_OR_FOLLOWER || partition.ErrorCode == ERROR_CODE_FENCED_LEADER_EPOCH || partition.ErrorCode == ERROR_CODE_UNKNOWN_LEADER_EPOCH:
+		// The current leader sent along is fetched from next
+		if !current {
+			return nil
+		}
+		r.wait(QUORUM_RETRY_BACKOFF_MS * time.Millisecond)
+		return nil
+	case !current:
+		return nil
+	}
+	if value, ok := partition.TaggedFields[FETCH_SNAPSHOT_ID_TAG]; ok {
+		var snapshotId SnapshotId
+		if err := ktypes.NewKDecoder(value).Decode(&snapshotId); err != nil {
+			return fmt.Errorf("failed to decode snapshot id: %v", err)
+		}
+		return r.fetchSnapshot(epoch, &snapshotId)
+	}
+	return processFetchedPartition(r.log, partition)
+}
+
+// fetchSnapshot downloads the snapshot of the leader a fetcher behind its
+// log start continues from, then empties the log so it starts at the end
+// of the snapshot. The snapshot is applied along with the records that
+// follow it.
+func (r *RaftClient) fetchSnapshot(epoch int32, snapshotId *SnapshotId) error {
+	name := snapshotFileName(int64(snapshotId.EndOffset), int32(snapshotId.Epoch))
+	partPath := filepath.Join(r.log.dir, name+".part")
+	file, err := os.Create(partPath)
+	if err != nil {
+		return fmt.Errorf("unable to create snapshot %s: %w", partPath, err)
+	}
+	defer file.Close()
+
+	requestBody := FetchSnapshotRequestBody{
+		ReplicaId: ktypes.Int32(brokerConfig.NodeId),
+		MaxBytes:  ktypes.Int32(brokerConfig.ReplicaFetchMaxBytes),
+		Topics: []FetchSnapshotRequestTopic{{
+			Name: ktypes.CompactString(METADATA_TOPIC),
+			Partitions: []FetchSnapshotRequestPartition{{
+				Partition:          ktypes.Int32(0),
+				CurrentLeaderEpoch: ktypes.Int32(epoch),
+				SnapshotId:         SnapshotId{EndOffset: snapshotId.EndOffset, Epoch: snapshotId.Epoch},
+			}},
+		}},
+		TaggedFields: ktypes.TaggedFieldValues{},
+	}
+	if clusterId != "" {
+		encoded, err := ktypes.NewKEncoder().Encode(&FetchSnapshotClusterId{ClusterId: ktypes.CompactNullableString(clusterId)})
+		if err != nil {
+			return fmt.Errorf("failed to encode cluster id: %v", err)
+		}
+		requestBody.TaggedFields[FETCH_SNAPSHOT_CLUSTER_ID_TAG] = encoded
+	}
+
+	for position := int64(0); ; {
+		requestBody.Topics[0].Partitions[0].Position = ktypes.Int64(position)
+		var responseBody FetchSnapshotResponseBody
+		if err := r.client.send(FETCH_SNAPSHOT_REQUEST_KEY, FETCH_SNAPSHOT_VERSION, &requestBody, &responseBody); err != nil {
+			return err
+		}
+		if responseBody.ErrorCode != ERROR_CODE_NONE {
+			return newKafkaError(responseBody.ErrorCode, "fetch snapshot failed with error %d", responseBody.ErrorCode)
+		}
+		if len(responseBody.Topics) != 1 || len(responseBody.Topics[0].Partitions) != 1 {
+			return fmt.Errorf("unexpected fetch snapshot response")
+		}
+		partition := &responseBody.Topics[0].Partitions[0]
+		if partition.ErrorCode != ERROR_CODE_NONE {
+			return newKafkaError(partition.ErrorCode, "fetch snapshot failed with error %d", partition.ErrorCode)
+		}
+		if _, err := file.Write(partition.UnalignedRecords); err != nil {
+			return fmt.Errorf("unable to write snapshot %s: %w", partPath, err)
+		}
+		position += int64(len(partition.UnalignedRecords))
+		if position >= int64(partition.Size) {
+			break
+		}
+		if len(partition.UnalignedRecords) == 0 {
+			return fmt.Errorf("snapshot %s ended at %d of %d bytes", name, position, partition.Size)
+		}
+	}
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("unable to sync snapshot %s: %w", partPath, err)
+	}
+	if err := os.Rename(partPath, filepath.Join(r.log.dir, name)); err != nil {
+		return fmt.Errorf("unable to rename snapshot %s: %w", partPath, err)
+	}
+	if err := r.log.TruncateFullyAndStartAt(int64(snapshotId.EndOffset)); err != nil {
+		return err
+	}
+	fmt.Println("Fetched metadata snapshot ", name)
+	notifyLogChanged()
+	return nil
+}
+
+// close stops the client. A leader resigns first so the other voters
+// elect a new one right away.
+func (r *RaftClient) close() {
+	r.mu.Lock()
+	r.closed = true
+	if r.role == QUORUM_ROLE_LEADER && len(r.voterStates) > 0 {
+		successors, epoch := r.preferredSuccessors(), r.epoch
+		r.stepDown()
+		r.role, r.leaderId = QUORUM_ROLE_UNATTACHED, NO_LEADER
+		r.writeQuorumState()
+		r.mu.Unlock()
+		r.sendEndQuorumEpochs(epoch, successors)
+	} else {
+		r.mu.Unlock()
+	}
+	r.signal()
+
+	select {
+	case <-r.stopped:
+	case <-time.After(time.Duration(brokerConfig.ControllerQuorumRequestTimeoutMs) * time.Millisecond):
+	}
+}
+
+// startMetadataApplyTask applies the metadata records as they are
+// committed, and takes the role of this broker in the partitions they
+// change.
+func startMetadataApplyTask() {
+	runningTasks.Add(1)
+	go func() {
+		defer runningTasks.Done()
+		appliedHighWatermark := int64(-1)
+		for {
+			changed := logChangedChannel()
+			if highWatermark := raftClient.log.HighWatermark(); highWatermark != appliedHighWatermark {
+				metadataMu.Lock()
+				err := applyCommittedMetadata()
+				changed := takeChangedPartitions()
+				metadataMu.Unlock()
+				if err != nil {
+					fmt.Println("Error applying metadata: ", err.Error())
+				}
+				updatePartitionRoles(changed)
+				appliedHighWatermark = highWatermark
+				// Waiters for metadata changes check again
+				notifyLogChanged()
+			}
+			select {
+			case <-changed:
+			case <-stopTasks:
+				return
+			}
+		}
+	}()
+}
+
+// validateQuorumRequest checks a request between controllers comes from a
+// node of the cluster.
+func validateQuorumRequest(req *Request, requestClusterId ktypes.CompactNullableString) ERROR_CODE {
+	if !authorize(req, ACL_OPERATION_CLUSTER_ACTION, RESOURCE_TYPE_CLUSTER, CLUSTER_RESOURCE_NAME) {
+		return ERROR_CODE_CLUSTER_AUTHORIZATION_FAILED
+	}
+	if requestClusterId != "" && clusterId != "" && string(requestClusterId) != clusterId {
+		return ERROR_CODE_INCONSISTENT_CLUSTER_ID
+	}
+	return ERROR_CODE_NONE
+}
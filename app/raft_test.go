@@ -0,0 +1,195 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestRaftClient returns the client of voter 1 in a quorum of voters 1,
+// 2 and 3, over a log holding 3 records of epoch 2
+func newTestRaftClient(t *testing.T) *RaftClient {
+	t.Helper()
+	log := openTestPartitionLog(t, 1<<20)
+	brokerConfig.NodeId = 1
+	brokerConfig.ControllerQuorumVoters = map[int32]string{1: "localhost:19091", 2: "localhost:19092", 3: "localhost:19093"}
+	log.setLeaderEpoch(2)
+	appendTestBatches(t, log, 3)
+	log.setReplicated(true, 0)
+	return &RaftClient{
+		log:      log,
+		role:     QUORUM_ROLE_UNATTACHED,
+		epoch:    2,
+		leaderId: NO_LEADER,
+		votedId:  NO_VOTE,
+		wakeup:   make(chan struct{}, 1),
+		stopped:  make(chan struct{}),
+	}
+}
+
+func TestQuorumMajority(t *testing.T) {
+	previousConfig := brokerConfig
+	t.Cleanup(func() { brokerConfig = previousConfig })
+
+	tests := []struct {
+		voters int32
+		want   int
+	}{
+		{1, 1},
+		{2, 2},
+		{3, 2},
+		{4, 3},
+		{5, 3},
+	}
+	for _, test := range tests {
+		brokerConfig.ControllerQuorumVoters = make(map[int32]string)
+		for id := range test.voters {
+			brokerConfig.ControllerQuorumVoters[id] = "localhost:9093"
+		}
+		if got := quorumMajority(); got != test.want {
+			t.Errorf("%d voters: got majority %d, want %d", test.voters, got, test.want)
+		}
+	}
+}
+
+func TestHandleVote(t *testing.T) {
+	r := newTestRaftClient(t)
+	steps := []struct {
+		name        string
+		candidateId int32
+		epoch       int32
+		lastEpoch   int32
+		lastOffset  int64
+		wantError   ERROR_CODE
+		wantGranted bool
+	}{
+		{"not a voter", 9, 3, 2, 3, ERROR_CODE_INCONSISTENT_VOTER_SET, false},
+		{"old epoch", 2, 1, 2, 3, ERROR_CODE_FENCED_LEADER_EPOCH, false},
+		{"log of an older epoch", 2, 3, 1, 10, ERROR_CODE_NONE, false},
+		{"shorter log", 2, 3, 2, 2, ERROR_CODE_NONE, false},
+		{"up to date log", 2, 3, 2, 3, ERROR_CODE_NONE, true},
+		{"second candidate", 3, 3, 2, 5, ERROR_CODE_NONE, false},
+		{"same candidate again", 2, 3, 2, 3, ERROR_CODE_NONE, true},
+		{"next epoch", 3, 4, 2, 3, ERROR_CODE_NONE, true},
+	}
+	for _, step := range steps {
+		errorCode, granted := r.handleVote(step.candidateId, step.epoch, step.lastEpoch, step.lastOffset)
+		if errorCode != step.wantError || granted != step.wantGranted {
+			t.Errorf("%s: got %d, granted %v, want %d, granted %v", step.name, errorCode, granted, step.wantError, step.wantGranted)
+		}
+	}
+
+	// The vote survives restarts
+	state, err := readQuorumState(filepath.Join(r.log.dir, QUORUM_STATE_FILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.LeaderEpoch != 4 || state.VotedId != 3 || state.LeaderId != NO_LEADER {
+		t.Errorf("got quorum state %+v, want a vote for 3 in epoch 4", state)
+	}
+
+	// No vote once the epoch has a leader
+	if errorCode := r.handleBeginQuorumEpoch(2, 5); errorCode != ERROR_CODE_NONE {
+		t.Fatalf("got error %d for a new leader", errorCode)
+	}
+	if r.role != QUORUM_ROLE_FOLLOWER || r.leaderId != 2 || r.epoch != 5 {
+		t.Fatalf("got role %d, leader %d in epoch %d, want to follow 2 in epoch 5", r.role, r.leaderId, r.epoch)
+	}
+	if _, granted := r.handleVote(3, 5, 2, 3); granted {
+		t.Errorf("vote granted in an epoch with a leader")
+	}
+}
+
+func TestUpdateVoterStateCommitsOnMajority(t *testing.T) {
+	r := newTestRaftClient(t)
+	// This node leads epoch 3, which starts after the 3 records of epoch 2
+	r.role, r.epoch, r.leaderId = QUORUM_ROLE_LEADER, 3, 1
+	r.voterStates = map[int32]*VoterState{2: {endOffset: -1}, 3: {endOffset: -1}}
+	r.epochStartOffset = 3
+	r.log.setLeaderEpoch(3)
+	appendTestBatches(t, r.log, 2)
+
+	steps := []struct {
+		name    string
+		voterId int32
+		epoch   int32
+		offset  int64
+		wantHw  int64
+	}{
+		{"records of older epochs only", 2, 3, 3, 0},
+		{"fetch of another epoch", 2, 2, 5, 0},
+		{"majority has a record of the epoch", 2, 3, 4, 4},
+		{"every voter caught up", 3, 3, 5, 5},
+		{"voter going back", 3, 3, 1, 5},
+	}
+	for _, step := range steps {
+		r.updateVoterState(step.voterId, step.epoch, step.offset)
+		if hw := r.log.HighWatermark(); hw != step.wantHw {
+			t.Errorf("%s: got high watermark %d, want %d", step.name, hw, step.wantHw)
+		}
+	}
+}
+
+func TestHandleEndQuorumEpoch(t *testing.T) {
+	r := newTestRaftClient(t)
+	r.mu.Lock()
+	r.transitionToFollower(2, 3)
+	r.mu.Unlock()
+
+	if errorCode := r.handleEndQuorumEpoch(3, 1, nil); errorCode != ERROR_CODE_FENCED_LEADER_EPOCH {
+		t.Errorf("got error %d for an old epoch, want %d", errorCode, ERROR_CODE_FENCED_LEADER_EPOCH)
+	}
+	if errorCode := r.handleEndQuorumEpoch(2, 2, nil); errorCode != ERROR_CODE_NONE || r.role != QUORUM_ROLE_FOLLOWER {
+		t.Errorf("got error %d, role %d after another node resigned, want to keep following", errorCode, r.role)
+	}
+	if errorCode := r.handleEndQuorumEpoch(3, 2, []int32{1, 2}); errorCode != ERROR_CODE_NONE {
+		t.Fatalf("got error %d", errorCode)
+	}
+	if r.role != QUORUM_ROLE_UNATTACHED || r.leaderId != NO_LEADER || r.epoch != 2 {
+		t.Errorf("got role %d, leader %d in epoch %d, want no leader in epoch 2", r.role, r.leaderId, r.epoch)
+	}
+}
+
+func TestWaitForCommit(t *testing.T) {
+	r := newTestRaftClient(t)
+	r.role, r.epoch, r.leaderId = QUORUM_ROLE_LEADER, 3, 1
+	r.voterStates = map[int32]*VoterState{2: {endOffset: -1}, 3: {endOffset: -1}}
+	r.epochStartOffset = 3
+	r.log.setLeaderEpoch(3)
+	brokerConfig.ControllerQuorumRequestTimeoutMs = 5000
+	wait := func(offset int64) <-chan error {
+		result := make(chan error, 1)
+		go func() { result <- r.waitForCommit(offset, 3) }()
+		return result
+	}
+
+	appendTestBatches(t, r.log, 1)
+	committed := wait(4)
+	select {
+	case err := <-committed:
+		t.Fatalf("got %v before a majority had the record", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	r.updateVoterState(2, 3, 4)
+	if err := purgatoryTestResult(t, committed); err != nil {
+		t.Errorf("got %v once a majority had the record, want it committed", err)
+	}
+
+	appendTestBatches(t, r.log, 1)
+	lost := wait(5)
+	r.mu.Lock()
+	r.role = QUORUM_ROLE_FOLLOWER
+	r.mu.Unlock()
+	notifyLogChanged()
+	if err := purgatoryTestResult(t, lost); errorCodeFromError(err) != ERROR_CODE_NOT_CONTROLLER {
+		t.Errorf("got %v after the leadership was lost, want NOT_CONTROLLER", err)
+	}
+
+	r.mu.Lock()
+	r.role = QUORUM_ROLE_LEADER
+	r.mu.Unlock()
+	brokerConfig.ControllerQuorumRequestTimeoutMs = 20
+	if err := purgatoryTestResult(t, wait(5)); errorCodeFromError(err) != ERROR_CODE_REQUEST_TIMED_OUT {
+		t.Errorf("got %v for a record never committed, want REQUEST_TIMED_OUT", err)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	RECORD_BATCH_MAGIC = 2
+
+	// Byte positions inside an encoded record batch
+	RECORD_BATCH_LENGTH_OFFSET     = 8
+	RECORD_BATCH_EPOCH_OFFSET      = 12
+	RECORD_BATCH_CRC_OFFSET        = 17
+	RECORD_BATCH_ATTRIBUTES_OFFSET = 21
+
+	// BaseOffset and BatchLength are not counted in BatchLength
+	RECORD_BATCH_LOG_OVERHEAD = 12
+
+	// Everything up to and including the record count
+	RECORD_BATCH_HEADER_SIZE = 61
+
+	// Record batch attribute flags
+	RECORD_BATCH_TRANSACTIONAL_FLAG = 0x10
+	RECORD_BATCH_CONTROL_FLAG       = 0x20
+
+	// Codecs the records of a batch are compressed with, in the lowest bits
+	// of its attributes
+	RECORD_BATCH_COMPRESSION_MASK   = 0x07
+	RECORD_BATCH_COMPRESSION_NONE   = 0
+	RECORD_BATCH_COMPRESSION_GZIP   = 1
+	RECORD_BATCH_COMPRESSION_SNAPPY = 2
+	RECORD_BATCH_COMPRESSION_LZ4    = 3
+	RECORD_BATCH_COMPRESSION_ZSTD   = 4
+
+	CONTROL_RECORD_VERSION = 0
+	CONTROL_RECORD_ABORT   = 0
+	CONTROL_RECORD_COMMIT  = 1
+)
+
+// Key of the single record of a control batch
+type ControlRecordKey struct {
+	Version ktypes.Int16 `order:"1"`
+	Type    ktypes.Int16 `order:"2"`
+}
+
+// Value of a COMMIT or ABORT control record
+type EndTransactionMarker struct {
+	Version          ktypes.Int16 `order:"1"`
+	CoordinatorEpoch ktypes.Int32 `order:"2"`
+}
+
+// RecordBatchHeader is the fixed size part of a record batch, decoded
+// without touching the records so client batches are never re-encoded.
+type RecordBatchHeader struct {
+	BaseOffset           ktypes.Int64 `order:"1"`
+	BatchLength          ktypes.Int32 `order:"2"`
+	PartitionLeaderEpoch ktypes.Int32 `order:"3"`
+	MagicByte            ktypes.Int8  `order:"4"`
+	Crc                  ktypes.Int32 `order:"5"`
+	Attributes           ktypes.Int16 `order:"6"`
+	LastOffsetDelta      ktypes.Int32 `order:"7"`
+	BaseTimestamp        ktypes.Int64 `order:"8"`
+	MaxTimestamp         ktypes.Int64 `order:"9"`
+	ProducerId           ktypes.Int64 `order:"10"`
+	ProducerEpoch        ktypes.Int16 `order:"11"`
+	FirstSequence        ktypes.Int32 `order:"12"`
+	RecordCount          ktypes.Int32 `order:"13"`
+}
+
+// RawRecordBatch is an encoded batch together with its decoded header
+type RawRecordBatch struct {
+	Header   RecordBatchHeader
+	Data     []byte
+	Position int64 // byte position of the batch in its segment
+}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// newRecordBatch builds a batch for records written at timestamp, numbering
+// them from baseOffset.
+func newRecordBatch(baseOffset int64, timestamp int64, records []Record) *RecordBatch {
+	for i := range records {
+		records[i].OffsetDelta = ktypes.VarInt(i)
+	}
+
+	return &RecordBatch{
+		BaseOffset:           ktypes.Int64(baseOffset),
+		PartitionLeaderEpoch: ktypes.Int32(0),
+		MagicByte:            ktypes.Int8(RECORD_BATCH_MAGIC),
+		Attributes:           ktypes.Int16(0),
+		LastOffsetDelta:      ktypes.Int32(len(records) - 1),
+		BaseTimestamp:        ktypes.Int64(timestamp),
+		MaxTimestamp:         ktypes.Int64(timestamp),
+		ProducerId:           ktypes.Int64(-1),
+		ProducerEpoch:        ktypes.Int16(-1),
+		FirstSequence:        ktypes.Int32(-1),
+		Records:              records,
+	}
+}
+
+// newTransactionalRecordBatch builds a batch written on behalf of a
+// transactional producer outside of its own produce requests.
+func newTransactionalRecordBatch(baseOffset int64, timestamp int64, producerId int64, producerEpoch int16, records []Record) *RecordBatch {
+	batch := newRecordBatch(baseOffset, timestamp, records)
+	batch.Attributes = ktypes.Int16(RECORD_BATCH_TRANSACTIONAL_FLAG)
+	batch.ProducerId = ktypes.Int64(producerId)
+	batch.ProducerEpoch = ktypes.Int16(producerEpoch)
+	return batch
+}
+
+// newControlBatch builds the COMMIT or ABORT marker ending a transaction.
+func newControlBatch(baseOffset int64, timestamp int64, producerId int64, producerEpoch int16, commit bool, coordinatorEpoch int32) (*RecordBatch, error) {
+	markerType := CONTROL_RECORD_ABORT
+	if commit {
+		markerType = CONTROL_RECORD_COMMIT
+	}
+	key, err := ktypes.NewKEncoder().Encode(&ControlRecordKey{
+		Version: ktypes.Int16(CONTROL_RECORD_VERSION),
+		Type:    ktypes.Int16(markerType),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode control record key: %w", err)
+	}
+	value, err := ktypes.NewKEncoder().Encode(&EndTransactionMarker{
+		Version:          ktypes.Int16(CONTROL_RECORD_VERSION),
+		CoordinatorEpoch: ktypes.Int32(coordinatorEpoch),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode control record value: %w", err)
+	}
+
+	batch := newTransactionalRecordBatch(baseOffset, timestamp, producerId, producerEpoch, []Record{{Key: key, Value: value}})
+	batch.Attributes = ktypes.Int16(RECORD_BATCH_TRANSACTIONAL_FLAG | RECORD_BATCH_CONTROL_FLAG)
+	return batch, nil
+}
+
+// encodeRecordBatch encodes the batch as it is stored on disk, filling in the
+// record lengths, the batch length and the CRC. The records are compressed
+// with the codec in the batch attributes.
+func encodeRecordBatch(batch *RecordBatch) ([]byte, error) {
+	encoder := ktypes.NewKEncoder()
+	for i := range batch.Records {
+		// A zero length is a single varint byte, so the record length is whatever follows it
+		batch.Records[i].Length = ktypes.VarInt(0)
+		encodedRecord, err := encoder.Encode(&batch.Records[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode record %d: %w", i, err)
+		}
+		batch.Records[i].Length = ktypes.VarInt(len(encodedRecord) - 1)
+	}
+
+	encoded, err := encoder.Encode(batch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode record batch: %w", err)
+	}
+	if codec := int16(batch.Attributes) & RECORD_BATCH_COMPRESSION_MASK; codec != RECORD_BATCH_COMPRESSION_NONE {
+		// Everything after the record count is compressed
+		compressed, err := compressRecords(codec, encoded[RECORD_BATCH_HEADER_SIZE:])
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded[:RECORD_BATCH_HEADER_SIZE:RECORD_BATCH_HEADER_SIZE], compressed...)
+	}
+
+	batch.BatchLength = ktypes.Int32(len(encoded) - RECORD_BATCH_LOG_OVERHEAD)
+	binary.BigEndian.PutUint32(encoded[RECORD_BATCH_LENGTH_OFFSET:], uint32(batch.BatchLength))
+
+	crc := crc32.Checksum(encoded[RECORD_BATCH_ATTRIBUTES_OFFSET:], crc32cTable)
+	batch.Crc = ktypes.Int32(crc)
+	binary.BigEndian.PutUint32(encoded[RECORD_BATCH_CRC_OFFSET:], crc)
+
+	return encoded, nil
+}
+
+// lastOffset returns the offset of the last record in the batch.
+func (batch *RecordBatch) lastOffset() int64 {
+	return int64(batch.BaseOffset) + int64(batch.LastOffsetDelta)
+}
+
+// lastOffset returns the offset of the last record in the batch.
+func (header *RecordBatchHeader) lastOffset() int64 {
+	return int64(header.BaseOffset) + int64(header.LastOffsetDelta)
+}
+
+func (batch *RecordBatch) isTransactional() bool {
+	return batch.Attributes&RECORD_BATCH_TRANSACTIONAL_FLAG != 0
+}
+
+func (batch *RecordBatch) isControl() bool {
+	return batch.Attributes&RECORD_BATCH_CONTROL_FLAG != 0
+}
+
+func (header *RecordBatchHeader) isTransactional() bool {
+	return header.Attributes&RECORD_BATCH_TRANSACTIONAL_FLAG != 0
+}
+
+func (header *RecordBatchHeader) isControl() bool {
+	return header.Attributes&RECORD_BATCH_CONTROL_FLAG != 0
+}
+
+// lastSequence returns the sequence number of the last record in the batch.
+func (header *RecordBatchHeader) lastSequence() int32 {
+	if header.FirstSequence < 0 {
+		return -1
+	}
+	// Sequence numbers wrap around after MaxInt32
+	return int32((int64(header.FirstSequence) + int64(header.LastOffsetDelta)) % (math.MaxInt32 + 1))
+}
+
+func (header *RecordBatchHeader) compressionCodec() int16 {
+	return int16(header.Attributes) & RECORD_BATCH_COMPRESSION_MASK
+}
+
+// supportedCompressionCodec reports whether the broker can decompress and
+// compress records with the codec. Only gzip is in the standard library, so
+// records compressed with snappy, lz4 or zstd are kept as clients wrote them.
+func supportedCompressionCodec(codec int16) bool {
+	return codec == RECORD_BATCH_COMPRESSION_NONE || codec == RECORD_BATCH_COMPRESSION_GZIP
+}
+
+// compressRecords compresses the encoded records of a batch with the codec.
+func compressRecords(codec int16, records []byte) ([]byte, error) {
+	switch codec {
+	case RECORD_BATCH_COMPRESSION_NONE:
+		return records, nil
+	case RECORD_BATCH_COMPRESSION_GZIP:
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		if _, err := writer.Write(records); err != nil {
+			return nil, fmt.Errorf("failed to compress records: %w", err)
+		}
+		if err := writer.Close(); err != nil {
+			return nil, fmt.Errorf("failed to compress records: %w", err)
+		}
+		return compressed.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unsupported compression codec %d", codec)
+}
+
+// decompressRecords returns the encoded records a batch compressed with the
+// codec.
+func decompressRecords(codec int16, data []byte) ([]byte, error) {
+	switch codec {
+	case RECORD_BATCH_COMPRESSION_NONE:
+		return data, nil
+	case RECORD_BATCH_COMPRESSION_GZIP:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress records: %w", err)
+		}
+		defer reader.Close()
+		records, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress records: %w", err)
+		}
+		return records, nil
+	}
+	return nil, fmt.Errorf("unsupported compression codec %d", codec)
+}
+
+// decodeRecordBatchHeader decodes the header of the encoded batch.
+func decodeRecordBatchHeader(data []byte) (*RecordBatchHeader, error) {
+	if len(data) < RECORD_BATCH_HEADER_SIZE {
+		return nil, fmt.Errorf("record batch too short: expected at least %d bytes, got %d", RECORD_BATCH_HEADER_SIZE, len(data))
+	}
+	var header RecordBatchHeader
+	if err := ktypes.NewKDecoder(data[:RECORD_BATCH_HEADER_SIZE]).Decode(&header); err != nil {
+		return nil, fmt.Errorf("failed to decode record batch header: %w", err)
+	}
+	return &header, nil
+}
+
+// splitRecordBatches splits concatenated encoded batches using their batch
+// lengths. A trailing partial batch is reported as an error.
+func splitRecordBatches(data []byte) ([]RawRecordBatch, error) {
+	batches := make([]RawRecordBatch, 0)
+	position := 0
+	for position < len(data) {
+		header, err := decodeRecordBatchHeader(data[position:])
+		if err != nil {
+			return batches, err
+		}
+		size := RECORD_BATCH_LOG_OVERHEAD + int(header.BatchLength)
+		if header.BatchLength < RECORD_BATCH_HEADER_SIZE-RECORD_BATCH_LOG_OVERHEAD || position+size > len(data) {
+			return batches, fmt.Errorf("invalid record batch length %d at position %d", header.BatchLength, position)
+		}
+		batches = append(batches, RawRecordBatch{
+			Header:   *header,
+			Data:     data[position : position+size],
+			Position: int64(position),
+		})
+		position += size
+	}
+	return batches, nil
+}
+
+// setBaseOffset rewrites the base offset of an encoded batch. The CRC does
+// not cover the base offset so it stays valid.
+func (batch *RawRecordBatch) setBaseOffset(baseOffset int64) {
+	binary.BigEndian.PutUint64(batch.Data[0:8], uint64(baseOffset))
+	batch.Header.BaseOffset = ktypes.Int64(baseOffset)
+}
+
+// setPartitionLeaderEpoch rewrites the leader epoch of an encoded batch,
+// which the CRC does not cover either.
+func (batch *RawRecordBatch) setPartitionLeaderEpoch(epoch int32) {
+	binary.BigEndian.PutUint32(batch.Data[RECORD_BATCH_EPOCH_OFFSET:], uint32(epoch))
+	batch.Header.PartitionLeaderEpoch = ktypes.Int32(epoch)
+}
+
+// validateRecordBatch checks the magic byte and CRC of an encoded batch.
+func validateRecordBatch(batch *RawRecordBatch) error {
+	if batch.Header.MagicByte != RECORD_BATCH_MAGIC {
+		return newKafkaError(ERROR_CODE_CORRUPT_MESSAGE, "unsupported record batch magic %d", batch.Header.MagicByte)
+	}
+	crc := crc32.Checksum(batch.Data[RECORD_BATCH_ATTRIBUTES_OFFSET:], crc32cTable)
+	if crc != uint32(batch.Header.Crc) {
+		return newKafkaError(ERROR_CODE_CORRUPT_MESSAGE, "record batch CRC mismatch: expected %d, computed %d", uint32(batch.Header.Crc), crc)
+	}
+	return nil
+}
+
+// controlRecordType returns the type of a control record from its key.
+func controlRecordType(record *Record) (int16, error) {
+	var key ControlRecordKey
+	if err := ktypes.NewKDecoder(record.Key).Decode(&key); err != nil {
+		return 0, fmt.Errorf("failed to decode control record key: %w", err)
+	}
+	return int16(key.Type), nil
+}
+
+// isCommitMarker reports whether an encoded control batch commits its
+// transaction, as opposed to aborting it.
+func (batch *RawRecordBatch) isCommitMarker() (bool, error) {
+	var record Record
+	if err := ktypes.NewKDecoder(batch.Data[RECORD_BATCH_HEADER_SIZE:]).Decode(&record); err != nil {
+		return false, fmt.Errorf("failed to decode control record: %w", err)
+	}
+	markerType, err := controlRecordType(&record)
+	if err != nil {
+		return false, err
+	}
+	return markerType == CONTROL_RECORD_COMMIT, nil
+}
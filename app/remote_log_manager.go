@@ -0,0 +1,565 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	REMOTE_LOG_METADATA_VERSION = 0
+	// Local retention following the topic's retention.ms and retention.bytes
+	LOCAL_RETENTION_FROM_RETENTION = -2
+)
+
+// remoteLogMetadataPath returns the file listing the remote segments of a
+// partition, in remote.log.metadata.dir so every replica reads the segments
+// its leaders copied.
+func remoteLogMetadataPath(topicName string, partition int32, topicId ktypes.UUID) string {
+	return filepath.Join(brokerConfig.RemoteLogMetadataDir, fmt.Sprintf("%s-%d-%s", topicName, partition, topicId))
+}
+
+// readRemoteLogMetadata reads the remote segments of a partition, a version
+// line, an entry count line and one line per segment with its id, offsets,
+// largest timestamp, size, state and "epoch:offset" leader epochs. A
+// missing file means no segment was copied.
+func readRemoteLogMetadata(path string, topicName string, partition int32, topicId ktypes.UUID) ([]RemoteLogSegmentMetadata, error) {
+	segments := make([]RemoteLogSegmentMetadata, 0)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return segments, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read remote log metadata %s: %w", path, err)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) < 2 || lines[0] != strconv.Itoa(REMOTE_LOG_METADATA_VERSION) {
+		return nil, fmt.Errorf("malformed remote log metadata %s", path)
+	}
+	count, err := strconv.Atoi(lines[1])
+	if err != nil || count != len(lines)-2 {
+		return nil, fmt.Errorf("malformed remote log metadata %s: unexpected entry count", path)
+	}
+	for _, line := range lines[2:] {
+		segment, err := parseRemoteLogSegmentMetadata(line)
+		if err != nil {
+			return nil, fmt.Errorf("malformed remote log metadata %s: %q", path, line)
+		}
+		segment.SegmentId.TopicId = topicId
+		segment.SegmentId.TopicName = topicName
+		segment.SegmentId.Partition = partition
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+func parseRemoteLogSegmentMetadata(line string) (RemoteLogSegmentMetadata, error) {
+	var segment RemoteLogSegmentMetadata
+	fields := strings.Fields(line)
+	if len(fields) != 7 {
+		return segment, fmt.Errorf("expected 7 fields")
+	}
+	id, err := hex.DecodeString(fields[0])
+	if err != nil || len(id) != len(segment.SegmentId.Id) {
+		return segment, fmt.Errorf("invalid segment id")
+	}
+	copy(segment.SegmentId.Id[:], id)
+	values := make([]int64, 5)
+	for i := range values {
+		if values[i], err = strconv.ParseInt(fields[i+1], 10, 64); err != nil {
+			return segment, err
+		}
+	}
+	segment.StartOffset, segment.EndOffset = values[0], values[1]
+	segment.MaxTimestampMs, segment.SegmentSizeInBytes = values[2], values[3]
+	segment.State = int8(values[4])
+	segment.SegmentLeaderEpochs = make([]EpochEntry, 0)
+	if fields[6] == "-" {
+		return segment, nil
+	}
+	for _, entry := range strings.Split(fields[6], ",") {
+		epoch, startOffset, ok := strings.Cut(entry, ":")
+		if !ok {
+			return segment, fmt.Errorf("invalid leader epoch")
+		}
+		e, err := strconv.ParseInt(epoch, 10, 32)
+		if err != nil {
+			return segment, err
+		}
+		o, err := strconv.ParseInt(startOffset, 10, 64)
+		if err != nil {
+			return segment, err
+		}
+		segment.SegmentLeaderEpochs = append(segment.SegmentLeaderEpochs, EpochEntry{int32(e), o})
+	}
+	return segment, nil
+}
+
+// writeRemoteLogMetadata replaces the remote segments of a partition.
+func writeRemoteLogMetadata(path string, segments []RemoteLogSegmentMetadata) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("unable to create remote log metadata folder: %w", err)
+	}
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%d\n%d\n", REMOTE_LOG_METADATA_VERSION, len(segments))
+	for _, segment := range segments {
+		epochs := make([]string, len(segment.SegmentLeaderEpochs))
+		for i, entry := range segment.SegmentLeaderEpochs {
+			epochs[i] = fmt.Sprintf("%d:%d", entry.Epoch, entry.StartOffset)
+		}
+		if len(epochs) == 0 {
+			epochs = []string{"-"}
+		}
+		fmt.Fprintf(&builder, "%s %d %d %d %d %d %s\n", hex.EncodeToString(segment.SegmentId.Id[:]),
+			segment.StartOffset, segment.EndOffset, segment.MaxTimestampMs, segment.SegmentSizeInBytes, segment.State, strings.Join(epochs, ","))
+	}
+	return writeCheckpointFile(path, builder.String())
+}
+
+// topicRemoteStorageEnabled tells whether the closed segments of a topic are
+// copied to remote storage. Compacted topics never are.
+func topicRemoteStorageEnabled(topicName string) bool {
+	return remoteStorageManager != nil &&
+		topicConfig(topicName, "remote.storage.enable", "false") == "true" &&
+		!hasCleanupPolicy(topicName, CLEANUP_POLICY_COMPACT)
+}
+
+// topicLocalRetention returns how long and up to what size a topic with
+// remote storage keeps its copied segments locally, -1 for no limit.
+func topicLocalRetention(topicName string) (int64, int64) {
+	topicRetentionMs, topicRetentionBytes := topicRetention(topicName)
+	retentionMs := int64(brokerConfig.LogLocalRetentionMs)
+	if n, err := strconv.ParseInt(topicConfig(topicName, "local.retention.ms", ""), 10, 64); err == nil && n >= -2 {
+		retentionMs = n
+	}
+	if retentionMs == LOCAL_RETENTION_FROM_RETENTION {
+		retentionMs = topicRetentionMs
+	}
+	retentionBytes := int64(brokerConfig.LogLocalRetentionBytes)
+	if n, err := strconv.ParseInt(topicConfig(topicName, "local.retention.bytes", ""), 10, 64); err == nil && n >= -2 {
+		retentionBytes = n
+	}
+	if retentionBytes == LOCAL_RETENTION_FROM_RETENTION {
+		retentionBytes = topicRetentionBytes
+	}
+	return retentionMs, retentionBytes
+}
+
+// copiedRemoteSegments returns the remote segments whose copy finished,
+// which reads are served from. Callers hold l.mu.
+func (l *PartitionLog) copiedRemoteSegments() []RemoteLogSegmentMetadata {
+	copied := make([]RemoteLogSegmentMetadata, 0, len(l.remoteSegments))
+	for _, segment := range l.remoteSegments {
+		if segment.State == REMOTE_LOG_SEGMENT_STATE_COPY_SEGMENT_FINISHED {
+			copied = append(copied, segment)
+		}
+	}
+	return copied
+}
+
+// remoteLogEndOffset returns the last offset copied to remote storage, -1
+// when none was. Callers hold l.mu.
+func (l *PartitionLog) remoteLogEndOffset() int64 {
+	endOffset := int64(-1)
+	for _, segment := range l.copiedRemoteSegments() {
+		endOffset = max(endOffset, segment.EndOffset)
+	}
+	return endOffset
+}
+
+// localLogStartOffset returns the first offset of the local segments,
+// below which reads go to remote storage. Callers hold l.mu.
+func (l *PartitionLog) localLogStartOffset() (int64, error) {
+	segments, err := l.segmentFiles()
+	if err != nil {
+		return 0, err
+	}
+	if len(segments) == 0 {
+		return l.logEndOffset, nil
+	}
+	return segmentBaseOffset(segments[0])
+}
+
+// refreshRemoteSegments reloads the remote segments of the log, which its
+// leader may have copied or deleted. The log start offset of followers
+// follows the remote segments deleted.
+func (l *PartitionLog) refreshRemoteSegments(state *PartitionState) error {
+	segments, err := readRemoteLogMetadata(remoteLogMetadataPath(l.topicName, l.partition, state.TopicId), l.topicName, l.partition, state.TopicId)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, previous := range l.copiedRemoteSegments() {
+		if !slices.ContainsFunc(segments, func(segment RemoteLogSegmentMetadata) bool {
+			return segment.SegmentId == previous.SegmentId && segment.State == REMOTE_LOG_SEGMENT_STATE_COPY_SEGMENT_FINISHED
+		}) {
+			l.logStartOffset = max(l.logStartOffset, min(previous.EndOffset+1, l.logEndOffset))
+		}
+	}
+	l.remoteSegments = segments
+	return l.epochCache.truncateFromStart(l.logStartOffset)
+}
+
+// setRemoteSegmentState records the state a remote segment moved to,
+// dropping it once deleted. Callers hold l.mu.
+func (l *PartitionLog) setRemoteSegmentState(state *PartitionState, segment *RemoteLogSegmentMetadata, segmentState int8) error {
+	segment.State = segmentState
+	i := slices.IndexFunc(l.remoteSegments, func(s RemoteLogSegmentMetadata) bool { return s.SegmentId == segment.SegmentId })
+	switch {
+	case segmentState == REMOTE_LOG_SEGMENT_STATE_DELETE_SEGMENT_FINISHED:
+		if i >= 0 {
+			l.remoteSegments = slices.Delete(l.remoteSegments, i, i+1)
+		}
+	case i >= 0:
+		l.remoteSegments[i] = *segment
+	default:
+		l.remoteSegments = append(l.remoteSegments, *segment)
+		slices.SortStableFunc(l.remoteSegments, func(a, b RemoteLogSegmentMetadata) int {
+			return cmp.Compare(a.StartOffset, b.StartOffset)
+		})
+	}
+	return writeRemoteLogMetadata(remoteLogMetadataPath(l.topicName, l.partition, state.TopicId), l.remoteSegments)
+}
+
+// nextSegmentToCopy returns the oldest closed segment not copied yet, as
+// long as it holds no record past the last stable offset.
+func (l *PartitionLog) nextSegmentToCopy(state *PartitionState) (*RemoteLogSegmentMetadata, *LogSegmentData, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	segments, err := l.segmentFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+	remoteLogEndOffset := l.remoteLogEndOffset()
+	for i := 0; i < len(segments)-1; i++ {
+		baseOffset, err := segmentBaseOffset(segments[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		nextBaseOffset, err := segmentBaseOffset(segments[i+1])
+		if err != nil {
+			return nil, nil, err
+		}
+		if nextBaseOffset <= remoteLogEndOffset+1 || nextBaseOffset <= l.logStartOffset {
+			continue
+		}
+		if nextBaseOffset > l.lastStableOffset() {
+			return nil, nil, nil
+		}
+		info, err := os.Stat(segments[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		if info.Size() == 0 {
+			continue
+		}
+		largestTimestamp, err := segmentLargestTimestamp(segments[i])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		metadata := &RemoteLogSegmentMetadata{
+			SegmentId: RemoteLogSegmentId{
+				TopicId:   state.TopicId,
+				TopicName: l.topicName,
+				Partition: l.partition,
+			},
+			StartOffset:         baseOffset,
+			EndOffset:           nextBaseOffset - 1,
+			MaxTimestampMs:      largestTimestamp,
+			SegmentSizeInBytes:  info.Size(),
+			SegmentLeaderEpochs: l.epochCache.epochsBetween(baseOffset, nextBaseOffset),
+		}
+		if _, err := rand.Read(metadata.SegmentId.Id[:]); err != nil {
+			return nil, nil, err
+		}
+		data := &LogSegmentData{
+			LogSegment:       segments[i],
+			LeaderEpochIndex: []byte(formatEpochEntries(metadata.SegmentLeaderEpochs)),
+		}
+		if _, err := os.Stat(transactionIndexPath(segments[i])); err == nil {
+			data.TransactionIndex = transactionIndexPath(segments[i])
+		}
+		snapshot := filepath.Join(l.dir, producerSnapshotFileName(nextBaseOffset))
+		if _, err := os.Stat(snapshot); err == nil {
+			data.ProducerSnapshot = snapshot
+		}
+		return metadata, data, nil
+	}
+	return nil, nil, nil
+}
+
+// copySegmentsToRemote copies the closed segments of a log this broker
+// leads to remote storage, oldest first. Segments are read while the log
+// is appended to, they no longer change.
+func (l *PartitionLog) copySegmentsToRemote(state *PartitionState) error {
+	for {
+		metadata, data, err := l.nextSegmentToCopy(state)
+		if err != nil || metadata == nil {
+			return err
+		}
+
+		l.mu.Lock()
+		err = l.setRemoteSegmentState(state, metadata, REMOTE_LOG_SEGMENT_STATE_COPY_SEGMENT_STARTED)
+		l.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if err := remoteStorageManager.CopyLogSegmentData(metadata, data); err != nil {
+			return err
+		}
+		l.mu.Lock()
+		err = l.setRemoteSegmentState(state, metadata, REMOTE_LOG_SEGMENT_STATE_COPY_SEGMENT_FINISHED)
+		l.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		fmt.Println("Copied segment ", data.LogSegment, " to remote storage")
+	}
+}
+
+// remoteSegmentsToDelete returns, oldest first, the remote segments older
+// than retentionMs, those beyond retentionBytes counting the records only
+// kept locally, and those wholly below the log start offset. Segments a
+// copy or deletion of did not finish are returned too. Callers hold l.mu.
+func (l *PartitionLog) remoteSegmentsToDelete(nowMs int64, retentionMs int64, retentionBytes int64) ([]RemoteLogSegmentMetadata, error) {
+	expired := make([]RemoteLogSegmentMetadata, 0)
+	for _, segment := range l.remoteSegments {
+		if segment.State != REMOTE_LOG_SEGMENT_STATE_COPY_SEGMENT_FINISHED {
+			expired = append(expired, segment)
+		}
+	}
+
+	copied := l.copiedRemoteSegments()
+	totalSize := int64(0)
+	for _, segment := range copied {
+		totalSize += segment.SegmentSizeInBytes
+	}
+	segments, err := l.segmentFiles()
+	if err != nil {
+		return nil, err
+	}
+	remoteLogEndOffset := l.remoteLogEndOffset()
+	for i, segment := range segments {
+		nextBaseOffset := l.logEndOffset
+		if i+1 < len(segments) {
+			if nextBaseOffset, err = segmentBaseOffset(segments[i+1]); err != nil {
+				return nil, err
+			}
+		}
+		if nextBaseOffset <= remoteLogEndOffset+1 {
+			continue
+		}
+		info, err := os.Stat(segment)
+		if err != nil {
+			return nil, err
+		}
+		totalSize += info.Size()
+	}
+
+	for _, segment := range copied {
+		belowStart := segment.EndOffset < l.logStartOffset
+		tooOld := retentionMs >= 0 && nowMs-segment.MaxTimestampMs > retentionMs
+		oversized := retentionBytes >= 0 && totalSize-segment.SegmentSizeInBytes >= retentionBytes
+		if !belowStart && !tooOld && !oversized {
+			break
+		}
+		expired = append(expired, segment)
+		totalSize -= segment.SegmentSizeInBytes
+	}
+	return expired, nil
+}
+
+// deleteRemoteSegments applies retention to the remote segments of a log
+// this broker leads. The log start offset moves past each deleted segment
+// before its data is. It returns the number of segments deleted.
+func (l *PartitionLog) deleteRemoteSegments(state *PartitionState, nowMs int64) (int, error) {
+	retentionMs, retentionBytes := topicRetention(l.topicName)
+	l.mu.Lock()
+	expired, err := l.remoteSegmentsToDelete(nowMs, retentionMs, retentionBytes)
+	l.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, segment := range expired {
+		l.mu.Lock()
+		if segment.State == REMOTE_LOG_SEGMENT_STATE_COPY_SEGMENT_FINISHED {
+			l.logStartOffset = max(l.logStartOffset, min(segment.EndOffset+1, l.logEndOffset))
+			err = l.epochCache.truncateFromStart(l.logStartOffset)
+		}
+		if err == nil {
+			err = l.setRemoteSegmentState(state, &segment, REMOTE_LOG_SEGMENT_STATE_DELETE_SEGMENT_STARTED)
+		}
+		l.mu.Unlock()
+		if err != nil {
+			return deleted, err
+		}
+		if err := remoteStorageManager.DeleteLogSegmentData(&segment); err != nil {
+			return deleted, err
+		}
+		l.mu.Lock()
+		err = l.setRemoteSegmentState(state, &segment, REMOTE_LOG_SEGMENT_STATE_DELETE_SEGMENT_FINISHED)
+		l.mu.Unlock()
+		if err != nil {
+			return deleted, err
+		}
+		fmt.Println("Deleted remote segment ", segment.SegmentId.Id.String(), " of ", l.topicName, "-", l.partition)
+		deleted++
+	}
+
+	if deleted > 0 {
+		l.mu.Lock()
+		abortedTxns := make([]AbortedTxn, 0, len(l.abortedTxns))
+		for _, abortedTxn := range l.abortedTxns {
+			if abortedTxn.LastOffset >= l.logStartOffset {
+				abortedTxns = append(abortedTxns, abortedTxn)
+			}
+		}
+		l.abortedTxns = abortedTxns
+		l.mu.Unlock()
+	}
+	return deleted, nil
+}
+
+// remoteSegmentFor returns the remote segment holding offset, when the
+// offset is below the local segments.
+func (l *PartitionLog) remoteSegmentFor(offset int64) (*RemoteLogSegmentMetadata, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.remoteSegments) == 0 {
+		return nil, nil
+	}
+	localLogStartOffset, err := l.localLogStartOffset()
+	if err != nil || offset >= localLogStartOffset {
+		return nil, err
+	}
+	for _, segment := range l.copiedRemoteSegments() {
+		if segment.StartOffset <= offset && offset <= segment.EndOffset {
+			return &segment, nil
+		}
+	}
+	return nil, nil
+}
+
+// readRemoteRecords returns the encoded batches of a remote segment holding
+// offsets from fromOffset, stopping at the first batch at or past
+// maxOffset or past maxBytes, and the offset following the last batch
+// returned.
+func readRemoteRecords(segment *RemoteLogSegmentMetadata, fromOffset int64, maxOffset int64, maxBytes int) ([]byte, int64, error) {
+	reader, err := remoteStorageManager.FetchLogSegment(segment, 0)
+	if err != nil {
+		return nil, fromOffset, err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fromOffset, fmt.Errorf("unable to read remote segment: %w", err)
+	}
+	recordsReader := newRecordsReader(fromOffset, maxOffset, maxBytes)
+	if err := recordsReader.readSegment(bytes.NewReader(data), int64(len(data))); err != nil {
+		return nil, fromOffset, fmt.Errorf("unable to read remote segment %s: %w", segment.SegmentId.Id, err)
+	}
+	return recordsReader.records, recordsReader.nextOffset, nil
+}
+
+// remoteAbortedTxns returns the aborted transactions of the remote segments
+// below the local segments overlapping the offsets from fromOffset up to
+// upperBoundOffset.
+func (l *PartitionLog) remoteAbortedTxns(fromOffset int64, upperBoundOffset int64) ([]AbortedTxn, error) {
+	l.mu.Lock()
+	localLogStartOffset, err := l.localLogStartOffset()
+	segments := l.copiedRemoteSegments()
+	l.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	abortedTxns := make([]AbortedTxn, 0)
+	for _, segment := range segments {
+		if segment.StartOffset >= min(upperBoundOffset, localLogStartOffset) || segment.EndOffset < fromOffset {
+			continue
+		}
+		reader, err := remoteStorageManager.FetchIndex(&segment, INDEX_TYPE_TRANSACTION)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read remote transaction index: %w", err)
+		}
+		segmentAbortedTxns, err := decodeTransactionIndex(data)
+		if err != nil {
+			return nil, err
+		}
+		abortedTxns = append(abortedTxns, segmentAbortedTxns...)
+	}
+	return abortedTxns, nil
+}
+
+// manageRemoteLog reloads the remote segments of a log, then, when this
+// broker leads its partition, copies its closed segments to remote storage
+// and applies retention to the remote ones. It returns the number of remote
+// segments deleted.
+func manageRemoteLog(log *PartitionLog, nowMs int64) (int, error) {
+	if !topicRemoteStorageEnabled(log.topicName) {
+		return 0, nil
+	}
+	state, ok := partitionState(log.topicName, log.partition)
+	if !ok {
+		return 0, nil
+	}
+	if err := log.refreshRemoteSegments(state); err != nil {
+		return 0, err
+	}
+	if !state.isLeader() {
+		return 0, nil
+	}
+	if err := log.copySegmentsToRemote(state); err != nil {
+		return 0, err
+	}
+	return log.deleteRemoteSegments(state, nowMs)
+}
+
+// startRemoteLogManagerTask copies segments to remote storage and applies
+// retention to them every remote.log.manager.task.interval.ms, when
+// remote.log.storage.system.enable is set.
+func startRemoteLogManagerTask() {
+	if remoteStorageManager == nil {
+		return
+	}
+	runPeriodicTask(time.Duration(brokerConfig.RemoteLogManagerTaskIntervalMs)*time.Millisecond, func() {
+		deleted := 0
+		for _, log := range openPartitionLogs() {
+			n, err := manageRemoteLog(log, time.Now().UnixMilli())
+			deleted += n
+			if err != nil {
+				fmt.Println("Error managing the remote log of ", log.topicName, "-", log.partition, ": ", err.Error())
+			}
+		}
+		if deleted > 0 {
+			if err := checkpointLogStartOffsets(); err != nil {
+				fmt.Println("Error checkpointing log start offsets: ", err.Error())
+			}
+		}
+	})
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+// openTestTieredLog returns a log of test-topic with remote storage enabled
+// and 5 batches in segments of their own, led by this broker
+func openTestTieredLog(t *testing.T) (*PartitionLog, *PartitionState) {
+	t.Helper()
+	log := openTestPartitionLog(t, 1)
+	brokerConfig.NodeId = 1
+	brokerConfig.RemoteLogMetadataDir = t.TempDir()
+	previousManager := remoteStorageManager
+	remoteStorageManager = &LocalTieredStorage{Dir: t.TempDir()}
+	metadataMu.Lock()
+	topicConfigs[log.topicName] = map[string]string{"remote.storage.enable": "true"}
+	metadataMu.Unlock()
+	t.Cleanup(func() {
+		remoteStorageManager = previousManager
+		metadataMu.Lock()
+		defer metadataMu.Unlock()
+		delete(topicConfigs, log.topicName)
+	})
+	appendTestBatches(t, log, 5)
+	return log, &PartitionState{TopicId: ktypes.UUID{0xcc}, Leader: 1}
+}
+
+// remoteTestOffsets returns the start offsets and states of the remote
+// segments of a log
+func remoteTestOffsets(log *PartitionLog) ([]int64, []int8) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	offsets, states := make([]int64, 0), make([]int8, 0)
+	for _, segment := range log.remoteSegments {
+		offsets = append(offsets, segment.StartOffset)
+		states = append(states, segment.State)
+	}
+	return offsets, states
+}
+
+func TestRemoteLogMetadata(t *testing.T) {
+	topicId := ktypes.UUID{0xcc}
+	path := filepath.Join(t.TempDir(), "remote-topic-0")
+	segments, err := readRemoteLogMetadata(path, "remote-topic", 0, topicId)
+	if err != nil || len(segments) != 0 {
+		t.Fatalf("got segments %v, %v without metadata, want none", segments, err)
+	}
+
+	want := []RemoteLogSegmentMetadata{
+		{
+			SegmentId:   RemoteLogSegmentId{TopicId: topicId, TopicName: "remote-topic", Id: ktypes.UUID{1}},
+			StartOffset: 0, EndOffset: 9, MaxTimestampMs: 1000, SegmentSizeInBytes: 700,
+			SegmentLeaderEpochs: []EpochEntry{{1, 0}, {2, 5}},
+			State:               REMOTE_LOG_SEGMENT_STATE_COPY_SEGMENT_FINISHED,
+		},
+		{
+			SegmentId:   RemoteLogSegmentId{TopicId: topicId, TopicName: "remote-topic", Id: ktypes.UUID{2}},
+			StartOffset: 10, EndOffset: 19, MaxTimestampMs: 2000, SegmentSizeInBytes: 800,
+			SegmentLeaderEpochs: []EpochEntry{},
+			State:               REMOTE_LOG_SEGMENT_STATE_COPY_SEGMENT_STARTED,
+		},
+	}
+	if err := writeRemoteLogMetadata(path, want); err != nil {
+		t.Fatal(err)
+	}
+	segments, err = readRemoteLogMetadata(path, "remote-topic", 0, topicId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.EqualFunc(segments, want, func(a, b RemoteLogSegmentMetadata) bool {
+		return a.SegmentId == b.SegmentId && a.StartOffset == b.StartOffset && a.EndOffset == b.EndOffset &&
+			a.MaxTimestampMs == b.MaxTimestampMs && a.SegmentSizeInBytes == b.SegmentSizeInBytes &&
+			a.State == b.State && slices.Equal(a.SegmentLeaderEpochs, b.SegmentLeaderEpochs)
+	}) {
+		t.Errorf("got segments %+v, want %+v", segments, want)
+	}
+
+	malformed := []string{
+		"1\n0\n",
+		"0\n2\n01000000000000000000000000000000 0 9 1000 700 1 -\n",
+		"0\n1\n01 0 9 1000 700 1 -\n",
+		"0\n1\n01000000000000000000000000000000 0 9 1000 700 1\n",
+		"0\n1\n01000000000000000000000000000000 0 9 1000 700 1 1-0\n",
+	}
+	for _, data := range malformed {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readRemoteLogMetadata(path, "remote-topic", 0, topicId); err == nil {
+			t.Errorf("got no error reading %q", data)
+		}
+	}
+}
+
+func TestTopicLocalRetention(t *testing.T) {
+	previousConfig := brokerConfig
+	t.Cleanup(func() { brokerConfig = previousConfig })
+	brokerConfig.LogRetentionMs = 5000
+	brokerConfig.LogRetentionBytes = 900
+	metadataMu.Lock()
+	topicConfigs["local-retention-topic"] = map[string]string{"local.retention.ms": "100", "local.retention.bytes": "-2", "retention.bytes": "400"}
+	metadataMu.Unlock()
+	t.Cleanup(func() {
+		metadataMu.Lock()
+		defer metadataMu.Unlock()
+		delete(topicConfigs, "local-retention-topic")
+	})
+
+	tests := []struct {
+		name       string
+		localMs    int
+		localBytes int
+		topic      string
+		wantMs     int64
+		wantBytes  int64
+	}{
+		{"retention defaults", LOCAL_RETENTION_FROM_RETENTION, LOCAL_RETENTION_FROM_RETENTION, "other-topic", 5000, 900},
+		{"broker local retention", 200, -1, "other-topic", 200, -1},
+		{"topic overrides", 200, 300, "local-retention-topic", 100, 400},
+	}
+	for _, test := range tests {
+		brokerConfig.LogLocalRetentionMs, brokerConfig.LogLocalRetentionBytes = test.localMs, test.localBytes
+		ms, bytes := topicLocalRetention(test.topic)
+		if ms != test.wantMs || bytes != test.wantBytes {
+			t.Errorf("%s: got %dms, %d bytes, want %dms, %d bytes", test.name, ms, bytes, test.wantMs, test.wantBytes)
+		}
+	}
+}
+
+func TestTieredLog(t *testing.T) {
+	log, state := openTestTieredLog(t)
+	segments, err := log.segmentFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every segment but the active one is copied
+	if err := log.copySegmentsToRemote(state); err != nil {
+		t.Fatal(err)
+	}
+	closed := len(segments) - 1
+	wantOffsets, wantStates := make([]int64, closed), make([]int8, closed)
+	for i := range closed {
+		wantOffsets[i], wantStates[i] = int64(i), REMOTE_LOG_SEGMENT_STATE_COPY_SEGMENT_FINISHED
+	}
+	if offsets, states := remoteTestOffsets(log); !slices.Equal(offsets, wantOffsets) || !slices.Equal(states, wantStates) {
+		t.Fatalf("got remote segments at %v in states %v, want %v in %v", offsets, states, wantOffsets, wantStates)
+	}
+	persisted, err := readRemoteLogMetadata(remoteLogMetadataPath(log.topicName, log.partition, state.TopicId), log.topicName, log.partition, state.TopicId)
+	if err != nil || len(persisted) != closed {
+		t.Errorf("got %d persisted remote segments, %v, want %d", len(persisted), err, closed)
+	}
+	// Copies are not repeated
+	if err := log.copySegmentsToRemote(state); err != nil {
+		t.Fatal(err)
+	}
+	if offsets, _ := remoteTestOffsets(log); len(offsets) != closed {
+		t.Errorf("got %d remote segments after another pass, want %d", len(offsets), closed)
+	}
+
+	// Local retention only deletes copied segments and keeps the log start
+	brokerConfig.LogRetentionMs, brokerConfig.LogRetentionBytes = -1, -1
+	brokerConfig.LogLocalRetentionMs, brokerConfig.LogLocalRetentionBytes = -1, 0
+	deleted, err := log.deleteOldSegments(time.Now().UnixMilli())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != closed || log.LogStartOffset() != 0 {
+		t.Errorf("got %d local segments deleted, log start offset %d, want %d and 0", deleted, log.LogStartOffset(), closed)
+	}
+	for offset := int64(0); offset < log.LogEndOffset(); offset++ {
+		records, _, err := log.ReadRecords(offset, log.LogEndOffset(), 1<<20)
+		if err != nil {
+			t.Fatalf("reading offset %d: %v", offset, err)
+		}
+		if offsets := batchOffsets(t, records); len(offsets) == 0 || offsets[0] != offset {
+			t.Errorf("reading offset %d: got batches at %v", offset, offsets)
+		}
+	}
+
+	// Remote retention drops unfinished copies first, then expired segments
+	unfinished := RemoteLogSegmentMetadata{SegmentId: RemoteLogSegmentId{TopicId: state.TopicId, TopicName: log.topicName, Id: ktypes.UUID{9}}, StartOffset: 1}
+	log.mu.Lock()
+	err = log.setRemoteSegmentState(state, &unfinished, REMOTE_LOG_SEGMENT_STATE_COPY_SEGMENT_STARTED)
+	log.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted, err := log.deleteRemoteSegments(state, time.Now().UnixMilli()); err != nil || deleted != 1 {
+		t.Errorf("got %d remote segments deleted, %v, want the unfinished one", deleted, err)
+	}
+	brokerConfig.LogRetentionMs = 0
+	if deleted, err := log.deleteRemoteSegments(state, time.Now().UnixMilli()+1000); err != nil || deleted != closed {
+		t.Errorf("got %d remote segments deleted, %v, want %d", deleted, err, closed)
+	}
+	if offsets, _ := remoteTestOffsets(log); len(offsets) != 0 {
+		t.Errorf("got remote segments at %v, want none", offsets)
+	}
+	if start := log.LogStartOffset(); start != int64(closed) {
+		t.Errorf("got log start offset %d, want %d", start, closed)
+	}
+	persisted, err = readRemoteLogMetadata(remoteLogMetadataPath(log.topicName, log.partition, state.TopicId), log.topicName, log.partition, state.TopicId)
+	if err != nil || len(persisted) != 0 {
+		t.Errorf("got %d persisted remote segments, %v, want none", len(persisted), err)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	LOCAL_TIERED_STORAGE_CLASS_NAME = "org.apache.kafka.server.log.remote.storage.LocalTieredStorage"
+
+	// States a remote segment goes through, in order. Segments whose copy
+	// or deletion did not finish are cleaned up by the next leader.
+	REMOTE_LOG_SEGMENT_STATE_COPY_SEGMENT_STARTED    = 0
+	REMOTE_LOG_SEGMENT_STATE_COPY_SEGMENT_FINISHED   = 1
+	REMOTE_LOG_SEGMENT_STATE_DELETE_SEGMENT_STARTED  = 2
+	REMOTE_LOG_SEGMENT_STATE_DELETE_SEGMENT_FINISHED = 3
+
+	// Indexes copied along with a segment
+	INDEX_TYPE_TRANSACTION       = 0
+	INDEX_TYPE_PRODUCER_SNAPSHOT = 1
+	INDEX_TYPE_LEADER_EPOCH      = 2
+)
+
+// RemoteLogSegmentId identifies a copy of a segment in remote storage. A
+// segment copied twice, e.g. by two leaders, gets two ids.
+type RemoteLogSegmentId struct {
+	TopicId   ktypes.UUID
+	TopicName string
+	Partition int32
+	Id        ktypes.UUID
+}
+
+// RemoteLogSegmentMetadata describes a segment in remote storage.
+type RemoteLogSegmentMetadata struct {
+	SegmentId   RemoteLogSegmentId
+	StartOffset int64
+	// Last offset of the segment
+	EndOffset          int64
+	MaxTimestampMs     int64
+	SegmentSizeInBytes int64
+	// Leader epochs of the segment's records and the offsets they start at
+	SegmentLeaderEpochs []EpochEntry
+	State               int8
+}
+
+// LogSegmentData holds the local files of a segment to copy to remote
+// storage. Empty paths stand for indexes the segment does not have.
+type LogSegmentData struct {
+	LogSegment       string
+	TransactionIndex string
+	ProducerSnapshot string
+	// Leader epochs of the segment, in the leader epoch checkpoint format
+	LeaderEpochIndex []byte
+}
+
+// RemoteStorageManager stores closed segments and their indexes outside of
+// the broker, so partitions can keep more records than fit on local disk.
+// Remote log metadata is kept by the broker, the storage only holds data.
+type RemoteStorageManager interface {
+	CopyLogSegmentData(metadata *RemoteLogSegmentMetadata, data *LogSegmentData) error
+	// FetchLogSegment returns the segment's data from startPosition on
+	FetchLogSegment(metadata *RemoteLogSegmentMetadata, startPosition int64) (io.ReadCloser, error)
+	FetchIndex(metadata *RemoteLogSegmentMetadata, indexType int8) (io.ReadCloser, error)
+	DeleteLogSegmentData(metadata *RemoteLogSegmentMetadata) error
+}
+
+// RemoteStorageManager configured with remote.log.storage.manager.class.name,
+// nil when remote.log.storage.system.enable is off
+var remoteStorageManager RemoteStorageManager
+
+// LocalTieredStorage is a remote storage kept in a local folder, one
+// sub-folder per partition, for running tiered storage without a remote
+// system. Brokers sharing the folder share the storage.
+type LocalTieredStorage struct {
+	Dir string
+}
+
+var localTieredStorageSuffixes = map[int8]string{
+	INDEX_TYPE_TRANSACTION:       TRANSACTION_INDEX_SUFFIX,
+	INDEX_TYPE_PRODUCER_SNAPSHOT: PRODUCER_SNAPSHOT_SUFFIX,
+	INDEX_TYPE_LEADER_EPOCH:      ".leader_epoch_checkpoint",
+}
+
+// path returns the file of a remote segment with the given suffix.
+func (s *LocalTieredStorage) path(metadata *RemoteLogSegmentMetadata, suffix string) string {
+	id := metadata.SegmentId
+	partitionDir := fmt.Sprintf("%s-%d-%s", id.TopicName, id.Partition, id.TopicId)
+	return filepath.Join(s.Dir, partitionDir, fmt.Sprintf("%020d-%s%s", metadata.StartOffset, id.Id, suffix))
+}
+
+func (s *LocalTieredStorage) CopyLogSegmentData(metadata *RemoteLogSegmentMetadata, data *LogSegmentData) error {
+	segmentPath := s.path(metadata, ".log")
+	if err := os.MkdirAll(filepath.Dir(segmentPath), 0755); err != nil {
+		return fmt.Errorf("unable to create remote partition folder: %w", err)
+	}
+	if err := copyFile(data.LogSegment, segmentPath); err != nil {
+		return err
+	}
+	// Missing indexes are stored empty, so every index of a copied segment
+	// can be fetched
+	for indexType, source := range map[int8]string{
+		INDEX_TYPE_TRANSACTION:       data.TransactionIndex,
+		INDEX_TYPE_PRODUCER_SNAPSHOT: data.ProducerSnapshot,
+	} {
+		indexPath := s.path(metadata, localTieredStorageSuffixes[indexType])
+		if source == "" {
+			if err := os.WriteFile(indexPath, nil, 0644); err != nil {
+				return fmt.Errorf("unable to write remote index: %w", err)
+			}
+			continue
+		}
+		if err := copyFile(source, indexPath); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(s.path(metadata, localTieredStorageSuffixes[INDEX_TYPE_LEADER_EPOCH]), data.LeaderEpochIndex, 0644); err != nil {
+		return fmt.Errorf("unable to write remote index: %w", err)
+	}
+	return nil
+}
+
+func (s *LocalTieredStorage) FetchLogSegment(metadata *RemoteLogSegmentMetadata, startPosition int64) (io.ReadCloser, error) {
+	file, err := os.Open(s.path(metadata, ".log"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to open remote segment: %w", err)
+	}
+	if _, err := file.Seek(startPosition, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("unable to read remote segment: %w", err)
+	}
+	return file, nil
+}
+
+func (s *LocalTieredStorage) FetchIndex(metadata *RemoteLogSegmentMetadata, indexType int8) (io.ReadCloser, error) {
+	suffix, ok := localTieredStorageSuffixes[indexType]
+	if !ok {
+		return nil, fmt.Errorf("unknown index type %d", indexType)
+	}
+	file, err := os.Open(s.path(metadata, suffix))
+	if err != nil {
+		return nil, fmt.Errorf("unable to open remote index: %w", err)
+	}
+	return file, nil
+}
+
+func (s *LocalTieredStorage) DeleteLogSegmentData(metadata *RemoteLogSegmentMetadata) error {
+	suffixes := []string{".log"}
+	for _, suffix := range localTieredStorageSuffixes {
+		suffixes = append(suffixes, suffix)
+	}
+	for _, suffix := range suffixes {
+		if err := os.Remove(s.path(metadata, suffix)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to delete remote segment: %w", err)
+		}
+	}
+	return nil
+}
+
+// copyFile writes a copy of the file at source to target and syncs it.
+func copyFile(source string, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %w", source, err)
+	}
+	defer in.Close()
+	out, err := os.Create(target)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %w", target, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("unable to copy %s: %w", source, err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return fmt.Errorf("unable to sync %s: %w", target, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+// readTestRemoteFile reads what a remote storage fetch returned
+func readTestRemoteFile(t *testing.T, reader io.ReadCloser, err error) string {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestLocalTieredStorage(t *testing.T) {
+	storage := &LocalTieredStorage{Dir: t.TempDir()}
+	localDir := t.TempDir()
+	segment := filepath.Join(localDir, segmentFileName(10))
+	snapshot := filepath.Join(localDir, producerSnapshotFileName(20))
+	if err := os.WriteFile(segment, []byte("segment-data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(snapshot, []byte("snapshot"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	metadata := &RemoteLogSegmentMetadata{
+		SegmentId:   RemoteLogSegmentId{TopicId: ktypes.UUID{1}, TopicName: "remote-topic", Partition: 3, Id: ktypes.UUID{2}},
+		StartOffset: 10,
+		EndOffset:   19,
+	}
+	err := storage.CopyLogSegmentData(metadata, &LogSegmentData{
+		LogSegment:       segment,
+		ProducerSnapshot: snapshot,
+		LeaderEpochIndex: []byte("epochs"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := storage.FetchLogSegment(metadata, 0)
+	if got := readTestRemoteFile(t, reader, err); got != "segment-data" {
+		t.Errorf("got segment %q, want %q", got, "segment-data")
+	}
+	reader, err = storage.FetchLogSegment(metadata, 8)
+	if got := readTestRemoteFile(t, reader, err); got != "data" {
+		t.Errorf("got segment from position 8 %q, want %q", got, "data")
+	}
+	tests := []struct {
+		indexType int8
+		want      string
+	}{
+		{INDEX_TYPE_TRANSACTION, ""},
+		{INDEX_TYPE_PRODUCER_SNAPSHOT, "snapshot"},
+		{INDEX_TYPE_LEADER_EPOCH, "epochs"},
+	}
+	for _, test := range tests {
+		reader, err := storage.FetchIndex(metadata, test.indexType)
+		if got := readTestRemoteFile(t, reader, err); got != test.want {
+			t.Errorf("index %d: got %q, want %q", test.indexType, got, test.want)
+		}
+	}
+	if _, err := storage.FetchIndex(metadata, 9); err == nil {
+		t.Error("got no error fetching an unknown index type")
+	}
+
+	// Another copy of the same segment is stored apart
+	other := *metadata
+	other.SegmentId.Id = ktypes.UUID{3}
+	if _, err := storage.FetchLogSegment(&other, 0); err == nil {
+		t.Error("got no error fetching a segment never copied")
+	}
+
+	if err := storage.DeleteLogSegmentData(metadata); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.DeleteLogSegmentData(metadata); err != nil {
+		t.Errorf("got error %v deleting a deleted segment", err)
+	}
+	files, err := os.ReadDir(filepath.Join(storage.Dir, "remote-topic-3-"+metadata.SegmentId.TopicId.String()))
+	if err != nil || len(files) != 0 {
+		t.Errorf("got files %v, %v left after deletion, want none", files, err)
+	}
+}
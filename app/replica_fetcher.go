@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	REPLICA_FETCH_VERSION = 16
+	// Session epoch of fetch requests that do not use a fetch session
+	FETCH_SESSION_FINAL_EPOCH = -1
+)
+
+type FetcherPartition struct {
+	topicName   string
+	partition   int32
+	topicId     ktypes.UUID
+	leaderEpoch int32
+}
+
+// ReplicaFetcher copies the partitions this broker follows from their
+// leader, one fetcher per leader.
+type ReplicaFetcher struct {
+	leaderId   int32
+	mu         sync.Mutex
+	partitions map[TopicPartition]FetcherPartition
+	client     *BrokerClient
+	// Closed once the fetcher stopped on shutdown
+	stopped chan struct{}
+}
+
+// Fetchers by leader, guarded by replicaFetchersMu
+var (
+	replicaFetchersMu sync.Mutex
+	replicaFetchers   = make(map[int32]*ReplicaFetcher)
+)
+
+// addFetcherPartition starts fetching a partition from its leader.
+func addFetcherPartition(leaderId int32, topicName string, partition int32, topicId ktypes.UUID, leaderEpoch int32) {
+	replicaFetchersMu.Lock()
+	fetcher, ok := replicaFetchers[leaderId]
+	if !ok {
+		fetcher = &ReplicaFetcher{leaderId: leaderId, partitions: make(map[TopicPartition]FetcherPartition), stopped: make(chan struct{})}
+		replicaFetchers[leaderId] = fetcher
+		go fetcher.run()
+	}
+	replicaFetchersMu.Unlock()
+
+	fetcher.mu.Lock()
+	defer fetcher.mu.Unlock()
+	fetcher.partitions[TopicPartition{topicName, partition}] = FetcherPartition{topicName, partition, topicId, leaderEpoch}
+}
+
+// removeFetcherPartition stops fetching a partition, when this broker leads
+// it or no longer has a replica of it.
+func removeFetcherPartition(topicName string, partition int32) {
+	replicaFetchersMu.Lock()
+	defer replicaFetchersMu.Unlock()
+	for _, fetcher := range replicaFetchers {
+		fetcher.mu.Lock()
+		delete(fetcher.partitions, TopicPartition{topicName, partition})
+		fetcher.mu.Unlock()
+	}
+}
+
+// stopReplicaFetchers waits for the replica fetchers to stop, once the
+// background tasks are stopped.
+func stopReplicaFetchers() {
+	replicaFetchersMu.Lock()
+	fetchers := slices.Collect(maps.Values(replicaFetchers))
+	replicaFetchersMu.Unlock()
+	for _, fetcher := range fetchers {
+		<-fetcher.stopped
+	}
+}
+
+func (f *ReplicaFetcher) run() {
+	defer close(f.stopped)
+	backoff := time.Duration(brokerConfig.ReplicaFetchBackoffMs) * time.Millisecond
+	for !tasksStopped() {
+		f.mu.Lock()
+		partitions := make([]FetcherPartition, 0, len(f.partitions))
+		for _, partition := range f.partitions {
+			partitions = append(partitions, partition)
+		}
+		f.mu.Unlock()
+		if len(partitions) == 0 {
+			pauseTask(backoff)
+			continue
+		}
+
+		if err := f.fetch(partitions); err != nil {
+			fmt.Println("Error fetching from broker ", f.leaderId, ": ", err.Error())
+			if f.client != nil {
+				f.client.Close()
+				f.client = nil
+			}
+			pauseTask(backoff)
+		}
+	}
+	if f.client != nil {
+		f.client.Close()
+	}
+}
+
+// fetch sends one fetch request for the partitions and appends the
+// records the leader returns.
+func (f *ReplicaFetcher) fetch(partitions []FetcherPartition) error {
+	if f.client == nil {
+		client, err := dialBroker(f.leaderId)
+		if err != nil {
+			return err
+		}
+		f.client = client
+	}
+
+	replicaState, err := ktypes.NewKEncoder().Encode(&FetchRequestReplicaState{
+		ReplicaId:    ktypes.Int32(brokerConfig.NodeId),
+		ReplicaEpoch: ktypes.Int64(-1),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode fetch replica state: %v", err)
+	}
+	requestBody := FetchRequestBody{
+		MaxWaitTimeMs:  ktypes.Int32(brokerConfig.ReplicaFetchWaitMaxMs),
+		MinBytes:       ktypes.Int32(1),
+		MaxBytes:       ktypes.Int32(brokerConfig.ReplicaFetchMaxBytes),
+		IsolationLevel: ktypes.Int8(ISOLATION_LEVEL_READ_UNCOMMITTED),
+		SessionEpoch:   ktypes.Int32(FETCH_SESSION_FINAL_EPOCH),
+		Topics:         []FetchRequestTopic{},
+		ForgettenTopic: []FetchRequestForgettenTopic{},
+		TaggedFields:   ktypes.TaggedFieldValues{FETCH_REPLICA_STATE_TAG: replicaState},
+	}
+	logs := make(map[ktypes.UUID]map[int32]*PartitionLog)
+	topicIndexes := make(map[ktypes.UUID]int)
+	for _, partition := range partitions {
+		log, err := getPartitionLog(partition.topicName, partition.partition)
+		if errorCodeFromError(err) == ERROR_CODE_KAFKA_STORAGE_ERROR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if logs[partition.topicId] == nil {
+			logs[partition.topicId] = make(map[int32]*PartitionLog)
+			topicIndexes[partition.topicId] = len(requestBody.Topics)
+			requestBody.Topics = append(requestBody.Topics, FetchRequestTopic{TopicId: partition.topicId})
+		}
+		logs[partition.topicId][partition.partition] = log
+
+		topic := &requestBody.Topics[topicIndexes[partition.topicId]]
+		topic.Partitions = append(topic.Partitions, FetchRequestPartition{
+			Partition:          ktypes.Int32(partition.partition),
+			CurrentLeaderEpoch: ktypes.Int32(partition.leaderEpoch),
+			FetchOffset:        ktypes.Int64(log.LogEndOffset()),
+			LastFetchedEpoch:   ktypes.Int32(log.LatestEpoch()),
+			LogStartOffset:     ktypes.Int64(log.LogStartOffset()),
+			PartitionMaxBytes:  ktypes.Int32(brokerConfig.ReplicaFetchMaxBytes),
+		})
+	}
+
+	if len(requestBody.Topics) == 0 {
+		return nil
+	}
+
+	var responseBody FetchResponseBody
+	if err := f.client.send(FETCH_REQUEST_KEY, REPLICA_FETCH_VERSION, &requestBody, &responseBody); err != nil {
+		return err
+	}
+	if responseBody.ErrorCode != ERROR_CODE_NONE {
+		return fmt.Errorf("fetch failed with error %d", responseBody.ErrorCode)
+	}
+
+	failed := 0
+	for _, topic := range responseBody.Responses {
+		for _, partition := range topic.Partitions {
+			log, ok := logs[topic.TopicId][int32(partition.PartitionIndex)]
+			if !ok {
+				continue
+			}
+			if err := checkStorageError(log, processFetchedPartition(log, &partition)); err != nil {
+				fmt.Println("Error replicating ", log.dir, ": ", err.Error())
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		time.Sleep(time.Duration(brokerConfig.ReplicaFetchBackoffMs) * time.Millisecond)
+	}
+	return nil
+}
+
+// processFetchedPartition appends the records fetched for a partition and
+// follows the leader's high watermark. A diverging epoch means the follower
+// has records the leader does not, a fetch offset the leader does not have
+// that the follower fell behind the leader's log start.
+func processFetchedPartition(log *PartitionLog, partition *FetchResponsePartition) error {
+	switch partition.ErrorCode {
+	case ERROR_CODE_NONE:
+		if value, ok := partition.TaggedFields[FETCH_DIVERGING_EPOCH_TAG]; ok {
+			var divergingEpoch FetchResponseEpochEndOffset
+			if err := ktypes.NewKDecoder(value).Decode(&divergingEpoch); err != nil {
+				return fmt.Errorf("failed to decode diverging epoch: %v", err)
+			}
+			return truncateToDivergingEpoch(log, &divergingEpoch)
+		}
+		if err := log.AppendReplicaBatches(partition.Records); err != nil {
+			return err
+		}
+		log.updateHighWatermark(int64(partition.HighWatermark))
+		return nil
+	case ERROR_CODE_OFFSET_OUT_OF_RANGE:
+		leaderLogStartOffset := int64(partition.LogStartOffset)
+		if log.LogEndOffset() < leaderLogStartOffset {
+			return log.TruncateFullyAndStartAt(leaderLogStartOffset)
+		}
+		_, err := log.TruncateTo(int64(partition.HighWatermark))
+		return err
+	default:
+		return newKafkaError(partition.ErrorCode, "leader returned error %d", partition.ErrorCode)
+	}
+}
+
+// truncateToDivergingEpoch truncates a follower's log to where it last
+// agrees with the leader's: the end of the diverging epoch on the leader,
+// or the end of the follower's own copy of that epoch when shorter. Earlier
+// divergences are found by the following fetches.
+func truncateToDivergingEpoch(log *PartitionLog, divergingEpoch *FetchResponseEpochEndOffset) error {
+	truncationOffset := log.HighWatermark()
+	if divergingEpoch.EndOffset != UNDEFINED_EPOCH_OFFSET {
+		_, followerEndOffset := log.EndOffsetForEpoch(int32(divergingEpoch.Epoch))
+		truncationOffset = int64(divergingEpoch.EndOffset)
+		if followerEndOffset != UNDEFINED_EPOCH_OFFSET {
+			truncationOffset = min(truncationOffset, followerEndOffset)
+		}
+	}
+	logEndOffset, err := log.TruncateTo(truncationOffset)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Truncated ", log.dir, " to ", logEndOffset, " after diverging from the leader at epoch ", divergingEpoch.Epoch)
+	return nil
+}
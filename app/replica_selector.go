@@ -0,0 +1,109 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+const (
+	RACK_AWARE_REPLICA_SELECTOR_CLASS_NAME = "org.apache.kafka.common.replica.RackAwareReplicaSelector"
+)
+
+// ClientMetadata describes the consumer sending a fetch request.
+type ClientMetadata struct {
+	RackId    string
+	ClientId  string
+	Address   string
+	Principal string
+}
+
+// ReplicaView is what the leader knows of a replica of a partition it leads.
+type ReplicaView struct {
+	BrokerId     int32
+	LogEndOffset int64
+	// Last time the replica had every record of the leader
+	LastCaughtUpTimeMs int64
+}
+
+// ReplicaSelector picks the replica a consumer should fetch a partition
+// from, among its leader and the in-sync followers having the fetch offset.
+type ReplicaSelector interface {
+	Select(topicName string, partitionId int32, client *ClientMetadata, leader ReplicaView, followers []ReplicaView) ReplicaView
+}
+
+// ReplicaSelector configured with replica.selector.class, nil when the
+// leader serves every consumer
+var replicaSelector ReplicaSelector
+
+// RackAwareReplicaSelector picks a replica in the rack of the consumer, the
+// leader if it is there, else the follower that is the most caught up.
+// Consumers in no rack or in a rack without replicas fetch from the leader.
+type RackAwareReplicaSelector struct{}
+
+func (s *RackAwareReplicaSelector) Select(topicName string, partitionId int32, client *ClientMetadata, leader ReplicaView, followers []ReplicaView) ReplicaView {
+	if client.RackId == "" || brokerRack(leader.BrokerId) == client.RackId {
+		return leader
+	}
+	sameRack := slices.DeleteFunc(slices.Clone(followers), func(follower ReplicaView) bool {
+		return brokerRack(follower.BrokerId) != client.RackId
+	})
+	if len(sameRack) == 0 {
+		return leader
+	}
+	return slices.MaxFunc(sameRack, func(a, b ReplicaView) int {
+		if a.LogEndOffset != b.LogEndOffset {
+			return cmp.Compare(a.LogEndOffset, b.LogEndOffset)
+		}
+		return cmp.Compare(a.LastCaughtUpTimeMs, b.LastCaughtUpTimeMs)
+	})
+}
+
+// newClientMetadata describes the consumer sending a fetch request.
+func newClientMetadata(req *Request, rackId string) *ClientMetadata {
+	return &ClientMetadata{
+		RackId:    rackId,
+		ClientId:  string(req.ClientId),
+		Address:   clientAddress(req.ClientHost),
+		Principal: req.Session.Principal,
+	}
+}
+
+// preferredReadReplica returns the follower a consumer fetching a partition
+// from fetchOffset should be sent to, -1 when it should keep fetching from
+// this broker.
+func preferredReadReplica(client *ClientMetadata, log *PartitionLog, fetchOffset int64) int32 {
+	if replicaSelector == nil {
+		return -1
+	}
+	state, ok := partitionState(log.topicName, log.partition)
+	if !ok || !state.isLeader() {
+		return -1
+	}
+
+	leader := ReplicaView{
+		BrokerId:           int32(brokerConfig.NodeId),
+		LogEndOffset:       log.LogEndOffset(),
+		LastCaughtUpTimeMs: time.Now().UnixMilli(),
+	}
+	followers := make([]ReplicaView, 0, len(state.Isr))
+	followerStatesMu.Lock()
+	for _, replicaId := range state.Isr {
+		follower, ok := followerStates[log.dir][replicaId]
+		if !ok || follower.logEndOffset < fetchOffset {
+			continue
+		}
+		followers = append(followers, ReplicaView{
+			BrokerId:           replicaId,
+			LogEndOffset:       follower.logEndOffset,
+			LastCaughtUpTimeMs: follower.lastCaughtUpTimeMs,
+		})
+	}
+	followerStatesMu.Unlock()
+
+	selected := replicaSelector.Select(log.topicName, log.partition, client, leader, followers)
+	if selected.BrokerId == leader.BrokerId {
+		return -1
+	}
+	return selected.BrokerId
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+func TestRackAwareReplicaSelector(t *testing.T) {
+	setTestBrokerRegistrations(t,
+		&BrokerRegistration{Id: 1, Rack: "rack-a"},
+		&BrokerRegistration{Id: 2, Rack: "rack-b"},
+		&BrokerRegistration{Id: 3, Rack: "rack-b"},
+		&BrokerRegistration{Id: 4, Rack: "rack-c"},
+	)
+	leader := ReplicaView{BrokerId: 1, LogEndOffset: 10, LastCaughtUpTimeMs: 300}
+	followers := []ReplicaView{
+		{BrokerId: 2, LogEndOffset: 10, LastCaughtUpTimeMs: 100},
+		{BrokerId: 3, LogEndOffset: 10, LastCaughtUpTimeMs: 200},
+		{BrokerId: 4, LogEndOffset: 5, LastCaughtUpTimeMs: 100},
+	}
+
+	tests := []struct {
+		name      string
+		rack      string
+		followers []ReplicaView
+		want      int32
+	}{
+		{"no rack", "", followers, 1},
+		{"rack of the leader", "rack-a", followers, 1},
+		{"most caught up in the rack", "rack-b", followers, 3},
+		{"only follower in the rack", "rack-c", followers, 4},
+		{"rack without replicas", "rack-d", followers, 1},
+		{"rack follower missing the offset", "rack-b", followers[2:], 1},
+		{"furthest log end first", "rack-b", []ReplicaView{{BrokerId: 2, LogEndOffset: 11}, followers[1]}, 2},
+	}
+	selector := &RackAwareReplicaSelector{}
+	for _, test := range tests {
+		client := &ClientMetadata{RackId: test.rack}
+		if got := selector.Select("test-topic", 0, client, leader, test.followers); got.BrokerId != test.want {
+			t.Errorf("%s: got replica %d, want %d", test.name, got.BrokerId, test.want)
+		}
+	}
+}
+
+func TestPreferredReadReplica(t *testing.T) {
+	setTestPartition(t)
+	log := openTestPartitionLog(t, 1<<20)
+	appendTestBatches(t, log, 5)
+	testTopicId := ktypes.UUID{0xbb}
+	metadataMu.Lock()
+	brokerRegistrations[2].Rack = "rack-b"
+	brokerRegistrations[3].Rack = "rack-b"
+	topicNameToTopicId[log.topicName] = testTopicId
+	topicIdToTopicName[testTopicId] = log.topicName
+	topicIdToPartitions[testTopicId] = []PartitionRecordValue{{
+		TopicId:        testTopicId,
+		Replicas:       ktypes.CompactArray[ktypes.Int32]{1, 2, 3},
+		InSyncReplicas: ktypes.CompactArray[ktypes.Int32]{1, 2},
+		Leader:         1,
+	}}
+	metadataMu.Unlock()
+
+	followerStatesMu.Lock()
+	followerStates[log.dir] = map[int32]*FollowerState{2: {logEndOffset: 3}, 3: {logEndOffset: 5}}
+	followerStatesMu.Unlock()
+	previousSelector := replicaSelector
+	t.Cleanup(func() {
+		replicaSelector = previousSelector
+		followerStatesMu.Lock()
+		defer followerStatesMu.Unlock()
+		delete(followerStates, log.dir)
+	})
+
+	tests := []struct {
+		name        string
+		selector    ReplicaSelector
+		nodeId      int
+		rack        string
+		fetchOffset int64
+		want        int32
+	}{
+		{"no selector", nil, 1, "rack-b", 2, -1},
+		{"in-sync follower in the rack", &RackAwareReplicaSelector{}, 1, "rack-b", 2, 2},
+		{"follower without the offset", &RackAwareReplicaSelector{}, 1, "rack-b", 4, -1},
+		{"rack of the leader", &RackAwareReplicaSelector{}, 1, "", 2, -1},
+		{"not the leader", &RackAwareReplicaSelector{}, 2, "rack-b", 2, -1},
+	}
+	for _, test := range tests {
+		replicaSelector = test.selector
+		brokerConfig.NodeId = test.nodeId
+		if got := preferredReadReplica(&ClientMetadata{RackId: test.rack}, log, test.fetchOffset); got != test.want {
+			t.Errorf("%s: got preferred read replica %d, want %d", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,841 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	PARTITION_CHANGE_ISR_TAG      = 0
+	PARTITION_CHANGE_LEADER_TAG   = 1
+	PARTITION_CHANGE_REPLICAS_TAG = 2
+	// Replicas a reassignment takes off the partition, and puts on it
+	PARTITION_CHANGE_REMOVING_REPLICAS_TAG = 3
+	PARTITION_CHANGE_ADDING_REPLICAS_TAG   = 4
+	// Log directory of each replica (KIP-858)
+	PARTITION_CHANGE_DIRECTORIES_TAG = 6
+	// Replicas that may lead though out of the ISR, and the last known ones
+	// of a partition left with no leader (KIP-966)
+	PARTITION_CHANGE_ELIGIBLE_LEADER_REPLICAS_TAG = 7
+	PARTITION_CHANGE_LAST_KNOWN_ELR_TAG           = 8
+
+	NO_LEADER = -1
+
+	REPLICATION_OFFSET_CHECKPOINT_FILE = "replication-offset-checkpoint"
+)
+
+// Changes the leader, in-sync replicas, replicas, ongoing reassignment or
+// replica log directories of a partition. Only the fields changed are set, as tagged fields.
+type PartitionChangeRecordValue struct {
+	Header       RecordValueHeader        `order:"1"`
+	PartitionId  ktypes.Int32             `order:"2"`
+	TopicId      ktypes.UUID              `order:"3"`
+	TaggedFields ktypes.TaggedFieldValues `order:"4"`
+}
+
+// Payload of the tagged fields listing brokers
+type BrokerIdList struct {
+	Ids ktypes.CompactArray[ktypes.Int32] `order:"1"`
+}
+
+// Payload of the tagged field holding the new leader
+type BrokerIdValue struct {
+	Id ktypes.Int32 `order:"1"`
+}
+
+// partitionRecordFor returns the metadata of a partition, callers hold
+// metadataMu.
+func partitionRecordFor(topicId ktypes.UUID, partitionId int32) (*PartitionRecordValue, bool) {
+	partitions := topicIdToPartitions[topicId]
+	for i := range partitions {
+		if int32(partitions[i].PartitionId) == partitionId {
+			return &partitions[i], true
+		}
+	}
+	return nil, false
+}
+
+// replicaDirectory returns the log directory holding a broker's replica of
+// a partition, UNASSIGNED when it is not known. Callers hold metadataMu.
+func replicaDirectory(partition *PartitionRecordValue, brokerId int32) ktypes.UUID {
+	i := slices.Index(toInt32Slice(partition.Replicas), brokerId)
+	if i < 0 || i >= len(partition.Directories) {
+		return DIRECTORY_ID_UNASSIGNED
+	}
+	return partition.Directories[i]
+}
+
+// applyPartitionChangeRecord updates a partition's metadata. The partition
+// epoch moves on every change, the leader epoch when the leader changes.
+// Replicas keep their log directory when the replicas change, new replicas
+// start UNASSIGNED.
+func applyPartitionChangeRecord(record *PartitionChangeRecordValue) error {
+	partition, ok := partitionRecordFor(record.TopicId, int32(record.PartitionId))
+	if !ok {
+		return fmt.Errorf("partition change for unknown partition %d of topic %s", record.PartitionId, record.TopicId)
+	}
+
+	if value, ok := record.TaggedFields[PARTITION_CHANGE_ISR_TAG]; ok {
+		var isr BrokerIdList
+		if err := ktypes.NewKDecoder(value).Decode(&isr); err != nil {
+			return fmt.Errorf("invalid partition change ISR: %w", err)
+		}
+		partition.InSyncReplicas = isr.Ids
+	}
+	if value, ok := record.TaggedFields[PARTITION_CHANGE_REPLICAS_TAG]; ok {
+		var replicas BrokerIdList
+		if err := ktypes.NewKDecoder(value).Decode(&replicas); err != nil {
+			return fmt.Errorf("invalid partition change replicas: %w", err)
+		}
+		directories := make([]ktypes.UUID, len(replicas.Ids))
+		for i, replicaId := range replicas.Ids {
+			directories[i] = replicaDirectory(partition, int32(replicaId))
+		}
+		partition.Directories = directories
+		partition.Replicas = replicas.Ids
+	}
+	if value, ok := record.TaggedFields[PARTITION_CHANGE_DIRECTORIES_TAG]; ok {
+		var directories DirectoryIdList
+		if err := ktypes.NewKDecoder(value).Decode(&directories); err != nil {
+			return fmt.Errorf("invalid partition change directories: %w", err)
+		}
+		partition.Directories = directories.Ids
+	}
+	if value, ok := record.TaggedFields[PARTITION_CHANGE_REMOVING_REPLICAS_TAG]; ok {
+		var removing BrokerIdList
+		if err := ktypes.NewKDecoder(value).Decode(&removing); err != nil {
+			return fmt.Errorf("invalid partition change removing replicas: %w", err)
+		}
+		partition.RemovingReplicas = removing.Ids
+	}
+	if value, ok := record.TaggedFields[PARTITION_CHANGE_ADDING_REPLICAS_TAG]; ok {
+		var adding BrokerIdList
+		if err := ktypes.NewKDecoder(value).Decode(&adding); err != nil {
+			return fmt.Errorf("invalid partition change adding replicas: %w", err)
+		}
+		partition.AddingReplicas = adding.Ids
+	}
+	if value, ok := record.TaggedFields[PARTITION_CHANGE_ELIGIBLE_LEADER_REPLICAS_TAG]; ok {
+		var elr BrokerIdList
+		if err := ktypes.NewKDecoder(value).Decode(&elr); err != nil {
+			return fmt.Errorf("invalid partition change eligible leader replicas: %w", err)
+		}
+		partition.eligibleLeaderReplicas = toInt32Slice(elr.Ids)
+	}
+	if value, ok := record.TaggedFields[PARTITION_CHANGE_LAST_KNOWN_ELR_TAG]; ok {
+		var lastKnownElr BrokerIdList
+		if err := ktypes.NewKDecoder(value).Decode(&lastKnownElr); err != nil {
+			return fmt.Errorf("invalid partition change last known ELR: %w", err)
+		}
+		partition.lastKnownElr = toInt32Slice(lastKnownElr.Ids)
+	}
+	if value, ok := record.TaggedFields[PARTITION_CHANGE_LEADER_TAG]; ok {
+		var leader BrokerIdValue
+		if err := ktypes.NewKDecoder(value).Decode(&leader); err != nil {
+			return fmt.Errorf("invalid partition change leader: %w", err)
+		}
+		if leader.Id != partition.Leader {
+			partition.Leader = leader.Id
+			partition.LeaderEpoch++
+		}
+	}
+	partition.PartitionEpoch++
+	return nil
+}
+
+// newBrokerIdListField encodes a list of brokers as a tagged field value.
+func newBrokerIdListField(ids []int32) []byte {
+	list := BrokerIdList{Ids: make([]ktypes.Int32, len(ids))}
+	for i, id := range ids {
+		list.Ids[i] = ktypes.Int32(id)
+	}
+	encoded, err := ktypes.NewKEncoder().Encode(&list)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode broker id list: %v", err))
+	}
+	return encoded
+}
+
+// newBrokerIdField encodes a broker as a tagged field value.
+func newBrokerIdField(id int32) []byte {
+	encoded, err := ktypes.NewKEncoder().Encode(&BrokerIdValue{Id: ktypes.Int32(id)})
+	if err != nil {
+		panic(fmt.Sprintf("Failed to encode broker id: %v", err))
+	}
+	return encoded
+}
+
+// electLeader returns the first of the replicas that is in the ISR and on
+// an unfenced broker, or NO_LEADER. Callers hold metadataMu.
+func electLeader(replicas []int32, isr []int32) int32 {
+	for _, replicaId := range replicas {
+		if slices.Contains(isr, replicaId) && isUnfencedBroker(replicaId) {
+			return replicaId
+		}
+	}
+	return NO_LEADER
+}
+
+// setEligibleLeaderReplicas adds to a partition change the eligible leader
+// replicas following from its new ISR and leader. Replicas leaving an ISR
+// below min.insync.replicas have every committed record, they stay eligible
+// to lead until the ISR is back to min.insync.replicas. A partition left
+// with no leader nor ELR keeps its last leader as last known ELR. Callers
+// hold metadataMu.
+func setEligibleLeaderReplicas(fields ktypes.TaggedFieldValues, topicId ktypes.UUID, partition *PartitionRecordValue, replicas []int32, isr []int32, leaderId int32) {
+	elr := make([]int32, 0)
+	if len(isr) < minInsyncReplicas(topicIdToTopicName[topicId]) {
+		for _, replicaId := range append(slices.Clone(partition.eligibleLeaderReplicas), toInt32Slice(partition.InSyncReplicas)...) {
+			if !slices.Contains(isr, replicaId) && slices.Contains(replicas, replicaId) && !slices.Contains(elr, replicaId) {
+				elr = append(elr, replicaId)
+			}
+		}
+	}
+	lastKnownElr := make([]int32, 0)
+	switch {
+	case leaderId != NO_LEADER || len(elr) > 0:
+	case partition.Leader != NO_LEADER:
+		lastKnownElr = []int32{int32(partition.Leader)}
+	default:
+		lastKnownElr = partition.lastKnownElr
+	}
+
+	if !slices.Equal(elr, partition.eligibleLeaderReplicas) && (len(elr) > 0 || len(partition.eligibleLeaderReplicas) > 0) {
+		fields[PARTITION_CHANGE_ELIGIBLE_LEADER_REPLICAS_TAG] = newBrokerIdListField(elr)
+	}
+	if !slices.Equal(lastKnownElr, partition.lastKnownElr) && (len(lastKnownElr) > 0 || len(partition.lastKnownElr) > 0) {
+		fields[PARTITION_CHANGE_LAST_KNOWN_ELR_TAG] = newBrokerIdListField(lastKnownElr)
+	}
+}
+
+// changePartition records a new leader and ISR for a partition, with only
+// the fields that change. Callers hold metadataWriteMu and metadataMu.
+func changePartition(topicId ktypes.UUID, partitionId int32, leaderId int32, isr []int32) error {
+	partition, ok := partitionRecordFor(topicId, partitionId)
+	if !ok {
+		return newKafkaError(ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION, "unknown partition %d of topic %s", partitionId, topicId)
+	}
+	fields := ktypes.TaggedFieldValues{}
+	if !slices.Equal(toInt32Slice(partition.InSyncReplicas), isr) {
+		fields[PARTITION_CHANGE_ISR_TAG] = newBrokerIdListField(isr)
+	}
+	if leaderId != int32(partition.Leader) {
+		fields[PARTITION_CHANGE_LEADER_TAG] = newBrokerIdField(leaderId)
+	}
+	setEligibleLeaderReplicas(fields, topicId, partition, toInt32Slice(partition.Replicas), isr, leaderId)
+	if len(fields) == 0 {
+		return nil
+	}
+	return appendMetadataRecord(&PartitionChangeRecordValue{
+		Header: RecordValueHeader{
+			FrameVersion: ktypes.Int8(METADATA_RECORD_FRAME_VERSION),
+			RecordType:   ktypes.Int8(PARTITION_CHANGE_RECORD_TYPE),
+		},
+		PartitionId:  ktypes.Int32(partitionId),
+		TopicId:      topicId,
+		TaggedFields: fields,
+	})
+}
+
+// changePartitionIsr records the ISR a partition leader asks for, once it
+// is checked against the partition's metadata. Callers hold metadataWriteMu
+// and metadataMu.
+func changePartitionIsr(topicId ktypes.UUID, partitionId int32, leaderId int32, leaderEpoch int32, partitionEpoch int32, isr []int32) (*PartitionRecordValue, error) {
+	partition, ok := partitionRecordFor(topicId, partitionId)
+	if !ok {
+		return nil, newKafkaError(ERROR_CODE_UNKNOWN_TOPIC_OR_PARTITION, "unknown partition %d of topic %s", partitionId, topicId)
+	}
+	if leaderId != int32(partition.Leader) {
+		return nil, newKafkaError(ERROR_CODE_NOT_LEADER_OR_FOLLOWER, "broker %d is not the leader of partition %d of topic %s", leaderId, partitionId, topicId)
+	}
+	if leaderEpoch != int32(partition.LeaderEpoch) {
+		return nil, newKafkaError(ERROR_CODE_FENCED_LEADER_EPOCH, "leader epoch %d of partition %d of topic %s is not %d", leaderEpoch, partitionId, topicId, partition.LeaderEpoch)
+	}
+	if partitionEpoch != int32(partition.PartitionEpoch) {
+		return nil, newKafkaError(ERROR_CODE_INVALID_UPDATE_VERSION, "partition epoch %d of partition %d of topic %s is not %d", partitionEpoch, partitionId, topicId, partition.PartitionEpoch)
+	}
+	replicas := toInt32Slice(partition.Replicas)
+	currentIsr := toInt32Slice(partition.InSyncReplicas)
+	for _, replicaId := range isr {
+		if !slices.Contains(replicas, replicaId) {
+			return nil, newKafkaError(ERROR_CODE_INELIGIBLE_REPLICA, "broker %d is not a replica of partition %d of topic %s", replicaId, partitionId, topicId)
+		}
+		if !slices.Contains(currentIsr, replicaId) && !isUnfencedBroker(replicaId) {
+			return nil, newKafkaError(ERROR_CODE_INELIGIBLE_REPLICA, "fenced broker %d cannot join the ISR of partition %d of topic %s", replicaId, partitionId, topicId)
+		}
+	}
+	if !slices.Contains(isr, leaderId) {
+		return nil, newKafkaError(ERROR_CODE_INVALID_REQUEST, "the ISR of partition %d of topic %s must hold its leader", partitionId, topicId)
+	}
+
+	fields := ktypes.TaggedFieldValues{
+		PARTITION_CHANGE_ISR_TAG: newBrokerIdListField(isr),
+	}
+	setEligibleLeaderReplicas(fields, topicId, partition, replicas, isr, leaderId)
+	err := appendMetadataRecord(&PartitionChangeRecordValue{
+		Header: RecordValueHeader{
+			FrameVersion: ktypes.Int8(METADATA_RECORD_FRAME_VERSION),
+			RecordType:   ktypes.Int8(PARTITION_CHANGE_RECORD_TYPE),
+		},
+		PartitionId:  ktypes.Int32(partitionId),
+		TopicId:      topicId,
+		TaggedFields: fields,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := maybeCompleteReassignment(topicId, partitionId); err != nil {
+		fmt.Println("Error completing the reassignment of partition ", partitionId, " of topic ", topicId, ": ", err.Error())
+	}
+	partition, _ = partitionRecordFor(topicId, partitionId)
+	return partition, nil
+}
+
+// alterPartitionIsr records a new ISR for a partition led by this broker,
+// through the active controller. The change is known to this broker once
+// the metadata log brings it.
+func alterPartitionIsr(topicName string, partitionId int32, state *PartitionState, isr []int32) error {
+	if raftClient.isLeader() {
+		metadataWriteMu.Lock()
+		defer metadataWriteMu.Unlock()
+		metadataMu.Lock()
+		defer metadataMu.Unlock()
+		_, err := changePartitionIsr(state.TopicId, partitionId, int32(brokerConfig.NodeId), state.LeaderEpoch, state.PartitionEpoch, isr)
+		return err
+	}
+
+	newIsr := make([]AlterPartitionRequestBrokerState, len(isr))
+	for i, replicaId := range isr {
+		newIsr[i] = AlterPartitionRequestBrokerState{BrokerId: ktypes.Int32(replicaId), BrokerEpoch: ktypes.Int64(-1)}
+	}
+	requestBody := AlterPartitionRequestBody{
+		BrokerId:    ktypes.Int32(brokerConfig.NodeId),
+		BrokerEpoch: ktypes.Int64(-1),
+		Topics: []AlterPartitionRequestTopic{{
+			TopicId: state.TopicId,
+			Partitions: []AlterPartitionRequestPartition{{
+				PartitionIndex:   ktypes.Int32(partitionId),
+				LeaderEpoch:      ktypes.Int32(state.LeaderEpoch),
+				NewIsrWithEpochs: newIsr,
+				PartitionEpoch:   ktypes.Int32(state.PartitionEpoch),
+			}},
+		}},
+	}
+	var responseBody AlterPartitionResponseBody
+	if err := sendToController(ALTER_PARTITION_REQUEST_KEY, ALTER_PARTITION_VERSION, &requestBody, &responseBody); err != nil {
+		return err
+	}
+	if responseBody.ErrorCode != ERROR_CODE_NONE {
+		return newKafkaError(responseBody.ErrorCode, "controller returned error %d", responseBody.ErrorCode)
+	}
+	if len(responseBody.Topics) != 1 || len(responseBody.Topics[0].Partitions) != 1 {
+		return fmt.Errorf("unexpected alter partition response")
+	}
+	partition := responseBody.Topics[0].Partitions[0]
+	if partition.ErrorCode != ERROR_CODE_NONE {
+		return newKafkaError(partition.ErrorCode, "controller returned error %d for %s-%d", partition.ErrorCode, topicName, partitionId)
+	}
+	return waitForPartitionEpoch(topicName, partitionId, int32(partition.PartitionEpoch))
+}
+
+// waitForPartitionEpoch waits for the metadata of a partition to reach
+// partitionEpoch on this broker.
+func waitForPartitionEpoch(topicName string, partitionId int32, partitionEpoch int32) error {
+	timeoutMs := brokerConfig.ControllerQuorumRequestTimeoutMs
+	timer := time.NewTimer(time.Duration(timeoutMs) * time.Millisecond)
+	defer timer.Stop()
+	for {
+		changed := logChangedChannel()
+		if state, ok := partitionState(topicName, partitionId); ok && state.PartitionEpoch >= partitionEpoch {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return newKafkaError(ERROR_CODE_REQUEST_TIMED_OUT, "partition epoch %d of %s-%d was not applied within %dms", partitionEpoch, topicName, partitionId, timeoutMs)
+		}
+	}
+}
+
+// PartitionState is a snapshot of a partition's replication metadata.
+type PartitionState struct {
+	TopicId        ktypes.UUID
+	Leader         int32
+	LeaderEpoch    int32
+	PartitionEpoch int32
+	Replicas       []int32
+	Isr            []int32
+	// Log directory of this broker's replica
+	Directory ktypes.UUID
+}
+
+func (p *PartitionState) isLeader() bool {
+	return p.Leader == int32(brokerConfig.NodeId)
+}
+
+func (p *PartitionState) isFollower() bool {
+	return !p.isLeader() && slices.Contains(p.Replicas, int32(brokerConfig.NodeId))
+}
+
+func toInt32Slice(values ktypes.CompactArray[ktypes.Int32]) []int32 {
+	ids := make([]int32, len(values))
+	for i, value := range values {
+		ids[i] = int32(value)
+	}
+	return ids
+}
+
+// partitionState returns the replication metadata of a partition of a user
+// topic.
+func partitionState(topicName string, partitionId int32) (*PartitionState, bool) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	topicId, ok := topicNameToTopicId[topicName]
+	if !ok {
+		return nil, false
+	}
+	partition, ok := partitionRecordFor(topicId, partitionId)
+	if !ok {
+		return nil, false
+	}
+	return &PartitionState{
+		TopicId:        topicId,
+		Leader:         int32(partition.Leader),
+		LeaderEpoch:    int32(partition.LeaderEpoch),
+		PartitionEpoch: int32(partition.PartitionEpoch),
+		Replicas:       toInt32Slice(partition.Replicas),
+		Isr:            toInt32Slice(partition.InSyncReplicas),
+		Directory:      replicaDirectory(partition, int32(brokerConfig.NodeId)),
+	}, true
+}
+
+// checkLeader returns NOT_LEADER_OR_FOLLOWER when clients must send their
+// requests for the partition to another broker.
+func checkLeader(topicName string, partitionId int32) *KafkaError {
+	state, ok := partitionState(topicName, partitionId)
+	if ok && !state.isLeader() {
+		return newKafkaError(ERROR_CODE_NOT_LEADER_OR_FOLLOWER, "broker %d is not the leader of %s-%d", brokerConfig.NodeId, topicName, partitionId)
+	}
+	return nil
+}
+
+// checkLeaderOrFollower returns NOT_LEADER_OR_FOLLOWER when this broker
+// holds no replica of the partition, for the requests followers serve too.
+func checkLeaderOrFollower(topicName string, partitionId int32) *KafkaError {
+	state, ok := partitionState(topicName, partitionId)
+	if ok && !state.isLeader() && !state.isFollower() {
+		return newKafkaError(ERROR_CODE_NOT_LEADER_OR_FOLLOWER, "broker %d is not a replica of %s-%d", brokerConfig.NodeId, topicName, partitionId)
+	}
+	return nil
+}
+
+// checkLeaderEpoch compares the leader epoch a client knows of with the
+// current one. Older epochs are fenced, newer ones mean this broker has yet
+// to learn of the leader change. -1 skips the check.
+func checkLeaderEpoch(topicName string, partitionId int32, currentLeaderEpoch int32) *KafkaError {
+	state, ok := partitionState(topicName, partitionId)
+	if !ok || currentLeaderEpoch == UNDEFINED_EPOCH {
+		return nil
+	}
+	if currentLeaderEpoch < state.LeaderEpoch {
+		return newKafkaError(ERROR_CODE_FENCED_LEADER_EPOCH, "leader epoch %d of %s-%d is older than %d", currentLeaderEpoch, topicName, partitionId, state.LeaderEpoch)
+	}
+	if currentLeaderEpoch > state.LeaderEpoch {
+		return newKafkaError(ERROR_CODE_UNKNOWN_LEADER_EPOCH, "leader epoch %d of %s-%d is newer than %d", currentLeaderEpoch, topicName, partitionId, state.LeaderEpoch)
+	}
+	return nil
+}
+
+// FollowerState is what the leader knows of a follower from its fetches.
+type FollowerState struct {
+	logEndOffset int64
+	// Last time the follower had fetched up to the leader's log end
+	lastCaughtUpTimeMs          int64
+	lastFetchTimeMs             int64
+	lastFetchLeaderLogEndOffset int64
+}
+
+// Followers of the partitions led by this broker, by partition folder then
+// replica id, guarded by followerStatesMu
+var (
+	followerStatesMu sync.Mutex
+	followerStates   = make(map[string]map[int32]*FollowerState)
+)
+
+// High watermarks checkpointed by the last run, by partition folder, until
+// the first role is taken in the partition
+var highWatermarks = make(map[string]int64)
+
+// becomeLeader starts leading a partition. Followers get until
+// replica.lag.time.max.ms to catch up before leaving the ISR.
+func becomeLeader(log *PartitionLog, state *PartitionState) {
+	nowMs := time.Now().UnixMilli()
+	followerStatesMu.Lock()
+	followers := make(map[int32]*FollowerState)
+	for _, replicaId := range state.Replicas {
+		if replicaId != int32(brokerConfig.NodeId) {
+			followers[replicaId] = &FollowerState{logEndOffset: -1, lastCaughtUpTimeMs: nowMs}
+		}
+	}
+	followerStates[log.dir] = followers
+	followerStatesMu.Unlock()
+
+	highWatermark, ok := highWatermarks[log.dir]
+	if !ok {
+		highWatermark = log.LogEndOffset()
+	}
+	log.setLeaderEpoch(state.LeaderEpoch)
+	log.setReplicated(len(state.Isr) > 1, highWatermark)
+}
+
+// becomeFollower starts following a partition's leader. Records the leader
+// does not have are found from the leader epochs when fetching, a log
+// without epochs is truncated to its high watermark instead.
+func becomeFollower(log *PartitionLog, state *PartitionState) error {
+	followerStatesMu.Lock()
+	delete(followerStates, log.dir)
+	followerStatesMu.Unlock()
+
+	highWatermark, ok := highWatermarks[log.dir]
+	if !ok {
+		highWatermark = log.HighWatermark()
+	}
+	log.setReplicated(true, highWatermark)
+	if log.LatestEpoch() != UNDEFINED_EPOCH {
+		return nil
+	}
+	if _, err := log.TruncateTo(log.HighWatermark()); err != nil {
+		return fmt.Errorf("unable to truncate %s: %w", log.dir, err)
+	}
+	return nil
+}
+
+// A partition of a user topic
+type TopicPartition struct {
+	topicName string
+	partition int32
+}
+
+// Partitions whose metadata changed since this broker last took its role
+// in them, guarded by metadataMu
+var changedPartitions = make([]TopicPartition, 0)
+
+// partitionChanged records the metadata of a partition changed, callers
+// hold metadataMu.
+func partitionChanged(topicId ktypes.UUID, partitionId int32) {
+	changedPartitions = append(changedPartitions, TopicPartition{topicIdToTopicName[topicId], partitionId})
+}
+
+// takeChangedPartitions returns the partitions changed since the last call,
+// callers hold metadataMu.
+func takeChangedPartitions() []TopicPartition {
+	changed := changedPartitions
+	changedPartitions = make([]TopicPartition, 0)
+	return changed
+}
+
+// Leader epoch of the role this broker last took in each partition, by
+// partition folder, guarded by partitionRolesMu which also serializes the
+// role changes
+var (
+	partitionRolesMu sync.Mutex
+	partitionRoles   = make(map[string]int32)
+)
+
+// updateFollowerStates starts tracking the followers a reassignment adds to
+// a partition led by this broker, and forgets the ones it removes.
+func updateFollowerStates(log *PartitionLog, state *PartitionState) {
+	nowMs := time.Now().UnixMilli()
+	followerStatesMu.Lock()
+	defer followerStatesMu.Unlock()
+	followers := followerStates[log.dir]
+	for _, replicaId := range state.Replicas {
+		if _, ok := followers[replicaId]; !ok && replicaId != int32(brokerConfig.NodeId) {
+			followers[replicaId] = &FollowerState{logEndOffset: -1, lastCaughtUpTimeMs: nowMs}
+		}
+	}
+	for replicaId := range followers {
+		if !slices.Contains(state.Replicas, replicaId) {
+			delete(followers, replicaId)
+		}
+	}
+}
+
+// updatePartitionRole makes this broker lead, follow or stop replicating a
+// partition as its metadata says. The role is taken again when the leader
+// epoch moved, a leader otherwise only follows the ISR and replicas. The
+// first role taken in a partition starts from the checkpointed high
+// watermark. A replica removed from the partition deletes its log. Replicas
+// in an offline log directory take no role, and the directory of the others
+// is reported to the controller when the metadata does not have it.
+func updatePartitionRole(topicName string, partitionId int32) error {
+	partitionRolesMu.Lock()
+	defer partitionRolesMu.Unlock()
+
+	state, ok := partitionState(topicName, partitionId)
+	if !ok || (!state.isLeader() && !state.isFollower()) {
+		dir, placed := placedPartitionLogDir(topicName, partitionId)
+		if _, ok := partitionRoles[dir]; ok && placed {
+			removeFetcherPartition(topicName, partitionId)
+			delete(partitionRoles, dir)
+			followerStatesMu.Lock()
+			delete(followerStates, dir)
+			followerStatesMu.Unlock()
+			if err := deletePartitionLog(topicName, partitionId); err != nil {
+				return err
+			}
+			fmt.Println("Deleted the replica of ", topicName, "-", partitionId)
+		}
+		return nil
+	}
+	log, err := getPartitionLog(topicName, partitionId)
+	if errorCodeFromError(err) == ERROR_CODE_KAFKA_STORAGE_ERROR {
+		removeFetcherPartition(topicName, partitionId)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if directoryId := logDirectoryFor(log.dir).id; directoryId != state.Directory {
+		queueDirectoryAssignment(state.TopicId, partitionId, directoryId)
+	}
+	dir := log.dir
+	if leaderEpoch, ok := partitionRoles[dir]; ok && leaderEpoch == state.LeaderEpoch {
+		if state.isLeader() {
+			updateFollowerStates(log, state)
+			log.setReplicated(len(state.Isr) > 1, log.HighWatermark())
+			maybeIncrementHighWatermark(log, state)
+		}
+		return nil
+	}
+
+	removeFetcherPartition(topicName, partitionId)
+	if state.isLeader() {
+		becomeLeader(log, state)
+	} else {
+		if err := becomeFollower(log, state); err != nil {
+			return err
+		}
+		if state.Leader != NO_LEADER {
+			addFetcherPartition(state.Leader, topicName, partitionId, state.TopicId, state.LeaderEpoch)
+		}
+	}
+	partitionRoles[dir] = state.LeaderEpoch
+	delete(highWatermarks, dir)
+	return nil
+}
+
+// updatePartitionRoles takes the role of this broker in partitions whose
+// metadata changed.
+func updatePartitionRoles(partitions []TopicPartition) {
+	for _, tp := range partitions {
+		if err := updatePartitionRole(tp.topicName, tp.partition); err != nil {
+			fmt.Println("Error updating the role of ", tp.topicName, "-", tp.partition, ": ", err.Error())
+		}
+	}
+}
+
+// startReplication takes the role of this broker in every partition it has
+// a replica of, fetching the partitions it follows from their leaders.
+func startReplication() error {
+	metadataMu.Lock()
+	partitions := make([]TopicPartition, 0)
+	for topicId, records := range topicIdToPartitions {
+		for _, record := range records {
+			partitions = append(partitions, TopicPartition{topicIdToTopicName[topicId], int32(record.PartitionId)})
+		}
+	}
+	metadataMu.Unlock()
+
+	for _, tp := range partitions {
+		if err := updatePartitionRole(tp.topicName, tp.partition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateFollowerFetchState records a follower fetching from fetchOffset,
+// which tells the leader the follower has every record before it. The
+// follower joins the ISR once it reaches the high watermark.
+func updateFollowerFetchState(topicName string, partitionId int32, replicaId int32, fetchOffset int64) error {
+	log, err := getPartitionLog(topicName, partitionId)
+	if err != nil {
+		return err
+	}
+	nowMs := time.Now().UnixMilli()
+	leaderLogEndOffset := log.LogEndOffset()
+
+	followerStatesMu.Lock()
+	follower, ok := followerStates[log.dir][replicaId]
+	if !ok {
+		followerStatesMu.Unlock()
+		return newKafkaError(ERROR_CODE_NOT_LEADER_OR_FOLLOWER, "broker %d is not a follower of %s-%d", replicaId, topicName, partitionId)
+	}
+	if fetchOffset >= leaderLogEndOffset {
+		follower.lastCaughtUpTimeMs = nowMs
+	} else if fetchOffset >= follower.lastFetchLeaderLogEndOffset {
+		follower.lastCaughtUpTimeMs = max(follower.lastCaughtUpTimeMs, follower.lastFetchTimeMs)
+	}
+	follower.logEndOffset = fetchOffset
+	follower.lastFetchTimeMs = nowMs
+	follower.lastFetchLeaderLogEndOffset = leaderLogEndOffset
+	followerStatesMu.Unlock()
+
+	state, ok := partitionState(topicName, partitionId)
+	if !ok || !state.isLeader() {
+		return nil
+	}
+	if !slices.Contains(state.Isr, replicaId) && fetchOffset >= log.HighWatermark() {
+		isr := append(slices.Clone(state.Isr), replicaId)
+		err := alterPartitionIsr(topicName, partitionId, state, isr)
+		switch {
+		case err == nil:
+			fmt.Println("Expanded ISR of ", topicName, "-", partitionId, " to ", isr)
+			state.Isr = isr
+			log.setReplicated(true, log.HighWatermark())
+		case errorCodeFromError(err) == ERROR_CODE_INELIGIBLE_REPLICA:
+			// A fenced follower keeps fetching, it joins the ISR once unfenced
+		default:
+			return err
+		}
+	}
+	maybeIncrementHighWatermark(log, state)
+	return nil
+}
+
+// maybeIncrementHighWatermark moves the high watermark up to the lowest log
+// end offset of the in-sync replicas.
+func maybeIncrementHighWatermark(log *PartitionLog, state *PartitionState) {
+	highWatermark := log.LogEndOffset()
+	followerStatesMu.Lock()
+	for _, replicaId := range state.Isr {
+		if replicaId == int32(brokerConfig.NodeId) {
+			continue
+		}
+		follower, ok := followerStates[log.dir][replicaId]
+		if !ok || follower.logEndOffset < 0 {
+			// Nothing is known of the follower yet
+			followerStatesMu.Unlock()
+			return
+		}
+		highWatermark = min(highWatermark, follower.logEndOffset)
+	}
+	followerStatesMu.Unlock()
+	log.updateHighWatermark(highWatermark)
+}
+
+// shrinkIsrs removes from the ISR of the partitions led by this broker the
+// followers that did not catch up within replica.lag.time.max.ms.
+func shrinkIsrs(nowMs int64) error {
+	for _, log := range openPartitionLogs() {
+		state, ok := partitionState(log.topicName, log.partition)
+		if !ok || !state.isLeader() || len(state.Isr) < 2 {
+			continue
+		}
+
+		followerStatesMu.Lock()
+		isr := make([]int32, 0, len(state.Isr))
+		for _, replicaId := range state.Isr {
+			follower, ok := followerStates[log.dir][replicaId]
+			if ok && nowMs-follower.lastCaughtUpTimeMs > int64(brokerConfig.ReplicaLagTimeMaxMs) {
+				continue
+			}
+			isr = append(isr, replicaId)
+		}
+		followerStatesMu.Unlock()
+		if len(isr) == len(state.Isr) {
+			continue
+		}
+
+		if err := alterPartitionIsr(log.topicName, log.partition, state, isr); err != nil {
+			return err
+		}
+		fmt.Println("Shrunk ISR of ", log.topicName, "-", log.partition, " to ", isr)
+		state.Isr = isr
+		log.setReplicated(len(isr) > 1, log.HighWatermark())
+		maybeIncrementHighWatermark(log, state)
+	}
+	return nil
+}
+
+func startIsrShrinkTask() {
+	runPeriodicTask(time.Duration(brokerConfig.ReplicaLagTimeMaxMs/2)*time.Millisecond, func() {
+		if err := shrinkIsrs(time.Now().UnixMilli()); err != nil {
+			fmt.Println("Error shrinking ISRs: ", err.Error())
+		}
+	})
+}
+
+// checkReplicated reports whether the in-sync replicas have the records
+// before offset, and the error they are acknowledged with then. Records are
+// only acknowledged while the ISR has at least min.insync.replicas replicas.
+func checkReplicated(topicName string, partitionId int32, log *PartitionLog, offset int64) (bool, error) {
+	if log.HighWatermark() < offset {
+		return false, nil
+	}
+	if state, ok := partitionState(topicName, partitionId); ok && len(state.Isr) < topicMinInsyncReplicas(topicName) {
+		return true, newKafkaError(ERROR_CODE_NOT_ENOUGH_REPLICAS_AFTER_APPEND, "the ISR of %s-%d shrank below min.insync.replicas", topicName, partitionId)
+	}
+	return true, nil
+}
+
+// checkMinInsyncReplicas returns NOT_ENOUGH_REPLICAS when acks=all records
+// cannot be accepted.
+func checkMinInsyncReplicas(topicName string, partitionId int32) *KafkaError {
+	if state, ok := partitionState(topicName, partitionId); ok && len(state.Isr) < topicMinInsyncReplicas(topicName) {
+		return newKafkaError(ERROR_CODE_NOT_ENOUGH_REPLICAS, "the ISR of %s-%d has %d replicas, below min.insync.replicas", topicName, partitionId, len(state.Isr))
+	}
+	return nil
+}
+
+// topicMinInsyncReplicas returns the ISR size acks=all produce requests need.
+func topicMinInsyncReplicas(topicName string) int {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	return minInsyncReplicas(topicName)
+}
+
+// minInsyncReplicas is topicMinInsyncReplicas for callers holding
+// metadataMu.
+func minInsyncReplicas(topicName string) int {
+	value := lookupTopicConfig(topicName, "min.insync.replicas", "")
+	if n, err := strconv.Atoi(value); err == nil && n >= 1 {
+		return n
+	}
+	return brokerConfig.MinInsyncReplicas
+}
+
+// loadHighWatermarks reads the high watermark checkpoint.
+func loadHighWatermarks() {
+	offsets, err := readOffsetCheckpoints(REPLICATION_OFFSET_CHECKPOINT_FILE)
+	if err != nil {
+		fmt.Println("Ignoring high watermark checkpoint: ", err.Error())
+		return
+	}
+	highWatermarks = offsets
+}
+
+// checkpointHighWatermarks writes the high watermark of every open log.
+func checkpointHighWatermarks() error {
+	logs := openPartitionLogs()
+	offsets := make([]int64, len(logs))
+	for i, log := range logs {
+		offsets[i] = log.HighWatermark()
+	}
+	return writeOffsetCheckpoints(REPLICATION_OFFSET_CHECKPOINT_FILE, logs, offsets)
+}
+
+func startHighWatermarkCheckpointTask() {
+	runPeriodicTask(time.Duration(brokerConfig.ReplicaHighWatermarkCheckpointIntervalMs)*time.Millisecond, func() {
+		if err := checkpointHighWatermarks(); err != nil {
+			fmt.Println("Error writing high watermark checkpoint: ", err.Error())
+		}
+	})
+}
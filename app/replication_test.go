@@ -0,0 +1,96 @@
+package main
+
+import "testing"
+
+// setTestFollowerLogEnd records the log end offset a follower fetched from
+func setTestFollowerLogEnd(log *PartitionLog, replicaId int32, logEndOffset int64) {
+	followerStatesMu.Lock()
+	defer followerStatesMu.Unlock()
+	followerStates[log.dir][replicaId].logEndOffset = logEndOffset
+}
+
+func TestHighWatermarkFollowsIsr(t *testing.T) {
+	log := openTestPartitionLog(t, 1<<20)
+	brokerConfig.NodeId = 1
+	appendTestBatches(t, log, 5)
+
+	// The last run had only committed the first 2 records
+	highWatermarks[log.dir] = 2
+	t.Cleanup(func() {
+		delete(highWatermarks, log.dir)
+		followerStatesMu.Lock()
+		defer followerStatesMu.Unlock()
+		delete(followerStates, log.dir)
+	})
+	state := &PartitionState{Leader: 1, LeaderEpoch: 1, Replicas: []int32{1, 2, 3}, Isr: []int32{1, 2, 3}}
+	becomeLeader(log, state)
+	if hw := log.HighWatermark(); hw != 2 {
+		t.Fatalf("got high watermark %d after becoming leader, want the checkpointed 2", hw)
+	}
+
+	steps := []struct {
+		name   string
+		change func()
+		wantHw int64
+	}{
+		{"followers unknown", func() {}, 2},
+		{"one follower fetched", func() { setTestFollowerLogEnd(log, 2, 4) }, 2},
+		{"both followers fetched", func() { setTestFollowerLogEnd(log, 3, 3) }, 3},
+		{"slowest follower caught up", func() { setTestFollowerLogEnd(log, 3, 5) }, 4},
+		{"high watermark never goes back", func() { setTestFollowerLogEnd(log, 2, 1) }, 4},
+		{"lagging follower left the ISR", func() { state.Isr = []int32{1, 3} }, 5},
+	}
+	for _, step := range steps {
+		step.change()
+		maybeIncrementHighWatermark(log, state)
+		if hw := log.HighWatermark(); hw != step.wantHw {
+			t.Errorf("%s: got high watermark %d, want %d", step.name, hw, step.wantHw)
+		}
+	}
+
+	// Records appended while replicated wait for the followers
+	appendTestBatches(t, log, 1)
+	if hw := log.HighWatermark(); hw != 5 {
+		t.Errorf("got high watermark %d after an append, want 5", hw)
+	}
+	// A leader alone in the ISR commits as it appends
+	state.Isr = []int32{1}
+	log.setReplicated(false, log.HighWatermark())
+	appendTestBatches(t, log, 1)
+	if hw := log.HighWatermark(); hw != 7 {
+		t.Errorf("got high watermark %d without followers in sync, want 7", hw)
+	}
+}
+
+func TestCompleteReplication(t *testing.T) {
+	log := openTestPartitionLog(t, 1<<20)
+	brokerConfig.NodeId = 1
+	t.Cleanup(func() {
+		delete(highWatermarks, log.dir)
+		followerStatesMu.Lock()
+		defer followerStatesMu.Unlock()
+		delete(followerStates, log.dir)
+	})
+	state := &PartitionState{Leader: 1, LeaderEpoch: 1, Replicas: []int32{1, 2}, Isr: []int32{1, 2}}
+	becomeLeader(log, state)
+	appendTestBatches(t, log, 3)
+
+	response := ProduceResponsePartition{BaseOffset: -1}
+	wait := &ReplicationWait{TopicName: log.topicName, Log: log, BaseOffset: 2, EndOffset: log.LogEndOffset(), Response: &response}
+	if wait.completeReplication() {
+		t.Fatal("write completed before the follower had its records")
+	}
+	setTestFollowerLogEnd(log, 2, 2)
+	maybeIncrementHighWatermark(log, state)
+	if wait.completeReplication() {
+		t.Fatal("write completed with the follower behind its last record")
+	}
+	setTestFollowerLogEnd(log, 2, 3)
+	maybeIncrementHighWatermark(log, state)
+	if !wait.completeReplication() {
+		t.Fatal("write not completed once the follower had its records")
+	}
+	if response.ErrorCode != ERROR_CODE_NONE || response.BaseOffset != 2 {
+		t.Errorf("got error %d at base offset %d, want 2", response.ErrorCode, response.BaseOffset)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	SASL_MECHANISM_PLAIN = "PLAIN"
+
+	ANONYMOUS_PRINCIPAL = "User:ANONYMOUS"
+	USER_PRINCIPAL_TYPE = "User:"
+)
+
+// ClientSession is the state of a client connection, kept across its requests.
+type ClientSession struct {
+	Listener      Listener
+	Principal     string
+	Authenticated bool
+
+	// Mechanism chosen by SaslHandshake, empty before the handshake
+	saslMechanism string
+	scram         *ScramServerExchange
+
+	// Set when the connection must be closed once the response is sent
+	closeConnection bool
+}
+
+func newClientSession(listener Listener) *ClientSession {
+	session := &ClientSession{Listener: listener, Principal: ANONYMOUS_PRINCIPAL}
+	// Without SASL every client is let in anonymously
+	session.Authenticated = !listener.usesSasl()
+	return session
+}
+
+func isSupportedSaslMechanism(mechanism string) bool {
+	if mechanism == SASL_MECHANISM_PLAIN {
+		return true
+	}
+	_, ok := scramMechanismByName(mechanism)
+	return ok
+}
+
+// isAllowedBeforeAuthentication reports whether a request may be sent on a
+// connection that did not authenticate yet.
+func isAllowedBeforeAuthentication(apiKey int16) bool {
+	switch apiKey {
+	case API_VERSIONS_REQUEST_KEY, SASL_HANDSHAKE_REQUEST_KEY, SASL_AUTHENTICATE_REQUEST_KEY:
+		return true
+	}
+	return false
+}
+
+// saslHandshake selects the mechanism the client will authenticate with.
+func (s *ClientSession) saslHandshake(mechanism string) ERROR_CODE {
+	if !s.Listener.usesSasl() || s.saslMechanism != "" || s.Authenticated {
+		return ERROR_CODE_ILLEGAL_SASL_STATE
+	}
+	if !slices.Contains(brokerConfig.SaslEnabledMechanisms, mechanism) {
+		return ERROR_CODE_UNSUPPORTED_SASL_MECHANISM
+	}
+	s.saslMechanism = mechanism
+	if scramMechanism, ok := scramMechanismByName(mechanism); ok {
+		s.scram = &ScramServerExchange{mechanism: scramMechanism}
+	}
+	return ERROR_CODE_NONE
+}
+
+// saslAuthenticate processes one SASL token from the client and returns the
+// token to send back. Failed authentications close the connection.
+func (s *ClientSession) saslAuthenticate(authBytes []byte) ([]byte, error) {
+	if s.saslMechanism == "" || s.Authenticated {
+		return nil, newKafkaError(ERROR_CODE_ILLEGAL_SASL_STATE, "unexpected SaslAuthenticate request")
+	}
+
+	var challenge []byte
+	var username string
+	var err error
+	done := true
+	if s.saslMechanism == SASL_MECHANISM_PLAIN {
+		username, err = authenticatePlain(authBytes)
+	} else {
+		challenge, done, err = s.scram.evaluate(authBytes)
+		username = s.scram.username
+	}
+	if err != nil {
+		s.closeConnection = true
+		return nil, newKafkaError(ERROR_CODE_SASL_AUTHENTICATION_FAILED, "authentication failed during authentication due to invalid credentials with SASL mechanism %s", s.saslMechanism)
+	}
+
+	if done {
+		s.Authenticated = true
+		s.Principal = USER_PRINCIPAL_TYPE + username
+		s.scram = nil
+	}
+	return challenge, nil
+}
+
+// authenticatePlain checks a PLAIN token, "authzid NUL username NUL password".
+func authenticatePlain(token []byte) (string, error) {
+	parts := bytes.Split(token, []byte{0})
+	if len(parts) != 3 {
+		return "", fmt.Errorf("invalid PLAIN token")
+	}
+	authorizationId, username, password := string(parts[0]), string(parts[1]), string(parts[2])
+	if username == "" || password == "" {
+		return "", fmt.Errorf("username and password are required")
+	}
+	if authorizationId != "" && authorizationId != username {
+		return "", fmt.Errorf("authorization id must match the username")
+	}
+	if !verifyPassword(username, password) {
+		return "", fmt.Errorf("invalid credentials for user %s", username)
+	}
+	return username, nil
+}
+
+// ScramServerExchange is the server side of a SCRAM authentication (RFC 5802)
+type ScramServerExchange struct {
+	mechanism ScramMechanism
+	username  string
+
+	// Set once the client-first message was processed
+	gs2Header              string
+	clientFirstMessageBare string
+	serverFirstMessage     string
+	nonce                  string
+	credential             *ScramCredential
+}
+
+// parseScramAttributes splits a SCRAM message into its attributes.
+func parseScramAttributes(message string) (map[string]string, error) {
+	attributes := make(map[string]string)
+	for _, attribute := range strings.Split(message, ",") {
+		// Extensions such as tokenauth have longer names
+		name, value, ok := strings.Cut(attribute, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid SCRAM attribute %q", attribute)
+		}
+		attributes[name] = value
+	}
+	return attributes, nil
+}
+
+// decodeScramUsername undoes the escaping of ',' and '=' in SCRAM user names.
+func decodeScramUsername(name string) (string, error) {
+	var decoded strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '=' {
+			decoded.WriteByte(name[i])
+			continue
+		}
+		switch {
+		case strings.HasPrefix(name[i:], "=2C"):
+			decoded.WriteByte(',')
+		case strings.HasPrefix(name[i:], "=3D"):
+			decoded.WriteByte('=')
+		default:
+			return "", fmt.Errorf("invalid escaping in SCRAM username")
+		}
+		i += 2
+	}
+	return decoded.String(), nil
+}
+
+// evaluate processes the next client message and returns the server's reply,
+// and whether the exchange is complete.
+func (e *ScramServerExchange) evaluate(message []byte) ([]byte, bool, error) {
+	if e.credential == nil {
+		serverFirstMessage, err := e.handleClientFirstMessage(string(message))
+		if err != nil {
+			return nil, false, err
+		}
+		return []byte(serverFirstMessage), false, nil
+	}
+
+	serverFinalMessage, err := e.handleClientFinalMessage(string(message))
+	if err != nil {
+		return nil, false, err
+	}
+	return []byte(serverFinalMessage), true, nil
+}
+
+func (e *ScramServerExchange) handleClientFirstMessage(message string) (string, error) {
+	// gs2-header: channel binding flag and optional authzid, then the bare message
+	flag, rest, ok := strings.Cut(message, ",")
+	if !ok || (flag != "n" && flag != "y") {
+		return "", fmt.Errorf("channel binding is not supported")
+	}
+	authorizationId, bare, ok := strings.Cut(rest, ",")
+	if !ok {
+		return "", fmt.Errorf("invalid SCRAM client-first message")
+	}
+
+	attributes, err := parseScramAttributes(bare)
+	if err != nil {
+		return "", err
+	}
+	username, err := decodeScramUsername(attributes["n"])
+	if err != nil {
+		return "", err
+	}
+	clientNonce := attributes["r"]
+	if username == "" || clientNonce == "" {
+		return "", fmt.Errorf("SCRAM client-first message must contain the username and a nonce")
+	}
+	if authorizationId != "" && authorizationId != "a="+attributes["n"] {
+		return "", fmt.Errorf("authorization id must match the username")
+	}
+
+	credential, ok := getScramCredential(username, e.mechanism)
+	if !ok {
+		return "", fmt.Errorf("unknown user %s", username)
+	}
+
+	serverNonce := make([]byte, 24)
+	if _, err := rand.Read(serverNonce); err != nil {
+		return "", err
+	}
+	e.username = username
+	e.credential = &credential
+	e.gs2Header = flag + "," + authorizationId + ","
+	e.clientFirstMessageBare = bare
+	e.nonce = clientNonce + base64.RawStdEncoding.EncodeToString(serverNonce)
+	e.serverFirstMessage = "r=" + e.nonce + ",s=" + base64.StdEncoding.EncodeToString(credential.Salt) + ",i=" + strconv.Itoa(int(credential.Iterations))
+	return e.serverFirstMessage, nil
+}
+
+func (e *ScramServerExchange) handleClientFinalMessage(message string) (string, error) {
+	withoutProof, proofAttribute, ok := strings.Cut(message, ",p=")
+	if !ok {
+		return "", fmt.Errorf("SCRAM client-final message has no proof")
+	}
+	attributes, err := parseScramAttributes(withoutProof)
+	if err != nil {
+		return "", err
+	}
+	if attributes["r"] != e.nonce {
+		return "", fmt.Errorf("invalid SCRAM nonce")
+	}
+	if attributes["c"] != base64.StdEncoding.EncodeToString([]byte(e.gs2Header)) {
+		return "", fmt.Errorf("invalid SCRAM channel binding")
+	}
+
+	proof, err := base64.StdEncoding.DecodeString(proofAttribute)
+	if err != nil {
+		return "", fmt.Errorf("invalid SCRAM proof: %w", err)
+	}
+	authMessage := []byte(e.clientFirstMessageBare + "," + e.serverFirstMessage + "," + withoutProof)
+	clientSignature := e.mechanism.hmac(e.credential.StoredKey, authMessage)
+	if len(proof) != len(clientSignature) {
+		return "", fmt.Errorf("invalid SCRAM proof length")
+	}
+	clientKey := make([]byte, len(proof))
+	for i := range proof {
+		clientKey[i] = proof[i] ^ clientSignature[i]
+	}
+	if !hmac.Equal(e.mechanism.hash(clientKey), e.credential.StoredKey) {
+		return "", fmt.Errorf("invalid SCRAM proof")
+	}
+
+	serverSignature := e.mechanism.hmac(e.credential.ServerKey, authMessage)
+	return "v=" + base64.StdEncoding.EncodeToString(serverSignature), nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/pbkdf2"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const scramTestIterations = SCRAM_MIN_ITERATIONS
+
+// scramTestSaltedPassword salts password the way a client would
+func scramTestSaltedPassword(t *testing.T, mechanism ScramMechanism, password string, salt []byte) []byte {
+	t.Helper()
+	saltedPassword, err := pbkdf2.Key(mechanism.Hash, password, salt, scramTestIterations, mechanism.Hash().Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return saltedPassword
+}
+
+// addTestScramCredential stores a credential for name until the test ends
+func addTestScramCredential(t *testing.T, mechanism ScramMechanism, name string, password string) {
+	t.Helper()
+	salt := []byte("salt-" + name)
+	credential := newScramCredential(mechanism, salt, scramTestSaltedPassword(t, mechanism, password, salt), scramTestIterations)
+
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	applyUserScramCredentialRecord(&UserScramCredentialRecordValue{
+		Name:       ktypes.CompactString(name),
+		Mechanism:  ktypes.Int8(mechanism.Type),
+		Salt:       credential.Salt,
+		StoredKey:  credential.StoredKey,
+		ServerKey:  credential.ServerKey,
+		Iterations: ktypes.Int32(credential.Iterations),
+	})
+	t.Cleanup(func() {
+		metadataMu.Lock()
+		defer metadataMu.Unlock()
+		applyRemoveUserScramCredentialRecord(&RemoveUserScramCredentialRecordValue{Name: ktypes.CompactString(name), Mechanism: ktypes.Int8(mechanism.Type)})
+	})
+}
+
+func TestAuthenticatePlain(t *testing.T) {
+	mechanism, _ := scramMechanismByName("SCRAM-SHA-512")
+	addTestScramCredential(t, mechanism, "plain-user", "secret")
+
+	tests := []struct {
+		token   string
+		wantErr bool
+	}{
+		{"\x00plain-user\x00secret", false},
+		{"plain-user\x00plain-user\x00secret", false},
+		{"\x00plain-user\x00wrong", true},
+		{"other\x00plain-user\x00secret", true},
+		{"\x00unknown\x00secret", true},
+		{"\x00plain-user\x00", true},
+		{"plain-user\x00secret", true},
+	}
+	for _, test := range tests {
+		username, err := authenticatePlain([]byte(test.token))
+		if (err != nil) != test.wantErr {
+			t.Errorf("token %q: got error %v, want error %v", test.token, err, test.wantErr)
+		}
+		if err == nil && username != "plain-user" {
+			t.Errorf("token %q: got user %q, want plain-user", test.token, username)
+		}
+	}
+}
+
+func TestParseScramAttributes(t *testing.T) {
+	attributes, err := parseScramAttributes("n=user,r=abc=,tokenauth=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attributes["n"] != "user" || attributes["r"] != "abc=" || attributes["tokenauth"] != "true" {
+		t.Errorf("got attributes %v", attributes)
+	}
+	for _, message := range []string{"n=user,r", "=x", ""} {
+		if _, err := parseScramAttributes(message); err == nil {
+			t.Errorf("message %q parsed, want an error", message)
+		}
+	}
+}
+
+func TestDecodeScramUsername(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"user", "user", false},
+		{"a=2Cb=3Dc", "a,b=c", false},
+		{"=3D=3D", "==", false},
+		{"a=b", "", true},
+		{"a=2", "", true},
+	}
+	for _, test := range tests {
+		got, err := decodeScramUsername(test.name)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("decoding %q: got %q, %v, want %q", test.name, got, err, test.want)
+		}
+	}
+}
+
+// scramTestExchange runs a client against a new server exchange, proving
+// password, and returns the server's final message
+func scramTestExchange(t *testing.T, mechanism ScramMechanism, name string, password string) (string, error) {
+	t.Helper()
+	exchange := &ScramServerExchange{mechanism: mechanism}
+	clientFirstMessageBare := "n=" + name + ",r=clientnonce"
+	serverFirst, done, err := exchange.evaluate([]byte("n,," + clientFirstMessageBare))
+	if err != nil {
+		return "", err
+	}
+	if done {
+		t.Fatal("exchange done after the client-first message")
+	}
+
+	attributes, err := parseScramAttributes(string(serverFirst))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(attributes["r"], "clientnonce") || len(attributes["r"]) == len("clientnonce") {
+		t.Fatalf("server nonce %q does not extend the client nonce", attributes["r"])
+	}
+	salt, err := base64.StdEncoding.DecodeString(attributes["s"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saltedPassword := scramTestSaltedPassword(t, mechanism, password, salt)
+	clientKey := mechanism.hmac(saltedPassword, []byte("Client Key"))
+	withoutProof := "c=" + base64.StdEncoding.EncodeToString([]byte("n,,")) + ",r=" + attributes["r"]
+	authMessage := []byte(clientFirstMessageBare + "," + string(serverFirst) + "," + withoutProof)
+	clientSignature := mechanism.hmac(mechanism.hash(clientKey), authMessage)
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ clientSignature[i]
+	}
+
+	serverFinal, done, err := exchange.evaluate([]byte(withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)))
+	if err != nil {
+		return "", err
+	}
+	if !done {
+		t.Fatal("exchange not done after the client-final message")
+	}
+	if exchange.username != name {
+		t.Errorf("got user %q, want %q", exchange.username, name)
+	}
+	serverSignature := mechanism.hmac(mechanism.hmac(saltedPassword, []byte("Server Key")), authMessage)
+	if want := "v=" + base64.StdEncoding.EncodeToString(serverSignature); string(serverFinal) != want {
+		t.Errorf("got server-final message %q, want %q", serverFinal, want)
+	}
+	return string(serverFinal), nil
+}
+
+func TestScramExchange(t *testing.T) {
+	for _, mechanism := range scramMechanisms {
+		addTestScramCredential(t, mechanism, "scram-user", "secret")
+		if _, err := scramTestExchange(t, mechanism, "scram-user", "secret"); err != nil {
+			t.Errorf("%s: %v", mechanism.Name, err)
+		}
+		if _, err := scramTestExchange(t, mechanism, "scram-user", "wrong"); err == nil {
+			t.Errorf("%s: wrong password accepted", mechanism.Name)
+		}
+		if _, err := scramTestExchange(t, mechanism, "unknown", "secret"); err == nil {
+			t.Errorf("%s: unknown user accepted", mechanism.Name)
+		}
+	}
+}
+
+func TestScramRejectsChannelBinding(t *testing.T) {
+	mechanism, _ := scramMechanismByName("SCRAM-SHA-256")
+	addTestScramCredential(t, mechanism, "scram-user", "secret")
+	exchange := &ScramServerExchange{mechanism: mechanism}
+	if _, _, err := exchange.evaluate([]byte("p=tls-unique,,n=scram-user,r=nonce")); err == nil {
+		t.Fatal("channel binding accepted")
+	}
+}
+
+func TestValidateScramCredentialUpsertion(t *testing.T) {
+	valid := ScramCredentialUpsertion{Name: "user", Mechanism: 1, Iterations: SCRAM_MIN_ITERATIONS, Salt: []byte("salt"), SaltedPassword: []byte("salted")}
+	if err := validateScramCredentialUpsertion(valid); err != nil {
+		t.Fatalf("valid upsertion rejected: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(*ScramCredentialUpsertion)
+		want   ERROR_CODE
+	}{
+		{"no name", func(u *ScramCredentialUpsertion) { u.Name = "" }, ERROR_CODE_UNACCEPTABLE_CREDENTIAL},
+		{"unknown mechanism", func(u *ScramCredentialUpsertion) { u.Mechanism = 3 }, ERROR_CODE_UNSUPPORTED_SASL_MECHANISM},
+		{"few iterations", func(u *ScramCredentialUpsertion) { u.Iterations = SCRAM_MIN_ITERATIONS - 1 }, ERROR_CODE_UNACCEPTABLE_CREDENTIAL},
+		{"many iterations", func(u *ScramCredentialUpsertion) { u.Iterations = SCRAM_MAX_ITERATIONS + 1 }, ERROR_CODE_UNACCEPTABLE_CREDENTIAL},
+		{"no salt", func(u *ScramCredentialUpsertion) { u.Salt = nil }, ERROR_CODE_UNACCEPTABLE_CREDENTIAL},
+	}
+	for _, test := range tests {
+		upsertion := valid
+		test.change(&upsertion)
+		if err := validateScramCredentialUpsertion(upsertion); err == nil || err.Code != test.want {
+			t.Errorf("%s: got %v, want error %d", test.name, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"hash"
+	"slices"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	SCRAM_MIN_ITERATIONS = 4096
+	SCRAM_MAX_ITERATIONS = 16384
+)
+
+type ScramMechanism struct {
+	Name string
+	Type int8
+	Hash func() hash.Hash
+}
+
+var scramMechanisms = []ScramMechanism{
+	{Name: "SCRAM-SHA-256", Type: 1, Hash: sha256.New},
+	{Name: "SCRAM-SHA-512", Type: 2, Hash: sha512.New},
+}
+
+func scramMechanismByName(name string) (ScramMechanism, bool) {
+	for _, mechanism := range scramMechanisms {
+		if mechanism.Name == name {
+			return mechanism, true
+		}
+	}
+	return ScramMechanism{}, false
+}
+
+func scramMechanismByType(mechanismType int8) (ScramMechanism, bool) {
+	for _, mechanism := range scramMechanisms {
+		if mechanism.Type == mechanismType {
+			return mechanism, true
+		}
+	}
+	return ScramMechanism{}, false
+}
+
+func (m ScramMechanism) hmac(key []byte, message []byte) []byte {
+	mac := hmac.New(m.Hash, key)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+func (m ScramMechanism) hash(message []byte) []byte {
+	h := m.Hash()
+	h.Write(message)
+	return h.Sum(nil)
+}
+
+// ScramCredential is what the broker keeps of a password, enough to verify
+// a client proof but not to impersonate the user.
+type ScramCredential struct {
+	Salt       []byte
+	StoredKey  []byte
+	ServerKey  []byte
+	Iterations int32
+}
+
+// newScramCredential derives the stored and server keys from a salted password.
+func newScramCredential(mechanism ScramMechanism, salt []byte, saltedPassword []byte, iterations int32) ScramCredential {
+	clientKey := mechanism.hmac(saltedPassword, []byte("Client Key"))
+	return ScramCredential{
+		Salt:       salt,
+		StoredKey:  mechanism.hash(clientKey),
+		ServerKey:  mechanism.hmac(saltedPassword, []byte("Server Key")),
+		Iterations: iterations,
+	}
+}
+
+type UserScramCredentialRecordValue struct {
+	Header       RecordValueHeader    `order:"1"`
+	Name         ktypes.CompactString `order:"2"`
+	Mechanism    ktypes.Int8          `order:"3"`
+	Salt         ktypes.CompactBytes  `order:"4"`
+	StoredKey    ktypes.CompactBytes  `order:"5"`
+	ServerKey    ktypes.CompactBytes  `order:"6"`
+	Iterations   ktypes.Int32         `order:"7"`
+	TaggedFields ktypes.TaggedFields  `order:"8"`
+}
+
+type RemoveUserScramCredentialRecordValue struct {
+	Header       RecordValueHeader    `order:"1"`
+	Name         ktypes.CompactString `order:"2"`
+	Mechanism    ktypes.Int8          `order:"3"`
+	TaggedFields ktypes.TaggedFields  `order:"4"`
+}
+
+// user name -> mechanism type -> credential, guarded by metadataMu
+var scramCredentials = make(map[string]map[int8]ScramCredential)
+
+func applyUserScramCredentialRecord(record *UserScramCredentialRecordValue) {
+	name := string(record.Name)
+	if _, ok := scramCredentials[name]; !ok {
+		scramCredentials[name] = make(map[int8]ScramCredential)
+	}
+	scramCredentials[name][int8(record.Mechanism)] = ScramCredential{
+		Salt:       record.Salt,
+		StoredKey:  record.StoredKey,
+		ServerKey:  record.ServerKey,
+		Iterations: int32(record.Iterations),
+	}
+}
+
+func applyRemoveUserScramCredentialRecord(record *RemoveUserScramCredentialRecordValue) {
+	name := string(record.Name)
+	delete(scramCredentials[name], int8(record.Mechanism))
+	if len(scramCredentials[name]) == 0 {
+		delete(scramCredentials, name)
+	}
+}
+
+// getScramCredential returns the user's credential for the mechanism.
+func getScramCredential(name string, mechanism ScramMechanism) (ScramCredential, bool) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	credential, ok := scramCredentials[name][mechanism.Type]
+	return credential, ok
+}
+
+// verifyPassword checks a clear text password, as sent with PLAIN, against
+// any SCRAM credential stored for the user.
+func verifyPassword(name string, password string) bool {
+	for _, mechanism := range scramMechanisms {
+		credential, ok := getScramCredential(name, mechanism)
+		if !ok {
+			continue
+		}
+		saltedPassword, err := pbkdf2.Key(mechanism.Hash, password, credential.Salt, int(credential.Iterations), mechanism.Hash().Size())
+		if err != nil {
+			continue
+		}
+		expected := newScramCredential(mechanism, credential.Salt, saltedPassword, credential.Iterations)
+		if subtle.ConstantTimeCompare(expected.StoredKey, credential.StoredKey) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+type ScramCredentialDeletion struct {
+	Name      string
+	Mechanism int8
+}
+
+type ScramCredentialUpsertion struct {
+	Name           string
+	Mechanism      int8
+	Iterations     int32
+	Salt           []byte
+	SaltedPassword []byte
+}
+
+// validateScramCredentialUpsertion returns the error for an invalid upsertion.
+func validateScramCredentialUpsertion(upsertion ScramCredentialUpsertion) *KafkaError {
+	if upsertion.Name == "" {
+		return newKafkaError(ERROR_CODE_UNACCEPTABLE_CREDENTIAL, "username must not be empty")
+	}
+	if _, ok := scramMechanismByType(upsertion.Mechanism); !ok {
+		return newKafkaError(ERROR_CODE_UNSUPPORTED_SASL_MECHANISM, "unknown SCRAM mechanism %d", upsertion.Mechanism)
+	}
+	if upsertion.Iterations < SCRAM_MIN_ITERATIONS || upsertion.Iterations > SCRAM_MAX_ITERATIONS {
+		return newKafkaError(ERROR_CODE_UNACCEPTABLE_CREDENTIAL, "iterations must be between %d and %d", SCRAM_MIN_ITERATIONS, SCRAM_MAX_ITERATIONS)
+	}
+	if len(upsertion.Salt) == 0 || len(upsertion.SaltedPassword) == 0 {
+		return newKafkaError(ERROR_CODE_UNACCEPTABLE_CREDENTIAL, "salt and salted password must not be empty")
+	}
+	return nil
+}
+
+// alterScramCredentials applies the deletions and upsertions user by user.
+// A user with any invalid change is left untouched and reported with the
+// first error found.
+func alterScramCredentials(deletions []ScramCredentialDeletion, upsertions []ScramCredentialUpsertion) (map[string]*KafkaError, error) {
+	metadataWriteMu.Lock()
+	defer metadataWriteMu.Unlock()
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	userErrors := make(map[string]*KafkaError)
+	setError := func(name string, err *KafkaError) {
+		if _, ok := userErrors[name]; !ok {
+			userErrors[name] = err
+		}
+	}
+
+	seen := make(map[ScramCredentialDeletion]bool)
+	for _, deletion := range deletions {
+		if seen[deletion] {
+			setError(deletion.Name, newKafkaError(ERROR_CODE_DUPLICATE_RESOURCE, "a user credential cannot be altered twice in the same request"))
+		}
+		seen[deletion] = true
+		if deletion.Name == "" {
+			setError(deletion.Name, newKafkaError(ERROR_CODE_UNACCEPTABLE_CREDENTIAL, "username must not be empty"))
+		} else if _, ok := scramMechanismByType(deletion.Mechanism); !ok {
+			setError(deletion.Name, newKafkaError(ERROR_CODE_UNSUPPORTED_SASL_MECHANISM, "unknown SCRAM mechanism %d", deletion.Mechanism))
+		} else if _, ok := scramCredentials[deletion.Name][deletion.Mechanism]; !ok {
+			setError(deletion.Name, newKafkaError(ERROR_CODE_RESOURCE_NOT_FOUND, "attempt to delete a user credential that does not exist"))
+		}
+	}
+	for _, upsertion := range upsertions {
+		key := ScramCredentialDeletion{Name: upsertion.Name, Mechanism: upsertion.Mechanism}
+		if seen[key] {
+			setError(upsertion.Name, newKafkaError(ERROR_CODE_DUPLICATE_RESOURCE, "a user credential cannot be altered twice in the same request"))
+		}
+		seen[key] = true
+		if err := validateScramCredentialUpsertion(upsertion); err != nil {
+			setError(upsertion.Name, err)
+		}
+	}
+
+	header := func(recordType int) RecordValueHeader {
+		return RecordValueHeader{
+			FrameVersion: ktypes.Int8(METADATA_RECORD_FRAME_VERSION),
+			RecordType:   ktypes.Int8(recordType),
+			Version:      ktypes.Int8(0),
+		}
+	}
+	for _, deletion := range deletions {
+		if userErrors[deletion.Name] != nil {
+			continue
+		}
+		err := appendMetadataRecord(&RemoveUserScramCredentialRecordValue{
+			Header:    header(REMOVE_USER_SCRAM_CREDENTIAL_RECORD_TYPE),
+			Name:      ktypes.CompactString(deletion.Name),
+			Mechanism: ktypes.Int8(deletion.Mechanism),
+		})
+		if err != nil {
+			return userErrors, err
+		}
+	}
+	for _, upsertion := range upsertions {
+		if userErrors[upsertion.Name] != nil {
+			continue
+		}
+		mechanism, _ := scramMechanismByType(upsertion.Mechanism)
+		credential := newScramCredential(mechanism, upsertion.Salt, upsertion.SaltedPassword, upsertion.Iterations)
+		err := appendMetadataRecord(&UserScramCredentialRecordValue{
+			Header:     header(USER_SCRAM_CREDENTIAL_RECORD_TYPE),
+			Name:       ktypes.CompactString(upsertion.Name),
+			Mechanism:  ktypes.Int8(upsertion.Mechanism),
+			Salt:       credential.Salt,
+			StoredKey:  credential.StoredKey,
+			ServerKey:  credential.ServerKey,
+			Iterations: ktypes.Int32(credential.Iterations),
+		})
+		if err != nil {
+			return userErrors, err
+		}
+	}
+
+	return userErrors, nil
+}
+
+type UserScramCredentialsDescription struct {
+	Name        string
+	Mechanisms  []ScramMechanism
+	Iterations  []int32
+	ErrorCode   ERROR_CODE
+	ErrorString string
+}
+
+// describeScramCredentials returns the mechanisms and iterations of the
+// users' credentials, or of every user when names is nil.
+func describeScramCredentials(names []string) []UserScramCredentialsDescription {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	if names == nil {
+		names = make([]string, 0, len(scramCredentials))
+		for name := range scramCredentials {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+	}
+
+	descriptions := make([]UserScramCredentialsDescription, 0, len(names))
+	counts := make(map[string]int)
+	for _, name := range names {
+		counts[name]++
+	}
+	for _, name := range names {
+		description := UserScramCredentialsDescription{Name: name}
+		credentials, ok := scramCredentials[name]
+		if counts[name] > 1 {
+			description.ErrorCode = ERROR_CODE_DUPLICATE_RESOURCE
+			description.ErrorString = "cannot describe the same user twice in a single request"
+		} else if !ok {
+			description.ErrorCode = ERROR_CODE_RESOURCE_NOT_FOUND
+			description.ErrorString = "attempt to describe a user credential that does not exist"
+		} else {
+			for _, mechanism := range scramMechanisms {
+				if credential, ok := credentials[mechanism.Type]; ok {
+					description.Mechanisms = append(description.Mechanisms, mechanism)
+					description.Iterations = append(description.Iterations, credential.Iterations)
+				}
+			}
+		}
+		descriptions = append(descriptions, description)
+	}
+	return descriptions
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Written to the metadata log directory once every log is flushed on
+// shutdown, the next start then trusts the logs on disk instead of
+// recovering them
+const CLEAN_SHUTDOWN_FILE = ".kafka_cleanshutdown"
+
+// Set at startup when the previous run shut down cleanly
+var hadCleanShutdown bool
+
+// Requests being processed and whether new ones are still accepted,
+// guarded by inFlightMu
+var (
+	inFlightMu       sync.Mutex
+	inFlightRequests int
+	shuttingDown     bool
+)
+
+// Closed on shutdown to stop the background tasks, which shutdown waits
+// for through runningTasks before closing the logs
+var (
+	stopTasks    = make(chan struct{})
+	runningTasks sync.WaitGroup
+)
+
+// runPeriodicTask runs task every interval in the background until the
+// broker shuts down.
+func runPeriodicTask(interval time.Duration, task func()) {
+	runningTasks.Add(1)
+	go func() {
+		defer runningTasks.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				task()
+			case <-stopTasks:
+				return
+			}
+		}
+	}()
+}
+
+// tasksStopped reports whether the background tasks are being stopped.
+func tasksStopped() bool {
+	select {
+	case <-stopTasks:
+		return true
+	default:
+		return false
+	}
+}
+
+// pauseTask waits for d, or until the background tasks are stopped.
+func pauseTask(d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-stopTasks:
+	}
+}
+
+// stopBackgroundTasks stops the background tasks and waits for the ones
+// running to return.
+func stopBackgroundTasks() {
+	close(stopTasks)
+	runningTasks.Wait()
+}
+
+// beginRequest registers a request about to be queued, it returns false
+// once the broker is shutting down.
+func beginRequest() bool {
+	inFlightMu.Lock()
+	defer inFlightMu.Unlock()
+	if shuttingDown {
+		return false
+	}
+	inFlightRequests++
+	return true
+}
+
+// endRequest is called once the response of a request is sent.
+func endRequest() {
+	inFlightMu.Lock()
+	defer inFlightMu.Unlock()
+	inFlightRequests--
+}
+
+func isShuttingDown() bool {
+	inFlightMu.Lock()
+	defer inFlightMu.Unlock()
+	return shuttingDown
+}
+
+// drainRequests stops accepting requests and waits for the in-flight ones,
+// at most until the deadline. It returns the number of requests abandoned.
+func drainRequests(deadline time.Time) int {
+	inFlightMu.Lock()
+	defer inFlightMu.Unlock()
+	shuttingDown = true
+	for inFlightRequests > 0 && time.Now().Before(deadline) {
+		inFlightMu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		inFlightMu.Lock()
+	}
+	return inFlightRequests
+}
+
+// readCleanShutdownMarker records whether the previous run shut down cleanly
+// and removes the marker, so a crash of this run is not mistaken for a
+// clean shutdown.
+func readCleanShutdownMarker() error {
+	path := metadataLogDir() + CLEAN_SHUTDOWN_FILE
+	if _, err := os.Stat(path); err != nil {
+		hadCleanShutdown = false
+		return nil
+	}
+	hadCleanShutdown = true
+	return os.Remove(path)
+}
+
+func writeCleanShutdownMarker() error {
+	file, err := os.Create(metadataLogDir() + CLEAN_SHUTDOWN_FILE)
+	if err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// closeAllLogs flushes and closes every open partition log.
+func closeAllLogs() error {
+	partitionLogsMu.Lock()
+	defer partitionLogsMu.Unlock()
+
+	var firstErr error
+	for _, log := range partitionLogs {
+		if err := log.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// waitForShutdownSignal blocks until SIGTERM or SIGINT, then shuts the
+// broker down.
+func waitForShutdownSignal(listeners []net.Listener) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+	fmt.Println("Received ", sig, ", shutting down")
+
+	if err := shutdown(listeners); err != nil {
+		fmt.Println("Error during shutdown: ", err.Error())
+		os.Exit(1)
+	}
+	fmt.Println("Shutdown complete")
+}
+
+// shutdown moves the partitions off this broker, stops accepting
+// connections, drains the in-flight requests, stops the background tasks
+// and replica fetchers and flushes the logs. The
+// clean shutdown marker is only written when every request completed and
+// every log was flushed.
+func shutdown(listeners []net.Listener) error {
+	// Partitions move to other brokers while this one still serves them
+	brokerLifecycle.controlledShutdown()
+
+	for _, l := range listeners {
+		l.Close()
+	}
+
+	// Idle connections are woken up from their read, busy ones finish their request first
+	connectionQuotas.interruptReads()
+	abandoned := drainRequests(time.Now().Add(SHUTDOWN_DRAIN_TIMEOUT_MS * time.Millisecond))
+	if abandoned > 0 {
+		fmt.Println("Shutdown deadline reached with ", abandoned, " requests in flight")
+	}
+
+	// Nothing else writes to the logs once they are closed
+	stopBackgroundTasks()
+	stopReplicaFetchers()
+	// The active controller resigns before its logs are closed
+	raftClient.close()
+	if err := closeAllLogs(); err != nil {
+		return fmt.Errorf("unable to flush logs: %w", err)
+	}
+	if err := checkpointRecoveryPoints(); err != nil {
+		return fmt.Errorf("unable to write recovery point checkpoint: %w", err)
+	}
+	if err := checkpointLogStartOffsets(); err != nil {
+		return fmt.Errorf("unable to write log start offset checkpoint: %w", err)
+	}
+	if err := checkpointHighWatermarks(); err != nil {
+		return fmt.Errorf("unable to write high watermark checkpoint: %w", err)
+	}
+	// Logs of offline directories were not flushed
+	if abandoned > 0 || len(onlineLogDirectories()) < len(logDirectories) {
+		return nil
+	}
+	if err := writeCleanShutdownMarker(); err != nil {
+		return fmt.Errorf("unable to write clean shutdown marker: %w", err)
+	}
+	return nil
+}
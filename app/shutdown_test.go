@@ -0,0 +1,105 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// resetTestShutdownState lets a test shut down without affecting the others
+func resetTestShutdownState(t *testing.T) {
+	t.Helper()
+	inFlightMu.Lock()
+	defer inFlightMu.Unlock()
+	previousStopTasks := stopTasks
+	stopTasks = make(chan struct{})
+	inFlightRequests, shuttingDown = 0, false
+	t.Cleanup(func() {
+		inFlightMu.Lock()
+		defer inFlightMu.Unlock()
+		stopTasks = previousStopTasks
+		inFlightRequests, shuttingDown = 0, false
+	})
+}
+
+func TestDrainRequestsWaitsForInFlightRequests(t *testing.T) {
+	resetTestShutdownState(t)
+	if !beginRequest() {
+		t.Fatal("request refused before shutdown")
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		endRequest()
+	}()
+
+	if abandoned := drainRequests(time.Now().Add(5 * time.Second)); abandoned != 0 {
+		t.Errorf("abandoned %d requests, want 0", abandoned)
+	}
+	if !isShuttingDown() || beginRequest() {
+		t.Error("request accepted after shutdown")
+	}
+}
+
+func TestDrainRequestsStopsAtDeadline(t *testing.T) {
+	resetTestShutdownState(t)
+	beginRequest()
+	beginRequest()
+	endRequest()
+
+	start := time.Now()
+	if abandoned := drainRequests(start.Add(50 * time.Millisecond)); abandoned != 1 {
+		t.Errorf("abandoned %d requests, want 1", abandoned)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("draining took %v past its deadline", elapsed)
+	}
+}
+
+func TestStopBackgroundTasks(t *testing.T) {
+	resetTestShutdownState(t)
+	var runs atomic.Int32
+	running := make(chan struct{})
+	runPeriodicTask(time.Millisecond, func() {
+		if runs.Add(1) == 1 {
+			close(running)
+		}
+	})
+	<-running
+
+	stopBackgroundTasks()
+	if !tasksStopped() {
+		t.Error("tasks not reported stopped")
+	}
+	stoppedRuns := runs.Load()
+	time.Sleep(20 * time.Millisecond)
+	if runs.Load() != stoppedRuns {
+		t.Error("task still running after being stopped")
+	}
+
+	// Paused tasks wake up once stopped
+	start := time.Now()
+	pauseTask(time.Minute)
+	if time.Since(start) > time.Second {
+		t.Error("pause not interrupted by the stop")
+	}
+}
+
+func TestCleanShutdownMarker(t *testing.T) {
+	previousConfig, previousClean := brokerConfig, hadCleanShutdown
+	t.Cleanup(func() { brokerConfig, hadCleanShutdown = previousConfig, previousClean })
+	brokerConfig.LogDirs = []string{t.TempDir() + "/"}
+
+	if err := readCleanShutdownMarker(); err != nil || hadCleanShutdown {
+		t.Fatalf("got clean shutdown %v, %v without a marker", hadCleanShutdown, err)
+	}
+	if err := writeCleanShutdownMarker(); err != nil {
+		t.Fatal(err)
+	}
+	if err := readCleanShutdownMarker(); err != nil || !hadCleanShutdown {
+		t.Fatalf("got clean shutdown %v, %v with a marker", hadCleanShutdown, err)
+	}
+	// The marker only vouches for the run that wrote it
+	if err := readCleanShutdownMarker(); err != nil || hadCleanShutdown {
+		t.Fatalf("got clean shutdown %v, %v after the marker was read", hadCleanShutdown, err)
+	}
+}
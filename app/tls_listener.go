@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	SSL_CLIENT_AUTH_NONE      = "none"
+	SSL_CLIENT_AUTH_REQUESTED = "requested"
+	SSL_CLIENT_AUTH_REQUIRED  = "required"
+)
+
+// Certificates currently served by the TLS listener, swapped when the files
+// change on disk. Guarded by tlsMu.
+var (
+	tlsMu       sync.Mutex
+	tlsConfig   *tls.Config
+	tlsModTimes map[string]time.Time
+)
+
+// tlsFiles returns the files the TLS configuration is built from.
+func tlsFiles() []string {
+	files := []string{brokerConfig.SslCertificateLocation, brokerConfig.SslKeyLocation}
+	if brokerConfig.SslTruststoreLocation != "" {
+		files = append(files, brokerConfig.SslTruststoreLocation)
+	}
+	return files
+}
+
+// fileModTimes returns the modification time of each file.
+func fileModTimes(files []string) (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[file] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// loadTlsConfig reads the broker certificate, its key and the CA bundle
+// client certificates are verified against.
+func loadTlsConfig() (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(brokerConfig.SslCertificateLocation, brokerConfig.SslKeyLocation)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+		ClientAuth:   tls.NoClientCert,
+	}
+
+	if brokerConfig.SslTruststoreLocation != "" {
+		data, err := os.ReadFile(brokerConfig.SslTruststoreLocation)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read TLS truststore: %w", err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in TLS truststore %s", brokerConfig.SslTruststoreLocation)
+		}
+		config.ClientCAs = clientCAs
+	}
+
+	switch brokerConfig.SslClientAuth {
+	case SSL_CLIENT_AUTH_REQUESTED:
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case SSL_CLIENT_AUTH_REQUIRED:
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// reloadTlsConfig swaps in the certificates from disk when any of the files
+// changed. A failed reload keeps the previous certificates.
+func reloadTlsConfig() {
+	modTimes, err := fileModTimes(tlsFiles())
+	if err != nil {
+		fmt.Println("Error checking TLS certificates: ", err.Error())
+		return
+	}
+
+	tlsMu.Lock()
+	defer tlsMu.Unlock()
+
+	changed := false
+	for file, modTime := range modTimes {
+		if !modTime.Equal(tlsModTimes[file]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	config, err := loadTlsConfig()
+	if err != nil {
+		fmt.Println("Error reloading TLS certificates: ", err.Error())
+		return
+	}
+	tlsConfig = config
+	tlsModTimes = modTimes
+	fmt.Println("Reloaded TLS certificates")
+}
+
+func currentTlsConfig() *tls.Config {
+	tlsMu.Lock()
+	defer tlsMu.Unlock()
+	return tlsConfig
+}
+
+// loadTlsCertificates loads the certificates shared by the TLS listeners.
+func loadTlsCertificates() error {
+	modTimes, err := fileModTimes(tlsFiles())
+	if err != nil {
+		return fmt.Errorf("unable to read TLS certificates: %w", err)
+	}
+	config, err := loadTlsConfig()
+	if err != nil {
+		return err
+	}
+
+	tlsMu.Lock()
+	defer tlsMu.Unlock()
+	tlsConfig = config
+	tlsModTimes = modTimes
+	return nil
+}
+
+// listenTls opens a TLS listener. Each handshake picks up the certificates
+// in use at that time, so reloads apply to new connections only.
+func listenTls(address string) (net.Listener, error) {
+	return tls.Listen("tcp", address, &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return currentTlsConfig(), nil
+		},
+	})
+}
+
+func startTlsReloadTask() {
+	runPeriodicTask(TLS_RELOAD_CHECK_INTERVAL_MS*time.Millisecond, func() {
+		reloadTlsConfig()
+	})
+}
+
+// tlsHandshake completes the handshake of a TLS connection. On SSL listeners
+// clients are identified by their certificate subject, or are anonymous when
+// they did not present one; SASL_SSL listeners still require SASL.
+func tlsHandshake(conn *tls.Conn, session *ClientSession) error {
+	conn.SetDeadline(time.Now().Add(TLS_HANDSHAKE_TIMEOUT_MS * time.Millisecond))
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+	if session.Listener.usesSasl() {
+		return nil
+	}
+
+	session.Authenticated = true
+	session.Principal = ANONYMOUS_PRINCIPAL
+	if peerCertificates := conn.ConnectionState().PeerCertificates; len(peerCertificates) > 0 {
+		session.Principal = USER_PRINCIPAL_TYPE + peerCertificates[0].Subject.String()
+	}
+	return nil
+}
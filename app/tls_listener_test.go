@@ -0,0 +1,294 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// tlsTestCertificate is a certificate and its key, signed by parent or
+// self-signed when parent is nil
+type tlsTestCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	tls         tls.Certificate
+}
+
+func newTlsTestCertificate(t *testing.T, subject pkix.Name, parent *tlsTestCertificate) *tlsTestCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.certificate, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tlsTestCertificate{
+		certificate: certificate,
+		key:         key,
+		tls:         tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+	}
+}
+
+// writeTlsTestFiles writes the broker certificate, its key and the CA bundle
+// and points brokerConfig at them
+func writeTlsTestFiles(t *testing.T, ca *tlsTestCertificate, server *tlsTestCertificate, clientAuth string) {
+	t.Helper()
+	dir := t.TempDir()
+	previousConfig := brokerConfig
+	t.Cleanup(func() { brokerConfig = previousConfig })
+	brokerConfig.SslCertificateLocation = filepath.Join(dir, "broker.pem")
+	brokerConfig.SslKeyLocation = filepath.Join(dir, "broker.key")
+	brokerConfig.SslTruststoreLocation = filepath.Join(dir, "truststore.pem")
+	brokerConfig.SslClientAuth = clientAuth
+
+	writeTlsTestFile(t, brokerConfig.SslTruststoreLocation, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.certificate.Raw}), time.Now())
+	writeTlsTestCertificate(t, server, time.Now())
+}
+
+// writeTlsTestCertificate replaces the broker certificate and its key,
+// modified at modTime
+func writeTlsTestCertificate(t *testing.T, server *tlsTestCertificate, modTime time.Time) {
+	t.Helper()
+	keyDer, err := x509.MarshalECPrivateKey(server.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTlsTestFile(t, brokerConfig.SslCertificateLocation, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.certificate.Raw}), modTime)
+	writeTlsTestFile(t, brokerConfig.SslKeyLocation, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), modTime)
+}
+
+// writeTlsTestFile writes data to a file modified at modTime
+func writeTlsTestFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tlsTestConnect connects a client presenting certificate, if any, and
+// returns the session of the connection on the broker side
+func tlsTestConnect(t *testing.T, ca *tlsTestCertificate, certificate *tlsTestCertificate) (*ClientSession, error) {
+	t.Helper()
+	if err := loadTlsCertificates(); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := listenTls("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	type result struct {
+		session *ClientSession
+		err     error
+	}
+	results := make(chan result, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			results <- result{nil, err}
+			return
+		}
+		defer conn.Close()
+		session := newClientSession(Listener{Name: "SSL", SecurityProtocol: SECURITY_PROTOCOL_SSL})
+		err = tlsHandshake(conn.(*tls.Conn), session)
+		results <- result{session, err}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.certificate)
+	clientConfig := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+	if certificate != nil {
+		clientConfig.Certificates = []tls.Certificate{certificate.tls}
+	}
+	conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+	if err == nil {
+		defer conn.Close()
+	}
+	r := <-results
+	return r.session, r.err
+}
+
+func TestTlsHandshakePrincipal(t *testing.T) {
+	previousTlsConfig, previousModTimes := tlsConfig, tlsModTimes
+	t.Cleanup(func() { tlsConfig, tlsModTimes = previousTlsConfig, previousModTimes })
+
+	ca := newTlsTestCertificate(t, pkix.Name{CommonName: "test-ca"}, nil)
+	server := newTlsTestCertificate(t, pkix.Name{CommonName: "localhost"}, ca)
+	client := newTlsTestCertificate(t, pkix.Name{CommonName: "client", Organization: []string{"test"}}, ca)
+	untrusted := newTlsTestCertificate(t, pkix.Name{CommonName: "untrusted"}, nil)
+
+	tests := []struct {
+		name          string
+		clientAuth    string
+		certificate   *tlsTestCertificate
+		wantErr       bool
+		wantPrincipal string
+	}{
+		{"no client auth", SSL_CLIENT_AUTH_NONE, client, false, ANONYMOUS_PRINCIPAL},
+		{"requested with certificate", SSL_CLIENT_AUTH_REQUESTED, client, false, "User:CN=client,O=test"},
+		{"requested without certificate", SSL_CLIENT_AUTH_REQUESTED, nil, false, ANONYMOUS_PRINCIPAL},
+		{"required with certificate", SSL_CLIENT_AUTH_REQUIRED, client, false, "User:CN=client,O=test"},
+		{"required without certificate", SSL_CLIENT_AUTH_REQUIRED, nil, true, ""},
+		{"untrusted certificate", SSL_CLIENT_AUTH_REQUIRED, untrusted, true, ""},
+	}
+	for _, test := range tests {
+		writeTlsTestFiles(t, ca, server, test.clientAuth)
+		session, err := tlsTestConnect(t, ca, test.certificate)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !session.Authenticated || session.Principal != test.wantPrincipal {
+			t.Errorf("%s: got principal %q (authenticated %v), want %q", test.name, session.Principal, session.Authenticated, test.wantPrincipal)
+		}
+	}
+}
+
+// tlsTestServe accepts connections on the listener and echoes what they send
+func tlsTestServe(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}()
+	}
+}
+
+// tlsTestDial connects a client and returns the connection with the serial
+// number of the certificate the broker presented
+func tlsTestDial(t *testing.T, listener net.Listener, ca *tlsTestCertificate) (*tls.Conn, *big.Int) {
+	t.Helper()
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.certificate)
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, conn.ConnectionState().PeerCertificates[0].SerialNumber
+}
+
+// tlsTestEcho fails unless the connection still carries data both ways
+func tlsTestEcho(t *testing.T, conn *tls.Conn) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("writing to an open connection: %v", err)
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "ping" {
+		t.Fatalf("got reply %q, %v on an open connection, want ping", reply, err)
+	}
+}
+
+func TestTlsCertificateReload(t *testing.T) {
+	previousTlsConfig, previousModTimes := tlsConfig, tlsModTimes
+	t.Cleanup(func() { tlsConfig, tlsModTimes = previousTlsConfig, previousModTimes })
+
+	ca := newTlsTestCertificate(t, pkix.Name{CommonName: "test-ca"}, nil)
+	first := newTlsTestCertificate(t, pkix.Name{CommonName: "localhost"}, ca)
+	second := newTlsTestCertificate(t, pkix.Name{CommonName: "localhost"}, ca)
+	third := newTlsTestCertificate(t, pkix.Name{CommonName: "localhost"}, ca)
+	writeTlsTestFiles(t, ca, first, SSL_CLIENT_AUTH_NONE)
+	if err := loadTlsCertificates(); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := listenTls("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go tlsTestServe(listener)
+
+	existing, serial := tlsTestDial(t, listener, ca)
+	if serial.Cmp(first.certificate.SerialNumber) != 0 {
+		t.Fatalf("got certificate %d, want the first one %d", serial, first.certificate.SerialNumber)
+	}
+	// Unchanged files are not reloaded
+	reloadTlsConfig()
+	if _, serial := tlsTestDial(t, listener, ca); serial.Cmp(first.certificate.SerialNumber) != 0 {
+		t.Errorf("got certificate %d without changes, want the first one %d", serial, first.certificate.SerialNumber)
+	}
+
+	// New handshakes get the new certificate, open connections stay up
+	modTime := time.Now().Add(time.Minute)
+	writeTlsTestCertificate(t, second, modTime)
+	reloadTlsConfig()
+	if _, serial := tlsTestDial(t, listener, ca); serial.Cmp(second.certificate.SerialNumber) != 0 {
+		t.Errorf("got certificate %d after reloading, want the second one %d", serial, second.certificate.SerialNumber)
+	}
+	tlsTestEcho(t, existing)
+
+	// Files caught halfway through being replaced keep the last certificate
+	secondPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: second.certificate.Raw})
+	thirdPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: third.certificate.Raw})
+	broken := []struct {
+		name        string
+		certificate []byte
+	}{
+		{"half-written certificate", secondPem[:len(secondPem)/2]},
+		{"certificate without its key", thirdPem},
+		{"empty certificate", nil},
+	}
+	for _, test := range broken {
+		modTime = modTime.Add(time.Minute)
+		writeTlsTestFile(t, brokerConfig.SslCertificateLocation, test.certificate, modTime)
+		reloadTlsConfig()
+		if _, serial := tlsTestDial(t, listener, ca); serial.Cmp(second.certificate.SerialNumber) != 0 {
+			t.Errorf("%s: got certificate %d, want the second one %d", test.name, serial, second.certificate.SerialNumber)
+		}
+	}
+	tlsTestEcho(t, existing)
+
+	// Once the files are complete again they are picked up
+	writeTlsTestCertificate(t, third, modTime.Add(time.Minute))
+	reloadTlsConfig()
+	if _, serial := tlsTestDial(t, listener, ca); serial.Cmp(third.certificate.SerialNumber) != 0 {
+		t.Errorf("got certificate %d after a complete write, want the third one %d", serial, third.certificate.SerialNumber)
+	}
+}
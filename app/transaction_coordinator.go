@@ -0,0 +1,535 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	TRANSACTION_LOG_KEY_VERSION   = 0
+	TRANSACTION_LOG_VALUE_VERSION = 0
+
+	// Epoch of this broker as coordinator, carried by the markers it writes
+	TRANSACTION_COORDINATOR_EPOCH = 0
+)
+
+// Transaction states as stored in __transaction_state
+const (
+	TXN_STATE_EMPTY int8 = iota
+	TXN_STATE_ONGOING
+	TXN_STATE_PREPARE_COMMIT
+	TXN_STATE_PREPARE_ABORT
+	TXN_STATE_COMPLETE_COMMIT
+	TXN_STATE_COMPLETE_ABORT
+	TXN_STATE_DEAD
+	TXN_STATE_PREPARE_EPOCH_FENCE
+)
+
+// Key of a transaction record in __transaction_state
+type TransactionLogKey struct {
+	Version         ktypes.Int16  `order:"1"`
+	TransactionalId ktypes.String `order:"2"`
+}
+
+type TransactionLogPartitions struct {
+	Topic        ktypes.String              `order:"1"`
+	PartitionIds ktypes.Array[ktypes.Int32] `order:"2"`
+}
+
+// Value of a transaction record in __transaction_state, empty for tombstones
+type TransactionLogValue struct {
+	Version                          ktypes.Int16                           `order:"1"`
+	ProducerId                       ktypes.Int64                           `order:"2"`
+	ProducerEpoch                    ktypes.Int16                           `order:"3"`
+	TransactionTimeoutMs             ktypes.Int32                           `order:"4"`
+	TransactionStatus                ktypes.Int8                            `order:"5"`
+	TransactionPartitions            ktypes.Array[TransactionLogPartitions] `order:"6"`
+	TransactionLastUpdateTimestampMs ktypes.Int64                           `order:"7"`
+	TransactionStartTimestampMs      ktypes.Int64                           `order:"8"`
+}
+
+type TransactionMetadata struct {
+	TransactionalId       string
+	ProducerId            int64
+	ProducerEpoch         int16
+	TimeoutMs             int32
+	State                 int8
+	Partitions            map[string][]int32
+	LastUpdateTimestampMs int64
+	StartTimestampMs      int64
+}
+
+var transactions = make(map[string]*TransactionMetadata)
+var transactionsMu sync.Mutex
+
+func transactionStatePartitionFor(transactionalId string) int32 {
+	return keyPartitionFor(transactionalId, TRANSACTION_STATE_PARTITIONS)
+}
+
+// hasPartition reports whether the partition was added to the ongoing transaction.
+func (t *TransactionMetadata) hasPartition(topic string, partition int32) bool {
+	return slices.Contains(t.Partitions[topic], partition)
+}
+
+func (t *TransactionMetadata) addPartition(topic string, partition int32) {
+	if !t.hasPartition(topic, partition) {
+		t.Partitions[topic] = append(t.Partitions[topic], partition)
+	}
+}
+
+// validateProducer checks that a request comes from the producer currently
+// owning the transactional id.
+func (t *TransactionMetadata) validateProducer(producerId int64, producerEpoch int16) ERROR_CODE {
+	if t.ProducerId != producerId {
+		return ERROR_CODE_INVALID_PRODUCER_ID_MAPPING
+	}
+	if t.ProducerEpoch != producerEpoch {
+		return ERROR_CODE_PRODUCER_FENCED
+	}
+	return ERROR_CODE_NONE
+}
+
+func encodeTransactionLogRecord(transaction *TransactionMetadata) (Record, error) {
+	key, err := ktypes.NewKEncoder().Encode(&TransactionLogKey{
+		Version:         ktypes.Int16(TRANSACTION_LOG_KEY_VERSION),
+		TransactionalId: ktypes.String(transaction.TransactionalId),
+	})
+	if err != nil {
+		return Record{}, fmt.Errorf("failed to encode transaction log key: %w", err)
+	}
+
+	topics := make([]string, 0, len(transaction.Partitions))
+	for topic := range transaction.Partitions {
+		topics = append(topics, topic)
+	}
+	slices.Sort(topics)
+	partitions := make([]TransactionLogPartitions, len(topics))
+	for i, topic := range topics {
+		partitionIds := make([]ktypes.Int32, len(transaction.Partitions[topic]))
+		for j, partition := range transaction.Partitions[topic] {
+			partitionIds[j] = ktypes.Int32(partition)
+		}
+		partitions[i] = TransactionLogPartitions{
+			Topic:        ktypes.String(topic),
+			PartitionIds: partitionIds,
+		}
+	}
+
+	value, err := ktypes.NewKEncoder().Encode(&TransactionLogValue{
+		Version:                          ktypes.Int16(TRANSACTION_LOG_VALUE_VERSION),
+		ProducerId:                       ktypes.Int64(transaction.ProducerId),
+		ProducerEpoch:                    ktypes.Int16(transaction.ProducerEpoch),
+		TransactionTimeoutMs:             ktypes.Int32(transaction.TimeoutMs),
+		TransactionStatus:                ktypes.Int8(transaction.State),
+		TransactionPartitions:            partitions,
+		TransactionLastUpdateTimestampMs: ktypes.Int64(transaction.LastUpdateTimestampMs),
+		TransactionStartTimestampMs:      ktypes.Int64(transaction.StartTimestampMs),
+	})
+	if err != nil {
+		return Record{}, fmt.Errorf("failed to encode transaction log value: %w", err)
+	}
+
+	return Record{Key: key, Value: value}, nil
+}
+
+// persistTransaction writes the transaction's state to its __transaction_state
+// partition, callers hold transactionsMu.
+func persistTransaction(transaction *TransactionMetadata) error {
+	transaction.LastUpdateTimestampMs = time.Now().UnixMilli()
+	record, err := encodeTransactionLogRecord(transaction)
+	if err != nil {
+		return err
+	}
+
+	log, err := getPartitionLog(TRANSACTION_STATE_TOPIC, transactionStatePartitionFor(transaction.TransactionalId))
+	if err != nil {
+		return err
+	}
+	if _, err := log.Append([]Record{record}); err != nil {
+		return err
+	}
+	return log.Flush()
+}
+
+// transitionTransaction moves the transaction to a new state and persists it.
+// The in-memory state is rolled back when the write fails.
+func transitionTransaction(transaction *TransactionMetadata, state int8) error {
+	previousState := transaction.State
+	transaction.State = state
+	if err := persistTransaction(transaction); err != nil {
+		transaction.State = previousState
+		return err
+	}
+	return nil
+}
+
+// writeTransactionMarkers ends the transaction in every partition it wrote to.
+func writeTransactionMarkers(transaction *TransactionMetadata, commit bool) error {
+	for topic, partitions := range transaction.Partitions {
+		for _, partition := range partitions {
+			log, err := getPartitionLog(topic, partition)
+			if err != nil {
+				return err
+			}
+			if _, err := log.AppendControlBatch(transaction.ProducerId, transaction.ProducerEpoch, commit, TRANSACTION_COORDINATOR_EPOCH); err != nil {
+				return fmt.Errorf("unable to write transaction marker to %s-%d: %w", topic, partition, err)
+			}
+			if err := log.Flush(); err != nil {
+				return fmt.Errorf("unable to flush transaction marker to %s-%d: %w", topic, partition, err)
+			}
+			if topic == CONSUMER_OFFSETS_TOPIC {
+				completeTxnOffsetCommit(transaction.ProducerId, partition, commit)
+			}
+		}
+	}
+	return nil
+}
+
+// completeTransaction runs the two phases of ending a transaction: the
+// decision is persisted first so a restarted coordinator can finish writing
+// the markers. Callers hold transactionsMu.
+func completeTransaction(transaction *TransactionMetadata, commit bool) error {
+	prepareState, completeState := TXN_STATE_PREPARE_ABORT, TXN_STATE_COMPLETE_ABORT
+	if commit {
+		prepareState, completeState = TXN_STATE_PREPARE_COMMIT, TXN_STATE_COMPLETE_COMMIT
+	}
+
+	if transaction.State != prepareState {
+		if err := transitionTransaction(transaction, prepareState); err != nil {
+			return err
+		}
+	}
+	if err := writeTransactionMarkers(transaction, commit); err != nil {
+		return err
+	}
+
+	transaction.Partitions = make(map[string][]int32)
+	transaction.StartTimestampMs = -1
+	return transitionTransaction(transaction, completeState)
+}
+
+// restoreTransaction undoes in-memory changes when a transition failed before
+// anything was persisted. Once the commit or abort decision is in the log the
+// transaction stays prepared and its markers are retried.
+func restoreTransaction(transaction *TransactionMetadata, previous *TransactionMetadata) {
+	if transaction.State == previous.State {
+		*transaction = *previous
+	}
+}
+
+// bumpProducerEpoch moves the transactional id to the next epoch, or to a
+// fresh producer id once the epoch is exhausted.
+func bumpProducerEpoch(transaction *TransactionMetadata) error {
+	if transaction.ProducerEpoch < math.MaxInt16-1 {
+		transaction.ProducerEpoch++
+		return nil
+	}
+	producerId, err := allocateProducerId()
+	if err != nil {
+		return err
+	}
+	transaction.ProducerId = producerId
+	transaction.ProducerEpoch = 0
+	return nil
+}
+
+// initTransactionalProducerId returns the producer id and epoch for a
+// transactional producer, fencing off any previous instance and aborting
+// the transaction it left open.
+func initTransactionalProducerId(transactionalId string, timeoutMs int32, producerId int64, producerEpoch int16) (int64, int16, ERROR_CODE) {
+	if timeoutMs <= 0 || timeoutMs > TRANSACTION_MAX_TIMEOUT_MS {
+		return NO_PRODUCER_ID, NO_PRODUCER_EPOCH, ERROR_CODE_INVALID_TRANSACTION_TIMEOUT
+	}
+
+	transactionsMu.Lock()
+	defer transactionsMu.Unlock()
+
+	transaction, ok := transactions[transactionalId]
+	if !ok {
+		newProducerId, err := allocateProducerId()
+		if err != nil {
+			fmt.Println("Error allocating producer id: ", err.Error())
+			return NO_PRODUCER_ID, NO_PRODUCER_EPOCH, ERROR_CODE_UNKNOWN_SERVER_ERROR
+		}
+		transaction = &TransactionMetadata{
+			TransactionalId:  transactionalId,
+			ProducerId:       newProducerId,
+			ProducerEpoch:    0,
+			TimeoutMs:        timeoutMs,
+			State:            TXN_STATE_EMPTY,
+			Partitions:       make(map[string][]int32),
+			StartTimestampMs: -1,
+		}
+		if err := persistTransaction(transaction); err != nil {
+			fmt.Println("Error persisting transaction: ", err.Error())
+			return NO_PRODUCER_ID, NO_PRODUCER_EPOCH, ERROR_CODE_UNKNOWN_SERVER_ERROR
+		}
+		transactions[transactionalId] = transaction
+		return transaction.ProducerId, transaction.ProducerEpoch, ERROR_CODE_NONE
+	}
+
+	// A producer retrying with its id and epoch must still own them
+	if producerId != NO_PRODUCER_ID {
+		if errorCode := transaction.validateProducer(producerId, producerEpoch); errorCode != ERROR_CODE_NONE {
+			return NO_PRODUCER_ID, NO_PRODUCER_EPOCH, errorCode
+		}
+	}
+
+	previous := *transaction
+	if err := bumpProducerEpoch(transaction); err != nil {
+		fmt.Println("Error bumping producer epoch: ", err.Error())
+		return NO_PRODUCER_ID, NO_PRODUCER_EPOCH, ERROR_CODE_UNKNOWN_SERVER_ERROR
+	}
+	if previous.State == TXN_STATE_ONGOING || previous.State == TXN_STATE_PREPARE_ABORT {
+		// Markers carry the bumped epoch so the fenced producer can not write anymore
+		if err := completeTransaction(transaction, false); err != nil {
+			fmt.Println("Error aborting transaction: ", err.Error())
+			restoreTransaction(transaction, &previous)
+			return NO_PRODUCER_ID, NO_PRODUCER_EPOCH, ERROR_CODE_CONCURRENT_TRANSACTIONS
+		}
+	} else if previous.State == TXN_STATE_PREPARE_COMMIT {
+		if err := completeTransaction(transaction, true); err != nil {
+			fmt.Println("Error committing transaction: ", err.Error())
+			restoreTransaction(transaction, &previous)
+			return NO_PRODUCER_ID, NO_PRODUCER_EPOCH, ERROR_CODE_CONCURRENT_TRANSACTIONS
+		}
+	}
+
+	transaction.TimeoutMs = timeoutMs
+	if err := transitionTransaction(transaction, TXN_STATE_EMPTY); err != nil {
+		fmt.Println("Error persisting transaction: ", err.Error())
+		restoreTransaction(transaction, &previous)
+		return NO_PRODUCER_ID, NO_PRODUCER_EPOCH, ERROR_CODE_UNKNOWN_SERVER_ERROR
+	}
+	return transaction.ProducerId, transaction.ProducerEpoch, ERROR_CODE_NONE
+}
+
+// getOngoingTransaction returns the transaction a producer may add partitions
+// to, callers hold transactionsMu.
+func getOngoingTransaction(transactionalId string, producerId int64, producerEpoch int16) (*TransactionMetadata, ERROR_CODE) {
+	transaction, ok := transactions[transactionalId]
+	if !ok {
+		return nil, ERROR_CODE_INVALID_PRODUCER_ID_MAPPING
+	}
+	if errorCode := transaction.validateProducer(producerId, producerEpoch); errorCode != ERROR_CODE_NONE {
+		return nil, errorCode
+	}
+	switch transaction.State {
+	case TXN_STATE_PREPARE_COMMIT, TXN_STATE_PREPARE_ABORT:
+		return nil, ERROR_CODE_CONCURRENT_TRANSACTIONS
+	case TXN_STATE_DEAD, TXN_STATE_PREPARE_EPOCH_FENCE:
+		return nil, ERROR_CODE_INVALID_TXN_STATE
+	}
+	return transaction, ERROR_CODE_NONE
+}
+
+// addPartitionsToTxn adds partitions to the producer's transaction, starting
+// it if needed.
+func addPartitionsToTxn(transactionalId string, producerId int64, producerEpoch int16, partitions map[string][]int32) ERROR_CODE {
+	transactionsMu.Lock()
+	defer transactionsMu.Unlock()
+
+	transaction, errorCode := getOngoingTransaction(transactionalId, producerId, producerEpoch)
+	if errorCode != ERROR_CODE_NONE {
+		return errorCode
+	}
+
+	previous := *transaction
+	previous.Partitions = make(map[string][]int32)
+	for topic, topicPartitions := range transaction.Partitions {
+		previous.Partitions[topic] = slices.Clone(topicPartitions)
+	}
+
+	if transaction.State != TXN_STATE_ONGOING {
+		transaction.StartTimestampMs = time.Now().UnixMilli()
+	}
+	for topic, topicPartitions := range partitions {
+		for _, partition := range topicPartitions {
+			transaction.addPartition(topic, partition)
+		}
+	}
+	if err := transitionTransaction(transaction, TXN_STATE_ONGOING); err != nil {
+		fmt.Println("Error persisting transaction: ", err.Error())
+		*transaction = previous
+		return ERROR_CODE_UNKNOWN_SERVER_ERROR
+	}
+	return ERROR_CODE_NONE
+}
+
+// endTxn commits or aborts the producer's transaction.
+func endTxn(transactionalId string, producerId int64, producerEpoch int16, commit bool) ERROR_CODE {
+	transactionsMu.Lock()
+	defer transactionsMu.Unlock()
+
+	transaction, ok := transactions[transactionalId]
+	if !ok {
+		return ERROR_CODE_INVALID_PRODUCER_ID_MAPPING
+	}
+	if errorCode := transaction.validateProducer(producerId, producerEpoch); errorCode != ERROR_CODE_NONE {
+		return errorCode
+	}
+
+	switch transaction.State {
+	case TXN_STATE_ONGOING:
+	case TXN_STATE_COMPLETE_COMMIT:
+		// Retried EndTxn of a transaction that already completed
+		if commit {
+			return ERROR_CODE_NONE
+		}
+		return ERROR_CODE_INVALID_TXN_STATE
+	case TXN_STATE_COMPLETE_ABORT:
+		if !commit {
+			return ERROR_CODE_NONE
+		}
+		return ERROR_CODE_INVALID_TXN_STATE
+	case TXN_STATE_PREPARE_COMMIT, TXN_STATE_PREPARE_ABORT:
+		return ERROR_CODE_CONCURRENT_TRANSACTIONS
+	default:
+		return ERROR_CODE_INVALID_TXN_STATE
+	}
+
+	if err := completeTransaction(transaction, commit); err != nil {
+		fmt.Println("Error completing transaction: ", err.Error())
+		return ERROR_CODE_UNKNOWN_SERVER_ERROR
+	}
+	return ERROR_CODE_NONE
+}
+
+// validateTxnOffsetCommit checks that offsets are committed by the producer
+// owning the transaction, after it added the group's offsets partition.
+func validateTxnOffsetCommit(transactionalId string, producerId int64, producerEpoch int16, groupId string) ERROR_CODE {
+	transactionsMu.Lock()
+	defer transactionsMu.Unlock()
+
+	transaction, errorCode := getOngoingTransaction(transactionalId, producerId, producerEpoch)
+	if errorCode != ERROR_CODE_NONE {
+		return errorCode
+	}
+	if transaction.State != TXN_STATE_ONGOING || !transaction.hasPartition(CONSUMER_OFFSETS_TOPIC, consumerOffsetsPartitionFor(groupId)) {
+		return ERROR_CODE_INVALID_TXN_STATE
+	}
+	return ERROR_CODE_NONE
+}
+
+// loadTransactionState replays every __transaction_state partition on disk
+// and finishes transactions whose commit or abort was decided before a restart.
+func loadTransactionState() error {
+	partitions, err := partitionFolders(TRANSACTION_STATE_TOPIC)
+	if err != nil {
+		return err
+	}
+
+	transactionsMu.Lock()
+	defer transactionsMu.Unlock()
+
+	for _, partition := range partitions {
+		log, err := getPartitionLog(TRANSACTION_STATE_TOPIC, int32(partition))
+		if err != nil {
+			return err
+		}
+		batches, err := log.ReadBatches(0)
+		if err != nil {
+			return err
+		}
+		for _, batch := range batches {
+			for _, record := range batch.Records {
+				if err := replayTransactionLogRecord(record); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, transaction := range transactions {
+		switch transaction.State {
+		case TXN_STATE_PREPARE_COMMIT:
+			err = completeTransaction(transaction, true)
+		case TXN_STATE_PREPARE_ABORT:
+			err = completeTransaction(transaction, false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func replayTransactionLogRecord(record Record) error {
+	var key TransactionLogKey
+	if err := ktypes.NewKDecoder(record.Key).Decode(&key); err != nil {
+		return fmt.Errorf("failed to decode transaction log key: %w", err)
+	}
+	if key.Version != TRANSACTION_LOG_KEY_VERSION {
+		return nil
+	}
+
+	if len(record.Value) == 0 {
+		delete(transactions, string(key.TransactionalId))
+		return nil
+	}
+
+	var value TransactionLogValue
+	if err := ktypes.NewKDecoder(record.Value).Decode(&value); err != nil {
+		return fmt.Errorf("failed to decode transaction log value: %w", err)
+	}
+	partitions := make(map[string][]int32)
+	for _, topic := range value.TransactionPartitions {
+		for _, partition := range topic.PartitionIds {
+			partitions[string(topic.Topic)] = append(partitions[string(topic.Topic)], int32(partition))
+		}
+	}
+	transactions[string(key.TransactionalId)] = &TransactionMetadata{
+		TransactionalId:       string(key.TransactionalId),
+		ProducerId:            int64(value.ProducerId),
+		ProducerEpoch:         int16(value.ProducerEpoch),
+		TimeoutMs:             int32(value.TransactionTimeoutMs),
+		State:                 int8(value.TransactionStatus),
+		Partitions:            partitions,
+		LastUpdateTimestampMs: int64(value.TransactionLastUpdateTimestampMs),
+		StartTimestampMs:      int64(value.TransactionStartTimestampMs),
+	}
+	return nil
+}
+
+// abortTimedOutTransactions aborts transactions open for longer than their
+// timeout. The epoch is bumped so the producer is fenced.
+func abortTimedOutTransactions(now int64) {
+	transactionsMu.Lock()
+	defer transactionsMu.Unlock()
+
+	for _, transaction := range transactions {
+		if transaction.State == TXN_STATE_PREPARE_COMMIT || transaction.State == TXN_STATE_PREPARE_ABORT {
+			// Markers that could not be written earlier are retried
+			if err := completeTransaction(transaction, transaction.State == TXN_STATE_PREPARE_COMMIT); err != nil {
+				fmt.Println("Error completing transaction: ", err.Error())
+			}
+			continue
+		}
+		if transaction.State != TXN_STATE_ONGOING || transaction.StartTimestampMs+int64(transaction.TimeoutMs) > now {
+			continue
+		}
+		fmt.Println("Aborting timed out transaction ", transaction.TransactionalId)
+		previous := *transaction
+		if err := bumpProducerEpoch(transaction); err != nil {
+			fmt.Println("Error bumping producer epoch: ", err.Error())
+			continue
+		}
+		if err := completeTransaction(transaction, false); err != nil {
+			fmt.Println("Error aborting transaction: ", err.Error())
+			restoreTransaction(transaction, &previous)
+		}
+	}
+}
+
+// startTransactionTimeoutTask periodically aborts timed out transactions.
+func startTransactionTimeoutTask() {
+	runPeriodicTask(TRANSACTION_ABORT_CHECK_INTERVAL_MS*time.Millisecond, func() {
+		abortTimedOutTransactions(time.Now().UnixMilli())
+	})
+}
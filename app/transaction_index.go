@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/codecrafters-io/kafka-starter-go/app/ktypes"
+)
+
+const (
+	TRANSACTION_INDEX_VERSION    = 0
+	TRANSACTION_INDEX_SUFFIX     = ".txnindex"
+	TRANSACTION_INDEX_ENTRY_SIZE = 34
+)
+
+// AbortedTxn is a transaction aborted in a partition, from its first batch
+// up to its ABORT marker.
+type AbortedTxn struct {
+	ProducerId       int64
+	FirstOffset      int64
+	LastOffset       int64
+	LastStableOffset int64
+}
+
+// On disk entry of the aborted transaction index
+type AbortedTxnEntry struct {
+	Version          ktypes.Int16 `order:"1"`
+	ProducerId       ktypes.Int64 `order:"2"`
+	FirstOffset      ktypes.Int64 `order:"3"`
+	LastOffset       ktypes.Int64 `order:"4"`
+	LastStableOffset ktypes.Int64 `order:"5"`
+}
+
+// transactionIndexPath returns the index kept next to a segment file.
+func transactionIndexPath(segment string) string {
+	return segment[:len(segment)-len(filepath.Ext(segment))] + TRANSACTION_INDEX_SUFFIX
+}
+
+// readTransactionIndex returns the aborted transactions recorded for a
+// segment. A missing index means no transaction was aborted in it.
+func readTransactionIndex(path string) ([]AbortedTxn, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return []AbortedTxn{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read transaction index: %w", err)
+	}
+	return decodeTransactionIndex(data)
+}
+
+// decodeTransactionIndex returns the aborted transactions of an encoded
+// transaction index. A torn trailing entry is ignored.
+func decodeTransactionIndex(data []byte) ([]AbortedTxn, error) {
+	abortedTxns := make([]AbortedTxn, 0, len(data)/TRANSACTION_INDEX_ENTRY_SIZE)
+	for position := 0; position+TRANSACTION_INDEX_ENTRY_SIZE <= len(data); position += TRANSACTION_INDEX_ENTRY_SIZE {
+		var entry AbortedTxnEntry
+		if err := ktypes.NewKDecoder(data[position : position+TRANSACTION_INDEX_ENTRY_SIZE]).Decode(&entry); err != nil {
+			return nil, fmt.Errorf("failed to decode transaction index entry: %w", err)
+		}
+		if entry.Version != TRANSACTION_INDEX_VERSION {
+			return nil, fmt.Errorf("unsupported transaction index version %d", entry.Version)
+		}
+		abortedTxns = append(abortedTxns, AbortedTxn{
+			ProducerId:       int64(entry.ProducerId),
+			FirstOffset:      int64(entry.FirstOffset),
+			LastOffset:       int64(entry.LastOffset),
+			LastStableOffset: int64(entry.LastStableOffset),
+		})
+	}
+	return abortedTxns, nil
+}
+
+// appendTransactionIndex adds an aborted transaction to a segment's index.
+func appendTransactionIndex(path string, abortedTxn AbortedTxn) error {
+	encoded, err := ktypes.NewKEncoder().Encode(&AbortedTxnEntry{
+		Version:          ktypes.Int16(TRANSACTION_INDEX_VERSION),
+		ProducerId:       ktypes.Int64(abortedTxn.ProducerId),
+		FirstOffset:      ktypes.Int64(abortedTxn.FirstOffset),
+		LastOffset:       ktypes.Int64(abortedTxn.LastOffset),
+		LastStableOffset: ktypes.Int64(abortedTxn.LastStableOffset),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode transaction index entry: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open transaction index: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(encoded); err != nil {
+		return fmt.Errorf("unable to write transaction index: %w", err)
+	}
+	return nil
+}
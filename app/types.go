@@ -9,11 +9,39 @@ type Request struct {
 	CorrelationId     ktypes.Int32  `order:"4"`
 	ClientId          ktypes.String `order:"5"`
 	Body              []byte
+	ClientHost        string
+	Session           *ClientSession
+	// Throttle time earned by the client, reported in the response
+	ThrottleTimeMs int32
+	// Set for requests the request_percentage quota is not charged for
+	QuotaExempt bool
 }
 
+type RequestHeaderTaggedFields struct {
+	TaggedFields ktypes.TaggedFields `order:"1"`
+}
+
+// Response is sent back size prefixed, with a v0 or v1 (flexible) header
 type Response struct {
+	CorrelationId ktypes.Int32
+	HeaderVersion int
+	Body          []byte
+	// delayed parks the request in the purgatory, to send its response with
+	// respond once the operation completes
+	delayed func(respond func(*Response))
+}
+
+// noResponse is what handlers return for requests the client expects no
+// response to, produce requests with acks=0. A nil response is a request
+// that failed, its connection is closed rather than left waiting.
+var noResponse = &Response{}
+
+type ResponseHeaderV0 struct {
 	CorrelationId ktypes.Int32 `order:"1"`
-	HeaderVersion ktypes.Int32 `order:"2"`
-	Body          ktypes.Bytes `order:"3"`
+}
+
+type ResponseHeaderV1 struct {
+	CorrelationId ktypes.Int32        `order:"1"`
+	TaggedFields  ktypes.TaggedFields `order:"2"`
 }
 